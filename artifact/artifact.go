@@ -0,0 +1,606 @@
+/*
+Package artifact reads the outputs of pakkero, the artifacts, without
+trusting the pakkero command: their header, their signature and their
+payload frame. It only needs the standard library, and it is the
+normative implementation of the format: pakkero writes and reads its
+outputs with it, an implementation disagreeing with it is wrong. The
+vectors of testdata/vectors.json are artifacts of each kind, with what
+reading them must give.
+
+An artifact is, in order:
+
+	launcher | marker | record | garbage | blobs | frame | padding | signature
+
+launcher is an ELF, the marker starts in the MarkerWindow bytes after
+its end, see ELFEnd. The key of the frame is PayloadKey of everything
+before offset, the start of the frame, so that changing a byte of the
+launcher, of the marker or of the garbage breaks it.
+
+The marker is 4 fields of MarkerField bytes:
+
+	salt | tag | version | offset
+
+tag is MarkerMAC(salt), version is the pakkero version, zero padded,
+XOR MarkerMAC(tag), offset is random unless the artifact is repackable:
+then it is the offset, 8 bytes little endian, and 8 zero bytes, XOR
+MarkerMAC(MarkerMAC(tag)). The key of MarkerMAC is in these sources:
+the marker tells pakkero its own outputs, it does not hide them.
+
+record, if the artifact is recoverable, is the offset sealed with a
+random key of RecoveryKeySize bytes, given when packing, see
+OpenRecoveryRecord. Else it is garbage too.
+
+The blobs are the ones of the launcher, the decoy, the libraries and
+the prologue, the launcher only knows where they are and their keys.
+
+frame is the payload, sealed by AES-256-GCM with PayloadKey, the nonce
+first, then reversed and each of its bytes bit-reversed, see OpenFrame.
+Its plaintext is the ID of a codec, then the stream of the codec of the
+payload encoded in base64, see Decode.
+
+padding is FinalPadding(offset) random bytes, signature, if the artifact
+is signed, the ed25519 signature of SignedMessage, see Sign.
+
+A scattered or whitened payload needs secrets of the launcher to be
+put back together, only the frame of the other artifacts can be read.
+*/
+package artifact
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"debug/elf"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	// MarkerField is the size of each field of the marker
+	MarkerField = 16
+	// MarkerSize is the size of the marker
+	MarkerSize = 4 * MarkerField
+	// MarkerWindow is how far after the end of the ELF the marker can start, UPX leaves its trailer there
+	MarkerWindow = 64 << 10
+	// RecoveryRecordSize is the size of the recovery record: nonce[12] | offset[8] | tag[16]
+	RecoveryRecordSize = 12 + 8 + 16
+	// RecoveryKeySize is the size of the AES-256 key sealing the recovery record
+	RecoveryKeySize = 32
+	// SignatureSize is the size of the signature ending a signed artifact
+	SignatureSize = ed25519.SignatureSize
+)
+
+// IDs of the codecs of the frame plaintext, never reused
+const (
+	CodecNone byte = iota
+	CodecZlib
+	CodecGzip
+	// CodecUPX is the whole launcher compressed by upx, never a frame codec
+	CodecUPX
+)
+
+// SignatureContext starts what the signature signs, see SignedMessage
+const SignatureContext = "pakkero artifact signature v1\x00"
+
+var (
+	// ErrNotArtifact is returned for a file with no marker, not packed by pakkero
+	ErrNotArtifact = errors.New("not a pakkero artifact")
+	// ErrNoOffset is returned reading the frame of an artifact that is not repackable with no key
+	ErrNoOffset = errors.New("the artifact is not repackable, its frame needs the recovery key")
+	// ErrSignature is returned by VerifySignature for an artifact not signed by the key
+	ErrSignature = errors.New("the artifact is not signed by the key, or it was modified")
+)
+
+var markerKey = sha256.Sum256([]byte("pakkero repack marker"))
+
+// MarkerMAC returns the HMAC-SHA256 of the input with the marker key, truncated to MarkerField bytes
+func MarkerMAC(input []byte) []byte {
+	mac := hmac.New(sha256.New, markerKey[:])
+	mac.Write(input)
+
+	return mac.Sum(nil)[:MarkerField]
+}
+
+/*
+SealMarker returns the marker of the version, random is the salt and
+the random offset field, 2*MarkerField bytes. offset is the one of a
+repackable artifact, 0 for the others.
+*/
+func SealMarker(random []byte, version string, offset int64) ([]byte, error) {
+	if len(random) != 2*MarkerField {
+		return nil, fmt.Errorf("the marker takes %d random bytes, not %d", 2*MarkerField, len(random))
+	}
+
+	if len(version) > MarkerField {
+		return nil, fmt.Errorf("the version %q is longer than %d bytes", version, MarkerField)
+	}
+
+	salt := append([]byte{}, random[:MarkerField]...)
+	offsetField := append([]byte{}, random[MarkerField:]...)
+
+	tag := MarkerMAC(salt)
+	mask := MarkerMAC(tag)
+
+	versionField := make([]byte, MarkerField)
+	copy(versionField, version)
+
+	for i := range versionField {
+		versionField[i] ^= mask[i]
+	}
+
+	if offset != 0 {
+		offsetMask := MarkerMAC(mask)
+		offsetField = make([]byte, MarkerField)
+		binary.LittleEndian.PutUint64(offsetField, uint64(offset))
+
+		for i := range offsetField {
+			offsetField[i] ^= offsetMask[i]
+		}
+	}
+
+	return append(append(append(salt, tag...), versionField...), offsetField...), nil
+}
+
+/*
+ELFEnd returns where the content of an ELF ends: after its last
+segment, section or section header table. An error is returned if it
+is not an ELF.
+*/
+func ELFEnd(r io.ReaderAt) (int64, error) {
+	binary, err := elf.NewFile(r)
+	if err != nil {
+		return 0, err
+	}
+
+	var end uint64
+
+	for _, prog := range binary.Progs {
+		if prog.Off+prog.Filesz > end {
+			end = prog.Off + prog.Filesz
+		}
+	}
+
+	for _, section := range binary.Sections {
+		if section.Type != elf.SHT_NOBITS && section.Offset+section.FileSize > end {
+			end = section.Offset + section.FileSize
+		}
+	}
+
+	// the section header table, debug/elf does not expose its offset
+	header := make([]byte, 64)
+
+	_, err = r.ReadAt(header, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	var shoff, shentsize, shnum uint64
+
+	order := binary.ByteOrder
+
+	if binary.Class == elf.ELFCLASS64 {
+		shoff = order.Uint64(header[0x28:])
+		shentsize = uint64(order.Uint16(header[0x3a:]))
+		shnum = uint64(order.Uint16(header[0x3c:]))
+	} else {
+		shoff = uint64(order.Uint32(header[0x20:]))
+		shentsize = uint64(order.Uint16(header[0x2e:]))
+		shnum = uint64(order.Uint16(header[0x30:]))
+	}
+
+	if shoff+shentsize*shnum > end {
+		end = shoff + shentsize*shnum
+	}
+
+	return int64(end), nil
+}
+
+/*
+Header is what the marker of an artifact carries, and where it is: the
+version of pakkero that packed it, the offset of its frame if it is
+repackable, else 0, where the marker starts and where it ends, that is
+where the recovery record starts, and the size of the artifact.
+*/
+type Header struct {
+	Version string
+	Offset  int64
+	Marker  int64
+	End     int64
+	Size    int64
+
+	// the artifact, read by VerifySignature
+	r io.ReaderAt
+}
+
+// readerSize returns the size of a bytes.Reader, strings.Reader, io.SectionReader or *os.File
+func readerSize(r io.ReaderAt) (int64, error) {
+	switch sized := r.(type) {
+	case interface{ Size() int64 }:
+		return sized.Size(), nil
+	case interface{ Stat() (os.FileInfo, error) }:
+		stat, err := sized.Stat()
+		if err != nil {
+			return 0, err
+		}
+
+		return stat.Size(), nil
+	}
+
+	return 0, errors.New("the size of the reader is unknown, use an io.SectionReader")
+}
+
+/*
+ParseHeader returns the Header of the artifact, the first marker in the
+MarkerWindow after the end of its ELF, or ErrNotArtifact. The reader
+must have a size, see io.SectionReader, and stay open while the header
+is used.
+The artifacts of older versions have no offset field, they end with the
+version.
+*/
+func ParseHeader(r io.ReaderAt) (Header, error) {
+	size, err := readerSize(r)
+	if err != nil {
+		return Header{}, err
+	}
+
+	end, err := ELFEnd(r)
+	if err != nil {
+		return Header{}, fmt.Errorf("%w: %s", ErrNotArtifact, err)
+	}
+
+	window := make([]byte, MarkerWindow+MarkerSize)
+
+	read, err := r.ReadAt(window, end)
+	if err != nil && err != io.EOF {
+		return Header{}, err
+	}
+
+	window = window[:read]
+
+	for i := 0; i+3*MarkerField <= len(window); i++ {
+		salt := window[i : i+MarkerField]
+		tag := window[i+MarkerField : i+2*MarkerField]
+
+		if !hmac.Equal(MarkerMAC(salt), tag) {
+			continue
+		}
+
+		mask := MarkerMAC(tag)
+		version := make([]byte, MarkerField)
+
+		for j := range version {
+			version[j] = window[i+2*MarkerField+j] ^ mask[j]
+		}
+
+		header := Header{
+			Version: string(bytes.TrimRight(version, "\x00")),
+			Marker:  end + int64(i),
+			End:     end + int64(i+MarkerSize),
+			Size:    size,
+			r:       r,
+		}
+
+		if i+MarkerSize <= len(window) {
+			offsetMask := MarkerMAC(mask)
+			offsetField := make([]byte, MarkerField)
+
+			for j := range offsetField {
+				offsetField[j] = window[i+3*MarkerField+j] ^ offsetMask[j]
+			}
+
+			if bytes.Equal(offsetField[8:], make([]byte, 8)) {
+				header.Offset = int64(binary.LittleEndian.Uint64(offsetField))
+			}
+		}
+
+		return header, nil
+	}
+
+	return Header{}, ErrNotArtifact
+}
+
+/*
+FinalPadding returns the size of the padding after the frame of the
+payload at offset: the zigzag varint of the offset, each of its bytes
+bit-reversed, read back as a varint, made positive.
+*/
+func FinalPadding(offset int64) int64 {
+	varint := make([]byte, binary.MaxVarintLen64)
+	varint = varint[:binary.PutVarint(varint, offset)]
+
+	for i := range varint {
+		varint[i] = reverseBits(varint[i])
+	}
+
+	padding, _ := binary.Varint(varint)
+	if padding < 0 {
+		padding *= -1
+	}
+
+	return padding
+}
+
+// reverseBits returns the byte with its bits in the reverse order
+func reverseBits(b byte) byte {
+	var d byte
+
+	for i := 0; i < 8; i++ {
+		d <<= 1
+		d |= b & 1
+		b >>= 1
+	}
+
+	return d
+}
+
+// recoveryCipher returns the AES-GCM of a recovery key
+func recoveryCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) != RecoveryKeySize {
+		return nil, fmt.Errorf("the recovery key is %d bytes, not %d", RecoveryKeySize, len(key))
+	}
+
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(c)
+}
+
+// SealRecoveryRecord returns the recovery record of the offset, sealed with the key and the nonce
+func SealRecoveryRecord(key []byte, nonce []byte, offset int64) ([]byte, error) {
+	gcm, err := recoveryCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("the nonce is %d bytes, not %d", gcm.NonceSize(), len(nonce))
+	}
+
+	plaintext := make([]byte, 8)
+	binary.LittleEndian.PutUint64(plaintext, uint64(offset))
+
+	return gcm.Seal(append([]byte{}, nonce...), nonce, plaintext, nil), nil
+}
+
+/*
+OpenRecoveryRecord returns the offset sealed in the recovery record of
+the artifact with the key. A wrong key and an artifact that is not
+recoverable fail the same way: the record is garbage for it.
+*/
+func OpenRecoveryRecord(h Header, key []byte) (int64, error) {
+	gcm, err := recoveryCipher(key)
+	if err != nil {
+		return 0, err
+	}
+
+	if h.r == nil || h.End+RecoveryRecordSize > h.Size {
+		return 0, errors.New("the artifact is truncated, no recovery record")
+	}
+
+	record := make([]byte, RecoveryRecordSize)
+
+	_, err = h.r.ReadAt(record, h.End)
+	if err != nil {
+		return 0, err
+	}
+
+	plaintext, err := gcm.Open(nil, record[:gcm.NonceSize()], record[gcm.NonceSize():], nil)
+	if err != nil {
+		return 0, errors.New("wrong recovery key, or the artifact is not recoverable")
+	}
+
+	return int64(binary.LittleEndian.Uint64(plaintext)), nil
+}
+
+// PayloadKey returns the AES-256 key of the frame after prefix, its SHA-512/256
+func PayloadKey(prefix []byte) [32]byte {
+	return sha512.Sum512_256(prefix)
+}
+
+/*
+OpenFrame returns the plaintext of the frame sealed with the key of the
+prefix, everything before it: the frame is reversed, each byte
+bit-reversed, then it is the nonce and the AES-256-GCM ciphertext. The
+frame is left as it is.
+*/
+func OpenFrame(frame []byte, prefix []byte) ([]byte, error) {
+	key := PayloadKey(prefix)
+
+	c, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(c)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := make([]byte, len(frame))
+	for i := range frame {
+		sealed[len(frame)-1-i] = reverseBits(frame[i])
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("the frame is truncated")
+	}
+
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+/*
+Decompress returns a reader of r decompressed by the codec of the ID.
+CodecUPX is read as it is, the binary decompresses itself.
+*/
+func Decompress(codec byte, r io.Reader) (io.Reader, error) {
+	switch codec {
+	case CodecNone, CodecUPX:
+		return r, nil
+	case CodecZlib:
+		return zlib.NewReader(r)
+	case CodecGzip:
+		return gzip.NewReader(r)
+	}
+
+	return nil, fmt.Errorf("unknown codec id %d", codec)
+}
+
+/*
+Decode returns a reader of the payload of the plaintext of a frame: its
+codec ID, then the stream of the codec of the base64 of the payload.
+*/
+func Decode(plaintext []byte) (io.Reader, error) {
+	if len(plaintext) == 0 {
+		return nil, errors.New("empty frame, no codec id")
+	}
+
+	if plaintext[0] == CodecUPX {
+		return nil, errors.New("the codec of the frame is upx, only a launcher is")
+	}
+
+	stream, err := Decompress(plaintext[0], bytes.NewReader(plaintext[1:]))
+	if err != nil {
+		return nil, fmt.Errorf("corrupted stream: %w", err)
+	}
+
+	return base64.NewDecoder(base64.StdEncoding, stream), nil
+}
+
+// readAt returns size bytes of the artifact at offset
+func readAt(r io.ReaderAt, offset int64, size int64) ([]byte, error) {
+	content := make([]byte, size)
+
+	_, err := r.ReadAt(content, offset)
+	if err == io.EOF {
+		err = nil
+	}
+
+	return content, err
+}
+
+/*
+FrameReader returns a reader of the payload of the artifact: the frame
+at the offset of its header if it is repackable and key is nil, else at
+the one of its recovery record opened with key, see OpenRecoveryRecord.
+The frame ends at the padding, or at the signature if the artifact is
+signed: which one is told by the frame opening, its tag authenticates
+its length too.
+*/
+func FrameReader(r io.ReaderAt, key []byte) (io.Reader, error) {
+	header, err := ParseHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	offset := header.Offset
+
+	if key != nil {
+		offset, err = OpenRecoveryRecord(header, key)
+		if err != nil {
+			return nil, err
+		}
+	} else if offset == 0 {
+		return nil, ErrNoOffset
+	}
+
+	end := header.Size - FinalPadding(offset)
+	if offset < header.End || offset >= end {
+		return nil, fmt.Errorf("the artifact is truncated, no frame after offset %d", offset)
+	}
+
+	prefix, err := readAt(r, 0, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, trailer := range []int64{0, SignatureSize} {
+		if end-trailer <= offset {
+			break
+		}
+
+		frame, err := readAt(r, offset, end-trailer-offset)
+		if err != nil {
+			return nil, err
+		}
+
+		plaintext, err := OpenFrame(frame, prefix)
+		if err == nil {
+			return Decode(plaintext)
+		}
+	}
+
+	return nil, errors.New("the frame does not decrypt, the artifact was modified")
+}
+
+/*
+SignedMessage returns what the signature of the artifact signs: the
+SignatureContext, then the SHA-512 of its size first bytes, everything
+before the signature.
+*/
+func SignedMessage(r io.ReaderAt, size int64) ([]byte, error) {
+	digest := sha512.New()
+
+	_, err := io.Copy(digest, io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, err
+	}
+
+	return digest.Sum([]byte(SignatureContext)), nil
+}
+
+// Sign returns the signature of the size first bytes of the artifact, appended to it to sign it
+func Sign(r io.ReaderAt, size int64, key ed25519.PrivateKey) ([]byte, error) {
+	message, err := SignedMessage(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	return ed25519.Sign(key, message), nil
+}
+
+/*
+VerifySignature returns ErrSignature unless the artifact of the header
+ends with the signature by the key of everything before it, see
+SignedMessage. An artifact that is not signed fails the same way.
+*/
+func VerifySignature(h Header, pub ed25519.PublicKey) error {
+	if h.r == nil {
+		return errors.New("the header is not read by ParseHeader")
+	}
+
+	if len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("the public key is %d bytes, not %d", len(pub), ed25519.PublicKeySize)
+	}
+
+	if h.Size < h.End+SignatureSize {
+		return ErrSignature
+	}
+
+	message, err := SignedMessage(h.r, h.Size-SignatureSize)
+	if err != nil {
+		return err
+	}
+
+	signature, err := readAt(h.r, h.Size-SignatureSize, SignatureSize)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(pub, message, signature) {
+		return ErrSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package artifact_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/89luca89/pakkero/artifact"
+)
+
+// vectors is testdata/vectors.json
+type vectors struct {
+	SigningSeed  string `json:"signing_seed"`
+	PublicKey    string `json:"public_key"`
+	FinalPadding []struct {
+		Offset  int64 `json:"offset"`
+		Padding int64 `json:"padding"`
+	} `json:"final_padding"`
+	Artifacts []struct {
+		Name          string `json:"name"`
+		File          string `json:"file"`
+		Version       string `json:"version"`
+		Offset        int64  `json:"offset"`
+		Marker        int64  `json:"marker"`
+		End           int64  `json:"end"`
+		Size          int64  `json:"size"`
+		FrameOffset   int64  `json:"frame_offset"`
+		FinalPadding  int64  `json:"final_padding"`
+		RecoveryKey   string `json:"recovery_key"`
+		Signed        bool   `json:"signed"`
+		Payload       string `json:"payload"`
+		PayloadSHA256 string `json:"payload_sha256"`
+	} `json:"artifacts"`
+	NotArtifacts []string `json:"not_artifacts"`
+}
+
+// readVectors returns the test vectors and the signing key they were signed with
+func readVectors(t *testing.T) (vectors, ed25519.PrivateKey) {
+	t.Helper()
+
+	content, err := ioutil.ReadFile(filepath.Join("testdata", "vectors.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := vectors{}
+
+	err = json.Unmarshal(content, &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seed, err := hex.DecodeString(v.SigningSeed)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		t.Fatalf("signing seed: %v", err)
+	}
+
+	key := ed25519.NewKeyFromSeed(seed)
+	if hex.EncodeToString(key.Public().(ed25519.PublicKey)) != v.PublicKey {
+		t.Fatal("the public key is not the one of the signing seed")
+	}
+
+	return v, key
+}
+
+func TestFinalPadding(t *testing.T) {
+	v, _ := readVectors(t)
+
+	for _, vector := range v.FinalPadding {
+		if padding := artifact.FinalPadding(vector.Offset); padding != vector.Padding {
+			t.Errorf("final padding of %d: %d, expected %d", vector.Offset, padding, vector.Padding)
+		}
+	}
+}
+
+func TestVectors(t *testing.T) {
+	v, key := readVectors(t)
+	pub := key.Public().(ed25519.PublicKey)
+
+	for _, vector := range v.Artifacts {
+		vector := vector
+
+		t.Run(vector.Name, func(t *testing.T) {
+			content, err := ioutil.ReadFile(filepath.Join("testdata", vector.File))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			header, err := artifact.ParseHeader(bytes.NewReader(content))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if header.Version != vector.Version || header.Offset != vector.Offset ||
+				header.Marker != vector.Marker || header.End != vector.End || header.Size != vector.Size {
+				t.Fatalf("header %+v, expected %+v", header, vector)
+			}
+
+			recoveryKey, err := hex.DecodeString(vector.RecoveryKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			frameOffset := header.Offset
+
+			if len(recoveryKey) == 0 {
+				recoveryKey = nil
+			} else {
+				frameOffset, err = artifact.OpenRecoveryRecord(header, recoveryKey)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// the offset of a recoverable artifact is only in its recovery record
+				_, err = artifact.FrameReader(bytes.NewReader(content), nil)
+				if !errors.Is(err, artifact.ErrNoOffset) {
+					t.Errorf("without the recovery key: %v, expected %s", err, artifact.ErrNoOffset)
+				}
+			}
+
+			if frameOffset != vector.FrameOffset || artifact.FinalPadding(frameOffset) != vector.FinalPadding {
+				t.Errorf("frame at %d, padding %d, expected %d and %d", frameOffset,
+					artifact.FinalPadding(frameOffset), vector.FrameOffset, vector.FinalPadding)
+			}
+
+			payload, err := artifact.FrameReader(bytes.NewReader(content), recoveryKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			plaintext, err := ioutil.ReadAll(payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sum := sha256.Sum256(plaintext)
+			if string(plaintext) != vector.Payload || hex.EncodeToString(sum[:]) != vector.PayloadSHA256 {
+				t.Errorf("payload %q, expected %q", plaintext, vector.Payload)
+			}
+
+			err = artifact.VerifySignature(header, pub)
+			if (err == nil) != vector.Signed {
+				t.Errorf("signature: %v, signed %v", err, vector.Signed)
+			}
+
+			if vector.Signed {
+				signature, err := artifact.Sign(bytes.NewReader(content), header.Size-ed25519.SignatureSize, key)
+				if err != nil || !bytes.Equal(signature, content[header.Size-ed25519.SignatureSize:]) {
+					t.Errorf("the signature is not the one of Sign: %v", err)
+				}
+			}
+
+			// any byte changed breaks the signature or the frame
+			content[len(content)/2] ^= 1
+
+			_, frameErr := artifact.FrameReader(bytes.NewReader(content), recoveryKey)
+			if frameErr == nil && artifact.VerifySignature(header, pub) == nil {
+				t.Error("a modified artifact reads as valid")
+			}
+		})
+	}
+}
+
+func TestNotArtifacts(t *testing.T) {
+	v, _ := readVectors(t)
+
+	for _, name := range v.NotArtifacts {
+		content, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = artifact.ParseHeader(bytes.NewReader(content))
+		if !errors.Is(err, artifact.ErrNotArtifact) {
+			t.Errorf("%s: %v, expected %s", name, err, artifact.ErrNotArtifact)
+		}
+	}
+}
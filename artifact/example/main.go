@@ -0,0 +1,261 @@
+/*
+Command example reads pakkero artifacts with the artifact package only,
+as an auditor not trusting pakkero would, see the test-artifact target
+of the Makefile. With -vectors it checks the test vectors of the
+package, else it prints the header of a file, checks its signature with
+-pubkey and writes its payload to -o, with its recovery key if it is
+not repackable.
+*/
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/89luca89/pakkero/artifact"
+)
+
+// vectors is testdata/vectors.json of the artifact package
+type vectors struct {
+	SigningSeed  string `json:"signing_seed"`
+	PublicKey    string `json:"public_key"`
+	FinalPadding []struct {
+		Offset  int64 `json:"offset"`
+		Padding int64 `json:"padding"`
+	} `json:"final_padding"`
+	Artifacts []struct {
+		Name          string `json:"name"`
+		File          string `json:"file"`
+		Version       string `json:"version"`
+		Offset        int64  `json:"offset"`
+		Marker        int64  `json:"marker"`
+		End           int64  `json:"end"`
+		Size          int64  `json:"size"`
+		RecoveryKey   string `json:"recovery_key"`
+		Signed        bool   `json:"signed"`
+		PayloadSHA256 string `json:"payload_sha256"`
+	} `json:"artifacts"`
+	NotArtifacts []string `json:"not_artifacts"`
+}
+
+// payloadSum returns the sha256 of the payload of the artifact, read with the key
+func payloadSum(r io.ReaderAt, key []byte) (string, error) {
+	payload, err := artifact.FrameReader(r, key)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+
+	_, err = io.Copy(hash, payload)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// checkVectors returns the first vector of the file that the package does not read as expected
+func checkVectors(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	v := vectors{}
+
+	err = json.Unmarshal(content, &v)
+	if err != nil {
+		return err
+	}
+
+	for _, vector := range v.FinalPadding {
+		if padding := artifact.FinalPadding(vector.Offset); padding != vector.Padding {
+			return fmt.Errorf("final padding of %d: %d, expected %d", vector.Offset, padding, vector.Padding)
+		}
+	}
+
+	pub, err := hex.DecodeString(v.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	for _, vector := range v.Artifacts {
+		content, err := ioutil.ReadFile(filepath.Join(filepath.Dir(path), vector.File))
+		if err != nil {
+			return err
+		}
+
+		header, err := artifact.ParseHeader(bytes.NewReader(content))
+		if err != nil {
+			return fmt.Errorf("%s: %w", vector.Name, err)
+		}
+
+		if header.Version != vector.Version || header.Offset != vector.Offset || header.Marker != vector.Marker ||
+			header.End != vector.End || header.Size != vector.Size {
+			return fmt.Errorf("%s: header %+v, expected %+v", vector.Name, header, vector)
+		}
+
+		key, err := hex.DecodeString(vector.RecoveryKey)
+		if err != nil {
+			return err
+		}
+
+		if len(key) == 0 {
+			key = nil
+		}
+
+		sum, err := payloadSum(bytes.NewReader(content), key)
+		if err != nil || sum != vector.PayloadSHA256 {
+			return fmt.Errorf("%s: payload %s %v, expected %s", vector.Name, sum, err, vector.PayloadSHA256)
+		}
+
+		err = artifact.VerifySignature(header, pub)
+		if (err == nil) != vector.Signed {
+			return fmt.Errorf("%s: signature %v, signed %v", vector.Name, err, vector.Signed)
+		}
+
+		// any byte changed breaks the signature or the frame
+		content[len(content)/2] ^= 1
+
+		_, frameErr := payloadSum(bytes.NewReader(content), key)
+		if frameErr == nil && artifact.VerifySignature(header, pub) == nil {
+			return fmt.Errorf("%s: a modified artifact reads as valid", vector.Name)
+		}
+
+		fmt.Printf("%s: OK\n", vector.Name)
+	}
+
+	for _, name := range v.NotArtifacts {
+		content, err := ioutil.ReadFile(filepath.Join(filepath.Dir(path), name))
+		if err != nil {
+			return err
+		}
+
+		_, err = artifact.ParseHeader(bytes.NewReader(content))
+		if !errors.Is(err, artifact.ErrNotArtifact) {
+			return fmt.Errorf("%s: %v, expected %s", name, err, artifact.ErrNotArtifact)
+		}
+
+		fmt.Printf("%s: OK, not an artifact\n", name)
+	}
+
+	return nil
+}
+
+// readPublicKey returns the ed25519 public key of a PEM file
+func readPublicKey(path string) (ed25519.PublicKey, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New(path + ": no PEM block")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New(path + ": not an ed25519 public key")
+	}
+
+	return pub, nil
+}
+
+// inspect prints the header of the artifact, checks its signature and writes its payload
+func inspect(path string, pubKey string, recoveryKey string, output string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	header, err := artifact.ParseHeader(file)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("version %s, marker at %d, offset %d, size %d\n", header.Version, header.Marker, header.Offset, header.Size)
+
+	if pubKey != "" {
+		pub, err := readPublicKey(pubKey)
+		if err != nil {
+			return err
+		}
+
+		err = artifact.VerifySignature(header, pub)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("signature OK")
+	}
+
+	if output == "" {
+		return nil
+	}
+
+	var key []byte
+
+	if recoveryKey != "" {
+		key, err = hex.DecodeString(recoveryKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	payload, err := artifact.FrameReader(file, key)
+	if err != nil {
+		return err
+	}
+
+	content, err := ioutil.ReadAll(payload)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(output, content, 0600)
+}
+
+func main() {
+	vectorsFile := flag.String("vectors", "", "check the test vectors of `vectors.json`")
+	pubKey := flag.String("pubkey", "", "check the signature with the ed25519 public key of `public.pem`")
+	recoveryKey := flag.String("key", "", "recovery `key` of the payload, in hex")
+	output := flag.String("o", "", "write the payload to `file`")
+	flag.Parse()
+
+	var err error
+
+	switch {
+	case *vectorsFile != "" && flag.NArg() == 0:
+		err = checkVectors(*vectorsFile)
+	case *vectorsFile == "" && flag.NArg() == 1:
+		err = inspect(flag.Arg(0), *pubKey, *recoveryKey, *output)
+	default:
+		fmt.Fprintln(os.Stderr, "usage: example -vectors vectors.json | example [-pubkey public.pem] [-key hex] [-o payload] file")
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// argument names that should be completed as paths
+var pathArguments = map[string]bool{
+	"file": true,
+}
+
+/*
+completion will print the completion script for the requested shell,
+the script is generated from the flag definitions.
+*/
+func completion(args []string) int {
+	generators := map[string]func(io.Writer, *flag.FlagSet){
+		"bash": bashCompletion,
+		"zsh":  zshCompletion,
+		"fish": fishCompletion,
+	}
+
+	if len(args) != 1 || generators[args[0]] == nil {
+		println("Usage: " + programName + " completion bash|zsh|fish")
+
+		return 1
+	}
+
+	generators[args[0]](os.Stdout, newFlagSet(&cliOptions{}))
+
+	return 0
+}
+
+/*
+completionFlags returns the flags in help order, split in
+flags with a path argument, with another argument and booleans.
+*/
+func completionFlags(flags *flag.FlagSet) ([]string, []string, []string) {
+	paths := []string{}
+	values := []string{}
+	booleans := []string{}
+
+	for _, group := range allGroups(flags) {
+		for _, name := range group.flags {
+			arg, _ := flagArgument(flags.Lookup(name))
+
+			switch {
+			case pathArguments[arg]:
+				paths = append(paths, "-"+name)
+			case arg != "":
+				values = append(values, "-"+name)
+			default:
+				booleans = append(booleans, "-"+name)
+			}
+		}
+	}
+
+	return paths, values, booleans
+}
+
+func sortedSubcommands() []string {
+	result := []string{}
+	for name := range subcommands {
+		result = append(result, name)
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+func bashCompletion(w io.Writer, flags *flag.FlagSet) {
+	paths, values, booleans := completionFlags(flags)
+	all := append(append(append([]string{}, paths...), values...), booleans...)
+
+	fmt.Fprintf(w, "# bash completion for %s\n", programName)
+	fmt.Fprintf(w, "_%s() {\n", programName)
+	fmt.Fprintln(w, `	local cur="${COMP_WORDS[COMP_CWORD]}"`)
+	fmt.Fprintln(w, `	local prev="${COMP_WORDS[COMP_CWORD-1]}"`)
+	fmt.Fprintln(w, `	case "${COMP_WORDS[1]}" in`)
+
+	for _, name := range sortedSubcommands() {
+		fmt.Fprintf(w, "\t%s)\n", name)
+		fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W \"%s\" -- \"$cur\"))\n",
+			strings.Join(subcommands[name], " "))
+		fmt.Fprintln(w, "\t\treturn")
+		fmt.Fprintln(w, "\t\t;;")
+	}
+
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, `	case "$prev" in`)
+	fmt.Fprintf(w, "\t%s)\n", strings.Join(paths, "|"))
+	fmt.Fprintln(w, `		COMPREPLY=($(compgen -f -- "$cur"))`)
+	fmt.Fprintln(w, "\t\treturn")
+	fmt.Fprintln(w, "\t\t;;")
+	fmt.Fprintf(w, "\t%s)\n", strings.Join(values, "|"))
+	fmt.Fprintln(w, "\t\treturn")
+	fmt.Fprintln(w, "\t\t;;")
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, `	if [ "$COMP_CWORD" -eq 1 ]; then`)
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W \"%s %s\" -- \"$cur\"))\n",
+		strings.Join(sortedSubcommands(), " "), strings.Join(all, " "))
+	fmt.Fprintln(w, "\t\treturn")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintf(w, "\tCOMPREPLY=($(compgen -W \"%s\" -- \"$cur\"))\n", strings.Join(all, " "))
+	fmt.Fprintln(w, "}")
+	fmt.Fprintf(w, "complete -F _%s %s\n", programName, programName)
+}
+
+// escape the single quotes and the brackets for zsh _arguments specs
+func zshEscape(input string) string {
+	replacer := strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`, ":", `\:`)
+
+	return replacer.Replace(input)
+}
+
+func zshCompletion(w io.Writer, flags *flag.FlagSet) {
+	fmt.Fprintf(w, "#compdef %s\n\n", programName)
+	fmt.Fprintf(w, "_%s() {\n", programName)
+	fmt.Fprintln(w, "\tif (( CURRENT == 3 )); then")
+	fmt.Fprintln(w, "\t\tcase $words[2] in")
+
+	for _, name := range sortedSubcommands() {
+		fmt.Fprintf(w, "\t\t%s) _values '%s' %s; return ;;\n",
+			name, name, strings.Join(subcommands[name], " "))
+	}
+
+	fmt.Fprintln(w, "\t\tesac")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "\t_arguments \\")
+
+	for _, group := range allGroups(flags) {
+		for _, name := range group.flags {
+			arg, usage := flagArgument(flags.Lookup(name))
+			spec := fmt.Sprintf("'-%s[%s]", name, zshEscape(usage))
+
+			switch {
+			case pathArguments[arg]:
+				spec += ":" + arg + ":_files"
+			case arg != "":
+				spec += ":" + arg + ": "
+			}
+
+			fmt.Fprintf(w, "\t\t%s' \\\n", spec)
+		}
+	}
+
+	fmt.Fprintf(w, "\t\t'1: :(%s)'\n", strings.Join(sortedSubcommands(), " "))
+	fmt.Fprintln(w, "}")
+	fmt.Fprintf(w, "\n_%s \"$@\"\n", programName)
+}
+
+// escape the single quotes for fish
+func fishEscape(input string) string {
+	return strings.ReplaceAll(input, "'", `\'`)
+}
+
+func fishCompletion(w io.Writer, flags *flag.FlagSet) {
+	fmt.Fprintf(w, "# fish completion for %s\n", programName)
+	fmt.Fprintf(w, "complete -c %s -f\n", programName)
+
+	for _, name := range sortedSubcommands() {
+		fmt.Fprintf(w, "complete -c %s -n '__fish_use_subcommand' -a %s\n", programName, name)
+		fmt.Fprintf(w, "complete -c %s -n '__fish_seen_subcommand_from %s' -a '%s'\n",
+			programName, name, strings.Join(subcommands[name], " "))
+	}
+
+	for _, group := range allGroups(flags) {
+		for _, name := range group.flags {
+			arg, usage := flagArgument(flags.Lookup(name))
+			line := fmt.Sprintf("complete -c %s -o %s -d '%s'", programName, name, fishEscape(usage))
+
+			switch {
+			case pathArguments[arg]:
+				line += " -r -F"
+			case arg != "":
+				line += " -r"
+			}
+
+			fmt.Fprintln(w, line)
+		}
+	}
+}
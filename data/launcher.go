@@ -1,31 +1,67 @@
 package main
 
 import (
+	// OB_FEATURE_BEGIN !userland
+	// OB_FEATURE_BEGIN !capture
 	obBufio "bufio"
+	// OB_FEATURE_END !capture
+	// OB_FEATURE_END !userland
 	obBytes "bytes"
+	// OB_FEATURE_BEGIN codecgzip
+	obGzip "compress/gzip"
+	// OB_FEATURE_END codecgzip
+	// OB_FEATURE_BEGIN codeczlib
 	obZlib "compress/zlib"
+	// OB_FEATURE_END codeczlib
 	obAES "crypto/aes"
 	obCipher "crypto/cipher"
+	// OB_FEATURE_BEGIN license|launchtoken|override
+	obEd25519 "crypto/ed25519"
+	// OB_FEATURE_END license|launchtoken|override
+	// OB_FEATURE_BEGIN registration
+	obHMAC "crypto/hmac"
+	obRand "crypto/rand"
+	obRSA "crypto/rsa"
+	// OB_FEATURE_END registration
+	obSHA256 "crypto/sha256"
 	obSHA "crypto/sha512"
 	obBase64 "encoding/base64"
 	obBinary "encoding/binary"
+	// OB_FEATURE_BEGIN launcherdebug
+	obFmt "fmt"
+	// OB_FEATURE_END launcherdebug
+	obHex "encoding/hex"
+	obErrors "errors"
+	obIO "io"
 	obUtilio "io/ioutil"
 	obMath "math"
+	// OB_FEATURE_BEGIN registration
+	obBig "math/big"
+	// OB_FEATURE_END registration
 	obOS "os"
 	obExec "os/exec"
+	// OB_FEATURE_BEGIN sched|seccomp
+	obRuntime "runtime"
+	// OB_FEATURE_END sched|seccomp
 	obSignal "os/signal"
 	obStrconv "strconv"
 	obStrings "strings"
 	obSync "sync"
+	// OB_FEATURE_BEGIN decoy
+	obAtomic "sync/atomic"
+	// OB_FEATURE_END decoy
 	obSyscall "syscall"
 	obTime "time"
 	obUnsafe "unsafe"
 )
 
 type obDependency struct {
-	obDepSize string
-	obDepName string
-	obDepBFD  []float64
+	obDepSize   string
+	obDepName   []byte
+	obDepDigest []byte
+	obDepMode   string
+	obDepPolicy string
+	obDepBFD    []float64
 }
 
 const ERR = 1
@@ -34,6 +70,17 @@ const obCorrelationLevel = 0.4
 const obStdLevel = 1
 const obFileSizeLevel = 15
 
+// largest int, the size limit of a buffer
+const obMaxInt = int64(^uint(0) >> 1)
+
+/*
+Anchor of the obfuscated strings: it is always 1, but known only at
+run time, so the compiler cannot fold their bitshifts back into plain
+bytes. Being a variable initializer, it is set before any other
+package variable using the strings.
+*/
+var obAnchor = uint8(obOS.Getpagesize() / obOS.Getpagesize())
+
 /*
 TODO:
     missing an int3 scanner (golang runtime is full of them...)
@@ -45,6 +92,127 @@ func obExit() {
 	obOS.Exit(ERR)
 }
 
+// index of each check in the attestation records, see pakkero.AttestChecks
+const (
+	obCheckSigTrap = iota + 1
+	obCheckParentCmdLine
+	obCheckParentTracer
+	obCheckParentStat
+	obCheckEnvArgs
+	obCheckEnvParent
+	obCheckEnv
+	obCheckLdPreload
+	obCheckDependency
+	obCheckLicense
+	obCheckSeals
+	obCheckDecrypt
+	obCheckGuard
+	obCheckHostname
+	obCheckRegistration
+	obCheckCustom
+)
+
+// true in a health check child, see obHealthCheck
+var obHealthChild bool
+
+// React to a failed check.
+func obTamper(obCheck int) {
+	// OB_FEATURE_BEGIN health
+	if obHealthChild {
+		obOS.Exit(obHealthFailed)
+	}
+	// OB_FEATURE_END health
+	// OB_FEATURE_BEGIN override
+	if obRelaxedChecks&(1<<uint(obCheck)) != 0 {
+		obRelax(obCheck)
+
+		return
+	}
+	// OB_FEATURE_END override
+	obAttest(obCheck) // OB_FEATURE attest
+	obExit()
+}
+
+// OB_FEATURE_BEGIN attest
+/*
+Write the attestation record of a check, 0 when all of them passed,
+as a single line of decimal numbers:
+
+	build id, check index, unix time
+
+Best effort: the file and the socket are non blocking, nothing
+waits and the errors are ignored.
+*/
+func obAttest(obCheck int) {
+	obRecord := obSensitive(nil, "ATTESTID")
+	obRecord = append(obRecord, " "...)
+	obRecord = obStrconv.AppendInt(obRecord, int64(obCheck), 10)
+	obRecord = append(obRecord, " "...)
+	obRecord = obStrconv.AppendInt(obRecord, obTime.Now().Unix(), 10)
+	obRecord = append(obRecord, "\n"...)
+
+	obPath := obSensitive(nil, "ATTESTPATH")
+	// OB_FEATURE_BEGIN attestfile
+	obAtFDCWD := -100
+
+	obWithPath(obPath, func(obCPath uintptr) {
+		obFileDescriptor, _, obErr := obSyscall.Syscall6(obSyscall.SYS_OPENAT,
+			uintptr(obAtFDCWD), obCPath,
+			uintptr(obSyscall.O_WRONLY|obSyscall.O_APPEND|obSyscall.O_CREAT|
+				obSyscall.O_NONBLOCK|obSyscall.O_CLOEXEC), 0600, 0, 0)
+		if obErr == obSyscall.Errno(0) {
+			obSyscall.Write(int(obFileDescriptor), obRecord)
+			obSyscall.Close(int(obFileDescriptor))
+		}
+	})
+	// OB_FEATURE_END attestfile
+	// OB_FEATURE_BEGIN attestsocket
+	// OB_SYSCALLS socket,connect
+	obSocket, obErr := obSyscall.Socket(obSyscall.AF_UNIX,
+		obSyscall.SOCK_DGRAM|obSyscall.SOCK_NONBLOCK|obSyscall.SOCK_CLOEXEC, 0)
+	if obErr == nil {
+		if obSyscall.Connect(obSocket, &obSyscall.SockaddrUnix{Name: obUnsafeString(obPath)}) == nil {
+			obSyscall.Write(obSocket, obRecord)
+		}
+
+		obSyscall.Close(obSocket)
+	}
+	// OB_FEATURE_END attestsocket
+	obWipe(obPath)
+	obWipe(obRecord)
+}
+
+// OB_FEATURE_END attest
+
+// OB_FEATURE_BEGIN launcherdebug
+var obDebugOutput = obOS.Stderr
+var obDebugOnce obSync.Once
+
+/*
+Log a troubleshooting message on stderr, or in the file set in
+PAKKERO_LAUNCHER_DEBUG_FILE.
+Messages end with a newline, so they are not obfuscated and
+they can be found in the binary.
+*/
+func obDebugf(obFormat string, obArgs ...interface{}) {
+	obDebugOnce.Do(func() {
+		obPath, obFound := obOS.LookupEnv("PAKKERO_LAUNCHER_DEBUG_FILE")
+		if !obFound {
+			return
+		}
+
+		obDebugFile, obErr := obOS.OpenFile(obPath,
+			obOS.O_APPEND|obOS.O_CREATE|obOS.O_WRONLY, 0600)
+		if obErr == nil {
+			obDebugOutput = obDebugFile
+		}
+	})
+
+	obFmt.Fprintf(obDebugOutput, "[launcher %d] "+obFormat, append([]interface{}{obOS.Getpid()}, obArgs...)...)
+}
+
+// OB_FEATURE_END launcherdebug
+
 /*
 Breakpoint on linux are 0xCC and will be interpreted as a
 SIGTRAP, we will intercept them.
@@ -53,9 +221,11 @@ func obSigTrap(obInput chan obOS.Signal) {
 	obMySignal := <-obInput
 	switch obMySignal {
 	case obSyscall.SIGILL:
-		obExit()
+		obDebugf("check sigtrap: failed, got SIGILL\n") // OB_FEATURE launcherdebug
+		obTamper(obCheckSigTrap)
 	case obSyscall.SIGTRAP:
-		obExit()
+		obDebugf("check sigtrap: failed, got SIGTRAP\n") // OB_FEATURE launcherdebug
+		obTamper(obCheckSigTrap)
 	default:
 		return
 	}
@@ -70,6 +240,7 @@ against NOP attacks and LD_PRELOAD attacks
 func obPtraceDetect() {
 	var obOffset = 0
 
+	// OB_SYSCALLS ptrace
 	_, _, obResult := obSyscall.RawSyscall(obSyscall.SYS_PTRACE,
 		uintptr(obSyscall.PTRACE_TRACEME),
 		0,
@@ -93,15 +264,58 @@ func obPtraceDetect() {
 	}
 }
 
+// OB_FEATURE_BEGIN android
+/*
+On android the apps are started by zygote and their shells run
+from /system/bin: their names are legitimate whatever they contain.
+*/
+func obAndroidParent() bool {
+	obPidParent := obOS.Getppid()
+
+	obCmdline, _ := obReadFile("/proc/" + obStrconv.FormatInt(int64(obPidParent), 10) +
+		"/cmdline")
+	obName := obStrings.SplitN(string(obCmdline), "\x00", 2)[0]
+
+	switch obName {
+	case "zygote", "zygote64", "app_process", "app_process32", "app_process64",
+		"/system/bin/sh", "sh":
+		return true
+	}
+
+	return false
+}
+
+/*
+Explain a permission failure if SELinux enforces its policy, on android
+it denies running from memory in most contexts, instead of dying silently.
+*/
+func obExplainDenied(obErr error) {
+	if !obErrors.Is(obErr, obSyscall.EACCES) && !obErrors.Is(obErr, obSyscall.EPERM) {
+		return
+	}
+
+	obEnforce, _ := obReadFile("/sys/fs/selinux/enforce")
+	if obStrings.TrimSpace(string(obEnforce)) == "1" {
+		println("SELinux is enforcing and denied running the program from memory")
+	}
+}
+
+// OB_FEATURE_END android
+
 /*
 Check the process cmdline to spot if a debugger is inline
 */
 func obParentCmdLineDetect() {
+	// OB_FEATURE_BEGIN android
+	if obAndroidParent() {
+		return
+	}
+	// OB_FEATURE_END android
 	obPidParent := obOS.Getppid()
 
 	obNameFile := "/proc/" + obStrconv.FormatInt(int64(obPidParent), 10) +
 		"/cmdline"
-	obStatParent, _ := obUtilio.ReadFile(obNameFile)
+	obStatParent, _ := obReadFile(obNameFile)
 
 	if obStrings.Contains(string(obStatParent), "gdb") ||
 		obStrings.Contains(string(obStatParent), "dlv") ||
@@ -114,7 +328,8 @@ func obParentCmdLineDetect() {
 		obStrings.Contains(string(obStatParent), "ltrace") ||
 		obStrings.Contains(string(obStatParent), "strace") ||
 		obStrings.Contains(string(obStatParent), "valgrind") {
-		obExit()
+		obDebugf("check parent-cmdline: failed\n") // OB_FEATURE launcherdebug
+		obTamper(obCheckParentCmdLine)
 	}
 }
 
@@ -126,7 +341,7 @@ func obParentTracerDetect() {
 
 	obNameFile := "/proc/" + obStrconv.FormatInt(int64(obPidParent), 10) +
 		"/status"
-	obStatParent, _ := obUtilio.ReadFile(obNameFile)
+	obStatParent, _ := obReadFile(obNameFile)
 	obStatLines := obStrings.Split(string(obStatParent), "\n")
 
 	for _, obValue := range obStatLines {
@@ -135,7 +350,8 @@ func obParentTracerDetect() {
 			obSplitValue := obStrings.Replace(obSplitArray[1], "\t", "", -1)
 
 			if obSplitValue != "0" {
-				obExit()
+				obDebugf("check parent-tracer: failed, TracerPid %s\n", obSplitValue) // OB_FEATURE launcherdebug
+				obTamper(obCheckParentTracer)
 			}
 		}
 	}
@@ -145,11 +361,16 @@ func obParentTracerDetect() {
 Check the process cmdline to spot if a debugger is the PPID of our process
 */
 func obParentDetect() {
+	// OB_FEATURE_BEGIN android
+	if obAndroidParent() {
+		return
+	}
+	// OB_FEATURE_END android
 	obPidParent := obOS.Getppid()
 
 	obNameFile := "/proc/" + obStrconv.FormatInt(int64(obPidParent), 10) +
 		"/stat"
-	obStatParent, _ := obUtilio.ReadFile(obNameFile)
+	obStatParent, _ := obReadFile(obNameFile)
 
 	if obStrings.Contains(string(obStatParent), "gdb") ||
 		obStrings.Contains(string(obStatParent), "dlv") ||
@@ -162,7 +383,8 @@ func obParentDetect() {
 		obStrings.Contains(string(obStatParent), "ltrace") ||
 		obStrings.Contains(string(obStatParent), "strace") ||
 		obStrings.Contains(string(obStatParent), "valgrind") {
-		obExit()
+		obDebugf("check parent-stat: failed\n") // OB_FEATURE launcherdebug
+		obTamper(obCheckParentStat)
 	}
 }
 
@@ -171,9 +393,23 @@ Check the process cmdline to spot if a debugger is launcher
 "_" and Args[0] should match otherwise
 */
 func obEnvArgsDetect() {
+	// OB_FEATURE_BEGIN init
+	// container runtimes do not set "_" for their entrypoint
+	if _, obFound := obOS.LookupEnv("_"); !obFound && obOS.Getpid() == 1 {
+		return
+	}
+	// OB_FEATURE_END init
+	// OB_FEATURE_BEGIN android
+	// neither zygote nor the android shells set "_",
+	// it is missing or inherited from an earlier command
+	if obAndroidParent() {
+		return
+	}
+	// OB_FEATURE_END android
 	obLines, _ := obOS.LookupEnv("_")
 	if obLines != obOS.Args[0] {
-		obExit()
+		obDebugf("check env-args: failed\n") // OB_FEATURE launcherdebug
+		obTamper(obCheckEnvArgs)
 	}
 }
 
@@ -194,7 +430,8 @@ func obEnvParentDetect() {
 		obStrings.Contains(obLines, "ltrace") ||
 		obStrings.Contains(obLines, "strace") ||
 		obStrings.Contains(obLines, "valgrind") {
-		obExit()
+		obDebugf("check env-parent: failed\n") // OB_FEATURE launcherdebug
+		obTamper(obCheckEnvParent)
 	}
 }
 
@@ -209,10 +446,74 @@ func obEnvDetect() {
 	_, obLineLdPreload := obOS.LookupEnv("LD_PRELOAD")
 
 	if obLines || obColumns || obLineLdPreload {
-		obExit()
+		obDebugf("check env: failed\n") // OB_FEATURE launcherdebug
+		obTamper(obCheckEnv)
+	}
+}
+
+// OB_FEATURE_BEGIN !launcherdebug
+// go runtime variables pinned by the launcher, unset if pinned to ""
+var obPinnedRuntime = map[string]string{
+	"GOTRACEBACK": "none",
+	"GODEBUG":     "",
+	"GOGC":        "",
+	"GOMAXPROCS":  "PINNEDPROCS", // OB_FEATURE pinprocs
+}
+
+// variable keeping the runtime variables the launcher inherited
+var obRuntimeStash = "OB_RUNTIME_ENV"
+
+/*
+Pin the go runtime variables: no stack traces on a crash whatever
+GOTRACEBACK says, no GODEBUG and GOGC tuning, and the GOMAXPROCS chosen
+at packing time, if any. The runtime reads them
+before main, so the launcher execs itself again with the pinned values,
+the inherited ones are given back to the payload, see obInheritedEnviron.
+The GODEBUG settings acting before main, like inittrace, cannot be undone.
+*/
+func obPinRuntime() {
+	obPinned := true
+
+	for obName, obValue := range obPinnedRuntime {
+		obCurrent, obFound := obOS.LookupEnv(obName)
+		obPinned = obPinned && obCurrent == obValue && obFound == (obValue != "")
+	}
+
+	if obPinned {
+		return
 	}
+
+	obStash := []byte{}
+	obEnv := []string{}
+
+	for _, obVariable := range obOS.Environ() {
+		obName := obStrings.SplitN(obVariable, "=", 2)[0]
+
+		if _, obFound := obPinnedRuntime[obName]; obFound {
+			obStash = append(append(obStash, obVariable...), 0)
+		} else if obName != obRuntimeStash {
+			obEnv = append(obEnv, obVariable)
+		}
+	}
+
+	for obName, obValue := range obPinnedRuntime {
+		if obValue != "" {
+			obEnv = append(obEnv, obName+"="+obValue)
+		}
+	}
+
+	obEnv = append(obEnv, obRuntimeStash+"="+obHex.EncodeToString(obStash))
+
+	// if the launcher cannot exec itself it runs unpinned
+	obNameFile, _ := obOS.Executable()
+
+	obSealDescriptors()
+
+	_ = obSyscall.Exec(obNameFile, obOS.Args, obEnv)
 }
 
+// OB_FEATURE_END !launcherdebug
+
 /*
 Check the process is launcher with a LD_PRELOAD set.
 This can be an injection attack (like on frida) to try and circumvent
@@ -224,23 +525,26 @@ func obLdPreloadDetect() {
 
 	err := obOS.Setenv(obKey, obValue)
 	if err != nil {
-		obExit()
+		obDebugf("check ld-preload: failed\n") // OB_FEATURE launcherdebug
+		obTamper(obCheckLdPreload)
 	}
 
 	obLineLdPreload, _ := obOS.LookupEnv(obKey)
 	if obLineLdPreload == obValue {
 		err := obOS.Unsetenv(obKey)
 		if err != nil {
-			obExit()
+			obDebugf("check ld-preload: failed\n") // OB_FEATURE launcherdebug
+			obTamper(obCheckLdPreload)
 		}
 	} else {
-		obExit()
+		obDebugf("check ld-preload: failed\n") // OB_FEATURE launcherdebug
+		obTamper(obCheckLdPreload)
 	}
 }
 
 // calculate BFD (byte frequency distribution) for the input dependency
-func obUtilBFDCalc(obInput string) []float64 {
-	obFile, _ := obUtilio.ReadFile(obInput)
+func obUtilBFDCalc(obInput *obOS.File) []float64 {
+	obFile, _ := obReadAll(obInput)
 
 	obBfd := make([]float64, 256)
 	for _, obValue := range obFile {
@@ -333,65 +637,215 @@ func obUtilCombinedStandardDeviationCalc(obDepBFD []float64, obTargetBFD []float
 	return obStdDev
 }
 
+/*
+Check all the registered dependencies, each one with its own mode:
+  - exists: the file must be present
+  - size: the file must have the same size
+  - digest: the file must have the same sha256
+  - bfd: the file must have similar size and byte frequency distribution
+*/
 func obDependencyCheck() {
-	obStrControl1 := "_DEP"
-	obStrControl2 := "_NAME"
-	obStrControl3 := "_SIZE"
-	obInstanceDep := obDependency{
-		obDepName: "DEPNAME1",
-		obDepSize: "DEPSIZE2",
-		obDepBFD:  []float64{1, 2, 3, 4},
-	}
-	// control that we effectively want to control the dependencies
-	if (obInstanceDep.obDepName != obStrControl1[1:]+obStrControl2[1:]+"1") &&
-		(obInstanceDep.obDepSize != obStrControl1[1:]+obStrControl3[1:]+"2") {
-		// check if the file is a symbolic link
-		obLTargetStats, _ := obOS.Lstat(obInstanceDep.obDepName)
-		if (obLTargetStats.Mode() & obOS.ModeSymlink) != 0 {
-			obExit()
+	obDependencies := []obDependency{
+		// OB_DEPENDENCIES
+	}
+
+	defer func() {
+		for _, obInstanceDep := range obDependencies {
+			obWipe(obInstanceDep.obDepName)
+			obWipe(obInstanceDep.obDepDigest)
 		}
-		// open dependency in current environment and check it's size
-		obFile, obErr := obOS.Open(obInstanceDep.obDepName)
-		if obErr != nil {
-			obExit()
+	}()
+
+	obFiles := []*obOS.File{}
+
+	for _, obInstanceDep := range obDependencies {
+		obFile, obMatch := obDependencyMatch(obInstanceDep)
+		if !obMatch {
+			obDebugf("check dependency: %s failed, policy %s\n", obInstanceDep.obDepName, obInstanceDep.obDepPolicy) // OB_FEATURE launcherdebug
+			obDependencyReact(obInstanceDep.obDepPolicy)
 		}
-		defer obFile.Close()
 
-		obStatsFile, _ := obFile.Stat()
-		obTargetDepSize, _ := obStrconv.ParseInt(obInstanceDep.obDepSize, 10, 64)
-		obTargetTreshold := (obTargetDepSize / 100) * obFileSizeLevel
-		// first check if file size is +/- 15% of registered size
-		if (obStatsFile.Size()-obTargetDepSize) < (-1*(obTargetTreshold)) ||
-			(obStatsFile.Size()-obTargetDepSize) > obTargetTreshold {
-			obExit()
+		obFiles = append(obFiles, obFile)
+	}
+
+	obKeepDependencies(obFiles)
+}
+
+// OB_FEATURE_BEGIN binddeps
+/*
+The dependencies verified by the first check, in the order they are
+registered, nil for the ones that did not match: their descriptors are
+passed to the payload, see obBindDependencies.
+*/
+var obBoundDeps []*obOS.File
+
+var obBoundDepsLock obSync.Mutex
+
+/*
+Pass the bound dependencies to the payload, each one as a descriptor
+whose path is in OB_DEP_FD_ and its index: opening it the payload reads
+the file that was verified, even if its path was replaced since.
+*/
+func obBindDependencies(obCommand *obExec.Cmd) {
+	obBoundDepsLock.Lock()
+	defer obBoundDepsLock.Unlock()
+
+	for obIndex, obFile := range obBoundDeps {
+		if obFile == nil {
+			continue
 		}
 
-		// Calculate BFD (byte frequency distribution) of target file
-		// and calculate standard deviation from registered fingerprint.
-		obTargetBFD := obUtilBFDCalc(obInstanceDep.obDepName)
+		// the check read it, the payload shares the offset
+		obFile.Seek(0, obIO.SeekStart)
+
+		obCommand.ExtraFiles = append(obCommand.ExtraFiles, obFile)
+		obCommand.Env = append(obCommand.Env, "OB_DEP_FD_"+obStrconv.Itoa(obIndex)+
+			"=/proc/self/fd/"+obStrconv.Itoa(2+len(obCommand.ExtraFiles)))
+	}
+}
+
+// OB_FEATURE_END binddeps
+/*
+Keep the descriptors of the dependencies of the first check to bind
+them, close the ones of the following checks.
+*/
+func obKeepDependencies(obFiles []*obOS.File) {
+	// OB_FEATURE_BEGIN binddeps
+	obBoundDepsLock.Lock()
+	defer obBoundDepsLock.Unlock()
 
-		// Calculate covariance of the 2 dataset
-		obCovariance := obUtilCovarianceCalc(obInstanceDep.obDepBFD, obTargetBFD)
-		// calculate the correlation index of  Bravais-Pearson to see if the
-		// two dataset are linearly correlated
-		obDepStdDev := obUtilStandardDeviationCalc(obInstanceDep.obDepBFD)
-		obTargetStdDev := obUtilStandardDeviationCalc(obTargetBFD)
-		obCorrelation := obCovariance / (obDepStdDev * obTargetStdDev)
+	if obBoundDeps == nil {
+		obBoundDeps = obFiles
 
-		if obCorrelation < obCorrelationLevel {
-			// not correlated, different nature
-			obExit()
+		return
+	}
+	// OB_FEATURE_END binddeps
+
+	for _, obFile := range obFiles {
+		if obFile != nil {
+			obFile.Close()
 		}
+	}
+}
+
+/*
+React to a dependency mismatch following its policy:
+  - enforce: the tamper reaction
+  - warn: run anyway, telling the payload with OB_DEP_MISMATCH=1
+  - degrade: run the decoy instead of the payload
+*/
+func obDependencyReact(obPolicy string) {
+	switch obPolicy {
+	// OB_FEATURE_BEGIN depwarn
+	case "warn":
+		obLauncherEnv = append(obLauncherEnv, "OB_DEP_MISMATCH=1")
 
-		obCombinedStdDev := obUtilCombinedStandardDeviationCalc(
-			obInstanceDep.obDepBFD,
-			obTargetBFD)
+		return
+	// OB_FEATURE_END depwarn
+	// OB_FEATURE_BEGIN decoy
+	case "degrade":
+		obAtomic.StoreInt32(&obDegradedFlag, 1)
 
-		// standard deviation should not be greater than 1
-		if obCombinedStdDev > obStdLevel {
-			obExit()
+		return
+		// OB_FEATURE_END decoy
+	}
+
+	obTamper(obCheckDependency)
+}
+
+/*
+Check if a dependency matches its registration, everything is checked
+on the descriptor opened, that is returned open if it matches, nil
+otherwise.
+*/
+func obDependencyMatch(obInstanceDep obDependency) (obFile *obOS.File, obMatch bool) {
+	// check if the file is a symbolic link
+	obLTargetStats, obErr := obSensitiveStat(obInstanceDep.obDepName, obAtSymlinkNoFollow)
+	if obErr != nil || (obLTargetStats.Mode&obSyscall.S_IFMT) == obSyscall.S_IFLNK {
+		return nil, false
+	}
+	// open dependency in current environment and check it's size
+	obFile, obErr = obSensitiveOpen(obInstanceDep.obDepName)
+	if obErr != nil {
+		return nil, false
+	}
+
+	defer func() {
+		if !obMatch {
+			obFile.Close()
+			obFile = nil
 		}
+	}()
+
+	// the path may have been replaced by a symbolic link since
+	obStatsFile, _ := obFile.Stat()
+	obTargetStats, _ := obStatsFile.Sys().(*obSyscall.Stat_t)
+
+	if obTargetStats == nil || obTargetStats.Dev != obLTargetStats.Dev || obTargetStats.Ino != obLTargetStats.Ino {
+		return obFile, false
+	}
+
+	obTargetDepSize, _ := obStrconv.ParseInt(obInstanceDep.obDepSize, 10, 64)
+
+	switch obInstanceDep.obDepMode {
+	case "exists":
+		return obFile, true
+	case "size":
+		return obFile, obStatsFile.Size() == obTargetDepSize
+	case "digest":
+		obContent, obErr := obReadAll(obFile)
+		obHash := obSHA256.Sum256(obContent)
+
+		return obFile, obErr == nil && obHex.EncodeToString(obHash[:]) == obUnsafeString(obInstanceDep.obDepDigest)
+	}
+
+	obTargetTreshold := (obTargetDepSize / 100) * obFileSizeLevel
+	// first check if file size is +/- 15% of registered size
+	if (obStatsFile.Size()-obTargetDepSize) < (-1*(obTargetTreshold)) ||
+		(obStatsFile.Size()-obTargetDepSize) > obTargetTreshold {
+		return obFile, false
+	}
+
+	// Calculate BFD (byte frequency distribution) of target file
+	// and calculate standard deviation from registered fingerprint.
+	obTargetBFD := obUtilBFDCalc(obFile)
+
+	// Calculate covariance of the 2 dataset
+	obCovariance := obUtilCovarianceCalc(obInstanceDep.obDepBFD, obTargetBFD)
+	// calculate the correlation index of  Bravais-Pearson to see if the
+	// two dataset are linearly correlated
+	obDepStdDev := obUtilStandardDeviationCalc(obInstanceDep.obDepBFD)
+	obTargetStdDev := obUtilStandardDeviationCalc(obTargetBFD)
+	obCorrelation := obCovariance / (obDepStdDev * obTargetStdDev)
+
+	if obCorrelation < obCorrelationLevel {
+		// not correlated, different nature
+		return obFile, false
 	}
+
+	obCombinedStdDev := obUtilCombinedStandardDeviationCalc(
+		obInstanceDep.obDepBFD,
+		obTargetBFD)
+
+	// standard deviation should not be greater than 1
+	return obFile, obCombinedStdDev <= obStdLevel
+}
+
+/*
+Toolchain compatibility shim: io/ioutil is deprecated since go 1.16 but
+its replacements in io and os are missing before, and the launcher is
+built by go 1.13 onwards.
+*/
+func obReadAll(obReader obIO.Reader) ([]byte, error) {
+	return obUtilio.ReadAll(obReader)
+}
+
+func obReadFile(obName string) ([]byte, error) {
+	return obUtilio.ReadFile(obName)
+}
+
+func obWriteFile(obName string, obContent []byte) error {
+	return obUtilio.WriteFile(obName, obContent, 0644)
 }
 
 /*
@@ -408,6 +862,93 @@ func obReverseByteArray(obInput []byte) []byte {
 	return obResult
 }
 
+// Zero a buffer once it's content is not needed anymore.
+func obWipe(obInput []byte) {
+	for obIndex := range obInput {
+		obInput[obIndex] = 0
+	}
+}
+
+/*
+Decode a sensitive secret appending it to obBuffer.
+The obfuscator replaces each call with a function filling the buffer
+byte by byte, so the secret never exists as a Go string: wipe the
+buffer as soon as it is not needed.
+*/
+func obSensitive(obBuffer []byte, obValue string) []byte {
+	return append(obBuffer[:0], obValue...)
+}
+
+/*
+View a buffer as a string without copying it, the string is
+wiped together with the buffer.
+*/
+func obUnsafeString(obInput []byte) string {
+	return *(*string)(obUnsafe.Pointer(&obInput))
+}
+
+/*
+Call obFunction with a NUL terminated copy of a sensitive path,
+wiped after the call: the syscall package would instead leave
+a copy of the path in the heap at each call.
+*/
+func obWithPath(obPath []byte, obFunction func(obCPath uintptr)) {
+	obCPath := make([]byte, len(obPath)+1)
+	copy(obCPath, obPath)
+	obFunction(uintptr(obUnsafe.Pointer(&obCPath[0])))
+	obWipe(obCPath)
+}
+
+// Open read-only a sensitive path.
+func obSensitiveOpen(obPath []byte) (*obOS.File, error) {
+	var obFileDescriptor uintptr
+
+	var obErr obSyscall.Errno
+
+	obAtFDCWD := -100
+
+	obWithPath(obPath, func(obCPath uintptr) {
+		obFileDescriptor, _, obErr = obSyscall.Syscall6(obSyscall.SYS_OPENAT,
+			uintptr(obAtFDCWD), obCPath,
+			uintptr(obSyscall.O_RDONLY|obSyscall.O_CLOEXEC), 0, 0, 0)
+	})
+
+	if obErr != obSyscall.Errno(0) {
+		return nil, obErr
+	}
+
+	return obOS.NewFile(obFileDescriptor, ""), nil
+}
+
+// Stat a sensitive path, obFlags are the fstatat ones.
+func obSensitiveStat(obPath []byte, obFlags uintptr) (obSyscall.Stat_t, error) {
+	var obStat obSyscall.Stat_t
+
+	var obErr obSyscall.Errno
+
+	obAtFDCWD := -100
+
+	obWithPath(obPath, func(obCPath uintptr) {
+		_, _, obErr = obSyscall.Syscall6(obSysFstatat,
+			uintptr(obAtFDCWD), obCPath,
+			uintptr(obUnsafe.Pointer(&obStat)), obFlags, 0, 0)
+	})
+
+	if obErr != obSyscall.Errno(0) {
+		return obStat, obErr
+	}
+
+	return obStat, nil
+}
+
+// Parse and wipe a sensitive decimal number.
+func obSensitiveInt(obInput []byte) int64 {
+	obResult, _ := obStrconv.ParseInt(obUnsafeString(obInput), 10, 64)
+	obWipe(obInput)
+
+	return obResult
+}
+
 // Change byte endianess
 func obByteReverse(obBar byte) byte {
 	var obFoo byte
@@ -427,207 +968,3040 @@ const (
 	obAllowSealing uint = 2
 	// memfd is now immutable
 	obSealAll = 0x0001 | 0x0002 | 0x0004 | 0x0008
-	// amd64 specific
-	obSysFCNTL       = obSyscall.SYS_FCNTL
-	obSysMEMFDCreate = 319
+	// fcntl commands to add and read seals
+	obAddSeals = 1024 + 9
+	obGetSeals = 1024 + 10
+	obSysFCNTL = obSyscall.SYS_FCNTL
+	// do not follow symbolic links in fstatat
+	obAtSymlinkNoFollow = 0x100
 )
 
-func obLauncher() {
-	// OB_CHECK
-	obNameFile, _ := obOS.Executable()
+var obSysMEMFDCreate, obSysFstatat = obSyscallTable()
 
-	obFile, _ := obOS.Open(obNameFile)
-	defer obFile.Close()
+/*
+Raw syscall numbers of memfd_create and fstatat, fstatat64 on 32 bit,
+missing from the syscall package of some architectures.
+The packer keeps only the table of the target one.
+*/
+func obSyscallTable() (obMEMFDCreate uintptr, obFstatat uintptr) {
+	obMEMFDCreate, obFstatat = 356, 300 // OB_FEATURE 386
+	obMEMFDCreate, obFstatat = 319, 262 // OB_FEATURE amd64
+	obMEMFDCreate, obFstatat = 385, 327 // OB_FEATURE arm
+	obMEMFDCreate, obFstatat = 279, 79  // OB_FEATURE arm64|riscv64
 
-	// OB_CHECK
-	obOffset, _ := obStrconv.ParseInt("9999999", 10, 64)
-	obStatsFile, _ := obFile.Stat()
+	return obMEMFDCreate, obFstatat
+}
 
-	// calculate final padding
-	obArrayFinalPadding := make([]byte, obBinary.MaxVarintLen64)
-	obByteFinalPadding := obArrayFinalPadding[:obBinary.PutVarint(obArrayFinalPadding, obOffset)]
+// OB_FEATURE_BEGIN antidump
+const (
+	// exclude a mapping from core dumps
+	obMadvDontDump = 16
+	// prctl option to toggle the dumpable flag
+	obSetDumpable = 4
+)
 
-	for obIndex := range obByteFinalPadding {
-		obByteFinalPadding[obIndex] = obByteReverse(obByteFinalPadding[obIndex])
+/*
+Mark the process as not dumpable, this will disable core dumps and
+make /proc/self/{fd,mem,maps} owned by root, so that a process running
+with the same uid cannot simply read them.
+*/
+func obDisableDump() {
+	// OB_SYSCALLS prctl
+	_, _, obErr := obSyscall.RawSyscall(obSyscall.SYS_PRCTL,
+		uintptr(obSetDumpable),
+		0,
+		0)
+	if obErr != obSyscall.Errno(0) {
+		obExit()
 	}
+}
 
-	obFinalPadding, _ := obBinary.Varint(obByteFinalPadding)
-
-	// make it positive!
-	if obFinalPadding < 0 {
-		obFinalPadding *= -1
+/*
+Allocate an anonymous mapping that is excluded from core dumps,
+the go heap is not page aligned so madvise cannot be used on it.
+*/
+func obNoDumpAlloc(obSize int) []byte {
+	if obSize == 0 {
+		return []byte{}
 	}
-	// read the complete executable
-	obKey := make([]byte, obOffset)
 
-	_, obErr := obFile.Read(obKey)
+	obBuffer, obErr := obSyscall.Mmap(-1, 0, obSize,
+		obSyscall.PROT_READ|obSyscall.PROT_WRITE,
+		obSyscall.MAP_ANON|obSyscall.MAP_PRIVATE)
 	if obErr != nil {
 		obExit()
 	}
 
-	// OB_CHECK
-	obSizeFile := obStatsFile.Size() - obOffset
-
-	// OB_CHECK
-	_, obErr = obFile.Seek(obOffset, 0)
+	obErr = obSyscall.Madvise(obBuffer, obMadvDontDump)
 	if obErr != nil {
 		obExit()
 	}
 
-	obCiphertext := make([]byte, obSizeFile)
+	return obBuffer
+}
 
-	// OB_CHECK
-	_, obErr = obFile.Read(obCiphertext)
-	if obErr != nil {
-		obExit()
-	}
+/*
+Move the input in a buffer excluded from core dumps and
+wipe the original one.
+*/
+func obNoDumpMove(obInput []byte) []byte {
+	obBuffer := obNoDumpAlloc(len(obInput))
+	copy(obBuffer, obInput)
+	obWipe(obInput)
 
-	obCiphertext = obCiphertext[:int64(len(obCiphertext))-obFinalPadding]
-	// OB_CHECK
-	// the payload was reversed!
-	obCiphertext = obReverseByteArray(obCiphertext)
+	return obBuffer
+}
 
-	// OB_CHECK
-	// restore endianess
-	for obIndex := range obCiphertext {
-		obCiphertext[obIndex] = obByteReverse(obCiphertext[obIndex])
+/*
+Ensure the memfd has all the seals in place: no write, no shrink,
+no grow and no further seal change.
+*/
+func obVerifySeals(obFileDescriptor uintptr) {
+	obSeals, _, obErr := obSyscall.Syscall(obSysFCNTL,
+		obFileDescriptor,
+		uintptr(obGetSeals),
+		0)
+	if obErr != obSyscall.Errno(0) || obSeals&obSealAll != obSealAll {
+		obTamper(obCheckSeals)
+	}
+}
+
+// OB_FEATURE_END antidump
+
+// OB_FEATURE_BEGIN arming
+// time to wait before checking again the arming conditions
+const obArmingRetry = 60 * obTime.Second
+
+/*
+Check if all the arming conditions are met at the given time,
+all the times are UTC.
+*/
+func obArmed(obNow obTime.Time) bool {
+	obNow = obNow.UTC()
+	// OB_FEATURE_BEGIN armafter
+	obArmAfter := obSensitiveInt(obSensitive(nil, "ARMAFTER"))
+	if obNow.Unix() < obArmAfter {
+		return false
+	}
+	// OB_FEATURE_END armafter
+	// OB_FEATURE_BEGIN runwindow
+	obWindowStart := obSensitiveInt(obSensitive(nil, "WINDOWSTART"))
+	obWindowEnd := obSensitiveInt(obSensitive(nil, "WINDOWEND"))
+	obMinute := int64(obNow.Hour()*60 + obNow.Minute())
+
+	if obWindowStart < obWindowEnd {
+		if obMinute < obWindowStart || obMinute >= obWindowEnd {
+			return false
+		}
+	} else if obMinute < obWindowStart && obMinute >= obWindowEnd {
+		// window wraps around midnight
+		return false
+	}
+	// OB_FEATURE_END runwindow
+	// OB_FEATURE_BEGIN triggerfile
+	obTriggerFile := obSensitive(nil, "TRIGGERFILE")
+	_, obErr := obSensitiveStat(obTriggerFile, 0)
+	obWipe(obTriggerFile)
+
+	if obErr != nil {
+		return false
+	}
+	// OB_FEATURE_END triggerfile
+	return true
+}
+
+/*
+Do not proceed until the launcher is armed, exit quietly
+or wait if requested.
+*/
+func obArming() {
+	for !obArmed(obTime.Now()) {
+		// OB_FEATURE_BEGIN !waitforarming
+		obOS.Exit(OK)
+		// OB_FEATURE_END !waitforarming
+		// OB_FEATURE_BEGIN waitforarming
+		obTime.Sleep(obArmingRetry)
+		// OB_FEATURE_END waitforarming
+	}
+}
+
+// OB_FEATURE_END arming
+// OB_FEATURE_BEGIN hostlist
+/*
+Match a hostname against a glob pattern, where * is any sequence of
+characters and ? any single one. Both are lowercase.
+*/
+func obGlobMatch(obPattern string, obName string) bool {
+	obPatternIndex, obNameIndex := 0, 0
+	// where to resume after the last star, matching one more character
+	obStarIndex, obStarName := -1, 0
+
+	for obNameIndex < len(obName) {
+		switch {
+		case obPatternIndex < len(obPattern) && obPattern[obPatternIndex:obPatternIndex+1] == "*":
+			obStarIndex, obStarName = obPatternIndex, obNameIndex
+			obPatternIndex++
+		case obPatternIndex < len(obPattern) &&
+			(obPattern[obPatternIndex:obPatternIndex+1] == "?" ||
+				obPattern[obPatternIndex] == obName[obNameIndex]):
+			obPatternIndex++
+			obNameIndex++
+		case obStarIndex >= 0:
+			obStarName++
+			obPatternIndex, obNameIndex = obStarIndex+1, obStarName
+		default:
+			return false
+		}
+	}
+
+	for obPatternIndex < len(obPattern) && obPattern[obPatternIndex:obPatternIndex+1] == "*" {
+		obPatternIndex++
+	}
+
+	return obPatternIndex == len(obPattern)
+}
+
+// Return true if the hostname matches any of the comma separated patterns.
+func obHostMatches(obHostname string, obPatterns string) bool {
+	for _, obPattern := range obStrings.Split(obPatterns, ",") {
+		if obGlobMatch(obPattern, obHostname) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+Check the hostname against the lists given at packing time: a host
+matching the deny list, or not matching the allow list if there is
+one, is a tampering.
+*/
+func obHostCheck() {
+	obHostname, _ := obOS.Hostname()
+	obHostname = obStrings.ToLower(obHostname)
+	// OB_FEATURE_BEGIN hostdeny
+	if obHostMatches(obHostname, "HOSTDENY") {
+		obDebugf("hostname: %q is denied\n", obHostname) // OB_FEATURE launcherdebug
+		obTamper(obCheckHostname)
+	}
+	// OB_FEATURE_END hostdeny
+	// OB_FEATURE_BEGIN hostallow
+	if !obHostMatches(obHostname, "HOSTALLOW") {
+		obDebugf("hostname: %q is not allowed\n", obHostname) // OB_FEATURE launcherdebug
+		obTamper(obCheckHostname)
+	}
+	// OB_FEATURE_END hostallow
+}
+
+// OB_FEATURE_END hostlist
+// OB_FEATURE_BEGIN registration
+// The public key of the registration records.
+func obRegistrationKey() *obRSA.PublicKey {
+	obModulus := obSensitive(nil, "REGISTRATIONKEY")
+	obKey := &obRSA.PublicKey{
+		N: new(obBig.Int).SetBytes(obModulus),
+		E: int(obSensitiveInt(obSensitive(nil, "REGISTRATIONEXP"))),
+	}
+	obWipe(obModulus)
+
+	return obKey
+}
+
+// The verifier of the machine id, see pakkero.Registration.
+func obRegistrationVerifier(obKey *obRSA.PublicKey, obMachineID []byte) []byte {
+	obHMACKey := obSHA256.Sum256(obKey.N.Bytes())
+	obMAC := obHMAC.New(obSHA256.New, obHMACKey[:])
+	obMAC.Write(obMachineID)
+
+	return obMAC.Sum(nil)
+}
+
+/*
+The record adopting this host, see pakkero.Registration: the verifier
+of the machine id and the claims encrypted with the public key, so
+that only the owner of the private key can read them.
+*/
+func obRegistrationRecord(obKey *obRSA.PublicKey, obMachineID []byte) ([]byte, error) {
+	obHostname, _ := obOS.Hostname()
+	if len(obHostname) > 64 {
+		obHostname = obHostname[:64]
+	}
+
+	obMachineIDHash := obSHA256.Sum256(obMachineID)
+	obClaims := make([]byte, 16)
+	obBinary.BigEndian.PutUint64(obClaims, uint64(obSensitiveInt(obSensitive(nil, "REGISTRATIONID"))))
+	obBinary.BigEndian.PutUint64(obClaims[8:], uint64(obTime.Now().Unix()))
+	obClaims = append(obClaims, obMachineIDHash[:]...)
+	obClaims = append(obClaims, obHostname...)
+
+	obSealed, obErr := obRSA.EncryptOAEP(obSHA256.New(), obRand.Reader, obKey, obClaims, nil)
+	if obErr != nil {
+		return nil, obErr
+	}
+
+	obRecord := append([]byte("PKR1"), obRegistrationVerifier(obKey, obMachineID)...)
+
+	return append(obRecord, obSealed...), nil
+}
+
+/*
+Open the registration record, creating it with pinfirsthost: read-only
+if it cannot be written, like on a read-only filesystem.
+*/
+func obRegistrationOpen() (*obOS.File, error) {
+	var obFileDescriptor uintptr
+
+	var obErr obSyscall.Errno
+
+	obAtFDCWD := -100
+	obPath := obSensitive(nil, "REGISTRATIONFILE")
+
+	obFlags := []int{obSyscall.O_RDONLY | obSyscall.O_CLOEXEC}
+	// OB_FEATURE_BEGIN pinfirsthost
+	// a health check never creates the record
+	if !obHealthChild {
+		obFlags = append([]int{obSyscall.O_RDWR | obSyscall.O_CREAT | obSyscall.O_CLOEXEC}, obFlags...)
+	}
+	// OB_FEATURE_END pinfirsthost
+
+	for _, obFlag := range obFlags {
+		obWithPath(obPath, func(obCPath uintptr) {
+			obFileDescriptor, _, obErr = obSyscall.Syscall6(obSyscall.SYS_OPENAT,
+				uintptr(obAtFDCWD), obCPath, uintptr(obFlag), 0600, 0, 0)
+		})
+
+		if obErr == obSyscall.Errno(0) {
+			break
+		}
+	}
+
+	obWipe(obPath)
+
+	if obErr != obSyscall.Errno(0) {
+		return nil, obErr
+	}
+
+	return obOS.NewFile(obFileDescriptor, ""), nil
+}
+
+// React to a host the launcher is not registered to.
+func obRegistrationMismatch() {
+	// OB_FEATURE_BEGIN registerwarn
+	obLauncherEnv = append(obLauncherEnv, "OB_HOST_MISMATCH=1")
+	// OB_FEATURE_END registerwarn
+	// OB_FEATURE_BEGIN !registerwarn
+	obTamper(obCheckRegistration)
+	// OB_FEATURE_END !registerwarn
+}
+
+/*
+Check the registration record matches the machine id of this host.
+With pinfirsthost a missing or empty record adopts this host: the
+record is locked while checked, so concurrent first runs write it
+once, and the others check it.
+*/
+func obRegistrationCheck() {
+	obFile, obErr := obRegistrationOpen()
+	// OB_FEATURE_BEGIN pinfirsthost
+	// a missing record would adopt this host
+	if obHealthChild && obErr == obSyscall.ENOENT {
+		return
+	}
+	// OB_FEATURE_END pinfirsthost
+	if obErr != nil {
+		obDebugf("registration: cannot open the record: %v\n", obErr) // OB_FEATURE launcherdebug
+		obRegistrationMismatch()
+
+		return
+	}
+	defer obFile.Close()
+
+	// OB_SYSCALLS flock
+	obSyscall.Flock(int(obFile.Fd()), obSyscall.LOCK_EX)
+
+	obContent, _ := obReadAll(obFile)
+	obMachineID, _ := obReadFile("/etc/machine-id")
+	obMachineID = obBytes.TrimSpace(obMachineID)
+	obKey := obRegistrationKey()
+
+	// OB_FEATURE_BEGIN pinfirsthost
+	if len(obContent) == 0 && obHealthChild {
+		return
+	}
+
+	if len(obContent) == 0 {
+		obRecord, obErr := obRegistrationRecord(obKey, obMachineID)
+		if obErr == nil {
+			_, obErr = obFile.Write(obRecord)
+		}
+
+		if obErr == nil {
+			obErr = obFile.Sync()
+		}
+
+		if obErr != nil {
+			obDebugf("registration: cannot adopt this host: %v\n", obErr) // OB_FEATURE launcherdebug
+			// a partial record would lock this host out
+			obFile.Truncate(0)
+			obRegistrationMismatch()
+		}
+
+		return
+	}
+	// OB_FEATURE_END pinfirsthost
+
+	obHeader := len("PKR1") + obSHA256.Size
+	if len(obContent) < obHeader || string(obContent[:len("PKR1")]) != "PKR1" ||
+		!obHMAC.Equal(obContent[len("PKR1"):obHeader], obRegistrationVerifier(obKey, obMachineID)) {
+		obDebugf("registration: the record is not of this host\n") // OB_FEATURE launcherdebug
+		obRegistrationMismatch()
+	}
+}
+
+// OB_FEATURE_END registration
+// OB_FEATURE_BEGIN license
+/*
+Check the license file against the public keys given at packing time,
+one after the other, any of them can sign it: its signature, its
+expiry and the machine it is bound to, if any.
+The layout is the one of pakkero.License.
+*/
+func obLicenseCheck() {
+	obPath := obSensitive(nil, "LICENSEFILE")
+	obFile, obErr := obSensitiveOpen(obPath)
+	obWipe(obPath)
+
+	if obErr != nil {
+		obDebugf("license: cannot open the license file: %v\n", obErr) // OB_FEATURE launcherdebug
+		obTamper(obCheckLicense)
+	}
+
+	obContent, _ := obReadAll(obFile)
+	obFile.Close()
+
+	// OB_CHECK
+	obHeader := len("PKL1") + 8 + 1
+	if len(obContent) < obHeader+obEd25519.SignatureSize ||
+		string(obContent[:len("PKL1")]) != "PKL1" {
+		obDebugf("license: not a license file\n") // OB_FEATURE launcherdebug
+		obTamper(obCheckLicense)
+	}
+
+	obSize := obHeader + int(obContent[obHeader-1])
+	if len(obContent) != obSize+obEd25519.SignatureSize {
+		obDebugf("license: not a license file\n") // OB_FEATURE launcherdebug
+		obTamper(obCheckLicense)
+	}
+
+	// OB_CHECK
+	obKeys := obSensitive(nil, "LICENSEPUBKEY")
+	obValid := false
+
+	for obSlot := 0; !obValid && obSlot+obEd25519.PublicKeySize <= len(obKeys); obSlot += obEd25519.PublicKeySize {
+		obValid = obEd25519.Verify(obEd25519.PublicKey(obKeys[obSlot:obSlot+obEd25519.PublicKeySize]),
+			obContent[:obSize], obContent[obSize:])
+		// OB_FEATURE_BEGIN launcherdebug
+		if obValid {
+			obDebugf("license: signed by key %d\n", obSlot/obEd25519.PublicKeySize+1)
+		}
+		// OB_FEATURE_END launcherdebug
+	}
+
+	obWipe(obKeys)
+
+	if !obValid {
+		obDebugf("license: invalid signature\n") // OB_FEATURE launcherdebug
+		obTamper(obCheckLicense)
+	}
+
+	// OB_CHECK
+	obExpiry := int64(obBinary.BigEndian.Uint64(obContent[len("PKL1"):]))
+	if obExpiry != 0 && obTime.Now().Unix() >= obExpiry {
+		obDebugf("license: expired\n") // OB_FEATURE launcherdebug
+		obTamper(obCheckLicense)
+	}
+
+	// OB_CHECK
+	if obSize > obHeader {
+		obMachineID, _ := obReadFile("/etc/machine-id")
+		if obStrings.TrimSpace(string(obMachineID)) != string(obContent[obHeader:obSize]) {
+			obDebugf("license: bound to another machine\n") // OB_FEATURE launcherdebug
+			obTamper(obCheckLicense)
+		}
+	}
+}
+
+// OB_FEATURE_END license
+// OB_FEATURE_BEGIN override
+// the checks an override token can relax, see pakkero.OverrideChecks
+const obRelaxableChecks = 1<<obCheckEnvArgs | 1<<obCheckEnvParent | 1<<obCheckEnv | 1<<obCheckLdPreload |
+	1<<obCheckParentCmdLine | 1<<obCheckParentTracer | 1<<obCheckParentStat |
+	1<<obCheckHostname | 1<<obCheckDependency
+
+// the longest an override token can be valid, and the clock skew tolerated
+const (
+	obOverrideMaxTTL = 7 * 24 * 3600
+	obOverrideSkew   = 5 * 60
+)
+
+// checks relaxed by the override token, a bit per check index
+var obRelaxedChecks uint32
+
+// the payload is told once that a check was relaxed
+var obRelaxedOnce obSync.Once
+
+/*
+Load the override token, if any: signed by the override key, valid for
+at most obOverrideMaxTTL and not expired. Anything else is ignored, as
+a missing token, and every check is enforced.
+*/
+func obLoadOverride() {
+	obPath := obSensitive(nil, "OVERRIDEFILE")
+	obFile, obErr := obSensitiveOpen(obPath)
+	obWipe(obPath)
+
+	if obErr != nil {
+		return
+	}
+
+	obContent, _ := obReadAll(obFile)
+	obFile.Close()
+
+	obSize := len("PKO1") + 8 + 8 + 4
+	if len(obContent) != obSize+obEd25519.SignatureSize || string(obContent[:len("PKO1")]) != "PKO1" {
+		obDebugf("override: not an override token, ignored\n") // OB_FEATURE launcherdebug
+		return
+	}
+
+	obKey := obSensitive(nil, "OVERRIDEPUBKEY")
+	obValid := obEd25519.Verify(obEd25519.PublicKey(obKey), obContent[:obSize], obContent[obSize:])
+	obWipe(obKey)
+
+	if !obValid {
+		obDebugf("override: invalid signature, ignored\n") // OB_FEATURE launcherdebug
+		return
+	}
+
+	obIssued := int64(obBinary.BigEndian.Uint64(obContent[len("PKO1"):]))
+	obExpiry := int64(obBinary.BigEndian.Uint64(obContent[len("PKO1")+8:]))
+	obNow := obTime.Now().Unix()
+
+	if obExpiry-obIssued > obOverrideMaxTTL || obNow+obOverrideSkew < obIssued || obNow >= obExpiry {
+		obDebugf("override: not valid now, ignored\n") // OB_FEATURE launcherdebug
+		return
+	}
+
+	obRelaxedChecks = obBinary.BigEndian.Uint32(obContent[len("PKO1")+16:]) & obRelaxableChecks
+	obDebugf("override: relaxing the checks %b until %d\n", obRelaxedChecks, obExpiry) // OB_FEATURE launcherdebug
+}
+
+/*
+A relaxed check failed: report it with the negative of its index and
+tell the payload with OB_CHECKS_RELAXED=1, instead of the tamper
+reaction.
+*/
+func obRelax(obCheck int) {
+	obDebugf("override: check %d failed, relaxed\n", obCheck) // OB_FEATURE launcherdebug
+	obAttest(-obCheck)                                        // OB_FEATURE attest
+	obRelaxedOnce.Do(func() {
+		obLauncherEnv = append(obLauncherEnv, "OB_CHECKS_RELAXED=1")
+	})
+}
+
+// OB_FEATURE_END override
+// OB_FEATURE_BEGIN health
+// results of a row of the health check matrix
+const (
+	obHealthPassed = iota
+	obHealthFailed
+	obHealthSkipped
+)
+
+// rows of the health check matrix, see pakkero.HealthChecks
+const (
+	obHealthDependency = iota + 1
+	obHealthHostname
+	obHealthRegistration
+	obHealthLicense
+	obHealthArming
+	obHealthInterpreter
+	obHealthMemfd
+)
+
+/*
+Run the health check if the variable named at packing time is set,
+then exit; return if it is not set. As the checks exit on failure, each
+one runs in a child, with the variable set to its name and row: the parent
+prints a line per row, with its index and result, and exits 0 only if
+none failed. The payload is never read nor decrypted.
+*/
+func obHealthCheck() {
+	obName := obSensitive(nil, "HEALTHVAR")
+	obVariable := string(obName)
+	obWipe(obName)
+
+	obValue, obSet := obOS.LookupEnv(obVariable)
+	if !obSet {
+		return
+	}
+
+	if obStrings.HasPrefix(obValue, obVariable) {
+		obRow, _ := obStrconv.Atoi(obValue[len(obVariable):])
+		obHealthChild = true
+		obOS.Exit(obHealthRun(obRow))
+	}
+
+	obExecutable, _ := obOS.Executable()
+	obStatus := OK
+
+	for obRow := obHealthDependency; obRow <= obHealthMemfd; obRow++ {
+		obCommand := obExec.Command(obExecutable)
+		obCommand.Env = append(obOS.Environ(), obVariable+"="+obVariable+obStrconv.Itoa(obRow))
+
+		obResult := obHealthPassed
+
+		obErr := obCommand.Run()
+		if obErr != nil {
+			obResult = obHealthFailed
+
+			var obExitErr *obExec.ExitError
+			if obErrors.As(obErr, &obExitErr) && obExitErr.ExitCode() == obHealthSkipped {
+				obResult = obHealthSkipped
+			}
+		}
+
+		if obResult == obHealthFailed {
+			obStatus = ERR
+		}
+
+		obOS.Stdout.WriteString(obStrconv.Itoa(obRow) + " " + obStrconv.Itoa(obResult) + "\n")
+	}
+
+	obOS.Exit(obStatus)
+}
+
+// Run a row of the health check, a failed check exits on its own.
+func obHealthRun(obRow int) int {
+	switch obRow {
+	case obHealthDependency:
+		obDependencyCheck()
+	// OB_FEATURE_BEGIN hostlist
+	case obHealthHostname:
+		obHostCheck()
+	// OB_FEATURE_END hostlist
+	// OB_FEATURE_BEGIN registration
+	case obHealthRegistration:
+		obRegistrationCheck()
+	// OB_FEATURE_END registration
+	// OB_FEATURE_BEGIN license
+	case obHealthLicense:
+		obLicenseCheck()
+	// OB_FEATURE_END license
+	// OB_FEATURE_BEGIN arming
+	case obHealthArming:
+		if !obArmed(obTime.Now()) {
+			return obHealthFailed
+		}
+	// OB_FEATURE_END arming
+	case obHealthInterpreter:
+		obPath := obSensitive(nil, "HEALTHINTERP")
+		defer obWipe(obPath)
+
+		if len(obPath) > 0 {
+			_, obErr := obSensitiveStat(obPath, 0)
+			if obErr != nil {
+				return obHealthFailed
+			}
+		}
+	case obHealthMemfd:
+		obFDName := ""
+		obFileDescriptor, _, obErrno := obSyscall.Syscall(obSysMEMFDCreate,
+			uintptr(obUnsafe.Pointer(&obFDName)),
+			uintptr(obCloexec|obAllowSealing), 0)
+		if obErrno != obSyscall.Errno(0) {
+			return obHealthFailed
+		}
+
+		obSyscall.Close(int(obFileDescriptor))
+	default:
+		return obHealthSkipped
+	}
+
+	return obHealthPassed
+}
+
+// OB_FEATURE_END health
+// OB_FEATURE_BEGIN decoy
+// set when a dependency mismatch asks to run the decoy
+var obDegradedFlag int32
+
+func obDegraded() bool {
+	return obAtomic.LoadInt32(&obDegradedFlag) == 1
+}
+
+// OB_FEATURE_END decoy
+// OB_FEATURE_BEGIN bundlelibs
+// private directory of the bundled libraries, removed once the payload exits
+var obLibsDir string
+
+func obMkdirTemp() (string, error) {
+	return obUtilio.TempDir("", "")
+}
+
+/*
+Decrypt the bundled libraries and write them in a private directory,
+each library was archived as:
+
+	name \0 | size, 8 bytes big endian | content
+*/
+func obExtractLibs(obFile *obOS.File) {
+	obLibsStart := obSensitiveInt(obSensitive(nil, "LIBSSTART"))
+	obLibsSize := obSensitiveInt(obSensitive(nil, "LIBSSIZE"))
+	obArchive := obDecrypt(obFile, obLibsStart, obLibsStart, obLibsSize, false)
+
+	// OB_CHECK
+	obDir, obErr := obMkdirTemp()
+	if obErr != nil {
+		obDebugf("libs: cannot create the directory: %v\n", obErr) // OB_FEATURE launcherdebug
+		obOS.Exit(obExitExtract)
+	}
+
+	obLibsDir = obDir
+
+	for len(obArchive) > 0 {
+		obEnd := obBytes.IndexByte(obArchive, 0)
+		if obEnd < 0 || len(obArchive) < obEnd+9 {
+			obDebugf("libs: truncated archive\n") // OB_FEATURE launcherdebug
+			obOS.RemoveAll(obLibsDir)
+			obExit()
+		}
+
+		obName := string(obArchive[:obEnd])
+		obSize := obBinary.BigEndian.Uint64(obArchive[obEnd+1 : obEnd+9])
+		obArchive = obArchive[obEnd+9:]
+
+		if obSize > uint64(len(obArchive)) {
+			obDebugf("libs: truncated archive\n") // OB_FEATURE launcherdebug
+			obOS.RemoveAll(obLibsDir)
+			obExit()
+		}
+
+		// OB_CHECK
+		obErr = obWriteFile(obLibsDir+"/"+obName, obArchive[:obSize])
+		if obErr != nil {
+			obDebugf("libs: cannot write %s: %v\n", obName, obErr) // OB_FEATURE launcherdebug
+			obOS.RemoveAll(obLibsDir)
+			obOS.Exit(obExitExtract)
+		}
+
+		obDebugf("libs: extracted %s\n", obName) // OB_FEATURE launcherdebug
+		obArchive = obArchive[obSize:]
+	}
+}
+
+/*
+Put the directory of the bundled libraries first in LD_LIBRARY_PATH,
+keeping the value the environment policy let through, if any.
+*/
+func obBundleLibsEnviron(obEnviron []string) []string {
+	obPath := obLibsDir
+	obResult := []string{}
+
+	for _, obVariable := range obEnviron {
+		if obStrings.HasPrefix(obVariable, "LD_LIBRARY_PATH=") {
+			if obValue := obVariable[len("LD_LIBRARY_PATH="):]; obValue != "" {
+				obPath = obLibsDir + ":" + obValue
+			}
+
+			continue
+		}
+
+		obResult = append(obResult, obVariable)
+	}
+
+	return append(obResult, "LD_LIBRARY_PATH="+obPath)
+}
+
+// OB_FEATURE_END bundlelibs
+// OB_FEATURE_BEGIN whiten
+/*
+Undo the whitening of the payload: XOR it with the AES-256-CTR keystream
+keyed by the ranges of the launcher file before it, the offset and the
+salt chosen at packing time, see pakkero.RegisterWhitening.
+*/
+func obUnwhiten(obPrefix []byte, obData []byte) {
+	obRanges := obSensitive(nil, "WHITENRANGES")
+	obSeed := []byte{}
+
+	for _, obRange := range obBytes.Split(obRanges, []byte(",")) {
+		obBounds := obBytes.Split(obRange, []byte(":"))
+		obStart, _ := obStrconv.ParseInt(obUnsafeString(obBounds[0]), 10, 64)
+		obLength, _ := obStrconv.ParseInt(obUnsafeString(obBounds[1]), 10, 64)
+
+		if obStart < 0 || obLength < 0 || obStart+obLength > int64(len(obPrefix)) {
+			obDebugf("whiten: range %d:%d past the offset\n", obStart, obLength) // OB_FEATURE launcherdebug
+			obTamper(obCheckDecrypt)
+		}
+
+		obSeed = append(obSeed, obPrefix[obStart:obStart+obLength]...)
+	}
+
+	obWipe(obRanges)
+
+	obSalt := obSensitive(nil, "WHITENSALT")
+	obSeed = obStrconv.AppendInt(obSeed, int64(len(obPrefix)), 10)
+	obSeed = append(obSeed, ":"...)
+	obSeed = append(obSeed, obSalt...)
+	obWipe(obSalt)
+
+	obKey := obSHA.Sum512_256(obSeed)
+	obWipe(obSeed)
+
+	obBlock, _ := obAES.NewCipher(obKey[:])
+	obCipher.NewCTR(obBlock, make([]byte, obBlock.BlockSize())).XORKeyStream(obData, obData)
+	obWipe(obKey[:])
+}
+
+// OB_FEATURE_END whiten
+// OB_FEATURE_BEGIN iohints
+const (
+	// posix_fadvise advices
+	obFadvSequential = 2
+	obFadvWillNeed   = 3
+	// back a mapping with transparent huge pages
+	obMadvHugePage = 14
+	// the least buffer worth huge pages, two of them
+	obHugePageMin = 4 << 20
+)
+
+var obSysFadvise = obFadviseNumber()
+
+/*
+Raw syscall number of fadvise64, fadvise64_64 on 32 bit, the packer
+keeps only the one of the target architecture.
+*/
+func obFadviseNumber() (obNumber uintptr) {
+	obNumber = 272 // OB_FEATURE 386
+	obNumber = 221 // OB_FEATURE amd64
+	obNumber = 270 // OB_FEATURE arm
+	obNumber = 223 // OB_FEATURE arm64|riscv64
+
+	return obNumber
+}
+
+/*
+Advise the kernel of how a range of a file will be read, the hints are
+opportunistic and their errors ignored. The 32 bit kernels take the
+offset and the size split in two registers each, arm after the advice.
+*/
+func obFadvise(obDescriptor uintptr, obStart int64, obSize int64, obAdvice uintptr) {
+	// OB_SYSCALLS fadvise64
+	// OB_FEATURE_BEGIN 386
+	obSyscall.Syscall6(obSysFadvise, obDescriptor, uintptr(obStart), uintptr(obStart>>32),
+		uintptr(obSize), uintptr(obSize>>32), obAdvice)
+	// OB_FEATURE_END 386
+	// OB_FEATURE_BEGIN amd64|arm64|riscv64
+	obSyscall.Syscall6(obSysFadvise, obDescriptor, uintptr(obStart), uintptr(obSize), obAdvice, 0, 0)
+	// OB_FEATURE_END amd64|arm64|riscv64
+	// OB_FEATURE_BEGIN arm
+	obSyscall.Syscall6(obSysFadvise, obDescriptor, obAdvice, uintptr(obStart), uintptr(obStart>>32),
+		uintptr(obSize), uintptr(obSize>>32))
+	// OB_FEATURE_END arm
+}
+
+/*
+Read a range of the launcher file in the buffer as the I/O profile
+says: advised as sequential, then read in chunks of IOCHUNK bytes,
+prefetched IOWINDOW chunks ahead, or all of it at once if 0.
+*/
+func obReadHinted(obFile *obOS.File, obBuffer []byte, obStart int64) error {
+	obChunk := obSensitiveInt(obSensitive(nil, "IOCHUNK"))
+	obWindow := obSensitiveInt(obSensitive(nil, "IOWINDOW"))
+	obSize := int64(len(obBuffer))
+	obDescriptor := obFile.Fd()
+
+	obFadvise(obDescriptor, obStart, obSize, obFadvSequential)
+
+	if obWindow == 0 {
+		obFadvise(obDescriptor, obStart, obSize, obFadvWillNeed)
+	} else {
+		obFadvise(obDescriptor, obStart, obChunk*obWindow, obFadvWillNeed)
+	}
+
+	for obDone := int64(0); obDone < obSize; obDone += obChunk {
+		// the chunk entering the window
+		if obWindow > 0 {
+			obFadvise(obDescriptor, obStart+obDone+obChunk*obWindow, obChunk, obFadvWillNeed)
+		}
+
+		obEnd := obDone + obChunk
+		if obEnd > obSize {
+			obEnd = obSize
+		}
+
+		_, obErr := obFile.ReadAt(obBuffer[obDone:obEnd], obStart+obDone)
+		if obErr != nil {
+			return obErr
+		}
+	}
+
+	return nil
+}
+
+/*
+Allocate a read or decryption buffer, on huge pages when it is big
+enough for them to save TLB misses: an anonymous mapping, as madvise
+cannot be used on the go heap. Released by obFreeBuffer.
+*/
+func obAllocBuffer(obSize int) []byte {
+	if obSize < obHugePageMin {
+		return make([]byte, obSize)
+	}
+
+	// OB_SYSCALLS mmap,madvise
+	obBuffer, obErr := obSyscall.Mmap(-1, 0, obSize,
+		obSyscall.PROT_READ|obSyscall.PROT_WRITE,
+		obSyscall.MAP_ANON|obSyscall.MAP_PRIVATE)
+	if obErr != nil {
+		return make([]byte, obSize)
+	}
+
+	// a kernel without transparent huge pages refuses it, it is a hint only
+	obSyscall.Madvise(obBuffer, obMadvHugePage)
+
+	return obBuffer
+}
+
+// Release a buffer of obAllocBuffer, a go heap one is left to the collector.
+func obFreeBuffer(obBuffer []byte) {
+	// OB_SYSCALLS munmap
+	obSyscall.Munmap(obBuffer[:cap(obBuffer)])
+}
+
+// OB_FEATURE_END iohints
+// OB_FEATURE_BEGIN scatter
+// the count and the start and size of each fragment, 8 bytes each
+const obScatterMapSize = 8 + 16*16
+
+/*
+Read the map of the fragments of the payload before the offset, at the
+end of the garbage, unmasking it with the AES-256-CTR keystream of the
+salt: their start and size, in the order of the ciphertext, see
+pakkero.ScatterMap. The fragments are before the map.
+*/
+func obScatterMap(obPrefix []byte) [][2]int64 {
+	obMapStart := obSensitiveInt(obSensitive(nil, "SCATTERMAP"))
+	if obMapStart < 0 || obMapStart+obScatterMapSize > int64(len(obPrefix)) {
+		obDebugf("scatter: map at %d past the key\n", obMapStart) // OB_FEATURE launcherdebug
+		obTamper(obCheckDecrypt)
+	}
+
+	obMap := append([]byte(nil), obPrefix[obMapStart:obMapStart+obScatterMapSize]...)
+
+	obSalt := obSensitive(nil, "SCATTERSALT")
+	obKey := obSHA.Sum512_256(obSalt)
+	obWipe(obSalt)
+
+	obBlock, _ := obAES.NewCipher(obKey[:])
+	obCipher.NewCTR(obBlock, make([]byte, obBlock.BlockSize())).XORKeyStream(obMap, obMap)
+	obWipe(obKey[:])
+
+	obCount := obBinary.LittleEndian.Uint64(obMap)
+	if obCount > (obScatterMapSize-8)/16 {
+		obDebugf("scatter: %d fragments\n", obCount) // OB_FEATURE launcherdebug
+		obTamper(obCheckDecrypt)
+	}
+
+	obFragments := [][2]int64{}
+
+	for obIndex := uint64(0); obIndex < obCount; obIndex++ {
+		obStart := int64(obBinary.LittleEndian.Uint64(obMap[8+16*obIndex:]))
+		obSize := int64(obBinary.LittleEndian.Uint64(obMap[16+16*obIndex:]))
+
+		if obStart < 0 || obSize < 0 || obStart+obSize > obMapStart {
+			obDebugf("scatter: fragment %d:%d past the map\n", obStart, obSize) // OB_FEATURE launcherdebug
+			obTamper(obCheckDecrypt)
+		}
+
+		obFragments = append(obFragments, [2]int64{obStart, obSize})
+	}
+
+	obWipe(obMap)
+
+	return obFragments
+}
+
+// OB_FEATURE_END scatter
+/*
+Read from the launcher file the ciphertext at the given position and
+decrypt it with the key derived from everything before obKeyEnd. The
+payload, if obIsPayload, is first gathered from its fragments before the
+offset, that are zeroed in the key, and its whitening removed.
+*/
+func obDecrypt(obFile *obOS.File, obKeyEnd int64, obStart int64, obSize int64, obIsPayload bool) []byte {
+	// positions are int64, buffers are int: 32 bit on 386 and arm
+	if obKeyEnd > obMaxInt || obSize > obMaxInt {
+		obDebugf("decrypt: %d bytes do not fit in memory\n", obKeyEnd+obSize) // OB_FEATURE launcherdebug
+		obOS.Exit(obExitExtract)
+	}
+
+	// read the complete executable
+	obKey := make([]byte, obKeyEnd)
+
+	var obErr error
+
+	// OB_CHECK
+	_, obErr = obFile.ReadAt(obKey, 0)     // OB_FEATURE !iohints
+	obErr = obReadHinted(obFile, obKey, 0) // OB_FEATURE iohints
+	if obErr != nil {
+		obDebugf("decrypt: cannot read the key: %v\n", obErr) // OB_FEATURE launcherdebug
+		obTamper(obCheckDecrypt)
+	}
+
+	// the fragments before the offset come first
+	obScattered := int64(0)
+	// OB_FEATURE_BEGIN scatter
+	obFragments := obScatterMap(obKey)
+
+	for _, obFragment := range obFragments {
+		if obIsPayload {
+			obScattered += obFragment[1]
+		}
+	}
+	// OB_FEATURE_END scatter
+
+	obCiphertext := make([]byte, obScattered+obSize)
+	// OB_FEATURE_BEGIN iohints
+	obCiphertext = obAllocBuffer(len(obCiphertext))
+	defer obFreeBuffer(obCiphertext)
+	// OB_FEATURE_END iohints
+
+	// OB_CHECK
+	_, obErr = obFile.ReadAt(obCiphertext[obScattered:], obStart)     // OB_FEATURE !iohints
+	obErr = obReadHinted(obFile, obCiphertext[obScattered:], obStart) // OB_FEATURE iohints
+	if obErr != nil {
+		obDebugf("decrypt: cannot read %d bytes at %d: %v\n", obSize, obStart, obErr) // OB_FEATURE launcherdebug
+		obTamper(obCheckDecrypt)
+	}
+
+	// OB_FEATURE_BEGIN scatter
+	// every key was derived with the fragments zeroed
+	obGathered := obCiphertext[:0]
+
+	for _, obFragment := range obFragments {
+		if obIsPayload {
+			obGathered = append(obGathered, obKey[obFragment[0]:obFragment[0]+obFragment[1]]...)
+		}
+	}
+
+	for _, obFragment := range obFragments {
+		obWipe(obKey[obFragment[0] : obFragment[0]+obFragment[1]])
+	}
+	// OB_FEATURE_END scatter
+
+	// OB_FEATURE_BEGIN whiten
+	if obIsPayload {
+		obUnwhiten(obKey, obCiphertext)
+	}
+	// OB_FEATURE_END whiten
+
+	// OB_CHECK
+	// the payload was reversed!
+	obCiphertext = obReverseByteArray(obCiphertext)
+
+	// OB_CHECK
+	// restore endianess
+	for obIndex := range obCiphertext {
+		obCiphertext[obIndex] = obByteReverse(obCiphertext[obIndex])
+	}
+
+	// OB_CHECK
+	/*
+		the aes-256 psk is the sha512_256 sum of the whole executable
+		this is also useful to protect against NOP attacks to the anti-debug
+		features in the binary.
+		This doubles also as anti-tamper measure.
+	*/
+	obPassword := obSHA.Sum512_256(obKey)
+	// OB_CHECK
+	obCipherBlock, _ := obAES.NewCipher(obPassword[:])
+
+	// OB_CHECK
+	obGCM, _ := obCipher.NewGCM(obCipherBlock)
+
+	// OB_CHECK
+	obSizeNonce := obGCM.NonceSize()
+	if len(obCiphertext) < obSizeNonce {
+		obDebugf("decrypt: ciphertext too short\n") // OB_FEATURE launcherdebug
+		obTamper(obCheckDecrypt)
+	}
+
+	// OB_CHECK
+	// decrypt!!!
+	obNonce, obCiphertext := obCiphertext[:obSizeNonce], obCiphertext[obSizeNonce:]
+	obOpened := []byte(nil)
+	// OB_FEATURE_BEGIN iohints
+	obOpened = obAllocBuffer(len(obCiphertext))[:0]
+	defer obFreeBuffer(obOpened)
+	// OB_FEATURE_END iohints
+	obCompressedPlaintext, _ := obGCM.Open(obOpened, obNonce, obCiphertext, nil)
+	obDebugf("decrypt: %d bytes, 0 means wrong key or tampered file\n", len(obCompressedPlaintext)) // OB_FEATURE launcherdebug
+
+	// OB_CHECK
+	// the payload was compressed, after the id of its codec
+	if len(obCompressedPlaintext) == 0 ||
+		int64(obCompressedPlaintext[0]) != obSensitiveInt(obSensitive(nil, "CODECID")) {
+		obDebugf("decompress: not a blob of the codec\n") // OB_FEATURE launcherdebug
+		obTamper(obCheckDecrypt)
+	}
+	// OB_CHECK
+	obDecompressor := obIO.Reader(obBytes.NewReader(obCompressedPlaintext[1:]))
+	// OB_FEATURE_BEGIN codeczlib
+	obZlibReader, obErr := obZlib.NewReader(obDecompressor)
+	if obErr != nil {
+		obDebugf("decompress: %v\n", obErr) // OB_FEATURE launcherdebug
+		obTamper(obCheckDecrypt)
+	}
+	defer obZlibReader.Close()
+	obDecompressor = obZlibReader
+	// OB_FEATURE_END codeczlib
+	// OB_FEATURE_BEGIN codecgzip
+	obGzipReader, obErr := obGzip.NewReader(obDecompressor)
+	if obErr != nil {
+		obDebugf("decompress: %v\n", obErr) // OB_FEATURE launcherdebug
+		obTamper(obCheckDecrypt)
+	}
+	defer obGzipReader.Close()
+	obDecompressor = obGzipReader
+	// OB_FEATURE_END codecgzip
+	// OB_CHECK
+	obPlaintext, obErr := obReadAll(obDecompressor)
+	if obErr != nil {
+		obDebugf("decompress: %v\n", obErr) // OB_FEATURE launcherdebug
+		obTamper(obCheckDecrypt)
+	}
+	// OB_FEATURE_BEGIN antidump
+	obCompressedPlaintext = obNoDumpMove(obCompressedPlaintext)
+	obPlaintext = obNoDumpMove(obPlaintext)
+	// OB_FEATURE_END antidump
+	// OB_CHECK
+	// payload was in b64
+	obSizeDecoded := obBase64.StdEncoding.DecodedLen(len(obPlaintext))
+	var obPayload []byte
+	obPayload = make([]byte, obSizeDecoded)  // OB_FEATURE !antidump
+	obPayload = obNoDumpAlloc(obSizeDecoded) // OB_FEATURE antidump
+	obSizePayload, _ := obBase64.StdEncoding.Decode(obPayload, obPlaintext)
+	obPayload = obPayload[:obSizePayload]
+	// OB_FEATURE_BEGIN antidump
+	// only the decoded payload is needed now, wipe our copies
+	obWipe(obCompressedPlaintext)
+	obWipe(obPlaintext)
+	// OB_FEATURE_END antidump
+
+	return obPayload
+}
+
+/*
+The environment inherited by the launcher, with the go runtime
+variables it had before obPinRuntime pinned them.
+*/
+func obInheritedEnviron() []string {
+	// OB_FEATURE_BEGIN !launcherdebug
+	if obStash, obFound := obOS.LookupEnv(obRuntimeStash); obFound {
+		obResult := []string{}
+
+		for _, obVariable := range obOS.Environ() {
+			obName := obStrings.SplitN(obVariable, "=", 2)[0]
+
+			if _, obPinned := obPinnedRuntime[obName]; !obPinned && obName != obRuntimeStash {
+				obResult = append(obResult, obVariable)
+			}
+		}
+
+		obBuffer, _ := obHex.DecodeString(obStash)
+
+		for _, obVariable := range obBytes.Split(obBuffer, []byte{0}) {
+			if len(obVariable) > 0 {
+				obResult = append(obResult, string(obVariable))
+			}
+		}
+
+		return obResult
+	}
+	// OB_FEATURE_END !launcherdebug
+
+	return obOS.Environ()
+}
+
+// variables set by the launcher for the payload, like OB_DEP_MISMATCH
+var obLauncherEnv []string
+
+/*
+Build the payload environment: the inherited variables allowed by the
+packing policy, then the ones given at packing time, then the ones set
+by the launcher, the last ones win.
+The variables read by the launcher itself are never forwarded.
+*/
+func obPayloadEnviron() []string {
+	obResult := obInheritedEnviron()
+	// OB_FEATURE_BEGIN envclear
+	obResult = []string{}
+	// OB_FEATURE_END envclear
+	// OB_FEATURE_BEGIN envallowlist
+	obAllowed := obStrings.Split("ENVALLOWLIST", ",")
+
+	for _, obVariable := range obInheritedEnviron() {
+		obName := obStrings.SplitN(obVariable, "=", 2)[0]
+
+		for _, obAllowedName := range obAllowed {
+			if obName == obAllowedName {
+				obResult = append(obResult, obVariable)
+			}
+		}
+	}
+	// OB_FEATURE_END envallowlist
+	// OB_FEATURE_BEGIN payloadenv
+	obBuffer := obSensitive(nil, "PAYLOADENV")
+
+	for _, obVariable := range obBytes.Split(obBuffer[:len(obBuffer)-1], []byte{0}) {
+		obResult = append(obResult, string(obVariable))
+	}
+
+	obWipe(obBuffer)
+	// OB_FEATURE_END payloadenv
+	obResult = append(obResult, obLauncherEnv...)
+	// OB_FEATURE_BEGIN bundlelibs
+	obResult = obBundleLibsEnviron(obResult)
+	// OB_FEATURE_END bundlelibs
+
+	// OB_FEATURE_BEGIN launcherdebug
+	obFiltered := []string{}
+
+	for _, obVariable := range obResult {
+		if !obStrings.HasPrefix(obVariable, "PAKKERO_LAUNCHER_DEBUG_FILE=") {
+			obFiltered = append(obFiltered, obVariable)
+		}
+	}
+
+	obResult = obFiltered
+	// OB_FEATURE_END launcherdebug
+
+	return obResult
+}
+
+// OB_FEATURE_BEGIN payloadargs
+/*
+Insert the arguments given at packing time right after the
+program name, before the runtime ones.
+*/
+func obPayloadArgs(obArgs []string) []string {
+	obBuffer := obSensitive(nil, "PAYLOADARGS")
+	obResult := []string{obArgs[0]}
+
+	for _, obArg := range obBytes.Split(obBuffer[:len(obBuffer)-1], []byte{0}) {
+		obResult = append(obResult, string(obArg))
+	}
+
+	obWipe(obBuffer)
+
+	return append(obResult, obArgs[1:]...)
+}
+
+// OB_FEATURE_END payloadargs
+// OB_FEATURE_BEGIN secretargs
+/*
+Pass the secret arguments to the payload in a sealed memfd, NUL
+terminated, instead of its argv: the payload reads them from the
+path in OB_SECRET_ARGS, so they never show in /proc/<pid>/cmdline.
+*/
+func obSecretArgs() *obOS.File {
+	obBuffer := obSensitive(nil, "SECRETARGS")
+	obFileDescriptor := obExtract(obBuffer)
+	obWipe(obBuffer)
+
+	// readable also from the inherited descriptor itself
+	obSyscall.Seek(int(obFileDescriptor), 0, 0)
+
+	// it is the first of the extra files, they start from descriptor 3
+	obLauncherEnv = append(obLauncherEnv, "OB_SECRET_ARGS=/proc/self/fd/3")
+	obDebugf("execute: secret arguments in fd %d\n", obFileDescriptor) // OB_FEATURE launcherdebug
+
+	return obOS.NewFile(obFileDescriptor, "")
+}
+
+// OB_FEATURE_END secretargs
+// OB_FEATURE_BEGIN launchtoken
+// ends of the pipe of the launch token of the payload being started
+var obTokenReader, obTokenWriter *obOS.File
+
+/*
+Give the payload being started a pipe, its path in OB_LAUNCH_TOKEN,
+where its launch token is written once its pid is known, see
+obWriteToken. A retried start gets a new one.
+*/
+func obTokenPipe(obCommand *obExec.Cmd) {
+	if obTokenWriter != nil {
+		obTokenReader.Close()
+		obTokenWriter.Close()
+	}
+
+	var obErr error
+
+	obTokenReader, obTokenWriter, obErr = obOS.Pipe()
+	if obErr != nil {
+		obExit()
+	}
+
+	obCommand.ExtraFiles = append(obCommand.ExtraFiles, obTokenReader)
+	obCommand.Env = append(obCommand.Env,
+		"OB_LAUNCH_TOKEN=/proc/self/fd/"+obStrconv.Itoa(2+len(obCommand.ExtraFiles)))
+}
+
+/*
+Write the launch token of the started payload: the unix time and its
+pid, signed with the key given at packing time, see the launchtoken
+package. The pipe holds it even if the payload never reads it.
+*/
+func obWriteToken(obPid int) {
+	obToken := make([]byte, len("PKT1")+8+4)
+	copy(obToken, "PKT1")
+	obBinary.BigEndian.PutUint64(obToken[len("PKT1"):], uint64(obTime.Now().Unix()))
+	obBinary.BigEndian.PutUint32(obToken[len("PKT1")+8:], uint32(obPid))
+
+	obSeed := obSensitive(nil, "LAUNCHTOKENKEY")
+	obKey := obEd25519.NewKeyFromSeed(obSeed)
+	obWipe(obSeed)
+
+	obToken = append(obToken, obEd25519.Sign(obKey, obToken)...)
+	obWipe(obKey)
+
+	obTokenWriter.Write(obToken)
+	obTokenWriter.Close()
+	obTokenReader.Close()
+	obDebugf("execute: launch token written for pid %d\n", obPid) // OB_FEATURE launcherdebug
+}
+
+// OB_FEATURE_END launchtoken
+
+// OB_FEATURE_BEGIN daemonize
+/*
+Run the payload detached: in a new session, without a controlling
+terminal, with stdin from /dev/null and the output in the log file,
+the payload is not a child of the caller anymore once we exit.
+Exit as soon as the payload runs, or with an error if it could not.
+*/
+func obDaemonize(obNewCommand func() *obExec.Cmd, obFileDescriptor uintptr) {
+	obNull, obErr := obOS.OpenFile("/dev/null", obOS.O_RDWR, 0)
+	if obErr != nil {
+		obExit()
+	}
+
+	obOutput := obNull
+	// OB_FEATURE_BEGIN daemonlog
+	obOutput, obErr = obOS.OpenFile("DAEMONLOG",
+		obOS.O_APPEND|obOS.O_CREATE|obOS.O_WRONLY, 0600)
+	if obErr != nil {
+		obDebugf("daemonize: log file failed: %v\n", obErr) // OB_FEATURE launcherdebug
+		obExit()
+	}
+	// OB_FEATURE_END daemonlog
+
+	// the launcher will be gone, the payload has to use its own
+	// copy of the descriptor, so interpreters of scripts can open it
+	obPayloadFile := obOS.NewFile(obFileDescriptor, "")
+
+	obCommand := obStart(func() *obExec.Cmd {
+		obCommand := obNewCommand()
+		// OB_SYSCALLS setsid
+		obCommand.SysProcAttr = &obSyscall.SysProcAttr{Setsid: true}
+		obCommand.ExtraFiles = append(obCommand.ExtraFiles, obPayloadFile)
+		obCommand.Path = "/proc/self/fd/" + obStrconv.Itoa(2+len(obCommand.ExtraFiles))
+		obCommand.Stdin = obNull
+		obCommand.Stdout = obOutput
+		obCommand.Stderr = obOutput
+
+		return obCommand
+	})
+
+	obWriteToken(obCommand.Process.Pid) // OB_FEATURE launchtoken
+
+	// OB_FEATURE_BEGIN daemonpidfile
+	obErr = obWriteFile("DAEMONPIDFILE",
+		[]byte(obStrconv.Itoa(obCommand.Process.Pid)+"\n"))
+	if obErr != nil {
+		obDebugf("daemonize: pidfile failed: %v\n", obErr) // OB_FEATURE launcherdebug
+		obCommand.Process.Kill()
+		obExit()
+	}
+	// OB_FEATURE_END daemonpidfile
+
+	obDebugf("daemonize: payload running, pid %d\n", obCommand.Process.Pid) // OB_FEATURE launcherdebug
+
+	// the payload is not ours to wait for
+	obCommand.Process.Release()
+	obOS.Exit(OK)
+}
+
+// OB_FEATURE_END daemonize
+
+// OB_FEATURE_BEGIN init
+// prctl option to adopt the orphaned descendants
+const obSetChildSubreaper = 36
+
+/*
+Run the payload as an init would: reap every child, including the
+orphans of the payload, forward the termination signals to it and
+exit with its status.
+As PID 1 (eg: a container entrypoint) orphans are ours already,
+otherwise we become their subreaper.
+*/
+func obRunAsInit(obNewCommand func() *obExec.Cmd, obFileDescriptor uintptr) {
+	if obOS.Getpid() != 1 {
+		// OB_SYSCALLS prctl
+		_, _, obErr := obSyscall.RawSyscall(obSyscall.SYS_PRCTL,
+			uintptr(obSetChildSubreaper), 1, 0)
+		if obErr != obSyscall.Errno(0) {
+			obExit()
+		}
+	}
+
+	// ask before starting, not to miss any signal
+	obSignals := make(chan obOS.Signal, 8)
+	obSignal.Notify(obSignals, obSyscall.SIGTERM, obSyscall.SIGINT,
+		obSyscall.SIGHUP, obSyscall.SIGQUIT, obSyscall.SIGUSR1,
+		obSyscall.SIGUSR2, obSyscall.SIGWINCH)
+
+	obCommand := obStart(func() *obExec.Cmd {
+		obCommand := obNewCommand()
+		obCommand.Stdin = obOS.Stdin
+		obCommand.Stdout = obOS.Stdout
+		obCommand.Stderr = obOS.Stderr
+
+		return obCommand
+	})
+
+	obSyscall.Close(int(obFileDescriptor)) // OB_FEATURE antidumpreopen
+	obPid := obCommand.Process.Pid
+	obWriteToken(obPid)                                // OB_FEATURE launchtoken
+	obDebugf("init: payload running, pid %d\n", obPid) // OB_FEATURE launcherdebug
+
+	obTimedOut, _ := obWatchdog(obPid) // OB_FEATURE payloadtimeout
+
+	go func() {
+		for obReceived := range obSignals {
+			obSyscall.Kill(obPid, obReceived.(obSyscall.Signal))
+		}
+	}()
+
+	for {
+		var obStatus obSyscall.WaitStatus
+
+		obReaped, obErr := obSyscall.Wait4(-1, &obStatus, 0, nil)
+		if obErr == obSyscall.EINTR {
+			continue
+		}
+
+		if obErr != nil {
+			obExit()
+		}
+
+		if obReaped != obPid {
+			obDebugf("init: reaped orphan %d\n", obReaped) // OB_FEATURE launcherdebug
+			continue
+		}
+
+		obDebugf("init: payload exited: %v\n", obStatus) // OB_FEATURE launcherdebug
+		// OB_FEATURE_BEGIN payloadtimeout
+		if obTimedOut() {
+			obOS.Exit(obExitTimeout)
+		}
+		// OB_FEATURE_END payloadtimeout
+		// OB_FEATURE_BEGIN successcodes
+		obSuccessExit(obStatus)
+		// OB_FEATURE_END successcodes
+
+		if obStatus.Signaled() {
+			obOS.Exit(128 + int(obStatus.Signal()))
+		}
+
+		obOS.Exit(obStatus.ExitStatus())
+	}
+}
+
+// OB_FEATURE_END init
+
+// OB_FEATURE_BEGIN rlimit
+/*
+Apply the resource limits given at packing time to ourselves, right
+before starting the payload that inherits them: go cannot run code
+between fork and exec.
+Raising a limit above our hard limit needs privileges, without them
+the payload is not started or, with the clamp policy, the limit is
+lowered to our hard limit.
+*/
+func obSetLimits() {
+	for _, obItem := range obStrings.Split("RLIMITS", ",") {
+		obFields := obStrings.Split(obItem, ":")
+		obResource, _ := obStrconv.Atoi(obFields[0])
+		obSoft, _ := obStrconv.ParseUint(obFields[1], 10, 64)
+		obHard, _ := obStrconv.ParseUint(obFields[2], 10, 64)
+		obLimit := obSyscall.Rlimit{Cur: obSoft, Max: obHard}
+
+		// OB_SYSCALLS prlimit64,getrlimit,setrlimit
+		obErr := obSyscall.Setrlimit(obResource, &obLimit)
+		// OB_FEATURE_BEGIN rlimitclamp
+		if obErr == obSyscall.EPERM {
+			var obCurrent obSyscall.Rlimit
+
+			obSyscall.Getrlimit(obResource, &obCurrent)
+
+			if obLimit.Max > obCurrent.Max {
+				obLimit.Max = obCurrent.Max
+			}
+
+			if obLimit.Cur > obLimit.Max {
+				obLimit.Cur = obLimit.Max
+			}
+
+			obErr = obSyscall.Setrlimit(obResource, &obLimit)
+		}
+		// OB_FEATURE_END rlimitclamp
+		if obErr != nil {
+			obDebugf("rlimit: resource %d failed: %v\n", obResource, obErr) // OB_FEATURE launcherdebug
+			obOS.Exit(obExitCannotExec)
+		}
+	}
+}
+
+// OB_FEATURE_END rlimit
+
+// OB_FEATURE_BEGIN sched
+/*
+Apply the scheduling given at packing time to ourselves, right before
+starting the payload that inherits it.
+On linux the nice value, the I/O priority and the affinity belong to
+the thread, so keep this goroutine on the one that will fork.
+*/
+func obSetScheduling() {
+	obRuntime.LockOSThread()
+	// OB_FEATURE_BEGIN nice
+	obNice, _ := obStrconv.Atoi("NICE")
+
+	// OB_SYSCALLS setpriority
+	if obErr := obSyscall.Setpriority(obSyscall.PRIO_PROCESS, 0, obNice); obErr != nil {
+		obDebugf("sched: nice %d failed: %v\n", obNice, obErr) // OB_FEATURE launcherdebug
+		obOS.Exit(obExitCannotExec)
+	}
+	// OB_FEATURE_END nice
+	// OB_FEATURE_BEGIN ionice
+	obIOPrio, _ := obStrconv.Atoi("IOPRIO")
+
+	// IOPRIO_WHO_PROCESS, 0 is the calling thread
+	// OB_SYSCALLS ioprio_set
+	_, _, obErrno := obSyscall.RawSyscall(obSyscall.SYS_IOPRIO_SET,
+		1, 0, uintptr(obIOPrio))
+	if obErrno != 0 {
+		obDebugf("sched: ioprio %d failed: %v\n", obIOPrio, obErrno) // OB_FEATURE launcherdebug
+		obOS.Exit(obExitCannotExec)
+	}
+	// OB_FEATURE_END ionice
+	// OB_FEATURE_BEGIN cpuset
+	obSetAffinity()
+	// OB_FEATURE_END cpuset
+}
+
+// OB_FEATURE_END sched
+
+// OB_FEATURE_BEGIN cpuset
+/*
+Restrict the CPUs we run on to the ones given at packing time that are
+available here, the machine may have fewer or a cgroup may forbid some:
+those are dropped and, if none is left, we keep running on every CPU.
+In strict mode any missing CPU is a failure instead.
+*/
+func obSetAffinity() {
+	var obAvailable, obWanted [16]uint64
+
+	_, _, obErrno := obSyscall.RawSyscall(obSyscall.SYS_SCHED_GETAFFINITY,
+		0, obUnsafe.Sizeof(obAvailable), uintptr(obUnsafe.Pointer(&obAvailable)))
+	if obErrno != 0 {
+		obDebugf("sched: getaffinity failed: %v\n", obErrno) // OB_FEATURE launcherdebug
+		obOS.Exit(obExitCannotExec)
+	}
+
+	obCount := 0
+
+	for _, obItem := range obStrings.Split("CPUSET", ",") {
+		obCPU, _ := obStrconv.Atoi(obItem)
+		obBit := uint64(1) << (uint(obCPU) % 64)
+
+		if obAvailable[obCPU/64]&obBit == 0 {
+			obDebugf("sched: cpu %d not available\n", obCPU) // OB_FEATURE launcherdebug
+			// OB_FEATURE_BEGIN cpusetstrict
+			obOS.Exit(obExitCannotExec)
+			// OB_FEATURE_END cpusetstrict
+			continue
+		}
+
+		obWanted[obCPU/64] |= obBit
+		obCount++
+	}
+
+	if obCount == 0 {
+		obDebugf("sched: no cpu available, running on every cpu\n") // OB_FEATURE launcherdebug
+		return
+	}
+
+	// OB_SYSCALLS sched_setaffinity
+	_, _, obErrno = obSyscall.RawSyscall(obSyscall.SYS_SCHED_SETAFFINITY,
+		0, obUnsafe.Sizeof(obWanted), uintptr(obUnsafe.Pointer(&obWanted)))
+	if obErrno != 0 {
+		obDebugf("sched: setaffinity failed: %v\n", obErrno) // OB_FEATURE launcherdebug
+		obOS.Exit(obExitCannotExec)
+	}
+}
+
+// OB_FEATURE_END cpuset
+// exit codes of the failures running the payload, like the shell ones
+const (
+	obExitExtract    = 125
+	obExitCannotExec = 126
+	obExitNotFound   = 127
+	// like timeout(1)
+	obExitTimeout = 124
+)
+
+// OB_FEATURE_BEGIN payloadtimeout
+/*
+Put the payload in a process group of its own, for obWatchdog to signal
+its children too: in the foreground if we are in the one of a terminal
+of our streams, for the payload to keep it.
+*/
+func obPayloadGroup() *obSyscall.SysProcAttr {
+	// OB_SYSCALLS setpgid,getpgid,ioctl
+	obGroup, _, _ := obSyscall.RawSyscall(obSyscall.SYS_GETPGID, 0, 0, 0)
+
+	for obTerminal := 0; obTerminal <= 2; obTerminal++ {
+		var obForeground int32
+
+		_, _, obErr := obSyscall.RawSyscall(obSyscall.SYS_IOCTL, uintptr(obTerminal),
+			obSyscall.TIOCGPGRP, uintptr(obUnsafe.Pointer(&obForeground)))
+		if obErr == 0 && uintptr(obForeground) == obGroup {
+			return &obSyscall.SysProcAttr{Setpgid: true, Foreground: true, Ctty: obTerminal}
+		}
+	}
+
+	return &obSyscall.SysProcAttr{Setpgid: true}
+}
+
+/*
+Watch the payload with pid obPid, started by obPayloadGroup: at the
+deadline send its process group SIGTERM, and SIGKILL if the payload is
+still running after the grace period.
+Call the returned function once the payload is reaped, it returns true
+if the payload was signaled; the returned channel is closed once it is
+killed, not to wait for the output of a child that left the group.
+*/
+func obWatchdog(obPid int) (func() bool, <-chan struct{}) {
+	obTimeout := obTime.Duration(obSensitiveInt(obSensitive(nil, "PAYLOADTIMEOUT"))) * obTime.Millisecond
+	obKillAfter := obTime.Duration(obSensitiveInt(obSensitive(nil, "PAYLOADKILLAFTER"))) * obTime.Millisecond
+	obReaped := make(chan struct{})
+	obTimedOut := make(chan bool, 1)
+	obExpired := make(chan struct{})
+
+	go func() {
+		select {
+		case <-obReaped:
+			obTimedOut <- false
+
+			return
+		case <-obTime.After(obTimeout):
+		}
+
+		obDebugf("timeout: terminating payload %d\n", obPid) // OB_FEATURE launcherdebug
+		obSyscall.Kill(-obPid, obSyscall.SIGTERM)
+
+		select {
+		case <-obReaped:
+		case <-obTime.After(obKillAfter):
+			obDebugf("timeout: killing payload %d\n", obPid) // OB_FEATURE launcherdebug
+			obSyscall.Kill(-obPid, obSyscall.SIGKILL)
+		}
+
+		close(obExpired)
+		obTimedOut <- true
+	}()
+
+	return func() bool {
+		close(obReaped)
+
+		return <-obTimedOut
+	}, obExpired
+}
+
+// OB_FEATURE_END payloadtimeout
+// OB_FEATURE_BEGIN successcodes
+/*
+Exit with the status of the payload mapped by the success codes: 0 if
+it exited with one of them, its own status otherwise, 1 for a 0 that
+is not a success code and 128 plus the signal if it was killed.
+*/
+func obSuccessExit(obStatus obSyscall.WaitStatus) {
+	if obStatus.Signaled() {
+		obOS.Exit(128 + int(obStatus.Signal()))
+	}
+
+	obCodes := obSensitive(nil, "SUCCESSCODES")
+	obSuccess := false
+
+	for _, obCode := range obStrings.Split(obUnsafeString(obCodes), ",") {
+		obValue, _ := obStrconv.Atoi(obCode)
+		obSuccess = obSuccess || obValue == obStatus.ExitStatus()
+	}
+
+	obWipe(obCodes)
+	obDebugf("exit: payload status %d, success %v\n", obStatus.ExitStatus(), obSuccess) // OB_FEATURE launcherdebug
+
+	switch {
+	case obSuccess:
+		obOS.Exit(OK)
+	case obStatus.ExitStatus() == 0:
+		obOS.Exit(ERR)
+	default:
+		obOS.Exit(obStatus.ExitStatus())
+	}
+}
+
+// OB_FEATURE_END successcodes
+
+/*
+Wait before retrying a transient failure, returns false if the
+failure is not transient or there are no attempts left.
+*/
+func obRetry(obAttempt int, obErr error) bool {
+	var obErrno obSyscall.Errno
+	if !obErrors.As(obErr, &obErrno) {
+		return false
+	}
+
+	switch obErrno {
+	case obSyscall.EAGAIN, obSyscall.EINTR, obSyscall.EBUSY,
+		obSyscall.ETXTBSY, obSyscall.ENOMEM:
+	default:
+		return false
+	}
+
+	obAttempts, _ := obStrconv.Atoi("EXECRETRIES")
+	if obAttempt >= obAttempts {
+		return false
+	}
+
+	obBackoff, _ := obStrconv.Atoi("EXECBACKOFF")
+	obTime.Sleep(obTime.Duration(obBackoff<<obAttempt) * obTime.Millisecond)
+
+	return true
+}
+
+/*
+Write the payload in a sealed memory file descriptor,
+the descriptor is closed on failure.
+*/
+func obExtractOnce(obPayload []byte) (uintptr, error) {
+	obFDName := ""
+	obFileDescriptor, _, obErrno := obSyscall.Syscall(obSysMEMFDCreate,
+		uintptr(obUnsafe.Pointer(&obFDName)),
+		uintptr(obCloexec|obAllowSealing), 0)
+	if obErrno != obSyscall.Errno(0) {
+		return 0, obErrno
+	}
+
+	// OB_CHECK
+	// write payload to FD, a single write stops at about 2GB
+	for obWritten := 0; obWritten < len(obPayload); {
+		obCount, obErr := obSyscall.Write(int(obFileDescriptor), obPayload[obWritten:])
+		if obErr != nil {
+			obSyscall.Close(int(obFileDescriptor))
+
+			return 0, obErr
+		}
+
+		obWritten += obCount
+	}
+
+	// OB_CHECK
+	// make it immutable
+	_, _, obErrno = obSyscall.Syscall(obSysFCNTL,
+		obFileDescriptor,
+		uintptr(obAddSeals),
+		uintptr(obSealAll))
+	if obErrno != obSyscall.Errno(0) {
+		obSyscall.Close(int(obFileDescriptor))
+
+		return 0, obErrno
+	}
+
+	return obFileDescriptor, nil
+}
+
+/*
+Extract the payload in a memory file descriptor, retrying the
+transient failures, exit with obExitExtract on the other ones.
+*/
+func obExtract(obPayload []byte) uintptr {
+	for obAttempt := 0; ; obAttempt++ {
+		obFileDescriptor, obErr := obExtractOnce(obPayload)
+		if obErr == nil {
+			return obFileDescriptor
+		}
+
+		obDebugf("execute: extraction failed: %v\n", obErr) // OB_FEATURE launcherdebug
+
+		if !obRetry(obAttempt, obErr) {
+			obExplainDenied(obErr) // OB_FEATURE android
+			obOS.Exit(obExitExtract)
+		}
+	}
+}
+
+// descriptors probed by obListDescriptors when /proc is not mounted
+const obMaxDescriptors = 65536
+
+/*
+List the open descriptors, from /proc, or probing each one when it is
+not mounted.
+*/
+func obListDescriptors() map[int]bool {
+	obDescriptors := map[int]bool{}
+
+	obDir, obErr := obOS.Open("/proc/self/fd")
+	if obErr == nil {
+		obSelf := int(obDir.Fd())
+		obNames, _ := obDir.Readdirnames(-1)
+		obDir.Close()
+
+		for _, obName := range obNames {
+			obDescriptor, obErr := obStrconv.Atoi(obName)
+			if obErr == nil && obDescriptor != obSelf {
+				obDescriptors[obDescriptor] = true
+			}
+		}
+
+		return obDescriptors
+	}
+
+	for obDescriptor := 0; obDescriptor < obMaxDescriptors; obDescriptor++ {
+		_, _, obErrno := obSyscall.Syscall(obSysFCNTL, uintptr(obDescriptor), obSyscall.F_GETFD, 0)
+		if obErrno == obSyscall.Errno(0) {
+			obDescriptors[obDescriptor] = true
+		}
+	}
+
+	return obDescriptors
+}
+
+/*
+The descriptors the launcher got from its caller, open before any of
+its own: they belong to the caller, like the sockets of a socket
+activation, and are passed on to the payload as they are.
+*/
+var obCallerDescriptors = obListDescriptors()
+
+/*
+Mark close-on-exec every descriptor the launcher opened, the ones of
+raw syscalls and of the go runtime included: the payload inherits
+stdin, stdout, stderr and obCallerDescriptors only, unless a feature
+passes it a descriptor explicitly, in the ExtraFiles of its command,
+that the child gets as copies numbered from 3 whatever their flags
+here. The same goes for the launcher executing itself.
+*/
+func obSealDescriptors() {
+	obSealed := 0
+
+	for obDescriptor := range obListDescriptors() {
+		if obDescriptor > 2 && !obCallerDescriptors[obDescriptor] {
+			obSyscall.CloseOnExec(obDescriptor)
+
+			obSealed++
+		}
+	}
+
+	obDebugf("execute: %d descriptors closed on exec\n", obSealed) // OB_FEATURE launcherdebug
+}
+
+/*
+Start a command built by obNewCommand, a failed command cannot be
+started again so each attempt builds a new one.
+Transient failures are retried, on the other ones exit with
+obExitNotFound if the payload or its interpreter is missing,
+obExitCannotExec otherwise.
+*/
+func obStart(obNewCommand func() *obExec.Cmd) *obExec.Cmd {
+	for obAttempt := 0; ; obAttempt++ {
+		obCommand := obNewCommand()
+
+		obSealDescriptors()
+
+		obErr := obCommand.Start()
+		if obErr == nil {
+			return obCommand
+		}
+
+		obDebugf("execute: start failed: %v\n", obErr) // OB_FEATURE launcherdebug
+
+		if obRetry(obAttempt, obErr) {
+			continue
+		}
+
+		obDiagnoseExec(obErr) // OB_FEATURE execdiag
+
+		var obErrno obSyscall.Errno
+		if obErrors.As(obErr, &obErrno) && obErrno == obSyscall.ENOENT {
+			obOS.Exit(obExitNotFound)
+		}
+
+		obExplainDenied(obErr) // OB_FEATURE android
+		obOS.Exit(obExitCannotExec)
+	}
+}
+
+// OB_FEATURE_BEGIN execdiag
+// what obDiagnoseExec found, the code printed
+const (
+	obDiagnosisNone = iota
+	obDiagnosisInterpreter
+	obDiagnosisLibrary
+	obDiagnosisArchitecture
+)
+
+// true once the payload itself is started, not the decoy nor the prologue
+var obDiagnosePayload bool
+
+// Return the e_machine of the ELF header of a file, 0 if it is not an ELF.
+func obElfMachine(obPath string) int {
+	obFile, obErr := obOS.Open(obPath)
+	if obErr != nil {
+		return 0
+	}
+	defer obFile.Close()
+
+	obHeader := make([]byte, 20)
+
+	_, obErr = obIO.ReadFull(obFile, obHeader)
+	if obErr != nil || !obBytes.HasPrefix(obHeader, []byte("\x7fELF")) {
+		return 0
+	}
+
+	// EI_DATA, 2 is big endian
+	if obHeader[5] == 2 {
+		return int(obBinary.BigEndian.Uint16(obHeader[18:]))
+	}
+
+	return int(obBinary.LittleEndian.Uint16(obHeader[18:]))
+}
+
+/*
+Return true if a library the payload needs is found like the dynamic
+loader would: in LD_LIBRARY_PATH, in /etc/ld.so.cache, or in the run
+path and the default directories recorded at packing time. Only the
+name is looked for, not its machine nor its own libraries.
+*/
+func obLibraryResolves(obName string, obDirs []string, obCache []byte) bool {
+	if obStrings.Contains(obName, "/") {
+		_, obErr := obOS.Stat(obName)
+
+		return obErr == nil
+	}
+
+	if obBytes.Contains(obCache, []byte("\x00"+obName+"\x00")) {
+		return true
+	}
+
+	for _, obDir := range obDirs {
+		if obDir == "" {
+			continue
+		}
+
+		_, obErr := obOS.Stat(obDir + "/" + obName)
+		if obErr == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+Find why the payload did not run, from what it needs recorded at
+packing time: obErr is the error starting it, nil if it exited with
+obExitNotFound, like the dynamic loader does on a missing library.
+Print a single line with the code of the cause, with no path nor name
+in it, nothing if no cause is found.
+*/
+func obDiagnoseExec(obErr error) {
+	if !obDiagnosePayload {
+		return
+	}
+
+	if obErr != nil && !obErrors.Is(obErr, obSyscall.ENOENT) &&
+		!obErrors.Is(obErr, obSyscall.ENOEXEC) && !obErrors.Is(obErr, obSyscall.ELIBBAD) {
+		return
+	}
+
+	obInterpreter := "EXECINTERP"
+	obMachine, _ := obStrconv.Atoi("EXECMACHINE")
+	obDiagnosis := obDiagnosisNone
+
+	_, obStatErr := obOS.Stat(obInterpreter)
+
+	switch {
+	case obInterpreter != "" && obStatErr != nil:
+		obDiagnosis = obDiagnosisInterpreter
+	// a script interpreter can be of any machine
+	case obInterpreter != "" && obMachine != 0 && obElfMachine(obInterpreter) != obMachine:
+		obDiagnosis = obDiagnosisArchitecture
+	case obErrors.Is(obErr, obSyscall.ENOEXEC) && obMachine != 0 && obElfMachine("/proc/self/exe") != obMachine:
+		obDiagnosis = obDiagnosisArchitecture
+	default:
+		obDirs := obStrings.Split("EXECLIBDIRS", ":")
+
+		for _, obVariable := range obPayloadEnviron() {
+			if obStrings.HasPrefix(obVariable, "LD_LIBRARY_PATH=") {
+				obDirs = append(obStrings.Split(obVariable[len("LD_LIBRARY_PATH="):], ":"), obDirs...)
+			}
+		}
+
+		obCache, _ := obReadFile("/etc/ld.so.cache")
+
+		for _, obName := range obStrings.Split("EXECNEEDED", ":") {
+			if obName != "" && !obLibraryResolves(obName, obDirs, obCache) {
+				obDiagnosis = obDiagnosisLibrary
+
+				break
+			}
+		}
+	}
+
+	obDebugf("execute: diagnosis %d\n", obDiagnosis) // OB_FEATURE launcherdebug
+
+	switch obDiagnosis {
+	case obDiagnosisInterpreter:
+		println("cannot run the program: E1 missing interpreter")
+	case obDiagnosisLibrary:
+		println("cannot run the program: E2 missing library")
+	case obDiagnosisArchitecture:
+		println("cannot run the program: E3 wrong architecture")
+	}
+}
+
+// OB_FEATURE_END execdiag
+
+// OB_FEATURE_BEGIN userland
+// implemented in the assembly built with the launcher, see pakkero.ExecModeUserland
+// OB_SYSCALLS clone,rt_sigaction,rt_sigprocmask,sigaltstack,close_range
+func obCopyTo(to uintptr, from []byte)
+func obUserlandSpawn(entry uintptr, stack uintptr) (pid int)
+
+const (
+	// ELF program header types and segment flags
+	obPtLoad   = 1
+	obPtInterp = 3
+	obPtPhdr   = 6
+	obPfX      = 1
+	obPfW      = 2
+	obPfR      = 4
+	// ELF file types, static binaries and static PIEs
+	obEtExec = 2
+	obEtDyn  = 3
+	// auxiliary vector entries describing the payload
+	obAtPhdr  = 3
+	obAtPhent = 4
+	obAtPhnum = 5
+	obAtBase  = 7
+	obAtEntry = 9
+	// fail instead of replacing a mapping, before linux 4.17 a hint
+	obMapFixedNoReplace = 0x100000
+	// size of the payload stack, the default limit of the main thread
+	obUserlandStackSize = 8 << 20
+)
+
+// a mapping of the payload
+type obMapping struct {
+	obStart uintptr
+	obSize  uintptr
+}
+
+// raw mmap, that can ask for an address
+func obMmap(obAddress uintptr, obSize uintptr, obProt uintptr, obFlags uintptr) (uintptr, error) {
+	obResult, _, obErrno := obSyscall.Syscall6(obSyscall.SYS_MMAP,
+		obAddress, obSize, obProt, obFlags, ^uintptr(0), 0)
+	if obErrno != obSyscall.Errno(0) {
+		return 0, obErrno
+	}
+
+	return obResult, nil
+}
+
+func obMunmap(obMapping obMapping) {
+	obSyscall.Syscall(obSyscall.SYS_MUNMAP, obMapping.obStart, obMapping.obSize, 0)
+}
+
+/*
+Map the PT_LOAD segments of a static ELF payload where they are linked,
+or anywhere for a static PIE, in the launcher memory: the fork running
+it inherits them. No file descriptor ever points to the payload.
+Returns the entry point, the address of the program headers and the
+mapping holding the segments.
+*/
+func obUserlandLoad(obPayload []byte) (uintptr, uintptr, obMapping, error) {
+	if len(obPayload) < 64 || string(obPayload[:4]) != "\x7fELF" ||
+		obPayload[4] != 2 || obPayload[5] != 1 ||
+		obBinary.LittleEndian.Uint16(obPayload[18:]) != 62 {
+		return 0, 0, obMapping{}, obErrors.New("not an amd64 ELF binary")
+	}
+
+	obType := obBinary.LittleEndian.Uint16(obPayload[16:])
+	if obType != obEtExec && obType != obEtDyn {
+		return 0, 0, obMapping{}, obErrors.New("not an executable")
+	}
+
+	obEntry := uintptr(obBinary.LittleEndian.Uint64(obPayload[24:]))
+	obPhoff := obBinary.LittleEndian.Uint64(obPayload[32:])
+	obPhentsize := uint64(obBinary.LittleEndian.Uint16(obPayload[54:]))
+	obPhnum := uint64(obBinary.LittleEndian.Uint16(obPayload[56:]))
+
+	if obPhentsize < 56 || obPhoff+obPhentsize*obPhnum > uint64(len(obPayload)) {
+		return 0, 0, obMapping{}, obErrors.New("truncated program headers")
+	}
+
+	obPage := uintptr(obOS.Getpagesize())
+	obLow, obHigh := ^uintptr(0), uintptr(0)
+	obHeaders := [][]byte{}
+	obPhdr := uintptr(0)
+
+	for obIndex := uint64(0); obIndex < obPhnum; obIndex++ {
+		obHeader := obPayload[obPhoff+obIndex*obPhentsize:]
+
+		switch obBinary.LittleEndian.Uint32(obHeader) {
+		case obPtInterp:
+			return 0, 0, obMapping{}, obErrors.New("dynamic binaries are not supported")
+		case obPtPhdr:
+			obPhdr = uintptr(obBinary.LittleEndian.Uint64(obHeader[16:]))
+		case obPtLoad:
+			obOffset := obBinary.LittleEndian.Uint64(obHeader[8:])
+			obVaddr := uintptr(obBinary.LittleEndian.Uint64(obHeader[16:]))
+			obFileSize := obBinary.LittleEndian.Uint64(obHeader[32:])
+			obMemSize := uintptr(obBinary.LittleEndian.Uint64(obHeader[40:]))
+
+			if obOffset+obFileSize > uint64(len(obPayload)) {
+				return 0, 0, obMapping{}, obErrors.New("truncated segment")
+			}
+
+			// the program headers are in the first segment holding them
+			if obPhdr == 0 && obPhoff >= obOffset && obPhoff < obOffset+obFileSize {
+				obPhdr = obVaddr + uintptr(obPhoff-obOffset)
+			}
+
+			if obVaddr&^(obPage-1) < obLow {
+				obLow = obVaddr &^ (obPage - 1)
+			}
+
+			if obVaddr+obMemSize > obHigh {
+				obHigh = obVaddr + obMemSize
+			}
+
+			obHeaders = append(obHeaders, obHeader)
+		}
+	}
+
+	if len(obHeaders) == 0 {
+		return 0, 0, obMapping{}, obErrors.New("no loadable segment")
+	}
+
+	// OB_CHECK
+	// reserve the whole image, a static binary exactly where it is linked
+	obHigh = (obHigh + obPage - 1) &^ (obPage - 1)
+	obFlags := uintptr(obSyscall.MAP_PRIVATE | obSyscall.MAP_ANONYMOUS)
+	obAddress := uintptr(0)
+
+	if obType == obEtExec {
+		obFlags |= obMapFixedNoReplace
+		obAddress = obLow
+	}
+
+	obStart, obErr := obMmap(obAddress, obHigh-obLow, obSyscall.PROT_NONE, obFlags)
+	if obErr != nil {
+		return 0, 0, obMapping{}, obErr
+	}
+
+	obImage := obMapping{obStart: obStart, obSize: obHigh - obLow}
+
+	if obType == obEtExec && obStart != obLow {
+		obMunmap(obImage)
+
+		return 0, 0, obMapping{}, obErrors.New("the payload addresses are in use")
+	}
+
+	obBase := obStart - obLow
+
+	// OB_CHECK
+	// copy the segments, what is past their file size stays zero
+	for _, obHeader := range obHeaders {
+		obOffset := obBinary.LittleEndian.Uint64(obHeader[8:])
+		obVaddr := obBase + uintptr(obBinary.LittleEndian.Uint64(obHeader[16:]))
+		obFileSize := obBinary.LittleEndian.Uint64(obHeader[32:])
+		obMemSize := uintptr(obBinary.LittleEndian.Uint64(obHeader[40:]))
+		obSegmentStart := obVaddr &^ (obPage - 1)
+		obSegmentEnd := (obVaddr + obMemSize + obPage - 1) &^ (obPage - 1)
+
+		// OB_SYSCALLS mprotect
+		_, _, obErrno := obSyscall.Syscall(obSyscall.SYS_MPROTECT, obSegmentStart, obSegmentEnd-obSegmentStart,
+			obSyscall.PROT_READ|obSyscall.PROT_WRITE)
+		if obErrno != obSyscall.Errno(0) {
+			obMunmap(obImage)
+
+			return 0, 0, obMapping{}, obErrno
+		}
+
+		obCopyTo(obVaddr, obPayload[obOffset:obOffset+obFileSize])
+	}
+
+	// then give them their own protection
+	for _, obHeader := range obHeaders {
+		obSegmentFlags := obBinary.LittleEndian.Uint32(obHeader[4:])
+		obVaddr := obBase + uintptr(obBinary.LittleEndian.Uint64(obHeader[16:]))
+		obMemSize := uintptr(obBinary.LittleEndian.Uint64(obHeader[40:]))
+		obSegmentStart := obVaddr &^ (obPage - 1)
+		obSegmentEnd := (obVaddr + obMemSize + obPage - 1) &^ (obPage - 1)
+		obProt := uintptr(0)
+
+		if obSegmentFlags&obPfR != 0 {
+			obProt |= obSyscall.PROT_READ
+		}
+
+		if obSegmentFlags&obPfW != 0 {
+			obProt |= obSyscall.PROT_WRITE
+		}
+
+		if obSegmentFlags&obPfX != 0 {
+			obProt |= obSyscall.PROT_EXEC
+		}
+
+		obSyscall.Syscall(obSyscall.SYS_MPROTECT, obSegmentStart, obSegmentEnd-obSegmentStart, obProt)
+	}
+
+	return obBase + obEntry, obBase + obPhdr, obImage, nil
+}
+
+/*
+Build the initial stack of the payload, the one the kernel builds
+for a new program: argc, argv, envp and the auxiliary vector, that is
+the launcher one describing the payload instead.
+Returns the stack pointer and the mapping of the stack.
+*/
+func obUserlandStack(obArgs []string, obEnv []string, obAuxv map[uint64]uint64) (uintptr, obMapping, error) {
+	obStack, obErr := obSyscall.Mmap(-1, 0, obUserlandStackSize,
+		obSyscall.PROT_READ|obSyscall.PROT_WRITE,
+		obSyscall.MAP_PRIVATE|obSyscall.MAP_ANONYMOUS|obSyscall.MAP_STACK)
+	if obErr != nil {
+		return 0, obMapping{}, obErr
+	}
+
+	obBottom := uintptr(obUnsafe.Pointer(&obStack[0]))
+	obStackMapping := obMapping{obStart: obBottom, obSize: uintptr(len(obStack))}
+
+	// the strings go at the top, the pointers to them below
+	obStrings := []byte{}
+	obPointers := []uint64{uint64(len(obArgs))}
+
+	for _, obList := range [][]string{obArgs, obEnv} {
+		for _, obString := range obList {
+			obPointers = append(obPointers, uint64(len(obStrings)))
+			obStrings = append(append(obStrings, obString...), 0)
+		}
+
+		obPointers = append(obPointers, 0)
 	}
 
-	// OB_CHECK
-	/*
-		the aes-256 psk is the sha512_256 sum of the whole executable
-		this is also useful to protect against NOP attacks to the anti-debug
-		features in the binary.
-		This doubles also as anti-tamper measure.
-	*/
-	obPassword := obSHA.Sum512_256(obKey)
-	// OB_CHECK
-	obCipherBlock, _ := obAES.NewCipher(obPassword[:])
+	obStringsStart := (len(obStack) - len(obStrings)) &^ 15
+	copy(obStack[obStringsStart:], obStrings)
 
-	// OB_CHECK
-	obGCM, _ := obCipher.NewGCM(obCipherBlock)
+	// from offsets to addresses, argc and the terminators excluded
+	for obIndex := 1; obIndex < len(obPointers); obIndex++ {
+		if obIndex != len(obArgs)+1 && obIndex != len(obPointers)-1 {
+			obPointers[obIndex] += uint64(obBottom) + uint64(obStringsStart)
+		}
+	}
 
-	// OB_CHECK
-	obSizeNonce := obGCM.NonceSize()
+	// the launcher auxiliary vector, its pointers are still valid in the fork
+	obLauncherAuxv, _ := obReadFile("/proc/self/auxv")
+	for obIndex := 0; obIndex+16 <= len(obLauncherAuxv); obIndex += 16 {
+		obKey := obBinary.LittleEndian.Uint64(obLauncherAuxv[obIndex:])
+		obValue := obBinary.LittleEndian.Uint64(obLauncherAuxv[obIndex+8:])
 
-	// OB_CHECK
-	// decrypt!!!
-	obNonce, obCiphertext := obCiphertext[:obSizeNonce], obCiphertext[obSizeNonce:]
-	obCompressedPlaintext, _ := obGCM.Open(nil, obNonce, obCiphertext, nil)
+		if obKey == 0 {
+			break
+		}
 
+		if obOverride, obFound := obAuxv[obKey]; obFound {
+			obValue = obOverride
+		}
+
+		obPointers = append(obPointers, obKey, obValue)
+	}
+
+	obPointers = append(obPointers, 0, 0)
+
+	// the stack pointer is 16 bytes aligned, pointing to argc
+	obStackStart := (obStringsStart - len(obPointers)*8) &^ 15
+	if obStackStart < 4096 {
+		obSyscall.Munmap(obStack)
+
+		return 0, obMapping{}, obSyscall.E2BIG
+	}
+
+	for obIndex, obPointer := range obPointers {
+		obBinary.LittleEndian.PutUint64(obStack[obStackStart+obIndex*8:], obPointer)
+	}
+
+	return obBottom + uintptr(obStackStart), obStackMapping, nil
+}
+
+/*
+Run the payload without ever creating a file descriptor pointing to it:
+map it in the launcher memory, fork, and jump to its entry point in
+the fork, see pakkero.ExecModeUserland. Static payloads only, exit with
+obExitCannotExec on the other ones, there is no fallback to the memfd.
+*/
+func obUserlandExecute(obPayload []byte) {
 	// OB_CHECK
-	// the payload was compressed!
-	obBufferPlaintext := obBytes.NewReader(obCompressedPlaintext)
-	// OB_CHECK
-	obZlibReader, obErr := obZlib.NewReader(obBufferPlaintext)
+	obEntry, obPhdr, obImage, obErr := obUserlandLoad(obPayload)
 	if obErr != nil {
-		obExit()
+		obDebugf("execute: userland load failed: %v\n", obErr) // OB_FEATURE launcherdebug
+		obOS.Exit(obExitCannotExec)
 	}
+
+	obPhnum := obBinary.LittleEndian.Uint16(obPayload[56:])
+	// the payload is mapped now, wipe our copy
+	obWipe(obPayload)
+
+	obArgs := obOS.Args
+	// OB_FEATURE_BEGIN payloadargs
+	obArgs = obPayloadArgs(obOS.Args)
+	// OB_FEATURE_END payloadargs
+
 	// OB_CHECK
-	obPlaintext, _ := obUtilio.ReadAll(obZlibReader)
-	obZlibReader.Close()
-	// OB_CHECK
-	// payload was in b64
-	obPayload, _ := obBase64.StdEncoding.DecodeString(string(obPlaintext))
+	obStackPointer, obStack, obErr := obUserlandStack(obArgs, obPayloadEnviron(), map[uint64]uint64{
+		obAtPhdr:  uint64(obPhdr),
+		obAtPhent: 56,
+		obAtPhnum: uint64(obPhnum),
+		obAtBase:  0,
+		obAtEntry: uint64(obEntry),
+	})
+	if obErr != nil {
+		obDebugf("execute: userland stack failed: %v\n", obErr) // OB_FEATURE launcherdebug
+		obOS.Exit(obExitCannotExec)
+	}
+
+	// OB_FEATURE_BEGIN sched
+	obSetScheduling()
+	// OB_FEATURE_END sched
+	// OB_FEATURE_BEGIN rlimit
+	obSetLimits()
+	// OB_FEATURE_END rlimit
 
 	// OB_CHECK
-	obFDName := ""
-	obFileDescriptor, _, _ := obSyscall.Syscall(obSysMEMFDCreate,
-		uintptr(obUnsafe.Pointer(&obFDName)),
-		uintptr(obCloexec|obAllowSealing), 0)
+	obDebugf("execute: userland jump to %#x\n", obEntry) // OB_FEATURE launcherdebug
+	obPid := obUserlandSpawn(obEntry, obStackPointer)
+
+	// the fork has its own copy
+	obMunmap(obImage)
+	obMunmap(obStack)
+
+	if obPid < 0 {
+		obDebugf("execute: userland fork failed: %v\n", obSyscall.Errno(-obPid)) // OB_FEATURE launcherdebug
+		obOS.Exit(obExitCannotExec)
+	}
+
+	obTimedOut, _ := obWatchdog(obPid) // OB_FEATURE payloadtimeout
+
+	var obStatus obSyscall.WaitStatus
 
 	// OB_CHECK
-	// write payload to FD
-	_, obErr = obSyscall.Write(int(obFileDescriptor), obPayload)
+	for {
+		_, obErr := obSyscall.Wait4(obPid, &obStatus, 0, nil)
+		if obErr != obSyscall.EINTR {
+			break
+		}
+	}
+
+	obDebugf("execute: payload exited: %v\n", obStatus.ExitStatus()) // OB_FEATURE launcherdebug
+	// OB_FEATURE_BEGIN payloadtimeout
+	if obTimedOut() {
+		obOS.Exit(obExitTimeout)
+	}
+	// OB_FEATURE_END payloadtimeout
+	// OB_FEATURE_BEGIN successcodes
+	obSuccessExit(obStatus)
+	// OB_FEATURE_END successcodes
+}
+
+// OB_FEATURE_END userland
+// OB_FEATURE_BEGIN capture
+// streams of the captured output, the first byte of each frame
+const (
+	obCaptureStdout = 1
+	obCaptureStderr = 2
+)
+
+// size of the reads of the pumps, each one is a frame
+const obCaptureChunkSize = 32 * 1024
+
+// chunks the pumps can queue before waiting for the writer
+const obCaptureQueue = 64
+
+type obCaptureChunk struct {
+	obStream byte
+	obData   []byte
+}
+
+// The encrypted file of the captured output.
+type obCaptureLog struct {
+	obFile  *obOS.File
+	obAEAD  obCipher.AEAD
+	obNonce []byte
+}
+
+/*
+Open the file of the captured output, appending so that each run
+adds its own frames, and prepare its cipher.
+Exit with obExitCannotExec if it cannot be opened: the payload
+never runs uncaptured.
+*/
+func obOpenCapture() *obCaptureLog {
+	var obFileDescriptor uintptr
+
+	var obErrno obSyscall.Errno
+
+	obAtFDCWD := -100
+	obPath := obSensitive(nil, "CAPTUREFILE")
+
+	obWithPath(obPath, func(obCPath uintptr) {
+		obFileDescriptor, _, obErrno = obSyscall.Syscall6(obSyscall.SYS_OPENAT,
+			uintptr(obAtFDCWD), obCPath,
+			uintptr(obSyscall.O_WRONLY|obSyscall.O_APPEND|obSyscall.O_CREAT|
+				obSyscall.O_CLOEXEC), 0600, 0, 0)
+	})
+	obWipe(obPath)
+
+	if obErrno != obSyscall.Errno(0) {
+		obDebugf("capture: cannot open the file: %v\n", obErrno) // OB_FEATURE launcherdebug
+		obOS.Exit(obExitCannotExec)
+	}
+
+	obKey := obSensitive(nil, "CAPTUREKEY")
+	obBlock, _ := obAES.NewCipher(obKey)
+	obWipe(obKey)
+
+	obAEAD, _ := obCipher.NewGCM(obBlock)
+	obNonce := make([]byte, obAEAD.NonceSize())
+
+	// a random nonce per run, counting up at each frame
+	obRandom, obErr := obOS.Open("/dev/urandom")
+	if obErr == nil {
+		_, obErr = obIO.ReadFull(obRandom, obNonce)
+		obRandom.Close()
+	}
+
 	if obErr != nil {
-		obExit()
+		obDebugf("capture: no random nonce: %v\n", obErr) // OB_FEATURE launcherdebug
+		obOS.Exit(obExitCannotExec)
+	}
+
+	return &obCaptureLog{
+		obFile:  obOS.NewFile(obFileDescriptor, ""),
+		obAEAD:  obAEAD,
+		obNonce: obNonce,
+	}
+}
+
+// Encrypt a chunk and append its frame to the file.
+func (obLog *obCaptureLog) obWrite(obChunk obCaptureChunk) error {
+	for obIndex := len(obLog.obNonce) - 1; obIndex >= 0; obIndex-- {
+		obLog.obNonce[obIndex]++
+		if obLog.obNonce[obIndex] != 0 {
+			break
+		}
+	}
+
+	obPlain := append([]byte{obChunk.obStream}, obChunk.obData...)
+	obFrame := make([]byte, 4, 4+len(obLog.obNonce)+len(obPlain)+obLog.obAEAD.Overhead())
+	obFrame = append(obFrame, obLog.obNonce...)
+	obFrame = obLog.obAEAD.Seal(obFrame, obLog.obNonce, obPlain, nil)
+	obBinary.BigEndian.PutUint32(obFrame, uint32(len(obFrame)-4))
+	obWipe(obPlain)
+
+	// a single write per frame, frames of concurrent runs do not mix
+	_, obErr := obLog.obFile.Write(obFrame)
+
+	return obErr
+}
+
+/*
+Capture the payload stdout and stderr in the encrypted file, and
+copy them to ours with capturetee.
+A pump per stream reads what the payload writes and queues it for
+a single writer: the pumps only wait when the queue is full, and
+the writer keeps draining it even if the file cannot be written
+anymore, so the payload never blocks on a full pipe for good.
+Returns when both streams are closed and every frame is written.
+*/
+func obCapture(obLog *obCaptureLog, obStdoutIn obIO.Reader, obStderrIn obIO.Reader) {
+	obChunks := make(chan obCaptureChunk, obCaptureQueue)
+	obWriterDone := make(chan struct{})
+
+	go func() {
+		defer close(obWriterDone)
+
+		var obErr error
+
+		for obChunk := range obChunks {
+			if obErr == nil {
+				obErr = obLog.obWrite(obChunk)
+			}
+
+			if obErr != nil {
+				obDebugf("capture: cannot write: %v\n", obErr) // OB_FEATURE launcherdebug
+			}
+
+			obWipe(obChunk.obData)
+		}
+	}()
+
+	obPump := func(obStream byte, obInput obIO.Reader, obTee *obOS.File) {
+		for {
+			obBuffer := make([]byte, obCaptureChunkSize)
+
+			obSize, obErr := obInput.Read(obBuffer)
+			if obSize > 0 {
+				obTee.Write(obBuffer[:obSize]) // OB_FEATURE capturetee
+				obChunks <- obCaptureChunk{obStream: obStream, obData: obBuffer[:obSize]}
+			}
+
+			if obErr != nil {
+				return
+			}
+		}
 	}
 
+	var obWaitGroup obSync.WaitGroup
+
+	obWaitGroup.Add(2)
+
+	go func() {
+		defer obWaitGroup.Done()
+
+		obPump(obCaptureStdout, obStdoutIn, obOS.Stdout)
+	}()
+	go func() {
+		defer obWaitGroup.Done()
+
+		obPump(obCaptureStderr, obStderrIn, obOS.Stderr)
+	}()
+
+	obWaitGroup.Wait()
+	close(obChunks)
+	<-obWriterDone
+	obLog.obFile.Close()
+}
+
+// OB_FEATURE_END capture
+/*
+Write the payload in a memory file descriptor and execute it,
+forwarding arguments and standard streams, or run it with
+obUserlandExecute in the userland exec mode.
+*/
+func obExecute(obPayload []byte) {
+	// OB_FEATURE_BEGIN userland
+	obUserlandExecute(obPayload)
+	// OB_FEATURE_END userland
+	// OB_FEATURE_BEGIN !userland
 	// OB_CHECK
-	// make it immutable
-	_, _, obErr = obSyscall.Syscall(obSysFCNTL,
-		obFileDescriptor,
-		uintptr(1024+9),
-		uintptr(obSealAll))
-	if obErr != obSyscall.Errno(0) {
+	obFileDescriptor := obExtract(obPayload)
+	obDebugf("execute: extraction by memfd, fd %d\n", obFileDescriptor) // OB_FEATURE launcherdebug
+
+	// OB_FEATURE_BEGIN antidump
+	obVerifySeals(obFileDescriptor)
+	// the payload is in the memfd now, wipe our copy
+	obWipe(obPayload)
+	// OB_FEATURE_END antidump
+	// OB_FEATURE_BEGIN antidumpreopen
+	// keep only a read-only, close-on-exec reference to the memfd
+	obReadOnlyFD, obErr := obSyscall.Open("/proc/self/fd/"+
+		obStrconv.Itoa(int(obFileDescriptor)),
+		obSyscall.O_RDONLY|obSyscall.O_CLOEXEC, 0)
+	if obErr != nil {
 		obExit()
 	}
 
+	// the interpreter of a script could not open a close-on-exec
+	// descriptor, fail like an exec would instead of running it
+	obMagic := make([]byte, 4)
+	obRead, obErr := obSyscall.Read(obReadOnlyFD, obMagic)
+	if obErr != nil || obRead != len(obMagic) || string(obMagic) != "\x7fELF" {
+		obDebugf("execute: the payload is not an ELF\n") // OB_FEATURE launcherdebug
+		obOS.Exit(obExitCannotExec)
+	}
+
+	obSyscall.Close(int(obFileDescriptor))
+	obFileDescriptor = uintptr(obReadOnlyFD)
+	// OB_FEATURE_END antidumpreopen
+
 	// OB_CHECK
 	obFDPath := "/proc/" +
 		obStrconv.Itoa(obOS.Getpid()) +
 		"/fd/" +
 		obStrconv.Itoa(int(obFileDescriptor))
+	// OB_FEATURE_BEGIN antidump
+	// our /proc entries are root owned now, the child has to
+	// use its own inherited copy of the descriptor.
+	obFDPath = "/proc/self/fd/" + obStrconv.Itoa(int(obFileDescriptor))
+	// OB_FEATURE_END antidump
+	// OB_FEATURE_BEGIN secretargs
+	obSecretFile := obSecretArgs()
+	// OB_FEATURE_END secretargs
 	// OB_CHECK
-	obCommand := obExec.Command(obFDPath)
-	// OB_CHECK
-	obCommand.Args = obOS.Args
-	obCommand.Stdin = obOS.Stdin
+	obNewCommand := func() *obExec.Cmd {
+		obCommand := obExec.Command(obFDPath)
+		obCommand.Args = obOS.Args
+		// OB_FEATURE_BEGIN payloadargs
+		obCommand.Args = obPayloadArgs(obOS.Args)
+		// OB_FEATURE_END payloadargs
+		// OB_FEATURE_BEGIN secretargs
+		obCommand.ExtraFiles = append(obCommand.ExtraFiles, obSecretFile)
+		// OB_FEATURE_END secretargs
+		obCommand.Env = obPayloadEnviron()
+		// OB_FEATURE_BEGIN binddeps
+		obBindDependencies(obCommand)
+		// OB_FEATURE_END binddeps
+		// OB_FEATURE_BEGIN launchtoken
+		obTokenPipe(obCommand)
+		// OB_FEATURE_END launchtoken
+		obCommand.SysProcAttr = obPayloadGroup() // OB_FEATURE payloadtimeout
+
+		return obCommand
+	}
+	// OB_FEATURE_BEGIN sched
+	obSetScheduling()
+	// OB_FEATURE_END sched
+	// OB_FEATURE_BEGIN rlimit
+	obSetLimits()
+	// OB_FEATURE_END rlimit
+	// OB_FEATURE_BEGIN daemonize
+	obDaemonize(obNewCommand, obFileDescriptor)
+	// OB_FEATURE_END daemonize
+	// OB_FEATURE_BEGIN init
+	obRunAsInit(obNewCommand, obFileDescriptor)
+	// OB_FEATURE_END init
+
+	// OB_FEATURE_BEGIN capture
+	obCaptureFile := obOpenCapture()
+	// OB_FEATURE_END capture
+
+	var obStdoutIn, obStderrIn obIO.ReadCloser
+
 	// OB_CHECK
-	obStdoutIn, _ := obCommand.StdoutPipe()
-	defer obStdoutIn.Close()
+	obDebugf("execute: running %s\n", obFDPath) // OB_FEATURE launcherdebug
+	obCommand := obStart(func() *obExec.Cmd {
+		obCommand := obNewCommand()
+		obCommand.Stdin = obOS.Stdin
+		obStdoutIn, _ = obCommand.StdoutPipe()
+		obStderrIn, _ = obCommand.StderrPipe()
+
+		return obCommand
+	})
 
-	obStderrIn, _ := obCommand.StderrPipe()
+	defer obStdoutIn.Close()
 	defer obStderrIn.Close()
 
+	// closed once the payload timed out, never without a timeout
+	var obExpired <-chan struct{}
+
+	obWriteToken(obCommand.Process.Pid)                        // OB_FEATURE launchtoken
+	obTimedOut, obExpired := obWatchdog(obCommand.Process.Pid) // OB_FEATURE payloadtimeout
+
+	// OB_FEATURE_BEGIN antidumpreopen
+	// the payload is running, the only reference left is the kernel's one
+	obSyscall.Close(int(obFileDescriptor))
+	// OB_FEATURE_END antidumpreopen
+
+	obOutputDone := make(chan struct{})
+
+	go func() {
+		defer close(obOutputDone)
+
+		// OB_FEATURE_BEGIN capture
+		obCapture(obCaptureFile, obStdoutIn, obStderrIn)
+		// OB_FEATURE_END capture
+		// OB_FEATURE_BEGIN !capture
+		var obWaitGroup obSync.WaitGroup
+
+		obWaitGroup.Add(2)
+
+		obStdoutScan := obBufio.NewScanner(obStdoutIn)
+		obStderrScan := obBufio.NewScanner(obStderrIn)
+		// OB_CHECK
+		// async fetch stdout
+		go func() {
+			defer obWaitGroup.Done()
+
+			for obStdoutScan.Scan() {
+				println(obStdoutScan.Text())
+			}
+		}()
+		// OB_CHECK
+		// async fetch stderr
+		go func() {
+			defer obWaitGroup.Done()
+
+			for obStderrScan.Scan() {
+				println(obStderrScan.Text())
+			}
+		}()
+		// OB_CHECK
+		obWaitGroup.Wait()
+		// OB_FEATURE_END !capture
+	}()
+
+	select {
+	case <-obOutputDone:
+	case <-obExpired:
+		obDebugf("timeout: not waiting for the output\n") // OB_FEATURE launcherdebug
+	}
+
+	// OB_FEATURE_BEGIN !launcherdebug
+	obCommand.Wait()
+	// OB_FEATURE_END !launcherdebug
+	obDebugf("execute: payload exited: %v\n", obCommand.Wait()) // OB_FEATURE launcherdebug
+	// OB_FEATURE_BEGIN execdiag
+	if obCommand.ProcessState.ExitCode() == obExitNotFound {
+		obDiagnoseExec(nil)
+	}
+	// OB_FEATURE_END execdiag
+	// OB_FEATURE_BEGIN bundlelibs
+	obOS.RemoveAll(obLibsDir)
+	// OB_FEATURE_END bundlelibs
+	// OB_FEATURE_BEGIN payloadtimeout
+	if obTimedOut() {
+		obOS.Exit(obExitTimeout)
+	}
+	// OB_FEATURE_END payloadtimeout
+	// OB_FEATURE_BEGIN successcodes
+	obSuccessExit(obCommand.ProcessState.Sys().(obSyscall.WaitStatus))
+	// OB_FEATURE_END successcodes
+	// OB_FEATURE_END !userland
+}
+
+// OB_FEATURE_BEGIN prologue
+/*
+Decrypt the prologue and run it to completion before the payload, from
+a memfd, with our stdio and the payload environment but none of the
+payload arguments: unless it exits 0 we exit with its status, 128 plus
+the signal if killed, and the payload is never decrypted.
+*/
+func obRunPrologue(obFile *obOS.File) {
+	obPrologueStart := obSensitiveInt(obSensitive(nil, "PROLOGUESTART"))
+	obPrologueSize := obSensitiveInt(obSensitive(nil, "PROLOGUESIZE"))
+	obPrologue := obDecrypt(obFile, obPrologueStart, obPrologueStart, obPrologueSize, false)
+
 	// OB_CHECK
-	obErr = obCommand.Start()
-	if obErr != nil {
-		obExit()
+	obFileDescriptor := obExtract(obPrologue)
+	obWipe(obPrologue)
+
+	obFDPath := "/proc/" +
+		obStrconv.Itoa(obOS.Getpid()) +
+		"/fd/" +
+		obStrconv.Itoa(int(obFileDescriptor))
+	// OB_FEATURE_BEGIN antidump
+	obFDPath = "/proc/self/fd/" + obStrconv.Itoa(int(obFileDescriptor))
+	// OB_FEATURE_END antidump
+
+	obDebugf("prologue: running %s\n", obFDPath) // OB_FEATURE launcherdebug
+	obCommand := obStart(func() *obExec.Cmd {
+		obCommand := obExec.Command(obFDPath)
+		obCommand.Args = obOS.Args[:1]
+		obCommand.Env = obPayloadEnviron()
+		obCommand.Stdin = obOS.Stdin
+		obCommand.Stdout = obOS.Stdout
+		obCommand.Stderr = obOS.Stderr
+
+		return obCommand
+	})
+
+	obCommand.Wait()
+	obSyscall.Close(int(obFileDescriptor))
+
+	obStatus := obCommand.ProcessState.Sys().(obSyscall.WaitStatus)
+	obDebugf("prologue: exited with %v\n", obCommand.ProcessState) // OB_FEATURE launcherdebug
+
+	if obStatus.Signaled() {
+		obOS.Exit(128 + int(obStatus.Signal()))
 	}
 
-	var obWaitGroup obSync.WaitGroup
+	if obStatus.ExitStatus() != 0 {
+		obOS.Exit(obStatus.ExitStatus())
+	}
+}
 
-	obWaitGroup.Add(2)
+// OB_FEATURE_END prologue
 
-	obStdoutScan := obBufio.NewScanner(obStdoutIn)
-	obStderrScan := obBufio.NewScanner(obStderrIn)
+func obLauncher() {
 	// OB_CHECK
-	// async fetch stdout
-	go func() {
-		defer obWaitGroup.Done()
+	obNameFile, _ := obOS.Executable()
+
+	obFile, _ := obOS.Open(obNameFile)
+	defer obFile.Close()
+
+	// OB_FEATURE_BEGIN bundlelibs
+	// the decoy may need them too
+	obExtractLibs(obFile)
+	// OB_FEATURE_END bundlelibs
+
+	// OB_FEATURE_BEGIN decoy
+	// a dependency asked to degrade, run the decoy instead
+	if obDegraded() {
+		obDebugf("launcher: degraded, running the decoy\n") // OB_FEATURE launcherdebug
+		obDecoyStart := obSensitiveInt(obSensitive(nil, "DECOYSTART"))
+		obDecoySize := obSensitiveInt(obSensitive(nil, "DECOYSIZE"))
+		obExecute(obDecrypt(obFile, obDecoyStart, obDecoyStart, obDecoySize, false))
+
+		return
+	}
+	// OB_FEATURE_END decoy
+
+	// OB_FEATURE_BEGIN prologue
+	obRunPrologue(obFile)
+	// OB_FEATURE_END prologue
 
-		for obStdoutScan.Scan() {
-			println(obStdoutScan.Text())
-		}
-	}()
 	// OB_CHECK
-	// async fetch stderr
-	go func() {
-		defer obWaitGroup.Done()
+	obOffset := obSensitiveInt(obSensitive(nil, "9999999"))
+	obStatsFile, _ := obFile.Stat()
+
+	// calculate final padding
+	obArrayFinalPadding := make([]byte, obBinary.MaxVarintLen64)
+	obByteFinalPadding := obArrayFinalPadding[:obBinary.PutVarint(obArrayFinalPadding, obOffset)]
+
+	for obIndex := range obByteFinalPadding {
+		obByteFinalPadding[obIndex] = obByteReverse(obByteFinalPadding[obIndex])
+	}
+
+	obFinalPadding, _ := obBinary.Varint(obByteFinalPadding)
+
+	// make it positive!
+	if obFinalPadding < 0 {
+		obFinalPadding *= -1
+	}
 
-		for obStderrScan.Scan() {
-			println(obStderrScan.Text())
-		}
-	}()
 	// OB_CHECK
-	obWaitGroup.Wait()
+	obSizeFile := obStatsFile.Size() - obOffset - obFinalPadding
+	obSizeFile -= 64 // OB_FEATURE signed
+	if obSizeFile <= 0 {
+		obDebugf("launcher: no payload after offset %d\n", obOffset) // OB_FEATURE launcherdebug
+		obExit()
+	}
+
+	// OB_CHECK
+	obDiagnosePayload = true // OB_FEATURE execdiag
+	obExecute(obDecrypt(obFile, obOffset, obOffset, obSizeFile, true))
+}
+
+/*
+Syscalls the launcher can make, see pakkero.LauncherSyscalls: the ones
+of the go runtime and of the os, os/exec and os/signal packages are
+here, each feature marks its own next to the code making them.
+*/
+// OB_SYSCALLS read,write,close,openat,lseek,pread64,fstat,newfstatat,readlinkat,getdents64
+// OB_SYSCALLS faccessat,faccessat2,fcntl,dup3,pipe2,memfd_create,getrandom,uname
+// OB_SYSCALLS mmap,munmap,madvise,mincore,brk,futex,nanosleep,clock_gettime,sched_yield
+// OB_SYSCALLS sched_getaffinity,restart_syscall,setitimer,timer_create,timer_settime,timer_delete
+// OB_SYSCALLS rt_sigaction,rt_sigprocmask,rt_sigreturn,sigaltstack,tgkill,kill,getpid,gettid,getppid
+// OB_SYSCALLS epoll_create1,epoll_ctl,epoll_pwait,epoll_pwait2,eventfd2,prlimit64
+// OB_SYSCALLS clone,clone3,execve,wait4,waitid,pidfd_open,pidfd_send_signal,exit,exit_group
+// OB_FEATURE_BEGIN amd64
+// OB_SYSCALLS arch_prctl
+// the older go runtimes make the legacy ones too
+// OB_SYSCALLS open,access,pipe,dup2,epoll_create,epoll_wait
+// OB_FEATURE_END amd64
+
+// OB_FEATURE_BEGIN seccomp
+// OB_SYSCALLS prctl,seccomp
+type obSockFilter struct {
+	obCode uint16
+	obJt   uint8
+	obJf   uint8
+	obK    uint32
 }
 
+type obSockFprog struct {
+	obLen    uint16
+	obFilter *obSockFilter
+}
+
+const (
+	// prctl option to never gain privileges on exec, needed by seccomp
+	obSetNoNewPrivs = 38
+	// seccomp(2) SECCOMP_SET_MODE_FILTER, SECCOMP_FILTER_FLAG_TSYNC
+	obSeccompSetModeFilter   = 1
+	obSeccompFilterFlagTsync = 1
+	// filter results: allow, kill the process, send SIGSYS
+	obSeccompRetAllow = 0x7fff0000
+	obSeccompRetKill  = 0x80000000
+	obSeccompRetTrap  = 0x00030000
+	// BPF_LD|BPF_W|BPF_ABS, BPF_JMP|BPF_JEQ|BPF_K, BPF_RET|BPF_K
+	obBPFLoad   = 0x20
+	obBPFJumpEq = 0x15
+	obBPFReturn = 0x06
+)
+
+/*
+Audit architecture and raw syscall number of seccomp, only amd64 and
+arm64 launchers have the filter.
+*/
+func obSeccompArch() (obArch uint32, obSeccomp uintptr) {
+	obArch, obSeccomp = 0xc000003e, 317 // OB_FEATURE amd64
+	obArch, obSeccomp = 0xc00000b7, 277 // OB_FEATURE arm64
+
+	return obArch, obSeccomp
+}
+
+/*
+Confine ourselves, every thread, to the syscalls given at packing time,
+before anything else: any other one kills the process. The filter is
+inherited by the payload, that can only make the syscalls allowed here.
+Fail closed, we never run without it.
+*/
+func obSeccompSelf() {
+	obRuntime.LockOSThread()
+	defer obRuntime.UnlockOSThread()
+
+	obAudit, obSysSeccomp := obSeccompArch()
+	obDeny := uint32(obSeccompRetKill)
+	// SIGSYS makes the go runtime print where the syscall was made
+	obDeny = obSeccompRetTrap // OB_FEATURE launcherdebug
+
+	// load the architecture, then the syscall number, of seccomp_data
+	obFilter := []obSockFilter{
+		{obBPFLoad, 0, 0, 4},
+		{obBPFJumpEq, 1, 0, obAudit},
+		{obBPFReturn, 0, 0, obDeny},
+		{obBPFLoad, 0, 0, 0},
+	}
+
+	for _, obItem := range obStrings.Split("SECCOMPSYSCALLS", ",") {
+		obNumber, _ := obStrconv.ParseUint(obItem, 10, 32)
+		obFilter = append(obFilter,
+			obSockFilter{obBPFJumpEq, 0, 1, uint32(obNumber)},
+			obSockFilter{obBPFReturn, 0, 0, obSeccompRetAllow})
+	}
+
+	obFilter = append(obFilter, obSockFilter{obBPFReturn, 0, 0, obDeny})
+	obProgram := obSockFprog{obLen: uint16(len(obFilter)), obFilter: &obFilter[0]}
+
+	_, _, obErrno := obSyscall.RawSyscall6(obSyscall.SYS_PRCTL,
+		obSetNoNewPrivs, 1, 0, 0, 0, 0)
+	if obErrno != 0 {
+		obDebugf("seccomp: no_new_privs failed: %v\n", obErrno) // OB_FEATURE launcherdebug
+		obOS.Exit(obExitCannotExec)
+	}
+
+	// with TSYNC a thread that cannot be synchronized is returned
+	obThread, _, obErrno := obSyscall.RawSyscall(obSysSeccomp,
+		obSeccompSetModeFilter, obSeccompFilterFlagTsync, uintptr(obUnsafe.Pointer(&obProgram)))
+	if obErrno != 0 || obThread != 0 {
+		obDebugf("seccomp: filter failed: %v, thread %d\n", obErrno, obThread) // OB_FEATURE launcherdebug
+		obOS.Exit(obExitCannotExec)
+	}
+
+	obDebugf("seccomp: %d syscalls allowed\n", (len(obFilter)-5)/2) // OB_FEATURE launcherdebug
+}
+
+// OB_FEATURE_END seccomp
+
 func main() {
+	// OB_FEATURE_BEGIN seccomp
+	obSeccompSelf()
+	// OB_FEATURE_END seccomp
+
+	// OB_FEATURE_BEGIN !launcherdebug
+	obPinRuntime()
+	// OB_FEATURE_END !launcherdebug
+
 	// Prepare to intercept SIGTRAP
 	obChannel := make(chan obOS.Signal, 1)
 	obSignal.Notify(obChannel, obSyscall.SIGTRAP, obSyscall.SIGILL)
 
 	go obSigTrap(obChannel)
 
+	obDebugf("PAKKERO LAUNCHER DEBUG BUILD, do not ship it\n") // OB_FEATURE launcherdebug
+
+	// OB_FEATURE_BEGIN health
+	obHealthCheck()
+	// OB_FEATURE_END health
+
+	// OB_FEATURE_BEGIN antidump
+	obDisableDump()
+	// OB_FEATURE_END antidump
+
+	// OB_FEATURE_BEGIN override
+	obLoadOverride()
+	// OB_FEATURE_END override
+
 	// obPtraceDetect()
 	// OB_CHECK
 	obDependencyCheck()
+	obDebugf("check dependency: passed\n") // OB_FEATURE launcherdebug
 	// OB_CHECK
 	obEnvArgsDetect()
+	obDebugf("check env-args: passed\n") // OB_FEATURE launcherdebug
 	// OB_CHECK
 	obParentTracerDetect()
+	obDebugf("check parent-tracer: passed\n") // OB_FEATURE launcherdebug
 	// OB_CHECK
 	obParentCmdLineDetect()
+	obDebugf("check parent-cmdline: passed\n") // OB_FEATURE launcherdebug
 	// OB_CHECK
 	obEnvDetect()
+	obDebugf("check env: passed\n") // OB_FEATURE launcherdebug
 	// OB_CHECK
 	obEnvParentDetect()
+	obDebugf("check env-parent: passed\n") // OB_FEATURE launcherdebug
 	// OB_CHECK
 	obLdPreloadDetect()
+	obDebugf("check ld-preload: passed\n") // OB_FEATURE launcherdebug
 	// OB_CHECK
 	obParentDetect()
+	obDebugf("check parent-stat: passed\n") // OB_FEATURE launcherdebug
+	// OB_FEATURE_BEGIN arming
+	obArming()
+	obDebugf("arming: armed\n") // OB_FEATURE launcherdebug
+	// OB_FEATURE_END arming
+	// OB_FEATURE_BEGIN license
+	obLicenseCheck()
+	obDebugf("license: valid\n") // OB_FEATURE launcherdebug
+	// OB_FEATURE_END license
+	// OB_FEATURE_BEGIN hostlist
+	// OB_CHECK
+	obHostCheck()
+	obDebugf("check hostname: passed\n") // OB_FEATURE launcherdebug
+	// OB_FEATURE_END hostlist
+	// OB_FEATURE_BEGIN registration
+	// OB_CHECK
+	obRegistrationCheck()
+	obDebugf("check registration: done\n") // OB_FEATURE launcherdebug
+	// OB_FEATURE_END registration
+	// OB_FEATURE_BEGIN attestsuccess
+	obAttest(0)
+	// OB_FEATURE_END attestsuccess
 	// OB_CHECK
 	obLauncher()
 }
@@ -0,0 +1,12 @@
+// Placeholders of the launcher assembly, so that the template builds and
+// vets as a package: the packer writes the real one next to the launcher
+// it builds, see launcherAsmParts.
+
+// func obCopyTo(to uintptr, from []byte)
+TEXT ·obCopyTo(SB), $0-32
+	RET
+
+// func obUserlandSpawn(entry uintptr, stack uintptr) (pid int)
+TEXT ·obUserlandSpawn(SB), $0-24
+	MOVQ $0, pid+16(FP)
+	RET
@@ -0,0 +1,141 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Target architecture library
+*/
+package pakkero
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+/*
+launcherArchs are the architectures with a syscall table in the
+launcher, with the binutils prefix of their cross tools.
+*/
+var launcherArchs = map[string]string{
+	"386":     "i686-linux-gnu-",
+	"amd64":   "x86_64-linux-gnu-",
+	"arm":     "arm-linux-gnueabihf-",
+	"arm64":   "aarch64-linux-gnu-",
+	"riscv64": "riscv64-linux-gnu-",
+}
+
+// architectures UPX cannot compress
+var uncompressibleArchs = []string{"riscv64"}
+
+// foreign architectures whose binaries the host strip handles
+var hostStripArchs = map[string][]string{
+	"amd64": {"386"},
+}
+
+// 32 bit architectures, whose launcher has to fit the payload in memory
+var archs32 = []string{"386", "arm"}
+
+/*
+maxMemory32 is the memory a 32 bit launcher can use to load the payload:
+the address space is 4GB at most, less with a 32 bit kernel, and it has
+to hold the go runtime and the mappings too.
+*/
+const maxMemory32 = 1 << 30
+
+// SupportedArchs returns the architectures the launcher can be built for
+func SupportedArchs() []string {
+	result := []string{}
+	for arch := range launcherArchs {
+		result = append(result, arch)
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+/*
+CheckArch returns an error if the launcher has no syscall table
+for the architecture, or if it cannot be compressed when requested.
+*/
+func CheckArch(arch string, compress bool) error {
+	if _, ok := launcherArchs[arch]; !ok {
+		return fmt.Errorf("unsupported architecture %q, architectures are: %s",
+			arch, strings.Join(SupportedArchs(), ", "))
+	}
+
+	if compress && Contains(uncompressibleArchs, arch) {
+		return fmt.Errorf("UPX cannot compress %s launchers", arch)
+	}
+
+	return nil
+}
+
+/*
+PinCrossStrip will pin strip to the binutils cross one of a foreign
+architecture, the host strip usually cannot handle its binaries.
+Out of linux the host strip is not an ELF one, the cross one is looked
+for with the host architecture too, and it is not needed: without it
+the host strip is used if it handles ELF, else skipped.
+A strip pinned by the user is kept.
+*/
+func PinCrossStrip(arch string) error {
+	if _, pinned := ToolPaths["strip"]; pinned {
+		return nil
+	}
+
+	linux := runtime.GOOS == "linux"
+
+	if linux && (arch == runtime.GOARCH || Contains(hostStripArchs[runtime.GOARCH], arch)) {
+		return nil
+	}
+
+	crossStrip := launcherArchs[arch] + "strip"
+
+	path, err := exec.LookPath(crossStrip)
+	if err != nil && !linux {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("packing for %s needs a strip supporting it, install %s or "+
+			"pin one with -tool strip=/path", arch, crossStrip)
+	}
+
+	return PinTool("strip", path)
+}
+
+/*
+CheckArchMemory returns an error if a 32 bit launcher cannot load the
+payload: it reads everything up to the offset, to derive the key, then
+keeps at once the ciphertext, the decompressed payload, in base64,
+and the decoded one. The ciphertext is at most as big as the base64.
+*/
+func CheckArchMemory(arch string, offset int64, payloadSize int64) error {
+	if !Contains(archs32, arch) {
+		return nil
+	}
+
+	encoded := (payloadSize + 2) / 3 * 4
+	needed := offset + 2*encoded + payloadSize
+
+	if needed > maxMemory32 {
+		return fmt.Errorf("a %s launcher needs up to %dMB to load this payload at this offset, "+
+			"more than the %dMB a 32 bit process can use", arch, needed>>20, maxMemory32>>20)
+	}
+
+	return nil
+}
+
+/*
+archFeatures returns the launcher features of the architecture,
+each one keeps the syscall table of its architecture.
+*/
+func archFeatures(arch string) map[string]bool {
+	result := map[string]bool{}
+	for name := range launcherArchs {
+		result[name] = name == arch
+	}
+
+	return result
+}
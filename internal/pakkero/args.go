@@ -0,0 +1,46 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Payload arguments library
+*/
+package pakkero
+
+import (
+	"errors"
+	"strings"
+)
+
+const payloadArgsPlaceholder = `"PAYLOADARGS"`
+const secretArgsPlaceholder = `"SECRETARGS"`
+
+/*
+RegisterPayloadArgs will register in the launcher the arguments given
+at packing time: the plain ones are placed in the payload argv, the
+secret ones are passed in a memfd, whose path is in OB_SECRET_ARGS.
+Each argument is NUL terminated, like in /proc/<pid>/cmdline.
+*/
+func RegisterPayloadArgs(args []string, secretArgs []string) error {
+	for name, list := range map[string][]string{
+		payloadArgsPlaceholder: args,
+		secretArgsPlaceholder:  secretArgs,
+	} {
+		if len(list) == 0 {
+			continue
+		}
+
+		joined := ""
+
+		for _, arg := range list {
+			if strings.Contains(arg, "\x00") {
+				return errors.New("payload arguments cannot contain NUL bytes")
+			}
+
+			joined += arg + "\x00"
+		}
+
+		Secrets[name] = []string{joined, GenerateTyposquatName()}
+	}
+
+	markSensitive("a -secret-arg", secretArgs...)
+
+	return nil
+}
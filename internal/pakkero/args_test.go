@@ -0,0 +1,80 @@
+package pakkero
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+/*
+The payload gets the packed arguments before the runtime ones in its
+argv, and the secret ones only in the memfd of OB_SECRET_ARGS: they are
+nowhere in its /proc/self/cmdline.
+*/
+func TestPayloadSecretArgs(t *testing.T) {
+	if _, ok := launcherArchs[runtime.GOARCH]; !ok {
+		t.Skipf("the launcher has no syscall table for %s", runtime.GOARCH)
+	}
+
+	keepSecrets(t)
+
+	err := RegisterPayloadArgs([]string{"--verbose"}, []string{"token=s3cret", "--key=k3y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decls := []string{}
+	for _, name := range []string{"obPayloadArgs", "obSecretArgs", "obExtract", "obExtractOnce", "obRetry",
+		"obWipe", "obSensitive", "obLauncherEnv", "obSysMEMFDCreate", "obSyscallTable", "obCloexec", "obExitExtract"} {
+		decls = append(decls, templateDecl(t, name))
+	}
+
+	program := StripFeatures(`package main
+
+import (
+	obBytes "bytes"
+	obErrors "errors"
+	"fmt"
+	obUtilio "io/ioutil"
+	obOS "os"
+	obExec "os/exec"
+	obStrconv "strconv"
+	obStrings "strings"
+	obSyscall "syscall"
+	obTime "time"
+	obUnsafe "unsafe"
+)
+
+`+strings.Join(decls, "\n\n")+`
+
+func main() {
+	if obOS.Getenv("ARGS_CHILD") != "" {
+		obCmdline, _ := obUtilio.ReadFile("/proc/self/cmdline")
+		obSecret, _ := obUtilio.ReadFile(obOS.Getenv("OB_SECRET_ARGS"))
+		fmt.Printf("%q %q %t\n", obOS.Args[1:], obSecret, obStrings.Contains(string(obCmdline), "s3cret"))
+
+		return
+	}
+
+	obCommand := obExec.Command("/proc/self/exe")
+	obCommand.Args = obPayloadArgs([]string{"/proc/self/exe", "runtime"})
+	obCommand.ExtraFiles = []*obOS.File{obSecretArgs()}
+	obCommand.Env = append(append(obOS.Environ(), obLauncherEnv...), "ARGS_CHILD=1")
+	obCommand.Stdout = obOS.Stdout
+	obCommand.Run()
+}
+`, archFeatures(runtime.GOARCH))
+
+	// the arguments are resolved by the string obfuscation in a pack
+	for _, placeholder := range []string{payloadArgsPlaceholder, secretArgsPlaceholder} {
+		program = strings.ReplaceAll(program, placeholder, strconv.Quote(Secrets[placeholder][0]))
+	}
+
+	output := runProgram(t, program)
+
+	expected := "[\"--verbose\" \"runtime\"] \"token=s3cret\\x00--key=k3y\\x00\" false\n"
+	if output != expected {
+		t.Errorf("payload arguments: %q, expected %q", output, expected)
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Arming library
+*/
+package pakkero
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const armAfterPlaceholder = `"ARMAFTER"`
+const windowStartPlaceholder = `"WINDOWSTART"`
+const windowEndPlaceholder = `"WINDOWEND"`
+const triggerFilePlaceholder = `"TRIGGERFILE"`
+
+// accepted layouts for the arm-after date, always UTC
+var armAfterLayouts = []string{
+	"2006-01-02T15:04Z",
+	"2006-01-02T15:04:05Z",
+	"2006-01-02",
+}
+
+/*
+ParseArmAfter will parse an UTC date in the form 2006-01-02T15:04Z
+(seconds and time are optional) and return it as unix time.
+*/
+func ParseArmAfter(input string) (int64, error) {
+	for _, layout := range armAfterLayouts {
+		date, err := time.Parse(layout, input)
+		if err == nil {
+			return date.Unix(), nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid arm-after date %q, use UTC like 2006-01-02T15:04Z", input)
+}
+
+/*
+ParseRunWindow will parse a window in the form HH:MM-HH:MM and return
+the start and end as minutes of the day.
+A window can wrap around midnight (eg: 22:00-06:00).
+*/
+func ParseRunWindow(input string) (int64, int64, error) {
+	bounds := strings.Split(input, "-")
+	if len(bounds) != 2 {
+		return 0, 0, fmt.Errorf("invalid run-window %q, use HH:MM-HH:MM", input)
+	}
+
+	minutes := []int64{}
+
+	for _, v := range bounds {
+		clock, err := time.Parse("15:04", v)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid run-window %q, use HH:MM-HH:MM", input)
+		}
+
+		minutes = append(minutes, int64(clock.Hour()*60+clock.Minute()))
+	}
+
+	if minutes[0] == minutes[1] {
+		return 0, 0, fmt.Errorf("invalid run-window %q, start and end are the same", input)
+	}
+
+	return minutes[0], minutes[1], nil
+}
+
+/*
+InRunWindow will check if a minute of the day is inside the window,
+the start is inclusive and the end is exclusive.
+This is the same logic compiled in the launcher.
+*/
+func InRunWindow(minute, start, end int64) bool {
+	if start < end {
+		return minute >= start && minute < end
+	}
+	// window wraps around midnight
+	return minute >= start || minute < end
+}
+
+/*
+RegisterArming will validate the arming conditions and add them to the
+secrets, so that they will be embedded obfuscated in the launcher.
+*/
+func RegisterArming(opts Options) error {
+	if opts.ArmAfter != "" {
+		armAfter, err := ParseArmAfter(opts.ArmAfter)
+		if err != nil {
+			return err
+		}
+
+		Secrets[armAfterPlaceholder] = []string{fmt.Sprintf("%d", armAfter),
+			GenerateTyposquatName()}
+	}
+
+	if opts.RunWindow != "" {
+		start, end, err := ParseRunWindow(opts.RunWindow)
+		if err != nil {
+			return err
+		}
+
+		Secrets[windowStartPlaceholder] = []string{fmt.Sprintf("%d", start),
+			GenerateTyposquatName()}
+		Secrets[windowEndPlaceholder] = []string{fmt.Sprintf("%d", end),
+			GenerateTyposquatName()}
+	}
+
+	if opts.TriggerFile != "" {
+		if !IsTargetAbs(opts.TriggerFile) {
+			return fmt.Errorf("invalid trigger-file %q, use absolute paths", opts.TriggerFile)
+		}
+
+		Secrets[triggerFilePlaceholder] = []string{opts.TriggerFile,
+			GenerateTyposquatName()}
+	}
+
+	if opts.WaitForArming && !opts.armingEnabled() {
+		return errors.New("wait-for-arming needs at least one arming condition")
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package pakkero
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// windows as start and end minutes of the day, the last ones wrap around midnight
+var testRunWindows = [][2]int64{{0, 60}, {540, 1020}, {0, 1439}, {1320, 360}, {1439, 0}, {60, 0}}
+
+func TestInRunWindow(t *testing.T) {
+	tests := []struct {
+		window   string
+		minute   int64
+		expected bool
+	}{
+		{"09:00-17:00", 9 * 60, true},
+		{"09:00-17:00", 17*60 - 1, true},
+		{"09:00-17:00", 17 * 60, false},
+		{"09:00-17:00", 9*60 - 1, false},
+		{"22:00-06:00", 23 * 60, true},
+		{"22:00-06:00", 0, true},
+		{"22:00-06:00", 6*60 - 1, true},
+		{"22:00-06:00", 6 * 60, false},
+		{"22:00-06:00", 12 * 60, false},
+		{"23:59-00:00", 23*60 + 59, true},
+		{"23:59-00:00", 0, false},
+	}
+
+	for _, test := range tests {
+		start, end, err := ParseRunWindow(test.window)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if InRunWindow(test.minute, start, end) != test.expected {
+			t.Errorf("%s at minute %d: expected %v", test.window, test.minute, test.expected)
+		}
+	}
+
+	for _, window := range []string{"09:00", "09:00-09:00", "9-17", "25:00-01:00"} {
+		if _, _, err := ParseRunWindow(window); err == nil {
+			t.Errorf("%s: expected an error", window)
+		}
+	}
+}
+
+// launcherRunWindow returns the run window check of obArmed, without the reading of its bounds
+func launcherRunWindow(t *testing.T) string {
+	t.Helper()
+
+	source := templateFunction(t, "obArmed")
+	source = source[strings.Index(source, "// OB_FEATURE_BEGIN runwindow"):]
+	source = source[:strings.Index(source, "// OB_FEATURE_END runwindow")]
+
+	lines := []string{}
+
+	for _, line := range strings.Split(source, "\n") {
+		if !strings.Contains(line, "obSensitive") {
+			lines = append(lines, line)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+/*
+InRunWindow is the logic compiled in the launcher: the window check of
+the template is built and run for every minute of the day of each window.
+*/
+func TestInRunWindowMatchesLauncher(t *testing.T) {
+	windows := []string{}
+	expected := []string{}
+
+	for _, window := range testRunWindows {
+		windows = append(windows, fmt.Sprintf("{%d, %d}", window[0], window[1]))
+		minutes := ""
+
+		for minute := int64(0); minute < 24*60; minute++ {
+			if InRunWindow(minute, window[0], window[1]) {
+				minutes += "1"
+			} else {
+				minutes += "0"
+			}
+		}
+
+		expected = append(expected, minutes)
+	}
+
+	program := `package main
+
+import (
+	"fmt"
+	obTime "time"
+)
+
+func obArmed(obNow obTime.Time, obWindowStart, obWindowEnd int64) bool {
+` + launcherRunWindow(t) + `
+	return true
+}
+
+func main() {
+	for _, window := range [][2]int64{` + strings.Join(windows, ", ") + `} {
+		for minute := 0; minute < 24*60; minute++ {
+			if obArmed(obTime.Date(2024, 1, 1, minute/60, minute%60, 30, 0, obTime.UTC), window[0], window[1]) {
+				fmt.Print("1")
+			} else {
+				fmt.Print("0")
+			}
+		}
+
+		fmt.Println()
+	}
+}
+`
+
+	output := runProgram(t, program)
+
+	results := strings.Fields(output)
+	if len(results) != len(expected) {
+		t.Fatalf("%d windows checked by the launcher, expected %d", len(results), len(expected))
+	}
+
+	for index, minutes := range results {
+		if minutes != expected[index] {
+			t.Errorf("window %v: the launcher and InRunWindow disagree", testRunWindows[index])
+		}
+	}
+}
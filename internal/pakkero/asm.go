@@ -0,0 +1,64 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Launcher assembly library
+*/
+package pakkero
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// launcherAsmArch is the only architecture with launcher assembly
+const launcherAsmArch = "amd64"
+
+/*
+launcherAsmParts are the instructions the launcher cannot run from go,
+by the feature needing them, built with it for amd64 only.
+Their names are renamed together with the ones of the launcher, see
+ObfuscateLauncher; not the ones of their arguments, go vet cannot
+match them, they are not in the binary anyway.
+*/
+var launcherAsmParts = []struct {
+	feature string
+	asm     string
+}{
+	{"userland", userlandAsm},
+}
+
+// launcherAsmFile returns the assembly file built with the launcher file
+func launcherAsmFile(launcherFile string) string {
+	return strings.TrimSuffix(launcherFile, ".go") + "_" + launcherAsmArch + ".s"
+}
+
+/*
+WriteLauncherAsm will write next to the launcher the assembly of
+the enabled features, nothing if none of them needs it.
+*/
+func WriteLauncherAsm(launcherFile string, features map[string]bool) error {
+	asm := ""
+
+	for _, part := range launcherAsmParts {
+		if features[part.feature] {
+			asm += part.asm
+		}
+	}
+
+	if asm == "" {
+		return nil
+	}
+
+	return ioutil.WriteFile(launcherAsmFile(launcherFile),
+		[]byte("#include \"textflag.h\"\n"+asm), 0644)
+}
+
+// readLauncherAsm returns the assembly next to the launcher, if any
+func readLauncherAsm(launcherFile string) (string, bool, error) {
+	content, err := ioutil.ReadFile(launcherAsmFile(launcherFile))
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+
+	return string(content), err == nil, err
+}
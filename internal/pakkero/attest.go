@@ -0,0 +1,107 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Attestation library
+*/
+package pakkero
+
+import (
+	"fmt"
+	"strings"
+)
+
+const attestIDPlaceholder = `"ATTESTID"`
+const attestPathPlaceholder = `"ATTESTPATH"`
+
+// Attestation channels
+const (
+	AttestOff    = "off"
+	AttestFile   = "file"
+	AttestSocket = "socket"
+)
+
+/*
+AttestChecks are the checks reported in the attestation records,
+by their index; 0 is the record of a launcher whose checks passed.
+*/
+var AttestChecks = []string{
+	"success",
+	"sigtrap",
+	"parent-cmdline",
+	"parent-tracer",
+	"parent-stat",
+	"env-args",
+	"env-parent",
+	"env",
+	"ld-preload",
+	"dependency",
+	"license",
+	"anti-dump-seals",
+	"decrypt",
+	"guard",
+	"hostname",
+	"registration",
+	"custom",
+}
+
+// Attest is where the launcher writes its attestation records
+type Attest struct {
+	Mode string
+	Path string
+}
+
+// Enabled returns true if the launcher writes attestation records
+func (a Attest) Enabled() bool {
+	return a.Mode == AttestFile || a.Mode == AttestSocket
+}
+
+/*
+build id of the launcher in the attestation and registration records,
+written in the manifest, 0 until one of them asks for it.
+*/
+var launcherBuildID int64
+
+// buildID returns the build id, generating it the first time
+func buildID() int64 {
+	if launcherBuildID == 0 {
+		launcherBuildID = Random(1, 1<<62)
+	}
+
+	return launcherBuildID
+}
+
+/*
+ParseAttest will parse the attestation channel: off, file:/path
+to append the records to a file or socket:/path to send them to
+a unix datagram socket.
+*/
+func ParseAttest(input string) (Attest, error) {
+	if input == "" || input == AttestOff {
+		return Attest{Mode: AttestOff}, nil
+	}
+
+	pair := strings.SplitN(input, ":", 2)
+	if len(pair) != 2 || (pair[0] != AttestFile && pair[0] != AttestSocket) {
+		return Attest{}, fmt.Errorf("invalid attest %q, use off, file:/path or socket:/path", input)
+	}
+
+	if !IsTargetAbs(pair[1]) {
+		return Attest{}, fmt.Errorf("attest %s needs an absolute path, got %q", pair[0], pair[1])
+	}
+
+	return Attest{Mode: pair[0], Path: pair[1]}, nil
+}
+
+/*
+RegisterAttest will add the build id to the secrets,
+with the path of the channel, so that they will be embedded obfuscated
+in the launcher.
+Returns the build id, that is written in the manifest too.
+*/
+func RegisterAttest(attest Attest) int64 {
+	Secrets[attestIDPlaceholder] = []string{fmt.Sprintf("%d", buildID()),
+		GenerateTyposquatName()}
+	Secrets[attestPathPlaceholder] = []string{attest.Path,
+		GenerateTyposquatName()}
+
+	return buildID()
+}
@@ -0,0 +1,374 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Audit log library
+*/
+package pakkero
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// AuditSchema is the version of the audit records
+const AuditSchema = 1
+
+// AuditSaltSuffix names the salt of an audit log, next to it
+const AuditSaltSuffix = ".salt"
+
+// status of an audit record
+const (
+	AuditOK     = "ok"
+	AuditFailed = "failed"
+)
+
+// every record starts with its hash, see AuditRecord
+const auditHashPrefix = `{"hash":"`
+
+// the rules fired by the options of the pack, for the audit log
+var policyFindings []string
+
+// true once the record of the pack is written, a failure writing it must not write another one
+var auditWritten bool
+
+/*
+AuditHost identifies the host packing: its name, machine id, platform
+and user. The machine id is hashed with the salt of the log, as it is
+the secret of the licenses bound to the host, see HashAuditSecret.
+*/
+type AuditHost struct {
+	Hostname  string `json:"hostname"`
+	MachineID string `json:"machine_id,omitempty"`
+	Platform  string `json:"platform"`
+	User      string `json:"user"`
+}
+
+/*
+AuditRecord is a line of an audit log, a pack that succeeded or failed:
+when, where, by which pakkero and tools, the options with the secrets
+hashed with the salt of the log, the digest of the inputs and of the
+output, the steps, the warnings and the policy rules fired.
+Hash is the HMAC-SHA256 of the line with an empty hash, keyed with the
+salt of the log, Previous the hash of the record before it, empty for
+the first one: changing, removing or reordering records breaks the
+chain, and without the salt it cannot be forged, see VerifyAuditLog.
+*/
+type AuditRecord struct {
+	Hash     string                 `json:"hash"`
+	Previous string                 `json:"previous"`
+	Sequence int64                  `json:"sequence"`
+	Schema   int                    `json:"schema"`
+	Time     time.Time              `json:"time"`
+	Status   string                 `json:"status"`
+	Error    string                 `json:"error,omitempty"`
+	Host     AuditHost              `json:"host"`
+	Pakkero  BuildInfo              `json:"pakkero"`
+	Options  map[string]interface{} `json:"options"`
+	Template ManifestFile           `json:"template"`
+	Inputs   []ManifestFile         `json:"inputs"`
+	Output   *ManifestFile          `json:"output,omitempty"`
+	Steps    []StepTiming           `json:"steps"`
+	Warnings []string               `json:"warnings"`
+	Policy   []string               `json:"policy"`
+}
+
+// auditHost returns the AuditHost of this host, what cannot be read is left empty
+func auditHost(salt []byte) AuditHost {
+	host := AuditHost{Platform: runtime.GOOS + "/" + runtime.GOARCH}
+
+	host.Hostname, _ = os.Hostname()
+
+	if machineID, err := ReadMachineID(); err == nil && machineID != "" {
+		host.MachineID = HashAuditSecret(salt, machineID)
+	}
+
+	if current, err := user.Current(); err == nil {
+		host.User = current.Username
+	}
+
+	return host
+}
+
+// readAuditSalt returns the salt of the audit log at path
+func readAuditSalt(path string) ([]byte, error) {
+	content, err := ioutil.ReadFile(path + AuditSaltSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	return hex.DecodeString(strings.TrimSpace(string(content)))
+}
+
+/*
+auditSalt returns the salt of the audit log at path, created with it
+and kept apart from it, readable by its owner only: the secrets of the
+records are hashed with it, so that a given value can be told used by
+whoever has the salt, and by nobody else from the log alone, and the
+chain of the records is keyed with it.
+*/
+func auditSalt(path string) ([]byte, error) {
+	salt, err := readAuditSalt(path)
+	if !errors.Is(err, os.ErrNotExist) {
+		return salt, err
+	}
+
+	salt = make([]byte, 32)
+
+	_, err = rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	return salt, ioutil.WriteFile(path+AuditSaltSuffix, []byte(hex.EncodeToString(salt)+"\n"), 0600)
+}
+
+// HashAuditSecret returns how a secret is written in the records of a log with the salt
+func HashAuditSecret(salt []byte, value string) string {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(value))
+
+	return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// auditHash returns the HMAC-SHA256 of a record line with an empty hash, keyed with the salt
+func auditHash(salt []byte, line []byte) string {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(line)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+/*
+auditLineHash returns the hash a record line has to start with, the
+auditHash of the line with an empty hash, or an error if it does not
+start with a hash.
+*/
+func auditLineHash(salt []byte, line []byte) (string, error) {
+	size := len(auditHashPrefix) + sha256.Size*2
+
+	if !bytes.HasPrefix(line, []byte(auditHashPrefix)) || len(line) < size+1 || line[size] != '"' {
+		return "", errors.New("it does not start with its hash")
+	}
+
+	return auditHash(salt, append([]byte(auditHashPrefix), line[size:]...)), nil
+}
+
+/*
+sealAuditRecord returns the line of a record, its hash set, see
+auditLineHash.
+*/
+func sealAuditRecord(salt []byte, record AuditRecord) ([]byte, error) {
+	record.Hash = ""
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(auditHashPrefix+auditHash(salt, line)), line[len(auditHashPrefix):]...), nil
+}
+
+// auditLines returns the lines of an audit log, a record each
+func auditLines(content []byte) [][]byte {
+	lines := [][]byte{}
+
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+/*
+VerifyAuditLog will check the chain of the records of the audit log at
+path, with its salt: each one must match its hash, follow the one before
+it and have the next sequence number. Returns the count of records, or
+the error of the first one breaking the chain, by its position in the
+log. Truncating the log after a record cannot be told.
+*/
+func VerifyAuditLog(path string) (int, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	salt, err := readAuditSalt(path)
+	if err != nil {
+		return 0, fmt.Errorf("the salt of the log is needed to check its chain: %w", err)
+	}
+
+	previous := ""
+	lines := auditLines(content)
+
+	for index, line := range lines {
+		position := index + 1
+
+		hash, err := auditLineHash(salt, line)
+		if err != nil {
+			return index, fmt.Errorf("record %d: %w, it was modified", position, err)
+		}
+
+		record := AuditRecord{}
+
+		err = json.Unmarshal(line, &record)
+		if err != nil {
+			return index, fmt.Errorf("record %d: not a record, it was modified: %w", position, err)
+		}
+
+		switch {
+		case record.Hash != hash:
+			return index, fmt.Errorf("record %d: its content does not match its hash, it was modified", position)
+		case record.Previous != previous:
+			return index, fmt.Errorf("record %d: it does not follow record %d, one of them was modified, "+
+				"or records were removed or inserted between them", position, index)
+		case record.Sequence != int64(position):
+			return index, fmt.Errorf("record %d: sequence %d, records were removed, inserted or reordered",
+				position, record.Sequence)
+		case record.Schema != AuditSchema:
+			return index, fmt.Errorf("record %d: unsupported schema %d, expected %d",
+				position, record.Schema, AuditSchema)
+		}
+
+		previous = record.Hash
+	}
+
+	return len(lines), nil
+}
+
+// auditInputs returns the digest of the files packed: the payload, the decoy, the prologue and the libraries
+func auditInputs(opts Options) ([]ManifestFile, error) {
+	paths := []string{opts.InFile}
+
+	for _, path := range []string{opts.Decoy, opts.Prologue} {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+
+	inputs := []ManifestFile{}
+
+	for _, path := range append(paths, opts.BundleLibs...) {
+		input, err := hashFile(path)
+		if err != nil {
+			return inputs, err
+		}
+
+		inputs = append(inputs, input)
+	}
+
+	return inputs, nil
+}
+
+/*
+appendAudit will append the record of the pack to the audit log of the
+options, chained to its last record, the output is the file written if
+not empty, else the pack failed with failure. The log is locked while the
+record is written, so that concurrent packs chain their records.
+*/
+func appendAudit(opts Options, output string, failure error) error {
+	file, err := os.OpenFile(opts.AuditLog, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = lockFile(file)
+	if err != nil {
+		return err
+	}
+
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
+
+	salt, err := auditSalt(opts.AuditLog)
+	if err != nil {
+		return err
+	}
+
+	record := AuditRecord{
+		Sequence: 1,
+		Schema:   AuditSchema,
+		Time:     time.Now().UTC(),
+		Status:   AuditOK,
+		Host:     auditHost(salt),
+		Pakkero:  GetBuildInfo(),
+		Steps:    Log.Steps(),
+		Warnings: Log.Warnings(),
+		Policy:   append([]string{}, policyFindings...),
+	}
+
+	if lines := auditLines(content); len(lines) > 0 {
+		last := AuditRecord{}
+
+		err = json.Unmarshal(lines[len(lines)-1], &last)
+		if err != nil || last.Hash == "" {
+			return fmt.Errorf("the last record of %s is not valid, check it with pakkero audit verify", opts.AuditLog)
+		}
+
+		record.Previous, record.Sequence = last.Hash, last.Sequence+1
+	}
+
+	record.Options, err = redactOptions(opts, func(value string) string {
+		return HashAuditSecret(salt, value)
+	})
+	if err != nil {
+		return err
+	}
+
+	stub, _ := base64.StdEncoding.DecodeString(LauncherStub)
+	templateSum := sha256.Sum256(stub)
+	record.Template = ManifestFile{Size: int64(len(stub)), SHA256: hex.EncodeToString(templateSum[:])}
+
+	// a failed pack may miss its inputs, the ones read are kept
+	record.Inputs, err = auditInputs(opts)
+	if err != nil && failure == nil {
+		return err
+	}
+
+	if failure != nil {
+		record.Status, record.Error = AuditFailed, redact(failure.Error())
+	} else {
+		digest, err := hashFile(output)
+		if err != nil {
+			return err
+		}
+
+		digest.Path = opts.OutFile
+		record.Output = &digest
+	}
+
+	line, err := sealAuditRecord(salt, record)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+
+	err = file.Sync()
+	if err != nil {
+		return err
+	}
+
+	auditWritten = true
+
+	return nil
+}
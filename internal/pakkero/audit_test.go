@@ -0,0 +1,121 @@
+package pakkero
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeAuditLog writes a log of count chained records with the salt, and the salt next to it
+func writeAuditLog(t *testing.T, salt []byte, count int) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	content := []byte{}
+	previous := ""
+
+	for sequence := 1; sequence <= count; sequence++ {
+		line, err := sealAuditRecord(salt, AuditRecord{
+			Previous: previous,
+			Sequence: int64(sequence),
+			Schema:   AuditSchema,
+			Status:   AuditOK,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		previous, err = auditLineHash(salt, line)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		content = append(content, append(line, '\n')...)
+	}
+
+	err := ioutil.WriteFile(path, content, 0600)
+	if err == nil {
+		err = ioutil.WriteFile(path+AuditSaltSuffix, []byte("0011223344\n"), 0600)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	salt := []byte{0x00, 0x11, 0x22, 0x33, 0x44}
+
+	count, err := VerifyAuditLog(writeAuditLog(t, salt, 3))
+	if err != nil || count != 3 {
+		t.Fatalf("3 chained records: %d, %v", count, err)
+	}
+
+	tests := []struct {
+		name     string
+		tamper   func(path string, lines [][]byte) [][]byte
+		expected string
+	}{
+		{"modified", func(path string, lines [][]byte) [][]byte {
+			lines[1] = bytes.Replace(lines[1], []byte(`"status":"ok"`), []byte(`"status":"failed"`), 1)
+			return lines
+		}, "record 2: its content does not match its hash"},
+		{"removed", func(path string, lines [][]byte) [][]byte {
+			return append(lines[:1], lines[2:]...)
+		}, "record 2: it does not follow record 1"},
+		{"reordered", func(path string, lines [][]byte) [][]byte {
+			lines[1], lines[2] = lines[2], lines[1]
+			return lines
+		}, "record 2: it does not follow record 1"},
+		{"resealed without the salt", func(path string, lines [][]byte) [][]byte {
+			line, err := sealAuditRecord([]byte("guessed"), AuditRecord{Sequence: 1, Schema: AuditSchema})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return [][]byte{line}
+		}, "record 1: its content does not match its hash"},
+		{"salt missing", func(path string, lines [][]byte) [][]byte {
+			os.Remove(path + AuditSaltSuffix)
+			return lines
+		}, "the salt of the log is needed"},
+	}
+
+	for _, test := range tests {
+		path := writeAuditLog(t, salt, 3)
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lines := test.tamper(path, auditLines(content))
+
+		err = ioutil.WriteFile(path, append(bytes.Join(lines, []byte("\n")), '\n'), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = VerifyAuditLog(path)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%s: %v, expected %q", test.name, err, test.expected)
+		}
+	}
+}
+
+func TestHashAuditSecret(t *testing.T) {
+	hash := HashAuditSecret([]byte("salt"), "secret")
+
+	if !strings.HasPrefix(hash, "hmac-sha256:") || strings.Contains(hash, "secret") {
+		t.Errorf("the secret is not hashed: %s", hash)
+	}
+
+	if hash == HashAuditSecret([]byte("other"), "secret") {
+		t.Error("the hash does not depend on the salt")
+	}
+}
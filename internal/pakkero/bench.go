@@ -0,0 +1,450 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Benchmark library
+*/
+package pakkero
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// RegressionThreshold is how much slower than the baseline a benchmark is flagged
+const RegressionThreshold = 0.20
+
+// fixed offset of the benchmark packs, so that runs compare
+const benchOffset = 3000000
+
+// BenchResult is the timing of a benchmark
+type BenchResult struct {
+	Name string        `json:"name"`
+	Runs int           `json:"runs"`
+	Mean time.Duration `json:"mean_ns"`
+	Min  time.Duration `json:"min_ns"`
+}
+
+/*
+BenchConfig selects the benchmarks: the sizes in MB of the scrubbed
+fixtures, the literals of the obfuscated templates, the sizes in KB
+of the secrets whose obfuscated template is built, the packer
+executable with the payload for the pack and start ones, and the size
+in MB of the payload of the cold start ones.
+An empty Executable skips those, a zero ColdSize the cold start ones.
+*/
+type BenchConfig struct {
+	Runs        int
+	ScrubSizes  []int
+	Literals    []int
+	SecretSizes []int
+	Executable  string
+	Payload     string
+	ColdSize    int
+}
+
+// benchmark runs fn the given times and returns its timing
+func benchmark(name string, runs int, fn func() error) (BenchResult, error) {
+	result := BenchResult{Name: name, Runs: runs}
+
+	var total time.Duration
+
+	for i := 0; i < runs; i++ {
+		start := time.Now()
+
+		err := fn()
+		if err != nil {
+			return result, fmt.Errorf("%s: %s", name, err)
+		}
+
+		elapsed := time.Since(start)
+		total += elapsed
+
+		if result.Min == 0 || elapsed < result.Min {
+			result.Min = elapsed
+		}
+	}
+
+	result.Mean = total / time.Duration(runs)
+
+	return result, nil
+}
+
+// scrubFixture returns size MB of garbage with the scrubbed strings in it
+func scrubFixture(size int) string {
+	var fixture strings.Builder
+
+	chunk := GenerateRandomGarbage(1 << 20)
+
+	for i := 0; i < size; i++ {
+		fixture.WriteString(chunk)
+		fixture.WriteString(extras[i%len(extras)])
+	}
+
+	return fixture.String()
+}
+
+// literalsTemplate returns a launcher-like template with the given string literals
+func literalsTemplate(literals int) string {
+	var template strings.Builder
+
+	template.WriteString("package main\n\nimport (\n\t\"fmt\"\n)\n\nfunc main() {\n")
+
+	for i := 0; i < literals; i++ {
+		fmt.Fprintf(&template, "\tfmt.Println(\"literal number %d\")\n", i)
+	}
+
+	template.WriteString("}\n")
+
+	return template.String()
+}
+
+// benchScrub times the scrubbing of the go strings from the fixtures
+func benchScrub(config BenchConfig) ([]BenchResult, error) {
+	results := []BenchResult{}
+
+	for _, size := range config.ScrubSizes {
+		fixture := scrubFixture(size)
+
+		result, err := benchmark(fmt.Sprintf("scrub %dMB", size), config.Runs, func() error {
+			scrubStrings(fixture, extras)
+			return nil
+		})
+		if err != nil {
+			return results, err
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// benchObfuscateStrings times the string obfuscation of the templates
+func benchObfuscateStrings(config BenchConfig) ([]BenchResult, error) {
+	results := []BenchResult{}
+
+	// the obfuscation registers its secrets, keep the real ones
+	secrets := Secrets
+	defer func() { Secrets = secrets }()
+
+	for _, literals := range config.Literals {
+		template := literalsTemplate(literals)
+
+		result, err := benchmark(fmt.Sprintf("obfuscate %d literals", literals), config.Runs, func() error {
+			Secrets = map[string][]string{}
+			return checkSource("string obfuscation", ObfuscateStrings(template))
+		})
+		if err != nil {
+			return results, err
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+/*
+benchBuildSecrets times building the obfuscated template of a secret,
+decoded in chunks and by a single function, with the go toolchain.
+*/
+func benchBuildSecrets(config BenchConfig) ([]BenchResult, error) {
+	results := []BenchResult{}
+
+	if len(config.SecretSizes) == 0 {
+		return results, nil
+	}
+
+	dir, err := ioutil.TempDir("", "pakkero-bench-")
+	if err != nil {
+		return results, err
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module bench\n"), 0644)
+	if err != nil {
+		return results, err
+	}
+
+	// the obfuscation registers its secrets and the chunk, keep the real ones
+	secrets, chunk := Secrets, stringChunk
+	defer func() { Secrets, stringChunk = secrets, chunk }()
+
+	for _, size := range config.SecretSizes {
+		secret := make([]byte, size<<10)
+		for i := range secret {
+			secret[i] = 'a' + byte(Random(0, 26))
+		}
+
+		template := "package main\n\nimport (\n\tobBytes \"bytes\"\n\t\"fmt\"\n)\n\n" +
+			"var obAnchor = uint8(1)\n\nvar _ = obBytes.Join\n\n" +
+			"func main() {\n\tfmt.Println(\"" + string(secret) + "\")\n}\n"
+
+		for _, variant := range []struct {
+			label string
+			chunk int64
+		}{
+			{"", chunk},
+			{", one function", int64(len(secret)) + 1},
+		} {
+			Secrets, stringChunk = map[string][]string{}, variant.chunk
+			source := ObfuscateStrings(template)
+			run := 0
+
+			result, err := benchmark(fmt.Sprintf("build %dKB secret%s", size, variant.label), config.Runs, func() error {
+				// a new source at each run, else the build cache answers
+				run++
+
+				err := ioutil.WriteFile(filepath.Join(dir, "main.go"),
+					[]byte(fmt.Sprintf("%s// run %d\n", source, run)), 0644)
+				if err != nil {
+					return err
+				}
+
+				// the same flags of the launcher build
+				if !ExecCommandIn(context.Background(), dir, "go",
+					[]string{"build", "-gcflags", "-N -l", "-o", "bench", "."}) {
+					return errors.New("go build failed")
+				}
+
+				return nil
+			})
+			if err != nil {
+				return results, err
+			}
+
+			results = append(results, result)
+		}
+	}
+
+	return results, nil
+}
+
+/*
+benchPack times packing the payload with the executable, and the cold
+start of the output, with and without compression when UPX is present.
+*/
+func benchPack(config BenchConfig) ([]BenchResult, error) {
+	results := []BenchResult{}
+
+	dir, err := ioutil.TempDir("", "pakkero-bench-")
+	if err != nil {
+		return results, err
+	}
+	defer os.RemoveAll(dir)
+
+	variants := [][]string{{}}
+	if _, err := ResolveTool("upx"); err == nil {
+		variants = append(variants, []string{"-c"})
+	}
+
+	for _, variant := range variants {
+		label := strings.Join(variant, " ")
+		output := filepath.Join(dir, "payload.enc")
+
+		args := append([]string{
+			"-file", config.Payload,
+			"-o", output,
+			"-offset", fmt.Sprint(benchOffset),
+		}, variant...)
+
+		result, err := benchmark(strings.TrimSpace("pack "+label), config.Runs, func() error {
+			os.Remove(output)
+			return benchCommand(config.Executable, args...)
+		})
+		if err != nil {
+			return results, err
+		}
+
+		results = append(results, result)
+
+		// starting is much faster than packing, run it more
+		result, err = benchmark(strings.TrimSpace("start "+label), config.Runs*10, func() error {
+			return benchCommand(output)
+		})
+		if err != nil {
+			return results, err
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+/*
+benchColdStart times the start of a payload of ColdSize MB packed with
+each I/O profile, from a cold page cache: the payload is padded with
+random bytes, that the none codec keeps as they are, and the output is
+evicted from the page cache before each run, see evictPageCache.
+The temporary directory must be on the disk to measure, not a tmpfs.
+*/
+func benchColdStart(config BenchConfig) ([]BenchResult, error) {
+	results := []BenchResult{}
+
+	dir, err := ioutil.TempDir("", "pakkero-bench-")
+	if err != nil {
+		return results, err
+	}
+	defer os.RemoveAll(dir)
+
+	payload := filepath.Join(dir, "payload")
+
+	content, err := ioutil.ReadFile(config.Payload)
+	if err != nil {
+		return results, err
+	}
+
+	// the kernel loads the segments only, the padding is never run
+	for len(content) < config.ColdSize<<20 {
+		content = append(content, GenerateRandomGarbage(1<<20)...)
+	}
+
+	err = ioutil.WriteFile(payload, content, 0755)
+	if err != nil {
+		return results, err
+	}
+
+	for _, profile := range IOProfileNames() {
+		output := filepath.Join(dir, profile+".enc")
+
+		err = benchCommand(config.Executable,
+			"-file", payload,
+			"-o", output,
+			"-offset", fmt.Sprint(benchOffset),
+			"-codec", "none",
+			"-io-profile", profile)
+		if err != nil {
+			return results, err
+		}
+
+		result, err := benchmark(fmt.Sprintf("cold start %dMB %s", config.ColdSize, profile), config.Runs, func() error {
+			err := evictPageCache(output)
+			if err != nil {
+				return err
+			}
+
+			return benchCommand(output)
+		})
+		if err != nil {
+			return results, err
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+/*
+benchCommand runs a command as a shell does, with "_" set to it, that
+the launcher checks. Its output is shown only if it fails.
+*/
+func benchCommand(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Env = append(os.Environ(), "_="+name)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s\n%s", err, output)
+	}
+
+	return nil
+}
+
+// RunBenchmarks runs the benchmarks selected by the config
+func RunBenchmarks(config BenchConfig) ([]BenchResult, error) {
+	results := []BenchResult{}
+
+	for _, bench := range []func(BenchConfig) ([]BenchResult, error){
+		benchScrub,
+		benchObfuscateStrings,
+		benchBuildSecrets,
+	} {
+		partial, err := bench(config)
+
+		results = append(results, partial...)
+		if err != nil {
+			return results, err
+		}
+	}
+
+	if config.Executable == "" {
+		return results, nil
+	}
+
+	partial, err := benchPack(config)
+
+	results = append(results, partial...)
+	if err != nil || config.ColdSize == 0 {
+		return results, err
+	}
+
+	partial, err = benchColdStart(config)
+
+	return append(results, partial...), err
+}
+
+// ReadBenchResults will read the results saved as json
+func ReadBenchResults(path string) ([]BenchResult, error) {
+	results := []BenchResult{}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return results, err
+	}
+
+	err = json.Unmarshal(content, &results)
+	if err != nil {
+		return results, fmt.Errorf("invalid benchmark results: %s", err)
+	}
+
+	return results, nil
+}
+
+/*
+PrintBenchResults will print the results as a table, against the
+baseline ones if any: a mean slower than RegressionThreshold is
+marked with REGRESSION at the end of its row.
+*/
+func PrintBenchResults(w io.Writer, results []BenchResult, baseline []BenchResult) {
+	previous := map[string]BenchResult{}
+	for _, result := range baseline {
+		previous[result.Name] = result
+	}
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(table, "BENCHMARK\tRUNS\tMEAN\tMIN\tBASELINE\tDELTA\t")
+
+	for _, result := range results {
+		row := fmt.Sprintf("%s\t%d\t%s\t%s\t", result.Name, result.Runs,
+			result.Mean.Round(time.Microsecond), result.Min.Round(time.Microsecond))
+
+		old, ok := previous[result.Name]
+		if !ok || old.Mean == 0 {
+			fmt.Fprintln(table, row+"-\t-\t")
+			continue
+		}
+
+		delta := float64(result.Mean-old.Mean) / float64(old.Mean)
+		row += fmt.Sprintf("%s\t%+.1f%%\t", old.Mean.Round(time.Microsecond), delta*100)
+
+		if delta > RegressionThreshold {
+			row += "REGRESSION"
+		}
+
+		fmt.Fprintln(table, row)
+	}
+
+	table.Flush()
+}
@@ -0,0 +1,108 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Payload build info library
+*/
+package pakkero
+
+import (
+	"bytes"
+	"debug/buildinfo"
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+/*
+The go command embeds the module information of a binary between
+these sentinels, it is there twice: inline in the .go.buildinfo
+section and as the runtime.modinfo string read by debug.ReadBuildInfo.
+*/
+var (
+	modInfoStart = []byte("\x30\x77\xaf\x0c\x92\x74\x08\x02\x41\xe1\xc1\x07\xe6\xd6\x18\xe6")
+	modInfoEnd   = []byte("\xf9\x32\x43\x31\x86\x18\x20\x72\x00\x82\x42\x10\x41\x16\xd8\xf2")
+)
+
+// magic of the .go.buildinfo section, what go version -m looks for
+var buildInfoMagic = []byte("\xff Go buildinf:")
+
+/*
+ReadPayloadBuildInfo returns the build info of a go payload, an error
+if the payload is not a go binary or has none.
+*/
+func ReadPayloadBuildInfo(infile string) (*debug.BuildInfo, error) {
+	info, err := buildinfo.ReadFile(infile)
+	if err != nil {
+		return nil, fmt.Errorf("the payload has no go build info: %s", err)
+	}
+
+	return info, nil
+}
+
+// garbageLetters returns size random lowercase letters
+func garbageLetters(size int) []byte {
+	garbage := make([]byte, size)
+	for i := range garbage {
+		garbage[i] = byte('a' + randomSource.Intn(26))
+	}
+
+	return garbage
+}
+
+/*
+ScrubBuildInfo will overwrite, in place and with same length garbage,
+the module information of a go binary: the module path and version,
+the dependencies and the build settings. The magic of the build info
+section is garbled too, so go version -m cannot read the binary.
+The offsets of the binary do not change, it still runs, only
+debug.ReadBuildInfo returns no module information in it.
+Returns the number of module information copies scrubbed.
+*/
+func ScrubBuildInfo(content []byte) ([]byte, int, error) {
+	scrubbed := 0
+	total := int64(len(content))
+
+	progressStage(ProgressScrub)
+
+	for start := 0; ; {
+		index := bytes.Index(content[start:], modInfoStart)
+		if index < 0 {
+			break
+		}
+
+		begin := start + index + len(modInfoStart)
+
+		end := bytes.Index(content[begin:], modInfoEnd)
+		if end < 0 {
+			break
+		}
+
+		copy(content[begin:begin+end], garbageLetters(end))
+
+		scrubbed++
+		start = begin + end + len(modInfoEnd)
+
+		progressStep(int64(start), total)
+	}
+
+	progressStep(total, total)
+
+	for {
+		index := bytes.Index(content, buildInfoMagic)
+		if index < 0 {
+			break
+		}
+
+		copy(content[index:], garbageLetters(len(buildInfoMagic)))
+	}
+
+	if scrubbed == 0 {
+		return content, 0, errors.New("no module information found in the payload")
+	}
+
+	_, err := buildinfo.Read(bytes.NewReader(content))
+	if err == nil {
+		return content, scrubbed, errors.New("the build info of the payload is still readable")
+	}
+
+	return content, scrubbed, nil
+}
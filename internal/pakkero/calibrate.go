@@ -0,0 +1,369 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Calibration library
+*/
+package pakkero
+
+import (
+	"bufio"
+	"context"
+	"debug/elf"
+	"debug/gosym"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CalibrationEnv is the variable with the path of the calibration file
+const CalibrationEnv = "PAKKERO_CALIBRATION"
+
+// DefaultCalibrationCount is the least functions a word names to be proposed
+const DefaultCalibrationCount = 10
+
+// calibrationWordRegex matches the words of a package path that can be scrubbed
+var calibrationWordRegex = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+
+// words of the calibration file, scrubbed with the extras, see LoadCalibration
+var calibratedExtras []string
+
+// the calibration file loaded, written in the manifest, nil if none
+var calibrationFile *ManifestFile
+
+// CalibrationWord is a word proposed by a calibration, with the functions naming it
+type CalibrationWord struct {
+	Word  string
+	Count int
+}
+
+/*
+DefaultCalibrationPath returns where pakkero calibrate writes, and the
+packs read, the calibration file when CalibrationEnv is not set:
+pakkero/calibration in the user configuration directory.
+*/
+func DefaultCalibrationPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "pakkero", "calibration")
+}
+
+/*
+CalibrationPath returns the calibration file of the packs: the one of
+CalibrationEnv, that must exist, else the default one if it exists,
+else none.
+*/
+func CalibrationPath() string {
+	if path, ok := os.LookupEnv(CalibrationEnv); ok {
+		return path
+	}
+
+	path := DefaultCalibrationPath()
+	if _, err := os.Stat(path); path == "" || err != nil {
+		return ""
+	}
+
+	return path
+}
+
+/*
+ReadCalibration returns the words of a calibration file: one per line,
+blank lines and the ones starting with # are skipped.
+*/
+func ReadCalibration(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	words := []string{}
+	scanner := bufio.NewScanner(file)
+
+	for line := 1; scanner.Scan(); line++ {
+		word := strings.TrimSpace(scanner.Text())
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+
+		if !calibrationWordRegex.MatchString(word) || len(word) < minStripLength {
+			return nil, fmt.Errorf("%s:%d: %q is not a word to scrub", path, line, word)
+		}
+
+		words = append(words, word)
+	}
+
+	return words, scanner.Err()
+}
+
+/*
+LoadCalibration will read the calibration file, so that the launcher is
+scrubbed of its words too, see StripFile; an empty path loads none.
+*/
+func LoadCalibration(path string) error {
+	calibratedExtras, calibrationFile = nil, nil
+
+	if path == "" {
+		return nil
+	}
+
+	words, err := ReadCalibration(path)
+	if err != nil {
+		return fmt.Errorf("cannot load the calibration: %s", err)
+	}
+
+	file, err := hashFile(path)
+	if err != nil {
+		return err
+	}
+
+	calibratedExtras, calibrationFile = words, &file
+
+	return nil
+}
+
+// scrubExtras returns the built-in extras and the calibrated ones
+func scrubExtras() []string {
+	return append(append([]string{}, extras...), calibratedExtras...)
+}
+
+/*
+proposeExtras returns the words of the package paths of the functions
+of a go binary, in its pclntab, that name at least minCount functions
+and are not scrubbed already, nor excluded, the most frequent first.
+The package paths stay in a stripped binary, the function names are
+read by the runtime for its tracebacks only.
+*/
+func proposeExtras(binary string, minCount int, exclude []string) ([]CalibrationWord, error) {
+	file, err := elf.Open(binary)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	pclntab, text := file.Section(".gopclntab"), file.Section(".text")
+	if pclntab == nil || text == nil {
+		return nil, fmt.Errorf("%s is not a go binary, it has no .gopclntab", binary)
+	}
+
+	content, err := pclntab.Data()
+	if err != nil {
+		return nil, err
+	}
+
+	table, err := gosym.NewTable(nil, gosym.NewLineTable(content, text.Addr))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the functions of %s: %s", binary, err)
+	}
+
+	known := map[string]bool{}
+	for _, word := range append(scrubExtras(), exclude...) {
+		known[strings.ToLower(word)] = true
+	}
+
+	counts := map[string]int{}
+
+	for _, function := range table.Funcs {
+		words := map[string]bool{}
+
+		for _, word := range strings.FieldsFunc(function.PackageName(), func(r rune) bool {
+			return r == '/' || r == '.' || r == '-' || r == '_'
+		}) {
+			if len(word) >= minStripLength && calibrationWordRegex.MatchString(word) && !known[word] {
+				words[word] = true
+			}
+		}
+
+		for word := range words {
+			counts[word]++
+		}
+	}
+
+	proposal := []CalibrationWord{}
+
+	for word, count := range counts {
+		if count >= minCount {
+			proposal = append(proposal, CalibrationWord{Word: word, Count: count})
+		}
+	}
+
+	sort.Slice(proposal, func(i, j int) bool {
+		if proposal[i].Count != proposal[j].Count {
+			return proposal[i].Count > proposal[j].Count
+		}
+
+		return proposal[i].Word < proposal[j].Word
+	})
+
+	return proposal, nil
+}
+
+/*
+CalibrateBinary returns the proposal of a calibration on a go binary,
+see proposeExtras.
+*/
+func CalibrateBinary(binary string, minCount int) ([]CalibrationWord, error) {
+	return proposeExtras(binary, minCount, nil)
+}
+
+// how long a scrubbed probe launcher can run, see probeRuns
+const probeRunTimeout = 10 * time.Second
+
+/*
+probeRuns returns false if the go runtime of the launcher crashes: a
+panic or a fatal error exit with 2, a fault is a signal, and a hang is
+killed by a signal too. A launcher with no payload exits with ERR.
+*/
+func probeRuns(ctx context.Context, launcher string) bool {
+	runCtx, cancel := context.WithTimeout(ctx, probeRunTimeout)
+	defer cancel()
+
+	command := exec.CommandContext(runCtx, launcher)
+	command.Env = []string{}
+
+	err := command.Run()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Exited() && exitErr.ExitCode() != 2
+	}
+
+	return err == nil
+}
+
+/*
+checkProposal runs the stripped and scrubbed launcher with the words of
+the proposal scrubbed, one at a time then all of them, and returns the
+ones it survives: the runtime reads some of its names, like the godebug
+settings.
+*/
+func checkProposal(ctx context.Context, launcher string, proposal []CalibrationWord) ([]CalibrationWord, error) {
+	if !probeRuns(ctx, launcher) {
+		return nil, errors.New("the launcher crashes with the current scrubbing, fix it first")
+	}
+
+	content, err := ioutil.ReadFile(launcher)
+	if err != nil {
+		return nil, err
+	}
+
+	scrubbed := launcher + ".scrubbed"
+	checked := []CalibrationWord{}
+
+	for _, word := range proposal {
+		err = ioutil.WriteFile(scrubbed, []byte(scrubStrings(string(content), []string{word.Word})), 0755)
+		if err != nil {
+			return nil, err
+		}
+
+		if probeRuns(ctx, scrubbed) {
+			checked = append(checked, word)
+		} else {
+			Log.Warnf("%s is read by the launcher, it is not proposed", word.Word)
+		}
+	}
+
+	words := []string{}
+	for _, word := range checked {
+		words = append(words, word.Word)
+	}
+
+	// and all of them at once
+	err = ioutil.WriteFile(scrubbed, []byte(scrubStrings(string(content), words)), 0755)
+	if err == nil && !probeRuns(ctx, scrubbed) {
+		err = errors.New("the launcher crashes with all the proposed words scrubbed, raise -min-count")
+	}
+
+	return checked, err
+}
+
+/*
+CalibrateLauncher builds a launcher with the features of the options
+and the current toolchain, see buildProbe, and returns the proposal of
+a calibration on it: the words of its random module path, scrubbed on
+their own, are left out, and so are the ones the launcher does not run
+without, see checkProposal. A launcher of another architecture cannot
+be run, its proposal is not checked.
+*/
+func CalibrateLauncher(ctx context.Context, opts Options, minCount int) ([]CalibrationWord, error) {
+	probe, err := buildProbe(ctx, opts)
+	if probe.dir != "" {
+		defer removeTracked(probe.dir)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	proposal, err := proposeExtras(probe.output, minCount, strings.FieldsFunc(probe.identity.Module, func(r rune) bool {
+		return r == '/' || r == '.' || r == '-'
+	}))
+	if err != nil || len(proposal) == 0 {
+		return proposal, err
+	}
+
+	if opts.Arch != runtime.GOARCH || runtime.GOOS != PlatformLinux {
+		Log.Warnf("the %s launcher cannot be run here, the proposal is not checked", opts.Arch)
+
+		return proposal, nil
+	}
+
+	err = StripFile(ctx, probe.output, probe.file, probe.identity.Module, true, opts.UseGarble)
+	if err != nil {
+		return nil, err
+	}
+
+	return checkProposal(ctx, probe.output, proposal)
+}
+
+/*
+WriteCalibration will append the words of a proposal to the calibration
+file, creating it and its directory, after a comment with the go
+toolchain that built them. Returns how many words were added.
+*/
+func WriteCalibration(path string, proposal []CalibrationWord) (int, error) {
+	if path == "" {
+		return 0, errors.New("no calibration file, set " + CalibrationEnv)
+	}
+
+	words := []string{}
+
+	current, err := ReadCalibration(path)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	for _, word := range proposal {
+		if !Contains(current, word.Word) {
+			words = append(words, word.Word)
+		}
+	}
+
+	if len(words) == 0 {
+		return 0, nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return 0, err
+	}
+
+	content, _ := ioutil.ReadFile(path)
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		content = append(content, '\n')
+	}
+
+	content = append(content, fmt.Sprintf("# %s\n%s\n",
+		ToolVersion("go").Version, strings.Join(words, "\n"))...)
+
+	return len(words), ioutil.WriteFile(path, content, 0644)
+}
@@ -0,0 +1,125 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Output capture library
+*/
+package pakkero
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const captureKeyPlaceholder = `"CAPTUREKEY"`
+const captureFilePlaceholder = `"CAPTUREFILE"`
+
+// CaptureKeySize is the size of the AES-256 key of the captured output
+const CaptureKeySize = 32
+
+// streams of the captured output, the first byte of each frame
+const (
+	CaptureStdout = 1
+	CaptureStderr = 2
+)
+
+/*
+maxCaptureFrame is the largest frame the launcher writes, a chunk of
+its pumps with the stream, the nonce and the tag: anything larger is
+not a frame.
+*/
+const maxCaptureFrame = 1 << 20
+
+/*
+RegisterCapture will generate the key of the captured output and add
+it to the secrets, with the path of the file on the target, so that
+they will be embedded obfuscated in the launcher.
+Returns the key, it is never written anywhere else.
+*/
+func RegisterCapture(path string) ([]byte, error) {
+	if !IsTargetAbs(path) {
+		return nil, errors.New("the capture file needs an absolute path")
+	}
+
+	key := make([]byte, CaptureKeySize)
+
+	err := randomRead(key)
+	if err != nil {
+		return nil, err
+	}
+
+	Secrets[captureKeyPlaceholder] = []string{string(key), GenerateTyposquatName()}
+	Secrets[captureFilePlaceholder] = []string{path, GenerateTyposquatName()}
+
+	return key, nil
+}
+
+// ParseCaptureKey will parse a capture key, as printed when packing
+func ParseCaptureKey(input string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(input))
+	if err != nil || len(key) != CaptureKeySize {
+		return nil, fmt.Errorf("invalid capture key, it is %d hex digits", CaptureKeySize*2)
+	}
+
+	return key, nil
+}
+
+/*
+DecryptCapture will decrypt a captured output file, calling output
+with the stream and the content of each frame, in order. Frames are:
+
+	length, 4 bytes big endian | nonce | AES-GCM of the stream byte and the content
+
+A file whose last frame is truncated, by a launcher killed while
+writing it, gives every complete frame and then an error.
+*/
+func DecryptCapture(input io.Reader, key []byte, output func(stream byte, content []byte) error) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+
+	for frame := 0; ; frame++ {
+		_, err = io.ReadFull(input, header)
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("frame %d: truncated", frame)
+		}
+
+		size := binary.BigEndian.Uint32(header)
+		if size < uint32(gcm.NonceSize()+gcm.Overhead()+1) || size > maxCaptureFrame {
+			return fmt.Errorf("frame %d: not a capture frame", frame)
+		}
+
+		sealed := make([]byte, size)
+
+		_, err = io.ReadFull(input, sealed)
+		if err != nil {
+			return fmt.Errorf("frame %d: truncated", frame)
+		}
+
+		plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+		if err != nil {
+			return fmt.Errorf("frame %d: wrong key or corrupted frame", frame)
+		}
+
+		err = output(plain[0], plain[1:])
+		if err != nil {
+			return err
+		}
+	}
+}
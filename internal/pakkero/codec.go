@@ -0,0 +1,345 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Compression codecs library
+*/
+package pakkero
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/89luca89/pakkero/artifact"
+)
+
+const codecIDPlaceholder = `"CODECID"`
+
+/*
+Codec compresses what is encrypted in the output: the blobs, that are
+the payload, the decoy, the libraries and the prologue, or the whole
+launcher binary.
+The compressed blobs start with the ID of their codec, the launcher
+compiles the decompressor of the codec of the pack only, its feature is
+"codec" and the name of the codec, and checks the ID.
+*/
+type Codec interface {
+	// ID is the byte starting the blobs the codec compressed
+	ID() byte
+	// Compress returns a writer compressing to w, flushed by its Close
+	Compress(w io.Writer) io.WriteCloser
+	// Decompress returns a reader of the decompressed r, whose reads
+	// fail if r is not a stream of the codec
+	Decompress(r io.Reader) io.Reader
+}
+
+// IDs of the codecs, written in the blobs, see artifact.Decompress: never reuse one
+const (
+	CodecIDNone = artifact.CodecNone
+	CodecIDZlib = artifact.CodecZlib
+	CodecIDGzip = artifact.CodecGzip
+	CodecIDUPX  = artifact.CodecUPX
+)
+
+// DefaultCodec is the codec of the blobs unless -codec says otherwise
+const DefaultCodec = "zlib"
+
+/*
+Codecs are the codecs of the blobs, by name: adding one takes an entry
+here and its decompressor in a codec feature of the launcher template.
+*/
+var Codecs = map[string]Codec{
+	"none": noneCodec{},
+	"zlib": zlibCodec{},
+	"gzip": gzipCodec{},
+}
+
+// blobCodec is the codec of the blobs of the pack, see RegisterCodec
+var blobCodec Codec = zlibCodec{}
+
+// CodecNames returns the names of the blob codecs, sorted
+func CodecNames() []string {
+	names := []string{}
+	for name := range Codecs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// codecFeatures returns the launcher feature of each blob codec, only the one named set
+func codecFeatures(name string) map[string]bool {
+	features := map[string]bool{}
+	for codec := range Codecs {
+		features["codec"+codec] = codec == name
+	}
+
+	return features
+}
+
+/*
+RegisterCodec will make the codec named the one of the blobs, and add
+its ID to the secrets, so that the launcher checks the blobs are of it.
+*/
+func RegisterCodec(name string) error {
+	codec, ok := Codecs[name]
+	if !ok {
+		return fmt.Errorf("unknown codec %q", name)
+	}
+
+	blobCodec = codec
+	Secrets[codecIDPlaceholder] = []string{fmt.Sprintf("%d", codec.ID()), GenerateTyposquatName()}
+
+	return nil
+}
+
+// errorReader fails every read with its error
+type errorReader struct {
+	err error
+}
+
+func (r errorReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+// nopWriteCloser is a writer whose Close does nothing
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// noneCodec stores the blobs as they are
+type noneCodec struct{}
+
+func (noneCodec) ID() byte {
+	return CodecIDNone
+}
+
+func (noneCodec) Compress(w io.Writer) io.WriteCloser {
+	return nopWriteCloser{w}
+}
+
+func (noneCodec) Decompress(r io.Reader) io.Reader {
+	return r
+}
+
+// zlibCodec is the deflate of compress/zlib, the one of the first launchers
+type zlibCodec struct{}
+
+func (zlibCodec) ID() byte {
+	return CodecIDZlib
+}
+
+func (zlibCodec) Compress(w io.Writer) io.WriteCloser {
+	return zlib.NewWriter(w)
+}
+
+func (zlibCodec) Decompress(r io.Reader) io.Reader {
+	reader, err := artifact.Decompress(CodecIDZlib, r)
+	if err != nil {
+		return errorReader{err}
+	}
+
+	return reader
+}
+
+// gzipCodec is the deflate of compress/gzip
+type gzipCodec struct{}
+
+func (gzipCodec) ID() byte {
+	return CodecIDGzip
+}
+
+func (gzipCodec) Compress(w io.Writer) io.WriteCloser {
+	return gzip.NewWriter(w)
+}
+
+func (gzipCodec) Decompress(r io.Reader) io.Reader {
+	reader, err := artifact.Decompress(CodecIDGzip, r)
+	if err != nil {
+		return errorReader{err}
+	}
+
+	return reader
+}
+
+/*
+upxCodec is the whole binary pseudo codec of -c: the launcher is
+compressed by the external upx, its UPX headers stripped, and it
+decompresses itself when run, so decompressing is reading it as it is.
+It is never a codec of the blobs.
+*/
+type upxCodec struct {
+	ctx context.Context
+}
+
+func (upxCodec) ID() byte {
+	return CodecIDUPX
+}
+
+func (c upxCodec) Compress(w io.Writer) io.WriteCloser {
+	return &upxWriter{ctx: c.ctx, w: w}
+}
+
+func (upxCodec) Decompress(r io.Reader) io.Reader {
+	return r
+}
+
+// upxWriter collects the binary, that upx compresses on Close
+type upxWriter struct {
+	bytes.Buffer
+	ctx context.Context
+	w   io.Writer
+}
+
+func (u *upxWriter) Close() error {
+	file, err := ioutil.TempFile("", "pakkero-upx-")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(u.Bytes())
+	file.Close()
+
+	if err != nil {
+		return err
+	}
+
+	err = execTool(u.ctx, "", "upx", []string{file.Name()}, nil)
+	if err != nil {
+		return err
+	}
+
+	if !StripUPXHeaders(file.Name()) {
+		return errors.New("cannot strip the UPX headers")
+	}
+
+	content, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		return err
+	}
+
+	_, err = u.w.Write(content)
+
+	return err
+}
+
+/*
+CompressContent will compress the input with the codec of the blobs,
+see RegisterCodec, after the ID of the codec.
+*/
+func CompressContent(input []byte) []byte {
+	return compressWith(blobCodec, input)
+}
+
+// compressWith will compress the input with the codec, after its ID
+func compressWith(codec Codec, input []byte) []byte {
+	progressStage(ProgressCompress)
+
+	var compressed bytes.Buffer
+
+	compressed.WriteByte(codec.ID())
+
+	writer := codec.Compress(&compressed)
+	total := int64(len(input))
+
+	var err error
+
+	for done := int64(0); done < total && err == nil; {
+		end := done + progressChunk
+		if end > total {
+			end = total
+		}
+
+		_, err = writer.Write(input[done:end])
+		done = end
+
+		progressStep(done, total)
+	}
+
+	if err == nil {
+		err = writer.Close()
+	}
+
+	if total == 0 {
+		progressStep(0, 0)
+	}
+
+	if err != nil {
+		panic(err)
+	}
+
+	return compressed.Bytes()
+}
+
+// codecOf returns the blob codec of the ID
+func codecOf(id byte) (Codec, error) {
+	for _, codec := range Codecs {
+		if codec.ID() == id {
+			return codec, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown codec id %d", id)
+}
+
+/*
+DecompressContent will decompress a blob compressed by CompressContent,
+with the codec of its ID: a corrupted stream is an error, never a panic.
+*/
+func DecompressContent(input []byte) ([]byte, error) {
+	if len(input) == 0 {
+		return nil, errors.New("empty blob, no codec id")
+	}
+
+	codec, err := codecOf(input[0])
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadAll(codec.Decompress(bytes.NewReader(input[1:])))
+	if err != nil {
+		return nil, fmt.Errorf("corrupted stream: %s", err)
+	}
+
+	return content, nil
+}
+
+/*
+compressFile will compress a whole file in place with the codec, like
+the launcher with upxCodec, keeping its mode.
+*/
+func compressFile(path string, codec Codec) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var compressed bytes.Buffer
+
+	writer := codec.Compress(&compressed)
+
+	_, err = writer.Write(content)
+	if err == nil {
+		err = writer.Close()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, compressed.Bytes(), 0755)
+}
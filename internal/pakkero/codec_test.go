@@ -0,0 +1,86 @@
+package pakkero
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	codec := blobCodec
+
+	t.Cleanup(func() {
+		blobCodec = codec
+		delete(Secrets, codecIDPlaceholder)
+	})
+
+	inputs := [][]byte{{}, []byte("pakkero"), bytes.Repeat([]byte("0123456789"), progressChunk/4)}
+
+	for _, name := range CodecNames() {
+		err := RegisterCodec(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, input := range inputs {
+			compressed := CompressContent(input)
+			if compressed[0] != Codecs[name].ID() {
+				t.Errorf("%s: the blob starts with %d", name, compressed[0])
+			}
+
+			content, err := DecompressContent(compressed)
+			if err != nil || !bytes.Equal(content, input) {
+				t.Errorf("%s, %d bytes: %d bytes, %v", name, len(input), len(content), err)
+			}
+		}
+	}
+
+	if err := RegisterCodec("lz4"); err == nil {
+		t.Error("lz4: expected an error")
+	}
+}
+
+func TestDecompressContentErrors(t *testing.T) {
+	zlib := compressWith(zlibCodec{}, []byte("pakkero pakkero pakkero"))
+	gzip := compressWith(gzipCodec{}, []byte("pakkero pakkero pakkero"))
+
+	tests := []struct {
+		name     string
+		input    []byte
+		expected string
+	}{
+		{"empty", nil, "empty blob"},
+		{"unknown codec", []byte{0xee, 1, 2}, "unknown codec id 238"},
+		{"upx is no blob codec", []byte{CodecIDUPX, 1, 2}, "unknown codec id"},
+		{"truncated zlib", zlib[:len(zlib)/2], "corrupted stream"},
+		{"gzip read as zlib", append([]byte{CodecIDZlib}, gzip[1:]...), "corrupted stream"},
+	}
+
+	for _, test := range tests {
+		_, err := DecompressContent(test.input)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%s: %v, expected %q", test.name, err, test.expected)
+		}
+	}
+}
+
+// a codec has its own ID and its own launcher feature
+func TestCodecFeatures(t *testing.T) {
+	ids := map[byte]string{CodecIDUPX: "upx"}
+
+	for _, name := range CodecNames() {
+		id := Codecs[name].ID()
+		if other, ok := ids[id]; ok {
+			t.Errorf("%s and %s share the ID %d", name, other, id)
+		}
+
+		ids[id] = name
+
+		features := codecFeatures(name)
+		for feature, enabled := range features {
+			if enabled != (feature == "codec"+name) {
+				t.Errorf("%s: feature %s is %v", name, feature, enabled)
+			}
+		}
+	}
+}
@@ -0,0 +1,35 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Daemonize library
+*/
+package pakkero
+
+import (
+	"fmt"
+)
+
+const daemonLogPlaceholder = `"DAEMONLOG"`
+const daemonPidfilePlaceholder = `"DAEMONPIDFILE"`
+
+/*
+RegisterDaemon will validate the log path and the pidfile
+of the daemonized payload and add them to the secrets.
+*/
+func RegisterDaemon(opts Options) error {
+	for placeholder, path := range map[string]string{
+		daemonLogPlaceholder:     opts.LogPath,
+		daemonPidfilePlaceholder: opts.Pidfile,
+	} {
+		if path == "" {
+			continue
+		}
+
+		if !IsTargetAbs(path) {
+			return fmt.Errorf("invalid path %q, use absolute paths", path)
+		}
+
+		Secrets[placeholder] = []string{path, GenerateTyposquatName()}
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Decoy library
+*/
+package pakkero
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+)
+
+const decoyStartPlaceholder = `"DECOYSTART"`
+const decoySizePlaceholder = `"DECOYSIZE"`
+
+// nonce and tag added by AES-GCM to the plaintext
+const gcmOverhead = 12 + 16
+
+// encodeDecoy returns the decoy with the same encoding and compression of the payload
+func encodeDecoy(decoy string) ([]byte, error) {
+	byteContent, err := ioutil.ReadFile(decoy)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading decoy: %s", err)
+	}
+
+	return CompressContent([]byte(base64.StdEncoding.EncodeToString(byteContent))), nil
+}
+
+/*
+RegisterDecoy will read and compress the decoy, and register its position
+in the launcher: the decoy is placed right before the payload offset,
+inside the pre-payload garbage.
+Returns the compressed decoy and its starting position.
+*/
+func RegisterDecoy(decoy string, offset int64) ([]byte, int64, error) {
+	plaintext, err := encodeDecoy(decoy)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	size := int64(len(plaintext) + gcmOverhead)
+
+	start := offset - size
+	if start <= 0 {
+		return nil, 0, fmt.Errorf("decoy is bigger than the offset: decoy=%d, offset=%d", size, offset)
+	}
+
+	Secrets[decoyStartPlaceholder] = []string{fmt.Sprintf("%d", start),
+		GenerateTyposquatName()}
+	Secrets[decoySizePlaceholder] = []string{fmt.Sprintf("%d", size),
+		GenerateTyposquatName()}
+
+	return plaintext, start, nil
+}
@@ -0,0 +1,210 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Dependency registration library
+*/
+package pakkero
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// placeholder in the launcher where the dependencies are declared
+const depListPlaceholder = "// OB_DEPENDENCIES"
+
+// Dependency check modes
+const (
+	// byte frequency distribution and size within 15%
+	DepModeBFD = "bfd"
+	// exact sha256 of the content
+	DepModeDigest = "digest"
+	// exact size
+	DepModeSize = "size"
+	// the file exists
+	DepModeExists = "exists"
+)
+
+var depModes = []string{DepModeBFD, DepModeDigest, DepModeSize, DepModeExists}
+
+// Dependency mismatch policies
+const (
+	// the launcher takes the tamper reaction
+	DepPolicyEnforce = "enforce"
+	// the payload runs with OB_DEP_MISMATCH=1 in the environment
+	DepPolicyWarn = "warn"
+	// the decoy runs instead of the payload
+	DepPolicyDegrade = "degrade"
+)
+
+var depPolicies = []string{DepPolicyEnforce, DepPolicyWarn, DepPolicyDegrade}
+
+/*
+BoundDepEnvPrefix starts the variables of the payload with the path of
+the descriptor of each dependency verified, with -bind-deps: it is
+followed by the index of the dependency, in the order they are given.
+*/
+const BoundDepEnvPrefix = "OB_DEP_FD_"
+
+// Dependency is a file that must be present for the launcher to run
+type Dependency struct {
+	Path   string
+	Mode   string
+	Policy string
+}
+
+/*
+cutSuffix will remove from the input a ":suffix" if suffix
+is one of the allowed values, returning the found suffix.
+*/
+func cutSuffix(input string, allowed []string) (string, string) {
+	separator := strings.LastIndex(input, ":")
+	if separator > 0 {
+		for _, v := range allowed {
+			if input[separator+1:] == v {
+				return input[:separator], v
+			}
+		}
+	}
+
+	return input, ""
+}
+
+/*
+ParseDependency will parse a dependency in the form /path/to/file[:mode][:policy],
+the default mode is the statistical (bfd) one and the default policy is enforce.
+*/
+func ParseDependency(input string) (Dependency, error) {
+	dependency := Dependency{Mode: DepModeBFD, Policy: DepPolicyEnforce}
+
+	path, policy := cutSuffix(input, depPolicies)
+	if policy != "" {
+		dependency.Policy = policy
+	}
+
+	path, mode := cutSuffix(path, depModes)
+	if mode != "" {
+		dependency.Mode = mode
+	}
+
+	dependency.Path = path
+
+	if !IsTargetAbs(dependency.Path) {
+		return dependency, fmt.Errorf("invalid dependency %s: use absolute paths", dependency.Path)
+	}
+
+	return dependency, nil
+}
+
+/*
+ParseDependencies will parse a list of dependencies, each entry
+can also be a comma separated list.
+*/
+func ParseDependencies(input []string) ([]Dependency, error) {
+	result := []Dependency{}
+
+	for _, entry := range input {
+		for _, v := range strings.Split(entry, ",") {
+			if v == "" {
+				continue
+			}
+
+			dependency, err := ParseDependency(v)
+			if err != nil {
+				return nil, err
+			}
+
+			result = append(result, dependency)
+		}
+	}
+
+	return result, nil
+}
+
+/*
+RegisterDependency will take a file in input and register the
+Byte Frequency Distribution (BFD) and some other data to let the launcher
+do statystical analysis of the found files.
+The registration is returned as the launcher code declaring it.
+*/
+func RegisterDependency(dependency Dependency) (string, error) {
+	depenencyLinkStats, err := os.Lstat(dependency.Path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read dependency %s: %s", dependency.Path, err)
+	}
+
+	if (depenencyLinkStats.Mode() & os.ModeSymlink) != 0 {
+		return "", fmt.Errorf("invalid path: %s is a symlink, use absolute paths", dependency.Path)
+	}
+
+	// read the whole file, this also ensures it is readable
+	bytes, err := ioutil.ReadFile(dependency.Path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read dependency %s: %s", dependency.Path, err)
+	}
+
+	digest := sha256.Sum256(bytes)
+
+	// calculate BFD (byte frequency distribution) for the input dependency
+	bfdString := "[]float64{"
+
+	if dependency.Mode == DepModeBFD {
+		bfd := make([]float64, 256)
+
+		for _, b := range bytes {
+			bfd[b]++
+		}
+		// make a string out of it
+		for _, v := range bfd {
+			bfdString += fmt.Sprintf("%f", v) + ","
+		}
+	}
+
+	bfdString += "}"
+
+	// the path goes through the secrets, not quoted in the source: a
+	// path with quotes or backslashes would escape the obfuscation
+	namePlaceholder := fmt.Sprintf(`"DEPNAME%x"`, sha256.Sum256([]byte(dependency.Path)))
+	Secrets[namePlaceholder] = []string{dependency.Path, GenerateTyposquatName()}
+	markSensitive("a -register-dep path", dependency.Path)
+
+	// strings in the declaration will be obfuscated
+	// together with all the other launcher's strings
+	return fmt.Sprintf("obDependency{\n"+
+		"obDepName: obSensitive(nil, %s),\n"+
+		"obDepSize: \"%d\",\n"+
+		"obDepDigest: obSensitive(nil, %q),\n"+
+		"obDepMode: %q,\n"+
+		"obDepPolicy: %q,\n"+
+		"obDepBFD: %s,\n"+
+		"},",
+		namePlaceholder,
+		len(bytes),
+		hex.EncodeToString(digest[:]),
+		dependency.Mode,
+		dependency.Policy,
+		bfdString), nil
+}
+
+/*
+RegisterDependencies will register all the dependencies in the launcher,
+failing if any of them cannot be read.
+*/
+func RegisterDependencies(launcher string, dependencies []Dependency) (string, error) {
+	declarations := []string{}
+
+	for _, dependency := range dependencies {
+		declaration, err := RegisterDependency(dependency)
+		if err != nil {
+			return launcher, err
+		}
+
+		declarations = append(declarations, declaration)
+	}
+
+	return strings.Replace(launcher, depListPlaceholder,
+		strings.Join(declarations, "\n"), 1), nil
+}
@@ -0,0 +1,280 @@
+package pakkero
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// the launcher declarations checking and binding the dependencies
+var dependencyDecls = []string{
+	"obDependency", "obCorrelationLevel", "obStdLevel", "obFileSizeLevel", "obCheckDependency",
+	"obLauncherEnv", "obDegradedFlag", "obDependencyCheck", "obDependencyReact", "obDependencyMatch",
+	"obKeepDependencies", "obBoundDeps", "obBoundDepsLock", "obBindDependencies",
+	"obSensitiveStat", "obSensitiveOpen", "obWithPath", "obWipe", "obReadAll", "obUnsafeString",
+	"obSensitive", "obSysMEMFDCreate", "obSyscallTable", "obAtSymlinkNoFollow",
+	"obUtilBFDCalc", "obUtilAbsCalc", "obUtilCovarianceCalc", "obUtilStandardDeviationCalc",
+	"obUtilCombinedStandardDeviationCalc",
+}
+
+/*
+dependencyProgram returns a program with the dependencies registered in
+the launcher declarations, and the main given. The tamper reaction
+prints the check and exits.
+*/
+func dependencyProgram(t *testing.T, dependencies []Dependency, main string) string {
+	t.Helper()
+
+	if _, ok := launcherArchs[runtime.GOARCH]; !ok {
+		t.Skipf("the launcher has no syscall table for %s", runtime.GOARCH)
+	}
+
+	keepSecrets(t)
+
+	decls := []string{}
+	for _, name := range dependencyDecls {
+		decls = append(decls, templateDecl(t, name))
+	}
+
+	features := archFeatures(runtime.GOARCH)
+	features["binddeps"] = true
+	features["depwarn"] = true
+	features["decoy"] = true
+
+	program, err := RegisterDependencies(StripFeatures(`package main
+
+import (
+	"fmt"
+	obHex "encoding/hex"
+	obSHA256 "crypto/sha256"
+	obIO "io"
+	obUtilio "io/ioutil"
+	obMath "math"
+	obOS "os"
+	obExec "os/exec"
+	obStrconv "strconv"
+	obSync "sync"
+	obAtomic "sync/atomic"
+	obSyscall "syscall"
+	obUnsafe "unsafe"
+)
+
+var _ = obUtilio.ReadFile
+
+func obTamper(obCheck int) {
+	fmt.Println("tampered", obCheck == obCheckDependency)
+	obOS.Exit(0)
+}
+
+`+strings.Join(decls, "\n\n")+"\n\n"+main, features), dependencies)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the paths are resolved by the string obfuscation in a pack
+	for placeholder, secret := range Secrets {
+		if strings.HasPrefix(placeholder, `"DEPNAME`) {
+			program = strings.ReplaceAll(program, placeholder, strconv.Quote(secret[0]))
+		}
+	}
+
+	return program
+}
+
+// writeDependencies writes each dependency, named by its content
+func writeDependencies(t *testing.T, contents ...string) []string {
+	t.Helper()
+
+	dir := t.TempDir()
+	paths := []string{}
+
+	for _, content := range contents {
+		path := filepath.Join(dir, content)
+
+		err := ioutil.WriteFile(path, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		paths = append(paths, path)
+	}
+
+	return paths
+}
+
+/*
+Two dependencies are registered and checked in the order given, and
+bound to the payload in that order. One of them modified is a refusal.
+*/
+func TestDependenciesBound(t *testing.T) {
+	paths := writeDependencies(t, "libssl", "config")
+
+	dependencies, err := ParseDependencies([]string{paths[0] + ":digest," + paths[1] + ":digest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	program := dependencyProgram(t, dependencies, `func main() {
+	if obOS.Getenv("DEPENDENCY_CHILD") != "" {
+		for _, obName := range []string{"OB_DEP_FD_0", "OB_DEP_FD_1"} {
+			obContent, obErr := obUtilio.ReadFile(obOS.Getenv(obName))
+			fmt.Println(obName, string(obContent), obErr)
+		}
+
+		return
+	}
+
+	obDependencyCheck()
+
+	obCommand := obExec.Command("/proc/self/exe")
+	obCommand.Env = append(obOS.Environ(), "DEPENDENCY_CHILD=1")
+	obCommand.Stdout = obOS.Stdout
+	obBindDependencies(obCommand)
+	obCommand.Run()
+}
+`)
+
+	output := runProgram(t, program)
+
+	expected := "OB_DEP_FD_0 libssl <nil>\nOB_DEP_FD_1 config <nil>\n"
+	if output != expected {
+		t.Errorf("bound dependencies: %q, expected %q", output, expected)
+	}
+
+	// same size, another digest
+	err = ioutil.WriteFile(paths[1], []byte("CONFIG"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output = runProgram(t, program)
+	if output != "tampered true\n" {
+		t.Errorf("a modified dependency: %q, expected the tamper reaction", output)
+	}
+}
+
+/*
+Each policy accepts an intact dependency, and reacts to a modified one:
+enforce with the tamper reaction, warn telling the payload and degrade
+running the decoy.
+*/
+func TestDependencyPolicies(t *testing.T) {
+	tests := []struct {
+		policy   string
+		expected string
+	}{
+		{DepPolicyEnforce, "tampered true\n"},
+		{DepPolicyWarn, "[OB_DEP_MISMATCH=1] 0\n"},
+		{DepPolicyDegrade, "[] 1\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			paths := writeDependencies(t, "libssl")
+
+			dependencies, err := ParseDependencies([]string{paths[0] + ":size:" + test.policy})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if dependencies[0].Mode != DepModeSize || dependencies[0].Policy != test.policy {
+				t.Fatalf("parsed %+v", dependencies[0])
+			}
+
+			program := dependencyProgram(t, dependencies, `func main() {
+	obDependencyCheck()
+	fmt.Println(obLauncherEnv, obAtomic.LoadInt32(&obDegradedFlag))
+}
+`)
+
+			output := runProgram(t, program)
+			if output != "[] 0\n" {
+				t.Errorf("an intact dependency: %q, expected no reaction", output)
+			}
+
+			err = ioutil.WriteFile(paths[0], []byte("libssl upgraded"), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			output = runProgram(t, program)
+			if output != test.expected {
+				t.Errorf("a modified dependency: %q, expected %q", output, test.expected)
+			}
+		})
+	}
+}
+
+/*
+The payload reads the dependency verified, even if its path is replaced
+after the check, and from its start: a check that follows keeps the
+first descriptors bound. A path replaced by a symbolic link fails.
+*/
+func TestDependenciesBoundReplaced(t *testing.T) {
+	paths := writeDependencies(t, "libssl")
+	replacement := writeDependencies(t, "LIBSSL")[0]
+
+	dependencies, err := ParseDependencies([]string{paths[0] + ":digest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	program := dependencyProgram(t, dependencies, `func main() {
+	if obOS.Getenv("DEPENDENCY_CHILD") != "" {
+		obContent, obErr := obUtilio.ReadFile(obOS.Getenv("OB_DEP_FD_0"))
+		fmt.Println(string(obContent), obErr)
+
+		return
+	}
+
+	obDependencyCheck()
+	obDependencyCheck()
+	obOS.Rename(`+strconv.Quote(replacement)+`, `+strconv.Quote(paths[0])+`)
+
+	obCommand := obExec.Command("/proc/self/exe")
+	obCommand.Env = append(obOS.Environ(), "DEPENDENCY_CHILD=1")
+	obCommand.Stdout = obOS.Stdout
+	obBindDependencies(obCommand)
+	obCommand.Run()
+
+	obDependencyCheck()
+}
+`)
+
+	output := runProgram(t, program)
+	if output != "libssl <nil>\ntampered true\n" {
+		t.Errorf("a dependency replaced after its check: %q", output)
+	}
+
+	// the same content, through a symbolic link
+	paths = writeDependencies(t, "libssl")
+	original := writeDependencies(t, "libssl")[0]
+
+	dependencies, err = ParseDependencies([]string{paths[0] + ":digest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	program = dependencyProgram(t, dependencies, `func main() {
+	obDependencyCheck()
+	fmt.Println("checked")
+}
+`)
+
+	err = os.Remove(paths[0])
+	if err == nil {
+		err = os.Symlink(original, paths[0])
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if output := runProgram(t, program); output != "tampered true\n" {
+		t.Errorf("a dependency replaced by a symbolic link: %q", output)
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Directives library
+*/
+package pakkero
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Directives naming launcher identifiers, see DirectivePolicies
+const (
+	DirectiveExport    = "export"
+	DirectiveLinkname  = "linkname"
+	DirectiveCgoExport = "cgo_export"
+)
+
+// What the name obfuscation does with the names of a directive
+const (
+	// the names are never renamed
+	DirectiveKeep = "keep"
+	// the names are renamed, in the directive too
+	DirectiveRename = "rename"
+)
+
+/*
+DirectivePolicies tells, for each directive, whether the names it holds
+are renamed with the other ob names, the directive included, or kept:
+the C name of an //export must stay a C identifier, the typosquatted
+names are not.
+*/
+var DirectivePolicies = map[string]string{
+	DirectiveExport:    DirectiveKeep,
+	DirectiveLinkname:  DirectiveRename,
+	DirectiveCgoExport: DirectiveKeep,
+}
+
+// the names of each directive, the first group is the local one
+var directiveRegexes = map[string]*regexp.Regexp{
+	DirectiveExport:    regexp.MustCompile(`(?m)^[ \t]*//export[ \t]+([^ \t\r\n]+)`),
+	DirectiveLinkname:  regexp.MustCompile(`(?m)^[ \t]*//go:linkname[ \t]+([^ \t\r\n]+)(?:[ \t]+([^ \t\r\n]+))?`),
+	DirectiveCgoExport: regexp.MustCompile(`(?m)^[ \t]*//go:cgo_export_(?:static|dynamic)[ \t]+([^ \t\r\n]+)(?:[ \t]+([^ \t\r\n]+))?`),
+}
+
+/*
+ParseDirectivePolicies will set the DirectivePolicies passed in the form
+directive=policy, the directives not listed keep their default.
+*/
+func ParseDirectivePolicies(input []string) error {
+	for _, item := range input {
+		pair := strings.SplitN(item, "=", 2)
+		if len(pair) != 2 {
+			return fmt.Errorf("invalid directive policy %q, use directive=policy", item)
+		}
+
+		if _, known := DirectivePolicies[pair[0]]; !known {
+			return fmt.Errorf("unknown directive %q, directives are: %s, %s, %s",
+				pair[0], DirectiveExport, DirectiveLinkname, DirectiveCgoExport)
+		}
+
+		switch {
+		case pair[1] != DirectiveKeep && pair[1] != DirectiveRename:
+			return fmt.Errorf("unknown policy %q for %s, policies are: %s, %s",
+				pair[1], pair[0], DirectiveKeep, DirectiveRename)
+		case pair[0] == DirectiveExport && pair[1] == DirectiveRename:
+			return fmt.Errorf("the %s names are C identifiers, they cannot be renamed", DirectiveExport)
+		}
+
+		DirectivePolicies[pair[0]] = pair[1]
+	}
+
+	return nil
+}
+
+/*
+directiveNames returns the ob names held by the directives whose
+policy is DirectiveKeep: the local identifier and, for the ones
+naming a symbol, its last element.
+*/
+func directiveNames(input string) map[string]bool {
+	kept := map[string]bool{}
+
+	for directive, regex := range directiveRegexes {
+		if DirectivePolicies[directive] != DirectiveKeep {
+			continue
+		}
+
+		for _, match := range regex.FindAllStringSubmatch(input, -1) {
+			for _, name := range match[1:] {
+				name = name[strings.LastIndex(name, ".")+1:]
+				if strings.HasPrefix(name, "ob") {
+					kept[name] = true
+				}
+			}
+		}
+	}
+
+	return kept
+}
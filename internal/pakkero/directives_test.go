@@ -0,0 +1,129 @@
+package pakkero
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// keepDirectivePolicies restores the DirectivePolicies once the test is done
+func keepDirectivePolicies(t *testing.T) {
+	t.Helper()
+
+	policies := map[string]string{}
+	for directive, policy := range DirectivePolicies {
+		policies[directive] = policy
+	}
+
+	t.Cleanup(func() { DirectivePolicies = policies })
+}
+
+func TestParseDirectivePolicies(t *testing.T) {
+	keepDirectivePolicies(t)
+
+	err := ParseDirectivePolicies([]string{"linkname=keep", "cgo_export=rename"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if DirectivePolicies[DirectiveLinkname] != DirectiveKeep ||
+		DirectivePolicies[DirectiveCgoExport] != DirectiveRename ||
+		DirectivePolicies[DirectiveExport] != DirectiveKeep {
+		t.Errorf("policies %v", DirectivePolicies)
+	}
+
+	for input, message := range map[string]string{
+		"linkname":        "use directive=policy",
+		"embed=keep":      "unknown directive",
+		"linkname=drop":   "unknown policy",
+		"export=rename":   "cannot be renamed",
+		"cgo_export=KEEP": "unknown policy",
+	} {
+		err = ParseDirectivePolicies([]string{input})
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("%s: error %v, expected %q", input, err, message)
+		}
+	}
+}
+
+/*
+The names held by a directive whose policy is keep stay as they are,
+the others are renamed in the directive too. Only whole names are
+replaced: keeping a name keeps none that it prefixes.
+*/
+func TestObfuscateNamesDirectives(t *testing.T) {
+	keepDirectivePolicies(t)
+
+	kept := keptNames
+	defer func() { keptNames = kept }()
+
+	program := `package main
+
+import (
+	obFmt "fmt"
+	_ "unsafe"
+)
+
+//export obExported
+func obExported() {}
+
+//go:cgo_export_static obStatic obStaticSymbol
+func obStatic() {}
+
+//go:linkname obNanotime runtime.nanotime
+func obNanotime() int64
+
+func obExportedTwice() int64 { return obNanotime() }
+
+func main() {
+	obFmt.Println(obExportedTwice() > 0)
+}
+`
+
+	for _, test := range []struct {
+		policies []string
+		kept     []string
+		renamed  []string
+	}{
+		{nil, []string{"obExported", "obStatic", "obStaticSymbol"}, []string{"obNanotime", "obExportedTwice", "obFmt"}},
+		{[]string{"linkname=keep", "cgo_export=rename"}, []string{"obExported", "obNanotime"},
+			[]string{"obStatic", "obStaticSymbol", "obExportedTwice"}},
+	} {
+		err := ParseDirectivePolicies(test.policies)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keptNames = nil
+		obfuscated := ObfuscateFuncVars(program)
+		name := fmt.Sprint(test.policies)
+
+		for _, kept := range test.kept {
+			if !strings.Contains(obfuscated, kept) {
+				t.Errorf("%s: %s is renamed", name, kept)
+			}
+		}
+
+		for _, renamed := range test.renamed {
+			if strings.Contains(obfuscated, renamed) {
+				t.Errorf("%s: %s is kept", name, renamed)
+			}
+		}
+
+		if len(keptNames) != len(test.kept) {
+			t.Errorf("%s: kept %q, expected %q", name, keptNames, test.kept)
+		}
+
+		typeCheck(t, obfuscated)
+	}
+
+	// the local name of a linkname is renamed, the program still links
+	DirectivePolicies[DirectiveLinkname] = DirectiveRename
+
+	program = strings.NewReplacer("//export obExported\n", "", "//go:cgo_export_static obStatic obStaticSymbol\n", "").
+		Replace(program)
+
+	if output := runProgram(t, ObfuscateFuncVars(program)); output != "true\n" {
+		t.Errorf("output %q", output)
+	}
+}
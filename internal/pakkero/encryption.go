@@ -7,10 +7,9 @@ package pakkero
 import (
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/rand"
-	"crypto/sha512"
-	"io"
 	"io/ioutil"
+
+	"github.com/89luca89/pakkero/artifact"
 )
 
 /*
@@ -36,30 +35,37 @@ func EncryptAESReversed(plaintext []byte, outfile string) (string, error) {
 	}
 
 	// use SHA512 (32byte) of the passphrase as key
-	key := sha512.Sum512_256(b)
+	key := artifact.PayloadKey(b)
 
 	//	generate new cipher
 	c, err := aes.NewCipher(key[:])
 	if err != nil {
-		println(err)
+		return "", err
 	}
 
 	gcm, err := cipher.NewGCM(c)
 	if err != nil {
-		println(err)
+		return "", err
 	}
 
 	nonce := make([]byte, gcm.NonceSize())
-	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
-		println(err)
+	if err = randomRead(nonce); err != nil {
+		return "", err
 	}
 
+	progressStage(ProgressEncrypt)
+
 	// cipher the payload with AESGCM using the generated password
 	bCiphertext := gcm.Seal(nonce, nonce, plaintext, nil)
+	total := int64(len(bCiphertext))
 
 	// swap endianess on all the encrypted bytes
 	for i := range bCiphertext {
 		bCiphertext[i] = ReverseByte(bCiphertext[i])
+
+		if i&(progressChunk-1) == 0 {
+			progressStep(int64(i), total)
+		}
 	}
 
 	ciphertext := string(bCiphertext)
@@ -67,5 +73,7 @@ func EncryptAESReversed(plaintext []byte, outfile string) (string, error) {
 	// reverse the complete payload
 	ciphertext = string(ReverseByteArray([]byte(ciphertext)))
 
+	progressStep(total, total)
+
 	return ciphertext, nil
 }
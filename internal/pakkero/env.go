@@ -0,0 +1,119 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Payload environment library
+*/
+package pakkero
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Environment policies, deciding which inherited variables reach the payload
+const (
+	EnvPassthrough = "passthrough"
+	EnvClear       = "clear"
+	EnvAllowlist   = "allowlist"
+)
+
+const envAllowlistPlaceholder = `"ENVALLOWLIST"`
+const payloadEnvPlaceholder = `"PAYLOADENV"`
+
+var envNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+/*
+EnvPolicy is the environment policy of the payload: with passthrough it
+inherits every variable, with clear none, with allowlist only the
+Allowed ones.
+*/
+type EnvPolicy struct {
+	Mode    string
+	Allowed []string
+}
+
+/*
+ParseEnvPolicy will parse an environment policy in the form
+clear, passthrough or allowlist:NAME,NAME...
+An empty input is passthrough.
+*/
+func ParseEnvPolicy(input string) (EnvPolicy, error) {
+	mode := input
+	allowed := ""
+
+	if strings.Contains(input, ":") {
+		pair := strings.SplitN(input, ":", 2)
+		mode, allowed = pair[0], pair[1]
+	}
+
+	switch mode {
+	case "", EnvPassthrough:
+		if allowed != "" {
+			return EnvPolicy{}, fmt.Errorf("%s does not take a list of variables", EnvPassthrough)
+		}
+
+		return EnvPolicy{Mode: EnvPassthrough}, nil
+	case EnvClear:
+		if allowed != "" {
+			return EnvPolicy{}, fmt.Errorf("%s does not take a list of variables", EnvClear)
+		}
+
+		return EnvPolicy{Mode: EnvClear}, nil
+	case EnvAllowlist:
+		names := strings.Split(allowed, ",")
+		for _, name := range names {
+			if !envNameRegex.MatchString(name) {
+				return EnvPolicy{}, fmt.Errorf("invalid variable name %q", name)
+			}
+		}
+
+		return EnvPolicy{Mode: EnvAllowlist, Allowed: Unique(names)}, nil
+	default:
+		return EnvPolicy{}, fmt.Errorf("unknown policy %q, policies are: %s, %s, %s:NAME,NAME",
+			mode, EnvPassthrough, EnvClear, EnvAllowlist)
+	}
+}
+
+/*
+ParsePayloadEnv will validate the NAME=value variables
+to be baked in the launcher.
+*/
+func ParsePayloadEnv(input []string) error {
+	for _, variable := range input {
+		pair := strings.SplitN(variable, "=", 2)
+		if len(pair) != 2 || !envNameRegex.MatchString(pair[0]) {
+			return fmt.Errorf("invalid variable %q, expected NAME=value", variable)
+		}
+	}
+
+	return nil
+}
+
+/*
+RegisterEnv will register in the launcher the environment policy and
+the variables given at packing time, those are sensitive secrets and,
+like the arguments, NUL terminated.
+*/
+func RegisterEnv(policy EnvPolicy, payloadEnv []string) error {
+	err := ParsePayloadEnv(payloadEnv)
+	if err != nil {
+		return err
+	}
+
+	if policy.Mode == EnvAllowlist {
+		Secrets[envAllowlistPlaceholder] = []string{strings.Join(policy.Allowed, ","),
+			GenerateTyposquatName()}
+	}
+
+	if len(payloadEnv) > 0 {
+		Secrets[payloadEnvPlaceholder] = []string{strings.Join(payloadEnv, "\x00") + "\x00",
+			GenerateTyposquatName()}
+	}
+
+	for _, variable := range payloadEnv {
+		pair := strings.SplitN(variable, "=", 2)
+		markSensitive("the -payload-env "+pair[0], pair[1])
+	}
+
+	return nil
+}
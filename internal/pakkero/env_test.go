@@ -0,0 +1,123 @@
+package pakkero
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvPolicy(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected EnvPolicy
+		err      string
+	}{
+		{"", EnvPolicy{Mode: EnvPassthrough}, ""},
+		{"clear", EnvPolicy{Mode: EnvClear}, ""},
+		{"allowlist:PATH,HOME,PATH", EnvPolicy{Mode: EnvAllowlist, Allowed: []string{"PATH", "HOME"}}, ""},
+		{"allowlist:PATH,1HOME", EnvPolicy{}, "invalid variable name"},
+		{"clear:PATH", EnvPolicy{}, "does not take a list"},
+		{"scrub", EnvPolicy{}, "unknown policy"},
+	}
+
+	for _, test := range tests {
+		policy, err := ParseEnvPolicy(test.input)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: error %v, expected %q", test.input, err, test.err)
+			}
+
+			continue
+		}
+
+		if err != nil || policy.Mode != test.expected.Mode ||
+			strings.Join(policy.Allowed, ",") != strings.Join(test.expected.Allowed, ",") {
+			t.Errorf("%q: %+v, %v, expected %+v", test.input, policy, err, test.expected)
+		}
+	}
+}
+
+/*
+The payload gets the inherited variables of the policy, then the packed
+ones, then the ones of the launcher. The file of the launcher debug
+messages is never forwarded, even if allowed.
+*/
+func TestLauncherPayloadEnviron(t *testing.T) {
+	t.Setenv("KEEP_ME", "1")
+	t.Setenv("DROP_ME", "1")
+	t.Setenv("PAKKERO_LAUNCHER_DEBUG_FILE", "/dev/null")
+
+	tests := []struct {
+		policy     string
+		payloadEnv []string
+		expected   string
+	}{
+		{"passthrough", nil, "KEEP_ME=1 DROP_ME=1 OB_DEP_MISMATCH=1"},
+		{"clear", []string{"BAKED=s3cret"}, "BAKED=s3cret OB_DEP_MISMATCH=1"},
+		{"allowlist:KEEP_ME,PAKKERO_LAUNCHER_DEBUG_FILE", []string{"BAKED=s3cret"},
+			"KEEP_ME=1 BAKED=s3cret OB_DEP_MISMATCH=1"},
+	}
+
+	decls := []string{}
+	for _, name := range []string{"obInheritedEnviron", "obLauncherEnv", "obPayloadEnviron", "obSensitive", "obWipe"} {
+		decls = append(decls, templateDecl(t, name))
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			keepSecrets(t)
+
+			policy, err := ParseEnvPolicy(test.policy)
+			if err == nil {
+				err = RegisterEnv(policy, test.payloadEnv)
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			features := Options{EnvPolicy: policy, PayloadEnv: test.payloadEnv, LauncherDebug: true}.launcherFeatures()
+
+			program := StripFeatures(`package main
+
+import (
+	obBytes "bytes"
+	"fmt"
+	obOS "os"
+	obStrings "strings"
+)
+
+// without packed variables, the launcher debug build splits no bytes
+var _ = obBytes.Split
+
+`+strings.Join(decls, "\n\n")+`
+
+func main() {
+	obLauncherEnv = []string{"OB_DEP_MISMATCH=1"}
+	obResult := []string{}
+
+	for _, obVariable := range obPayloadEnviron() {
+		switch obStrings.SplitN(obVariable, "=", 2)[0] {
+		case "KEEP_ME", "DROP_ME", "PAKKERO_LAUNCHER_DEBUG_FILE", "BAKED", "OB_DEP_MISMATCH":
+			obResult = append(obResult, obVariable)
+		}
+	}
+
+	fmt.Println(obStrings.Join(obResult, " "))
+}
+`, features)
+
+			// the variables are resolved by the string obfuscation in a pack
+			for _, placeholder := range []string{envAllowlistPlaceholder, payloadEnvPlaceholder} {
+				if secret, ok := Secrets[placeholder]; ok {
+					program = strings.ReplaceAll(program, placeholder, strconv.Quote(secret[0]))
+				}
+			}
+
+			output := runProgram(t, program)
+			if output != test.expected+"\n" {
+				t.Errorf("payload environment: %q, expected %q", output, test.expected)
+			}
+		})
+	}
+}
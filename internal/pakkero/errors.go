@@ -0,0 +1,214 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Errors library
+*/
+package pakkero
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+/*
+PackError is the failure of a pack: the step it happened in, its cause,
+the output of the tool that failed, if any, a hint on how to fix it and
+the exit code of the pack. Every failed step of Pakkero is reported as
+one, see failStep, the same way on the terminal and in the -error-json
+file, and returned.
+*/
+type PackError struct {
+	Stage  string
+	Err    error
+	Output string
+	Hint   string
+	// ERR if zero, see ExitCode
+	Code int
+}
+
+func (e *PackError) Error() string {
+	if e.Stage == "" {
+		return e.Err.Error()
+	}
+
+	return e.Stage + ": " + e.Err.Error()
+}
+
+func (e *PackError) Unwrap() error {
+	return e.Err
+}
+
+// MarshalJSON writes the cause as its message
+func (e *PackError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Stage  string `json:"stage"`
+		Error  string `json:"error"`
+		Output string `json:"output,omitempty"`
+		Hint   string `json:"hint,omitempty"`
+	}{e.Stage, e.Err.Error(), e.Output, e.Hint})
+}
+
+/*
+ExitCode returns the exit code of the result of a pack: OK if err is
+nil, the Code of the PackError it wraps, else ERR.
+*/
+func ExitCode(err error) int {
+	if err == nil {
+		return OK
+	}
+
+	var packErr *PackError
+	if errors.As(err, &packErr) && packErr.Code != 0 {
+		return packErr.Code
+	}
+
+	return ERR
+}
+
+// hints of the tools that failed, see execTool
+var toolHints = map[string]string{
+	"go": "the go output is above, with -vv its command too: a pre-obfuscate hook can break the " +
+		"launcher source, another toolchain can be pinned with -tool go=/path",
+	"garble": "pack without -use-garble, or pin another garble with -tool garble=/path",
+	"strip":  "pin another strip with -tool strip=/path, or install binutils",
+	"upx":    "pack without -c, or pin another upx with -tool upx=/path",
+}
+
+// ToolMissingHint returns the hint of a tool that cannot be run
+func ToolMissingHint(name string) string {
+	return fmt.Sprintf("install %s, or pin it with -tool %s=/path", name, name)
+}
+
+/*
+errorHint returns the hint of the cause of an error that has none, empty
+if there is nothing to suggest.
+*/
+func errorHint(err error) string {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return "check the path, the file does not exist"
+	case errors.Is(err, os.ErrPermission):
+		return "check the permissions of the file and of its directory"
+	case errors.Is(err, ErrCorruptOutput):
+		return "the output did not read back as written, check the free space and the filesystem of -o"
+	}
+
+	return ""
+}
+
+/*
+StageError returns err as a PackError of the stage: the stage, output
+and hint of the PackErrors it wraps are kept, the outer ones first, a
+missing hint comes from its cause, see errorHint.
+*/
+func StageError(stage string, err error) *PackError {
+	result := &PackError{Stage: stage, Err: err}
+
+	for cause := err; cause != nil; cause = errors.Unwrap(cause) {
+		inner, ok := cause.(*PackError)
+		if !ok {
+			continue
+		}
+
+		if inner.Stage != "" && result.Stage == stage {
+			result.Stage = inner.Stage
+		}
+
+		if result.Output == "" {
+			result.Output = inner.Output
+		}
+
+		if result.Hint == "" {
+			result.Hint = inner.Hint
+		}
+
+		// not wrapped, it is the error itself
+		if err == cause {
+			result.Err = inner.Err
+		}
+	}
+
+	if result.Hint == "" {
+		result.Hint = errorHint(err)
+	}
+
+	return result
+}
+
+/*
+ReportError will log the error and its hint, and write it as json to
+jsonPath if not empty, both redacted. The output of the tool is not logged, the tool
+run did it already.
+*/
+func ReportError(err *PackError, jsonPath string) {
+	Log.Errorf("%s", err)
+
+	if err.Hint != "" {
+		hint := redact(err.Hint)
+		Log.write(LevelError, fmt.Sprintf(WarningColor, "hint: ")+hint, "hint: "+hint)
+	}
+
+	if jsonPath == "" {
+		return
+	}
+
+	content, _ := json.MarshalIndent(err, "", "  ")
+
+	writeErr := ioutil.WriteFile(jsonPath, []byte(redact(string(content))+"\n"), 0644)
+	if writeErr != nil {
+		Log.Errorf("cannot write the error to %s: %s", jsonPath, writeErr)
+	}
+}
+
+/*
+failStep will mark the step in progress as failed, report err as a
+PackError of it and return it, for Pakkero to return after the cleanup.
+*/
+func (opts Options) failStep(err error) error {
+	return opts.failStepWith(ERR, err)
+}
+
+// failStepWith is failStep with the exit code of the pack
+func (opts Options) failStepWith(code int, err error) error {
+	stage := Log.Step()
+	if stage != "" {
+		Log.Done(StatusErr)
+	}
+
+	packErr := StageError(stage, err)
+	packErr.Code = code
+
+	ReportError(packErr, opts.ErrorJSON)
+
+	// the failed packs are in the history too
+	if opts.AuditLog != "" && !auditWritten {
+		auditErr := appendAudit(opts, "", packErr)
+		if auditErr != nil {
+			Log.Errorf("cannot append to the audit log %s: %s", opts.AuditLog, auditErr)
+		}
+	}
+
+	return packErr
+}
+
+/*
+timeoutError returns the error of a stage that failed for its timeout,
+the global one or a cancellation, with how to avoid it, else err.
+*/
+func (opts Options) timeoutError(ctx context.Context, stageCtx context.Context, stage string, err error) error {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return &PackError{Err: fmt.Errorf("global timeout exceeded during stage %s", stage),
+			Hint: "raise -timeout"}
+	case errors.Is(ctx.Err(), context.Canceled):
+		return &PackError{Err: fmt.Errorf("canceled during stage %s", stage)}
+	case errors.Is(stageCtx.Err(), context.DeadlineExceeded):
+		return &PackError{Err: fmt.Errorf("stage %s timed out after %s", stage, opts.StageTimeouts[stage]),
+			Hint: fmt.Sprintf("raise it with -stage-timeout %s=duration", stage)}
+	}
+
+	return err
+}
@@ -0,0 +1,138 @@
+package pakkero
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStageError(t *testing.T) {
+	tool := &PackError{Err: errors.New("exit status 1"), Output: "no room", Hint: toolHints["strip"]}
+
+	err := StageError("Stripping Launcher", tool)
+	if err.Stage != "Stripping Launcher" || err.Output != "no room" || err.Hint != toolHints["strip"] ||
+		err.Err.Error() != "exit status 1" {
+		t.Errorf("tool failure: %+v", err)
+	}
+
+	// the stage of an inner PackError wins, its hint is kept through the wrapping
+	inner := &PackError{Stage: "Compiling Launcher", Err: errors.New("cannot use"), Hint: toolHints["go"]}
+
+	err = StageError("Running pre-obfuscate hooks", fmt.Errorf("hook: %w", inner))
+	if err.Stage != "Compiling Launcher" || err.Hint != toolHints["go"] {
+		t.Errorf("wrapped failure: %+v", err)
+	}
+
+	_, missing := os.Open("/nonexistent/pakkero")
+
+	err = StageError("Checking Options", fmt.Errorf("payload: %w", missing))
+	if err.Hint != "check the path, the file does not exist" {
+		t.Errorf("missing input: %+v", err)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected int
+	}{
+		{nil, OK},
+		{errors.New("failed"), ERR},
+		{&PackError{Err: errors.New("failed")}, ERR},
+		{fmt.Errorf("pack: %w", &PackError{Err: errors.New("mismatch"), Code: ERRVERIFY}), ERRVERIFY},
+	}
+
+	for _, test := range tests {
+		if code := ExitCode(test.err); code != test.expected {
+			t.Errorf("%v: %d, expected %d", test.err, code, test.expected)
+		}
+	}
+}
+
+// a failed pack returns its error to the caller, with its stage and hint, instead of exiting
+func TestPakkeroReturnsPackError(t *testing.T) {
+	dir := t.TempDir()
+
+	err := Pakkero(context.Background(), Options{
+		InFile:  filepath.Join(dir, "missing"),
+		OutFile: filepath.Join(dir, "out"),
+		Offset:  2900000,
+	})
+
+	var packErr *PackError
+	if !errors.As(err, &packErr) {
+		t.Fatalf("%v is not a PackError", err)
+	}
+
+	if packErr.Stage != "Checking Options" || !strings.HasPrefix(packErr.Hint, "check the path") ||
+		ExitCode(err) != ERR {
+		t.Errorf("missing payload: %+v", packErr)
+	}
+}
+
+// a canceled pack stops at the next stage, with its error
+func TestPakkeroCanceled(t *testing.T) {
+	dir := t.TempDir()
+
+	payload := filepath.Join(dir, "payload")
+
+	err := ioutil.WriteFile(payload, []byte("#!/bin/sh\n"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = Pakkero(ctx, Options{InFile: payload, OutFile: filepath.Join(dir, "out"), Offset: 2900000})
+
+	var packErr *PackError
+	if !errors.As(err, &packErr) || !strings.HasPrefix(packErr.Err.Error(), "canceled during stage") {
+		t.Errorf("canceled pack: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "out")); !os.IsNotExist(err) {
+		t.Errorf("a canceled pack left its output: %v", err)
+	}
+}
+
+// a pack failing once the launcher workspace exists leaves the environment of pakkero alone
+func TestPakkeroLeavesEnvironment(t *testing.T) {
+	dir := t.TempDir()
+
+	payload := filepath.Join(dir, "payload")
+
+	err := ioutil.WriteFile(payload, []byte("#!/bin/sh\n"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("GOTMPDIR", "")
+	t.Setenv("GOARCH", "riscv64")
+	t.Setenv("CGO_ENABLED", "1")
+
+	err = Pakkero(context.Background(), Options{
+		InFile:  payload,
+		OutFile: filepath.Join(dir, "out"),
+		Offset:  2900000,
+		Arch:    "amd64",
+		Hooks: Hooks{PreObfuscate: func(ctx context.Context, src string) (string, error) {
+			return "", errors.New("stop")
+		}},
+	})
+	var packErr *PackError
+	if !errors.As(err, &packErr) || packErr.Stage != "Running pre-obfuscate hooks" {
+		t.Fatalf("the failing hook did not stop the pack: %v", err)
+	}
+
+	for name, value := range map[string]string{"GOTMPDIR": "", "GOARCH": "riscv64", "CGO_ENABLED": "1"} {
+		if os.Getenv(name) != value {
+			t.Errorf("%s changed to %q", name, os.Getenv(name))
+		}
+	}
+}
@@ -0,0 +1,313 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Estimate library
+*/
+package pakkero
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/89luca89/pakkero/artifact"
+)
+
+/*
+EstimateTolerance is how far the estimated output size can be from the
+one of the pack, in percent, for a launcher not compressed nor changed
+by the hooks, built with the toolchain of launcherBaselines.
+*/
+const EstimateTolerance = 5
+
+/*
+launcherBaselines are the sizes of the launcher of the default options,
+by arch, as measureLauncher finds them: built with go 1.27, another
+toolchain moves them by a few percent.
+*/
+var launcherBaselines = map[string]int64{
+	"386":     2307724,
+	"amd64":   2461168,
+	"arm":     2347100,
+	"arm64":   2347100,
+	"riscv64": 2216700,
+}
+
+const (
+	// how much the launcher grows per byte of template source its features keep
+	launcherBytesPerSource = 7
+	// how much each integrity guard adds to the launcher
+	launcherBytesPerGuard = 512
+	// how much the obfuscated secrets add to the measured launcher
+	launcherSecretsSize = 16 << 10
+	// what is left of the launcher compressed by UPX, in percent, a rough guess
+	upxLauncherRatio = 45
+)
+
+const (
+	// the payload is sampled in chunks of this size, a multiple of 3 so that they encode alone
+	estimateChunk = 48 << 10
+	// how many chunks are sampled, a smaller payload is compressed whole
+	estimateChunks = 16
+	// the bytes hashed and encrypted to measure the throughput of this host
+	estimateThroughputSize = 8 << 20
+)
+
+/*
+StartupEstimate is how long the launcher takes to derive the keys, from
+everything before each blob it decrypts, to decrypt and to decompress
+the payload, on this host. The checks and the execution of the payload
+are not counted.
+*/
+type StartupEstimate struct {
+	KeyDerivation time.Duration `json:"key_derivation_ns"`
+	Decryption    time.Duration `json:"decryption_ns"`
+	Decompression time.Duration `json:"decompression_ns"`
+	Total         time.Duration `json:"total_ns"`
+}
+
+/*
+Estimate is what a pack of some options is predicted to be, without
+building it: the layout before the offset, the payload after it, the
+final padding, the whole output size, how much the codec compresses the
+payload and the launcher startup.
+*/
+type Estimate struct {
+	Layout      OffsetLayout
+	Offset      int64
+	Payload     int64
+	Padding     int64
+	Size        int64
+	Compression float64
+	Startup     StartupEstimate
+}
+
+/*
+estimateLauncher returns the predicted size of the launcher of the
+options as measureLauncher finds it, without the secrets: the baseline
+of its arch, grown by the template source that its features keep more
+than the default ones, and by its guards.
+*/
+func estimateLauncher(opts Options) int64 {
+	stub, _ := base64.StdEncoding.DecodeString(LauncherStub)
+
+	source := int64(len(StripFeatures(string(stub), opts.buildFeatures())))
+	baseline := int64(len(StripFeatures(string(stub), Options{Arch: opts.Arch}.buildFeatures())))
+
+	size := launcherBaselines[opts.Arch] + (source-baseline)*launcherBytesPerSource +
+		int64(opts.Guards)*launcherBytesPerGuard
+
+	if opts.Compress {
+		size = size * upxLauncherRatio / 100
+	}
+
+	return size
+}
+
+/*
+samplePayload returns the encoded samples of the payload, what the codec
+compresses, and the encoded size of the whole of it: estimateChunks
+evenly spread, or all of it if it is smaller.
+*/
+func samplePayload(infile string) ([][]byte, int64, error) {
+	file, err := os.Open(infile)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	size := stat.Size()
+	encoded := int64(base64.StdEncoding.EncodedLen(int(size)))
+
+	if size <= estimateChunk*estimateChunks {
+		content, err := ioutil.ReadAll(file)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return [][]byte{[]byte(base64.StdEncoding.EncodeToString(content))}, encoded, nil
+	}
+
+	samples := [][]byte{}
+	stride := (size - estimateChunk) / (estimateChunks - 1)
+
+	for i := int64(0); i < estimateChunks; i++ {
+		chunk := make([]byte, estimateChunk)
+
+		_, err = file.ReadAt(chunk, i*stride)
+		if err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+
+		samples = append(samples, []byte(base64.StdEncoding.EncodeToString(chunk)))
+	}
+
+	return samples, encoded, nil
+}
+
+// throughput returns how long fn takes per byte of a buffer of size bytes
+func throughput(size int, fn func([]byte)) float64 {
+	buffer := make([]byte, size)
+
+	start := time.Now()
+	fn(buffer)
+
+	return float64(time.Since(start)) / float64(size)
+}
+
+/*
+estimatePadding returns the mean final padding of the offsets the
+hysteresis can choose from base, see artifact.FinalPadding.
+*/
+func estimatePadding(base int64) int64 {
+	total := int64(0)
+	for hysteresis := int64(128); hysteresis < 4094; hysteresis++ {
+		total += artifact.FinalPadding(base + hysteresis)
+	}
+
+	return total / (4094 - 128)
+}
+
+/*
+EstimatePack returns the Estimate of packing with the options, reading
+a sample of the payload only: the launcher is not built, see
+estimateLauncher, the payload is compressed by samplePayload and the
+startup is timed by hashing, decrypting and decompressing buffers of
+the same sizes on this host.
+The offset is the middle of the hysteresis, the scattered fragments
+are the mean of PlanScatter.
+*/
+func EstimatePack(opts Options) (Estimate, error) {
+	estimate := Estimate{}
+
+	codec, ok := Codecs[opts.codec()]
+	if !ok {
+		codec = blobCodec
+	}
+
+	blobs, err := blobsSize(opts)
+	if err != nil {
+		return estimate, err
+	}
+
+	samples, encoded, err := samplePayload(opts.InFile)
+	if err != nil {
+		return estimate, err
+	}
+
+	launcherSize := estimateLauncher(opts)
+
+	base := opts.Offset
+	if opts.AutoOffset {
+		base = AutoOffset(launcherSize, opts.OffsetPadding, blobs)
+	}
+
+	// the middle of the hysteresis, see Pakkero
+	estimate.Offset = base + (128+4094)/2
+	estimate.Layout = OffsetLayout{
+		LauncherSize: launcherSize + launcherSecretsSize,
+		KeyMaterial:  base - launcherSize - launcherSecretsSize - markerSize - blobs,
+		Blobs:        blobs,
+		Auto:         opts.AutoOffset,
+	}
+
+	// compress the samples, and time their decompression
+	sampled, compressed := int64(0), int64(0)
+	decompression := time.Duration(0)
+
+	for _, sample := range samples {
+		stream := compressWith(codec, sample)
+
+		start := time.Now()
+
+		_, err = io.Copy(ioutil.Discard, codec.Decompress(bytes.NewReader(stream[1:])))
+		if err != nil {
+			return estimate, err
+		}
+
+		decompression += time.Since(start)
+		sampled += int64(len(sample))
+		compressed += int64(len(stream) - 1)
+	}
+
+	estimate.Compression = float64(compressed) / float64(sampled)
+	estimate.Payload = 1 + int64(float64(encoded)*estimate.Compression) + gcmOverhead
+
+	// the fragments before the offset are the mean of PlanScatter, as the garbage fits them
+	if opts.Layout == LayoutScatter {
+		scattered := estimate.Payload * 13 / 24
+		if room := estimate.Layout.KeyMaterial - scatterMapSize; scattered > room {
+			scattered = room
+		}
+
+		estimate.Payload -= scattered
+	}
+
+	estimate.Padding = estimatePadding(base)
+	estimate.Size = estimate.Offset + estimate.Payload + estimate.Padding
+
+	if opts.SignKey != "" {
+		estimate.Size += artifact.SignatureSize
+	}
+
+	// a key from everything before each blob
+	hashes := 1
+	for _, enabled := range []bool{len(opts.BundleLibs) > 0, opts.Prologue != "", opts.Whiten} {
+		if enabled {
+			hashes++
+		}
+	}
+
+	hashTime := throughput(estimateThroughputSize, func(buffer []byte) {
+		sha512.Sum512_256(buffer)
+	})
+
+	// opening what is sealed here, reversed as the launcher does
+	key := sha512.Sum512_256(nil)
+	block, _ := aes.NewCipher(key[:])
+	gcm, _ := cipher.NewGCM(block)
+	nonce := make([]byte, gcm.NonceSize())
+	sealed := gcm.Seal(nil, nonce, make([]byte, estimateThroughputSize), nil)
+
+	decryptTime := throughput(estimateThroughputSize, func([]byte) {
+		for i := range sealed {
+			sealed[i] = ReverseByte(sealed[i])
+		}
+
+		for i := range sealed {
+			sealed[i] = ReverseByte(sealed[i])
+		}
+
+		_, _ = gcm.Open(nil, nonce, sealed, nil)
+	})
+
+	// the payload is decoded once decompressed
+	encodedSample := bytes.Repeat([]byte("QUJD"), estimateThroughputSize/4)
+
+	decodeTime := throughput(estimateThroughputSize, func(buffer []byte) {
+		base64.StdEncoding.Decode(buffer, encodedSample)
+	})
+
+	payloadSize := 1 + int64(float64(encoded)*estimate.Compression) + gcmOverhead
+
+	estimate.Startup = StartupEstimate{
+		KeyDerivation: time.Duration(hashTime * float64(int64(hashes)*estimate.Offset)),
+		Decryption:    time.Duration(decryptTime * float64(payloadSize)),
+		Decompression: time.Duration(float64(decompression)*float64(encoded)/float64(sampled) +
+			decodeTime*float64(encoded)),
+	}
+	estimate.Startup.Total = estimate.Startup.KeyDerivation + estimate.Startup.Decryption +
+		estimate.Startup.Decompression
+
+	return estimate, nil
+}
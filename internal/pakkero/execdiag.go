@@ -0,0 +1,117 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Exec diagnostics library
+*/
+package pakkero
+
+import (
+	"debug/elf"
+	"fmt"
+	"strings"
+)
+
+const execInterpreterPlaceholder = `"EXECINTERP"`
+const execMachinePlaceholder = `"EXECMACHINE"`
+const execNeededPlaceholder = `"EXECNEEDED"`
+const execLibDirsPlaceholder = `"EXECLIBDIRS"`
+
+// directories searched by the glibc and musl dynamic loaders after their cache
+var defaultLibraryDirs = []string{"/lib", "/usr/lib", "/usr/local/lib"}
+
+// the Debian multiarch directory of the libraries of each machine
+var multiarchTriplets = map[elf.Machine]string{
+	elf.EM_386:     "i386-linux-gnu",
+	elf.EM_X86_64:  "x86_64-linux-gnu",
+	elf.EM_ARM:     "arm-linux-gnueabihf",
+	elf.EM_AARCH64: "aarch64-linux-gnu",
+	elf.EM_RISCV:   "riscv64-linux-gnu",
+}
+
+/*
+ExecRequirements is what the payload needs from the target to run: the
+interpreter of its shebang or its dynamic linker, the machine of an ELF,
+the libraries of its DT_NEEDED not bundled, and the directories the
+dynamic linker looks for them in, its run path and the default ones.
+*/
+type ExecRequirements struct {
+	Interpreter string
+	Machine     elf.Machine
+	Needed      []string
+	LibraryDirs []string
+}
+
+/*
+PayloadRequirements returns the ExecRequirements of the payload, the
+libraries in bundle are left out. A script needs its interpreter only,
+a static binary nothing but its machine.
+*/
+func PayloadRequirements(infile string, bundle []string) (ExecRequirements, error) {
+	interpreter, err := payloadInterpreter(infile)
+	if err != nil {
+		return ExecRequirements{}, err
+	}
+
+	requirements := ExecRequirements{Interpreter: interpreter}
+
+	payload, err := elf.Open(infile)
+	if err != nil {
+		// not an ELF
+		return requirements, nil
+	}
+	defer payload.Close()
+
+	requirements.Machine = payload.Machine
+
+	for _, lib := range NeededLibraries(infile, bundle) {
+		if !lib.Bundled {
+			requirements.Needed = append(requirements.Needed, lib.Name)
+		}
+	}
+
+	if len(requirements.Needed) == 0 {
+		return requirements, nil
+	}
+
+	// $ORIGIN is the memfd directory once packed, those entries are useless
+	for _, tag := range []elf.DynTag{elf.DT_RUNPATH, elf.DT_RPATH} {
+		paths, _ := payload.DynString(tag)
+		for _, path := range paths {
+			for _, dir := range strings.Split(path, ":") {
+				if strings.HasPrefix(dir, "/") && !strings.Contains(dir, "$") {
+					requirements.LibraryDirs = append(requirements.LibraryDirs, dir)
+				}
+			}
+		}
+	}
+
+	if triplet, ok := multiarchTriplets[payload.Machine]; ok {
+		requirements.LibraryDirs = append(requirements.LibraryDirs, "/lib/"+triplet, "/usr/lib/"+triplet)
+	}
+
+	if payload.Class == elf.ELFCLASS64 {
+		requirements.LibraryDirs = append(requirements.LibraryDirs, "/lib64", "/usr/lib64")
+	}
+
+	requirements.LibraryDirs = append(requirements.LibraryDirs, defaultLibraryDirs...)
+
+	return requirements, nil
+}
+
+/*
+RegisterExecDiagnostics will add the ExecRequirements of the payload to
+the secrets, so that the launcher tells which one the target misses
+when the payload does not run.
+*/
+func RegisterExecDiagnostics(infile string, bundle []string) (ExecRequirements, error) {
+	requirements, err := PayloadRequirements(infile, bundle)
+	if err != nil {
+		return requirements, err
+	}
+
+	Secrets[execInterpreterPlaceholder] = []string{requirements.Interpreter, GenerateTyposquatName()}
+	Secrets[execMachinePlaceholder] = []string{fmt.Sprintf("%d", requirements.Machine), GenerateTyposquatName()}
+	Secrets[execNeededPlaceholder] = []string{strings.Join(requirements.Needed, ":"), GenerateTyposquatName()}
+	Secrets[execLibDirsPlaceholder] = []string{strings.Join(requirements.LibraryDirs, ":"), GenerateTyposquatName()}
+
+	return requirements, nil
+}
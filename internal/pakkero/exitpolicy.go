@@ -0,0 +1,61 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Exit policy library
+*/
+package pakkero
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const payloadTimeoutPlaceholder = `"PAYLOADTIMEOUT"`
+const payloadKillAfterPlaceholder = `"PAYLOADKILLAFTER"`
+const successCodesPlaceholder = `"SUCCESSCODES"`
+
+// DefaultPayloadKillAfter is the grace between SIGTERM and SIGKILL at the timeout
+const DefaultPayloadKillAfter = 10 * time.Second
+
+/*
+ParseSuccessCodes will parse a comma separated list of exit codes,
+from 0 to 255, like 0,3.
+*/
+func ParseSuccessCodes(input string) ([]int, error) {
+	codes := []int{}
+
+	for _, item := range strings.Split(input, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil || code < 0 || code > 255 {
+			return nil, fmt.Errorf("invalid exit code %q, use numbers from 0 to 255", item)
+		}
+
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+/*
+RegisterExitPolicy will add the payload timeout and the success codes
+to the secrets, so that they will be embedded obfuscated in the
+launcher, the durations in milliseconds.
+*/
+func RegisterExitPolicy(opts Options) {
+	if opts.PayloadTimeout > 0 {
+		Secrets[payloadTimeoutPlaceholder] = []string{
+			fmt.Sprintf("%d", opts.PayloadTimeout.Milliseconds()), GenerateTyposquatName()}
+		Secrets[payloadKillAfterPlaceholder] = []string{
+			fmt.Sprintf("%d", opts.PayloadKillAfter.Milliseconds()), GenerateTyposquatName()}
+	}
+
+	if len(opts.SuccessCodes) > 0 {
+		codes := make([]string, len(opts.SuccessCodes))
+		for index, code := range opts.SuccessCodes {
+			codes[index] = strconv.Itoa(code)
+		}
+
+		Secrets[successCodesPlaceholder] = []string{strings.Join(codes, ","), GenerateTyposquatName()}
+	}
+}
@@ -0,0 +1,343 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Explain library
+*/
+package pakkero
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// examples of each transformation shown in the explain report
+const explainExamples = 3
+
+// runes of a typosquatted name shown in the explain report
+const explainNameLength = 8
+
+var aliasRegex = regexp.MustCompile(`^obImport[0-9]+Alias$`)
+
+/*
+ExplainedExample is an example of a transformation, secret is the
+plaintext it hides, if any: redacted in the report unless unsafe.
+*/
+type ExplainedExample struct {
+	Text     string
+	Secret   string
+	IsSecret bool
+}
+
+// ExplainedPass is what an obfuscation pass did to the launcher
+type ExplainedPass struct {
+	Name     string
+	Counts   []string
+	Examples []ExplainedExample
+}
+
+// the passes of the last ObfuscateLauncher, see WriteExplain
+var explainedPasses []ExplainedPass
+
+// the checks of each check point of the last GenerateRandomAntiDebug
+var checkSites []string
+
+// old and new names of the last obfuscateNames, in replacement order
+var renamedNames [][2]string
+
+// names the last obfuscateNames kept for their directives
+var keptNames []string
+
+// shortName returns the start of a typosquatted name, they are all 128 runes long
+func shortName(name string) string {
+	if utf8.RuneCountInString(name) <= explainNameLength {
+		return name
+	}
+
+	return string([]rune(name)[:explainNameLength]) + "..."
+}
+
+// explainAliases describes the import aliasing pass, from its output
+func explainAliases(content string) ExplainedPass {
+	pass := ExplainedPass{Name: "import aliasing"}
+	aliased := 0
+
+	file, err := parser.ParseFile(token.NewFileSet(), "launcher.go", content, parser.ImportsOnly)
+	if err == nil {
+		for _, spec := range file.Imports {
+			if spec.Name == nil || !aliasRegex.MatchString(spec.Name.Name) {
+				continue
+			}
+
+			aliased++
+
+			if len(pass.Examples) < explainExamples {
+				pass.Examples = append(pass.Examples, ExplainedExample{
+					Text: spec.Path.Value + " imported as " + spec.Name.Name,
+				})
+			}
+		}
+	}
+
+	pass.Counts = []string{fmt.Sprintf("%d imports aliased, renamed later with the ob names", aliased)}
+
+	return pass
+}
+
+// explainGuards describes the integrity guards pass
+func explainGuards(requested int, placed int, markers int) ExplainedPass {
+	return ExplainedPass{
+		Name: "integrity guards",
+		Counts: []string{
+			fmt.Sprintf("%d of %d requested guards placed at random, out of %d check markers",
+				placed, requested, markers),
+		},
+	}
+}
+
+// explainAntiDebug describes the anti-debug pass, from the checkSites
+func explainAntiDebug() ExplainedPass {
+	pass := ExplainedPass{
+		Name:   "anti-debug checks",
+		Counts: []string{fmt.Sprintf("%d check sites injected, each with its own ordering", len(checkSites))},
+	}
+
+	for i, site := range checkSites {
+		pass.Examples = append(pass.Examples, ExplainedExample{
+			Text: fmt.Sprintf("site %d: %s", i+1, site),
+		})
+	}
+
+	return pass
+}
+
+/*
+explainStrings describes the string obfuscation pass: input is its
+input, registered the Secrets before it ran, that are the ones of the
+options, the template strings are added by the pass.
+*/
+func explainStrings(input string, registered map[string]bool) ExplainedPass {
+	pass := ExplainedPass{Name: "string obfuscation"}
+
+	keys := []string{}
+	for k := range Secrets {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	strs, secrets, left := 0, 0, 0
+	strExamples, secretExamples := []ExplainedExample{}, []ExplainedExample{}
+
+	for _, k := range keys {
+		w := Secrets[k]
+
+		switch {
+		case !strings.Contains(input, k):
+			continue
+		case strings.Contains(w[1], "leave"):
+			left++
+		case registered[k]:
+			secrets++
+
+			secretExamples = append(secretExamples, ExplainedExample{
+				Text:     k + " -> " + shortName(w[1]) + "()",
+				Secret:   w[0],
+				IsSecret: true,
+			})
+		default:
+			strs++
+
+			strExamples = append(strExamples, ExplainedExample{
+				Text: k + " -> " + shortName(w[1]) + "()",
+			})
+		}
+	}
+
+	// each distinct call gets a function of its own
+	sensitive := 0
+	sensitiveExamples := []ExplainedExample{}
+	seen := map[string]bool{}
+
+	for _, match := range sensitiveRegex.FindAllStringSubmatch(input, -1) {
+		if seen[match[0]] {
+			continue
+		}
+
+		seen[match[0]] = true
+		sensitive++
+
+		value := match[2][1 : len(match[2])-1]
+		if secret, present := Secrets[match[2]]; present {
+			value = secret[0]
+		}
+
+		sensitiveExamples = append(sensitiveExamples, ExplainedExample{
+			Text:     "obSensitive(" + match[1] + ", ...) -> buffer filling function",
+			Secret:   value,
+			IsSecret: true,
+		})
+	}
+
+	pass.Counts = []string{
+		fmt.Sprintf("%d template strings obfuscated as byteshift functions, %d bytes per function",
+			strs, stringChunk),
+		fmt.Sprintf("%d option secrets obfuscated as byteshift functions", secrets),
+		fmt.Sprintf("%d sensitive secrets obfuscated as buffer filling byteshift functions", sensitive),
+		fmt.Sprintf("%d strings left as they are", left),
+	}
+
+	for _, examples := range [][]ExplainedExample{strExamples, secretExamples, sensitiveExamples} {
+		if len(examples) > explainExamples {
+			examples = examples[:explainExamples]
+		}
+
+		pass.Examples = append(pass.Examples, examples...)
+	}
+
+	return pass
+}
+
+// explainNames describes the name obfuscation pass, from the renamedNames
+func explainNames() ExplainedPass {
+	pass := ExplainedPass{
+		Name: "name obfuscation",
+		Counts: []string{
+			fmt.Sprintf("%d identifiers renamed to typosquatted names", len(renamedNames)),
+			fmt.Sprintf("%d identifiers kept for their directives", len(keptNames)),
+		},
+	}
+
+	for _, renamed := range renamedNames {
+		if len(pass.Examples) == explainExamples {
+			break
+		}
+
+		pass.Examples = append(pass.Examples, ExplainedExample{
+			Text: renamed[0] + " -> " + shortName(renamed[1]),
+		})
+	}
+
+	return pass
+}
+
+// redactSecret returns how a secret is shown in the report
+func redactSecret(secret string, unsafe bool) string {
+	if unsafe {
+		return strconv.Quote(secret)
+	}
+
+	return fmt.Sprintf("%s (%d bytes)", redacted, len(secret))
+}
+
+/*
+WriteExplain will write the report of the last ObfuscateLauncher:
+each pass with its counts and examples, the secrets plaintext is
+redacted unless unsafe. diff is the path of the diff, if any.
+*/
+func WriteExplain(path string, unsafe bool, diff string) error {
+	var report bytes.Buffer
+
+	fmt.Fprintf(&report, "pakkero %s obfuscation report\n", Version)
+
+	if unsafe {
+		fmt.Fprintf(&report, "secrets: shown in plaintext, do not share this report\n")
+	} else {
+		fmt.Fprintf(&report, "secrets: redacted, use -explain-unsafe to show them\n")
+	}
+
+	if diff != "" {
+		fmt.Fprintf(&report, "diff: %s\n", diff)
+	}
+
+	for i, pass := range explainedPasses {
+		fmt.Fprintf(&report, "\n%d. %s\n", i+1, pass.Name)
+
+		for _, count := range pass.Counts {
+			fmt.Fprintf(&report, "   %s\n", count)
+		}
+
+		for _, example := range pass.Examples {
+			if example.IsSecret {
+				fmt.Fprintf(&report, "   - %s = %s\n", example.Text, redactSecret(example.Secret, unsafe))
+			} else {
+				fmt.Fprintf(&report, "   - %s\n", example.Text)
+			}
+		}
+	}
+
+	return ioutil.WriteFile(path, report.Bytes(), 0600)
+}
+
+/*
+WriteExplainDiff will save the unified diff between the launcher source
+before the obfuscation and the obfuscated one in infile, returning its
+path. The diff is kept outside the workspace, that is removed.
+The sensitive secrets are redacted from the source before, unless
+unsafe; after the obfuscation they are byteshift operations, as
+in the launcher.
+*/
+func WriteExplainDiff(before string, infile string, unsafe bool) (string, error) {
+	if !unsafe {
+		before = sensitiveRegex.ReplaceAllString(before, "obSensitive($1, \""+redacted+"\")")
+	}
+
+	path, err := ResolveTool("diff")
+	if err != nil {
+		return "", err
+	}
+
+	source, err := ioutil.TempFile("", "pakkero-launcher-*.go")
+	if err != nil {
+		return "", err
+	}
+
+	Track(source.Name())
+	defer removeTracked(source.Name())
+
+	_, err = source.WriteString(before)
+	if err == nil {
+		err = source.Close()
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	output, err := ioutil.TempFile("", "pakkero-explain-*.diff")
+	if err != nil {
+		return "", err
+	}
+	defer output.Close()
+
+	var stderr bytes.Buffer
+
+	cmd := exec.Command(path, "-u", "-L", "launcher.go", "-L", "launcher.go.obfuscated",
+		source.Name(), infile)
+	cmd.Stdout = output
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+
+	// diff exits with 1 when the files differ, that is expected
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		err = nil
+	}
+
+	if err != nil {
+		os.Remove(output.Name())
+
+		return "", fmt.Errorf("diff failed: %s %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return output.Name(), nil
+}
@@ -0,0 +1,351 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Fat output library
+*/
+package pakkero
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// the members of a fat output start aligned to this, after the selector
+const fatAlignment = 4096
+
+/*
+fatMachines are the names uname -m gives for each arch, the selector
+picks the member of the arch of the first one matching.
+*/
+var fatMachines = map[string][]string{
+	"386":     {"i386", "i486", "i586", "i686"},
+	"amd64":   {"x86_64", "amd64"},
+	"arm":     {"armv6l", "armv7l", "armv8l", "arm"},
+	"arm64":   {"aarch64", "arm64", "aarch64_be"},
+	"riscv64": {"riscv64"},
+}
+
+/*
+fatSelectorTemplate is the front of a fat output, run by /bin/sh: it
+carves the member of the machine out of the file, to a directory that
+allows running files, probed as ProbeTempDir does, and runs it with "_"
+set to it, that the launcher checks. The member is removed when it
+exits, the selector exits with its code.
+Its verbs are the archs, the case of each one, see fatSelector, and the
+archs again.
+*/
+const fatSelectorTemplate = `#!/bin/sh
+# pakkero fat output: %s
+case "$(uname -m)" in
+%s*) echo "${0##*/}: no member for $(uname -m), only for: %s" >&2; exit 126 ;;
+esac
+d=
+for t in "$TMPDIR" "$XDG_RUNTIME_DIR" /tmp /var/tmp "$HOME"; do
+	[ -n "$t" ] && d=$(mktemp -d "$t/.pakkero-fat.XXXXXX" 2>/dev/null) || continue
+	printf '#!/bin/sh\nexit 0\n' > "$d/p" && chmod 700 "$d/p" && "$d/p" 2>/dev/null && break
+	rm -rf "$d"
+	d=
+done
+[ -n "$d" ] || { echo "${0##*/}: no temporary directory allows running files" >&2; exit 126; }
+trap 'rm -rf "$d"' EXIT HUP INT TERM
+a="$d/${0##*/}"
+tail -c +$((o + 1)) "$0" | head -c "$s" > "$a" && chmod 700 "$a" || exit 126
+env _="$a" "$a" "$@"
+exit $?
+`
+
+/*
+FatTarget is a member of a fat output to pack: the payload of an arch,
+packed with the options of the others.
+*/
+type FatTarget struct {
+	Arch string
+	File string
+}
+
+// FatMember is a member of a fat output, a pakkero artifact of an arch at an offset of it
+type FatMember struct {
+	Arch     string    `json:"arch"`
+	Offset   int64     `json:"offset"`
+	Size     int64     `json:"size"`
+	SHA256   string    `json:"sha256"`
+	Manifest *Manifest `json:"manifest,omitempty"`
+}
+
+/*
+ParseFatTarget will parse a -fat value, arch=file, the arch must be one
+a launcher can be built for.
+*/
+func ParseFatTarget(value string) (FatTarget, error) {
+	pair := strings.SplitN(value, "=", 2)
+	if len(pair) != 2 || pair[0] == "" || pair[1] == "" {
+		return FatTarget{}, fmt.Errorf("%q is not arch=file", value)
+	}
+
+	if _, ok := fatMachines[pair[0]]; !ok {
+		return FatTarget{}, fmt.Errorf("unsupported architecture %q, architectures are: %s",
+			pair[0], strings.Join(SupportedArchs(), ", "))
+	}
+
+	return FatTarget{Arch: pair[0], File: pair[1]}, nil
+}
+
+/*
+fatSelector returns the selector of the members, see
+fatSelectorTemplate, padded with zeros to fatAlignment: the shell
+never reads past its exit.
+*/
+func fatSelector(members []FatMember) ([]byte, error) {
+	archs := []string{}
+	cases := ""
+
+	for _, member := range members {
+		archs = append(archs, member.Arch)
+		cases += fmt.Sprintf("%s) o=%d s=%d ;;\n",
+			strings.Join(fatMachines[member.Arch], "|"), member.Offset, member.Size)
+	}
+
+	selector := fmt.Sprintf(fatSelectorTemplate, strings.Join(archs, " "), cases, strings.Join(archs, ", "))
+	if len(selector) > fatAlignment {
+		return nil, fmt.Errorf("the selector is %d bytes, more than %d", len(selector), fatAlignment)
+	}
+
+	return append([]byte(selector), make([]byte, fatAlignment-len(selector))...), nil
+}
+
+/*
+AssembleFat will write to outfile the selector of the members, the
+packed files at paths by arch, each one at an offset aligned to
+fatAlignment. Returns the members with their offset, size and sha256.
+*/
+func AssembleFat(outfile string, archs []string, paths map[string]string) ([]FatMember, error) {
+	members := []FatMember{}
+	offset := int64(fatAlignment)
+
+	for _, arch := range archs {
+		file, err := hashFile(paths[arch])
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, FatMember{Arch: arch, Offset: offset, Size: file.Size, SHA256: file.SHA256})
+		offset = (offset + file.Size + fatAlignment - 1) / fatAlignment * fatAlignment
+	}
+
+	selector, err := fatSelector(members)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := os.OpenFile(outfile, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer output.Close()
+
+	_, err = output.Write(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, member := range members {
+		_, err = output.Seek(member.Offset, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+
+		input, err := os.Open(paths[member.Arch])
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = io.Copy(output, input)
+		input.Close()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the padding after the last member is not written by the seek
+	return members, output.Truncate(members[len(members)-1].Offset + members[len(members)-1].Size)
+}
+
+/*
+FatConfig is how to pack a fat output: the members, packed each one by
+running Executable, pakkero, with Args, its arguments with no -fat, the
+output, the manifest and the payload and arch of each member added.
+Options are the ones of the first member: the fat output has the record
+in their AuditLog, and the error of a member is written to their
+ErrorJSON, Args must have neither.
+*/
+type FatConfig struct {
+	Executable string
+	Args       []string
+	Targets    []FatTarget
+	OutFile    string
+	Manifest   string
+	Options    Options
+}
+
+/*
+PackFat will pack each member of the config in a temporary directory,
+then assemble them in a workfile moved to the output, see AssembleFat.
+The manifest of the fat output is the one of the first member, with the
+output and the members, with their own manifest.
+A member that fails to pack has already reported its error, an
+*exec.ExitError is returned, the error of ctx if it is done.
+The members append no audit record, the fat output does.
+*/
+func PackFat(ctx context.Context, config FatConfig) error {
+	startLifecycle()
+
+	err := packFat(ctx, config)
+
+	if config.Options.AuditLog != "" {
+		output := config.OutFile
+		if err != nil {
+			output = ""
+		}
+
+		auditErr := appendAudit(config.Options, output, err)
+
+		switch {
+		case auditErr != nil && err == nil:
+			Log.Warnf("the audit log %s has no record of this pack: %s", config.Options.AuditLog, auditErr)
+		case auditErr != nil:
+			Log.Errorf("cannot append to the audit log %s: %s", config.Options.AuditLog, auditErr)
+		}
+	}
+
+	return err
+}
+
+// packFat is PackFat without the audit record
+func packFat(ctx context.Context, config FatConfig) error {
+	dir, err := ioutil.TempDir("", "pakkero-fat-")
+	if err != nil {
+		return err
+	}
+
+	Track(dir)
+
+	archs := []string{}
+	paths := map[string]string{}
+	manifests := map[string]string{}
+
+	for _, target := range config.Targets {
+		Log.Start("Packing the " + target.Arch + " member")
+
+		paths[target.Arch] = filepath.Join(dir, target.Arch+".enc")
+		manifests[target.Arch] = filepath.Join(dir, target.Arch+".json")
+
+		args := append(append([]string{}, config.Args...), "-arch", target.Arch, "-file", target.File,
+			"-o", paths[target.Arch], "-manifest", manifests[target.Arch])
+
+		// the error of the member is the one of the fat output
+		errorJSON := filepath.Join(dir, target.Arch+".error.json")
+		if config.Options.ErrorJSON != "" {
+			args = append(args, "-error-json", errorJSON)
+		}
+
+		cmd := exec.CommandContext(ctx, config.Executable, args...)
+		cmd.Stdout, cmd.Stderr = Log.Output, os.Stderr
+
+		err = cmd.Run()
+		if err != nil {
+			Log.Done(StatusErr)
+
+			if content, readErr := ioutil.ReadFile(errorJSON); readErr == nil {
+				readErr = ioutil.WriteFile(config.Options.ErrorJSON, content, 0644)
+				if readErr != nil {
+					Log.Errorf("cannot write the error to %s: %s", config.Options.ErrorJSON, readErr)
+				}
+			}
+
+			Cleanup()
+
+			// the member was killed, it could not report
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+
+			return fmt.Errorf("packing the %s member failed: %w", target.Arch, err)
+		}
+
+		archs = append(archs, target.Arch)
+
+		Log.Done(StatusOK)
+	}
+
+	Log.Start("Assembling the fat output")
+
+	// the output has the mode of the first member
+	workfile, members := "", []FatMember{}
+
+	stat, err := os.Stat(paths[archs[0]])
+	if err == nil {
+		workfile, err = createWorkFile(config.OutFile, stat.Mode().Perm())
+	}
+
+	if err == nil {
+		members, err = AssembleFat(workfile, archs, paths)
+	}
+
+	if err == nil {
+		err = commitWorkFile(workfile, config.OutFile)
+	}
+
+	if err == nil && config.Manifest != "" {
+		err = writeFatManifest(config, members, manifests)
+	}
+
+	if err == nil {
+		err = removeTracked(dir)
+	}
+
+	if err != nil {
+		Log.Done(StatusErr)
+		Cleanup()
+
+		return err
+	}
+
+	Log.Done(StatusOK)
+
+	return nil
+}
+
+/*
+writeFatManifest will write the manifest of the fat output, the one of
+the first member with the output and the members, with their manifests
+written by manifests by arch.
+*/
+func writeFatManifest(config FatConfig, members []FatMember, manifests map[string]string) error {
+	for i := range members {
+		manifest, err := ReadManifest(manifests[members[i].Arch])
+		if err != nil {
+			return err
+		}
+
+		// the member was packed to a temporary path, it has none
+		manifest.Output.Path = ""
+		members[i].Manifest = &manifest
+	}
+
+	output, err := hashFile(config.OutFile)
+	if err != nil {
+		return err
+	}
+
+	manifest := *members[0].Manifest
+	manifest.Output = output
+	manifest.Fat = members
+
+	return writeManifest(config.Manifest, manifest)
+}
@@ -0,0 +1,221 @@
+package pakkero
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseFatTarget(t *testing.T) {
+	target, err := ParseFatTarget("arm64=dist/payload=arm64")
+	if err != nil || target != (FatTarget{Arch: "arm64", File: "dist/payload=arm64"}) {
+		t.Errorf("arm64: %+v, %v", target, err)
+	}
+
+	for _, value := range []string{"arm64", "=payload", "arm64=", "mips=payload"} {
+		if _, err := ParseFatTarget(value); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
+
+// assembleTestFat assembles members printing their arch and arguments, and exiting with the first one
+func assembleTestFat(t *testing.T, archs ...string) (string, []FatMember) {
+	t.Helper()
+
+	dir := t.TempDir()
+	paths := map[string]string{}
+
+	for index, arch := range archs {
+		paths[arch] = filepath.Join(dir, arch)
+
+		// members of different sizes, the second one over the alignment
+		member := "#!/bin/sh\necho " + arch + " \"$@\"\nexit \"${1:-0}\"\n" +
+			"#" + strings.Repeat("x", index*fatAlignment) + "\n"
+
+		err := ioutil.WriteFile(paths[arch], []byte(member), 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	output := filepath.Join(dir, "fat")
+
+	err := ioutil.WriteFile(output, nil, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := AssembleFat(output, archs, paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return output, members
+}
+
+func TestAssembleFat(t *testing.T) {
+	output, members := assembleTestFat(t, "amd64", "arm64")
+
+	content, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(content, []byte("#!/bin/sh\n# pakkero fat output: amd64 arm64\n")) {
+		t.Errorf("selector: %q", content[:64])
+	}
+
+	for _, member := range members {
+		if member.Offset%fatAlignment != 0 {
+			t.Errorf("%s is at %d, not aligned", member.Arch, member.Offset)
+		}
+
+		carved := content[member.Offset : member.Offset+member.Size]
+		if !bytes.HasPrefix(carved, []byte("#!/bin/sh\necho "+member.Arch)) {
+			t.Errorf("%s: %q", member.Arch, carved[:32])
+		}
+	}
+
+	last := members[len(members)-1]
+	if int64(len(content)) != last.Offset+last.Size {
+		t.Errorf("%d bytes, the last member ends at %d", len(content), last.Offset+last.Size)
+	}
+}
+
+/*
+The selector runs the member of the machine uname -m says, with the
+arguments, exits with its code and removes it; 126 for a machine with
+no member.
+*/
+func TestFatSelector(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no /bin/sh")
+	}
+
+	output, _ := assembleTestFat(t, "amd64", "arm64")
+	bin := t.TempDir()
+
+	run := func(machine string, args ...string) (string, int) {
+		err := ioutil.WriteFile(filepath.Join(bin, "uname"), []byte("#!/bin/sh\necho "+machine+"\n"), 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tmp := t.TempDir()
+
+		cmd := exec.Command(output, args...)
+		cmd.Env = append(os.Environ(), "PATH="+bin+":"+os.Getenv("PATH"), "TMPDIR="+tmp)
+
+		result, err := cmd.CombinedOutput()
+
+		// the member is removed once it exits
+		if entries, _ := ioutil.ReadDir(tmp); len(entries) > 0 {
+			t.Errorf("%s: %s left", machine, entries[0].Name())
+		}
+
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return string(result), exitErr.ExitCode()
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(result), 0
+	}
+
+	tests := []struct {
+		machine  string
+		args     []string
+		expected string
+		code     int
+	}{
+		{"x86_64", nil, "amd64\n", 0},
+		{"aarch64", []string{"3", "two words"}, "arm64 3 two words\n", 3},
+		{"arm64", []string{"0"}, "arm64 0\n", 0},
+		{"riscv64", nil, "fat: no member for riscv64, only for: amd64, arm64\n", 126},
+	}
+
+	for _, test := range tests {
+		result, code := run(test.machine, test.args...)
+		if result != test.expected || code != test.code {
+			t.Errorf("%s %v: %q exit %d, expected %q exit %d", test.machine, test.args, result, code,
+				test.expected, test.code)
+		}
+	}
+}
+
+// writeFatPakkero writes a pakkero packing a member as a copy of its payload, failing one of arch with an error
+func writeFatPakkero(t *testing.T, dir string, failing string) string {
+	t.Helper()
+
+	script := `#!/bin/sh
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-arch) arch=$2; shift ;;
+	-file) file=$2; shift ;;
+	-o) output=$2; shift ;;
+	-error-json) errors=$2; shift ;;
+	esac
+	shift
+done
+if [ "$arch" = "` + failing + `" ]; then
+	echo '{"Stage": "Compiling Launcher"}' > "$errors"
+	exit 1
+fi
+cp "$file" "$output"
+`
+
+	path := filepath.Join(dir, "pakkero")
+
+	err := ioutil.WriteFile(path, []byte(script), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// a fat output has a single audit record, and the error of the member that failed
+func TestPackFatAudit(t *testing.T) {
+	dir := t.TempDir()
+	payload := filepath.Join(dir, "payload")
+
+	err := ioutil.WriteFile(payload, []byte("#!/bin/sh\n"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := Options{InFile: payload, OutFile: filepath.Join(dir, "fat"), Arch: "amd64",
+		AuditLog: filepath.Join(dir, "audit.log"), ErrorJSON: filepath.Join(dir, "error.json")}
+
+	for count, failing := range []string{"", "arm64"} {
+		err = PackFat(context.Background(), FatConfig{
+			Executable: writeFatPakkero(t, dir, failing),
+			Targets:    []FatTarget{{Arch: "amd64", File: payload}, {Arch: "arm64", File: payload}},
+			OutFile:    opts.OutFile,
+			Options:    opts,
+		})
+		if (err != nil) != (failing != "") {
+			t.Fatalf("failing %q: %v", failing, err)
+		}
+
+		records, err := VerifyAuditLog(opts.AuditLog)
+		if err != nil || records != count+1 {
+			t.Errorf("failing %q: %d records, %v", failing, records, err)
+		}
+	}
+
+	content, err := ioutil.ReadFile(opts.ErrorJSON)
+	if err != nil || !strings.Contains(string(content), "Compiling Launcher") {
+		t.Errorf("error of the failed member: %q, %v", content, err)
+	}
+}
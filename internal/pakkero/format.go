@@ -0,0 +1,49 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Payload format library
+*/
+package pakkero
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+)
+
+// magic numbers of the Mach-O thin and universal binaries, in both byte orders
+var machoMagics = [][]byte{
+	{0xfe, 0xed, 0xfa, 0xce}, {0xce, 0xfa, 0xed, 0xfe},
+	{0xfe, 0xed, 0xfa, 0xcf}, {0xcf, 0xfa, 0xed, 0xfe},
+	{0xca, 0xfe, 0xba, 0xbe}, {0xbe, 0xba, 0xfe, 0xca},
+}
+
+/*
+CheckPayloadFormat returns an error if the payload is in a format
+the launcher cannot run: the launcher is a linux program executing
+its payload through a memfd, Mach-O binaries need a macOS one.
+*/
+func CheckPayloadFormat(infile string) error {
+	file, err := os.Open(infile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	magic := make([]byte, 4)
+
+	_, err = io.ReadFull(file, magic)
+	if err != nil {
+		// too short to be a binary, scripts are fine
+		return nil
+	}
+
+	for _, machoMagic := range machoMagics {
+		if bytes.Equal(magic, machoMagic) {
+			return errors.New("the payload is a Mach-O binary, only linux payloads " +
+				"(ELF binaries and scripts) can be packed")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Garble build library
+*/
+package pakkero
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// garble obfuscation flags, given before its build command
+var garbleOptions = []string{"-literals", "-tiny"}
+
+/*
+garbleFlags turns the go build flags in the garble ones:
+garble adds -trimpath by itself.
+*/
+func garbleFlags(buildFlags []string) []string {
+	result := append([]string{}, garbleOptions...)
+
+	for _, flag := range buildFlags {
+		if flag != "-trimpath" {
+			result = append(result, flag)
+		}
+	}
+
+	return result
+}
+
+/*
+pinGarbleGo makes garble use the go pinned with -tool, garble runs
+the go found in PATH, so the pinned one goes first.
+*/
+func pinGarbleGo() error {
+	path, pinned := ToolPaths["go"]
+	if !pinned {
+		return nil
+	}
+
+	return os.Setenv("PATH", filepath.Dir(path)+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
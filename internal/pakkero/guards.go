@@ -0,0 +1,117 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Integrity guards library
+*/
+package pakkero
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MaxGuards is the highest number of integrity guards in a launcher
+const MaxGuards = 32
+
+// strings covered by each guard
+const (
+	minGuardStrings = 2
+	maxGuardStrings = 5
+)
+
+// name of the i-th guard, renamed with the other ob names
+func guardName(i int) string {
+	return fmt.Sprintf("obGuard%d", i)
+}
+
+var checkMarkerRegex = regexp.MustCompile(`(?m)^\s*// OB_CHECK\s*$`)
+
+/*
+InjectGuards will place a call to each integrity guard right before
+a random OB_CHECK marker, at most one per marker.
+The guards are pure, they can run in any order and any number of times.
+Returns the new launcher and the guards placed, fewer than count if
+there are not enough markers.
+*/
+func InjectGuards(input string, count int) (string, int) {
+	markers := checkMarkerRegex.FindAllStringIndex(input, -1)
+	if count > len(markers) {
+		count = len(markers)
+	}
+
+	// pick the markers, then place the calls from the last one
+	// so that the indexes of the others stay valid
+	picked := []int{}
+
+	for _, i := range randomSource.Perm(len(markers))[:count] {
+		picked = append(picked, markers[i][0])
+	}
+
+	sort.Sort(sort.Reverse(sort.IntSlice(picked)))
+
+	for i, start := range picked {
+		input = input[:start] + guardName(i) + "()\n" + input[start:]
+	}
+
+	return input, count
+}
+
+/*
+GenerateGuards will create the integrity guards placed by InjectGuards:
+each one decodes a few random obfuscated strings of the launcher,
+hashes them and takes the tamper reaction if the hash is not the one of
+their values at packing time. Patching a single byteshift of a covered
+string trips every guard covering it.
+Only the strings used by the launcher are covered, never the sensitive
+ones, that must not exist as strings. Call it after ObfuscateStrings.
+*/
+func GenerateGuards(input string, count int) string {
+	candidates := []string{}
+
+	for k, w := range Secrets {
+		// used besides its own declaration
+		if !strings.Contains(w[1], "leave") && strings.Count(input, w[1]+"()") > 1 {
+			candidates = append(candidates, k)
+		}
+	}
+
+	// a stable order, so the same seed generates the same guards
+	sort.Strings(candidates)
+
+	guards := ""
+
+	for i := 0; i < count; i++ {
+		// nothing to cover, still the call must resolve
+		if len(candidates) == 0 {
+			guards += fmt.Sprintf("func %s() {}\n", guardName(i))
+			continue
+		}
+
+		size := int(Random(minGuardStrings, maxGuardStrings+1))
+		if size > len(candidates) {
+			size = len(candidates)
+		}
+
+		hash := fnv.New32a()
+		calls := []string{}
+
+		for _, j := range randomSource.Perm(len(candidates))[:size] {
+			w := Secrets[candidates[j]]
+
+			hash.Write([]byte(w[0]))
+			calls = append(calls, w[1]+"()")
+		}
+
+		// same FNV-1a of hash/fnv, without importing it
+		guards += fmt.Sprintf("func %s() {\n"+
+			"obSum := uint32(2166136261)\n"+
+			"for _, obByte := range []byte(%s) {\n"+
+			"obSum = (obSum ^ uint32(obByte)) * 16777619\n}\n"+
+			"if obSum != %d {\nobTamper(obCheckGuard)\n}\n}\n",
+			guardName(i), strings.Join(calls, " + "), hash.Sum32())
+	}
+
+	return guards
+}
@@ -0,0 +1,115 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Health check library
+*/
+package pakkero
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"os"
+	"strings"
+)
+
+const healthVarPlaceholder = `"HEALTHVAR"`
+const healthInterpreterPlaceholder = `"HEALTHINTERP"`
+
+// length of the name of the health check variable
+const healthVarLength = 16
+
+/*
+HealthChecks are the rows of the health check matrix printed by the
+launcher, by their index; the result of a row is one of HealthPassed,
+HealthFailed or HealthSkipped, for a check not in the launcher.
+*/
+var HealthChecks = []string{
+	"",
+	"dependency",
+	"hostname",
+	"registration",
+	"license",
+	"arming",
+	"interpreter",
+	"memfd",
+}
+
+// Results of a row of the health check matrix
+const (
+	HealthPassed = iota
+	HealthFailed
+	HealthSkipped
+)
+
+/*
+name of the variable running the health check of the launcher, written
+in the manifest, empty until RegisterHealthCheck.
+*/
+var healthVar string
+
+/*
+payloadInterpreter returns what the payload needs to run: the
+interpreter of its shebang, or the dynamic linker of an ELF, empty if
+it needs none.
+*/
+func payloadInterpreter(infile string) (string, error) {
+	binary, err := elf.Open(infile)
+	if err == nil {
+		defer binary.Close()
+
+		for _, prog := range binary.Progs {
+			if prog.Type != elf.PT_INTERP {
+				continue
+			}
+
+			interpreter := make([]byte, prog.Filesz)
+
+			_, err = prog.ReadAt(interpreter, 0)
+			if err != nil {
+				return "", err
+			}
+
+			return string(bytes.TrimRight(interpreter, "\x00")), nil
+		}
+
+		return "", nil
+	}
+
+	file, err := os.Open(infile)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	line, _ := bufio.NewReader(file).ReadString('\n')
+	if !strings.HasPrefix(line, "#!") {
+		return "", nil
+	}
+
+	fields := strings.Fields(line[2:])
+	if len(fields) == 0 {
+		return "", nil
+	}
+
+	return fields[0], nil
+}
+
+/*
+RegisterHealthCheck will add to the secrets the random name of the
+variable running the health check, and the interpreter of the payload
+it looks for, so that they will be embedded obfuscated in the launcher.
+The name is written in the manifest only.
+*/
+func RegisterHealthCheck(infile string) error {
+	interpreter, err := payloadInterpreter(infile)
+	if err != nil {
+		return err
+	}
+
+	healthVar = strings.ToUpper(string(garbageLetters(healthVarLength)))
+
+	Secrets[healthVarPlaceholder] = []string{healthVar, GenerateTyposquatName()}
+	Secrets[healthInterpreterPlaceholder] = []string{interpreter, GenerateTyposquatName()}
+
+	return nil
+}
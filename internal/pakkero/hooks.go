@@ -0,0 +1,188 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Hooks library
+*/
+package pakkero
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Hook stages, in the order they run
+const (
+	HookPreObfuscate = "pre-obfuscate"
+	HookPostBuild    = "post-build"
+	HookPostAssemble = "post-assemble"
+)
+
+// HookStages lists the stages accepting a hook
+var HookStages = []string{HookPreObfuscate, HookPostBuild, HookPostAssemble}
+
+/*
+Hooks are called at fixed points of the packing, with the context of
+the pack, a hook returning an error fails the pack. Unset hooks are
+skipped.
+*/
+type Hooks struct {
+	// receives the launcher source before the obfuscation,
+	// returns the source to obfuscate
+	PreObfuscate func(ctx context.Context, src string) (string, error)
+	// receives the launcher binary, built, stripped and compressed
+	PostBuild func(ctx context.Context, binPath string) error
+	// receives the complete output, before its mode is set
+	PostAssemble func(ctx context.Context, outPath string) error
+}
+
+// HookOutput is the standard output of an executable hook, see ExecHooks
+type HookOutput struct {
+	Stage   string `json:"stage"`
+	Command string `json:"command"`
+	Output  string `json:"output"`
+}
+
+// outputs of the executable hooks run so far, written in the manifest
+var hookOutputs []HookOutput
+
+/*
+ParseHooks will parse the hooks passed in the form
+stage=/path/to/executable, the executables of each
+stage are kept in order.
+*/
+func ParseHooks(input []string) (map[string][]string, error) {
+	result := map[string][]string{}
+
+	for _, item := range input {
+		pair := strings.SplitN(item, "=", 2)
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("invalid hook %q, use stage=/path/to/executable", item)
+		}
+
+		if !Contains(HookStages, pair[0]) {
+			return nil, fmt.Errorf("unknown hook stage %q, stages are: %s",
+				pair[0], strings.Join(HookStages, ", "))
+		}
+
+		stat, err := os.Stat(pair[1])
+		if err != nil || !isExecutable(stat) {
+			return nil, fmt.Errorf("hook %s: %s is not an executable file", pair[0], pair[1])
+		}
+
+		result[pair[0]] = append(result[pair[0]], pair[1])
+	}
+
+	return result, nil
+}
+
+/*
+runHook will run an executable hook on target, with the stage, a
+private temporary directory and the target in its environment as
+PAKKERO_HOOK_STAGE, PAKKERO_HOOK_DIR and PAKKERO_HOOK_TARGET.
+Its standard output is logged and kept for the manifest, it is killed
+once ctx is done.
+*/
+func runHook(ctx context.Context, stage string, executable string, target string) error {
+	// the hook does not run in the current directory
+	target, err := filepath.Abs(target)
+	if err != nil {
+		return err
+	}
+
+	dir, err := ioutil.TempDir("", "pakkero-hook-")
+	if err != nil {
+		return err
+	}
+
+	Track(dir)
+	defer removeTracked(dir)
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, executable, target)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"PAKKERO_HOOK_STAGE="+stage,
+		"PAKKERO_HOOK_DIR="+dir,
+		"PAKKERO_HOOK_TARGET="+target)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+
+	output := strings.TrimSpace(stdout.String())
+	hookOutputs = append(hookOutputs, HookOutput{Stage: stage, Command: executable, Output: output})
+
+	if output != "" {
+		Log.Infof("%s hook %s: %s", stage, filepath.Base(executable), output)
+	}
+
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			Log.Errorf("%s", message)
+		}
+
+		return fmt.Errorf("%s hook %s failed: %s", stage, executable, err)
+	}
+
+	return nil
+}
+
+/*
+ExecHooks returns the hooks running the executables of each stage,
+in order, the first one failing stops the pack. Each executable gets
+the target as its only argument:
+  - pre-obfuscate: the launcher source, to be edited in place
+  - post-build: the launcher binary
+  - post-assemble: the output
+*/
+func ExecHooks(executables map[string][]string) Hooks {
+	hooks := Hooks{}
+
+	if len(executables[HookPreObfuscate]) > 0 {
+		hooks.PreObfuscate = func(ctx context.Context, src string) (string, error) {
+			err := ioutil.WriteFile(launcherFile, []byte(src), 0644)
+			if err != nil {
+				return "", err
+			}
+
+			for _, executable := range executables[HookPreObfuscate] {
+				err = runHook(ctx, HookPreObfuscate, executable, launcherFile)
+				if err != nil {
+					return "", err
+				}
+			}
+
+			content, err := ioutil.ReadFile(launcherFile)
+
+			return string(content), err
+		}
+	}
+
+	for stage, hook := range map[string]*func(context.Context, string) error{
+		HookPostBuild:    &hooks.PostBuild,
+		HookPostAssemble: &hooks.PostAssemble,
+	} {
+		if len(executables[stage]) == 0 {
+			continue
+		}
+
+		stage := stage
+		*hook = func(ctx context.Context, target string) error {
+			for _, executable := range executables[stage] {
+				err := runHook(ctx, stage, executable, target)
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+	}
+
+	return hooks
+}
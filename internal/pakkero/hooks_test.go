@@ -0,0 +1,124 @@
+package pakkero
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeHook writes a shell script hook to dir and returns its path
+func writeHook(t *testing.T, dir string, name string, script string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+
+	err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// the hooks of a stage run in order, each with its stage, directory and target
+func TestExecHooks(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target")
+
+	err := ioutil.WriteFile(target, nil, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script := `[ "$1" = "$PAKKERO_HOOK_TARGET" ] && [ "$PWD" = "$PAKKERO_HOOK_DIR" ] &&
+	echo "$(basename "$0") $PAKKERO_HOOK_STAGE" >> "$1"`
+
+	hooks := ExecHooks(map[string][]string{HookPostBuild: {
+		writeHook(t, dir, "first", script),
+		writeHook(t, dir, "second", script),
+	}})
+
+	if hooks.PreObfuscate != nil || hooks.PostAssemble != nil {
+		t.Errorf("hooks of stages without executables: %+v", hooks)
+	}
+
+	err = hooks.PostBuild(context.Background(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(target)
+	if err != nil || string(content) != "first post-build\nsecond post-build\n" {
+		t.Errorf("target: %q, %v", content, err)
+	}
+}
+
+// a hook exiting nonzero fails its stage, the following ones do not run
+func TestExecHooksFailure(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "marker")
+
+	hooks := ExecHooks(map[string][]string{HookPostAssemble: {
+		writeHook(t, dir, "failing", "exit 3"),
+		writeHook(t, dir, "following", "touch "+marker),
+	}})
+
+	err := hooks.PostAssemble(context.Background(), filepath.Join(dir, "output"))
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("failing hook: %v", err)
+	}
+
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("a hook ran after a failing one: %v", err)
+	}
+}
+
+// a hook is killed once the context of the pack is done
+func TestExecHooksCanceled(t *testing.T) {
+	dir := t.TempDir()
+
+	hooks := ExecHooks(map[string][]string{HookPostBuild: {writeHook(t, dir, "sleeping", "exec sleep 30")}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	err := hooks.PostBuild(ctx, filepath.Join(dir, "launcher"))
+	if err == nil || time.Since(start) > 10*time.Second {
+		t.Errorf("hook not killed: %v after %s", err, time.Since(start))
+	}
+}
+
+// a pre-obfuscate hook exiting nonzero aborts the pack in its stage
+func TestPakkeroHookFailure(t *testing.T) {
+	dir := t.TempDir()
+
+	payload := filepath.Join(dir, "payload")
+
+	err := ioutil.WriteFile(payload, []byte("#!/bin/sh\n"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Pakkero(context.Background(), Options{
+		InFile:  payload,
+		OutFile: filepath.Join(dir, "out"),
+		Offset:  2900000,
+		Hooks:   ExecHooks(map[string][]string{HookPreObfuscate: {writeHook(t, dir, "failing", "exit 1")}}),
+	})
+
+	var packErr *PackError
+	if !errors.As(err, &packErr) || packErr.Stage != "Running pre-obfuscate hooks" {
+		t.Errorf("failing hook: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "out")); !os.IsNotExist(err) {
+		t.Errorf("a failed pack left its output: %v", err)
+	}
+}
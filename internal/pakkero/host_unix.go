@@ -0,0 +1,42 @@
+//go:build !windows
+// +build !windows
+
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Unix host library
+*/
+package pakkero
+
+import (
+	"os"
+	"syscall"
+)
+
+// hostTempDirEnv is the variable of the temporary directory, see os.TempDir
+const hostTempDirEnv = "TMPDIR"
+
+// hostModes is true if the files of the host have unix permission bits
+const hostModes = true
+
+// hostUmask returns the umask of the process
+func hostUmask() os.FileMode {
+	umask := syscall.Umask(0)
+	syscall.Umask(umask)
+
+	return os.FileMode(umask)
+}
+
+// isExecutable returns true if the file can be run by someone
+func isExecutable(stat os.FileInfo) bool {
+	return stat.Mode().IsRegular() && stat.Mode().Perm()&0111 != 0
+}
+
+// syncDir will flush the entries of a directory to disk
+func syncDir(dir string) error {
+	return syncPath(dir)
+}
+
+// lockFile will wait for an exclusive lock of the file, released when it is closed
+func lockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+}
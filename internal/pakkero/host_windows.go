@@ -0,0 +1,47 @@
+//go:build windows
+// +build windows
+
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Windows host library
+*/
+package pakkero
+
+import (
+	"os"
+)
+
+// hostTempDirEnv is the variable of the temporary directory, see os.TempDir
+const hostTempDirEnv = "TMP"
+
+// hostModes is true if the files of the host have unix permission bits
+const hostModes = false
+
+// hostUmask returns the umask of the process, windows has none
+func hostUmask() os.FileMode {
+	return 0
+}
+
+/*
+isExecutable returns true if the file can be run by someone: windows
+has no execute bit, it runs any regular file it knows how to.
+*/
+func isExecutable(stat os.FileInfo) bool {
+	return stat.Mode().IsRegular()
+}
+
+/*
+syncDir will flush the entries of a directory to disk: windows cannot
+sync a directory, its renames are already durable once they return.
+*/
+func syncDir(dir string) error {
+	return nil
+}
+
+/*
+lockFile does nothing: windows has no advisory lock, concurrent packs
+must not share a file locked with it.
+*/
+func lockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,50 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Host list library
+*/
+package pakkero
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const hostAllowPlaceholder = `"HOSTALLOW"`
+const hostDenyPlaceholder = `"HOSTDENY"`
+
+// hostnames are letters, digits, dots and dashes, plus the glob wildcards
+var hostPatternRegex = regexp.MustCompile(`^[a-z0-9.*?-]+$`)
+
+/*
+ParseHostPatterns will validate and lowercase the hostname glob
+patterns, where * is any sequence of characters and ? any single one.
+*/
+func ParseHostPatterns(patterns []string) ([]string, error) {
+	result := []string{}
+
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if !hostPatternRegex.MatchString(pattern) {
+			return nil, fmt.Errorf("invalid pattern %q, use hostname characters, * and ?", pattern)
+		}
+
+		result = append(result, pattern)
+	}
+
+	return Unique(result), nil
+}
+
+/*
+RegisterHostList will add the allowed and denied hostname patterns to
+the secrets, so that they will be embedded obfuscated in the launcher.
+*/
+func RegisterHostList(allow []string, deny []string) {
+	if len(allow) > 0 {
+		Secrets[hostAllowPlaceholder] = []string{strings.Join(allow, ","), GenerateTyposquatName()}
+	}
+
+	if len(deny) > 0 {
+		Secrets[hostDenyPlaceholder] = []string{strings.Join(deny, ","), GenerateTyposquatName()}
+	}
+}
@@ -0,0 +1,70 @@
+package pakkero
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseHostPatterns(t *testing.T) {
+	patterns, err := ParseHostPatterns([]string{"Build-??.CI.example", "*.lan", "build-??.ci.example"})
+	if err != nil || !reflect.DeepEqual(patterns, []string{"build-??.ci.example", "*.lan"}) {
+		t.Errorf("patterns: %v, %v", patterns, err)
+	}
+
+	for _, pattern := range []string{"host_1", "host/1", "[ab]", "host,other", ""} {
+		if _, err := ParseHostPatterns([]string{pattern}); err == nil {
+			t.Errorf("%q: expected an error", pattern)
+		}
+	}
+}
+
+/*
+obHostMatches, the glob matching of the launcher run from the template,
+matches like path.Match for the hostname characters.
+*/
+func TestLauncherHostMatches(t *testing.T) {
+	patterns := []string{"*", "?", "web-*", "*.lan", "web-??.lan", "*-*.lan", "web*01*", "**.lan", "web-01.lan",
+		"*a*b*c", "?eb-*", ""}
+	hostnames := []string{"web-01.lan", "web-1.lan", "db-01.lan", "web-01", "abc", "aXbYc", "acb", "w", ""}
+
+	checks := []string{}
+	expected := ""
+
+	for _, pattern := range patterns {
+		for _, hostname := range hostnames {
+			checks = append(checks, fmt.Sprintf("{%q, %q}", pattern, hostname))
+
+			matched, _ := path.Match(pattern, hostname)
+			expected += fmt.Sprintf("%v\n", matched)
+		}
+	}
+
+	output := runProgram(t, `package main
+
+import (
+	"fmt"
+	obStrings "strings"
+)
+
+`+templateFunction(t, "obGlobMatch")+`
+
+`+templateFunction(t, "obHostMatches")+`
+
+func main() {
+	for _, check := range [][2]string{`+strings.Join(checks, ", ")+`} {
+		fmt.Println(obHostMatches(check[1], check[0]))
+	}
+
+	// any pattern of a list
+	fmt.Println(obHostMatches("db-01.lan", "web-*,db-*"), obHostMatches("db-01.lan", "web-*,*.wan"))
+}
+`)
+
+	expected += "true false\n"
+	if output != expected {
+		t.Errorf("the launcher matches:\n%s\nexpected:\n%s", output, expected)
+	}
+}
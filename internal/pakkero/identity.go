@@ -0,0 +1,153 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Launcher identity library
+*/
+package pakkero
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// building blocks of plausible module paths and file names
+var (
+	identityHosts = []string{
+		"github.com", "gitlab.com", "bitbucket.org", "codeberg.org",
+	}
+	identityWords = []string{
+		"amber", "anchor", "atlas", "beacon", "birch", "bolt", "cedar",
+		"cinder", "cobalt", "comet", "coral", "delta", "ember", "fable",
+		"falcon", "fern", "flint", "forge", "garnet", "harbor", "hazel",
+		"indigo", "jasper", "juniper", "kestrel", "lantern", "linden",
+		"maple", "meadow", "mesa", "nimbus", "oak", "onyx", "orbit",
+		"pebble", "pine", "prism", "quartz", "raven", "ridge", "river",
+		"saffron", "sage", "slate", "sparrow", "spruce", "summit",
+		"thistle", "timber", "tundra", "vale", "willow", "zephyr",
+	}
+	identitySuffixes = []string{
+		"", "", "-cli", "-tool", "-agent", "-service", "-utils", "d",
+	}
+	identityFiles = []string{
+		"main", "app", "run", "cmd", "entry", "service", "daemon",
+		"agent", "start", "boot",
+	}
+)
+
+/*
+workspaceEnv is the environment of the go commands run for the launcher:
+it imports only the standard library, so nothing has to be downloaded,
+neither modules nor toolchains, and a build must never wait on the network.
+The workspace is a module whatever GO111MODULE pakkero runs with.
+The launcher relies on memfd and /proc, it is always a linux program.
+*/
+var workspaceEnv = map[string]string{
+	"GO111MODULE": "on",
+	"GOOS":        "linux",
+	"GOPROXY":     "off",
+	"GOTOOLCHAIN": "local",
+	"GOWORK":      "off",
+	"GOFLAGS":     "",
+}
+
+/*
+Identity is the go module and the source file name used to build
+the launcher, random for each build so the launcher does not carry
+a constant module path or file name.
+*/
+type Identity struct {
+	Module string
+	File   string
+}
+
+/*
+NewIdentity generates a random identity for the launcher,
+the same non-zero seed always generates the same identity.
+*/
+func NewIdentity(seed int64) Identity {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	random := rand.New(rand.NewSource(seed))
+
+	pick := func(list []string) string {
+		return list[random.Intn(len(list))]
+	}
+
+	user := pick(identityWords)
+	project := pick(identityWords) + pick(identitySuffixes)
+
+	return Identity{
+		Module: pick(identityHosts) + "/" + user + "/" + project,
+		File:   pick(identityFiles) + ".go",
+	}
+}
+
+/*
+CreateWorkspace creates a temporary module for the launcher with
+the identity module path, targeting the go 1.x minor version,
+it returns the workspace directory, to be removed even on error,
+and the launcher source path.
+*/
+func (identity Identity) CreateWorkspace(minor int) (string, string, error) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		return "", "", err
+	}
+
+	goMod := fmt.Sprintf("module %s\n\ngo 1.%d\n", identity.Module, minor)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte(goMod), 0644)
+
+	return dir, filepath.Join(dir, identity.File), err
+}
+
+/*
+WorkspaceEnviron returns the environment of the go commands run for the
+launcher, building it for the target architecture, to be added to the
+one of each command: the environment of pakkero is left as it is.
+*/
+func WorkspaceEnviron(arch string) []string {
+	env := []string{}
+	for name, value := range workspaceEnv {
+		env = append(env, name+"="+value)
+	}
+
+	sort.Strings(env)
+
+	return append(env, "GOARCH="+arch)
+}
+
+/*
+ExternalPackages lists the packages imported by the launcher workspace
+that are neither in the standard library nor in the launcher module,
+they would have to be downloaded, listed by go run with env.
+*/
+func (identity Identity) ExternalPackages(ctx context.Context, dir string, env []string) ([]string, error) {
+	run, err := runTool(ctx, dir, "go", []string{"list", "-e", "-deps",
+		"-f", "{{if not .Standard}}{{.ImportPath}}{{end}}", "."}, env)
+	if err == nil && run.ExitCode != 0 {
+		err = fmt.Errorf("%s: exit status %d", run, run.ExitCode)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+
+	for _, line := range strings.Split(string(run.Stdout), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && line != identity.Module {
+			result = append(result, line)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,83 @@
+package pakkero
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+/*
+The launcher builds in its workspace with no proxy and an empty module
+cache, whatever GOFLAGS and GO111MODULE pakkero gets, and needs no external package:
+one that is imported is listed.
+*/
+func TestWorkspaceOffline(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not in PATH")
+	}
+
+	t.Setenv("GOMODCACHE", t.TempDir())
+	t.Setenv("GOFLAGS", "-mod=vendor")
+	t.Setenv("GO111MODULE", "off")
+	t.Setenv("GOPROXY", "https://proxy.invalid")
+
+	launcher := obfuscatedLauncherSeed(t, Options{})
+	identity := NewIdentity(1)
+
+	dir, file, err := identity.CreateWorkspace(GoMinorVersion())
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := filepath.Glob(filepath.Join(filepath.Dir(launcher), "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, source := range sources {
+		content, err := ioutil.ReadFile(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		name := filepath.Join(dir, filepath.Base(source))
+		if source == launcher {
+			name = file
+		}
+
+		err = ioutil.WriteFile(name, content, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	env := WorkspaceEnviron(launcherAsmArch)
+
+	missing, err := identity.ExternalPackages(context.Background(), dir, env)
+	if err != nil || len(missing) > 0 {
+		t.Fatalf("external packages of the launcher: %q, %v", missing, err)
+	}
+
+	run, err := runTool(context.Background(), dir, "go",
+		[]string{"build", "-o", filepath.Join(dir, "launcher")}, append(env, "CGO_ENABLED=0"))
+	if err != nil || run.ExitCode != 0 {
+		t.Fatalf("%s: %v %s", run, err, run.Stderr)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "external.go"),
+		[]byte("package main\n\nimport _ \"example.com/missing/module\"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	missing, err = identity.ExternalPackages(context.Background(), dir, env)
+	if err != nil || !reflect.DeepEqual(missing, []string{"example.com/missing/module"}) {
+		t.Errorf("external packages: %q, %v", missing, err)
+	}
+}
@@ -0,0 +1,62 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+I/O profile library
+*/
+package pakkero
+
+import (
+	"fmt"
+	"sort"
+)
+
+const ioChunkPlaceholder = `"IOCHUNK"`
+const ioWindowPlaceholder = `"IOWINDOW"`
+
+// DefaultIOProfile reads the payload as the first launchers did, with no hint
+const DefaultIOProfile = "default"
+
+/*
+IOProfile is how the launcher reads its blobs from its own file: the
+range is advised as sequential to the kernel and read in chunks of
+Chunk bytes, prefetched Window chunks ahead, or all of it at once if 0.
+The read and decryption buffers of the big blobs are on huge pages.
+*/
+type IOProfile struct {
+	Chunk  int64
+	Window int64
+}
+
+/*
+IOProfiles are the I/O profiles by name, but the default one:
+a local disk gets the whole range prefetched at once, the kernel
+readahead merges it in big sequential reads, a network filesystem gets
+a bounded window of bigger chunks, so that the first ones are not
+queued behind the whole payload.
+*/
+var IOProfiles = map[string]IOProfile{
+	"disk":    {Chunk: 4 << 20, Window: 0},
+	"network": {Chunk: 16 << 20, Window: 4},
+}
+
+// IOProfileNames returns the names of the I/O profiles, the default one too, sorted
+func IOProfileNames() []string {
+	names := []string{DefaultIOProfile}
+	for name := range IOProfiles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+/*
+RegisterIOProfile will add the chunk size and the prefetch window of
+the I/O profile to the secrets.
+*/
+func RegisterIOProfile(name string) {
+	profile := IOProfiles[name]
+
+	Secrets[ioChunkPlaceholder] = []string{fmt.Sprintf("%d", profile.Chunk), GenerateTyposquatName()}
+	Secrets[ioWindowPlaceholder] = []string{fmt.Sprintf("%d", profile.Window), GenerateTyposquatName()}
+}
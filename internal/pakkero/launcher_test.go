@@ -0,0 +1,237 @@
+package pakkero
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+/*
+obRetry retries the transient failures only, wrapped or not, as many
+times as the pack asks, doubling its wait at each attempt.
+*/
+func TestLauncherRetry(t *testing.T) {
+	retry := strings.NewReplacer(`"EXECRETRIES"`, `"3"`, `"EXECBACKOFF"`, `"20"`).
+		Replace(templateFunction(t, "obRetry"))
+
+	output := runProgram(t, `package main
+
+import (
+	obErrors "errors"
+	"fmt"
+	"os"
+	obStrconv "strconv"
+	obSyscall "syscall"
+	obTime "time"
+)
+
+`+retry+`
+
+func main() {
+	for _, obErr := range []error{obSyscall.ETXTBSY, &os.PathError{Op: "fork/exec", Err: obSyscall.EAGAIN},
+		obSyscall.ENOENT, obErrors.New("not an errno")} {
+		obStart := obTime.Now()
+		obAttempt := 0
+
+		for obRetry(obAttempt, obErr) {
+			obAttempt++
+		}
+
+		fmt.Println(obAttempt, obTime.Since(obStart) >= 140*obTime.Millisecond)
+	}
+}
+`)
+
+	// 20+40+80 ms waited for 3 retries
+	expected := "3 true\n3 true\n0 false\n0 false\n"
+	if output != expected {
+		t.Errorf("retries and backoff: %q, expected %q", output, expected)
+	}
+}
+
+/*
+obExtractOnce hands a memfd sealed against writes, shrinking, growing
+and further seals: the child it is passed to reads the seals with
+F_GET_SEALS and fails to change it.
+*/
+func TestLauncherMemfdSeals(t *testing.T) {
+	if _, ok := launcherArchs[runtime.GOARCH]; !ok {
+		t.Skipf("the launcher has no syscall table for %s", runtime.GOARCH)
+	}
+
+	table := StripFeatures(templateFunction(t, "obSyscallTable"), archFeatures(runtime.GOARCH))
+
+	output := runProgram(t, `package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	obSyscall "syscall"
+	obUnsafe "unsafe"
+)
+
+const (
+	obCloexec      uint = 1
+	obAllowSealing uint = 2
+	obSealAll           = 0x0001 | 0x0002 | 0x0004 | 0x0008
+	obAddSeals          = 1024 + 9
+	obGetSeals          = 1024 + 10
+	obSysFCNTL          = obSyscall.SYS_FCNTL
+	obCheckSeals        = 1
+)
+
+var obSysMEMFDCreate, _ = obSyscallTable()
+
+func obTamper(obCheck int) {
+	fmt.Println("tampered", obCheck)
+	os.Exit(1)
+}
+
+`+table+`
+
+`+templateFunction(t, "obExtractOnce")+`
+
+`+templateFunction(t, "obVerifySeals")+`
+
+func main() {
+	if os.Getenv("MEMFD_CHILD") != "" {
+		// F_GET_SEALS, F_SEAL_SEAL|F_SEAL_SHRINK|F_SEAL_GROW|F_SEAL_WRITE
+		seals, _, errno := obSyscall.Syscall(obSyscall.SYS_FCNTL, 3, 1034, 0)
+		fmt.Println(seals == 15, errno == 0)
+
+		_, err := obSyscall.Write(3, []byte("tampered"))
+		fmt.Println(err == obSyscall.EPERM)
+		fmt.Println(obSyscall.Ftruncate(3, 0) == obSyscall.EPERM)
+
+		content := make([]byte, 16)
+		count, _ := obSyscall.Pread(3, content, 0)
+		fmt.Println(string(content[:count]))
+
+		return
+	}
+
+	fd, err := obExtractOnce([]byte("payload"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	obVerifySeals(fd)
+
+	cmd := exec.Command("/proc/self/exe")
+	cmd.Env = append(os.Environ(), "MEMFD_CHILD=1")
+	cmd.ExtraFiles = []*os.File{os.NewFile(fd, "memfd")}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		fmt.Println(err)
+	}
+}
+`)
+
+	expected := "true true\ntrue\ntrue\npayload\n"
+	if output != expected {
+		t.Errorf("seals seen by the child: %q, expected %q", output, expected)
+	}
+}
+
+/*
+obRunAsInit reaps the orphans of the payload, that are not left as
+zombies, forwards the termination signals to it and exits with its
+status.
+*/
+func TestLauncherRunAsInit(t *testing.T) {
+	output := runProgram(t, `package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	obOS "os"
+	obExec "os/exec"
+	obSignal "os/signal"
+	"strings"
+	obSyscall "syscall"
+	"time"
+)
+
+func obExit() {
+	fmt.Println("exit")
+	obOS.Exit(1)
+}
+
+func obStart(obNewCommand func() *obExec.Cmd) *obExec.Cmd {
+	obCommand := obNewCommand()
+	if obCommand.Start() != nil {
+		obExit()
+	}
+
+	return obCommand
+}
+
+`+StripFeatures(templateDecl(t, "obSetChildSubreaper")+"\n\n"+templateFunction(t, "obRunAsInit"), nil)+`
+
+// zombies returns the zombie children of the process
+func zombies(pid int) int {
+	count := 0
+	stats, _ := ioutil.ReadDir("/proc")
+
+	for _, stat := range stats {
+		content, _ := ioutil.ReadFile("/proc/" + stat.Name() + "/stat")
+		fields := strings.Fields(string(content[strings.LastIndex(string(content), ")")+1:]))
+
+		if len(fields) > 1 && fields[0] == "Z" && fields[1] == fmt.Sprint(pid) {
+			count++
+		}
+	}
+
+	return count
+}
+
+func main() {
+	switch obOS.Getenv("INIT_ROLE") {
+	case "launcher":
+		obRunAsInit(func() *obExec.Cmd {
+			obCommand := obExec.Command("/proc/self/exe", obOS.Args[1:]...)
+			obCommand.Env = append(obOS.Environ(), "INIT_ROLE=payload")
+
+			return obCommand
+		}, 0)
+	case "payload":
+		if len(obOS.Args) > 1 {
+			obSignals := make(chan obOS.Signal, 1)
+			obSignal.Notify(obSignals, obSyscall.SIGTERM)
+			fmt.Println("received", <-obSignals)
+			obOS.Exit(3)
+		}
+
+		// the sleep is orphaned once sh exits, and adopted by the launcher
+		obExec.Command("sh", "-c", "sleep 0.1 &").Run()
+		time.Sleep(500 * time.Millisecond)
+		fmt.Println("zombies", zombies(obOS.Getppid()))
+		obOS.Exit(7)
+	default:
+		for _, args := range [][]string{nil, {"signal"}} {
+			obCommand := obExec.Command("/proc/self/exe", args...)
+			obCommand.Env = append(obOS.Environ(), "INIT_ROLE=launcher")
+			obCommand.Stdout = obOS.Stdout
+			obCommand.Start()
+
+			if args != nil {
+				time.Sleep(500 * time.Millisecond)
+				obCommand.Process.Signal(obSyscall.SIGTERM)
+			}
+
+			obCommand.Wait()
+			fmt.Println("exit", obCommand.ProcessState.ExitCode())
+		}
+	}
+}
+`)
+
+	expected := "zombies 0\nexit 7\nreceived terminated\nexit 3\n"
+	if output != expected {
+		t.Errorf("payload run as init: %q, expected %q", output, expected)
+	}
+}
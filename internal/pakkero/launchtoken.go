@@ -0,0 +1,35 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Launch token library
+*/
+package pakkero
+
+import (
+	"crypto/ed25519"
+)
+
+const launchTokenKeyPlaceholder = `"LAUNCHTOKENKEY"`
+
+/*
+id of the public key of the launch tokens, written in the manifest,
+empty until RegisterLaunchToken.
+*/
+var launchTokenKey string
+
+/*
+RegisterLaunchToken will read the ed25519 private key signing the launch
+tokens and add its seed to the sensitive secrets: the launcher gives
+the payload a token signed with it, the payload checks it with the
+public key, see the launchtoken package. Returns the id of the key.
+*/
+func RegisterLaunchToken(privKey string) (string, error) {
+	key, err := ReadLicensePrivateKey(privKey)
+	if err != nil {
+		return "", err
+	}
+
+	launchTokenKey = LicenseKeyID(key.Public().(ed25519.PublicKey))
+	Secrets[launchTokenKeyPlaceholder] = []string{string(key.Seed()), GenerateTyposquatName()}
+
+	return launchTokenKey, nil
+}
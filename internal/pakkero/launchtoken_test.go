@@ -0,0 +1,148 @@
+package pakkero
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/89luca89/pakkero/launchtoken"
+)
+
+func TestRegisterLaunchToken(t *testing.T) {
+	keepSecrets(t)
+
+	id := launchTokenKey
+	defer func() { launchTokenKey = id }()
+
+	dir := t.TempDir()
+	public, private := writeLicenseKeys(t, dir, "token")
+
+	keyID, err := RegisterLaunchToken(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := launchtoken.ParsePublicKey(content)
+	if err != nil || keyID != LicenseKeyID(key) || launchTokenKey != keyID {
+		t.Errorf("key id: %s %s, %v", keyID, launchTokenKey, err)
+	}
+
+	seed := Secrets[launchTokenKeyPlaceholder][0]
+	if !ed25519.NewKeyFromSeed([]byte(seed)).Public().(ed25519.PublicKey).Equal(key) {
+		t.Error("the secret is not the seed of the key")
+	}
+
+	if _, err := RegisterLaunchToken(public); err == nil {
+		t.Error("a public key signs the launch tokens")
+	}
+
+	// a launcher logging its checks does not hold the key
+	fired, err := CheckPolicy(Options{LauncherDebug: true, LaunchTokenKey: private})
+	if err == nil || len(fired) == 0 || fired[0].ID != "PK001" {
+		t.Errorf("-launcher-debug with -launch-token-key: %v, %v", fired, err)
+	}
+}
+
+// the payload started by the launcher reads a token the launchtoken package accepts
+func TestLauncherLaunchToken(t *testing.T) {
+	keepSecrets(t)
+
+	id := launchTokenKey
+	defer func() { launchTokenKey = id }()
+
+	public, private := writeLicenseKeys(t, t.TempDir(), "token")
+
+	_, err := RegisterLaunchToken(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decls := []string{}
+	for _, name := range []string{"obTokenPipe", "obWriteToken", "obTokenReader", "obSensitive", "obWipe"} {
+		decls = append(decls, templateDecl(t, name))
+	}
+
+	program := StripFeatures(`package main
+
+import (
+	obBinary "encoding/binary"
+	obEd25519 "crypto/ed25519"
+	"fmt"
+	obUtilio "io/ioutil"
+	obOS "os"
+	obExec "os/exec"
+	obStrconv "strconv"
+	obTime "time"
+)
+
+func obExit() {
+	obOS.Exit(1)
+}
+
+`+strings.Join(decls, "\n\n")+`
+
+func main() {
+	if obOS.Getenv("TOKEN_CHILD") != "" {
+		obToken, _ := obUtilio.ReadFile(obOS.Getenv("OB_LAUNCH_TOKEN"))
+		fmt.Printf("%d %x\n", obOS.Getpid(), obToken)
+
+		return
+	}
+
+	obCommand := obExec.Command("/proc/self/exe")
+	obCommand.Env = append(obOS.Environ(), "TOKEN_CHILD=1")
+	obCommand.Stdout = obOS.Stdout
+
+	// a retried start gets a new pipe
+	obTokenPipe(obCommand)
+	obCommand.ExtraFiles, obCommand.Env = nil, obCommand.Env[:len(obCommand.Env)-1]
+	obTokenPipe(obCommand)
+
+	obCommand.Start()
+	obWriteToken(obCommand.Process.Pid)
+	obCommand.Wait()
+}
+`, archFeatures(runtime.GOARCH))
+
+	program = strings.ReplaceAll(program, launchTokenKeyPlaceholder,
+		strconv.Quote(Secrets[launchTokenKeyPlaceholder][0]))
+
+	var pid int
+
+	var encoded string
+
+	output := runProgram(t, program)
+	if _, err := fmt.Sscanf(output, "%d %s", &pid, &encoded); err != nil {
+		t.Fatalf("the payload output: %q", output)
+	}
+
+	token, err := hex.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := launchtoken.ParsePublicKey(content)
+	if err == nil {
+		err = launchtoken.Check(token, key, pid, time.Now(), time.Minute)
+	}
+
+	if err != nil {
+		t.Errorf("the launch token %x: %v", token, err)
+	}
+}
@@ -0,0 +1,168 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Leak scan library
+*/
+package pakkero
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"unicode/utf16"
+)
+
+// minLeakLength is the shortest value scanned for, shorter ones are found by chance
+const minLeakLength = 6
+
+// minLeakNumber is the shortest number scanned for, shorter ones are constants of the launcher code too
+const minLeakNumber = 10
+
+// redactedMark replaces a sensitive value in the logs
+const redactedMark = "<redacted>"
+
+/*
+values that are secret by nature, by what they are: they are redacted
+from the logs and scanned for in the output, see markSensitive.
+*/
+var sensitiveValues = map[string]string{}
+
+/*
+markSensitive will add values to the sensitive ones, the ones shorter
+than minLeakLength are ignored.
+*/
+func markSensitive(what string, values ...string) {
+	for _, value := range values {
+		if len(value) >= minLeakLength {
+			sensitiveValues[value] = what
+		}
+	}
+}
+
+// redact returns the message with the sensitive values replaced by redactedMark
+func redact(message string) string {
+	for value := range sensitiveValues {
+		message = strings.ReplaceAll(message, value, redactedMark)
+	}
+
+	return message
+}
+
+// Leak is a plaintext value found in the output
+type Leak struct {
+	What     string
+	Encoding string
+	Offset   int64
+}
+
+func (l Leak) String() string {
+	return fmt.Sprintf("%s, %s at offset %d", l.What, l.Encoding, l.Offset)
+}
+
+// a needle of leakNeedles
+type leakNeedle struct {
+	what     string
+	encoding string
+	content  []byte
+}
+
+/*
+leakNeedles returns the forms of the value looked for: as it is, in
+UTF-16 both endians, and in base64 at the 3 alignments it can have in
+a longer encoded stream, where only the groups of its own bytes are
+known.
+*/
+func leakNeedles(what string, value string) []leakNeedle {
+	needles := []leakNeedle{{what, "plain", []byte(value)}}
+
+	little, big := []byte{}, []byte{}
+	for _, unit := range utf16.Encode([]rune(value)) {
+		little = append(little, byte(unit), byte(unit>>8))
+		big = append(big, byte(unit>>8), byte(unit))
+	}
+
+	needles = append(needles, leakNeedle{what, "utf-16le", little}, leakNeedle{what, "utf-16be", big})
+
+	for alignment := 0; alignment < 3; alignment++ {
+		encoded := base64.StdEncoding.EncodeToString(append(make([]byte, alignment), value...))
+
+		start, end := 0, 4*((alignment+len(value))/3)
+		if alignment > 0 {
+			start = 4
+		}
+
+		if end-start >= 2*minLeakLength {
+			needles = append(needles, leakNeedle{what, "base64", []byte(encoded[start:end])})
+		}
+	}
+
+	return needles
+}
+
+/*
+scannedValues returns the values the output is scanned for, by what
+they are: the sensitive ones, and the Secrets registered for the
+launcher, the obfuscated strings that are not a literal of the
+template itself, nor left as they are, nor a short number: positions
+and sizes like 16777216 are found by chance.
+*/
+func scannedValues() map[string]string {
+	values := map[string]string{}
+
+	for key, secret := range Secrets {
+		if len(secret[0]) < minLeakLength || strings.Contains(secret[1], "leave") ||
+			(len(secret[0]) < minLeakNumber && strings.Trim(secret[0], "0123456789") == "") ||
+			`"`+secret[0]+`"` == key || "`"+secret[0]+"`" == key || "'"+secret[0]+"'" == key {
+			continue
+		}
+
+		values[secret[0]] = "the secret " + strings.Trim(key, "\"`'")
+	}
+
+	for value, what := range sensitiveValues {
+		values[value] = what
+	}
+
+	return values
+}
+
+/*
+ScanLeaks returns where the values, by what they are, are found in the
+file, in any of their leakNeedles forms, by offset. The file is
+read once, each position is checked against the needles starting with
+its bytes only.
+*/
+func ScanLeaks(path string, values map[string]string) ([]Leak, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// the needles by their first 2 bytes
+	index := make([][]leakNeedle, 1<<16)
+
+	for value, what := range values {
+		for _, needle := range leakNeedles(what, value) {
+			key := uint16(needle.content[0])<<8 | uint16(needle.content[1])
+			index[key] = append(index[key], needle)
+		}
+	}
+
+	leaks := []Leak{}
+	found := map[string]bool{}
+
+	for i := 0; i+1 < len(content); i++ {
+		for _, needle := range index[uint16(content[i])<<8|uint16(content[i+1])] {
+			// once per value and encoding
+			if found[needle.what+needle.encoding] || !bytes.HasPrefix(content[i:], needle.content) {
+				continue
+			}
+
+			found[needle.what+needle.encoding] = true
+			leaks = append(leaks, Leak{What: needle.what, Encoding: needle.encoding, Offset: int64(i)})
+		}
+	}
+
+	return leaks, nil
+}
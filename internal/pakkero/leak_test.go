@@ -0,0 +1,200 @@
+package pakkero
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// keepSensitive restores the sensitive values once the test is done
+func keepSensitive(t *testing.T) {
+	t.Helper()
+
+	values := sensitiveValues
+	t.Cleanup(func() { sensitiveValues = values })
+
+	sensitiveValues = map[string]string{}
+}
+
+// the sensitive values registered are redacted from the logs and the error reports
+func TestRedact(t *testing.T) {
+	keepSecrets(t)
+	keepSensitive(t)
+
+	logger := Log
+	t.Cleanup(func() { Log = logger })
+
+	err := RegisterPayloadArgs([]string{"--verbose"}, []string{"--token=hunter22", "short"})
+	if err == nil {
+		err = RegisterEnv(EnvPolicy{Mode: EnvPassthrough}, []string{"API_KEY=s3cr3t-v4lue"})
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sensitiveValues) != 2 || sensitiveValues["--token=hunter22"] == "" ||
+		sensitiveValues["s3cr3t-v4lue"] == "" {
+		t.Errorf("sensitive values: %v", sensitiveValues)
+	}
+
+	output := &bytes.Buffer{}
+	Log = NewLogger(LevelInfo)
+	Log.Output = output
+	Log.Color = false
+
+	Log.Infof("running with --token=hunter22 and %s", "s3cr3t-v4lue")
+
+	report := filepath.Join(t.TempDir(), "error.json")
+	ReportError(&PackError{
+		Err:    errors.New("cannot use --token=hunter22"),
+		Output: "s3cr3t-v4lue",
+		Hint:   "remove s3cr3t-v4lue",
+	}, report)
+
+	content, err := ioutil.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, logged := range []string{output.String(), string(content)} {
+		if strings.Contains(logged, "hunter22") || strings.Contains(logged, "s3cr3t") ||
+			!strings.Contains(logged, redactedMark) {
+			t.Errorf("not redacted: %s", logged)
+		}
+	}
+
+	// the arguments that are not secret are logged as they are
+	if !strings.Contains(redact("--verbose short"), "--verbose short") {
+		t.Errorf("redacted: %s", redact("--verbose short"))
+	}
+}
+
+// a value is found once for each of its encodings, wherever it is in a longer base64 stream
+func TestScanLeaks(t *testing.T) {
+	value := "sup3r-s3cret"
+
+	little, big := []byte{}, []byte{}
+	for _, unit := range utf16.Encode([]rune(value)) {
+		little = append(little, byte(unit), byte(unit>>8))
+		big = append(big, byte(unit>>8), byte(unit))
+	}
+
+	content := [][]byte{[]byte("header "), []byte(value), []byte(" "), []byte(value), []byte(" "), little,
+		[]byte(" "), big, []byte(" "), []byte(base64.StdEncoding.EncodeToString([]byte(value)))}
+
+	scan := func(content []byte) []Leak {
+		path := filepath.Join(t.TempDir(), "output")
+
+		err := ioutil.WriteFile(path, content, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		leaks, err := ScanLeaks(path, map[string]string{value: "the secret", "not-in-there": "another secret"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, leak := range leaks {
+			if leak.What != "the secret" {
+				t.Errorf("leak of %s", leak)
+			}
+		}
+
+		return leaks
+	}
+
+	// once per encoding
+	leaks := scan(bytes.Join(content, nil))
+
+	encodings := []string{}
+	for _, leak := range leaks {
+		encodings = append(encodings, leak.Encoding)
+	}
+
+	if strings.Join(encodings, " ") != "plain utf-16le utf-16be base64" || leaks[0].Offset != int64(len("header ")) {
+		t.Errorf("leaks: %v", leaks)
+	}
+
+	for alignment := 0; alignment < 3; alignment++ {
+		encoded := base64.StdEncoding.EncodeToString(append([]byte("pad")[:alignment], value+" and more"...))
+
+		if leaks := scan([]byte(encoded)); len(leaks) != 1 || leaks[0].Encoding != "base64" {
+			t.Errorf("base64 at the alignment %d: %v", alignment, leaks)
+		}
+	}
+
+	if _, err := ScanLeaks(filepath.Join(t.TempDir(), "missing"), nil); err == nil {
+		t.Error("scanning a missing file")
+	}
+}
+
+// the secrets of the template itself, left as they are and short numbers are not scanned
+func TestScannedValues(t *testing.T) {
+	keepSecrets(t)
+	keepSensitive(t)
+
+	Secrets = map[string][]string{
+		`"OVERRIDEFILE"`: {"/etc/app.override", "obName"},
+		`"PRINTED"`:      {"PRINTED", "obName"},
+		`"LEFT"`:         {"left as it is", "leave"},
+		`"OFFSET"`:       {"16777216", "obName"},
+		`"BUILDID"`:      {"1234567890123", "obName"},
+		`"SHORT"`:        {"abc", "obName"},
+	}
+	markSensitive("a -secret-arg", "--token=hunter22")
+
+	values := scannedValues()
+	if len(values) != 3 || values["/etc/app.override"] != "the secret OVERRIDEFILE" ||
+		values["1234567890123"] != "the secret BUILDID" || values["--token=hunter22"] != "a -secret-arg" {
+		t.Errorf("scanned values: %v", values)
+	}
+}
+
+// a launcher built as a pack holds its secrets obfuscated only
+func TestLauncherLeaks(t *testing.T) {
+	keepSecrets(t)
+	keepSensitive(t)
+
+	id := overrideKey
+	defer func() { overrideKey = id }()
+
+	public, _ := writeLicenseKeys(t, t.TempDir(), "override")
+
+	_, err := RegisterOverride(public, "/etc/pakkero-leak-test.override")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	binary := buildLauncher(t, obfuscatedLauncher(t, Options{Arch: launcherAsmArch, OverridePubKey: public,
+		OverrideFile: "/etc/pakkero-leak-test.override"}))
+	path := filepath.Join(t.TempDir(), "launcher")
+
+	err = ioutil.WriteFile(path, binary, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := scannedValues()
+	if values["/etc/pakkero-leak-test.override"] == "" {
+		t.Fatalf("the override file is not scanned for: %v", values)
+	}
+
+	leaks, err := ScanLeaks(path, values)
+	if err != nil || len(leaks) > 0 {
+		t.Errorf("leaks: %v, %v", leaks, err)
+	}
+
+	// the version of the toolchain is in plaintext
+	leaks, err = ScanLeaks(path, map[string]string{runtime.Version(): "the toolchain"})
+	if err != nil || len(leaks) != 1 {
+		t.Errorf("the scan of the launcher: %v, %v", leaks, err)
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Payload libraries library
+*/
+package pakkero
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+const libsStartPlaceholder = `"LIBSSTART"`
+const libsSizePlaceholder = `"LIBSSIZE"`
+
+// libraries found on every glibc system, never worth a warning
+var commonLibraries = []string{
+	"libc.so.6", "libm.so.6", "libpthread.so.0", "libdl.so.2", "librt.so.1",
+	"libresolv.so.2", "libutil.so.1", "libgcc_s.so.1", "libstdc++.so.6",
+}
+
+// prefixes of the dynamic loaders and of the musl libc
+var commonLibraryPrefixes = []string{"ld-linux", "ld-musl-", "libc.musl-"}
+
+// PayloadLibrary is a library the payload needs, see NeededLibraries
+type PayloadLibrary struct {
+	Name    string
+	Common  bool
+	Bundled bool
+}
+
+// commonLibrary returns true if the library is on every system
+func commonLibrary(name string) bool {
+	for _, prefix := range commonLibraryPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+
+	return Contains(commonLibraries, name)
+}
+
+/*
+NeededLibraries returns the libraries in the DT_NEEDED entries of the
+payload, the bundled ones are those with a file of the same name in
+bundle. Scripts and static binaries need none.
+*/
+func NeededLibraries(infile string, bundle []string) []PayloadLibrary {
+	result := []PayloadLibrary{}
+
+	payload, err := elf.Open(infile)
+	if err != nil {
+		return result
+	}
+	defer payload.Close()
+
+	needed, err := payload.ImportedLibraries()
+	if err != nil {
+		return result
+	}
+
+	bundled := []string{}
+	for _, lib := range bundle {
+		bundled = append(bundled, filepath.Base(lib))
+	}
+
+	for _, name := range needed {
+		result = append(result, PayloadLibrary{
+			Name:    name,
+			Common:  commonLibrary(name),
+			Bundled: Contains(bundled, name),
+		})
+	}
+
+	return result
+}
+
+// archiveBundledLibs returns the archive of the libraries, see RegisterBundledLibs
+func archiveBundledLibs(libs []string) ([]byte, error) {
+	var archive bytes.Buffer
+
+	names := []string{}
+
+	for _, lib := range libs {
+		name := filepath.Base(lib)
+		if Contains(names, name) {
+			return nil, fmt.Errorf("library %s is bundled twice", name)
+		}
+
+		names = append(names, name)
+
+		content, err := ioutil.ReadFile(lib)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading library: %s", err)
+		}
+
+		size := make([]byte, 8)
+		binary.BigEndian.PutUint64(size, uint64(len(content)))
+
+		archive.WriteString(name)
+		archive.WriteByte(0)
+		archive.Write(size)
+		archive.Write(content)
+	}
+
+	// same encoding and compression of the payload
+	return CompressContent([]byte(base64.StdEncoding.EncodeToString(archive.Bytes()))), nil
+}
+
+/*
+RegisterBundledLibs will archive, compress and register the position of
+the bundled libraries in the launcher: they are placed right before
+end, inside the pre-payload garbage. Each library is archived as:
+
+	name \0 | size, 8 bytes big endian | content
+
+Returns the compressed archive and its starting position.
+*/
+func RegisterBundledLibs(libs []string, end int64) ([]byte, int64, error) {
+	plaintext, err := archiveBundledLibs(libs)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	size := int64(len(plaintext) + gcmOverhead)
+
+	start := end - size
+	if start <= 0 {
+		return nil, 0, fmt.Errorf("bundled libraries are bigger than the offset: libraries=%d, space=%d", size, end)
+	}
+
+	Secrets[libsStartPlaceholder] = []string{fmt.Sprintf("%d", start),
+		GenerateTyposquatName()}
+	Secrets[libsSizePlaceholder] = []string{fmt.Sprintf("%d", size),
+		GenerateTyposquatName()}
+
+	return plaintext, start, nil
+}
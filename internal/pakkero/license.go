@@ -0,0 +1,247 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+License library
+*/
+package pakkero
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+const licensePubKeyPlaceholder = `"LICENSEPUBKEY"`
+const licenseFilePlaceholder = `"LICENSEFILE"`
+
+// LicenseMagic starts every license file
+const LicenseMagic = "PKL1"
+
+// longest machine id, its length is a single byte
+const maxMachineID = 255
+
+// MaxLicenseKeys is the highest number of public keys a launcher accepts licenses of
+const MaxLicenseKeys = 8
+
+// the public keys given at packing time, by their LicenseKeyID
+var licenseKeys []string
+
+/*
+License holds the claims of a license file, laid out as:
+
+	"PKL1" | expiry, unix time, 8 bytes big endian, 0 never expires |
+	machine id length, 1 byte | machine id, empty on any machine |
+	ed25519 signature of everything before, 64 bytes
+
+The machine id is the content of /etc/machine-id, without the newline.
+*/
+type License struct {
+	Expires   time.Time
+	MachineID string
+}
+
+// claims returns the signed part of the license
+func (l License) claims() []byte {
+	var claims bytes.Buffer
+
+	expiry := make([]byte, 8)
+	if !l.Expires.IsZero() {
+		binary.BigEndian.PutUint64(expiry, uint64(l.Expires.Unix()))
+	}
+
+	claims.WriteString(LicenseMagic)
+	claims.Write(expiry)
+	claims.WriteByte(byte(len(l.MachineID)))
+	claims.WriteString(l.MachineID)
+
+	return claims.Bytes()
+}
+
+// readPEM returns the DER content of the first block of a PEM file
+func readPEM(path string, blockType string) ([]byte, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("%s: not a PEM %s", path, blockType)
+	}
+
+	return block.Bytes, nil
+}
+
+/*
+ReadLicensePublicKey will read an ed25519 public key in the PEM
+PKIX format, as written by openssl pkey -pubout.
+*/
+func ReadLicensePublicKey(path string) (ed25519.PublicKey, error) {
+	der, err := readPEM(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an ed25519 public key", path)
+	}
+
+	return public, nil
+}
+
+/*
+ReadLicensePrivateKey will read an ed25519 private key in the PEM
+PKCS8 format, as written by openssl genpkey -algorithm ed25519.
+*/
+func ReadLicensePrivateKey(path string) (ed25519.PrivateKey, error) {
+	der, err := readPEM(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an ed25519 private key", path)
+	}
+
+	return private, nil
+}
+
+// IssueLicense returns the license file of the claims, signed with key
+func IssueLicense(key ed25519.PrivateKey, license License) ([]byte, error) {
+	if len(license.MachineID) > maxMachineID {
+		return nil, fmt.Errorf("machine id longer than %d bytes", maxMachineID)
+	}
+
+	claims := license.claims()
+
+	return append(claims, ed25519.Sign(key, claims)...), nil
+}
+
+/*
+LicenseKeyID returns the id of a public key: the start of its sha256,
+in hex, enough to tell the keys of a rotation apart.
+*/
+func LicenseKeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+
+	return hex.EncodeToString(sum[:8])
+}
+
+/*
+VerifyLicense will check the signature and the claims of a license file
+at the given time, on the machine with the given id. The signature can
+be of any of the keys, tried in order: the index of the one that made
+it is returned, -1 if none did.
+This is the same logic compiled in the launcher.
+*/
+func VerifyLicense(content []byte, keys []ed25519.PublicKey, now time.Time, machineID string) (int, error) {
+	header := len(LicenseMagic) + 8 + 1
+	if len(content) < header+ed25519.SignatureSize ||
+		string(content[:len(LicenseMagic)]) != LicenseMagic {
+		return -1, errors.New("not a license file")
+	}
+
+	size := header + int(content[header-1])
+	if len(content) != size+ed25519.SignatureSize {
+		return -1, errors.New("not a license file")
+	}
+
+	slot := -1
+
+	for i, key := range keys {
+		if ed25519.Verify(key, content[:size], content[size:]) {
+			slot = i
+
+			break
+		}
+	}
+
+	if slot < 0 {
+		return -1, errors.New("invalid license signature")
+	}
+
+	expiry := int64(binary.BigEndian.Uint64(content[len(LicenseMagic):]))
+	if expiry != 0 && now.Unix() >= expiry {
+		return slot, errors.New("the license is expired")
+	}
+
+	if bound := string(content[header:size]); bound != "" && bound != machineID {
+		return slot, errors.New("the license is bound to another machine")
+	}
+
+	return slot, nil
+}
+
+// ReadMachineID returns the machine id a license can be bound to
+func ReadMachineID() (string, error) {
+	content, err := ioutil.ReadFile("/etc/machine-id")
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(content)), nil
+}
+
+/*
+RegisterLicense will read the public keys and add them to the secrets,
+one after the other, with the path of the license file on the target,
+so that they will be embedded obfuscated in the launcher: a license
+signed by any of them is valid, so that the keys can be rotated.
+Returns the LicenseKeyID of each key, written in the manifest.
+*/
+func RegisterLicense(pubKeys []string, licenseFile string) ([]string, error) {
+	if len(pubKeys) > MaxLicenseKeys {
+		return nil, fmt.Errorf("at most %d license public keys", MaxLicenseKeys)
+	}
+
+	if !IsTargetAbs(licenseFile) {
+		return nil, errors.New("the license file needs an absolute path")
+	}
+
+	keys := ""
+	ids := []string{}
+	files := map[string]string{}
+
+	for _, pubKey := range pubKeys {
+		key, err := ReadLicensePublicKey(pubKey)
+		if err != nil {
+			return nil, err
+		}
+
+		id := LicenseKeyID(key)
+		if previous, ok := files[id]; ok {
+			return nil, fmt.Errorf("%s and %s are the same license public key", previous, pubKey)
+		}
+
+		files[id] = pubKey
+		keys += string(key)
+		ids = append(ids, id)
+	}
+
+	Secrets[licensePubKeyPlaceholder] = []string{keys, GenerateTyposquatName()}
+	Secrets[licenseFilePlaceholder] = []string{licenseFile, GenerateTyposquatName()}
+
+	licenseKeys = ids
+
+	return ids, nil
+}
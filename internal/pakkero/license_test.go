@@ -0,0 +1,322 @@
+package pakkero
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeLicenseKeys writes the PEM files of a new key pair, as openssl does
+func writeLicenseKeys(t *testing.T, dir string, name string) (string, string) {
+	t.Helper()
+
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicFile := filepath.Join(dir, name+".pub")
+	privateFile := filepath.Join(dir, name+".key")
+
+	err = ioutil.WriteFile(publicFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0644)
+	if err == nil {
+		err = ioutil.WriteFile(privateFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return publicFile, privateFile
+}
+
+func TestVerifyLicense(t *testing.T) {
+	dir := t.TempDir()
+	keys := []ed25519.PublicKey{}
+	privates := []ed25519.PrivateKey{}
+
+	for _, name := range []string{"old", "new", "other"} {
+		publicFile, privateFile := writeLicenseKeys(t, dir, name)
+
+		public, err := ReadLicensePublicKey(publicFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		private, err := ReadLicensePrivateKey(privateFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys = append(keys, public)
+		privates = append(privates, private)
+	}
+
+	now := time.Unix(1700000000, 0)
+
+	issue := func(key ed25519.PrivateKey, license License) []byte {
+		content, err := IssueLicense(key, license)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return content
+	}
+
+	valid := issue(privates[1], License{Expires: now.Add(time.Hour), MachineID: "machine"})
+
+	tests := []struct {
+		name    string
+		content []byte
+		slot    int
+		message string
+	}{
+		{"valid", valid, 1, ""},
+		{"never expires on any machine", issue(privates[0], License{}), 0, ""},
+		{"unknown key", issue(privates[2], License{}), -1, "invalid license signature"},
+		{"expired", issue(privates[0], License{Expires: now}), 0, "expired"},
+		{"another machine", issue(privates[0], License{MachineID: "another"}), 0, "another machine"},
+		{"truncated", valid[:len(valid)-1], -1, "not a license file"},
+		{"extended", append(append([]byte{}, valid...), 0), -1, "not a license file"},
+		{"bad magic", append([]byte("PKL2"), valid[4:]...), -1, "not a license file"},
+		{"modified claims", append(append([]byte{}, valid[:12]...), valid[13:]...), -1, "not a license file"},
+	}
+
+	for _, test := range tests {
+		slot, err := VerifyLicense(test.content, keys[:2], now, "machine")
+		if slot != test.slot || (err == nil) != (test.message == "") ||
+			(err != nil && !strings.Contains(err.Error(), test.message)) {
+			t.Errorf("%s: slot %d, error %v, expected %d %q", test.name, slot, err, test.slot, test.message)
+		}
+	}
+
+	_, err := IssueLicense(privates[0], License{MachineID: strings.Repeat("m", maxMachineID+1)})
+	if err == nil {
+		t.Error("a machine id longer than its length byte is issued")
+	}
+
+	_, err = ReadLicensePublicKey(filepath.Join(dir, "old.key"))
+	if err == nil || !strings.Contains(err.Error(), "not a PEM PUBLIC KEY") {
+		t.Errorf("a private key read as public: %v", err)
+	}
+}
+
+func TestRegisterLicense(t *testing.T) {
+	keepSecrets(t)
+
+	dir := t.TempDir()
+	first, _ := writeLicenseKeys(t, dir, "first")
+	second, _ := writeLicenseKeys(t, dir, "second")
+
+	ids, err := RegisterLicense([]string{first, second}, "/etc/app.license")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ReadLicensePublicKey(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 2 || ids[1] != LicenseKeyID(key) || len(ids[1]) != 16 {
+		t.Errorf("key ids %q", ids)
+	}
+
+	if len(Secrets[licensePubKeyPlaceholder][0]) != 2*ed25519.PublicKeySize ||
+		Secrets[licenseFilePlaceholder][0] != "/etc/app.license" {
+		t.Errorf("secrets %q", Secrets)
+	}
+
+	for message, keys := range map[string][]string{
+		"same license public key": {first, second, first},
+		"at most":                 strings.Split(strings.Repeat(first+" ", MaxLicenseKeys+1), " ")[:MaxLicenseKeys+1],
+	} {
+		_, err = RegisterLicense(keys, "/etc/app.license")
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("error %v, expected %q", err, message)
+		}
+	}
+
+	_, err = RegisterLicense([]string{first}, "app.license")
+	if err == nil || !strings.Contains(err.Error(), "absolute path") {
+		t.Errorf("a relative license file: %v", err)
+	}
+}
+
+/*
+The launcher accepts the licenses VerifyLicense accepts, signed by any
+of the keys registered, and reacts to the others.
+*/
+func TestLauncherLicenseCheck(t *testing.T) {
+	if _, ok := launcherArchs[runtime.GOARCH]; !ok {
+		t.Skipf("the launcher has no syscall table for %s", runtime.GOARCH)
+	}
+
+	keepSecrets(t)
+
+	dir := t.TempDir()
+	licenseFile := filepath.Join(dir, "license")
+	first, _ := writeLicenseKeys(t, dir, "first")
+	second, secondKey := writeLicenseKeys(t, dir, "second")
+	_, otherKey := writeLicenseKeys(t, dir, "other")
+
+	_, err := RegisterLicense([]string{first, second}, licenseFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	machineID, err := ReadMachineID()
+	if err != nil {
+		machineID = ""
+	}
+
+	cases := []struct {
+		key      string
+		license  License
+		expected string
+	}{
+		{secondKey, License{Expires: time.Now().Add(time.Hour), MachineID: machineID}, "valid"},
+		{otherKey, License{}, "tampered"},
+		{secondKey, License{Expires: time.Now().Add(-time.Hour)}, "tampered"},
+		{secondKey, License{MachineID: "another" + machineID}, "tampered"},
+	}
+
+	licenses := []string{}
+	expected := ""
+
+	for _, test := range cases {
+		key, err := ReadLicensePrivateKey(test.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		content, err := IssueLicense(key, test.license)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		licenses = append(licenses, strconv.Quote(string(content)))
+		expected += test.expected + "\n"
+	}
+
+	// a missing license file
+	licenses = append(licenses, strconv.Quote(""))
+	expected += "tampered\n"
+
+	decls := []string{}
+	for _, name := range []string{"obLicenseCheck", "obCheckLicense", "obSensitive", "obSensitiveOpen",
+		"obWithPath", "obWipe", "obReadAll", "obReadFile"} {
+		decls = append(decls, templateDecl(t, name))
+	}
+
+	program := StripFeatures(`package main
+
+import (
+	obBinary "encoding/binary"
+	obEd25519 "crypto/ed25519"
+	"fmt"
+	obIO "io"
+	obUtilio "io/ioutil"
+	obOS "os"
+	obExec "os/exec"
+	obStrings "strings"
+	obSyscall "syscall"
+	obTime "time"
+	obUnsafe "unsafe"
+)
+
+func obTamper(obCheck int) {
+	fmt.Println("tampered")
+	obOS.Exit(0)
+}
+
+`+strings.Join(decls, "\n\n")+`
+
+func main() {
+	if obOS.Getenv("LICENSE_CHILD") != "" {
+		obLicenseCheck()
+		fmt.Println("valid")
+
+		return
+	}
+
+	for _, obLicense := range []string{`+strings.Join(licenses, ", ")+`} {
+		obOS.Remove(`+strconv.Quote(licenseFile)+`)
+
+		if obLicense != "" {
+			obUtilio.WriteFile(`+strconv.Quote(licenseFile)+`, []byte(obLicense), 0600)
+		}
+
+		obCommand := obExec.Command("/proc/self/exe")
+		obCommand.Env = append(obOS.Environ(), "LICENSE_CHILD=1")
+		obCommand.Stdout = obOS.Stdout
+		obCommand.Run()
+	}
+}
+`, archFeatures(runtime.GOARCH))
+
+	// the keys and the path are resolved by the string obfuscation in a pack
+	for _, placeholder := range []string{licensePubKeyPlaceholder, licenseFilePlaceholder} {
+		program = strings.ReplaceAll(program, placeholder, strconv.Quote(Secrets[placeholder][0]))
+	}
+
+	output := runProgram(t, program)
+	if output != expected {
+		t.Errorf("license checks:\n%s\nexpected:\n%s", output, expected)
+	}
+}
+
+// the manifest names the keys of the rotation by their LicenseKeyID, in order
+func TestManifestLicenseKeys(t *testing.T) {
+	keepSecrets(t)
+
+	keys := licenseKeys
+	defer func() { licenseKeys = keys }()
+
+	dir := t.TempDir()
+	first, _ := writeLicenseKeys(t, dir, "first")
+	second, _ := writeLicenseKeys(t, dir, "second")
+	payload := filepath.Join(dir, "payload")
+	manifest := filepath.Join(dir, "manifest.json")
+
+	ids, err := RegisterLicense([]string{first, second}, "/etc/app.license")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(payload, []byte("payload"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = WriteManifest(manifest, Options{InFile: payload, LicensePubKeys: []string{first, second}}, nil, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := ReadManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(result.LicenseKeys, " ") != strings.Join(ids, " ") {
+		t.Errorf("manifest license keys %q, expected %q", result.LicenseKeys, ids)
+	}
+}
@@ -0,0 +1,218 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Lifecycle library
+*/
+package pakkero
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+/*
+the temporary files and directories of the pack, removed by Cleanup
+on any exit path that did not rename or remove them already.
+*/
+var lifecycle struct {
+	mutex sync.Mutex
+	paths []string
+	once  sync.Once
+}
+
+/*
+startLifecycle starts the cleanup of a new pack: the paths of a previous
+one are left to it, Cleanup runs once again, for the paths of this one.
+*/
+func startLifecycle() {
+	lifecycle.mutex.Lock()
+	defer lifecycle.mutex.Unlock()
+
+	lifecycle.paths = nil
+	lifecycle.once = sync.Once{}
+}
+
+// Track registers a temporary file or directory for the cleanup
+func Track(path string) {
+	lifecycle.mutex.Lock()
+	defer lifecycle.mutex.Unlock()
+
+	lifecycle.paths = append(lifecycle.paths, path)
+}
+
+// untrack removes a path from the cleanup, once it is gone or in place
+func untrack(path string) {
+	lifecycle.mutex.Lock()
+	defer lifecycle.mutex.Unlock()
+
+	for index, tracked := range lifecycle.paths {
+		if tracked == path {
+			lifecycle.paths = append(lifecycle.paths[:index], lifecycle.paths[index+1:]...)
+
+			return
+		}
+	}
+}
+
+// removeTracked removes a tracked path now, untracking it
+func removeTracked(path string) error {
+	err := os.RemoveAll(path)
+	if err == nil {
+		untrack(path)
+	}
+
+	return err
+}
+
+/*
+Cleanup removes every tracked path, the last tracked first. It runs
+once per pack whatever calls it, an error, a signal or a panic: a
+concurrent call waits for the first one to finish.
+Only tracked paths are removed, files of the user never are.
+*/
+func Cleanup() {
+	lifecycle.once.Do(func() {
+		lifecycle.mutex.Lock()
+		defer lifecycle.mutex.Unlock()
+
+		if len(lifecycle.paths) == 0 {
+			return
+		}
+
+		Log.Start("Cleaning up")
+
+		status := StatusOK
+
+		for index := len(lifecycle.paths) - 1; index >= 0; index-- {
+			err := os.RemoveAll(lifecycle.paths[index])
+			if err != nil {
+				status = StatusErr
+
+				Log.Debugf("cannot remove %s: %s", lifecycle.paths[index], err)
+			}
+		}
+
+		lifecycle.paths = nil
+
+		Log.Done(status)
+	})
+}
+
+/*
+createWorkFile creates an empty tracked file next to path, where to
+build it, with mode minus the umask: renamed by commitWorkFile on
+success, so that an existing file at path is replaced only by a
+complete one.
+*/
+func createWorkFile(path string, mode os.FileMode) (string, error) {
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".pakkero-*")
+	if err != nil {
+		return "", err
+	}
+
+	Track(file.Name())
+
+	err = file.Chmod(mode &^ hostUmask())
+	if err != nil {
+		file.Close()
+
+		return "", err
+	}
+
+	return file.Name(), file.Close()
+}
+
+/*
+commitWorkFile renames a work file into place, once it is on disk: the
+file is synced before the rename, and its directory after, so that a
+crash leaves at path either the old file or the complete new one.
+*/
+func commitWorkFile(work string, path string) error {
+	err := syncPath(work)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(work, path)
+	if err != nil {
+		return err
+	}
+
+	untrack(work)
+
+	return syncDir(filepath.Dir(path))
+}
+
+/*
+SameFile returns true if the paths are the same file once the symlinks
+are resolved, compared by device and inode, not by name: a hardlink or
+a link to it is the file too. A path that does not exist is no file.
+*/
+func SameFile(path string, other string) (bool, error) {
+	stat, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	otherStat, err := os.Stat(other)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return os.SameFile(stat, otherStat), nil
+}
+
+/*
+snapshotFile copies a file to a tracked temporary one with its mode,
+and returns its path: what reads the copy is not affected by anything
+written to the file.
+*/
+func snapshotFile(path string) (string, error) {
+	input, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer input.Close()
+
+	stat, err := input.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	file, err := ioutil.TempFile("", "pakkero-payload-")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	Track(file.Name())
+
+	_, err = io.Copy(file, input)
+	if err == nil {
+		err = file.Chmod(stat.Mode().Perm())
+	}
+
+	return file.Name(), err
+}
+
+// syncPath flushes a file or a directory to disk
+func syncPath(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return file.Sync()
+}
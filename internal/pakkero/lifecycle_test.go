@@ -0,0 +1,159 @@
+package pakkero
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSameFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	other := filepath.Join(dir, "other")
+
+	for _, path := range []string{file, other} {
+		err := ioutil.WriteFile(path, []byte("content"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hardlink := filepath.Join(dir, "hardlink")
+	symlink := filepath.Join(dir, "symlink")
+
+	if err := os.Link(file, hardlink); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink("file", symlink); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path     string
+		other    string
+		expected bool
+	}{
+		{file, file, true},
+		{file, filepath.Join(dir, ".", "file"), true},
+		{file, hardlink, true},
+		{symlink, file, true},
+		{file, other, false},
+		{file, filepath.Join(dir, "missing"), false},
+		{filepath.Join(dir, "missing"), filepath.Join(dir, "missing"), false},
+	}
+
+	for _, test := range tests {
+		same, err := SameFile(test.path, test.other)
+		if err != nil || same != test.expected {
+			t.Errorf("%s and %s: %v, %v", test.path, test.other, same, err)
+		}
+	}
+}
+
+// the snapshot keeps the content and the mode, what is written to the file after does not reach it
+func TestSnapshotFile(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	path := filepath.Join(t.TempDir(), "payload")
+
+	err := ioutil.WriteFile(path, []byte("payload"), 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := snapshotFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { removeTracked(snapshot) })
+
+	err = ioutil.WriteFile(path, []byte("packed output"), 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(snapshot)
+	if err != nil || string(content) != "payload" {
+		t.Errorf("snapshot: %q, %v", content, err)
+	}
+
+	if stat, err := os.Stat(snapshot); err != nil || stat.Mode().Perm() != 0750 {
+		t.Errorf("snapshot mode: %v, %v", stat.Mode(), err)
+	}
+}
+
+func TestCleanupPerPack(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{"first", "second"} {
+		startLifecycle()
+
+		path := filepath.Join(dir, name)
+
+		err := ioutil.WriteFile(path, []byte(name), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		Track(path)
+		Cleanup()
+		Cleanup()
+
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s pack: the tracked file is left, %v", name, err)
+		}
+	}
+}
+
+// the work file replaces the path only once committed, and is then no more cleaned up
+func TestCommitWorkFile(t *testing.T) {
+	startLifecycle()
+
+	path := filepath.Join(t.TempDir(), "output")
+
+	err := ioutil.WriteFile(path, []byte("old"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	work, err := createWorkFile(path, 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(work, []byte("new"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if content, _ := ioutil.ReadFile(path); string(content) != "old" {
+		t.Errorf("the path is %q before the commit", content)
+	}
+
+	err = commitWorkFile(work, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Cleanup()
+
+	if content, err := ioutil.ReadFile(path); err != nil || string(content) != "new" {
+		t.Errorf("committed: %q, %v", content, err)
+	}
+
+	if _, err := os.Stat(work); !os.IsNotExist(err) {
+		t.Errorf("the work file is left: %v", err)
+	}
+
+	if stat, err := os.Stat(path); err != nil || stat.Mode().Perm() != 0750&^hostUmask() {
+		t.Errorf("committed mode: %v, %v", stat.Mode(), err)
+	}
+
+	err = commitWorkFile(work, path)
+	if err == nil {
+		t.Error("a missing work file is committed")
+	}
+}
@@ -0,0 +1,233 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Logging library
+*/
+package pakkero
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel is the verbosity of the logger
+type LogLevel int
+
+// Log levels, from the less to the most verbose
+const (
+	LevelError LogLevel = iota
+	LevelWarn
+	LevelInfo
+	LevelDebug
+)
+
+// Status of a pipeline step
+const (
+	StatusOK   = "OK"
+	StatusErr  = "ERR"
+	StatusSkip = "SKIPPING"
+)
+
+var levelNames = map[LogLevel]string{
+	LevelError: "error",
+	LevelWarn:  "warning",
+	LevelInfo:  "info",
+	LevelDebug: "debug",
+}
+
+var levelColors = map[LogLevel]string{
+	LevelError: ErrorColor,
+	LevelWarn:  WarningColor,
+	LevelInfo:  "%s",
+	LevelDebug: "%s",
+}
+
+var statusColors = map[string]string{
+	StatusOK:   SuccessColor,
+	StatusErr:  ErrorColor,
+	StatusSkip: WarningColor,
+}
+
+/*
+Logger is a leveled logger, messages are written to Output
+and duplicated, uncolored and timestamped, to File if set.
+*/
+type Logger struct {
+	Level  LogLevel
+	Color  bool
+	Output io.Writer
+	File   io.Writer
+	// name and start of the pipeline step in progress
+	step      string
+	stepStart time.Time
+	// the finished steps, in order
+	steps []StepTiming
+	// the warnings logged, redacted, for the audit log
+	warnings []string
+	mutex    sync.Mutex
+}
+
+// StepTiming is the status and the duration of a finished pipeline step
+type StepTiming struct {
+	Name     string        `json:"name"`
+	Status   string        `json:"status"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// Log is the logger used by the whole pipeline
+var Log = NewLogger(LevelWarn)
+
+/*
+NewLogger returns a logger writing on stderr, colors are
+disabled if NO_COLOR is set.
+*/
+func NewLogger(level LogLevel) *Logger {
+	_, noColor := os.LookupEnv("NO_COLOR")
+
+	return &Logger{
+		Level:  level,
+		Color:  !noColor,
+		Output: os.Stderr,
+	}
+}
+
+/*
+SetLogFile will duplicate every message to the given file,
+the file is appended if already present.
+*/
+func (l *Logger) SetLogFile(path string) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	l.mutex.Lock()
+	l.File = file
+	l.mutex.Unlock()
+
+	return nil
+}
+
+// Enabled returns true if messages of the level will be written
+func (l *Logger) Enabled(level LogLevel) bool {
+	return level <= l.Level
+}
+
+func (l *Logger) write(level LogLevel, colored string, plain string) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.Color {
+		fmt.Fprintln(l.Output, colored)
+	} else {
+		fmt.Fprintln(l.Output, plain)
+	}
+
+	if l.File != nil {
+		for _, line := range strings.Split(plain, "\n") {
+			fmt.Fprintf(l.File, "%s %-7s %s\n",
+				time.Now().UTC().Format(time.RFC3339), levelNames[level], line)
+		}
+	}
+}
+
+// logf logs the message with the sensitive values redacted, see markSensitive
+func (l *Logger) logf(level LogLevel, format string, args ...interface{}) {
+	message := redact(fmt.Sprintf(format, args...))
+	prefix := ""
+
+	if level != LevelInfo {
+		prefix = levelNames[level] + ": "
+	}
+
+	if level == LevelWarn {
+		l.mutex.Lock()
+		l.warnings = append(l.warnings, message)
+		l.mutex.Unlock()
+	}
+
+	l.write(level,
+		fmt.Sprintf(levelColors[level], prefix)+message,
+		prefix+message)
+}
+
+// Errorf logs a message at error level
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.logf(LevelError, format, args...)
+}
+
+// Warnf logs a message at warning level
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.logf(LevelWarn, format, args...)
+}
+
+// Infof logs a message at info level
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.logf(LevelInfo, format, args...)
+}
+
+// Debugf logs a message at debug level
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.logf(LevelDebug, format, args...)
+}
+
+/*
+Start will begin a new pipeline step, it is logged
+with its status once Done is called.
+*/
+func (l *Logger) Start(step string) {
+	l.step = step
+	l.stepStart = time.Now().UTC()
+	l.Debugf("%s...", step)
+}
+
+/*
+Done will log the status of the current step, failed steps
+are logged as errors, the other ones as info.
+*/
+func (l *Logger) Done(status string) {
+	level := LevelInfo
+	if status == StatusErr {
+		level = LevelError
+	}
+
+	l.steps = append(l.steps, StepTiming{
+		Name:     l.step,
+		Status:   status,
+		Start:    l.stepStart,
+		Duration: time.Since(l.stepStart),
+	})
+
+	line := fmt.Sprintf(" → %-32s", l.step+"...")
+	l.write(level,
+		line+fmt.Sprintf(statusColors[status], "[ "+status+" ]"),
+		line+"[ "+status+" ]")
+
+	l.step = ""
+}
+
+// Step returns the name of the pipeline step in progress, empty if none
+func (l *Logger) Step() string {
+	return l.step
+}
+
+// Steps returns the finished pipeline steps, in order
+func (l *Logger) Steps() []StepTiming {
+	return append([]StepTiming{}, l.steps...)
+}
+
+// Warnings returns the warnings logged, whatever the level, in order
+func (l *Logger) Warnings() []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return append([]string{}, l.warnings...)
+}
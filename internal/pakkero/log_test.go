@@ -0,0 +1,67 @@
+package pakkero
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// a level logs its messages and the less verbose ones only
+func TestLoggerLevels(t *testing.T) {
+	logAll := func(logger *Logger) {
+		logger.Errorf("an %s", "error")
+		logger.Warnf("a %s", "warning")
+		logger.Infof("an %s", "info")
+		logger.Debugf("a %s", "debug")
+	}
+
+	tests := []struct {
+		level    LogLevel
+		expected string
+	}{
+		{LevelError, "error: an error\n"},
+		{LevelWarn, "error: an error\nwarning: a warning\n"},
+		{LevelInfo, "error: an error\nwarning: a warning\nan info\n"},
+		{LevelDebug, "error: an error\nwarning: a warning\nan info\ndebug: a debug\n"},
+	}
+
+	for _, test := range tests {
+		output := &bytes.Buffer{}
+		logger := NewLogger(test.level)
+		logger.Output = output
+		logger.Color = false
+
+		logAll(logger)
+
+		if output.String() != test.expected {
+			t.Errorf("level %s logged %q, expected %q", levelNames[test.level], output, test.expected)
+		}
+
+		// whatever the level, the warnings are kept for the audit log
+		if warnings := logger.Warnings(); len(warnings) != 1 || warnings[0] != "a warning" {
+			t.Errorf("level %s kept the warnings %q", levelNames[test.level], warnings)
+		}
+	}
+}
+
+// the log file has the messages of the level, uncolored and with their level
+func TestLoggerFile(t *testing.T) {
+	output := &bytes.Buffer{}
+	file := &bytes.Buffer{}
+	logger := NewLogger(LevelWarn)
+	logger.Output = output
+	logger.File = file
+	logger.Color = true
+
+	logger.Errorf("broken")
+	logger.Infof("filtered")
+
+	if !strings.Contains(output.String(), "\033[") {
+		t.Errorf("uncolored output: %q", output)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(file.String(), "\n"), "\n")
+	if len(lines) != 1 || !strings.HasSuffix(lines[0], " error   error: broken") || strings.Contains(lines[0], "\033[") {
+		t.Errorf("log file %q, expected one uncolored error", file)
+	}
+}
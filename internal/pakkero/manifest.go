@@ -0,0 +1,316 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Manifest library
+*/
+package pakkero
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// ManifestSchema is the version of the manifest format
+const ManifestSchema = 1
+
+// value of the redacted options
+const redacted = "[redacted]"
+
+// ManifestFile is the digest of a file that went into, or came out of, a pack
+type ManifestFile struct {
+	Path   string `json:"path,omitempty"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+/*
+Manifest describes a packed artifact for attestation: what went into it,
+the options, with the secrets redacted, the tools, how long each step
+took, the output of the executable hooks and the build id of the
+attestation and registration records, the variable running the
+health check of the launcher, the LicenseKeyID of the license public
+keys and of the launch token key, what is before the payload, see OffsetLayout, the syscalls
+the launcher makes, see LauncherSyscalls, and the calibration file of
+the scrubbing, see LoadCalibration.
+An estimated one describes a pack not built, see WriteEstimate: its
+output has no sha256, its startup is predicted. A fat one is the one
+of its first member, with the offset and the manifest of each member,
+see PackFat.
+Its keys are sorted, so it can be signed as it is.
+*/
+type Manifest struct {
+	Schema         int                    `json:"schema"`
+	Created        time.Time              `json:"created"`
+	Pakkero        BuildInfo              `json:"pakkero"`
+	Payload        ManifestFile           `json:"payload"`
+	Template       ManifestFile           `json:"template"`
+	Output         ManifestFile           `json:"output"`
+	Options        map[string]interface{} `json:"options"`
+	Steps          []StepTiming           `json:"steps"`
+	Hooks          []HookOutput           `json:"hooks,omitempty"`
+	AttestID       int64                  `json:"attest_id,omitempty"`
+	RegistrationID int64                  `json:"registration_id,omitempty"`
+	HealthVar      string                 `json:"health_var,omitempty"`
+	LicenseKeys    []string               `json:"license_keys,omitempty"`
+	LaunchTokenKey string                 `json:"launch_token_key,omitempty"`
+	OverrideKey    string                 `json:"override_key,omitempty"`
+	SignatureKey   string                 `json:"signature_key,omitempty"`
+	Layout         *OffsetLayout          `json:"layout,omitempty"`
+	Syscalls       []string               `json:"syscalls,omitempty"`
+	Calibration    *ManifestFile          `json:"calibration,omitempty"`
+	Estimated      bool                   `json:"estimated,omitempty"`
+	Startup        *StartupEstimate       `json:"startup,omitempty"`
+	Fat            []FatMember            `json:"fat,omitempty"`
+}
+
+// hashFile returns the size and the sha256 of a file
+func hashFile(path string) (ManifestFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+
+	return ManifestFile{Path: path, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+/*
+redactOptions returns the options as written in the manifest: the
+offset, that derives the key, the secret arguments and the values of
+the payload environment are replaced by what hide returns of them.
+*/
+func redactOptions(opts Options, hide func(string) string) (map[string]interface{}, error) {
+	content, err := json.Marshal(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]interface{}{}
+
+	// keep the numbers as they are, unlimited rlimits do not fit a float64
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+
+	err = decoder.Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+
+	result["Offset"] = hide(fmt.Sprintf("%d", opts.Offset))
+
+	secretArgs := []string{}
+	for _, arg := range opts.SecretArgs {
+		secretArgs = append(secretArgs, hide(arg))
+	}
+
+	result["SecretArgs"] = secretArgs
+
+	payloadEnv := []string{}
+	for _, variable := range opts.PayloadEnv {
+		pair := append(strings.SplitN(variable, "=", 2), "")
+		payloadEnv = append(payloadEnv, pair[0]+"="+hide(pair[1]))
+	}
+
+	result["PayloadEnv"] = payloadEnv
+
+	return result, nil
+}
+
+/*
+newManifest returns the manifest of a pack of the options, with what
+went into it only, template is the launcher template used.
+*/
+func newManifest(opts Options, template []byte) (Manifest, error) {
+	options, err := redactOptions(opts, func(string) string {
+		return redacted
+	})
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	payload, err := hashFile(opts.InFile)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	templateSum := sha256.Sum256(template)
+
+	created := opts.SourceDate
+	if created.IsZero() {
+		created = time.Now().UTC()
+	}
+
+	return Manifest{
+		Schema:  ManifestSchema,
+		Created: created,
+		Pakkero: GetBuildInfo(),
+		Payload: payload,
+		Template: ManifestFile{
+			Size:   int64(len(template)),
+			SHA256: hex.EncodeToString(templateSum[:]),
+		},
+		Options: options,
+	}, nil
+}
+
+// writeManifest will write the manifest as indented json
+func writeManifest(path string, manifest Manifest) error {
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, append(content, '\n'), 0644)
+}
+
+/*
+WriteManifest will write the manifest of the output, built in
+workfile and renamed to opts.OutFile, template is the launcher
+template used.
+*/
+func WriteManifest(path string, opts Options, template []byte, workfile string) error {
+	manifest, err := newManifest(opts, template)
+	if err != nil {
+		return err
+	}
+
+	output, err := hashFile(workfile)
+	if err != nil {
+		return err
+	}
+
+	output.Path = opts.OutFile
+
+	manifest.Output = output
+	manifest.Steps = Log.Steps()
+	manifest.Hooks = hookOutputs
+	manifest.Syscalls = launcherSyscalls
+
+	if opts.Attest.Enabled() {
+		manifest.AttestID = launcherBuildID
+	}
+
+	if opts.RegisterHost != "" {
+		manifest.RegistrationID = launcherBuildID
+	}
+
+	if opts.HealthCheck {
+		manifest.HealthVar = healthVar
+	}
+
+	if len(opts.LicensePubKeys) > 0 {
+		manifest.LicenseKeys = licenseKeys
+	}
+
+	manifest.LaunchTokenKey = launchTokenKey
+	manifest.SignatureKey = signatureKey
+	manifest.OverrideKey = overrideKey
+	manifest.Calibration = calibrationFile
+
+	if outputLayout.LauncherSize > 0 {
+		layout := outputLayout
+		manifest.Layout = &layout
+	}
+
+	return writeManifest(path, manifest)
+}
+
+/*
+WriteEstimate will write the estimated manifest of a pack of the
+options, see EstimatePack: the output has its predicted size and no
+sha256, the layout and the startup are predicted too.
+*/
+func WriteEstimate(path string, opts Options, estimate Estimate) error {
+	stub, _ := base64.StdEncoding.DecodeString(LauncherStub)
+
+	manifest, err := newManifest(opts, stub)
+	if err != nil {
+		return err
+	}
+
+	manifest.Output = ManifestFile{Path: opts.OutFile, Size: estimate.Size}
+	manifest.Layout = &estimate.Layout
+	manifest.Estimated = true
+	manifest.Startup = &estimate.Startup
+
+	return writeManifest(path, manifest)
+}
+
+// ReadManifest will read and validate a manifest
+func ReadManifest(path string) (Manifest, error) {
+	manifest := Manifest{}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return manifest, err
+	}
+
+	err = json.Unmarshal(content, &manifest)
+	if err != nil {
+		return manifest, fmt.Errorf("invalid manifest: %s", err)
+	}
+
+	if manifest.Schema != ManifestSchema {
+		return manifest, fmt.Errorf("unsupported manifest schema %d, expected %d",
+			manifest.Schema, ManifestSchema)
+	}
+
+	files := map[string]ManifestFile{
+		"payload":  manifest.Payload,
+		"template": manifest.Template,
+	}
+
+	// an estimate has no output to hash
+	if !manifest.Estimated {
+		files["output"] = manifest.Output
+	}
+
+	for name, file := range files {
+		sum, err := hex.DecodeString(file.SHA256)
+		if err != nil || len(sum) != sha256.Size {
+			return manifest, fmt.Errorf("invalid manifest: bad sha256 of the %s", name)
+		}
+	}
+
+	return manifest, nil
+}
+
+// VerifyManifest will check that an artifact is the output described by a manifest
+func VerifyManifest(path string, artifact string) error {
+	manifest, err := ReadManifest(path)
+	if err != nil {
+		return err
+	}
+
+	if manifest.Estimated {
+		return errors.New("the manifest is an estimate, no artifact matches it")
+	}
+
+	actual, err := hashFile(artifact)
+	if err != nil {
+		return err
+	}
+
+	if actual.Size != manifest.Output.Size || actual.SHA256 != manifest.Output.SHA256 {
+		return errors.New("the artifact does not match the manifest: sha256 " +
+			actual.SHA256 + ", expected " + manifest.Output.SHA256)
+	}
+
+	return nil
+}
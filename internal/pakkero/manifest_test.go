@@ -0,0 +1,172 @@
+package pakkero
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+The manifest has the digests of the payload, the template and the
+output and the steps logged, but not the offset, the secret arguments
+and the values of the payload environment.
+*/
+func TestWriteManifest(t *testing.T) {
+	dir := t.TempDir()
+	payload := filepath.Join(dir, "payload")
+	output := filepath.Join(dir, "payload.enc")
+	manifest := filepath.Join(dir, "manifest.json")
+
+	err := ioutil.WriteFile(payload, []byte("payload"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(output, []byte("launcher and payload"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := Log
+	defer func() { Log = logger }()
+
+	Log = NewLogger(LevelError)
+	Log.Output = &bytes.Buffer{}
+	Log.Start("Packing")
+	Log.Done(StatusOK)
+
+	opts := Options{
+		InFile:     payload,
+		Offset:     1234567,
+		SecretArgs: []string{"--token=s3cret"},
+		PayloadEnv: []string{"API_KEY=s3cret"},
+		Rlimits:    []Rlimit{{"stack", 3, 8 << 20, RlimitInfinity}},
+	}
+
+	err = WriteManifest(manifest, opts, []byte("template"), output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(content, []byte("s3cret")) || bytes.Contains(content, []byte("1234567")) {
+		t.Errorf("the manifest is not redacted:\n%s", content)
+	}
+
+	result, err := ReadManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	templateSum := sha256.Sum256([]byte("template"))
+	outputSum := sha256.Sum256([]byte("launcher and payload"))
+
+	if result.Template.SHA256 != hex.EncodeToString(templateSum[:]) ||
+		result.Output.SHA256 != hex.EncodeToString(outputSum[:]) ||
+		result.Output.Size != 20 || result.Payload.Size != 7 {
+		t.Errorf("wrong digests: %+v %+v %+v", result.Payload, result.Template, result.Output)
+	}
+
+	// the numbers are written as they are, an unlimited limit does not fit a float64
+	if !bytes.Contains(content, []byte(`"Hard": 18446744073709551615`)) {
+		t.Errorf("the unlimited stack is not kept:\n%s", content)
+	}
+
+	if len(result.Steps) != 1 || result.Steps[0].Name != "Packing" || result.Steps[0].Status != StatusOK {
+		t.Errorf("steps %+v, expected the packing one", result.Steps)
+	}
+
+	options, err := json.Marshal(result.Options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		`"Offset":"[redacted]"`,
+		`"SecretArgs":["[redacted]"]`,
+		`"PayloadEnv":["API_KEY=[redacted]"]`,
+	} {
+		if !strings.Contains(string(options), expected) {
+			t.Errorf("options %s, expected %s", options, expected)
+		}
+	}
+}
+
+// an artifact matches the manifest only with the same size and sha256
+func TestVerifyManifest(t *testing.T) {
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "manifest.json")
+	artifact := filepath.Join(dir, "artifact")
+
+	sum := sha256.Sum256([]byte("artifact"))
+	file := ManifestFile{Size: 8, SHA256: hex.EncodeToString(sum[:])}
+
+	writeManifest := func(manifest Manifest) {
+		content, err := json.Marshal(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = ioutil.WriteFile(filepath.Join(dir, "manifest.json"), content, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := ioutil.WriteFile(artifact, []byte("artifact"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeManifest(Manifest{Schema: ManifestSchema, Created: time.Now(),
+		Payload: file, Template: file, Output: file})
+
+	err = VerifyManifest(manifest, artifact)
+	if err != nil {
+		t.Errorf("the artifact does not match: %s", err)
+	}
+
+	err = ioutil.WriteFile(artifact, []byte("artifacT"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = VerifyManifest(manifest, artifact)
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("a modified artifact: %v", err)
+	}
+
+	for message, invalid := range map[string]Manifest{
+		"unsupported manifest schema": {Schema: ManifestSchema + 1, Payload: file, Template: file, Output: file},
+		"bad sha256 of the template":  {Schema: ManifestSchema, Payload: file, Output: file},
+		"bad sha256 of the output": {Schema: ManifestSchema, Payload: file, Template: file,
+			Output: ManifestFile{SHA256: "abcd"}},
+	} {
+		writeManifest(invalid)
+
+		_, err = ReadManifest(manifest)
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("error %v, expected %q", err, message)
+		}
+	}
+
+	err = ioutil.WriteFile(manifest, []byte("{"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ReadManifest(manifest)
+	if err == nil || !strings.Contains(err.Error(), "invalid manifest") {
+		t.Errorf("a truncated manifest: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+File mode library
+*/
+package pakkero
+
+import (
+	"os"
+)
+
+// default mode of the output, before the umask
+const outputMode = 0755
+
+/*
+CheckPayloadMode returns a warning for each attribute of the payload
+that cannot survive the extract-and-exec model of the launcher:
+setuid/setgid bits and file capabilities.
+*/
+func CheckPayloadMode(infile string) []string {
+	warnings := []string{}
+
+	stat, err := os.Stat(infile)
+	if err != nil {
+		return warnings
+	}
+
+	if stat.Mode()&(os.ModeSetuid|os.ModeSetgid) != 0 {
+		warnings = append(warnings,
+			"the payload has setuid/setgid bits, they are lost when the launcher "+
+				"executes it from memory: run the packed file as the target user "+
+				"or group instead (eg: runuser, sudo -u)")
+	}
+
+	if hasCapabilities(infile) {
+		warnings = append(warnings,
+			"the payload has file capabilities, they are lost when the launcher "+
+				"executes it from memory: grant them as ambient capabilities to "+
+				"the process running the packed file instead (eg: systemd "+
+				"AmbientCapabilities=, capsh --addamb)")
+	}
+
+	return warnings
+}
+
+/*
+SetOutputMode will make the output executable, 0755 honoring the umask,
+or with the same permission bits of the payload if preserve is set.
+*/
+func SetOutputMode(outfile string, infile string, preserve bool) error {
+	if preserve {
+		stat, err := os.Stat(infile)
+		if err != nil {
+			return err
+		}
+
+		return os.Chmod(outfile, stat.Mode().Perm())
+	}
+
+	return os.Chmod(outfile, outputMode&^hostUmask())
+}
@@ -0,0 +1,90 @@
+//go:build !windows
+// +build !windows
+
+package pakkero
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// writeModeFile writes a file and sets its mode, the umask does not apply
+func writeModeFile(t *testing.T, name string, mode os.FileMode) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+
+	err := ioutil.WriteFile(path, []byte(name), 0600)
+	if err == nil {
+		err = os.Chmod(path, mode)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// the output is 0755 less the umask, or has the bits of the payload with -preserve-mode
+func TestSetOutputMode(t *testing.T) {
+	umask := syscall.Umask(0027)
+	t.Cleanup(func() { syscall.Umask(umask) })
+
+	infile := writeModeFile(t, "payload", 0710)
+
+	tests := []struct {
+		preserve bool
+		expected os.FileMode
+	}{
+		{false, 0750},
+		{true, 0710},
+	}
+
+	for _, test := range tests {
+		outfile := writeModeFile(t, "output", 0644)
+
+		err := SetOutputMode(outfile, infile, test.preserve)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stat, err := os.Stat(outfile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if stat.Mode().Perm() != test.expected {
+			t.Errorf("preserve %t: mode %o, expected %o", test.preserve, stat.Mode().Perm(), test.expected)
+		}
+	}
+}
+
+// setuid and setgid bits are warned about, they are lost by the launcher
+func TestCheckPayloadMode(t *testing.T) {
+	tests := []struct {
+		mode     os.FileMode
+		warnings int
+	}{
+		{0755, 0},
+		{0755 | os.ModeSetuid, 1},
+		{0755 | os.ModeSetgid, 1},
+	}
+
+	for _, test := range tests {
+		warnings := CheckPayloadMode(writeModeFile(t, "payload", test.mode))
+		if len(warnings) != test.warnings {
+			t.Errorf("mode %s: warnings %q", test.mode, warnings)
+		}
+
+		for _, warning := range warnings {
+			if !strings.Contains(warning, "setuid/setgid") {
+				t.Errorf("mode %s: warning %q", test.mode, warning)
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+//go:build linux
+// +build linux
+
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Linux mount library
+*/
+package pakkero
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// unescapeMount returns a field of mountinfo with its octal escapes, like \040, decoded
+func unescapeMount(field string) string {
+	var result strings.Builder
+
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+3 < len(field) {
+			if value, err := strconv.ParseUint(field[i+1:i+4], 8, 8); err == nil {
+				result.WriteByte(byte(value))
+				i += 3
+
+				continue
+			}
+		}
+
+		result.WriteByte(field[i])
+	}
+
+	return result.String()
+}
+
+/*
+dirMount returns the mount point the directory is on, the longest one
+of /proc/self/mountinfo holding it, and the options of the mount, like
+ro or noexec, empty if it cannot tell.
+*/
+func dirMount(dir string) (string, []string) {
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		resolved = dir
+	}
+
+	resolved, _ = filepath.Abs(resolved)
+
+	content, err := ioutil.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return "", nil
+	}
+
+	mount, options := "", []string(nil)
+
+	for _, line := range strings.Split(string(content), "\n") {
+		// id parent major:minor root mount-point options ...
+		fields := strings.Fields(line)
+		if len(fields) < 6 {
+			continue
+		}
+
+		point := unescapeMount(fields[4])
+
+		// the last one mounted on the same point hides the others
+		if (resolved == point || strings.HasPrefix(resolved, strings.TrimSuffix(point, "/")+"/")) &&
+			len(point) >= len(mount) {
+			mount, options = point, strings.Split(fields[5], ",")
+		}
+	}
+
+	return mount, options
+}
@@ -0,0 +1,32 @@
+//go:build linux
+// +build linux
+
+package pakkero
+
+import (
+	"testing"
+)
+
+func TestUnescapeMount(t *testing.T) {
+	tests := map[string]string{
+		`/mnt/my\040disk`: "/mnt/my disk",
+		`/mnt/tab\011`:    "/mnt/tab\t",
+		`/mnt/back\134`:   `/mnt/back\`,
+		`/mnt/plain`:      "/mnt/plain",
+		`/mnt/short\04`:   `/mnt/short\04`,
+		`/mnt/not\999`:    `/mnt/not\999`,
+	}
+
+	for field, expected := range tests {
+		if result := unescapeMount(field); result != expected {
+			t.Errorf("%s: %q, expected %q", field, result, expected)
+		}
+	}
+}
+
+func TestDirMount(t *testing.T) {
+	mount, options := dirMount("/proc/self")
+	if mount != "/proc" || len(options) == 0 {
+		t.Errorf("/proc/self: %q %v", mount, options)
+	}
+}
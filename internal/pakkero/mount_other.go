@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Mount library of the other hosts
+*/
+package pakkero
+
+// dirMount returns the mount of the directory and its options: only linux can tell
+func dirMount(dir string) (string, []string) {
+	return "", nil
+}
@@ -5,22 +5,55 @@ Obfuscation library
 package pakkero
 
 import (
-	"crypto/rand"
+	"bytes"
+	"context"
 	"encoding/hex"
 	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
 	"io/ioutil"
-	mathRand "math/rand"
+	"path"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
-	"time"
+	"unicode"
 )
 
 // Secrets are the group of strings that we want to obfuscate
 var Secrets = map[string][]string{}
 
+/*
+sensitiveRegex matches the sensitive secrets in the launcher,
+written as obSensitive(buffer, "secret").
+*/
+var sensitiveRegex = regexp.MustCompile(`obSensitive\(([a-zA-Z0-9_]+), ("[^"\\]*")\)`)
+
+/*
+preservedRegex matches the code whose strings must stay literal: block
+comments, holding the cgo preamble too, the import "C" lines and the
+start of struct types, whose fields can have tags.
+*/
+var preservedRegex = regexp.MustCompile(`(?s)/\*.*?\*/|(?m)^[ \t]*import[ \t]+"C"[ \t]*$|\bstruct[ \t]*\{`)
+
+/*
+stringRegexes match the literals on a line, by delimiter: the escaped
+delimiters do not end rune and interpreted strings.
+*/
+var stringRegexes = []*regexp.Regexp{
+	regexp.MustCompile("`[^`\n]*`"),
+	regexp.MustCompile(`'(?:[^'\\\n]|\\.)*'`),
+	regexp.MustCompile(`"(?:[^"\\\n]|\\.)*"`),
+}
+
 // LauncherStub Stub of the Launcher.go, put here during compilation time
 const LauncherStub = "LAUNCHERSTUB"
 
+// minStripLength is the shortest string StripFile will null out
+const minStripLength = 3
+
 var extras = []string{
 	// ELF Headers
 	".gopclntab",
@@ -64,7 +97,9 @@ var extras = []string{
 /*
 StripUPXHeaders will ensure no trace of UPX headers are left
 so that reversing will be more challenging and break
-simple attempts like "upx -d" in case of compression
+simple attempts like "upx -d" in case of compression.
+Each sequence is replaced with random garbage by pakkero itself, the
+same on every host, sed could not match the ones across a newline.
 */
 func StripUPXHeaders(infile string) bool {
 	// Bit sequence of UPX copyright and header infos
@@ -86,70 +121,73 @@ func StripUPXHeaders(infile string) bool {
 		`\x20\x52\x65\x73\x65\x72\x76\x65\x64\x2e`,
 		`\x55\x50\x58\x21`,
 	}
-	result := true
 
-	for _, v := range header {
-		sedString := ""
-		// generate random byte sequence
-		replace := make([]byte, 1)
-
-		for len(sedString) < len(v) {
-			_, err := rand.Read(replace)
-			if err != nil {
-				return false
-			}
+	content, err := ioutil.ReadFile(infile)
+	if err != nil {
+		return false
+	}
 
-			sedString += `\x` + hex.EncodeToString(replace)
+	for _, v := range header {
+		sequence, err := hex.DecodeString(strings.ReplaceAll(v, `\x`, ""))
+		if err != nil {
+			return false
 		}
-		// replace UPX sequence with random garbage
-		result = ExecCommand("sed", []string{"-i", `s/` + v + `/` + sedString + `/g`, infile})
-		if !result {
-			return result
+
+		replace := make([]byte, len(sequence))
+
+		err = randomRead(replace)
+		if err != nil {
+			return false
 		}
+
+		content = bytes.ReplaceAll(content, sequence, replace)
 	}
 
-	return result
+	return ioutil.WriteFile(infile, content, 0755) == nil
 }
 
 /*
 StripFile will strip out all unneeded headers from and ELF
-file in input
+file in input, and anonymize the golang strings if requested: the
+extras and the calibrated words, see LoadCalibration.
+A garbled file has no import or module path left to anonymize.
 */
-func StripFile(infile string, launcherFile string) bool {
-	// strip symbols and headers
-	if !ExecCommand("strip",
-		[]string{
-			"-sxX",
-			"--remove-section=.bss",
-			"--remove-section=.comment",
-			"--remove-section=.eh_frame",
-			"--remove-section=.eh_frame_hdr",
-			"--remove-section=.fini",
-			"--remove-section=.fini_array",
-			"--remove-section=.gnu.build.attributes",
-			"--remove-section=.gnu.hash",
-			"--remove-section=.gnu.version",
-			"--remove-section=.gosymtab",
-			"--remove-section=.got",
-			"--remove-section=.note.ABI-tag",
-			"--remove-section=.note.gnu.build-id",
-			"--remove-section=.note.go.buildid",
-			"--remove-section=.shstrtab",
-			"--remove-section=.typelink",
-			infile,
-		}) {
-		return false
+func StripFile(ctx context.Context, infile string, launcherFile string, module string,
+	anonymize bool, garbled bool) error {
+	// strip symbols and headers, with the arguments of the strip found
+	flavor := ToolFlavor("strip")
+
+	args := stripArgs(flavor, infile)
+	if args == nil {
+		Log.Warnf("strip is %s, the launcher keeps its sections, "+
+			"install binutils or pin one with -tool strip=/path", flavor)
+	} else {
+		err := execTool(ctx, "", "strip", args, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !anonymize {
+		return nil
 	}
 
 	// ------------------------------------------------------------------------
 	// proceede with manual
 	// stripping of golang builtins and keyWords strings
 	removeStrings := []string{}
-	removeStrings = append(removeStrings, extras...)
-	// stripping of the dependencies strings
-	removeStrings = append(removeStrings, ListImportsFromFile(launcherFile)...)
-	// anonymize the launcherFile string to hide the original launcher file name
-	removeStrings = append(removeStrings, launcherFile)
+	removeStrings = append(removeStrings, scrubExtras()...)
+
+	// garble already replaced the paths with hashes
+	if !garbled {
+		// stripping of the dependencies strings
+		removeStrings = append(removeStrings, ListImportsFromFile(launcherFile)...)
+		// anonymize the launcher file name, -trimpath leaves only the
+		// basename in the binary, never the path of the host
+		removeStrings = append(removeStrings, path.Base(launcherFile))
+		// and the module path, present in the build info and file table
+		removeStrings = append(removeStrings, module)
+	}
 
 	// deduplicate
 	removeStrings = Unique(removeStrings)
@@ -157,22 +195,33 @@ func StripFile(infile string, launcherFile string) bool {
 	// read file to string
 	byteContent, err := ioutil.ReadFile(infile)
 	if err != nil {
-		return false
+		return err
 	}
 
-	input := string(byteContent)
+	input := scrubStrings(string(byteContent), removeStrings)
+
+	// save.
+	err = ioutil.WriteFile(infile, []byte(input), 0644)
+	// ------------------------------------------------------------------------
+
+	return err
+}
 
+// scrubStrings will null every occurrence of the strings in the input
+func scrubStrings(input string, removeStrings []string) string {
 	for _, remove := range removeStrings {
+		// two letter strings like "os" appear by chance in the
+		// pclntab and code, nulling them corrupts the runtime.
+		if len(remove) < minStripLength {
+			continue
+		}
 		// generate new random string to place instead
 		newName := GenerateNullString(len(remove))
 		input = strings.ReplaceAll(input, remove, newName)
 		input = strings.ReplaceAll(input, strings.Title(remove), newName)
 	}
-	// save.
-	err = ioutil.WriteFile(infile, []byte(input), 0644)
-	// ------------------------------------------------------------------------
 
-	return err == nil
+	return input
 }
 
 /*
@@ -189,22 +238,86 @@ func GenerateTyposquatName() string {
 	length := 128
 	b := make([]rune, length)
 	// ensure we do not start with a number or we will break code.
-	b[0] = letterRunes[mathRand.Intn(len(letterRunes))]
+	b[0] = letterRunes[randomSource.Intn(len(letterRunes))]
 	for i := range b {
 		if i != 0 {
-			mathRand.Seed(time.Now().UnixNano())
-			b[i] = mixedRunes[mathRand.Intn(len(mixedRunes))]
+			b[i] = mixedRunes[randomSource.Intn(len(mixedRunes))]
 		}
 	}
 
 	return string(b)
 }
 
+// bounds of the bytes decoded by a single generated function
+const (
+	minStringChunk = 256
+	maxStringChunk = 512
+)
+
+// bytes decoded by a single generated function, random for each build
+var stringChunk int64
+
+/*
+chunkString will split a secret in chunks of the size chosen for this
+build, long secrets would otherwise generate a single huge function,
+slow to build and easy to spot.
+*/
+func chunkString(txt string) []string {
+	if stringChunk == 0 {
+		stringChunk = Random(minStringChunk, maxStringChunk)
+	}
+
+	chunks := []string{}
+
+	for len(txt) > int(stringChunk) {
+		chunks = append(chunks, txt[:stringChunk])
+		txt = txt[stringChunk:]
+	}
+
+	return append(chunks, txt)
+}
+
 /*
 GenerateStringFunc will hide a string creating a function that returns
-that value as a string encoded with a series of byteshift operations
+that value as a string encoded with a series of byteshift operations,
+each one on the launcher obAnchor, that the compiler cannot fold.
+Long strings are decoded by sub-functions, whose outputs are
+concatenated, see chunkString.
 */
 func GenerateStringFunc(txt string, function string) string {
+	// no byte to decode, no anchor to use
+	if txt == "" {
+		return "func " + function + "() string { return \"\" }"
+	}
+
+	chunks := chunkString(txt)
+	if len(chunks) == 1 {
+		return fmt.Sprintf("func "+
+			function+
+			"() string { EAX := obAnchor;"+
+			"return string(\n[]byte{\n%s,\n},\n)}",
+			generateBitshifts(chunks[0], ",\n"))
+	}
+
+	subs := ""
+	calls := []string{}
+
+	for _, chunk := range chunks {
+		name := GenerateTyposquatName()
+		subs += fmt.Sprintf("func "+
+			name+
+			"() []byte { EAX := obAnchor;"+
+			"return []byte{\n%s,\n}}\n",
+			generateBitshifts(chunk, ",\n"))
+		calls = append(calls, name+"()")
+	}
+
+	return subs + "func " + function + "() string { " +
+		"return string(obBytes.Join([][]byte{" + strings.Join(calls, ", ") + "}, nil))}"
+}
+
+// generateBitshifts returns the byteshift operations of txt, joined by sep
+func generateBitshifts(txt string, sep string) string {
 	lines := []string{}
 	for _, item := range []byte(txt) {
 		lines = append(
@@ -212,16 +325,61 @@ func GenerateStringFunc(txt string, function string) string {
 		)
 	}
 
-	return fmt.Sprintf("func "+
-		function+
-		"() string { EAX := uint8(obUnsafe.Sizeof(true));"+
-		"return string(\n[]byte{\n%s,\n},\n)}",
-		strings.Join(lines, ",\n"))
+	return strings.Join(lines, sep)
+}
+
+/*
+preserveCode will replace the code matched by preservedRegex, up to the
+closing brace for struct types, with NUL delimited markers: those never
+appear in go source. Returns the new body and the preserved code,
+in the order of the markers.
+*/
+func preserveCode(body string) (string, []string) {
+	preserved := []string{}
+	result := ""
+
+	for {
+		match := preservedRegex.FindStringIndex(body)
+		if match == nil {
+			break
+		}
+
+		end := match[1]
+
+		if strings.HasSuffix(body[match[0]:end], "{") {
+			depth := 1
+			for ; end < len(body) && depth > 0; end++ {
+				switch body[end] {
+				case '{':
+					depth++
+				case '}':
+					depth--
+				}
+			}
+		}
+
+		result += body[:match[0]] + fmt.Sprintf("\x00%d\x00", len(preserved))
+		preserved = append(preserved, body[match[0]:end])
+		body = body[end:]
+	}
+
+	return result + body, preserved
+}
+
+// restoreCode will put back the code replaced by preserveCode
+func restoreCode(body string, preserved []string) string {
+	for i, code := range preserved {
+		body = strings.Replace(body, fmt.Sprintf("\x00%d\x00", i), code, 1)
+	}
+
+	return body
 }
 
 /*
 ObfuscateStrings will extract all plaintext strings denotet with
-backticks and obfuscate them using byteshift wise operations
+backticks and obfuscate them using byteshift wise operations,
+sensitive secrets are obfuscated first by ObfuscateSensitive.
+Comments, cgo imports and struct tags are left as they are.
 */
 func ObfuscateStrings(input string) string {
 
@@ -232,16 +390,13 @@ func ObfuscateStrings(input string) string {
 	// import section
 	importSection := input[:imports+endimports+1]
 
-	// the rest of the program
-	body := input[imports+endimports+1:]
+	// the rest of the program, sensitive secrets first
+	body, preserved := preserveCode(input[imports+endimports+1:])
+	body, sensitiveFuncs := ObfuscateSensitive(body)
 
-	// various types of string delimiter
-	tickTypes := []string{"`", `'`, `"`}
-
-	// for each ticktype, try to get all the strings and
+	// for each type of string delimiter, try to get all the strings and
 	// obfuscate them using functions
-	for _, v := range tickTypes {
-		regex := regexp.MustCompile(v + ".*?" + v)
+	for _, regex := range stringRegexes {
 		words := regex.FindAllString(body, -1)
 		words = Unique(words)
 
@@ -259,8 +414,17 @@ func ObfuscateStrings(input string) string {
 	}
 	// create function call
 	funcString := ""
-	// replace all secrects with the respective obfuscated string
-	for k, w := range Secrets {
+	// replace all secrects with the respective obfuscated string,
+	// in a stable order so the same seed generates the same launcher
+	keys := []string{}
+	for k := range Secrets {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		w := Secrets[k]
 		// in case we manually added some secrets that we want to leave
 		if !strings.Contains(w[1], "leave") {
 			funcString = funcString + GenerateStringFunc(w[0], w[1]) + "\n"
@@ -272,12 +436,78 @@ func ObfuscateStrings(input string) string {
 
 	// reconstruct the program correctly and
 	// insert all the functions before the main
-	body = body + "\n" + funcString
+	body = restoreCode(body, preserved) + "\n" + funcString + sensitiveFuncs
 
 	// join back with the import section
 	return importSection + body
 }
 
+/*
+GenerateBytesFunc will hide a sensitive string creating a function that
+appends it to a caller-provided buffer byte by byte, using the same
+byteshift operations of GenerateStringFunc, so that the value never
+exists as a string and can be wiped after use.
+Long strings are appended by sub-functions, like in GenerateStringFunc.
+*/
+func GenerateBytesFunc(txt string, function string) string {
+	// no byte to append, no anchor to use
+	if txt == "" {
+		return "func " + function + "(obBuffer []byte) []byte {\nreturn obBuffer[:0]}"
+	}
+
+	subs := ""
+	calls := []string{}
+
+	for _, chunk := range chunkString(txt) {
+		lines := []string{}
+		for _, item := range []byte(chunk) {
+			lines = append(lines,
+				"obBuffer = append(obBuffer, "+GenerateBitshift(item)+")")
+		}
+
+		name := GenerateTyposquatName()
+		subs += fmt.Sprintf("func "+
+			name+
+			"(obBuffer []byte) []byte { EAX := obAnchor;\n"+
+			"%s\nreturn obBuffer}\n",
+			strings.Join(lines, "\n"))
+		calls = append(calls, "obBuffer = "+name+"(obBuffer)")
+	}
+
+	return subs + "func " + function + "(obBuffer []byte) []byte {\n" +
+		"obBuffer = obBuffer[:0]\n" + strings.Join(calls, "\n") + "\nreturn obBuffer}"
+}
+
+/*
+ObfuscateSensitive will replace each obSensitive(buffer, "secret") call
+with a function filling the buffer, see GenerateBytesFunc.
+Placeholders are resolved using the Secrets.
+Returns the new body and the generated functions.
+*/
+func ObfuscateSensitive(body string) (string, string) {
+	funcString := ""
+
+	for _, match := range sensitiveRegex.FindAllStringSubmatch(body, -1) {
+		if !strings.Contains(body, match[0]) {
+			// already replaced
+			continue
+		}
+
+		value := match[2][1 : len(match[2])-1]
+
+		secret, present := Secrets[match[2]]
+		if present {
+			value = secret[0]
+		}
+
+		name := GenerateTyposquatName()
+		funcString = funcString + GenerateBytesFunc(value, name) + "\n"
+		body = strings.ReplaceAll(body, match[0], name+"("+match[1]+")")
+	}
+
+	return body, funcString
+}
+
 /*
 ObfuscateFuncVars will:
   - extract all obfuscation-enabled func and var names:
@@ -286,68 +516,515 @@ ObfuscateFuncVars will:
     replace all string with that
 */
 func ObfuscateFuncVars(input string) string {
+	return obfuscateNames([]string{input})[0]
+}
+
+/*
+obfuscateNames is ObfuscateFuncVars on sources built together,
+like the go and the assembly ones of a package: a name gets the same
+random replacement in each of them.
+Only whole names are replaced, the names kept for their directives,
+see DirectivePolicies, are left as they are.
+*/
+func obfuscateNames(inputs []string) []string {
 	// obfuscate functions and variables names
 	regex := regexp.MustCompile(`\bob[a-zA-Z0-9_]+`)
-	words := regex.FindAllString(input, -1)
-	words = ReverseStringArray(words)
+	words := regex.FindAllString(strings.Join(inputs, "\n"), -1)
+	words = ReverseSlice(words)
 	words = Unique(words)
 
+	kept := directiveNames(strings.Join(inputs, "\n"))
+	names := map[string]string{}
+
 	for _, w := range words {
+		if kept[w] {
+			keptNames = append(keptNames, w)
+
+			continue
+		}
+
 		// generate random name for each matching string
-		input = strings.ReplaceAll(input, w, GenerateTyposquatName())
+		names[w] = GenerateTyposquatName()
+		renamedNames = append(renamedNames, [2]string{w, names[w]})
 	}
 
-	return input
+	for i := range inputs {
+		inputs[i] = regex.ReplaceAllStringFunc(inputs[i], func(w string) string {
+			if name, ok := names[w]; ok {
+				return name
+			}
+
+			return w
+		})
+	}
+
+	return inputs
+}
+
+/*
+importName guesses the name of a package from its import path, the
+same way goimports does: the last element, without a version suffix,
+a go- prefix or anything after a non identifier character,
+like gopkg.in/yaml.v2 that is yaml.
+*/
+func importName(importPath string) string {
+	base := path.Base(importPath)
+
+	if strings.HasPrefix(base, "v") {
+		if _, err := strconv.Atoi(base[1:]); err == nil && path.Dir(importPath) != "." {
+			base = path.Base(path.Dir(importPath))
+		}
+	}
+
+	base = strings.TrimPrefix(base, "go-")
+
+	end := strings.IndexFunc(base, func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '_'
+	})
+	if end >= 0 {
+		base = base[:end]
+	}
+
+	return base
+}
+
+/*
+AliasImports will give an ob alias to every import without one and
+rename the package in its selectors, so that the name obfuscation
+renames it too. Blank, dot and cgo imports are left as they are.
+A package whose name is not the one guessed by importName
+needs an explicit alias in the template.
+*/
+func AliasImports(input string) (string, error) {
+	fileSet := token.NewFileSet()
+
+	file, err := parser.ParseFile(fileSet, "launcher.go", input, parser.ParseComments)
+	if err != nil {
+		return input, err
+	}
+
+	aliases := map[string]string{}
+
+	for i, spec := range file.Imports {
+		importPath, _ := strconv.Unquote(spec.Path.Value)
+		if spec.Name != nil || importPath == "C" {
+			continue
+		}
+
+		// the index keeps the aliases apart, even as substrings of each other
+		alias := fmt.Sprintf("obImport%dAlias", i)
+		aliases[importName(importPath)] = alias
+		spec.Name = ast.NewIdent(alias)
+	}
+
+	if len(aliases) == 0 {
+		return input, nil
+	}
+
+	ast.Inspect(file, func(node ast.Node) bool {
+		selector, ok := node.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+
+		// a package is never resolved to a declaration in the file
+		pkg, ok := selector.X.(*ast.Ident)
+		if ok && pkg.Obj == nil && aliases[pkg.Name] != "" {
+			pkg.Name = aliases[pkg.Name]
+		}
+
+		return true
+	})
+
+	var output bytes.Buffer
+
+	err = format.Node(&output, fileSet, file)
+	if err != nil {
+		return input, err
+	}
+
+	return output.String(), nil
+}
+
+// ways a check point dispatches its checks
+const (
+	dispatchInline = iota
+	dispatchGoroutine
+	dispatchBatch
+)
+
+/*
+dispatchChecks returns the code running the checks at a check point:
+each one is dispatched at random inline, in a goroutine of its own or
+in a goroutine shared with others, so that the number of goroutines
+started differs per point and per build.
+The goroutines are waited for, every check has passed when the code
+returns. The ordering is returned too, for the explain report.
+*/
+func dispatchChecks(checks []string) (string, string) {
+	inline := []string{}
+	goroutines := []string{}
+	batch := []string{}
+
+	for _, check := range ShuffleSlice(checks) {
+		switch randomSource.Intn(3) {
+		case dispatchInline:
+			inline = append(inline, check)
+		case dispatchGoroutine:
+			goroutines = append(goroutines, check)
+		case dispatchBatch:
+			batch = append(batch, check)
+		}
+	}
+
+	// a batch of one is a goroutine of its own
+	if len(batch) > 0 {
+		goroutines = append(goroutines, strings.Join(batch, "; "))
+	}
+
+	ordering := "inline [" + strings.Join(inline, ", ") + "], goroutines [" +
+		strings.Join(goroutines, " | ") + "]"
+
+	if len(goroutines) == 0 {
+		return strings.Join(inline, "; "), ordering
+	}
+
+	result := fmt.Sprintf("{ var obWaitGroup obSync.WaitGroup; obWaitGroup.Add(%d); ", len(goroutines))
+
+	for _, goroutine := range goroutines {
+		result += "go func() { defer obWaitGroup.Done(); " + goroutine + " }(); "
+	}
+
+	for _, check := range inline {
+		result += check + "; "
+	}
+
+	return result + "obWaitGroup.Wait() }", ordering
+}
+
+// antiDebugChecks are the calls GenerateRandomAntiDebug spreads over the check points
+var antiDebugChecks = []string{
+	`obDependencyCheck()`,
+	`obEnvArgsDetect()`,
+	`obParentTracerDetect()`,
+	`obParentCmdLineDetect()`,
+	`obEnvDetect()`,
+	`obEnvParentDetect()`,
+	`obLdPreloadDetect()`,
+	`obParentDetect()`,
+}
+
+/*
+injectedSymbols are the launcher declarations used by the code the
+obfuscation injects, besides the antiDebugChecks: the dispatch of the
+checks, the integrity guards and the string functions.
+*/
+var injectedSymbols = []string{"obSync", "obTamper", "obCheckGuard", "obAnchor", "obBytes"}
+
+/*
+CheckInjectedSymbols returns an error if the launcher does not declare
+a name the injected code uses: a feature block left out by
+StripFeatures must never hold one of them, else the build would fail
+on a typosquatted name pointing nowhere.
+*/
+func CheckInjectedSymbols(input string) error {
+	file, err := parser.ParseFile(token.NewFileSet(), "launcher.go", input, 0)
+	if err != nil {
+		return err
+	}
+
+	declared := map[string]bool{}
+
+	for _, spec := range file.Imports {
+		if spec.Name != nil {
+			declared[spec.Name.Name] = true
+		}
+	}
+
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv == nil {
+				declared[decl.Name.Name] = true
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.ValueSpec:
+					for _, name := range spec.Names {
+						declared[name.Name] = true
+					}
+				case *ast.TypeSpec:
+					declared[spec.Name.Name] = true
+				}
+			}
+		}
+	}
+
+	missing := []string{}
+
+	for _, check := range antiDebugChecks {
+		name := strings.TrimSuffix(check, "()")
+		if !declared[name] {
+			missing = append(missing, name)
+		}
+	}
+
+	for _, name := range injectedSymbols {
+		if !declared[name] {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("the enabled launcher features leave out %s, used by the injected checks",
+			strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// a custom check declared by the launcher, see CustomChecks
+var checkFuncRegex = regexp.MustCompile(`^\s*// OB_CHECK_FUNC\b(.*)$`)
+
+/*
+CustomChecks returns the calls of the checks the launcher declares,
+for a pre-obfuscate hook to add its own, with:
+
+	// OB_CHECK_FUNC obName
+
+obName must be a function of the launcher with the func() bool
+signature, returning true on tampering: it is called with the
+antiDebugChecks, reported as obCheckCustom.
+An error, with its line, for a declaration that is not one of them.
+*/
+func CustomChecks(input string) ([]string, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), "launcher.go", input, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	funcs := map[string]*ast.FuncDecl{}
+
+	for _, decl := range file.Decls {
+		if decl, ok := decl.(*ast.FuncDecl); ok && decl.Recv == nil {
+			funcs[decl.Name.Name] = decl
+		}
+	}
+
+	checks := []string{}
+
+	for i, v := range strings.Split(input, "\n") {
+		match := checkFuncRegex.FindStringSubmatch(v)
+		if match == nil {
+			continue
+		}
+
+		name := strings.TrimSpace(match[1])
+
+		decl, declared := funcs[name]
+		if !declared {
+			return nil, fmt.Errorf("line %d: OB_CHECK_FUNC %q is not a function of the launcher", i+1, name)
+		}
+
+		results := decl.Type.Results
+		if len(decl.Type.Params.List) > 0 ||
+			results == nil || len(results.List) != 1 || len(results.List[0].Names) > 1 ||
+			!isIdent(results.List[0].Type, "bool") {
+			return nil, fmt.Errorf("line %d: OB_CHECK_FUNC %s is not a func() bool", i+1, name)
+		}
+
+		check := "if " + name + "() { obTamper(obCheckCustom) }"
+		if !Contains(checks, check) {
+			checks = append(checks, check)
+		}
+	}
+
+	return checks, nil
+}
+
+// isIdent returns true if expr is the identifier name
+func isIdent(expr ast.Expr, name string) bool {
+	ident, ok := expr.(*ast.Ident)
+
+	return ok && ident.Name == name
+}
+
+// MaxChecksPerSite is the highest number of checks at a check point, unless there are too few points
+const MaxChecksPerSite = 3
+
+/*
+distributeChecks returns the checks of each of sites check points:
+every check is at one point at least, and each point has at most
+MaxChecksPerSite of them, more only if there are not enough points to
+place them all. The points are then topped up with random checks up to
+a random number, so that each point runs at least one.
+*/
+func distributeChecks(checks []string, sites int) [][]string {
+	if sites == 0 {
+		return nil
+	}
+
+	limit := MaxChecksPerSite
+	if needed := (len(checks) + sites - 1) / sites; needed > limit {
+		limit = needed
+	}
+
+	result := make([][]string, sites)
+	order := randomSource.Perm(sites)
+
+	// every check once, over the points in random order
+	for i, check := range ShuffleSlice(append([]string{}, checks...)) {
+		site := order[i%sites]
+		result[site] = append(result[site], check)
+	}
+
+	// then the remainder, at random
+	for i := range result {
+		wanted := 1 + randomSource.Intn(limit)
+
+		for _, check := range ShuffleSlice(append([]string{}, checks...)) {
+			if len(result[i]) >= wanted {
+				break
+			}
+
+			if !Contains(result[i], check) {
+				result[i] = append(result[i], check)
+			}
+		}
+	}
+
+	return result
 }
 
 /*
 GenerateRandomAntiDebug will Insert random order of anti-debug check
 together with inline compilation to induce big number
-of instructions in random order
+of instructions in random order, see dispatchChecks.
+The CustomChecks of the launcher are placed with the antiDebugChecks,
+spread over the OB_CHECK markers, see distributeChecks.
+A launcher with no marker would run no check: that is an error unless
+allowNoChecks.
 */
-func GenerateRandomAntiDebug(input string) string {
+func GenerateRandomAntiDebug(input string, allowNoChecks bool) (string, error) {
+	custom, err := CustomChecks(input)
+	if err != nil {
+		return "", err
+	}
+
 	lines := strings.Split(input, "\n")
-	randomChecks := []string{
-		`obDependencyCheck()`,
-		`obEnvArgsDetect()`,
-		`obParentTracerDetect()`,
-		`obParentCmdLineDetect()`,
-		`obEnvDetect()`,
-		`obEnvParentDetect() `,
-		`obLdPreloadDetect()`,
-		`obParentDetect()`,
+	randomChecks := append(append([]string{}, antiDebugChecks...), custom...)
+
+	sites := 0
+	for _, v := range lines {
+		if checkMarkerRegex.MatchString(v) {
+			sites++
+		}
+	}
+
+	if sites == 0 && !allowNoChecks {
+		return "", fmt.Errorf("no // OB_CHECK marker, the launcher would run no check")
 	}
+
+	distribution := distributeChecks(randomChecks, sites)
 	// find OB_CHECK and put the checks there.
+	site := 0
 	for i, v := range lines {
-		if strings.Contains(v, "// OB_CHECK") {
-			threadString := ""
-			checkString := ""
-			// randomize order of check to replace
-			for j, v := range ShuffleSlice(randomChecks) {
-				threadString = threadString + "go " + v + ";"
-				checkString += v
-
-				if j != (len(randomChecks) - 1) {
-					checkString += `||`
-				}
-			}
-			// add action in case of failed check
-			lines[i] = threadString
+		switch {
+		case checkMarkerRegex.MatchString(v):
+			code, ordering := dispatchChecks(distribution[site])
+			lines[i] = code
+			checkSites = append(checkSites, ordering)
+			site++
+		case checkFuncRegex.MatchString(v):
+			lines[i] = ""
 		}
 	}
 	// back to single string
-	return strings.Join(lines, "\n")
+	return strings.Join(lines, "\n"), nil
+}
+
+/*
+StripFeatures will remove from the launcher the code blocks of optional
+features that are not enabled.
+
+A feature block is delimited by:
+
+	// OB_FEATURE_BEGIN name
+	// OB_FEATURE_END name
+
+and is kept only if the feature is enabled, a block named "!name" is
+instead kept only if the feature is disabled. A block of several names,
+"name|other", is kept if any of them is: an import shared by features
+is written once, in such a block.
+A single line can be marked with a trailing:
+
+	// OB_FEATURE name
+
+Blocks can be nested, markers are always removed.
+*/
+func StripFeatures(input string, features map[string]bool) string {
+	const lineMarker = " // OB_FEATURE "
+
+	// enabled returns true if any of the names of a block or a line is kept
+	enabled := func(names string) bool {
+		for _, name := range strings.Split(names, "|") {
+			if strings.HasPrefix(name, "!") != features[strings.TrimPrefix(name, "!")] {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	lines := strings.Split(input, "\n")
+	result := []string{}
+	// stack of the currently open blocks, true if kept
+	blocks := []bool{}
+
+	for _, v := range lines {
+		line := strings.TrimSpace(v)
+
+		switch {
+		case strings.HasPrefix(line, "// OB_FEATURE_BEGIN "):
+			blocks = append(blocks, enabled(strings.TrimPrefix(line, "// OB_FEATURE_BEGIN ")))
+		case strings.HasPrefix(line, "// OB_FEATURE_END "):
+			if len(blocks) > 0 {
+				blocks = blocks[:len(blocks)-1]
+			}
+		default:
+			keep := true
+
+			for _, b := range blocks {
+				keep = keep && b
+			}
+
+			if marker := strings.Index(v, lineMarker); marker >= 0 {
+				keep = keep && enabled(strings.TrimSpace(v[marker+len(lineMarker):]))
+				v = v[:marker]
+			}
+
+			if keep {
+				result = append(result, v)
+			}
+		}
+	}
+
+	return strings.Join(result, "\n")
 }
 
 /*
 ObfuscateLauncher the go code of the runner before compiling it.
 
 Basic techniques are applied:
-- GenerateRandomAntiDebug
-- ObfuscateStrings
+- InjectGuards, the given number of integrity guards
+- GenerateRandomAntiDebug, failing with no check point unless allowNoChecks
+- ObfuscateStrings, then GenerateGuards
 - ObfuscateFuncVars
+
+What each pass did is kept for WriteExplain.
 */
-func ObfuscateLauncher(infile string) error {
+func ObfuscateLauncher(infile string, guards int, allowNoChecks bool) error {
 	byteContent, err := ioutil.ReadFile(infile)
 	if err != nil {
 		return err
@@ -355,26 +1032,127 @@ func ObfuscateLauncher(infile string) error {
 
 	content := string(byteContent)
 
+	explainedPasses = nil
+	checkSites = nil
+	renamedNames = nil
+	keptNames = nil
+	// chosen again for each launcher, the same seed gives the same one
+	stringChunk = 0
+
+	// ------------------------------------------------------------------------
+	//	--- Start import aliasing
+	content, err = AliasImports(content)
+	if err != nil {
+		return fmt.Errorf("the import aliasing pass cannot parse the launcher: %s", err)
+	}
+
+	err = CheckInjectedSymbols(content)
+	if err != nil {
+		return err
+	}
+
+	explainedPasses = append(explainedPasses, explainAliases(content))
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	//	--- Start integrity guards, before the anti-debug checks
+	//	--- take their markers
+	requested := guards
+	markers := len(checkMarkerRegex.FindAllStringIndex(content, -1))
+
+	content, guards = InjectGuards(content, guards)
+
+	explainedPasses = append(explainedPasses, explainGuards(requested, guards, markers))
+	// ------------------------------------------------------------------------
+
 	// ------------------------------------------------------------------------
 	//	--- Start anti-debug checks
-	content = GenerateRandomAntiDebug(content)
+	content, err = GenerateRandomAntiDebug(content, allowNoChecks)
+	if err != nil {
+		return fmt.Errorf("the anti-debug pass: %s", err)
+	}
+
+	explainedPasses = append(explainedPasses, explainAntiDebug())
+
+	err = checkSource("anti-debug", content)
+	if err != nil {
+		return err
+	}
 	// ------------------------------------------------------------------------
 
 	// ------------------------------------------------------------------------
 	//	--- Start string obfuscation
+	registered := map[string]bool{}
+	for k := range Secrets {
+		registered[k] = true
+	}
+
+	input := content
+
 	content = ObfuscateStrings(content)
-	// ------------------------------------------------------------------------
+	content += "\n" + GenerateGuards(content, guards)
+
+	explainedPasses = append(explainedPasses, explainStrings(input, registered))
 
+	err = checkSource("string obfuscation", content)
+	if err != nil {
+		return err
+	}
 	// ------------------------------------------------------------------------
-	//	--- Start function name obfuscation
-	content = ObfuscateFuncVars(content)
+
 	// ------------------------------------------------------------------------
+	//	--- Start function name obfuscation, with the assembly
+	//	--- of the launcher, if any
+	asm, hasAsm, err := readLauncherAsm(infile)
+	if err != nil {
+		return err
+	}
 
-	// save.
-	err = ioutil.WriteFile(infile, []byte(content), 0644)
+	sources := obfuscateNames([]string{content, asm})
+	content = sources[0]
+
+	explainedPasses = append(explainedPasses, explainNames())
+
+	err = checkSource("name obfuscation", content)
 	if err != nil {
 		return err
 	}
+	// ------------------------------------------------------------------------
 
-	return nil
+	// save.
+	if hasAsm {
+		err = ioutil.WriteFile(launcherAsmFile(infile), []byte(sources[1]), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return ioutil.WriteFile(infile, []byte(content), 0644)
+}
+
+/*
+checkSource returns an error if a pass of the obfuscation generated
+source that does not parse, the build would fail later on it
+with an error pointing nowhere near the pass.
+The source is dumped outside the workspace, that is removed,
+and its path is in the error.
+*/
+func checkSource(pass string, content string) error {
+	_, err := parser.ParseFile(token.NewFileSet(), "launcher.go", content, 0)
+	if err == nil {
+		return nil
+	}
+
+	dump, dumpErr := ioutil.TempFile("", "pakkero-launcher-*.go")
+	if dumpErr != nil {
+		return fmt.Errorf("the %s pass generated invalid go: %s", pass, err)
+	}
+	defer dump.Close()
+
+	_, dumpErr = dump.WriteString(content)
+	if dumpErr != nil {
+		return fmt.Errorf("the %s pass generated invalid go: %s", pass, err)
+	}
+
+	return fmt.Errorf("the %s pass generated invalid go, source dumped to %s: %s", pass, dump.Name(), err)
 }
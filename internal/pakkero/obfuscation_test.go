@@ -0,0 +1,1004 @@
+package pakkero
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// launcherTemplate is the launcher source the LauncherStub is made of
+const launcherTemplate = "../../data/launcher.go"
+
+// the standard library, type-checked once from source for every test
+var sourceImporter = importer.ForCompiler(token.NewFileSet(), "source", nil)
+
+// typeErrors returns the first error type-checking a main package source
+func typeErrors(source string) error {
+	fileSet := token.NewFileSet()
+
+	file, err := parser.ParseFile(fileSet, "launcher.go", source, 0)
+	if err != nil {
+		return err
+	}
+
+	config := types.Config{Importer: sourceImporter, FakeImportC: true}
+
+	_, err = config.Check("main", fileSet, []*ast.File{file}, nil)
+
+	return err
+}
+
+// typeCheck type-checks a launcher source, the assembly functions have no body
+func typeCheck(t *testing.T, source string) {
+	t.Helper()
+
+	err := typeErrors(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// templateFunction returns the source of a function of the launcher template
+func templateFunction(t *testing.T, name string) string {
+	t.Helper()
+
+	content, err := ioutil.ReadFile(launcherTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fileSet := token.NewFileSet()
+
+	file, err := parser.ParseFile(fileSet, launcherTemplate, content, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, declaration := range file.Decls {
+		function, ok := declaration.(*ast.FuncDecl)
+		if ok && function.Recv == nil && function.Name.Name == name {
+			return string(content[fileSet.Position(function.Pos()).Offset:fileSet.Position(function.End()).Offset])
+		}
+	}
+
+	t.Fatalf("the template has no function %s", name)
+
+	return ""
+}
+
+/*
+templateDecl returns the source of the declaration of name in the
+launcher template: a function, or the whole var, const or type block
+that declares it.
+*/
+func templateDecl(t *testing.T, name string) string {
+	t.Helper()
+
+	content, err := ioutil.ReadFile(launcherTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fileSet := token.NewFileSet()
+
+	file, err := parser.ParseFile(fileSet, launcherTemplate, content, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := func(node ast.Node) string {
+		return string(content[fileSet.Position(node.Pos()).Offset:fileSet.Position(node.End()).Offset])
+	}
+
+	for _, declaration := range file.Decls {
+		switch declaration := declaration.(type) {
+		case *ast.FuncDecl:
+			if declaration.Recv == nil && declaration.Name.Name == name {
+				return source(declaration)
+			}
+		case *ast.GenDecl:
+			for _, spec := range declaration.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					if spec.Name.Name == name {
+						return source(declaration)
+					}
+				case *ast.ValueSpec:
+					for _, identifier := range spec.Names {
+						if identifier.Name == name {
+							return source(declaration)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	t.Fatalf("the template declares no %s", name)
+
+	return ""
+}
+
+// runProgram runs the main package source with go and returns its output
+func runProgram(t *testing.T, source string) string {
+	t.Helper()
+
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not in PATH")
+	}
+
+	dir := t.TempDir()
+
+	err := ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte(source), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command("go", "run", "main.go")
+	cmd.Dir = dir
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s: %s", err, output)
+	}
+
+	return string(output)
+}
+
+/*
+obfuscatedLauncher writes the launcher of the options to a main.go in a
+temporary directory as a pack would, stripped and obfuscated, with its
+assembly, and returns the file.
+*/
+func obfuscatedLauncher(t *testing.T, opts Options) string {
+	t.Helper()
+
+	content, err := ioutil.ReadFile(launcherTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	features := opts.buildFeatures()
+	file := filepath.Join(t.TempDir(), "main.go")
+
+	err = ioutil.WriteFile(file, []byte(StripFeatures(string(content), features)), 0600)
+	if err == nil {
+		err = WriteLauncherAsm(file, features)
+	}
+
+	if err == nil {
+		err = ObfuscateLauncher(file, opts.Guards, opts.AllowNoChecks)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+/*
+The template is vetted as it is, every feature in: a declaration or an
+import written once per feature would break it, see StripFeatures.
+The launchers of the options are vetted as a pack builds them: a
+variable used only in a stripped block would break them.
+*/
+func TestLauncherTemplateTypeChecks(t *testing.T) {
+	content, err := ioutil.ReadFile(launcherTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	typeCheck(t, string(content))
+
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"daemonize", Options{Daemonize: true}},
+		{"daemonize pidfile", Options{Daemonize: true, Pidfile: "payload.pid"}},
+		{"exec mode userland", Options{ExecMode: ExecModeUserland}},
+		{"payload timeout", Options{PayloadTimeout: time.Minute}},
+		{"prologue", Options{Prologue: "prologue.sh"}},
+		{"anti-dump", Options{AntiDump: true}},
+		{"launcher debug", Options{LauncherDebug: true}},
+		{"codec none", Options{Codec: "none"}},
+	}
+
+	for _, arch := range SupportedArchs() {
+		tests = append(tests, struct {
+			name string
+			opts Options
+		}{arch, Options{Arch: arch}})
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.opts.Arch == "" {
+				test.opts.Arch = launcherAsmArch
+			}
+
+			launcher, err := ioutil.ReadFile(obfuscatedLauncher(t, test.opts))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			typeCheck(t, string(launcher))
+		})
+	}
+}
+
+func TestStripFeatures(t *testing.T) {
+	input := strings.Join([]string{
+		"a",
+		"// OB_FEATURE_BEGIN one",
+		"b",
+		"// OB_FEATURE_BEGIN !two",
+		"c",
+		"// OB_FEATURE_END !two",
+		"// OB_FEATURE_END one",
+		"// OB_FEATURE_BEGIN two|three",
+		"d",
+		"// OB_FEATURE_END two|three",
+		"e // OB_FEATURE two",
+		"f // OB_FEATURE !one|three",
+	}, "\n")
+
+	tests := []struct {
+		features map[string]bool
+		expected []string
+	}{
+		{map[string]bool{}, []string{"a", "f"}},
+		{map[string]bool{"one": true}, []string{"a", "b", "c"}},
+		{map[string]bool{"one": true, "two": true}, []string{"a", "b", "d", "e"}},
+		{map[string]bool{"three": true}, []string{"a", "d", "f"}},
+	}
+
+	for _, test := range tests {
+		result := StripFeatures(input, test.features)
+		if result != strings.Join(test.expected, "\n") {
+			t.Errorf("features %v: %q, expected %q", test.features, result, test.expected)
+		}
+	}
+}
+
+func TestCheckSource(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	err := checkSource("name obfuscation", "package main\n\nfunc main() {}\n")
+	if err != nil {
+		t.Errorf("valid source: %v", err)
+	}
+
+	err = checkSource("name obfuscation", "package main\n\nfunc main() {\n")
+	if err == nil || !strings.HasPrefix(err.Error(), "the name obfuscation pass generated invalid go") {
+		t.Errorf("invalid source: %v", err)
+	}
+}
+
+// the source of a broken pass is dumped out of the workspace, at the path in the error
+func TestCheckSourceDump(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+
+	source := "package main\n\nfunc main() {\n"
+
+	err := checkSource("string obfuscation", source)
+	if err == nil {
+		t.Fatal("invalid source: no error")
+	}
+
+	dumps, _ := filepath.Glob(filepath.Join(dir, "pakkero-launcher-*.go"))
+	if len(dumps) != 1 || !strings.Contains(err.Error(), "source dumped to "+dumps[0]+":") {
+		t.Fatalf("%v, dumps: %v", err, dumps)
+	}
+
+	content, err := ioutil.ReadFile(dumps[0])
+	if err != nil || string(content) != source {
+		t.Errorf("dump: %q, %v", content, err)
+	}
+}
+
+/*
+fuzzProgram is a main package printing a string literal, with what the
+obfuscated strings need: obAnchor and bytes as obBytes.
+*/
+const fuzzProgram = `package main
+
+import (
+	obBytes "bytes"
+	obFmt "fmt"
+	obOS "os"
+)
+
+var obAnchor = uint8(obOS.Getpagesize() / obOS.Getpagesize())
+
+func main() {
+	obFmt.Println(%s, obBytes.MinRead)
+}
+`
+
+// keepSecrets restores the Secrets once the test is done, and starts it with none
+func keepSecrets(t *testing.T) {
+	t.Helper()
+
+	secrets := Secrets
+	t.Cleanup(func() { Secrets = secrets })
+
+	Secrets = map[string][]string{}
+}
+
+/*
+the string passes work on the text of the launcher, whose strings hold no
+other delimiter, nor the code preserved or replaced before them.
+*/
+var unsupportedString = regexp.MustCompile("['`]|/\\*|\\bstruct[ \\t]*\\{|obSensitive\\(")
+
+// any string literal is obfuscated into a call that type-checks, and leaves no plaintext
+func FuzzObfuscateStrings(f *testing.F) {
+	for _, seed := range []string{"", "a", "quote \" inside", "\x00\xff", "tab\there", "ünïcödé", "\"escaped\" quotes",
+		"// not a comment", "%s %d %v", strings.Repeat("long ", 300)} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, value string) {
+		if unsupportedString.MatchString(value) {
+			t.Skip()
+		}
+
+		keepSecrets(t)
+
+		literal := strconv.Quote(value)
+		obfuscated := ObfuscateStrings(fmt.Sprintf(fuzzProgram, literal))
+
+		typeCheck(t, obfuscated)
+
+		// void and escaped strings are left as they are
+		if len(value) == 0 || strings.Contains(literal, `\`) {
+			return
+		}
+
+		if strings.Contains(obfuscated, literal) {
+			t.Errorf("%s is left in plaintext", literal)
+		}
+
+		if !strings.Contains(obfuscated, "obFmt.Println("+Secrets[literal][1]+"()") {
+			t.Errorf("%s is not replaced by its function", literal)
+		}
+	})
+}
+
+// a source that type-checks still does once its ob names are renamed
+func FuzzObfuscateFuncVars(f *testing.F) {
+	f.Add(`package main
+
+import obFmt "fmt"
+
+type obPoint struct {
+	obX int ` + "`json:\"obX\"`" + `
+}
+
+func (obPoint obPoint) obSum(obOther obPoint) int { return obPoint.obX + obOther.obX }
+
+func main() {
+obLoop:
+	for obIndex := 0; obIndex < 3; obIndex++ {
+		if obIndex == 2 {
+			break obLoop
+		}
+	}
+
+	// obPoint is renamed in comments, and "obX" in strings
+	obFmt.Println(obPoint{obX: 1}.obSum(obPoint{}), "obX")
+}
+`)
+	f.Add(`package main
+
+var obCounter, ob2 = 1, 2
+
+const obLimit = 1 << 3
+
+func obNext() int { obCounter++; return obCounter % obLimit }
+
+func main() { _ = obNext() + ob2; var object, ob = 0, 1; _, _ = object, ob }
+`)
+
+	f.Fuzz(func(t *testing.T, source string) {
+		if typeErrors(source) != nil {
+			t.Skip()
+		}
+
+		typeCheck(t, ObfuscateFuncVars(source))
+	})
+}
+
+// the checks spread over the launcher type-check whatever their number and order
+func FuzzGenerateRandomAntiDebug(f *testing.F) {
+	content, err := ioutil.ReadFile(launcherTemplate)
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	launcher := StripFeatures(string(content), Options{Arch: launcherAsmArch}.buildFeatures())
+
+	f.Add(int64(0), uint8(0))
+	f.Add(int64(1), uint8(1))
+	f.Add(int64(42), uint8(7))
+
+	f.Fuzz(func(t *testing.T, seed int64, custom uint8) {
+		source, sites := randomSource, checkSites
+		t.Cleanup(func() { randomSource, checkSites, reproducible = source, sites, false })
+
+		SetRandomSeed(seed)
+
+		// as many custom checks as the checks of the launcher, at most
+		input := launcher
+		for index := 0; index < int(custom)%(len(antiDebugChecks)+1); index++ {
+			input += fmt.Sprintf("\n// OB_CHECK_FUNC obFuzzCheck%d\nfunc obFuzzCheck%d() bool { return false }\n",
+				index, index)
+		}
+
+		output, err := GenerateRandomAntiDebug(input, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		typeCheck(t, output)
+	})
+}
+
+/*
+buildLauncher builds the launcher written by obfuscatedLauncher and
+returns the binary. The source is dumped if the build fails.
+*/
+func buildLauncher(t *testing.T, file string) []byte {
+	t.Helper()
+
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not in PATH")
+	}
+
+	dir := filepath.Dir(file)
+
+	err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module launcher\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command("go", "build", "-o", filepath.Join(dir, "launcher"))
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH="+launcherAsmArch, "CGO_ENABLED=0")
+
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		binary, err := ioutil.ReadFile(filepath.Join(dir, "launcher"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return binary
+	}
+
+	// the workspace is removed with the test, like in checkSource
+	dump, dumpErr := ioutil.TempFile("", "pakkero-launcher-*.go")
+	if dumpErr == nil {
+		content, _ := ioutil.ReadFile(file)
+		_, dumpErr = dump.Write(content)
+		dump.Close()
+	}
+
+	if dumpErr != nil {
+		t.Fatalf("%s: %s", err, output)
+	}
+
+	t.Fatalf("%s, source dumped to %s: %s", err, dump.Name(), output)
+
+	return nil
+}
+
+// obfuscatedLauncherSeed is obfuscatedLauncher with a fixed seed
+func obfuscatedLauncherSeed(t *testing.T, opts Options) string {
+	t.Helper()
+
+	source := randomSource
+	t.Cleanup(func() { randomSource, reproducible = source, false })
+	keepSecrets(t)
+
+	SetRandomSeed(1)
+
+	return obfuscatedLauncher(t, opts)
+}
+
+/*
+The launchers of the options build, obfuscated with a fixed seed, and
+keep neither an ob name nor the plaintext of a path they open.
+*/
+func TestObfuscatedLauncherBuilds(t *testing.T) {
+	obName := regexp.MustCompile(`\bob[A-Z][a-zA-Z0-9_]*`)
+
+	for _, test := range []struct {
+		name string
+		opts Options
+	}{
+		{name: "default"},
+		{name: "daemonize pidfile", opts: Options{Daemonize: true, Pidfile: "payload.pid"}},
+		{name: "launcher debug", opts: Options{LauncherDebug: true}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			file := obfuscatedLauncherSeed(t, test.opts)
+
+			content, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if name := obName.FindString(string(content)); name != "" {
+				t.Errorf("%s is left in the launcher", name)
+			}
+
+			if strings.Contains(string(content), `"/proc/self/fd/"`) {
+				t.Error("a path is left in plaintext in the launcher")
+			}
+
+			buildLauncher(t, file)
+		})
+	}
+}
+
+/*
+A launcher debug build is marked as such, the production one has none
+of the debug messages compiled in, nor the variable of their file.
+*/
+func TestLauncherDebugStrings(t *testing.T) {
+	content, err := ioutil.ReadFile(launcherTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the constant start of each message, up to its first verb or escape
+	messages := []string{"PAKKERO_LAUNCHER_DEBUG_FILE"}
+
+	for _, match := range regexp.MustCompile(`obDebugf\("([^"%\\]{8,})`).FindAllStringSubmatch(string(content), -1) {
+		messages = append(messages, match[1])
+	}
+
+	if len(messages) < 10 {
+		t.Fatalf("debug messages: %q", messages)
+	}
+
+	debug := buildLauncher(t, obfuscatedLauncherSeed(t, Options{LauncherDebug: true}))
+	if !bytes.Contains(debug, []byte("PAKKERO LAUNCHER DEBUG BUILD")) {
+		t.Error("the debug build has no marker")
+	}
+
+	production := buildLauncher(t, obfuscatedLauncherSeed(t, Options{}))
+
+	for _, message := range messages {
+		if bytes.Contains(production, []byte(message)) {
+			t.Errorf("the production build has %q", message)
+		}
+	}
+}
+
+/*
+The sensitive secrets are decoded in the buffer given, without a string
+or a reallocation holding them: a placeholder decodes its secret, a long
+one is decoded in chunks, and none is left in plaintext.
+*/
+func TestObfuscateSensitive(t *testing.T) {
+	keepSecrets(t)
+
+	long := strings.Repeat("0123456789abcdef", 100)
+	Secrets[`"PINPLACEHOLDER"`] = []string{"pin 1234", GenerateTyposquatName()}
+
+	body, funcs := ObfuscateSensitive(`
+func main() {
+	obBuffer := make([]byte, 0, 2048)
+	obPrint := func(obSecret []byte) {
+		obFmt.Printf("%d %t %.8s\n", len(obSecret),
+			len(obSecret) == 0 || &obSecret[0] == &obBuffer[:1][0], obSecret)
+	}
+
+	obPrint(obSensitive(obBuffer, "PINPLACEHOLDER"))
+	obPrint(obSensitive(obBuffer, "` + long + `"))
+	obFmt.Println(string(obBuffer[:1600]) == obStrings.Repeat("0123456789abcdef", 100))
+	obPrint(obSensitive(nil, ""))
+}
+`)
+
+	for _, plaintext := range []string{"obSensitive(", `"PINPLACEHOLDER"`, `"` + long + `"`, "pin 1234"} {
+		if strings.Contains(body+funcs, plaintext) {
+			t.Errorf("%.30q is left", plaintext)
+		}
+	}
+
+	output := runProgram(t, `package main
+
+import (
+	obFmt "fmt"
+	obOS "os"
+	obStrings "strings"
+)
+
+var obAnchor = uint8(obOS.Getpagesize() / obOS.Getpagesize())
+`+body+funcs)
+
+	expected := "8 true pin 1234\n1600 true 01234567\ntrue\n0 true \n"
+	if output != expected {
+		t.Errorf("decoded secrets: %q, expected %q", output, expected)
+	}
+}
+
+/*
+The struct tags and the cgo preamble keep their strings, they would
+not compile as calls, the other raw strings are obfuscated.
+*/
+func TestObfuscateStringsPreserved(t *testing.T) {
+	keepSecrets(t)
+
+	preserved := []string{
+		"`json:\"name\"`",
+		"`json:\"id,omitempty\" db:\"id\"`",
+		"static const char *greeting = \"hello from c\";",
+		"// not a \"struct {\" with 'tags'\n",
+		"import \"C\"",
+	}
+
+	obfuscated := ObfuscateStrings(`package main
+
+import (
+	obBytes "bytes"
+	obJSON "encoding/json"
+	obFmt "fmt"
+	obOS "os"
+)
+
+/*
+#include <stdlib.h>
+` + preserved[2] + `
+*/
+` + preserved[4] + `
+
+var obAnchor = uint8(obOS.Getpagesize() / obOS.Getpagesize())
+
+type obRecord struct {
+	Name string ` + preserved[0] + `
+	ID   int    ` + preserved[1] + `
+	obNested struct {
+		obValue string ` + "`yaml:\"value\"`" + `
+	}
+}
+
+var obQuery = ` + "`SELECT name FROM users WHERE id = ?`" + `
+
+func main() {
+	` + preserved[3] + `	obOutput, _ := obJSON.Marshal(obRecord{ID: 1})
+	obFmt.Println(obQuery, string(obOutput), obBytes.MinRead)
+}
+`)
+
+	typeCheck(t, obfuscated)
+
+	for _, code := range append(preserved, "`yaml:\"value\"`") {
+		if !strings.Contains(obfuscated, code) {
+			t.Errorf("%s is not preserved", code)
+		}
+	}
+
+	if strings.Contains(obfuscated, "SELECT") {
+		t.Error("the query is left in plaintext")
+	}
+
+	if len(Secrets) != 1 {
+		t.Errorf("obfuscated strings: %q, expected the query only", Secrets)
+	}
+}
+
+func TestImportName(t *testing.T) {
+	for importPath, expected := range map[string]string{
+		"fmt":                          "fmt",
+		"math/rand":                    "rand",
+		"gopkg.in/yaml.v2":             "yaml",
+		"github.com/mattn/go-isatty":   "isatty",
+		"github.com/example/go-mod/v3": "mod",
+		"example.com/v2":               "example",
+	} {
+		if name := importName(importPath); name != expected {
+			t.Errorf("%s: name %s, expected %s", importPath, name, expected)
+		}
+	}
+}
+
+/*
+The imports without an alias get an ob one, in their selectors too,
+but not in those of a local shadowing the package. The blank, dot and
+cgo imports are left as they are.
+*/
+func TestAliasImports(t *testing.T) {
+	input := `package main
+
+import (
+	"fmt"
+	obStrings "strings"
+	. "math"
+	_ "embed"
+	"time"
+)
+
+func obLocal() int {
+	time := struct{ Second int }{7}
+
+	return time.Second
+}
+
+func main() {
+	fmt.Println(obStrings.ToUpper("ok"), int(time.Second/time.Millisecond), obLocal(), Abs(-1))
+}
+`
+
+	aliased, err := AliasImports(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	typeCheck(t, aliased)
+
+	for _, expected := range []string{
+		`obImport0Alias "fmt"`, `obStrings "strings"`, `. "math"`, `_ "embed"`, `obImport4Alias "time"`,
+		"obImport0Alias.Println(", "obImport4Alias.Second/obImport4Alias.Millisecond",
+		"return time.Second",
+	} {
+		if !strings.Contains(aliased, expected) {
+			t.Errorf("%s not found in:\n%s", expected, aliased)
+		}
+	}
+
+	if output := runProgram(t, aliased); output != "OK 1000 7 1\n" {
+		t.Errorf("output %q", output)
+	}
+
+	// a template with every import aliased is left as it is
+	again, err := AliasImports(aliased)
+	if err != nil || again != aliased {
+		t.Errorf("aliased again: %v\n%s", err, again)
+	}
+
+	file := filepath.Join(t.TempDir(), "main.go")
+
+	err = ioutil.WriteFile(file, []byte(input), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imports := ListImportsFromFile(file)
+	if strings.Join(imports, " ") != "fmt strings math embed time" {
+		t.Errorf("imports %q", imports)
+	}
+}
+
+/*
+The obfuscated strings are never folded back by the compiler, their
+bytes are not in an optimized build, because obAnchor is known only
+at run time. A constant anchor would be folded.
+*/
+func TestObfuscatedStringsNotFolded(t *testing.T) {
+	plaintext := "Qz7#kP2!vX9@mW4$rT6^jL8%nB3&hD5*"
+
+	for _, test := range []struct {
+		anchor string
+		folded bool
+	}{
+		{templateDecl(t, "obAnchor"), false},
+		{"const obAnchor = uint8(1)", true},
+	} {
+		keepSecrets(t)
+
+		program := strings.Replace(fmt.Sprintf(fuzzProgram, strconv.Quote(plaintext)),
+			"var obAnchor = uint8(obOS.Getpagesize() / obOS.Getpagesize())", test.anchor+"\n\nvar _ = obOS.Getpagesize", 1)
+
+		file := filepath.Join(t.TempDir(), "main.go")
+
+		err := ioutil.WriteFile(file, []byte(ObfuscateStrings(program)), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		binary := buildLauncher(t, file)
+
+		// the folded bytes are moved as immediates, 8 at most
+		found := false
+		for i := 0; i+8 <= len(plaintext); i++ {
+			found = found || bytes.Contains(binary, []byte(plaintext[i:i+8]))
+		}
+
+		if found != test.folded {
+			t.Errorf("%s: the plaintext is in the binary: %t", test.anchor, found)
+		}
+	}
+}
+
+// the chunk is chosen once per launcher, and a long string is decoded one chunk per function
+func TestGenerateStringFuncChunks(t *testing.T) {
+	keepSecrets(t)
+
+	chunk := stringChunk
+	defer func() { stringChunk = chunk }()
+
+	stringChunk = 0
+	chunks := chunkString(strings.Repeat("x", 2*maxStringChunk))
+
+	if stringChunk < minStringChunk || stringChunk >= maxStringChunk {
+		t.Errorf("chunk of %d bytes, expected [%d, %d)", stringChunk, minStringChunk, maxStringChunk)
+	}
+
+	if len(chunks) < 2 || len(chunks[0]) != int(stringChunk) || len(strings.Join(chunks, "")) != 2*maxStringChunk {
+		t.Errorf("%d chunks of %d bytes", len(chunks), len(chunks[0]))
+	}
+
+	stringChunk = minStringChunk
+
+	long := ""
+	for i := 0; len(long) < 3*minStringChunk+10; i++ {
+		long += strconv.Itoa(i) + " "
+	}
+
+	functions := map[string]int{}
+	for name, text := range map[string]string{"obLong": long, "obShort": "short", "obEmpty": ""} {
+		function := GenerateStringFunc(text, name)
+		functions[name] = strings.Count(function, "func ")
+
+		for _, decoder := range strings.Split(function, "func ")[1:] {
+			if strings.Count(decoder, ",\n") > minStringChunk {
+				t.Errorf("a function decodes more than a chunk:\nfunc %s", decoder)
+			}
+		}
+	}
+
+	if functions["obLong"] != 5 || functions["obShort"] != 1 || functions["obEmpty"] != 1 {
+		t.Errorf("functions %v, expected 4 chunks and a join for the long string", functions)
+	}
+
+	program := ObfuscateStrings(fmt.Sprintf(fuzzProgram, strconv.Quote(long)))
+	typeCheck(t, program)
+
+	if output := runProgram(t, program); output != long+" 512\n" {
+		t.Errorf("decoded %q", output)
+	}
+}
+
+/*
+A custom check is a func() bool of the launcher, declared once or more,
+and called at the check sites; any other declaration is refused with
+its line.
+*/
+func TestCustomChecks(t *testing.T) {
+	declarations := `package main
+
+type obType struct{}
+
+func (obType) obMethod() bool { return false }
+
+func obValid() bool { return false }
+
+func obNamed() (obTampered bool) { return }
+
+func obArgs(obValue int) bool { return obValue > 0 }
+
+func obInt() int { return 0 }
+
+func obTwo() (bool, bool) { return false, false }
+
+func obNone() {}
+`
+
+	checks, err := CustomChecks(declarations + "// OB_CHECK_FUNC obValid\n\t// OB_CHECK_FUNC obNamed\n// OB_CHECK_FUNC obValid\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"if obValid() { obTamper(obCheckCustom) }", "if obNamed() { obTamper(obCheckCustom) }"}
+	if fmt.Sprint(checks) != fmt.Sprint(expected) {
+		t.Errorf("checks %q, expected %q", checks, expected)
+	}
+
+	line := strings.Count(declarations, "\n") + 1
+
+	for name, message := range map[string]string{
+		"obMissing": "is not a function of the launcher",
+		"obMethod":  "is not a function of the launcher",
+		"obArgs":    "is not a func() bool",
+		"obInt":     "is not a func() bool",
+		"obTwo":     "is not a func() bool",
+		"obNone":    "is not a func() bool",
+	} {
+		_, err = CustomChecks(declarations + "// OB_CHECK_FUNC " + name + "\n")
+		if err == nil || !strings.Contains(err.Error(), message) ||
+			!strings.HasPrefix(err.Error(), fmt.Sprintf("line %d: ", line)) {
+			t.Errorf("%s: error %v, expected %q at line %d", name, err, message, line)
+		}
+	}
+
+	// the declaration is removed, the check is called at a site
+	sites := checkSites
+	defer func() { checkSites = sites }()
+
+	output, err := GenerateRandomAntiDebug(declarations+"// OB_CHECK_FUNC obValid\n\nfunc main() {\n\t// OB_CHECK\n}\n", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(output, "OB_CHECK") || !strings.Contains(output, expected[0]) {
+		t.Errorf("the custom check is not placed:\n%s", output)
+	}
+
+	_, err = GenerateRandomAntiDebug(declarations, false)
+	if err == nil || !strings.Contains(err.Error(), "no // OB_CHECK marker") {
+		t.Errorf("a launcher without checks: %v", err)
+	}
+}
+
+/*
+Every check is at a point at least once, every point runs a check at
+least, never the same twice, and no more checks than the limit.
+*/
+func FuzzDistributeChecks(f *testing.F) {
+	f.Add(int64(0), uint8(1), uint8(1))
+	f.Add(int64(1), uint8(8), uint8(3))
+	f.Add(int64(2), uint8(3), uint8(8))
+	f.Add(int64(3), uint8(40), uint8(2))
+
+	f.Fuzz(func(t *testing.T, seed int64, count uint8, sites uint8) {
+		source := randomSource
+		t.Cleanup(func() { randomSource, reproducible = source, false })
+
+		SetRandomSeed(seed)
+
+		checks := []string{}
+		for i := 0; i < int(count)%64+1; i++ {
+			checks = append(checks, fmt.Sprintf("obCheck%d()", i))
+		}
+
+		distribution := distributeChecks(checks, int(sites)%32)
+		if len(distribution) != int(sites)%32 {
+			t.Fatalf("%d points, expected %d", len(distribution), int(sites)%32)
+		}
+
+		if len(distribution) == 0 {
+			return
+		}
+
+		limit := MaxChecksPerSite
+		if needed := (len(checks) + len(distribution) - 1) / len(distribution); needed > limit {
+			limit = needed
+		}
+
+		placed := map[string]bool{}
+
+		for site, points := range distribution {
+			if len(points) == 0 || len(points) > limit || len(Unique(append([]string{}, points...))) != len(points) {
+				t.Errorf("point %d runs %q, limit %d", site, points, limit)
+			}
+
+			for _, check := range points {
+				placed[check] = true
+			}
+		}
+
+		if len(placed) != len(checks) {
+			t.Errorf("%d checks placed, expected %d: %q", len(placed), len(checks), distribution)
+		}
+	})
+}
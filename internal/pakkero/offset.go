@@ -0,0 +1,215 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Offset library
+*/
+package pakkero
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+/*
+MinKeyMaterial is the least garbage between the launcher and the
+offset, that derives the key with it: an automatic offset leaves at
+least this much, a smaller one is warned about.
+*/
+const MinKeyMaterial = 64 * 1024
+
+// DefaultOffsetPadding is the garbage AutoOffset leaves, in percent of the launcher size
+const DefaultOffsetPadding = 20
+
+/*
+OffsetLayout describes what is before the payload: the launcher size,
+the garbage deriving the key with it, the decoy, the libraries and the
+prologue, and if the offset was chosen by AutoOffset. It is written in
+the manifest, the offset itself, their sum, is not.
+*/
+type OffsetLayout struct {
+	LauncherSize int64 `json:"launcher_size"`
+	KeyMaterial  int64 `json:"key_material"`
+	Blobs        int64 `json:"blobs"`
+	Auto         bool  `json:"auto"`
+}
+
+// layout of the output, written in the manifest, empty until the offset is verified
+var outputLayout OffsetLayout
+
+/*
+blobsSize returns the space taken right before the offset by the
+decoy, the bundled libraries and the prologue, see RegisterDecoy.
+*/
+func blobsSize(opts Options) (int64, error) {
+	size := int64(0)
+
+	if opts.Decoy != "" {
+		decoy, err := encodeDecoy(opts.Decoy)
+		if err != nil {
+			return 0, err
+		}
+
+		size += int64(len(decoy) + gcmOverhead)
+	}
+
+	if len(opts.BundleLibs) > 0 {
+		libs, err := archiveBundledLibs(opts.BundleLibs)
+		if err != nil {
+			return 0, err
+		}
+
+		size += int64(len(libs) + gcmOverhead)
+	}
+
+	if opts.Prologue != "" {
+		prologue, err := encodePrologue(opts.Prologue)
+		if err != nil {
+			return 0, err
+		}
+
+		size += int64(len(prologue) + gcmOverhead)
+	}
+
+	return size, nil
+}
+
+/*
+AutoOffset returns the offset for a launcher of launcherSize bytes: the
+launcher, the marker, the padding, a percentage of the launcher but at
+least MinKeyMaterial, and the decoy, the libraries and the prologue,
+blobs bytes.
+*/
+func AutoOffset(launcherSize int64, padding int64, blobs int64) int64 {
+	garbage := launcherSize * padding / 100
+	if garbage < MinKeyMaterial {
+		garbage = MinKeyMaterial
+	}
+
+	return launcherSize + markerSize + garbage + blobs
+}
+
+/*
+probeLauncher is a launcher built with the features of some options in
+a workspace of its own, to measure or calibrate: its source file, the
+binary and the identity it was built with.
+*/
+type probeLauncher struct {
+	dir      string
+	file     string
+	output   string
+	identity Identity
+}
+
+/*
+buildProbe builds a probeLauncher, not stripped nor compressed, whose
+workspace the caller removes with removeTracked. The secrets not
+registered yet are built as their placeholders, of about their size;
+the hooks do not run.
+*/
+func buildProbe(ctx context.Context, opts Options) (probeLauncher, error) {
+	// the probe must not register anything in the pack
+	secrets := map[string][]string{}
+	for key, value := range Secrets {
+		secrets[key] = value
+	}
+
+	defer func() {
+		Secrets = secrets
+	}()
+
+	stub, _ := base64.StdEncoding.DecodeString(LauncherStub)
+
+	env := WorkspaceEnviron(opts.Arch)
+	features := opts.buildFeatures()
+	probe := probeLauncher{identity: NewIdentity(opts.IdentitySeed)}
+
+	minor := GoMinorVersion()
+	if minor < 0 {
+		minor = minGoMinorVersion
+	}
+
+	dir, file, err := probe.identity.CreateWorkspace(minor)
+	if dir != "" {
+		Track(dir)
+	}
+
+	probe.dir, probe.file = dir, file
+
+	if err == nil {
+		err = ioutil.WriteFile(file, []byte(StripFeatures(string(stub), features)), 0644)
+	}
+
+	if err == nil {
+		err = WriteLauncherAsm(file, features)
+	}
+
+	if err == nil {
+		err = ObfuscateLauncher(file, opts.Guards, opts.AllowNoChecks)
+	}
+
+	if err != nil {
+		return probe, err
+	}
+
+	probe.output = filepath.Join(dir, ".launcher")
+
+	builder, flags, err := launcherBuildCommand(opts, features, probe.output)
+	if err != nil {
+		return probe, err
+	}
+
+	buildCtx, cancel := opts.stageContext(ctx, StageBuild)
+	defer cancel()
+
+	err = execTool(buildCtx, dir, builder, flags, env)
+	if err != nil {
+		return probe, opts.timeoutError(ctx, buildCtx, StageBuild, err)
+	}
+
+	return probe, nil
+}
+
+/*
+measureLauncher builds a launcher with the features of the options, see
+buildProbe, and returns its size once stripped and compressed like the
+one of the pack; the changes of the hooks are not measured.
+*/
+func measureLauncher(ctx context.Context, opts Options) (int64, error) {
+	probe, err := buildProbe(ctx, opts)
+	if probe.dir != "" {
+		defer removeTracked(probe.dir)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	output := probe.output
+
+	stripCtx, cancel := opts.stageContext(ctx, StageStrip)
+	defer cancel()
+
+	err = StripFile(stripCtx, output, probe.file, probe.identity.Module, !opts.LauncherDebug, opts.UseGarble)
+	if err != nil {
+		return 0, opts.timeoutError(ctx, stripCtx, StageStrip, err)
+	}
+
+	if opts.Compress {
+		compressCtx, cancel := opts.stageContext(ctx, StageCompress)
+		defer cancel()
+
+		err = compressFile(output, upxCodec{ctx: compressCtx})
+		if err != nil {
+			return 0, opts.timeoutError(ctx, compressCtx, StageCompress, err)
+		}
+	}
+
+	stat, err := os.Stat(output)
+	if err != nil {
+		return 0, err
+	}
+
+	return stat.Size(), nil
+}
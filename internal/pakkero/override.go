@@ -0,0 +1,202 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Check override library
+*/
+package pakkero
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const overridePubKeyPlaceholder = `"OVERRIDEPUBKEY"`
+const overrideFilePlaceholder = `"OVERRIDEFILE"`
+
+// OverrideMagic starts every override token
+const OverrideMagic = "PKO1"
+
+// MaxOverrideTTL is the longest an override token can be valid, the launcher ignores longer ones
+const MaxOverrideTTL = 7 * 24 * time.Hour
+
+// how much an override token issued in the future is tolerated, for the clocks skew
+const overrideClockSkew = 5 * time.Minute
+
+/*
+id of the public key of the override tokens, written in the manifest,
+empty until RegisterOverride.
+*/
+var overrideKey string
+
+/*
+OverrideChecks are the checks an override token can relax, by the name
+given to pakkero override issue, as their AttestChecks: the ones about
+the host the launcher runs on. The ones protecting the payload itself,
+like the decryption, the guards or the license, are never relaxed.
+*/
+var OverrideChecks = map[string][]string{
+	"env":        {"env-args", "env-parent", "env", "ld-preload"},
+	"parent":     {"parent-cmdline", "parent-tracer", "parent-stat"},
+	"hostname":   {"hostname"},
+	"dependency": {"dependency"},
+}
+
+/*
+Override holds the claims of an override token, laid out as:
+
+	"PKO1" | issued, unix time, 8 bytes big endian |
+	expiry, unix time, 8 bytes big endian |
+	relaxed checks, a bit per AttestChecks index, 4 bytes big endian |
+	ed25519 signature of everything before, 64 bytes
+*/
+type Override struct {
+	Issued  time.Time
+	Expires time.Time
+	Checks  uint32
+}
+
+// OverrideSize is the size of an override token
+const OverrideSize = len(OverrideMagic) + 8 + 8 + 4 + ed25519.SignatureSize
+
+// claims returns the signed part of the override token
+func (o Override) claims() []byte {
+	var claims bytes.Buffer
+
+	claims.WriteString(OverrideMagic)
+	binary.Write(&claims, binary.BigEndian, o.Issued.Unix())
+	binary.Write(&claims, binary.BigEndian, o.Expires.Unix())
+	binary.Write(&claims, binary.BigEndian, o.Checks)
+
+	return claims.Bytes()
+}
+
+// OverrideCheckNames returns the names of the checks an override relaxes, sorted
+func OverrideCheckNames() []string {
+	names := []string{}
+	for name := range OverrideChecks {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+/*
+ParseOverrideChecks will parse the checks to relax, each item can be a
+comma separated list of OverrideChecks names, into the bits of their
+AttestChecks indexes.
+*/
+func ParseOverrideChecks(inputs []string) (uint32, error) {
+	mask := uint32(0)
+
+	for _, input := range inputs {
+		for _, name := range strings.Split(input, ",") {
+			checks, ok := OverrideChecks[strings.TrimSpace(name)]
+			if !ok {
+				return 0, fmt.Errorf("unknown check %q, checks are: %s", name,
+					strings.Join(OverrideCheckNames(), ", "))
+			}
+
+			for _, check := range checks {
+				for index, attestCheck := range AttestChecks {
+					if attestCheck == check {
+						mask |= 1 << uint(index)
+					}
+				}
+			}
+		}
+	}
+
+	if mask == 0 {
+		return 0, errors.New("no check to relax")
+	}
+
+	return mask, nil
+}
+
+// RelaxedChecks returns the AttestChecks names of the bits of the mask
+func RelaxedChecks(mask uint32) []string {
+	names := []string{}
+
+	for index, check := range AttestChecks {
+		if mask&(1<<uint(index)) != 0 {
+			names = append(names, check)
+		}
+	}
+
+	return names
+}
+
+// IssueOverride returns the override token of the claims, signed with key
+func IssueOverride(key ed25519.PrivateKey, override Override) ([]byte, error) {
+	ttl := override.Expires.Sub(override.Issued)
+	if ttl <= 0 || ttl > MaxOverrideTTL {
+		return nil, fmt.Errorf("the override must be valid for more than 0 and at most %s", MaxOverrideTTL)
+	}
+
+	claims := override.claims()
+
+	return append(claims, ed25519.Sign(key, claims)...), nil
+}
+
+/*
+VerifyOverride will check the signature and the validity of an override
+token at the given time and return its claims. This is the same logic
+compiled in the launcher, that ignores a token failing it.
+*/
+func VerifyOverride(content []byte, key ed25519.PublicKey, now time.Time) (Override, error) {
+	if len(content) != OverrideSize || string(content[:len(OverrideMagic)]) != OverrideMagic {
+		return Override{}, errors.New("not an override token")
+	}
+
+	claims := content[:OverrideSize-ed25519.SignatureSize]
+	if !ed25519.Verify(key, claims, content[len(claims):]) {
+		return Override{}, errors.New("invalid override signature")
+	}
+
+	override := Override{
+		Issued:  time.Unix(int64(binary.BigEndian.Uint64(claims[len(OverrideMagic):])), 0).UTC(),
+		Expires: time.Unix(int64(binary.BigEndian.Uint64(claims[len(OverrideMagic)+8:])), 0).UTC(),
+		Checks:  binary.BigEndian.Uint32(claims[len(OverrideMagic)+16:]),
+	}
+
+	switch {
+	case override.Expires.Sub(override.Issued) > MaxOverrideTTL:
+		return override, fmt.Errorf("the override is valid for more than %s", MaxOverrideTTL)
+	case now.Add(overrideClockSkew).Before(override.Issued):
+		return override, fmt.Errorf("the override is issued in the future, at %s", override.Issued)
+	case !now.Before(override.Expires):
+		return override, fmt.Errorf("the override expired at %s", override.Expires)
+	}
+
+	return override, nil
+}
+
+/*
+RegisterOverride will read the public key checking the override tokens
+and add it to the secrets, with the path of the token on the target, so
+that they will be embedded obfuscated in the launcher.
+Returns the id of the key.
+*/
+func RegisterOverride(pubKey string, overrideFile string) (string, error) {
+	if !IsTargetAbs(overrideFile) {
+		return "", errors.New("the override file needs an absolute path")
+	}
+
+	key, err := ReadLicensePublicKey(pubKey)
+	if err != nil {
+		return "", err
+	}
+
+	overrideKey = LicenseKeyID(key)
+	Secrets[overridePubKeyPlaceholder] = []string{string(key), GenerateTyposquatName()}
+	Secrets[overrideFilePlaceholder] = []string{overrideFile, GenerateTyposquatName()}
+
+	return overrideKey, nil
+}
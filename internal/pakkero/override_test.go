@@ -0,0 +1,261 @@
+package pakkero
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseOverrideChecks(t *testing.T) {
+	mask, err := ParseOverrideChecks([]string{"hostname", "parent, dependency"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if checks := RelaxedChecks(mask); !reflect.DeepEqual(checks, []string{"parent-cmdline", "parent-tracer",
+		"parent-stat", "dependency", "hostname"}) {
+		t.Errorf("relaxed checks: %v", checks)
+	}
+
+	// the checks protecting the payload are never relaxed
+	all, err := ParseOverrideChecks(OverrideCheckNames())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, check := range []string{"success", "license", "anti-dump-seals", "decrypt", "guard", "custom"} {
+		if Contains(RelaxedChecks(all), check) {
+			t.Errorf("%s can be relaxed", check)
+		}
+	}
+
+	for _, input := range [][]string{{"hostname,license"}, {""}, {}} {
+		if _, err := ParseOverrideChecks(input); err == nil {
+			t.Errorf("%q is accepted", input)
+		}
+	}
+}
+
+func TestVerifyOverride(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, other, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1700000000, 0).UTC()
+	claims := Override{Issued: now.Add(-time.Hour), Expires: now.Add(time.Hour), Checks: 1 << 14}
+
+	token, err := IssueOverride(private, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	override, err := VerifyOverride(token, public, now)
+	if err != nil || override != claims {
+		t.Errorf("override: %+v, %v", override, err)
+	}
+
+	for _, expires := range []time.Time{claims.Issued, claims.Issued.Add(MaxOverrideTTL + time.Second)} {
+		if _, err := IssueOverride(private, Override{Issued: claims.Issued, Expires: expires}); err == nil {
+			t.Errorf("an override valid until %s is issued", expires)
+		}
+	}
+
+	tampered := append([]byte{}, token...)
+	tampered[len(OverrideMagic)+16+3] |= 1
+
+	// signed as IssueOverride refuses to
+	tooLong := Override{Issued: now, Expires: now.Add(MaxOverrideTTL + time.Second)}.claims()
+	tooLong = append(tooLong, ed25519.Sign(private, tooLong)...)
+
+	for _, test := range []struct {
+		name  string
+		token []byte
+		now   time.Time
+	}{
+		{"another key", mustIssueOverride(t, other, claims), now},
+		{"tampered", tampered, now},
+		{"truncated", token[:OverrideSize-1], now},
+		{"valid for too long", tooLong, now},
+		{"expired", token, claims.Expires},
+		{"issued in the future", token, claims.Issued.Add(-overrideClockSkew - time.Second)},
+	} {
+		if _, err := VerifyOverride(test.token, public, test.now); err == nil {
+			t.Errorf("%s: accepted", test.name)
+		}
+	}
+
+	// the clocks may be skewed
+	if _, err := VerifyOverride(token, public, claims.Issued.Add(-overrideClockSkew)); err != nil {
+		t.Errorf("issued within the clock skew: %v", err)
+	}
+}
+
+// mustIssueOverride returns the override token of the claims signed with key
+func mustIssueOverride(t *testing.T, key ed25519.PrivateKey, override Override) []byte {
+	t.Helper()
+
+	token, err := IssueOverride(key, override)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func TestRegisterOverride(t *testing.T) {
+	keepSecrets(t)
+
+	id := overrideKey
+	defer func() { overrideKey = id }()
+
+	public, _ := writeLicenseKeys(t, t.TempDir(), "override")
+
+	if _, err := RegisterOverride(public, "relative/token"); err == nil {
+		t.Error("a relative override file")
+	}
+
+	keyID, err := RegisterOverride(public, "/etc/app.override")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ReadLicensePublicKey(public)
+	if err != nil || keyID != LicenseKeyID(key) || overrideKey != keyID {
+		t.Errorf("key id: %s %s, %v", keyID, overrideKey, err)
+	}
+
+	if Secrets[overridePubKeyPlaceholder][0] != string(key) ||
+		Secrets[overrideFilePlaceholder][0] != "/etc/app.override" {
+		t.Errorf("secrets: %q %q", Secrets[overridePubKeyPlaceholder], Secrets[overrideFilePlaceholder])
+	}
+}
+
+// the launcher relaxes the checks of a valid token only, and only the relaxable ones
+func TestLauncherOverride(t *testing.T) {
+	if _, ok := launcherArchs[runtime.GOARCH]; !ok {
+		t.Skipf("the launcher has no syscall table for %s", runtime.GOARCH)
+	}
+
+	keepSecrets(t)
+
+	id := overrideKey
+	defer func() { overrideKey = id }()
+
+	dir := t.TempDir()
+	overrideFile := filepath.Join(dir, "token")
+	public, private := writeLicenseKeys(t, dir, "override")
+	_, other := writeLicenseKeys(t, dir, "other")
+
+	_, err := RegisterOverride(public, overrideFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ReadLicensePrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherKey, err := ReadLicensePrivateKey(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hostname, err := ParseOverrideChecks([]string{"hostname"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relaxable, err := ParseOverrideChecks(OverrideCheckNames())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	valid := Override{Issued: now, Expires: now.Add(time.Hour), Checks: hostname}
+	expired := Override{Issued: now.Add(-2 * time.Hour), Expires: now.Add(-time.Hour), Checks: hostname}
+	tooLong := Override{Issued: now, Expires: now.Add(MaxOverrideTTL + time.Hour), Checks: hostname}.claims()
+	tampered := mustIssueOverride(t, key, valid)
+	tampered[len(OverrideMagic)+16] = 0xff
+
+	tokens := []string{
+		strconv.Quote(string(mustIssueOverride(t, key, valid))),
+		strconv.Quote(string(mustIssueOverride(t, key, Override{Issued: now, Expires: now.Add(time.Hour),
+			Checks: 0xffffffff}))),
+		strconv.Quote(string(mustIssueOverride(t, key, expired))),
+		strconv.Quote(string(mustIssueOverride(t, otherKey, valid))),
+		strconv.Quote(string(tampered)),
+		strconv.Quote(string(append(tooLong, ed25519.Sign(key, tooLong)...))),
+		strconv.Quote(""),
+	}
+	expected := fmt.Sprintf("%d\n%d\n0\n0\n0\n0\n0\n", hostname, relaxable)
+
+	decls := []string{}
+	for _, name := range []string{"obLoadOverride", "obRelaxableChecks", "obOverrideMaxTTL", "obRelaxedChecks",
+		"obCheckSigTrap",
+		"obSensitive", "obSensitiveOpen", "obWithPath", "obWipe", "obReadAll"} {
+		decls = append(decls, templateDecl(t, name))
+	}
+
+	program := StripFeatures(`package main
+
+import (
+	obBinary "encoding/binary"
+	obEd25519 "crypto/ed25519"
+	"fmt"
+	obIO "io"
+	obUtilio "io/ioutil"
+	obOS "os"
+	obExec "os/exec"
+	obSyscall "syscall"
+	obTime "time"
+	obUnsafe "unsafe"
+)
+
+`+strings.Join(decls, "\n\n")+`
+
+func main() {
+	if obOS.Getenv("OVERRIDE_CHILD") != "" {
+		obLoadOverride()
+		fmt.Println(obRelaxedChecks)
+
+		return
+	}
+
+	for _, obToken := range []string{`+strings.Join(tokens, ", ")+`} {
+		obOS.Remove(`+strconv.Quote(overrideFile)+`)
+
+		if obToken != "" {
+			obUtilio.WriteFile(`+strconv.Quote(overrideFile)+`, []byte(obToken), 0600)
+		}
+
+		obCommand := obExec.Command("/proc/self/exe")
+		obCommand.Env = append(obOS.Environ(), "OVERRIDE_CHILD=1")
+		obCommand.Stdout = obOS.Stdout
+		obCommand.Run()
+	}
+}
+`, archFeatures(runtime.GOARCH))
+
+	// the key and the path are resolved by the string obfuscation in a pack
+	for _, placeholder := range []string{overridePubKeyPlaceholder, overrideFilePlaceholder} {
+		program = strings.ReplaceAll(program, placeholder, strconv.Quote(Secrets[placeholder][0]))
+	}
+
+	output := runProgram(t, program)
+	if output != expected {
+		t.Errorf("relaxed checks:\n%s\nexpected:\n%s", output, expected)
+	}
+}
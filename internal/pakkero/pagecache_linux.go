@@ -0,0 +1,44 @@
+//go:build linux && (amd64 || arm64 || riscv64)
+// +build linux
+// +build amd64 arm64 riscv64
+
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Linux page cache library
+*/
+package pakkero
+
+import (
+	"os"
+	"syscall"
+)
+
+// posix_fadvise advice dropping the clean pages of a file
+const fadvDontNeed = 4
+
+/*
+evictPageCache will drop the pages of a file from the page cache, like
+writing to /proc/sys/vm/drop_caches does for every file, with no
+privilege: its next read comes from its device. A tmpfs keeps its
+pages, its reads are never cold.
+*/
+func evictPageCache(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// the dirty pages are not dropped, write them first
+	err = file.Sync()
+	if err != nil {
+		return err
+	}
+
+	_, _, errno := syscall.Syscall6(syscall.SYS_FADVISE64, file.Fd(), 0, 0, fadvDontNeed, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
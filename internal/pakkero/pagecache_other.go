@@ -0,0 +1,20 @@
+//go:build !linux || !(amd64 || arm64 || riscv64)
+// +build !linux !amd64,!arm64,!riscv64
+
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Page cache library of the other hosts
+*/
+package pakkero
+
+import (
+	"errors"
+)
+
+/*
+evictPageCache will drop the pages of a file from the page cache: only
+the 64 bit linux hosts can, the cold start benchmarks need it.
+*/
+func evictPageCache(path string) error {
+	return errors.New("the page cache cannot be evicted on this host")
+}
@@ -4,316 +4,1847 @@ Package pakkero will pack, compress and encrypt any type of executable.
 package pakkero
 
 import (
+	"context"
 	"encoding/base64"
-	"encoding/binary"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"math/rand"
 	"os"
-	"os/signal"
-	"syscall"
+	"path/filepath"
+	"strings"
 	"time"
+
+	"github.com/89luca89/pakkero/artifact"
 )
 
 const offsetPlaceholder = `"9999999"`
-const depNamePlaceholder = `"DEPNAME1"`
-const depSizePlaceholder = `"DEPSIZE2"`
-const depBFDPlaceholder = "[]float64{1, 2, 3, 4}"
+const execRetriesPlaceholder = `"EXECRETRIES"`
+const execBackoffPlaceholder = `"EXECBACKOFF"`
+const pinnedProcsPlaceholder = `"PINNEDPROCS"`
 
-var launcherFile = os.TempDir() + "/launcher.go"
+// highest GOMAXPROCS the launcher can be pinned to, see Options.PinProcs
+const maxPinnedProcs = 4
 
-func cleanup() {
-	fmt.Printf(ErrorColor, "\t\t[ ERR ]\n")
-	fmt.Print(" → Cleaning up...")
+// launcher workspace and source, created for each build
+var (
+	launcherDir  string
+	launcherFile string
+)
 
-	// remove unused file
-	ExecCommand("rm", []string{"-f", launcherFile})
-	fmt.Printf(SuccessColor, "\t\t\t[ OK ]\n")
+// Options holds all the parameters of a packing
+type Options struct {
+	// file to pack
+	InFile string
+	// output file, defaults to InFile + .enc
+	OutFile string
+	// the output replaces InFile, that is read from a copy, see SameFile
+	InPlace bool
+	// offset where to start the payload
+	Offset int64
+	// measure the launcher and choose the offset, see AutoOffset
+	AutoOffset bool
+	// garbage after the launcher chosen by AutoOffset, in percent of its size
+	OffsetPadding int64
+	// dependencies to register as fingerprint
+	Dependencies []Dependency
+	// pass the verified dependencies to the payload as descriptors, see BoundDepEnvPrefix
+	BindDeps bool
+	// file to run instead of the payload when a dependency
+	// with the degrade policy does not match
+	Decoy string
+	// libraries extracted for the payload, see RegisterBundledLibs
+	BundleLibs []string
+	// file run to completion before the payload, that runs only if it
+	// exits 0, see RegisterPrologue
+	Prologue string
+	// compress the launcher using UPX, see upxCodec
+	Compress bool
+	// codec of the payload, the decoy, the libraries and the
+	// prologue, one of Codecs, DefaultCodec if empty
+	Codec string
+	// how the launcher reads the blobs, see IOProfiles, DefaultIOProfile
+	// if empty
+	IOProfile string
+	// copy the permission bits of InFile instead of using 0755
+	PreserveMode bool
+	// seal the memfd, exclude buffers from core dumps and
+	// make the launcher not dumpable
+	AntiDump bool
+	// reopen the memfd read-only and close every other
+	// reference to it once the payload is running
+	AntiDumpReopen bool
+	// run only after this UTC date (2006-01-02T15:04Z)
+	ArmAfter string
+	// run only inside this UTC window (HH:MM-HH:MM)
+	RunWindow string
+	// run only if this file exists
+	TriggerFile string
+	// sleep and retry instead of exiting when not armed
+	WaitForArming bool
+	// ed25519 public keys checking the license, any of them signs
+	// a valid one, see RegisterLicense
+	LicensePubKeys []string
+	// absolute path of the license file on the target
+	LicenseFile string
+	// calibration file of the scrubbing, empty for none, see
+	// LoadCalibration
+	Calibration string
+	// ed25519 public key of the override tokens relaxing the checks,
+	// and their absolute path on the target, see RegisterOverride
+	OverridePubKey string
+	OverrideFile   string
+	// ed25519 private key signing the launch token given to the
+	// payload, see RegisterLaunchToken
+	LaunchTokenKey string
+	// ed25519 private key signing the output, see RegisterSigningKey
+	SignKey string
+	// absolute path of the registration record on the target, and the
+	// RSA public key encrypting it, see Registration
+	RegisterHost       string
+	RegisterHostPubKey string
+	// what to do on a host the record is not of, RegistrationEnforce
+	// or RegistrationWarn
+	RegisterHostPolicy string
+	// a missing record adopts the host instead of being a mismatch
+	PinFirstHost bool
+	// hostname glob patterns the launcher runs on, and the ones it
+	// never runs on, see ParseHostPatterns
+	AllowHostnames []string
+	DenyHostnames  []string
+	// where the launcher writes its attestation records, see Attest
+	Attest Attest
+	// write a record when all the checks pass too
+	AttestSuccess bool
+	// build a launcher running its host checks, without the payload,
+	// when a random variable is set, see RegisterHealthCheck
+	HealthCheck bool
+	// build a launcher logging each stage, never ship it
+	LauncherDebug bool
+	// go on packing even if go vet reports findings on the launcher
+	AllowVet bool
+	// fail if building the launcher would need the network
+	Offline bool
+	// build the launcher with garble instead of go
+	UseGarble bool
+	// integrity guards checking the obfuscated strings, see InjectGuards
+	Guards int
+	// pack a launcher with no check point, that runs no anti-debug check
+	AllowNoChecks bool
+	// XOR the encrypted payload with a keystream keyed by ranges of the
+	// output before it, see RegisterWhitening
+	Whiten bool
+	// layout of the payload, LayoutContiguous after the offset or
+	// LayoutScatter in fragments before it too, see PlanScatter
+	Layout string
+	// confine the launcher to the syscalls it makes, and the payload
+	// to them and to SeccompAllow, amd64 and arm64 only, see RegisterSeccomp
+	SeccompSelf bool
+	// syscalls the payload makes besides the ones of the launcher
+	SeccompAllow []string
+	// do not scan the output for the secrets left in plaintext, see ScanLeaks
+	SkipLeakScan bool
+	// pin the GOMAXPROCS of the launcher to a random small value,
+	// so that its thread count varies per build
+	PinProcs bool
+	// how the launcher runs the payload, ExecModeMemfd or ExecModeUserland
+	ExecMode string
+	// absolute path of the file on the target receiving the payload
+	// stdout and stderr encrypted, see RegisterCapture
+	CaptureOutput string
+	// copy the captured output to the launcher stdout and stderr too
+	CaptureTee bool
+	// arguments given to the payload before the runtime ones
+	PayloadArgs []string
+	// arguments given to the payload outside of its argv
+	SecretArgs []string
+	// which inherited variables reach the payload
+	EnvPolicy EnvPolicy
+	// NAME=value variables always given to the payload
+	PayloadEnv []string
+	// detach the payload in a new session and exit once it runs
+	Daemonize bool
+	// file receiving the daemonized payload output, /dev/null if empty
+	LogPath string
+	// file where to write the daemonized payload pid
+	Pidfile string
+	// reap every child and exit with the payload status, for
+	// container entrypoints
+	Init bool
+	// SIGTERM the payload once it runs for this long, then SIGKILL it
+	// after PayloadKillAfter, the launcher exits with 124
+	PayloadTimeout   time.Duration
+	PayloadKillAfter time.Duration
+	// payload exit codes the launcher exits 0 with, see obSuccessExit
+	SuccessCodes []int
+	// retries of the transient failures running the payload
+	ExecRetries int
+	// wait before the first retry, doubled at each one
+	ExecBackoff time.Duration
+	// resource limits of the payload
+	Rlimits []Rlimit
+	// what to do with limits above the launcher hard limit,
+	// RlimitFail or RlimitClamp
+	RlimitPolicy string
+	// nice value, I/O priority and CPU affinity of the payload
+	Scheduling Scheduling
+	// architecture of the launcher, see SupportedArchs
+	Arch string
+	// platform running the launcher, PlatformLinux or PlatformAndroid
+	Platform string
+	// file where to write the manifest of the output, see Manifest
+	Manifest string
+	// file where to write the error of a failed pack as json, see PackError
+	ErrorJSON string
+	// file where to append the record of the pack, succeeded or failed, see AuditRecord
+	AuditLog string
+	// file where to write the report of the obfuscation, see WriteExplain
+	Explain string
+	// save the diff of the obfuscation too, see WriteExplainDiff
+	ExplainDiff bool
+	// show the secrets plaintext in the report and in the diff
+	ExplainUnsafe bool
+	// seed of the launcher identity, random if 0
+	IdentitySeed int64
+	// IDs of the PolicyRules not to check, see CheckPolicy
+	PolicyAllow []string
+	// fail on any fired PolicyRules, not only on the fatal ones
+	PolicyStrict bool
+	// carry the offset in the marker, so that Repack can replace the payload
+	Repackable bool
+	// seal the offset after the marker with a key printed once, so that
+	// Extract can recover the payload
+	Recoverable bool
+	// pack a payload that is already a pakkero output
+	AllowRepack bool
+	// garble the module information of a go payload, see ScrubBuildInfo
+	ScrubPayloadBuildInfo bool
+	// make every random choice follow IdentitySeed, see SetRandomSeed
+	Reproducible bool
+	// time of the output and of the manifest, the current one if zero
+	SourceDate time.Time
+	// timeout of each stage running external tools, see Stages
+	StageTimeouts map[string]time.Duration
+	// called at fixed points of the packing, see Hooks
+	Hooks Hooks `json:"-"`
+	// told how far the long stages are, nil to skip it
+	Progress Progress `json:"-"`
 }
 
-func trap() {
-	// Prepare to intercept SIGTERM
-	c := make(chan os.Signal)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+// armingEnabled returns true if at least an arming condition is set
+func (opts Options) armingEnabled() bool {
+	return opts.ArmAfter != "" || opts.RunWindow != "" || opts.TriggerFile != ""
+}
 
-	go func() {
-		<-c
-		cleanup()
-		os.Exit(ERR)
-	}()
+/*
+launcherFeatures returns the optional launcher features
+enabled by the options
+*/
+func (opts Options) launcherFeatures() map[string]bool {
+	features := map[string]bool{
+		"antidump":       opts.AntiDump || opts.AntiDumpReopen,
+		"antidumpreopen": opts.AntiDumpReopen,
+		"arming":         opts.armingEnabled(),
+		"armafter":       opts.ArmAfter != "",
+		"runwindow":      opts.RunWindow != "",
+		"triggerfile":    opts.TriggerFile != "",
+		"waitforarming":  opts.WaitForArming,
+		"license":        len(opts.LicensePubKeys) > 0,
+		"launchtoken":    opts.LaunchTokenKey != "",
+		"signed":         opts.SignKey != "",
+		"override":       opts.OverridePubKey != "",
+		"registration":   opts.RegisterHost != "",
+		"registerwarn":   opts.RegisterHost != "" && opts.RegisterHostPolicy == RegistrationWarn,
+		"pinfirsthost":   opts.RegisterHost != "" && opts.PinFirstHost,
+		"hostlist":       len(opts.AllowHostnames) > 0 || len(opts.DenyHostnames) > 0,
+		"hostallow":      len(opts.AllowHostnames) > 0,
+		"hostdeny":       len(opts.DenyHostnames) > 0,
+		"whiten":         opts.Whiten,
+		"scatter":        opts.Layout == LayoutScatter,
+		"seccomp":        opts.SeccompSelf && Contains(SeccompArchs, opts.Arch),
+		"pinprocs":       opts.PinProcs,
+		"userland":       opts.ExecMode == ExecModeUserland,
+		"execdiag":       opts.ExecMode != ExecModeUserland,
+		"capture":        opts.CaptureOutput != "",
+		"capturetee":     opts.CaptureOutput != "" && opts.CaptureTee,
+		"attest":         opts.Attest.Enabled(),
+		"attestfile":     opts.Attest.Mode == AttestFile,
+		"attestsocket":   opts.Attest.Mode == AttestSocket,
+		"attestsuccess":  opts.Attest.Enabled() && opts.AttestSuccess,
+		"health":         opts.HealthCheck,
+		"depwarn":        opts.hasDepPolicy(DepPolicyWarn),
+		"decoy":          opts.Decoy != "",
+		"binddeps":       opts.BindDeps,
+		"prologue":       opts.Prologue != "",
+		"bundlelibs":     len(opts.BundleLibs) > 0,
+		"launcherdebug":  opts.LauncherDebug,
+		"payloadargs":    len(opts.PayloadArgs) > 0,
+		"secretargs":     len(opts.SecretArgs) > 0,
+		"envclear":       opts.EnvPolicy.Mode == EnvClear || opts.EnvPolicy.Mode == EnvAllowlist,
+		"envallowlist":   opts.EnvPolicy.Mode == EnvAllowlist,
+		"payloadenv":     len(opts.PayloadEnv) > 0,
+		"daemonize":      opts.Daemonize,
+		"payloadtimeout": opts.PayloadTimeout > 0,
+		"successcodes":   len(opts.SuccessCodes) > 0,
+		"daemonlog":      opts.LogPath != "",
+		"daemonpidfile":  opts.Pidfile != "",
+		"init":           opts.Init,
+		"rlimit":         len(opts.Rlimits) > 0,
+		"rlimitclamp":    opts.RlimitPolicy == RlimitClamp,
+		"android":        opts.Platform == PlatformAndroid,
+		"sched":          opts.Scheduling.Enabled(),
+		"nice":           opts.Scheduling.HasNice,
+		"ionice":         opts.Scheduling.IOPrio != 0,
+		"cpuset":         len(opts.Scheduling.CPUs) > 0,
+		"cpusetstrict":   opts.Scheduling.Strict,
+	}
+
+	for feature, enabled := range codecFeatures(opts.codec()) {
+		features[feature] = enabled
+	}
+
+	_, features["iohints"] = IOProfiles[opts.IOProfile]
+
+	return features
+}
+
+// codec returns the name of the codec of the blobs, DefaultCodec if none is given
+func (opts Options) codec() string {
+	if opts.Codec == "" {
+		return DefaultCodec
+	}
+
+	return opts.Codec
+}
+
+// buildFeatures returns the launcherFeatures with the architecture ones
+func (opts Options) buildFeatures() map[string]bool {
+	features := opts.launcherFeatures()
+	for feature, enabled := range archFeatures(opts.Arch) {
+		features[feature] = enabled
+	}
+
+	return features
+}
+
+/*
+launcherBuildCommand returns the tool and the arguments building the
+launcher of the features into output, an absolute path.
+*/
+func launcherBuildCommand(opts Options, features map[string]bool, output string) (string, []string, error) {
+	ldflags := "-s -w -extldflags -static"
+
+	// the userland launcher makes room for the payload
+	if features["userland"] {
+		ldflags += " -T " + userlandTextAddress
+	}
+
+	flags := []string{"build", "-a",
+		"-trimpath",
+		"-gcflags",
+		"-N -l -nolocalimports",
+		"-ldflags",
+		ldflags,
+		"-o",
+		output,
+		".",
+	}
+
+	if !opts.UseGarble {
+		return "go", flags, nil
+	}
+
+	return "garble", garbleFlags(flags), pinGarbleGo()
+}
+
+// hasDepPolicy returns true if any dependency uses the policy
+func (opts Options) hasDepPolicy(policy string) bool {
+	for _, dependency := range opts.Dependencies {
+		if dependency.Policy == policy {
+			return true
+		}
+	}
+
+	return false
 }
 
-// Pakkero will Encrypt and pack the payload for a secure execution
-func Pakkero(infile string, offset int64, outfile string, dependency string, compress bool) {
-	trap()
+/*
+Pakkero will Encrypt and pack the payload for a secure execution.
+The external tools are killed when ctx is done or their stage times out,
+no further stage starts, the temporary files are removed and the stage
+is reported.
+A failed pack returns its *PackError, already reported, see ExitCode.
+*/
+func Pakkero(ctx context.Context, opts Options) (packErr error) {
+	infile := opts.InFile
+	offset := opts.Offset
+	outfile := opts.OutFile
+	compress := opts.Compress
+
+	progress = opts.Progress
+
+	startLifecycle()
+
+	// a failed step leaves no temporary file, a panic neither
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			Cleanup()
+			panic(recovered)
+		}
+
+		if packErr != nil {
+			Cleanup()
+		}
+	}()
+
+	// a canceled or timed out pack starts no further stage
+	startStep := func(name string) error {
+		Log.Start(name)
+
+		if ctx.Err() != nil {
+			return opts.failStep(opts.timeoutError(ctx, ctx, name, ctx.Err()))
+		}
+
+		return nil
+	}
+
+	if opts.LauncherDebug {
+		Log.Warnf("building a DEBUG launcher: it logs every stage and " +
+			"it is not obfuscated, never ship it")
+	}
+
+	if opts.SeccompSelf && !Contains(SeccompArchs, opts.Arch) {
+		Log.Warnf("the seccomp filter is %s only, the %s launcher will not have it",
+			strings.Join(SeccompArchs, " and "), opts.Arch)
+	}
+
+	// ------------------------------------------------------------------------
+	// The output replaces the payload: everything reads a copy of it, taken before anything is written
+	if err := startStep("Copying payload"); err != nil {
+		return err
+	}
+
+	if opts.InPlace {
+		snapshot, err := snapshotFile(infile)
+		if err != nil {
+			return opts.failStep(fmt.Errorf("failed copying the payload: %w", err))
+		}
+
+		infile = snapshot
+		defer removeTracked(snapshot)
+
+		Log.Done(StatusOK)
+	} else {
+		Log.Done(StatusSkip)
+	}
+	// ------------------------------------------------------------------------
+
+	if err := startStep("Checking Options"); err != nil {
+		return err
+	}
+
+	// the weakening option combinations, before anything is built
+	fired, err := CheckPolicy(opts)
+	for _, rule := range fired {
+		Log.Warnf("policy %s: %s", rule.ID, rule.Summary)
+		policyFindings = append(policyFindings, rule.ID+": "+rule.Summary)
+	}
+
+	if err != nil {
+		return opts.failStep(err)
+	}
+
+	err = CheckPayloadFormat(infile)
+	if err != nil {
+		return opts.failStep(err)
+	}
+
+	// the blobs are compressed with it from the launcher measure on
+	err = RegisterCodec(opts.codec())
+	if err != nil {
+		return opts.failStep(err)
+	}
+
+	// the measured launcher is scrubbed of the calibrated words too
+	err = LoadCalibration(opts.Calibration)
+	if err != nil {
+		return opts.failStep(err)
+	}
+
+	// fail before building the launcher, not after
+	if opts.ScrubPayloadBuildInfo {
+		info, err := ReadPayloadBuildInfo(infile)
+		if err != nil {
+			return opts.failStep(fmt.Errorf("-scrub-payload-buildinfo: %w", err))
+		}
+
+		Log.Infof("payload module %s %s, built with %s: its build info will be scrubbed",
+			info.Main.Path, info.Main.Version, info.GoVersion)
+	}
+
+	// the userland exec cannot fall back to the memfd at run time
+	if opts.ExecMode == ExecModeUserland {
+		for _, payload := range []string{infile, opts.Decoy} {
+			if payload == "" {
+				continue
+			}
+
+			err = CheckUserlandPayload(payload)
+			if err != nil {
+				return opts.failStep(err)
+			}
+		}
+	}
+
+	// a pakkero output in a launcher is slow, huge and hard to debug
+	if version, packed := DetectRepack(infile); packed {
+		if !opts.AllowRepack {
+			return opts.failStep(&PackError{Err: fmt.Errorf("the payload is already packed by pakkero %s", version),
+				Hint: "use -allow-repack to pack it again"})
+		}
+
+		Log.Warnf("repacking a payload packed by pakkero %s", version)
+	}
+
+	// warn early about what will be lost in the packed file
+	for _, warning := range CheckPayloadMode(infile) {
+		Log.Warnf("%s", warning)
+	}
+
+	// and about the libraries the target may not have
+	for _, lib := range NeededLibraries(infile, opts.BundleLibs) {
+		switch {
+		case lib.Bundled:
+			Log.Infof("payload library %s: bundled", lib.Name)
+		case lib.Common:
+			Log.Infof("payload library %s: common", lib.Name)
+		default:
+			Log.Warnf("the payload needs %s, the target must have it or bundle it with -bundle-libs", lib.Name)
+		}
+	}
+
+	Log.Done(StatusOK)
+
+	// ------------------------------------------------------------------------
+	// choose the offset from the size of a launcher built like the one
+	// of the pack, the final launcher may differ by the secrets only
+	if err := startStep("Measuring Launcher"); err != nil {
+		return err
+	}
+
+	if opts.AutoOffset {
+		blobs, err := blobsSize(opts)
+
+		launcherSize := int64(0)
+		if err == nil {
+			launcherSize, err = measureLauncher(ctx, opts)
+		}
+
+		if err != nil {
+			return opts.failStep(fmt.Errorf("failed measuring the launcher: %w", err))
+		}
+
+		offset = AutoOffset(launcherSize, opts.OffsetPadding, blobs)
+
+		Log.Done(StatusOK)
+		Log.Infof("launcher of %d bytes, offset %d chosen", launcherSize, offset)
+	} else {
+		Log.Done(StatusSkip)
+	}
+	// ------------------------------------------------------------------------
 
-	fmt.Print(" → Randomizing offset...")
+	if err := startStep("Randomizing offset"); err != nil {
+		return err
+	}
 
 	// declare outfile as original filename + .enc
 	if len(outfile) == 0 {
-		outfile = infile + ".enc"
+		outfile = opts.InFile + ".enc"
 	}
 
+	opts.OutFile = outfile
+
 	// ------------------------------------------------------------------------
 	// offset Hysteresis, this will prevent easy key retrieving
-	rand.Seed(time.Now().UTC().UnixNano())
 	offset += Random(128, 4094)
-	fmt.Printf(SuccessColor, "\t\t[ OK ]\n")
+
+	// a 32 bit launcher must be able to load the payload
+	stat, err := os.Stat(infile)
+	if err == nil {
+		err = CheckArchMemory(opts.Arch, offset, stat.Size())
+	}
+
+	if err != nil {
+		return opts.failStep(err)
+	}
+
+	Log.Done(StatusOK)
 	// ------------------------------------------------------------------------
 
 	// ------------------------------------------------------------------------
 	// Register Dependency to try and bypass any tampering on dependent
 	// packages
-	fmt.Print(" → Registering Dependencies...")
+	if err := startStep("Registering Dependencies"); err != nil {
+		return err
+	}
+
+	// copy the stub from where to start.
+	launcherStub, _ := base64.StdEncoding.DecodeString(LauncherStub)
 
 	// ------------------------------------------------------------------------
-	// Register eventual dependency passed by cli
+	// Register eventual dependencies passed by cli
 	// If a dependency check is present, register it.
-	if dependency != "" {
-		RegisterDependency(dependency)
-	} else {
-		// in case of missing dependency add an empty variable for BFD
-		Secrets[depBFDPlaceholder] = []string{"[]float64{}", "leaveBFD"}
+	launcher, err := RegisterDependencies(string(launcherStub), opts.Dependencies)
+	if err != nil {
+		return opts.failStep(err)
 	}
 
-	fmt.Printf(SuccessColor, "\t\t[ OK ]\n")
+	Log.Done(StatusOK)
 	// ------------------------------------------------------------------------
 
 	// ------------------------------------------------------------------------
-	// Create the launcher program starting from our stub
-	fmt.Print(" → Creating Launcher Stub...")
+	// Register the decoy, if any
+	if err := startStep("Registering Decoy"); err != nil {
+		return err
+	}
 
-	// add offset to the secrets!
-	Secrets[offsetPlaceholder] = []string{fmt.Sprintf("%d", offset),
-		GenerateTyposquatName()}
+	var decoy []byte
 
-	// copy the stub from where to start.
-	launcherStub, _ := base64.StdEncoding.DecodeString(LauncherStub)
-	err := ioutil.WriteFile(launcherFile, launcherStub, 0644)
+	decoyStart := offset
 
-	if err != nil {
-		fmt.Printf(ErrorColor, "\t\t[ ERR ]\n")
-		println(fmt.Sprintf("failed writing to file: %s", err))
-		cleanup()
-		os.Exit(ERR)
-	}
+	if opts.Decoy != "" {
+		decoy, decoyStart, err = RegisterDecoy(opts.Decoy, offset)
+		if err != nil {
+			return opts.failStep(err)
+		}
+
+		Log.Done(StatusOK)
+	} else {
+		if opts.hasDepPolicy(DepPolicyDegrade) {
+			return opts.failStep(&PackError{Err: errors.New("the degrade dependency policy needs a decoy"),
+				Hint: "pass the file to run instead of the payload with -decoy"})
+		}
 
-	fmt.Printf(SuccessColor, "\t\t[ OK ]\n")
+		Log.Done(StatusSkip)
+	}
 	// ------------------------------------------------------------------------
 
 	// ------------------------------------------------------------------------
-	// Obfuscate the launcher
-	fmt.Print(" → Obfuscating Launcher Stub...")
+	// Register the bundled libraries, if any, before the decoy
+	if err := startStep("Registering Bundled Libraries"); err != nil {
+		return err
+	}
 
-	err = ObfuscateLauncher(launcherFile)
-	if err != nil {
-		fmt.Printf(ErrorColor, "\t\t[ ERR ]\n")
-		println(fmt.Sprintf("failed obfuscating file file: %s", err))
-		cleanup()
-		os.Exit(ERR)
+	var libs []byte
+
+	blobsStart := decoyStart
+
+	if len(opts.BundleLibs) > 0 {
+		libs, blobsStart, err = RegisterBundledLibs(opts.BundleLibs, decoyStart)
+		if err != nil {
+			return opts.failStep(err)
+		}
+
+		Log.Done(StatusOK)
+	} else {
+		Log.Done(StatusSkip)
 	}
+	// ------------------------------------------------------------------------
 
-	fmt.Printf(SuccessColor, "\t\t[ OK ]\n")
 	// ------------------------------------------------------------------------
+	// Register the prologue, if any, before the libraries
+	if err := startStep("Registering Prologue"); err != nil {
+		return err
+	}
 
+	var prologue []byte
+
+	if opts.Prologue != "" {
+		prologue, blobsStart, err = RegisterPrologue(opts.Prologue, blobsStart)
+		if err != nil {
+			return opts.failStep(err)
+		}
+
+		Log.Done(StatusOK)
+	} else {
+		Log.Done(StatusSkip)
+	}
 	// ------------------------------------------------------------------------
-	// compile the launcher binary
-	fmt.Print(" → Compiling Launcher...")
 
-	var flags []string
+	// ------------------------------------------------------------------------
+	// Register the map of the scattered payload, if any, before the prologue
+	if err := startStep("Registering Scatter"); err != nil {
+		return err
+	}
 
-	os.Setenv("CGO_ENABLED", "0")
+	if opts.Layout == LayoutScatter {
+		blobsStart = RegisterScatter(blobsStart)
+		Log.Done(StatusOK)
+	} else {
+		Log.Done(StatusSkip)
+	}
+	// ------------------------------------------------------------------------
 
-	flags = []string{"build", "-a",
-		"-trimpath",
-		"-gcflags",
-		"-N -l -nolocalimports",
-		"-ldflags",
-		"-s -w -extldflags -static",
+	// ------------------------------------------------------------------------
+	// Register the arming conditions, if any
+	if err := startStep("Registering Arming"); err != nil {
+		return err
 	}
-	flags = append(flags, "-o")
-	flags = append(flags, outfile)
-	flags = append(flags, launcherFile)
 
-	if ExecCommand("go", flags) {
-		fmt.Printf(SuccessColor, "\t\t[ OK ]\n")
+	if opts.armingEnabled() || opts.WaitForArming {
+		err := RegisterArming(opts)
+		if err != nil {
+			return opts.failStep(err)
+		}
+
+		Log.Done(StatusOK)
 	} else {
-		fmt.Printf(ErrorColor, "\t\t[ ERR ]\n")
-		ExecCommand("rm", []string{"-f", outfile})
-		cleanup()
-		os.Exit(ERR)
+		Log.Done(StatusSkip)
 	}
 	// ------------------------------------------------------------------------
 
 	// ------------------------------------------------------------------------
-	// Strip File of excess headers
-	fmt.Print(" → Stripping Launcher...")
+	// Register the license check, if any
+	if err := startStep("Registering License"); err != nil {
+		return err
+	}
 
-	if StripFile(outfile, launcherFile) {
-		fmt.Printf(SuccessColor, "\t\t[ OK ]\n")
+	if len(opts.LicensePubKeys) > 0 {
+		ids, err := RegisterLicense(opts.LicensePubKeys, opts.LicenseFile)
+		if err != nil {
+			return opts.failStep(err)
+		}
+
+		Log.Done(StatusOK)
+		Log.Infof("license public keys: %s", strings.Join(ids, ", "))
 	} else {
-		fmt.Printf(ErrorColor, "\t\t[ ERR ]\n")
-		ExecCommand("rm", []string{"-f", outfile})
-		cleanup()
-		os.Exit(ERR)
+		Log.Done(StatusSkip)
 	}
 	// ------------------------------------------------------------------------
 
 	// ------------------------------------------------------------------------
-	// Compress File of occupy less space
-	// Then remove UPX headers from file.
-	fmt.Print(" → Compressing Launcher...")
+	// Register the key of the launch token, if any
+	if err := startStep("Registering Launch Token"); err != nil {
+		return err
+	}
 
-	if compress {
-		if ExecCommand("upx", []string{outfile}) &&
-			StripUPXHeaders(outfile) {
-			fmt.Printf(SuccessColor, "\t\t[ OK ]\n")
-		} else {
-			fmt.Printf(ErrorColor, "\t\t[ ERR ]\n")
-			ExecCommand("rm", []string{"-f", outfile})
-			cleanup()
-			os.Exit(ERR)
+	if opts.LaunchTokenKey != "" {
+		id, err := RegisterLaunchToken(opts.LaunchTokenKey)
+		if err != nil {
+			return opts.failStep(err)
 		}
+
+		Log.Done(StatusOK)
+		Log.Infof("launch token key: %s", id)
 	} else {
-		fmt.Printf(WarningColor, "\t\t[ SKIPPING ]\n")
+		Log.Done(StatusSkip)
 	}
 	// ------------------------------------------------------------------------
 
 	// ------------------------------------------------------------------------
-	// Remove unused file
-	fmt.Print(" → Cleaning up...")
+	// Register the key signing the output, if any
+	if err := startStep("Registering Signing Key"); err != nil {
+		return err
+	}
+
+	if opts.SignKey != "" {
+		id, err := RegisterSigningKey(opts.SignKey)
+		if err != nil {
+			return opts.failStep(err)
+		}
 
-	if ExecCommand("rm", []string{"-f", launcherFile}) {
-		fmt.Printf(SuccessColor, "\t\t\t[ OK ]\n")
+		Log.Done(StatusOK)
+		Log.Infof("signing key: %s", id)
 	} else {
-		fmt.Printf(ErrorColor, "\t\t\t[ ERR ]\n")
-		ExecCommand("rm", []string{"-f", outfile})
-		os.Exit(ERR)
+		Log.Done(StatusSkip)
 	}
 	// ------------------------------------------------------------------------
 
-	// read compiled file
-	encFile, err := os.OpenFile(outfile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		fmt.Printf(ErrorColor, "\t\t[ ERR ]\n")
-		println(fmt.Sprintf("failed writing to file: %s", err))
-		os.Exit(ERR)
+	// ------------------------------------------------------------------------
+	// Register the key of the override tokens, if any
+	if err := startStep("Registering Override"); err != nil {
+		return err
 	}
-	defer encFile.Close()
-	encFileStat, _ := encFile.Stat()
-	encFileSize := encFileStat.Size()
 
+	if opts.OverridePubKey != "" {
+		id, err := RegisterOverride(opts.OverridePubKey, opts.OverrideFile)
+		if err != nil {
+			return opts.failStep(err)
+		}
+
+		Log.Done(StatusOK)
+		Log.Infof("override key: %s", id)
+	} else {
+		Log.Done(StatusSkip)
+	}
 	// ------------------------------------------------------------------------
-	// Input validation
-	fmt.Print(" → Verifying input offset...")
 
-	// Ensure input offset is valid comared to compiled file size!
-	if offset <= encFileSize {
-		ExecCommand("rm", []string{"-f", outfile})
-		fmt.Printf(ErrorColor, "\t\t[ ERR ]\n")
-		println("ERROR! Calculated offset is lower than launcher size: " +
-			fmt.Sprintf("offset=%d, filesize=%d", offset, encFileSize))
-		os.Exit(ERR)
+	// ------------------------------------------------------------------------
+	// Register the host registration, if any
+	if err := startStep("Registering Host Registration"); err != nil {
+		return err
 	}
 
-	fmt.Printf(SuccessColor, "\t\t[ OK ]\n")
+	if opts.RegisterHost != "" {
+		err := RegisterRegistration(opts.RegisterHostPubKey, opts.RegisterHost)
+		if err != nil {
+			return opts.failStep(err)
+		}
+
+		Log.Done(StatusOK)
+		Log.Infof("registration build id: %d", buildID())
+	} else {
+		Log.Done(StatusSkip)
+	}
 	// ------------------------------------------------------------------------
 
 	// ------------------------------------------------------------------------
-	// Pre-Payload Garbage
-	// calculate where to put garbage and where to put the payload
-	fmt.Print(" → Adding garbage...")
+	// Register the hostname lists, if any
+	if err := startStep("Registering Host Lists"); err != nil {
+		return err
+	}
 
-	blockCount := offset - encFileSize
-	// append randomness to the runner itself
-	_, err = encFile.WriteString(GenerateRandomGarbage(blockCount))
-	if err != nil {
-		fmt.Printf(ErrorColor, "\t\t\t[ ERR ]\n")
-		println(fmt.Sprintf("failed writing to file: %s", err))
-		os.Exit(ERR)
+	if len(opts.AllowHostnames) > 0 || len(opts.DenyHostnames) > 0 {
+		RegisterHostList(opts.AllowHostnames, opts.DenyHostnames)
+		Log.Done(StatusOK)
+	} else {
+		Log.Done(StatusSkip)
 	}
+	// ------------------------------------------------------------------------
 
-	fmt.Printf(SuccessColor, "\t\t\t[ OK ]\n")
 	// ------------------------------------------------------------------------
+	// Register the attestation channel, if any
+	if err := startStep("Registering Attestation"); err != nil {
+		return err
+	}
 
+	if opts.Attest.Enabled() {
+		Log.Done(StatusOK)
+		Log.Infof("attestation build id: %d", RegisterAttest(opts.Attest))
+	} else {
+		Log.Done(StatusSkip)
+	}
 	// ------------------------------------------------------------------------
-	// Encryption and compression of the payload
-	// get file to encrypt argument
-	fmt.Print(" → Reading payload...")
 
-	byteContent, err := ioutil.ReadFile(infile) // just pass the file name
-	if err != nil {
-		fmt.Printf(ErrorColor, "\t\t\t[ ERR ]\n")
-		println(fmt.Sprintf("failed reading file: %s", err))
-		os.Exit(ERR)
+	// ------------------------------------------------------------------------
+	// Register the health check, its variable goes to the manifest only
+	if err := startStep("Registering Health Check"); err != nil {
+		return err
 	}
 
-	content := string(byteContent)
+	if opts.HealthCheck {
+		err := RegisterHealthCheck(infile)
+		if err != nil {
+			return opts.failStep(err)
+		}
 
-	// plaintext content
-	plaintext := []byte(base64.StdEncoding.EncodeToString([]byte(content)))
+		Log.Done(StatusOK)
+	} else {
+		Log.Done(StatusSkip)
+	}
+	// ------------------------------------------------------------------------
 
-	fmt.Printf(SuccessColor, "\t\t\t[ OK ]\n")
 	// ------------------------------------------------------------------------
+	// Register what the payload needs, told by the launcher if it does not run
+	if err := startStep("Registering Exec Diagnostics"); err != nil {
+		return err
+	}
 
-	fmt.Print(" → Compressing payload...")
+	if opts.launcherFeatures()["execdiag"] {
+		requirements, err := RegisterExecDiagnostics(infile, opts.BundleLibs)
+		if err != nil {
+			return opts.failStep(err)
+		}
 
-	// GZIP before encrypt
-	plaintext = GzipContent(plaintext)
+		Log.Done(StatusOK)
+		Log.Debugf("payload interpreter %q, machine %s, libraries %s",
+			requirements.Interpreter, requirements.Machine, strings.Join(requirements.Needed, ", "))
+	} else {
+		Log.Done(StatusSkip)
+	}
+	// ------------------------------------------------------------------------
 
-	fmt.Printf(SuccessColor, "\t\t[ OK ]\n")
 	// ------------------------------------------------------------------------
+	// Register the output capture, if any
+	if err := startStep("Registering Output Capture"); err != nil {
+		return err
+	}
 
-	fmt.Print(" → Encrypting payload...")
+	if opts.CaptureOutput != "" {
+		key, err := RegisterCapture(opts.CaptureOutput)
+		if err != nil {
+			return opts.failStep(err)
+		}
 
-	// encrypt aes256-gcm
-	ciphertext, err := EncryptAESReversed(plaintext, outfile)
-	if err != nil {
-		fmt.Printf(ErrorColor, "\t\t[ ERR ]\n")
-		println(fmt.Sprintf("failed encrypting file: %s", err))
-		os.Exit(ERR)
+		Log.Done(StatusOK)
+		// printed once and never logged to -log-file nor to the manifest
+		fmt.Fprintf(Log.Output, "capture key: %x\n", key)
+	} else {
+		Log.Done(StatusSkip)
 	}
+	// ------------------------------------------------------------------------
 
-	// append payload to the runner itself
-	_, err = encFile.WriteString(ciphertext)
-	if err != nil {
-		fmt.Printf(ErrorColor, "\t\t[ ERR ]\n")
-		println(fmt.Sprintf("failed writing to file: %s", err))
-		os.Exit(ERR)
+	// ------------------------------------------------------------------------
+	// Register the payload arguments, if any
+	if err := startStep("Registering Payload Arguments"); err != nil {
+		return err
 	}
 
-	fmt.Printf(SuccessColor, "\t\t[ OK ]\n")
+	if len(opts.PayloadArgs) > 0 || len(opts.SecretArgs) > 0 {
+		err := RegisterPayloadArgs(opts.PayloadArgs, opts.SecretArgs)
+		if err != nil {
+			return opts.failStep(err)
+		}
+
+		Log.Done(StatusOK)
+	} else {
+		Log.Done(StatusSkip)
+	}
 	// ------------------------------------------------------------------------
 
 	// ------------------------------------------------------------------------
-	// Post-Payload Garbage
-	// calculate final padding
-	fmt.Print(" → Adding garbage to payload...")
+	// Register the payload environment, if any
+	if err := startStep("Registering Payload Environment"); err != nil {
+		return err
+	}
+
+	if opts.EnvPolicy.Mode == EnvAllowlist || len(opts.PayloadEnv) > 0 {
+		err := RegisterEnv(opts.EnvPolicy, opts.PayloadEnv)
+		if err != nil {
+			return opts.failStep(err)
+		}
 
-	finalPaddingArray := make([]byte, binary.MaxVarintLen64)
-	n := binary.PutVarint(finalPaddingArray, offset)
-	finalPaddingB := finalPaddingArray[:n]
-	// change endianess to every byte composing
-	// the offset
-	for i := range finalPaddingB {
-		finalPaddingB[i] = ReverseByte(finalPaddingB[i])
+		Log.Done(StatusOK)
+	} else {
+		Log.Done(StatusSkip)
 	}
+	// ------------------------------------------------------------------------
 
-	finalPadding, _ := binary.Varint(finalPaddingB)
-	// and ensure it is positive!
-	if finalPadding < 0 {
-		finalPadding *= -1
+	// ------------------------------------------------------------------------
+	// Register the daemonize options, if any
+	if err := startStep("Registering Daemon"); err != nil {
+		return err
 	}
 
-	// append random garbage equal to bit-reverse of the offset
-	// at the end of the payload
-	_, err = encFile.WriteString(GenerateRandomGarbage(finalPadding))
-	if err != nil {
-		fmt.Printf(ErrorColor, "\t\t[ ERR ]\n")
-		println(fmt.Sprintf("failed writing to file: %s", err))
-		os.Exit(ERR)
+	if opts.Daemonize {
+		err := RegisterDaemon(opts)
+		if err != nil {
+			return opts.failStep(err)
+		}
+
+		Log.Done(StatusOK)
+	} else {
+		Log.Done(StatusSkip)
 	}
+	// ------------------------------------------------------------------------
 
 	// ------------------------------------------------------------------------
-	fmt.Printf(SuccessColor, "\t\t[ OK ]\n")
+	// Register the payload timeout and success codes, if any
+	if err := startStep("Registering Exit Policy"); err != nil {
+		return err
+	}
+
+	if opts.PayloadTimeout > 0 || len(opts.SuccessCodes) > 0 {
+		RegisterExitPolicy(opts)
+		Log.Done(StatusOK)
+	} else {
+		Log.Done(StatusSkip)
+	}
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// Register the resource limits, if any
+	if err := startStep("Registering Resource Limits"); err != nil {
+		return err
+	}
+
+	if len(opts.Rlimits) > 0 {
+		RegisterRlimits(opts.Rlimits)
+		Log.Done(StatusOK)
+	} else {
+		Log.Done(StatusSkip)
+	}
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// Register the scheduling, if any
+	if err := startStep("Registering Scheduling"); err != nil {
+		return err
+	}
+
+	if opts.Scheduling.Enabled() {
+		RegisterScheduling(opts.Scheduling)
+		Log.Done(StatusOK)
+	} else {
+		Log.Done(StatusSkip)
+	}
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// Register the I/O hints, if any
+	if err := startStep("Registering I/O Profile"); err != nil {
+		return err
+	}
+
+	if opts.launcherFeatures()["iohints"] {
+		RegisterIOProfile(opts.IOProfile)
+		Log.Done(StatusOK)
+	} else {
+		Log.Done(StatusSkip)
+	}
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// Register the whitening of the payload, if requested
+	if err := startStep("Registering Whitening"); err != nil {
+		return err
+	}
+
+	if opts.Whiten {
+		RegisterWhitening(offset)
+		Log.Done(StatusOK)
+	} else {
+		Log.Done(StatusSkip)
+	}
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// Create the launcher program starting from our stub
+	if err := startStep("Creating Launcher Stub"); err != nil {
+		return err
+	}
+
+	// add offset to the secrets!
+	Secrets[offsetPlaceholder] = []string{fmt.Sprintf("%d", offset),
+		GenerateTyposquatName()}
+	Secrets[execRetriesPlaceholder] = []string{fmt.Sprintf("%d", opts.ExecRetries),
+		GenerateTyposquatName()}
+	Secrets[execBackoffPlaceholder] = []string{fmt.Sprintf("%d", opts.ExecBackoff.Milliseconds()),
+		GenerateTyposquatName()}
+
+	if opts.PinProcs {
+		Secrets[pinnedProcsPlaceholder] = []string{fmt.Sprintf("%d", Random(1, maxPinnedProcs+1)),
+			GenerateTyposquatName()}
+	}
+
+	// the go commands run for the launcher build it for its target
+	goEnv := WorkspaceEnviron(opts.Arch)
+
+	// remove the code of the features we do not need, the
+	// toolchain ones follow the go version building the launcher,
+	// the architecture ones the target of the launcher
+	features := opts.buildFeatures()
+	launcher = StripFeatures(launcher, features)
+
+	// build the launcher in its own module, with a random identity
+	identity := NewIdentity(opts.IdentitySeed)
+	minor := GoMinorVersion()
+
+	if minor < 0 {
+		minor = minGoMinorVersion
+	}
+
+	launcherDir, launcherFile, err = identity.CreateWorkspace(minor)
+	if launcherDir != "" {
+		Track(launcherDir)
+	}
+
+	// the go commands killed on timeout leave their work directory,
+	// keep it in the workspace, hidden from the go tools
+	if err == nil {
+		goTmpDir := filepath.Join(launcherDir, ".gotmp")
+
+		err = os.Mkdir(goTmpDir, 0700)
+		goEnv = append(goEnv, "GOTMPDIR="+goTmpDir)
+	}
+
+	if err == nil {
+		err = ioutil.WriteFile(launcherFile, []byte(launcher), 0644)
+	}
+
+	if err == nil {
+		err = WriteLauncherAsm(launcherFile, features)
+	}
+
+	if err != nil {
+		return opts.failStep(fmt.Errorf("failed writing to file: %w", err))
+	}
+
+	Log.Done(StatusOK)
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// let the hooks edit the launcher source
+	if err := startStep("Running pre-obfuscate hooks"); err != nil {
+		return err
+	}
+
+	if opts.Hooks.PreObfuscate != nil {
+		src, err := ioutil.ReadFile(launcherFile)
+		if err == nil {
+			launcher, err = opts.Hooks.PreObfuscate(ctx, string(src))
+		}
+
+		if err == nil {
+			err = ioutil.WriteFile(launcherFile, []byte(launcher), 0644)
+		}
+
+		if err != nil {
+			return opts.failStep(opts.timeoutError(ctx, ctx, HookPreObfuscate, err))
+		}
+
+		Log.Done(StatusOK)
+	} else {
+		Log.Done(StatusSkip)
+	}
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// list the syscalls the launcher makes, to confine it to them
+	if err := startStep("Registering Syscalls"); err != nil {
+		return err
+	}
+
+	launcherSyscalls, err = LauncherSyscalls(launcher)
+	if err == nil && features["seccomp"] {
+		err = RegisterSeccomp(opts.Arch, launcherSyscalls, opts.SeccompAllow)
+	}
+
+	if err != nil {
+		return opts.failStep(err)
+	}
+
+	Log.Done(StatusOK)
+	Log.Infof("the launcher makes %d syscalls", len(launcherSyscalls))
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// Obfuscate the launcher
+	if err := startStep("Obfuscating Launcher Stub"); err != nil {
+		return err
+	}
+
+	// the source before the obfuscation, for the explain diff
+	preObfuscation := []byte{}
+	if opts.Explain != "" && opts.ExplainDiff {
+		preObfuscation, err = ioutil.ReadFile(launcherFile)
+	}
+
+	if err == nil {
+		err = ObfuscateLauncher(launcherFile, opts.Guards, opts.AllowNoChecks)
+	}
+
+	if err != nil {
+		return opts.failStep(fmt.Errorf("failed obfuscating file file: %w", err))
+	}
+
+	Log.Done(StatusOK)
+
+	if len(checkSites) == 0 {
+		Log.Warnf("the launcher has no check point, it runs no anti-debug check")
+	} else {
+		Log.Infof("anti-debug checks spread over %d check points", len(checkSites))
+	}
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// Describe what the obfuscation did, before the build can fail
+	if err := startStep("Writing explain report"); err != nil {
+		return err
+	}
+
+	if opts.Explain != "" {
+		diff := ""
+		if opts.ExplainDiff {
+			diff, err = WriteExplainDiff(string(preObfuscation), launcherFile, opts.ExplainUnsafe)
+		}
+
+		explainWorkfile := ""
+		if err == nil {
+			explainWorkfile, err = createWorkFile(opts.Explain, 0600)
+		}
+
+		if err == nil {
+			err = WriteExplain(explainWorkfile, opts.ExplainUnsafe, diff)
+		}
+
+		if err == nil {
+			err = commitWorkFile(explainWorkfile, opts.Explain)
+		}
+
+		if err != nil {
+			return opts.failStep(fmt.Errorf("failed writing the explain report: %w", err))
+		}
+
+		Log.Done(StatusOK)
+
+		if diff != "" {
+			Log.Infof("obfuscation diff saved to %s", diff)
+		}
+	} else {
+		Log.Done(StatusSkip)
+	}
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// make sure nothing has to be downloaded to build the launcher
+	if err := startStep("Checking Offline Build"); err != nil {
+		return err
+	}
+
+	if opts.Offline {
+		missing, err := identity.ExternalPackages(ctx, launcherDir, goEnv)
+		if err != nil {
+			return opts.failStep(fmt.Errorf("failed listing the launcher packages: %w", err))
+		}
+
+		if len(missing) > 0 {
+			return opts.failStep(&PackError{Err: fmt.Errorf("the launcher needs packages not available offline: %s",
+				strings.Join(missing, ", ")), Hint: "use a go toolchain with them in its standard library"})
+		}
+
+		Log.Done(StatusOK)
+	} else {
+		Log.Done(StatusSkip)
+	}
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// vet the launcher, the generated code must be clean on the
+	// toolchain building it
+	if err := startStep("Vetting Launcher"); err != nil {
+		return err
+	}
+
+	vetCtx, cancel := opts.stageContext(ctx, StageVet)
+	defer cancel()
+
+	err = execTool(vetCtx, launcherDir, "go", []string{"vet", "."}, goEnv)
+	if err == nil {
+		Log.Done(StatusOK)
+	} else {
+		if !opts.AllowVet || vetCtx.Err() != nil {
+			err = opts.timeoutError(ctx, vetCtx, StageVet, err)
+			if vetCtx.Err() == nil {
+				err = &PackError{Err: err, Hint: "pack anyway with -allow-vet, if the findings are harmless"}
+			}
+
+			return opts.failStep(err)
+		}
+
+		Log.Done(StatusErr)
+		Log.Warnf("go vet findings ignored, as requested by -allow-vet")
+	}
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// compile the launcher binary
+	if err := startStep("Compiling Launcher"); err != nil {
+		return err
+	}
+
+	// the output is built next to its destination and renamed into
+	// place at the end, an existing file there is left alone until then
+	workfile, err := createWorkFile(outfile, outputMode)
+	if err == nil {
+		// go build does not overwrite files it did not build
+		err = os.Remove(workfile)
+	}
+
+	// go build runs inside the launcher workspace
+	absWorkfile := ""
+	if err == nil {
+		absWorkfile, err = filepath.Abs(workfile)
+	}
+
+	if err != nil {
+		return opts.failStep(err)
+	}
+
+	builder, flags, err := launcherBuildCommand(opts, features, absWorkfile)
+	if err != nil {
+		return opts.failStep(err)
+	}
+
+	buildCtx, cancel := opts.stageContext(ctx, StageBuild)
+	defer cancel()
+
+	err = execTool(buildCtx, launcherDir, builder, flags, append(goEnv, "CGO_ENABLED=0"))
+	if err != nil {
+		return opts.failStep(opts.timeoutError(ctx, buildCtx, StageBuild, err))
+	}
+
+	Log.Done(StatusOK)
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// Strip File of excess headers
+	if err := startStep("Stripping Launcher"); err != nil {
+		return err
+	}
+
+	stripCtx, cancel := opts.stageContext(ctx, StageStrip)
+	defer cancel()
+
+	// the debug launcher keeps its strings readable
+	err = StripFile(stripCtx, workfile, launcherFile, identity.Module, !opts.LauncherDebug, opts.UseGarble)
+	if err != nil {
+		return opts.failStep(opts.timeoutError(ctx, stripCtx, StageStrip, err))
+	}
+
+	Log.Done(StatusOK)
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// Compress File of occupy less space
+	// Then remove UPX headers from file.
+	if err := startStep("Compressing Launcher"); err != nil {
+		return err
+	}
+
+	if compress {
+		compressCtx, cancel := opts.stageContext(ctx, StageCompress)
+		defer cancel()
+
+		err = compressFile(workfile, upxCodec{ctx: compressCtx})
+		if err != nil {
+			return opts.failStep(opts.timeoutError(ctx, compressCtx, StageCompress, err))
+		}
+
+		Log.Done(StatusOK)
+	} else {
+		Log.Done(StatusSkip)
+	}
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// let the hooks edit the launcher binary
+	if err := startStep("Running post-build hooks"); err != nil {
+		return err
+	}
+
+	if opts.Hooks.PostBuild != nil {
+		err = opts.Hooks.PostBuild(ctx, workfile)
+		if err != nil {
+			return opts.failStep(opts.timeoutError(ctx, ctx, HookPostBuild, err))
+		}
+
+		Log.Done(StatusOK)
+	} else {
+		Log.Done(StatusSkip)
+	}
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// Remove unused file
+	if err := startStep("Cleaning up"); err != nil {
+		return err
+	}
+
+	err = removeTracked(launcherDir)
+	if err != nil {
+		return opts.failStep(err)
+	}
+
+	Log.Done(StatusOK)
+	// ------------------------------------------------------------------------
+
+	// read compiled file
+	encFile, err := os.OpenFile(workfile, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return opts.failStep(fmt.Errorf("failed writing to file: %w", err))
+	}
+	defer encFile.Close()
+	encFileStat, _ := encFile.Stat()
+	encFileSize := encFileStat.Size()
+
+	// ------------------------------------------------------------------------
+	// Input validation
+	if err := startStep("Verifying input offset"); err != nil {
+		return err
+	}
+
+	// Ensure input offset is valid comared to compiled file size!
+	// the decoy, the libraries and the prologue, if present, live right before the offset.
+	keyMaterial := blobsStart - encFileSize - markerSize
+	suggested := encFileSize + markerSize + MinKeyMaterial + offset - blobsStart
+
+	if keyMaterial < 0 {
+		return opts.failStep(&PackError{Err: fmt.Errorf("calculated offset is lower than launcher size: "+
+			"offset=%d, decoy, libraries and prologue=%d, filesize=%d",
+			offset, offset-blobsStart, encFileSize),
+			Hint: fmt.Sprintf("use -offset %d or more, or -offset auto", suggested)})
+	}
+
+	// the measured launcher was smaller, a pre-obfuscate or post-build hook grew it
+	if keyMaterial < MinKeyMaterial && opts.AutoOffset {
+		return opts.failStep(&PackError{Err: fmt.Errorf("the launcher is %d bytes, bigger than the measured one: "+
+			"only %d bytes of garbage left", encFileSize, keyMaterial), Hint: "raise -offset-padding"})
+	}
+
+	Log.Done(StatusOK)
+
+	if keyMaterial < MinKeyMaterial {
+		Log.Warnf("only %d bytes of garbage derive the key with the launcher, "+
+			"use -offset %d or more, or -offset auto", keyMaterial, suggested)
+	}
+
+	outputLayout = OffsetLayout{
+		LauncherSize: encFileSize,
+		KeyMaterial:  keyMaterial,
+		Blobs:        offset - blobsStart,
+		Auto:         opts.AutoOffset,
+	}
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// Compression of the payload, its size is needed to scatter it
+	// get file to encrypt argument
+	if err := startStep("Reading payload"); err != nil {
+		return err
+	}
+
+	byteContent, err := ioutil.ReadFile(infile) // just pass the file name
+	if err != nil {
+		return opts.failStep(fmt.Errorf("failed reading file: %w", err))
+	}
+
+	Log.Done(StatusOK)
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// Garble the module information of a go payload
+	if err := startStep("Scrubbing payload build info"); err != nil {
+		return err
+	}
+
+	if opts.ScrubPayloadBuildInfo {
+		scrubbed := 0
+
+		byteContent, scrubbed, err = ScrubBuildInfo(byteContent)
+		if err != nil {
+			return opts.failStep(fmt.Errorf("failed scrubbing the payload build info: %w", err))
+		}
+
+		Log.Done(StatusOK)
+		Log.Infof("payload module information scrubbed in %d places", scrubbed)
+	} else {
+		Log.Done(StatusSkip)
+	}
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	if err := startStep("Encoding payload"); err != nil {
+		return err
+	}
+
+	content := string(byteContent)
+
+	// plaintext content
+	plaintext := []byte(base64.StdEncoding.EncodeToString([]byte(content)))
+
+	Log.Done(StatusOK)
+	// ------------------------------------------------------------------------
+
+	if err := startStep("Compressing payload"); err != nil {
+		return err
+	}
+
+	// compress before encrypt, with the codec of the blobs
+	plaintext = CompressContent(plaintext)
+
+	Log.Done(StatusOK)
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// Pre-Payload Garbage
+	// calculate where to put garbage and where to put the payload
+	if err := startStep("Adding garbage"); err != nil {
+		return err
+	}
+
+	// mark the output for the repacking detection, the marker is garbage too
+	marker, err := GenerateRepackMarker(offset, opts.Repackable)
+	if err == nil {
+		_, err = encFile.Write(marker)
+	}
+
+	if err != nil {
+		return opts.failStep(fmt.Errorf("failed writing to file: %w", err))
+	}
+
+	blockCount := blobsStart - encFileSize - markerSize
+
+	// the recovery record takes the start of the garbage, see Extract
+	var recoveryKey []byte
+
+	if opts.Recoverable {
+		if blockCount < recoveryRecordSize {
+			return opts.failStep(&PackError{Err: errors.New("no room for the recovery record after the launcher"),
+				Hint: fmt.Sprintf("use -offset %d or more", offset+recoveryRecordSize-blockCount)})
+		}
+
+		var record []byte
+
+		record, recoveryKey, err = GenerateRecoveryRecord(offset)
+		if err == nil {
+			_, err = encFile.Write(record)
+		}
+
+		if err != nil {
+			return opts.failStep(fmt.Errorf("failed writing to file: %w", err))
+		}
+
+		blockCount -= int64(len(record))
+	}
+
+	// append randomness to the runner itself
+	err = writeProgress(encFile, GenerateRandomGarbage(blockCount))
+	if err != nil {
+		return opts.failStep(fmt.Errorf("failed writing to file: %w", err))
+	}
+
+	Log.Done(StatusOK)
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// Scatter the payload in the launcher and the garbage, whose places
+	// are zeroed until it is encrypted, followed by their map
+	if err := startStep("Scattering payload"); err != nil {
+		return err
+	}
+
+	if payloadScattering != nil {
+		var fragments []Fragment
+
+		size := int64(len(plaintext) + gcmOverhead)
+
+		fragments, err = PlanScatter(workfile, blobsStart-blockCount, blobsStart, size)
+		if err == nil {
+			var scatterMap []byte
+
+			scatterMap, err = ScatterMap()
+			if err == nil {
+				_, err = encFile.Write(scatterMap)
+			}
+		}
+
+		if err == nil {
+			err = ClearFragments(workfile)
+		}
+
+		if err != nil {
+			return opts.failStep(fmt.Errorf("failed scattering the payload: %w", err))
+		}
+
+		Log.Done(StatusOK)
+		Log.Infof("payload in %d fragments before the offset, %d of %d bytes",
+			len(fragments), payloadScattering.scatteredSize(), size)
+	} else {
+		Log.Done(StatusSkip)
+	}
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// Prologue, encrypted with the launcher and the garbage before it
+	if err := startStep("Encrypting prologue"); err != nil {
+		return err
+	}
+
+	if prologue != nil {
+		ciphertext, err := EncryptAESReversed(prologue, workfile)
+		if err == nil {
+			err = writeProgress(encFile, ciphertext)
+		}
+
+		if err != nil {
+			return opts.failStep(fmt.Errorf("failed encrypting prologue: %w", err))
+		}
+
+		Log.Done(StatusOK)
+	} else {
+		Log.Done(StatusSkip)
+	}
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// Libraries, encrypted with the launcher, the garbage and the prologue before them
+	if err := startStep("Encrypting bundled libraries"); err != nil {
+		return err
+	}
+
+	if libs != nil {
+		ciphertext, err := EncryptAESReversed(libs, workfile)
+		if err == nil {
+			err = writeProgress(encFile, ciphertext)
+		}
+
+		if err != nil {
+			return opts.failStep(fmt.Errorf("failed encrypting bundled libraries: %w", err))
+		}
+
+		Log.Done(StatusOK)
+	} else {
+		Log.Done(StatusSkip)
+	}
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// Decoy, encrypted with the launcher, the garbage and the libraries before it
+	if err := startStep("Encrypting decoy"); err != nil {
+		return err
+	}
+
+	if decoy != nil {
+		ciphertext, err := EncryptAESReversed(decoy, workfile)
+		if err == nil {
+			err = writeProgress(encFile, ciphertext)
+		}
+
+		if err != nil {
+			return opts.failStep(fmt.Errorf("failed encrypting decoy: %w", err))
+		}
+
+		Log.Done(StatusOK)
+	} else {
+		Log.Done(StatusSkip)
+	}
+	// ------------------------------------------------------------------------
+
+	if err := startStep("Encrypting payload"); err != nil {
+		return err
+	}
+
+	// encrypt aes256-gcm
+	ciphertext, err := EncryptAESReversed(plaintext, workfile)
+	if err == nil && opts.Whiten {
+		ciphertext, err = WhitenPayload(ciphertext, workfile)
+	}
+
+	// the fragments first, the rest after the offset
+	if err == nil && payloadScattering != nil {
+		ciphertext, err = WriteFragments(ciphertext, workfile)
+	}
+
+	if err != nil {
+		return opts.failStep(fmt.Errorf("failed encrypting file: %w", err))
+	}
+
+	// append payload to the runner itself
+	err = writeProgress(encFile, ciphertext)
+	if err != nil {
+		return opts.failStep(fmt.Errorf("failed writing to file: %w", err))
+	}
+
+	Log.Done(StatusOK)
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// Post-Payload Garbage
+	// calculate final padding
+	if err := startStep("Adding garbage to payload"); err != nil {
+		return err
+	}
+
+	padding := artifact.FinalPadding(offset)
+
+	// append random garbage equal to bit-reverse of the offset
+	// at the end of the payload
+	err = writeProgress(encFile, GenerateRandomGarbage(padding))
+	if err != nil {
+		return opts.failStep(fmt.Errorf("failed writing to file: %w", err))
+	}
+
+	Log.Done(StatusOK)
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// let the hooks edit the output, the launcher finds the
+	// payload from the end of the file, its size must not change
+	if err := startStep("Running post-assemble hooks"); err != nil {
+		return err
+	}
+
+	if opts.Hooks.PostAssemble != nil {
+		err = encFile.Sync()
+		if err == nil {
+			err = opts.Hooks.PostAssemble(ctx, workfile)
+		}
+
+		if err != nil {
+			return opts.failStep(opts.timeoutError(ctx, ctx, HookPostAssemble, err))
+		}
+
+		Log.Done(StatusOK)
+	} else {
+		Log.Done(StatusSkip)
+	}
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// Sign what the hooks left, the signature ends the output
+	if err := startStep("Signing output"); err != nil {
+		return err
+	}
+
+	if signingKey != nil {
+		err = encFile.Sync()
+		if err == nil {
+			err = SignOutput(workfile)
+		}
+
+		if err != nil {
+			return opts.failStep(fmt.Errorf("failed signing the output: %w", err))
+		}
+
+		Log.Done(StatusOK)
+	} else {
+		Log.Done(StatusSkip)
+	}
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// Make the output executable
+	if err := startStep("Setting output mode"); err != nil {
+		return err
+	}
+
+	err = SetOutputMode(workfile, infile, opts.PreserveMode)
+	if err != nil {
+		return opts.failStep(fmt.Errorf("failed setting output mode: %w", err))
+	}
+
+	if !opts.SourceDate.IsZero() {
+		err = os.Chtimes(workfile, opts.SourceDate, opts.SourceDate)
+		if err != nil {
+			return opts.failStep(fmt.Errorf("failed setting output time: %w", err))
+		}
+	}
+
+	Log.Done(StatusOK)
+
+	if !hostModes {
+		Log.Warnf("this host has no execute bit, run chmod +x on the output once it is on the target")
+	}
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// Read the output back before it replaces anything, a short write
+	// or a hook breaking it must not leave a corrupt output in place
+	if err := startStep("Verifying output"); err != nil {
+		return err
+	}
+
+	err = encFile.Close()
+	if err != nil {
+		return opts.failStep(fmt.Errorf("failed writing to file: %w", err))
+	}
+
+	err = VerifyOutput(workfile, offset, int64(len(ciphertext)))
+	if err != nil {
+		return opts.failStepWith(ERRVERIFY, err)
+	}
+
+	Log.Done(StatusOK)
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// No secret may survive in plaintext, whatever stage or hook left it
+	if err := startStep("Scanning for leaks"); err != nil {
+		return err
+	}
+
+	if opts.SkipLeakScan {
+		Log.Done(StatusSkip)
+	} else {
+		leaks, err := ScanLeaks(workfile, scannedValues())
+		if err != nil {
+			return opts.failStep(err)
+		}
+
+		if len(leaks) > 0 {
+			found := []string{}
+			for _, leak := range leaks {
+				found = append(found, leak.String())
+			}
+
+			return opts.failStep(&PackError{
+				Err:    fmt.Errorf("%d leaks of the secrets found in the output", len(leaks)),
+				Output: strings.Join(found, "\n"),
+				Hint: "check the hooks, a common value like abcdefgh can be found by chance in the " +
+					"launcher code: use a longer one, or -skip-leak-scan"})
+		}
+
+		Log.Done(StatusOK)
+	}
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// Describe what went into the output, for attestation
+	if err := startStep("Writing manifest"); err != nil {
+		return err
+	}
+
+	manifestWorkfile := ""
+
+	if opts.Manifest != "" {
+		manifestWorkfile, err = createWorkFile(opts.Manifest, 0644)
+		if err == nil {
+			err = WriteManifest(manifestWorkfile, opts, launcherStub, workfile)
+		}
+
+		if err != nil {
+			return opts.failStep(fmt.Errorf("failed writing the manifest: %w", err))
+		}
+
+		Log.Done(StatusOK)
+	} else {
+		Log.Done(StatusSkip)
+	}
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// Everything succeeded, replace the output and the manifest
+	if err := startStep("Moving output into place"); err != nil {
+		return err
+	}
+
+	err = commitWorkFile(workfile, outfile)
+
+	if err == nil && manifestWorkfile != "" {
+		err = commitWorkFile(manifestWorkfile, opts.Manifest)
+	}
+
+	if err != nil {
+		return opts.failStep(err)
+	}
+
+	Log.Done(StatusOK)
+	// ------------------------------------------------------------------------
+
+	// ------------------------------------------------------------------------
+	// Keep the evidence of the pack in the local history, once the output is in place
+	if err := startStep("Appending audit record"); err != nil {
+		return err
+	}
+
+	if opts.AuditLog != "" {
+		// the output is in place already, the pack succeeded without its record
+		err = appendAudit(opts, outfile, nil)
+		if err != nil {
+			Log.Done(StatusErr)
+			Log.Warnf("the audit log %s has no record of this pack: %s", opts.AuditLog, err)
+		} else {
+			Log.Done(StatusOK)
+		}
+	} else {
+		Log.Done(StatusSkip)
+	}
+
+	// printed once and never logged to -log-file nor to the manifest
+	if recoveryKey != nil {
+		fmt.Fprintf(Log.Output, "recovery key: %x\n", recoveryKey)
+	}
+	// ------------------------------------------------------------------------
+
+	return nil
 }
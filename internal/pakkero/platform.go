@@ -0,0 +1,56 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Target platform library
+*/
+package pakkero
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Platforms the launcher can run on, both are linux kernels
+const (
+	PlatformLinux   = "linux"
+	PlatformAndroid = "android"
+)
+
+// architectures of the android devices and emulators
+var androidArchs = []string{"386", "amd64", "arm", "arm64"}
+
+// default architecture of the platforms, the others use the host one
+var platformArchs = map[string]string{
+	PlatformAndroid: "arm64",
+}
+
+/*
+ResolvePlatform will check the options against the platform and fill in
+its defaults, archSet is true if the architecture was chosen explicitly.
+*/
+func ResolvePlatform(opts *Options, archSet bool) error {
+	switch opts.Platform {
+	case PlatformLinux:
+		return nil
+	case PlatformAndroid:
+	default:
+		return fmt.Errorf("unknown platform %q, platforms are: %s, %s",
+			opts.Platform, PlatformLinux, PlatformAndroid)
+	}
+
+	if !archSet {
+		opts.Arch = platformArchs[opts.Platform]
+	}
+
+	if !Contains(androidArchs, opts.Arch) {
+		return fmt.Errorf("android runs on %s, not on %s",
+			strings.Join(androidArchs, ", "), opts.Arch)
+	}
+
+	if opts.Daemonize {
+		return errors.New("-daemonize does not work on android: " +
+			"processes detached from an app or a shell are killed")
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Policy library
+*/
+package pakkero
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+PolicyRule is a combination of options that packs fine but weakens the
+protection of the output: a fatal rule fails the pack, the other ones
+warn, unless allowed, see CheckPolicy.
+*/
+type PolicyRule struct {
+	ID string
+	// what is weakened, shown with the ID
+	Summary string
+	Fatal   bool
+	// true if the options fire the rule
+	Match func(opts Options) bool
+}
+
+// PolicyRules are the rules checked before packing, in ID order
+var PolicyRules = []PolicyRule{
+	{
+		ID:      "PK001",
+		Summary: "-launcher-debug with -license-pubkey, -register-host or -launch-token-key: a launcher logging its checks holds production keys",
+		Fatal:   true,
+		Match: func(opts Options) bool {
+			return opts.LauncherDebug && (len(opts.LicensePubKeys) > 0 || opts.RegisterHost != "" ||
+				opts.LaunchTokenKey != "")
+		},
+	},
+	{
+		ID:      "PK002",
+		Summary: "-explain report in the directory of the output: it may be shipped with it",
+		Match: func(opts Options) bool {
+			outfile := opts.OutFile
+			if outfile == "" {
+				outfile = opts.InFile + ".enc"
+			}
+
+			return opts.Explain != "" && sameDir(opts.Explain, outfile)
+		},
+	},
+	{
+		ID:      "PK003",
+		Summary: "-explain-unsafe: the report and the diff hold the secrets in plaintext",
+		Match: func(opts Options) bool {
+			return opts.ExplainUnsafe
+		},
+	},
+	{
+		ID:      "PK004",
+		Summary: "-reproducible: the offset, the garbage and the nonce follow the -identity-seed, never reuse it across releases",
+		Match: func(opts Options) bool {
+			return opts.Reproducible
+		},
+	},
+	{
+		ID:      "PK005",
+		Summary: "-register-host-policy warn: the payload runs on hosts the record is not of",
+		Match: func(opts Options) bool {
+			return opts.RegisterHost != "" && opts.RegisterHostPolicy == RegistrationWarn
+		},
+	},
+	{
+		ID:      "PK006",
+		Summary: "-capture-tee: the encrypted payload output is shown in clear on the launcher stdout and stderr",
+		Match: func(opts Options) bool {
+			return opts.CaptureOutput != "" && opts.CaptureTee
+		},
+	},
+	{
+		ID:      "PK007",
+		Summary: "-repackable: the offset, that derives the key, can be read from the output with the pakkero sources",
+		Match: func(opts Options) bool {
+			return opts.Repackable
+		},
+	},
+	{
+		ID:      "PK008",
+		Summary: "-override-pubkey: a token signed by its key relaxes the host checks of every launcher packed with it",
+		Match: func(opts Options) bool {
+			return opts.OverridePubKey != ""
+		},
+	},
+}
+
+// sameDir returns true if the two files are in the same directory
+func sameDir(a string, b string) bool {
+	dirA, errA := filepath.Abs(filepath.Dir(a))
+	dirB, errB := filepath.Abs(filepath.Dir(b))
+
+	return errA == nil && errB == nil && dirA == dirB
+}
+
+// PolicyRuleIDs returns the IDs of the PolicyRules
+func PolicyRuleIDs() []string {
+	ids := []string{}
+	for _, rule := range PolicyRules {
+		ids = append(ids, rule.ID)
+	}
+
+	sort.Strings(ids)
+
+	return ids
+}
+
+// CheckPolicyAllow returns an error if an allowed rule does not exist
+func CheckPolicyAllow(allow []string) error {
+	ids := PolicyRuleIDs()
+
+	for _, id := range allow {
+		if !Contains(ids, id) {
+			return fmt.Errorf("unknown rule %q, rules are: %s", id, strings.Join(ids, ", "))
+		}
+	}
+
+	return nil
+}
+
+/*
+CheckPolicy returns the rules fired by the options and not in their
+PolicyAllow, and an error if one of them is fatal, or any with
+PolicyStrict. An allowed rule is never reported, fatal ones included.
+*/
+func CheckPolicy(opts Options) ([]PolicyRule, error) {
+	fired := []PolicyRule{}
+	failed := []string{}
+
+	for _, rule := range PolicyRules {
+		if Contains(opts.PolicyAllow, rule.ID) || !rule.Match(opts) {
+			continue
+		}
+
+		fired = append(fired, rule)
+
+		if rule.Fatal || opts.PolicyStrict {
+			failed = append(failed, rule.ID)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fired, fmt.Errorf("policy rules %s failed, allow them with -allow if intended",
+			strings.Join(failed, ", "))
+	}
+
+	return fired, nil
+}
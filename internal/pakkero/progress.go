@@ -0,0 +1,76 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Progress library
+*/
+package pakkero
+
+import (
+	"io"
+)
+
+/*
+Progress is told how far the long stages of the packing are: Stage
+when one starts, then Step with the bytes done out of the total, growing
+up to the total, that is always the last Step of a stage.
+It is called from the packing goroutine only.
+*/
+type Progress interface {
+	Stage(name string)
+	Step(done, total int64)
+}
+
+// Progress stages, a stage can run more than once, once per blob
+const (
+	ProgressScrub    = "scrubbing"
+	ProgressCompress = "compressing"
+	ProgressEncrypt  = "encrypting"
+	ProgressAssemble = "assembling"
+)
+
+// bytes processed between two steps, a power of two
+const progressChunk = 1 << 20
+
+// progress of the current packing, see Options.Progress
+var progress Progress
+
+// progressStage starts a stage, if anybody listens
+func progressStage(name string) {
+	if progress != nil {
+		progress.Stage(name)
+	}
+}
+
+// progressStep reports a step of the current stage, if anybody listens
+func progressStep(done int64, total int64) {
+	if progress != nil {
+		progress.Step(done, total)
+	}
+}
+
+// writeProgress writes content in chunks, as a ProgressAssemble stage
+func writeProgress(writer io.Writer, content string) error {
+	progressStage(ProgressAssemble)
+
+	total := int64(len(content))
+
+	for done := int64(0); done < total; {
+		end := done + progressChunk
+		if end > total {
+			end = total
+		}
+
+		_, err := io.WriteString(writer, content[done:end])
+		if err != nil {
+			return err
+		}
+
+		done = end
+		progressStep(done, total)
+	}
+
+	if total == 0 {
+		progressStep(0, 0)
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package pakkero
+
+import (
+	"bytes"
+	"testing"
+)
+
+// recordedProgress records the stages and the steps it is told
+type recordedProgress struct {
+	stages []string
+	steps  [][2]int64
+}
+
+func (p *recordedProgress) Stage(name string) {
+	p.stages = append(p.stages, name)
+}
+
+func (p *recordedProgress) Step(done, total int64) {
+	p.steps = append(p.steps, [2]int64{done, total})
+}
+
+// recordProgress records the progress until the end of the test
+func recordProgress(t *testing.T) *recordedProgress {
+	t.Helper()
+
+	recorded := &recordedProgress{}
+	progress = recorded
+
+	t.Cleanup(func() { progress = nil })
+
+	return recorded
+}
+
+func TestWriteProgress(t *testing.T) {
+	for _, size := range []int{0, 10, progressChunk, 2*progressChunk + 1} {
+		recorded := recordProgress(t)
+		content := string(bytes.Repeat([]byte{'a'}, size))
+		output := &bytes.Buffer{}
+
+		err := writeProgress(output, content)
+		if err != nil || output.String() != content {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+
+		if len(recorded.stages) != 1 || recorded.stages[0] != ProgressAssemble {
+			t.Errorf("%d bytes, stages: %v", size, recorded.stages)
+		}
+
+		// growing up to the total, that is the last step
+		last := recorded.steps[len(recorded.steps)-1]
+		if last != [2]int64{int64(size), int64(size)} {
+			t.Errorf("%d bytes, last step: %v", size, last)
+		}
+
+		for index := 1; index < len(recorded.steps); index++ {
+			if recorded.steps[index][0] <= recorded.steps[index-1][0] {
+				t.Errorf("%d bytes, steps: %v", size, recorded.steps)
+			}
+		}
+	}
+}
+
+func TestCompressProgress(t *testing.T) {
+	recorded := recordProgress(t)
+	input := bytes.Repeat([]byte("pakkero"), progressChunk/2)
+
+	compressWith(zlibCodec{}, input)
+
+	if len(recorded.stages) != 1 || recorded.stages[0] != ProgressCompress {
+		t.Errorf("stages: %v", recorded.stages)
+	}
+
+	if last := recorded.steps[len(recorded.steps)-1]; last != [2]int64{int64(len(input)), int64(len(input))} {
+		t.Errorf("last step: %v", last)
+	}
+}
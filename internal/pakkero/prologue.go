@@ -0,0 +1,52 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Prologue library
+*/
+package pakkero
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+)
+
+const prologueStartPlaceholder = `"PROLOGUESTART"`
+const prologueSizePlaceholder = `"PROLOGUESIZE"`
+
+// encodePrologue returns the prologue encoded and compressed on its own, like the payload
+func encodePrologue(prologue string) ([]byte, error) {
+	byteContent, err := ioutil.ReadFile(prologue)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading prologue: %s", err)
+	}
+
+	return CompressContent([]byte(base64.StdEncoding.EncodeToString(byteContent))), nil
+}
+
+/*
+RegisterPrologue will read and compress the prologue, and register its
+position in the launcher: the prologue is placed right before end,
+inside the pre-payload garbage, ahead of the libraries and the decoy.
+The launcher runs it to completion before decrypting the payload.
+Returns the compressed prologue and its starting position.
+*/
+func RegisterPrologue(prologue string, end int64) ([]byte, int64, error) {
+	plaintext, err := encodePrologue(prologue)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	size := int64(len(plaintext) + gcmOverhead)
+
+	start := end - size
+	if start <= 0 {
+		return nil, 0, fmt.Errorf("prologue is bigger than the offset: prologue=%d, space=%d", size, end)
+	}
+
+	Secrets[prologueStartPlaceholder] = []string{fmt.Sprintf("%d", start),
+		GenerateTyposquatName()}
+	Secrets[prologueSizePlaceholder] = []string{fmt.Sprintf("%d", size),
+		GenerateTyposquatName()}
+
+	return plaintext, start, nil
+}
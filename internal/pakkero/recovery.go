@@ -0,0 +1,111 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Recovery library
+*/
+package pakkero
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/89luca89/pakkero/artifact"
+)
+
+/*
+The outputs packed with -recoverable carry, right after the marker,
+the offset of the payload sealed with a random key, see
+artifact.SealRecoveryRecord. The key derived from what is before the
+offset cannot be wrapped there, it covers the record too: the offset
+derives it. Without the recovery key the record is random bytes in the
+garbage, like the other outputs have in its place.
+*/
+const recoveryRecordSize = artifact.RecoveryRecordSize
+
+// RecoveryKeySize is the size of the AES-256 key of the recovery record
+const RecoveryKeySize = artifact.RecoveryKeySize
+
+/*
+GenerateRecoveryRecord returns the recovery record of the payload at
+offset and the key sealing it, never written anywhere else.
+*/
+func GenerateRecoveryRecord(offset int64) ([]byte, []byte, error) {
+	key := make([]byte, RecoveryKeySize)
+	nonce := make([]byte, 12)
+
+	err := randomRead(key)
+	if err == nil {
+		err = randomRead(nonce)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	record, err := artifact.SealRecoveryRecord(key, nonce, offset)
+
+	return record, key, err
+}
+
+// ParseRecoveryKey will parse a recovery key, as printed when packing
+func ParseRecoveryKey(input string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(input))
+	if err != nil || len(key) != RecoveryKeySize {
+		return nil, fmt.Errorf("invalid recovery key, it is %d hex digits", RecoveryKeySize*2)
+	}
+
+	return key, nil
+}
+
+/*
+Extract will write to outfile the payload of a file packed with
+-recoverable, given the recovery key printed when packing it.
+The offset is read from the recovery record, the payload is then
+decrypted like the launcher does, see artifact.FrameReader. A wrong
+key, or an output that is not recoverable, fails the same way: the
+record is garbage for it.
+*/
+func Extract(packed string, key []byte, outfile string) error {
+	startLifecycle()
+
+	file, err := os.Open(packed)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	payload, err := artifact.FrameReader(file, key)
+
+	switch {
+	case errors.Is(err, artifact.ErrNotArtifact):
+		return fmt.Errorf("%s is not packed by pakkero", packed)
+	case err != nil:
+		return fmt.Errorf("%s: %w", packed, err)
+	}
+
+	workfile, err := createWorkFile(outfile, outputMode)
+	if err != nil {
+		return err
+	}
+
+	output, err := os.OpenFile(workfile, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+
+	_, err = io.Copy(output, payload)
+	if err != nil {
+		return fmt.Errorf("the payload of %s does not decode: %w", packed, err)
+	}
+
+	err = output.Close()
+	if err != nil {
+		return err
+	}
+
+	return commitWorkFile(workfile, outfile)
+}
@@ -0,0 +1,72 @@
+package pakkero
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseRecoveryKey(t *testing.T) {
+	key := strings.Repeat("ab", RecoveryKeySize)
+
+	parsed, err := ParseRecoveryKey(" " + key + "\n")
+	if err != nil || hex.EncodeToString(parsed) != key {
+		t.Errorf("key %x, error %v", parsed, err)
+	}
+
+	for _, input := range []string{"", key[2:], key + "ab", "zz" + key[2:]} {
+		_, err = ParseRecoveryKey(input)
+		if err == nil || !strings.Contains(err.Error(), "invalid recovery key") {
+			t.Errorf("%q: error %v", input, err)
+		}
+	}
+}
+
+/*
+The payload of a recoverable output is extracted with its recovery key
+only, the one of a repackable output with no key; the other outputs
+and keys fail the same way.
+*/
+func TestExtract(t *testing.T) {
+	payload := "#!/bin/sh\necho payload\n"
+	recoverable, _, key := packArtifact(t, payload, Codecs["gzip"], false, true)
+	repackable, _, _ := packArtifact(t, payload, Codecs["none"], true, false)
+	plain, _, _ := packArtifact(t, payload, Codecs["zlib"], false, false)
+
+	dir := t.TempDir()
+	output := filepath.Join(dir, "payload")
+
+	wrongKey := append([]byte{}, key...)
+	wrongKey[0] ^= 1
+
+	for _, test := range []struct {
+		name    string
+		packed  string
+		key     []byte
+		message string
+	}{
+		{"recoverable", recoverable, key, ""},
+		{"repackable", repackable, nil, ""},
+		{"recoverable without key", recoverable, nil, "not repackable"},
+		{"recoverable with a wrong key", recoverable, wrongKey, recoverable},
+		{"not recoverable", plain, key, plain},
+		{"not packed", output, key, "is not packed by pakkero"},
+	} {
+		err := Extract(test.packed, test.key, output)
+
+		if test.message == "" {
+			content, readErr := ioutil.ReadFile(output)
+			if err != nil || readErr != nil || string(content) != payload {
+				t.Errorf("%s: extracted %q, error %v", test.name, content, err)
+			}
+
+			continue
+		}
+
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%s: error %v, expected %q", test.name, err, test.message)
+		}
+	}
+}
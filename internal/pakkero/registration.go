@@ -0,0 +1,170 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Host registration library
+*/
+package pakkero
+
+import (
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const registrationFilePlaceholder = `"REGISTRATIONFILE"`
+const registrationKeyPlaceholder = `"REGISTRATIONKEY"`
+const registrationExponentPlaceholder = `"REGISTRATIONEXP"`
+const registrationIDPlaceholder = `"REGISTRATIONID"`
+
+// RegistrationMagic starts every registration record
+const RegistrationMagic = "PKR1"
+
+// Registration policies, what the launcher does on a host it is not registered to
+const (
+	RegistrationEnforce = "enforce"
+	RegistrationWarn    = "warn"
+)
+
+// the smallest RSA key, the encrypted claims must fit in a single OAEP block
+const minRegistrationKeyBits = 2048
+
+// longest hostname in the encrypted claims, HOST_NAME_MAX
+const maxRegistrationHostname = 64
+
+/*
+Registration is what the launcher writes when it adopts a host,
+laid out as:
+
+	"PKR1" | verifier, 32 bytes | RSA-OAEP-SHA256 of the claims
+
+The claims are the build id and the unix time, 8 bytes big endian
+each, the SHA-256 of the machine id and the hostname. The verifier is
+the HMAC-SHA256 of the machine id, keyed with the SHA-256 of the public
+key modulus, the launcher recomputes it at each run.
+*/
+type Registration struct {
+	BuildID       int64
+	Time          time.Time
+	MachineIDHash string
+	Hostname      string
+}
+
+// registrationVerifier returns the verifier of a machine id for a public key
+func registrationVerifier(key *rsa.PublicKey, machineID string) []byte {
+	hmacKey := sha256.Sum256(key.N.Bytes())
+	mac := hmac.New(sha256.New, hmacKey[:])
+	mac.Write([]byte(machineID))
+
+	return mac.Sum(nil)
+}
+
+/*
+ReadRegistrationPublicKey will read an RSA public key in the PEM
+PKIX format, as written by openssl pkey -pubout.
+*/
+func ReadRegistrationPublicKey(path string) (*rsa.PublicKey, error) {
+	der, err := readPEM(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	public, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an RSA public key", path)
+	}
+
+	if public.N.BitLen() < minRegistrationKeyBits {
+		return nil, fmt.Errorf("%s: RSA keys need at least %d bits", path, minRegistrationKeyBits)
+	}
+
+	return public, nil
+}
+
+/*
+ReadRegistrationPrivateKey will read an RSA private key in the PEM
+PKCS8 format, as written by openssl genpkey -algorithm RSA.
+*/
+func ReadRegistrationPrivateKey(path string) (*rsa.PrivateKey, error) {
+	der, err := readPEM(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	private, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an RSA private key", path)
+	}
+
+	return private, nil
+}
+
+/*
+VerifyRegistration returns true if the record was written on the host
+with the given machine id, this is the same logic compiled in the launcher.
+*/
+func VerifyRegistration(content []byte, key *rsa.PublicKey, machineID string) bool {
+	header := len(RegistrationMagic) + sha256.Size
+	if len(content) < header || string(content[:len(RegistrationMagic)]) != RegistrationMagic {
+		return false
+	}
+
+	return hmac.Equal(content[len(RegistrationMagic):header], registrationVerifier(key, machineID))
+}
+
+// DecryptRegistration returns the claims of a registration record
+func DecryptRegistration(content []byte, key *rsa.PrivateKey) (Registration, error) {
+	header := len(RegistrationMagic) + sha256.Size
+	if len(content) < header || string(content[:len(RegistrationMagic)]) != RegistrationMagic {
+		return Registration{}, errors.New("not a registration record")
+	}
+
+	claims, err := rsa.DecryptOAEP(sha256.New(), nil, key, content[header:], nil)
+	if err != nil || len(claims) < 16+sha256.Size {
+		return Registration{}, errors.New("wrong key or corrupted record")
+	}
+
+	return Registration{
+		BuildID:       int64(binary.BigEndian.Uint64(claims)),
+		Time:          time.Unix(int64(binary.BigEndian.Uint64(claims[8:])), 0).UTC(),
+		MachineIDHash: hex.EncodeToString(claims[16 : 16+sha256.Size]),
+		Hostname:      string(claims[16+sha256.Size:]),
+	}, nil
+}
+
+/*
+RegisterRegistration will read the public key and add it to the
+secrets, with the path of the record on the target and the build id,
+so that they will be embedded obfuscated in the launcher.
+*/
+func RegisterRegistration(pubKey string, recordFile string) error {
+	key, err := ReadRegistrationPublicKey(pubKey)
+	if err != nil {
+		return err
+	}
+
+	if !IsTargetAbs(recordFile) {
+		return errors.New("the registration record needs an absolute path")
+	}
+
+	Secrets[registrationKeyPlaceholder] = []string{string(key.N.Bytes()), GenerateTyposquatName()}
+	Secrets[registrationExponentPlaceholder] = []string{fmt.Sprintf("%d", key.E), GenerateTyposquatName()}
+	Secrets[registrationFilePlaceholder] = []string{recordFile, GenerateTyposquatName()}
+	Secrets[registrationIDPlaceholder] = []string{fmt.Sprintf("%d", buildID()), GenerateTyposquatName()}
+
+	return nil
+}
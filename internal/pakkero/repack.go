@@ -0,0 +1,252 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Repacking library
+*/
+package pakkero
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/89luca89/pakkero/artifact"
+)
+
+/*
+The packed files are marked right after the launcher, at the start of
+the garbage, see artifact.SealMarker: without the key the marker is
+random bytes in random bytes, there are no fixed bytes to match. The key
+is in the pakkero sources though: the marker keeps pakkero from packing
+its own output by mistake, it does not hide that a file is packed.
+*/
+const markerSize = artifact.MarkerSize
+
+/*
+GenerateRepackMarker returns a new marker carrying the pakkero version,
+and the offset of the payload if repackable.
+*/
+func GenerateRepackMarker(offset int64, repackable bool) ([]byte, error) {
+	random := make([]byte, 2*artifact.MarkerField)
+
+	err := randomRead(random)
+	if err != nil {
+		return nil, err
+	}
+
+	if !repackable {
+		offset = 0
+	}
+
+	return artifact.SealMarker(random, Version, offset)
+}
+
+/*
+readMarker returns the header of a packed file, and false if the file
+is not a pakkero output.
+*/
+func readMarker(infile string) (artifact.Header, bool) {
+	file, err := os.Open(infile)
+	if err != nil {
+		return artifact.Header{}, false
+	}
+	defer file.Close()
+
+	header, err := artifact.ParseHeader(file)
+
+	return header, err == nil
+}
+
+/*
+DetectRepack returns the version of pakkero that packed the file,
+and false if the file is not a pakkero output.
+*/
+func DetectRepack(infile string) (string, bool) {
+	marker, packed := readMarker(infile)
+
+	return marker.Version, packed
+}
+
+/*
+checkPayload returns an error if the ciphertext is not a payload
+encrypted by EncryptAESReversed with the key derived from prefix, or
+its plaintext is not a stream of its codec, else the codec.
+*/
+func checkPayload(ciphertext []byte, prefix []byte) (Codec, error) {
+	plaintext, err := artifact.OpenFrame(ciphertext, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = DecompressContent(plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	return codecOf(plaintext[0])
+}
+
+// ErrCorruptOutput is returned by VerifyOutput for an output not as written
+var ErrCorruptOutput = errors.New("corrupt output")
+
+/*
+VerifyOutput reads back an output assembled with the payload at offset
+and payloadSize long, and returns an ErrCorruptOutput if its length is
+not the expected one, its marker does not pass its MAC, its signature
+does not verify if it is signed, or its payload does not decrypt with
+the key derived from what is before it. A scattered payload is gathered first, and must not decrypt without its
+fragments.
+*/
+func VerifyOutput(outfile string, offset int64, payloadSize int64) error {
+	content, err := ioutil.ReadFile(outfile)
+	if err != nil {
+		return err
+	}
+
+	size := offset + payloadSize + artifact.FinalPadding(offset)
+	if signingKey != nil {
+		size += artifact.SignatureSize
+	}
+
+	if int64(len(content)) != size {
+		return fmt.Errorf("%w: %s is %d bytes, %d expected", ErrCorruptOutput, outfile, len(content), size)
+	}
+
+	marker, ok := readMarker(outfile)
+	if !ok || (marker.Offset != 0 && marker.Offset != offset) {
+		return fmt.Errorf("%w: %s has no valid marker", ErrCorruptOutput, outfile)
+	}
+
+	if signingKey != nil {
+		err = verifySignature(outfile)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrCorruptOutput, err)
+		}
+	}
+
+	payload := content[offset : offset+payloadSize]
+	if payloadScattering != nil {
+		payload = payloadScattering.gather(content[:offset], payload)
+
+		// carving what is after the offset must not be enough, even with the key
+		_, err = artifact.OpenFrame(content[offset:offset+payloadSize], content[:offset])
+		if err == nil {
+			return fmt.Errorf("%w: the payload after the offset of %s decrypts alone", ErrCorruptOutput, outfile)
+		}
+
+		err = nil
+	}
+
+	if payloadWhitening != nil {
+		err = payloadWhitening.xor(payload, content[:offset])
+	}
+
+	if err == nil {
+		_, err = checkPayload(payload, content[:offset])
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w: the payload of %s does not decrypt: %s", ErrCorruptOutput, outfile, err)
+	}
+
+	return nil
+}
+
+/*
+Repack will write to outfile the packed file with infile as payload:
+the launcher, the garbage, the decoy and the libraries before the
+offset are kept byte for byte, only the payload and the garbage after
+it are new. The key is derived from what is before the offset, so the
+new payload is encrypted with the same one.
+The packed file must be a repackable output of this pakkero version,
+and its payload must decrypt, else the offset is not the right one.
+The new output is verified before it replaces outfile.
+*/
+func Repack(packed string, infile string, outfile string) error {
+	startLifecycle()
+
+	marker, ok := readMarker(packed)
+
+	switch {
+	case !ok:
+		return fmt.Errorf("%s is not packed by pakkero", packed)
+	case marker.Version != Version:
+		return fmt.Errorf("%s is packed by pakkero %s, it can be repacked only by that version",
+			packed, marker.Version)
+	case marker.Offset == 0:
+		return fmt.Errorf("%s is not repackable, pack it with -repackable", packed)
+	}
+
+	content, err := ioutil.ReadFile(packed)
+	if err != nil {
+		return err
+	}
+
+	offset := marker.Offset
+	payloadEnd := int64(len(content)) - artifact.FinalPadding(offset)
+
+	if offset >= payloadEnd {
+		return fmt.Errorf("%s is truncated, no payload after offset %d", packed, offset)
+	}
+
+	prefix := content[:offset]
+
+	// the new payload is compressed with the codec of the old one
+	codec, err := checkPayload(content[offset:payloadEnd], prefix)
+	if err != nil {
+		return fmt.Errorf("the payload of %s does not decrypt: %s", packed, err)
+	}
+
+	err = CheckPayloadFormat(infile)
+	if err != nil {
+		return err
+	}
+
+	byteContent, err := ioutil.ReadFile(infile)
+	if err != nil {
+		return err
+	}
+
+	workfile, err := createWorkFile(outfile, outputMode)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(workfile, prefix, 0)
+	if err != nil {
+		return err
+	}
+
+	plaintext := compressWith(codec, []byte(base64.StdEncoding.EncodeToString(byteContent)))
+
+	ciphertext, err := EncryptAESReversed(plaintext, workfile)
+	if err != nil {
+		return err
+	}
+
+	encFile, err := os.OpenFile(workfile, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer encFile.Close()
+
+	err = writeProgress(encFile, ciphertext)
+	if err == nil {
+		err = writeProgress(encFile, GenerateRandomGarbage(artifact.FinalPadding(offset)))
+	}
+
+	if err == nil {
+		err = encFile.Close()
+	}
+
+	if err == nil {
+		err = VerifyOutput(workfile, offset, int64(len(ciphertext)))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return commitWorkFile(workfile, outfile)
+}
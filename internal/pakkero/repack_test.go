@@ -0,0 +1,360 @@
+package pakkero
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/89luca89/pakkero/artifact"
+)
+
+/*
+The marker is found in the window after the end of an ELF, with the
+version that wrote it, and nowhere else.
+*/
+func TestDetectRepack(t *testing.T) {
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	launcher, err := ioutil.ReadFile(executable)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	marker, err := GenerateRepackMarker(0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := GenerateRepackMarker(0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(marker) == string(other) {
+		t.Error("two markers are the same")
+	}
+
+	corrupted := append([]byte{}, marker...)
+	corrupted[artifact.MarkerField] ^= 1
+
+	garbage := make([]byte, 1000)
+
+	tests := []struct {
+		name     string
+		content  []byte
+		expected bool
+	}{
+		{"plain elf", launcher, false},
+		{"marked elf", append(append(launcher, marker...), garbage...), true},
+		{"marked after the garbage", append(append(append(launcher, garbage...), marker...), garbage...), true},
+		{"marked outside the window", append(append(launcher, make([]byte, artifact.MarkerWindow+artifact.MarkerSize)...), marker...), false},
+		{"corrupted marker", append(launcher, corrupted...), false},
+		{"marked script", append([]byte("#!/bin/sh\n"), marker...), false},
+	}
+
+	dir := t.TempDir()
+
+	for _, test := range tests {
+		payload := filepath.Join(dir, "payload")
+
+		err = ioutil.WriteFile(payload, test.content, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		version, packed := DetectRepack(payload)
+		if packed != test.expected || (packed && version != Version) {
+			t.Errorf("%s: packed %t by %q, expected %t by %q", test.name, packed, version, test.expected, Version)
+		}
+	}
+
+	// the offset is carried by the markers of the repackable outputs only
+	payload := filepath.Join(dir, "payload")
+
+	for _, repackable := range []bool{false, true} {
+		marker, err = GenerateRepackMarker(123456, repackable)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = ioutil.WriteFile(payload, append(launcher, marker...), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		header, packed := readMarker(payload)
+		if !packed || (header.Offset == 123456) != repackable {
+			t.Errorf("repackable %t: packed %t at offset %d", repackable, packed, header.Offset)
+		}
+	}
+
+	// the end of the ELF is found, whatever follows it
+	err = ioutil.WriteFile(payload, []byte("#!/bin/sh\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	_, err = artifact.ELFEnd(file)
+	if err == nil {
+		t.Error("a script has an ELF end")
+	}
+
+	err = ioutil.WriteFile(payload, append(launcher, garbage...), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err = os.Open(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	end, err := artifact.ELFEnd(file)
+	if err != nil || end != int64(len(launcher)) {
+		t.Errorf("ELF end %d, error %v, expected %d", end, err, len(launcher))
+	}
+}
+
+/*
+packArtifact writes an output as a pack does, with the executable of
+the test as launcher: the marker, the recovery record if recoverable,
+the garbage up to the offset, the payload encrypted with the key
+derived from what is before it and the final padding. Returns the
+output, the offset and the recovery key.
+*/
+func packArtifact(t *testing.T, payload string, codec Codec, repackable bool, recoverable bool) (string, int64, []byte) {
+	t.Helper()
+
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	launcher, err := ioutil.ReadFile(executable)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offset := int64(len(launcher) + markerSize + 4096)
+
+	marker, err := GenerateRepackMarker(offset, repackable)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	garbage := []byte(GenerateRandomGarbage(4096))
+
+	var recoveryKey []byte
+
+	if recoverable {
+		var record []byte
+
+		record, recoveryKey, err = GenerateRecoveryRecord(offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		copy(garbage, record)
+	}
+
+	prefix := append(append(launcher, marker...), garbage...)
+	packed := filepath.Join(t.TempDir(), "packed")
+
+	err = ioutil.WriteFile(packed, prefix, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ciphertext, err := EncryptAESReversed(compressWith(codec,
+		[]byte(base64.StdEncoding.EncodeToString([]byte(payload)))), packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := append(append(prefix, ciphertext...), GenerateRandomGarbage(artifact.FinalPadding(offset))...)
+
+	err = ioutil.WriteFile(packed, content, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = VerifyOutput(packed, offset, int64(len(ciphertext)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return packed, offset, recoveryKey
+}
+
+// openPayload returns the plaintext of the payload of an output, and its codec
+func openPayload(t *testing.T, packed string, offset int64) (string, Codec) {
+	t.Helper()
+
+	content, err := ioutil.ReadFile(packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext, err := artifact.OpenFrame(content[offset:int64(len(content))-artifact.FinalPadding(offset)], content[:offset])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codec, err := codecOf(plaintext[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decompressed, err := DecompressContent(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(string(decompressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(payload), codec
+}
+
+/*
+Repack keeps what is before the offset byte for byte and replaces the
+payload, with the same key and codec. Only a repackable output of this
+version is repacked.
+*/
+func TestRepack(t *testing.T) {
+	packed, offset, _ := packArtifact(t, "#!/bin/sh\necho old\n", Codecs["gzip"], true, false)
+	dir := t.TempDir()
+	payload := filepath.Join(dir, "payload")
+	output := filepath.Join(dir, "output")
+
+	err := ioutil.WriteFile(payload, []byte("#!/bin/sh\necho new\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Repack(packed, payload, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := ioutil.ReadFile(packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(after[:offset]) != string(before[:offset]) {
+		t.Error("what is before the offset changed")
+	}
+
+	repacked, codec := openPayload(t, output, offset)
+	if repacked != "#!/bin/sh\necho new\n" || codec.ID() != CodecIDGzip {
+		t.Errorf("repacked payload %q with codec %d", repacked, codec.ID())
+	}
+
+	// repacked in place
+	err = Repack(output, payload, output)
+	if err != nil {
+		t.Errorf("repacking in place: %s", err)
+	}
+
+	notRepackable, _, _ := packArtifact(t, "#!/bin/sh\n", Codecs["zlib"], false, false)
+
+	truncated := filepath.Join(dir, "truncated")
+
+	err = ioutil.WriteFile(truncated, before[:offset], 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for input, message := range map[string]string{
+		payload:       "is not packed by pakkero",
+		notRepackable: "pack it with -repackable",
+		truncated:     "is truncated",
+	} {
+		err = Repack(input, payload, filepath.Join(dir, "failed"))
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("error %v, expected %q", err, message)
+		}
+	}
+
+	version := Version
+	defer func() { Version = version }()
+
+	Version = "0.0.1"
+
+	err = Repack(packed, payload, filepath.Join(dir, "failed"))
+	if err == nil || !strings.Contains(err.Error(), "packed by pakkero "+version) {
+		t.Errorf("repacking the output of another version: %v", err)
+	}
+
+	if _, err = os.Stat(filepath.Join(dir, "failed")); !os.IsNotExist(err) {
+		t.Errorf("a failed repack left its output: %v", err)
+	}
+}
+
+// an output not as written is corrupt, whatever part of it changed
+func TestVerifyOutput(t *testing.T) {
+	packed, offset, _ := packArtifact(t, "#!/bin/sh\necho payload\n", Codecs["zlib"], true, false)
+
+	content, err := ioutil.ReadFile(packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payloadSize := int64(len(content)) - offset - artifact.FinalPadding(offset)
+
+	header, _ := readMarker(packed)
+	output := filepath.Join(t.TempDir(), "output")
+
+	for name, modify := range map[string]func([]byte) []byte{
+		"truncated":    func(content []byte) []byte { return content[:len(content)-1] },
+		"marker":       func(content []byte) []byte { content[header.Marker] ^= 1; return content },
+		"payload":      func(content []byte) []byte { content[offset+payloadSize/2] ^= 1; return content },
+		"launcher":     func(content []byte) []byte { content[offset/2] ^= 1; return content },
+		"appended":     func(content []byte) []byte { return append(content, 0) },
+		"payload size": func(content []byte) []byte { return content },
+	} {
+		modified := modify(append([]byte{}, content...))
+
+		err = ioutil.WriteFile(output, modified, 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		size := payloadSize
+		if name == "payload size" {
+			size--
+		}
+
+		err = VerifyOutput(output, offset, size)
+		if !errors.Is(err, ErrCorruptOutput) {
+			t.Errorf("%s: error %v, expected %s", name, err, ErrCorruptOutput)
+		}
+	}
+
+	err = VerifyOutput(packed, offset, payloadSize)
+	if err != nil {
+		t.Errorf("the output as written: %s", err)
+	}
+}
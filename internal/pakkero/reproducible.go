@@ -0,0 +1,78 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Reproducible packing library
+*/
+package pakkero
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	mathRand "math/rand"
+	"strconv"
+	"time"
+)
+
+/*
+randomSource takes every random choice of the packing: names, offset,
+garbage and shuffles. It is seeded once from the time, or from the seed
+of a reproducible packing, see SetRandomSeed.
+*/
+var randomSource = mathRand.New(mathRand.NewSource(time.Now().UnixNano()))
+
+// true if the random choices follow a seed
+var reproducible bool
+
+/*
+SetRandomSeed makes every random choice of the packing follow the seed,
+the same seed, payload and options always give the same output.
+The garbage and the nonce are derived from the seed too, so the seed
+has to be kept as secret as the offset.
+*/
+func SetRandomSeed(seed int64) {
+	randomSource = mathRand.New(mathRand.NewSource(seed))
+	reproducible = true
+}
+
+/*
+randomRead fills the buffer with random bytes, from crypto/rand
+unless the packing is reproducible.
+*/
+func randomRead(buf []byte) error {
+	if reproducible {
+		_, err := randomSource.Read(buf)
+		return err
+	}
+
+	_, err := rand.Read(buf)
+
+	return err
+}
+
+/*
+ParseSourceDateEpoch will parse a SOURCE_DATE_EPOCH, the unix time
+to use in place of the current one, see reproducible-builds.org.
+An empty value returns the zero time.
+*/
+func ParseSourceDateEpoch(input string) (time.Time, error) {
+	if input == "" {
+		return time.Time{}, nil
+	}
+
+	epoch, err := strconv.ParseInt(input, 10, 64)
+	if err != nil || epoch < 0 {
+		return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q, use a unix time", input)
+	}
+
+	return time.Unix(epoch, 0).UTC(), nil
+}
+
+// CheckReproducible returns an error if a reproducible packing has no seed
+func CheckReproducible(seed int64) error {
+	if seed == 0 {
+		return errors.New("-reproducible needs a non-zero -identity-seed, " +
+			"every random choice follows it")
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package pakkero
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSourceDateEpoch(t *testing.T) {
+	date, err := ParseSourceDateEpoch("1700000000")
+	if err != nil || !date.Equal(time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)) {
+		t.Errorf("date %s, error %v", date, err)
+	}
+
+	date, err = ParseSourceDateEpoch("")
+	if err != nil || !date.IsZero() {
+		t.Errorf("an empty epoch gives %s, error %v", date, err)
+	}
+
+	for _, input := range []string{"-1", "yesterday", "1.5"} {
+		_, err = ParseSourceDateEpoch(input)
+		if err == nil || !strings.Contains(err.Error(), "invalid SOURCE_DATE_EPOCH") {
+			t.Errorf("%s: error %v", input, err)
+		}
+	}
+
+	if CheckReproducible(0) == nil || CheckReproducible(42) != nil {
+		t.Error("a reproducible packing needs a non-zero seed")
+	}
+}
+
+// seeded runs the packing step with every random choice following the seed
+func seeded(t *testing.T, seed int64, step func() []byte) []byte {
+	t.Helper()
+
+	source := randomSource
+	defer func() { randomSource, reproducible = source, false }()
+
+	keepSecrets(t)
+	SetRandomSeed(seed)
+
+	return step()
+}
+
+/*
+The same seed gives the same launcher source and the same ciphertext,
+a different seed does not.
+*/
+func TestReproduciblePacking(t *testing.T) {
+	launcher := func() []byte {
+		content, err := ioutil.ReadFile(obfuscatedLauncher(t, Options{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return content
+	}
+
+	stub := filepath.Join(t.TempDir(), "stub")
+
+	err := ioutil.WriteFile(stub, []byte("launcher"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypt := func() []byte {
+		ciphertext, err := EncryptAESReversed([]byte("payload"), stub)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return append([]byte(ciphertext), strings.Join(ShuffleSlice([]string{"a", "b", "c", "d", "e"}), "")...)
+	}
+
+	for name, step := range map[string]func() []byte{"launcher": launcher, "ciphertext": encrypt} {
+		first := seeded(t, 1, step)
+
+		if !bytes.Equal(first, seeded(t, 1, step)) {
+			t.Errorf("the %s changes with the same seed", name)
+		}
+
+		if bytes.Equal(first, seeded(t, 2, step)) {
+			t.Errorf("the %s is the same with another seed", name)
+		}
+	}
+
+	if reproducible {
+		t.Error("the packing is still reproducible")
+	}
+}
+
+// the manifest of a reproducible packing is created at SOURCE_DATE_EPOCH
+func TestManifestSourceDate(t *testing.T) {
+	dir := t.TempDir()
+	payload := filepath.Join(dir, "payload")
+	manifest := filepath.Join(dir, "manifest.json")
+
+	err := ioutil.WriteFile(payload, []byte("payload"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	date, err := ParseSourceDateEpoch("1700000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = WriteManifest(manifest, Options{InFile: payload, SourceDate: date}, nil, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := ReadManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Created.Equal(date) {
+		t.Errorf("created %s, expected %s", result.Created, date)
+	}
+}
@@ -0,0 +1,175 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Resource limits library
+*/
+package pakkero
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policies for limits above the hard limit of the launcher
+const (
+	RlimitFail  = "fail"
+	RlimitClamp = "clamp"
+)
+
+const rlimitsPlaceholder = `"RLIMITS"`
+
+// linux resource numbers, see getrlimit(2)
+var rlimitResources = map[string]int{
+	"cpu":        0,
+	"fsize":      1,
+	"data":       2,
+	"stack":      3,
+	"core":       4,
+	"rss":        5,
+	"nproc":      6,
+	"nofile":     7,
+	"memlock":    8,
+	"as":         9,
+	"locks":      10,
+	"sigpending": 11,
+	"msgqueue":   12,
+	"nice":       13,
+	"rtprio":     14,
+	"rttime":     15,
+}
+
+// resources measured in bytes, they accept the K, M, G and T suffixes
+var rlimitSizes = []string{"fsize", "data", "stack", "core", "rss", "memlock", "as", "msgqueue"}
+
+var rlimitSuffixes = map[string]uint64{
+	"K": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+	"T": 1 << 40,
+}
+
+// RlimitInfinity is the value of an unlimited resource
+const RlimitInfinity = math.MaxUint64
+
+// Rlimit is a resource limit to apply to the payload
+type Rlimit struct {
+	Name     string
+	Resource int
+	Soft     uint64
+	Hard     uint64
+}
+
+/*
+parseRlimitValue will parse the value of a limit: unlimited, a number,
+a size with a K, M, G or T suffix for the resources in bytes or a
+duration for cpu (seconds if there is no unit).
+*/
+func parseRlimitValue(name string, input string) (uint64, error) {
+	if input == "unlimited" {
+		return RlimitInfinity, nil
+	}
+
+	if name == "cpu" {
+		if _, err := strconv.ParseUint(input, 10, 64); err != nil {
+			duration, err := time.ParseDuration(input)
+			if err != nil || duration < time.Second {
+				return 0, fmt.Errorf("invalid cpu time %q, use seconds or a duration like 5m", input)
+			}
+
+			return uint64(duration / time.Second), nil
+		}
+	}
+
+	multiplier := uint64(1)
+
+	if Contains(rlimitSizes, name) {
+		for suffix, value := range rlimitSuffixes {
+			if strings.HasSuffix(strings.ToUpper(input), suffix) {
+				multiplier = value
+				input = input[:len(input)-1]
+			}
+		}
+	}
+
+	value, err := strconv.ParseUint(input, 10, 64)
+	if err != nil || value > RlimitInfinity/multiplier {
+		return 0, fmt.Errorf("invalid value %q for %s", input, name)
+	}
+
+	return value * multiplier, nil
+}
+
+/*
+ParseRlimits will parse a list of name=value or name=soft:hard limits,
+a single value sets both the soft and the hard limit.
+*/
+func ParseRlimits(input []string) ([]Rlimit, error) {
+	result := []Rlimit{}
+	seen := map[string]bool{}
+
+	for _, item := range input {
+		pair := strings.SplitN(item, "=", 2)
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("invalid limit %q, expected name=value", item)
+		}
+
+		name := strings.ToLower(pair[0])
+
+		resource, ok := rlimitResources[name]
+		if !ok {
+			names := []string{}
+			for known := range rlimitResources {
+				names = append(names, known)
+			}
+
+			sort.Strings(names)
+
+			return nil, fmt.Errorf("unknown limit %q, limits are: %s", name, strings.Join(names, ", "))
+		}
+
+		if seen[name] {
+			return nil, fmt.Errorf("limit %s given twice", name)
+		}
+
+		seen[name] = true
+		values := strings.SplitN(pair[1], ":", 2)
+
+		soft, err := parseRlimitValue(name, values[0])
+		if err != nil {
+			return nil, err
+		}
+
+		hard := soft
+
+		if len(values) == 2 {
+			hard, err = parseRlimitValue(name, values[1])
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if soft > hard {
+			return nil, fmt.Errorf("the soft limit of %s is greater than the hard one", name)
+		}
+
+		result = append(result, Rlimit{Name: name, Resource: resource, Soft: soft, Hard: hard})
+	}
+
+	return result, nil
+}
+
+/*
+RegisterRlimits will add the limits to the secrets,
+as a comma separated list of resource:soft:hard.
+*/
+func RegisterRlimits(limits []Rlimit) {
+	items := []string{}
+	for _, limit := range limits {
+		items = append(items, fmt.Sprintf("%d:%d:%d", limit.Resource, limit.Soft, limit.Hard))
+	}
+
+	Secrets[rlimitsPlaceholder] = []string{strings.Join(items, ","), GenerateTyposquatName()}
+}
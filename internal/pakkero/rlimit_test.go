@@ -0,0 +1,117 @@
+package pakkero
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestParseRlimits(t *testing.T) {
+	limits, err := ParseRlimits([]string{"nofile=1024", "AS=2G", "cpu=5m", "stack=8M:unlimited", "core=0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Rlimit{
+		{"nofile", 7, 1024, 1024},
+		{"as", 9, 2 << 30, 2 << 30},
+		{"cpu", 0, 300, 300},
+		{"stack", 3, 8 << 20, RlimitInfinity},
+		{"core", 4, 0, 0},
+	}
+
+	if fmt.Sprint(limits) != fmt.Sprint(expected) {
+		t.Errorf("limits %v, expected %v", limits, expected)
+	}
+
+	for input, message := range map[string]string{
+		"files=10":        "unknown limit",
+		"nofile":          "expected name=value",
+		"nofile=1K":       "invalid value",
+		"cpu=10ms":        "invalid cpu time",
+		"as=4G:2G":        "greater than the hard one",
+		"as=99999999999T": "invalid value",
+	} {
+		_, err := ParseRlimits(strings.Split(input, " "))
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("%s: error %v, expected %q", input, err, message)
+		}
+	}
+
+	_, err = ParseRlimits([]string{"nofile=1", "NOFILE=2"})
+	if err == nil || !strings.Contains(err.Error(), "given twice") {
+		t.Errorf("a limit given twice: %v", err)
+	}
+}
+
+/*
+The payload inherits the limits set by obSetLimits. A limit that cannot
+be raised, a number of files above the kernel maximum even for root,
+does not start it, unless the clamp policy lowers it to the hard limit.
+*/
+func TestLauncherSetLimits(t *testing.T) {
+	var files syscall.Rlimit
+
+	err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hard := strconv.FormatUint(files.Max, 10)
+
+	tests := []struct {
+		limits   []string
+		policy   string
+		expected string
+	}{
+		{[]string{"nofile=64:128", "cpu=5m"}, RlimitFail, "64 128 300\nexit 0\n"},
+		{[]string{"nofile=unlimited"}, RlimitFail, "exit 126\n"},
+		{[]string{"nofile=unlimited"}, RlimitClamp, hard + " " + hard + " unlimited\nexit 0\n"},
+	}
+
+	for _, test := range tests {
+		keepSecrets(t)
+
+		limits, err := ParseRlimits(test.limits)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		RegisterRlimits(limits)
+
+		features := Options{Rlimits: limits, RlimitPolicy: test.policy}.launcherFeatures()
+		program := StripFeatures(`package main
+
+import (
+	"fmt"
+	obOS "os"
+	obExec "os/exec"
+	obStrconv "strconv"
+	obStrings "strings"
+	obSyscall "syscall"
+)
+
+`+templateDecl(t, "obExitCannotExec")+"\n\n"+templateFunction(t, "obSetLimits")+`
+
+func main() {
+	if obOS.Getenv("LIMITS_CHILD") != "" {
+		obSetLimits()
+		obSyscall.Exec("/bin/sh", []string{"sh", "-c", "echo $(ulimit -Sn) $(ulimit -Hn) $(ulimit -St)"}, obOS.Environ())
+	}
+
+	obCommand := obExec.Command("/proc/self/exe")
+	obCommand.Env = append(obOS.Environ(), "LIMITS_CHILD=1")
+	obCommand.Stdout = obOS.Stdout
+	obCommand.Run()
+	fmt.Println("exit", obCommand.ProcessState.ExitCode())
+}
+`, features)
+
+		output := runProgram(t, strings.ReplaceAll(program, rlimitsPlaceholder, strconv.Quote(Secrets[rlimitsPlaceholder][0])))
+		if output != test.expected {
+			t.Errorf("%s with %s: %q, expected %q", test.limits, test.policy, output, test.expected)
+		}
+	}
+}
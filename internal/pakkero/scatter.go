@@ -0,0 +1,337 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Payload layout library
+*/
+package pakkero
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/89luca89/pakkero/artifact"
+)
+
+const scatterMapPlaceholder = `"SCATTERMAP"`
+const scatterSaltPlaceholder = `"SCATTERSALT"`
+
+// LayoutContiguous and LayoutScatter are the layouts of the payload in the output
+const (
+	LayoutContiguous = "contiguous"
+	LayoutScatter    = "scatter"
+)
+
+// Layouts are the layouts of the payload, the default one first
+var Layouts = []string{LayoutContiguous, LayoutScatter}
+
+const (
+	// most fragments of the payload before the offset
+	maxFragments = 16
+	// least size of a fragment, smaller gaps of the launcher are left alone
+	minFragment = 64
+	// the map is the count and the start and size of each fragment, 8 bytes each
+	scatterMapSize = 8 + maxFragments*16
+)
+
+// Fragment is a part of the encrypted payload placed before the offset
+type Fragment struct {
+	Start int64
+	Size  int64
+}
+
+/*
+scattering is the layout of a scattered payload: its fragments before
+the offset, in the order of the ciphertext, the rest is after the
+offset. Their map is at the end of the garbage, masked with the
+AES-256-CTR keystream of the salt: it is before the offset, the key is
+derived from it as from the launcher.
+*/
+type scattering struct {
+	mapStart  int64
+	salt      int64
+	fragments []Fragment
+}
+
+// payloadScattering is the layout of the payload, nil if it is contiguous
+var payloadScattering *scattering
+
+/*
+RegisterScatter will place the map of the fragments right before the
+blobs starting at blobsStart, and add its position to the secrets with
+the salt masking it, the build id, so that they will be embedded
+obfuscated in the launcher.
+Returns the new start of the blobs, the map is one of them.
+*/
+func RegisterScatter(blobsStart int64) int64 {
+	payloadScattering = &scattering{mapStart: blobsStart - scatterMapSize, salt: buildID()}
+
+	Secrets[scatterMapPlaceholder] = []string{fmt.Sprintf("%d", payloadScattering.mapStart),
+		GenerateTyposquatName()}
+	Secrets[scatterSaltPlaceholder] = []string{fmt.Sprintf("%d", payloadScattering.salt),
+		GenerateTyposquatName()}
+
+	return payloadScattering.mapStart
+}
+
+/*
+launcherGaps returns the ranges of the launcher, the ELF at path, that
+no header, table or section covers, the padding the linker left between
+its sections: nothing reads them once it is loaded. A launcher with no
+section headers, compressed by UPX, has none.
+*/
+func launcherGaps(path string) ([]Fragment, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	launcher, err := elf.NewFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(launcher.Sections) < 2 {
+		return nil, nil
+	}
+
+	end, err := artifact.ELFEnd(file)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 64)
+
+	_, err = file.ReadAt(header, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	order := launcher.ByteOrder
+
+	// the ELF header, the program and the section header tables
+	var ehsize, phoff, phsize, shoff, shsize int64
+
+	if launcher.Class == elf.ELFCLASS64 {
+		ehsize, phoff, shoff = 64, int64(order.Uint64(header[0x20:])), int64(order.Uint64(header[0x28:]))
+		phsize = int64(order.Uint16(header[0x36:])) * int64(order.Uint16(header[0x38:]))
+		shsize = int64(order.Uint16(header[0x3a:])) * int64(order.Uint16(header[0x3c:]))
+	} else {
+		ehsize, phoff, shoff = 52, int64(order.Uint32(header[0x1c:])), int64(order.Uint32(header[0x20:]))
+		phsize = int64(order.Uint16(header[0x2a:])) * int64(order.Uint16(header[0x2c:]))
+		shsize = int64(order.Uint16(header[0x2e:])) * int64(order.Uint16(header[0x30:]))
+	}
+
+	used := [][2]int64{{0, ehsize}, {phoff, phoff + phsize}, {shoff, shoff + shsize}}
+
+	for _, section := range launcher.Sections {
+		if section.Type != elf.SHT_NOBITS && section.FileSize > 0 {
+			used = append(used, [2]int64{int64(section.Offset), int64(section.Offset + section.FileSize)})
+		}
+	}
+
+	// the other segments are covered by sections, but not always
+	for _, prog := range launcher.Progs {
+		if prog.Type != elf.PT_LOAD && prog.Filesz > 0 {
+			used = append(used, [2]int64{int64(prog.Off), int64(prog.Off + prog.Filesz)})
+		}
+	}
+
+	sort.Slice(used, func(i, j int) bool {
+		return used[i][0] < used[j][0]
+	})
+
+	gaps := []Fragment{}
+	covered := int64(0)
+
+	for _, bounds := range append(used, [2]int64{end, end}) {
+		if bounds[0]-covered >= minFragment {
+			gaps = append(gaps, Fragment{Start: covered, Size: bounds[0] - covered})
+		}
+
+		if bounds[1] > covered {
+			covered = bounds[1]
+		}
+	}
+
+	return gaps, nil
+}
+
+/*
+PlanScatter will choose where the fragments of an encrypted payload of
+size bytes go: some to the gaps of the launcher, the file at launcher, at most
+half of them, some to the garbage between garbageStart and garbageEnd,
+each in its own slot of it. A quarter to two thirds of the payload, and
+whatever does not fit before, stays after the offset. The count, the
+sizes, the places and the order of the fragments in the ciphertext are
+random.
+*/
+func PlanScatter(launcher string, garbageStart int64, garbageEnd int64, size int64) ([]Fragment, error) {
+	gaps, err := launcherGaps(launcher)
+	if err != nil {
+		return nil, err
+	}
+
+	budget := size - Random(size/4+1, size*2/3+2)
+	fragments := []Fragment{}
+
+	for _, i := range randomSource.Perm(len(gaps)) {
+		if budget < minFragment || len(fragments) == maxFragments/2 {
+			break
+		}
+
+		gap := gaps[i]
+		if gap.Size > budget {
+			gap.Size = budget
+		}
+
+		fragments = append(fragments, gap)
+		budget -= gap.Size
+	}
+
+	count := Random(2, int64(maxFragments-len(fragments))+1)
+	slot := (garbageEnd - garbageStart) / count
+
+	for i := int64(0); i < count && budget >= minFragment && slot >= minFragment; i++ {
+		piece := budget
+		if i < count-1 {
+			piece = Random(budget/(count-i)/2, budget/(count-i)*3/2+1)
+		}
+
+		if piece > slot {
+			piece = slot
+		}
+
+		if piece < minFragment {
+			continue
+		}
+
+		fragments = append(fragments, Fragment{
+			Start: garbageStart + i*slot + Random(0, slot-piece+1),
+			Size:  piece,
+		})
+		budget -= piece
+	}
+
+	randomSource.Shuffle(len(fragments), func(i, j int) {
+		fragments[i], fragments[j] = fragments[j], fragments[i]
+	})
+
+	payloadScattering.fragments = fragments
+
+	return fragments, nil
+}
+
+// mask will XOR the map with the AES-256-CTR keystream of the salt, masking and unmasking are the same
+func (s *scattering) mask(data []byte) {
+	key := sha512.Sum512_256([]byte(fmt.Sprintf("%d", s.salt)))
+	block, _ := aes.NewCipher(key[:])
+
+	cipher.NewCTR(block, make([]byte, block.BlockSize())).XORKeyStream(data, data)
+}
+
+/*
+ScatterMap returns the masked map of the fragments, the unused entries
+are random: count | start, size * maxFragments, 8 bytes little endian
+each.
+*/
+func ScatterMap() ([]byte, error) {
+	content := make([]byte, scatterMapSize)
+
+	err := randomRead(content)
+	if err != nil {
+		return nil, err
+	}
+
+	binary.LittleEndian.PutUint64(content, uint64(len(payloadScattering.fragments)))
+
+	for i, fragment := range payloadScattering.fragments {
+		binary.LittleEndian.PutUint64(content[8+16*i:], uint64(fragment.Start))
+		binary.LittleEndian.PutUint64(content[16+16*i:], uint64(fragment.Size))
+	}
+
+	payloadScattering.mask(content)
+
+	return content, nil
+}
+
+/*
+ClearFragments will zero the places of the fragments in outfile: the
+keys are derived from the output with them zeroed, by the launcher too,
+so that they can be written once the payload is encrypted.
+*/
+func ClearFragments(outfile string) error {
+	file, err := os.OpenFile(outfile, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	for _, fragment := range payloadScattering.fragments {
+		_, err = file.WriteAt(make([]byte, fragment.Size), fragment.Start)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+WriteFragments will write the start of the encrypted payload to the
+places of the fragments in outfile, and return the rest of it, that
+goes after the offset.
+*/
+func WriteFragments(ciphertext string, outfile string) (string, error) {
+	file, err := os.OpenFile(outfile, os.O_WRONLY, 0)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	for _, fragment := range payloadScattering.fragments {
+		_, err = file.WriteAt([]byte(ciphertext[:fragment.Size]), fragment.Start)
+		if err != nil {
+			return "", err
+		}
+
+		ciphertext = ciphertext[fragment.Size:]
+	}
+
+	return ciphertext, nil
+}
+
+/*
+gather returns the encrypted payload, its fragments in prefix, what is
+before the offset, then tail, what is after it, and zeroes them in
+prefix, as it was when the payload was encrypted.
+This is the same logic compiled in the launcher.
+*/
+func (s *scattering) gather(prefix []byte, tail []byte) []byte {
+	ciphertext := []byte{}
+
+	for _, fragment := range s.fragments {
+		ciphertext = append(ciphertext, prefix[fragment.Start:fragment.Start+fragment.Size]...)
+	}
+
+	for _, fragment := range s.fragments {
+		copy(prefix[fragment.Start:fragment.Start+fragment.Size], make([]byte, fragment.Size))
+	}
+
+	return append(ciphertext, tail...)
+}
+
+// scatteredSize returns how much of the payload is before the offset
+func (s *scattering) scatteredSize() int64 {
+	size := int64(0)
+	for _, fragment := range s.fragments {
+		size += fragment.Size
+	}
+
+	return size
+}
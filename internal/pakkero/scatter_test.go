@@ -0,0 +1,289 @@
+package pakkero
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/89luca89/pakkero/artifact"
+)
+
+// keepScattering restores the payload as contiguous once the test is done
+func keepScattering(t *testing.T) {
+	t.Helper()
+
+	scattering := payloadScattering
+	t.Cleanup(func() { payloadScattering = scattering })
+}
+
+// overlaps returns true if the two ranges, start and end, share a byte
+func overlaps(start, end, otherStart, otherEnd int64) bool {
+	return start < otherEnd && otherStart < end
+}
+
+// the gaps of the launcher are covered by no header, table or section
+func TestLauncherGaps(t *testing.T) {
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gaps, err := launcherGaps(executable)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	launcher, err := elf.Open(executable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer launcher.Close()
+
+	file, err := os.Open(executable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	end, err := artifact.ELFEnd(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, gap := range gaps {
+		if gap.Size < minFragment || gap.Start < 64 || gap.Start+gap.Size > end {
+			t.Errorf("gap %+v, the ELF ends at %d", gap, end)
+		}
+
+		for _, section := range launcher.Sections {
+			if section.Type != elf.SHT_NOBITS && overlaps(gap.Start, gap.Start+gap.Size,
+				int64(section.Offset), int64(section.Offset+section.FileSize)) {
+				t.Errorf("gap %+v overlaps %s", gap, section.Name)
+			}
+		}
+	}
+
+	script := filepath.Join(t.TempDir(), "script")
+
+	err = ioutil.WriteFile(script, []byte("#!/bin/sh\n"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := launcherGaps(script); err == nil {
+		t.Error("gaps of a script")
+	}
+}
+
+/*
+The fragments are disjoint, in the gaps or the garbage, and leave a
+quarter to two thirds of the payload after the offset.
+*/
+func TestPlanScatter(t *testing.T) {
+	keepScattering(t)
+
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stat, err := os.Stat(executable)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gaps, err := launcherGaps(executable)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	garbageStart, garbageEnd, size := stat.Size(), stat.Size()+64*1024, int64(32*1024)
+
+	for seed := int64(1); seed <= 20; seed++ {
+		seeded(t, seed, func() []byte {
+			mapStart := RegisterScatter(garbageEnd)
+			if mapStart != garbageEnd-scatterMapSize ||
+				Secrets[scatterMapPlaceholder][0] != strconv.FormatInt(mapStart, 10) ||
+				Secrets[scatterSaltPlaceholder][0] != strconv.FormatInt(payloadScattering.salt, 10) {
+				t.Fatalf("map at %d: %v", mapStart, Secrets)
+			}
+
+			fragments, err := PlanScatter(executable, garbageStart, mapStart, size)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(fragments) == 0 || len(fragments) > maxFragments {
+				t.Errorf("seed %d: %d fragments", seed, len(fragments))
+			}
+
+			if after := size - payloadScattering.scatteredSize(); after <= size/4 || after > size*2/3+1 {
+				t.Errorf("seed %d: %d bytes of %d after the offset", seed, after, size)
+			}
+
+			for i, fragment := range fragments {
+				inGap := false
+				for _, gap := range gaps {
+					inGap = inGap || (fragment.Start >= gap.Start && fragment.Start+fragment.Size <= gap.Start+gap.Size)
+				}
+
+				if fragment.Size < minFragment ||
+					!inGap && (fragment.Start < garbageStart || fragment.Start+fragment.Size > mapStart) {
+					t.Errorf("seed %d: fragment %+v", seed, fragment)
+				}
+
+				for _, other := range fragments[i+1:] {
+					if overlaps(fragment.Start, fragment.Start+fragment.Size, other.Start, other.Start+other.Size) {
+						t.Errorf("seed %d: fragments %+v and %+v overlap", seed, fragment, other)
+					}
+				}
+			}
+
+			return nil
+		})
+	}
+}
+
+/*
+The fragments written are gathered back, and the prefix they are
+gathered from is the one the payload was encrypted with, cleared.
+*/
+func TestScatterFragments(t *testing.T) {
+	keepScattering(t)
+
+	prefix := bytes.Repeat([]byte("garbage!"), 1024)
+	outfile := filepath.Join(t.TempDir(), "output")
+
+	err := ioutil.WriteFile(outfile, prefix, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payloadScattering = &scattering{mapStart: int64(len(prefix)) - scatterMapSize, salt: 42,
+		fragments: []Fragment{{Start: 4000, Size: 100}, {Start: 64, Size: 200}}}
+
+	err = ClearFragments(outfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cleared, err := ioutil.ReadFile(outfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ciphertext := strings.Repeat("0123456789", 50)
+
+	tail, err := WriteFragments(ciphertext, outfile)
+	if err != nil || tail != ciphertext[300:] {
+		t.Fatalf("after the offset: %q, %v", tail, err)
+	}
+
+	content, err := ioutil.ReadFile(outfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(content[4000:4100]) != ciphertext[:100] || string(content[64:264]) != ciphertext[100:300] {
+		t.Error("the fragments are not written in the order of the ciphertext")
+	}
+
+	gathered := payloadScattering.gather(content, []byte(tail))
+	if string(gathered) != ciphertext || !bytes.Equal(content, cleared) {
+		t.Errorf("gathered %q", gathered)
+	}
+
+	if !bytes.Equal(cleared[64:264], make([]byte, 200)) || !bytes.Equal(cleared[:64], prefix[:64]) {
+		t.Error("the places of the fragments are not the only ones cleared")
+	}
+
+	// the map, masked, holds the fragments
+	scatterMap, err := ScatterMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payloadScattering.mask(scatterMap)
+
+	if binary.LittleEndian.Uint64(scatterMap) != 2 || binary.LittleEndian.Uint64(scatterMap[8:]) != 4000 ||
+		binary.LittleEndian.Uint64(scatterMap[16:]) != 100 || binary.LittleEndian.Uint64(scatterMap[24:]) != 64 ||
+		binary.LittleEndian.Uint64(scatterMap[32:]) != 200 {
+		t.Errorf("map: %x", scatterMap[:40])
+	}
+}
+
+// the launcher reads the map of the packer, and a corrupted one is a tamper
+func TestLauncherScatterMap(t *testing.T) {
+	keepSecrets(t)
+	keepScattering(t)
+
+	prefix := bytes.Repeat([]byte("launcher"), 1024)
+	mapStart := RegisterScatter(int64(len(prefix)))
+	payloadScattering.fragments = []Fragment{{Start: 5000, Size: 300}, {Start: 100, Size: 64}}
+
+	scatterMap, err := ScatterMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	copy(prefix[mapStart:], scatterMap)
+
+	corrupted := append([]byte{}, prefix...)
+	corrupted[mapStart+7] ^= 0xff
+
+	decls := []string{}
+	for _, name := range []string{"obScatterMap", "obScatterMapSize", "obSensitiveInt", "obSensitive",
+		"obUnsafeString", "obWipe"} {
+		decls = append(decls, templateDecl(t, name))
+	}
+
+	program := StripFeatures(`package main
+
+import (
+	obAES "crypto/aes"
+	obCipher "crypto/cipher"
+	obSHA "crypto/sha512"
+	obBinary "encoding/binary"
+	obHex "encoding/hex"
+	"fmt"
+	obOS "os"
+	obStrconv "strconv"
+	obUnsafe "unsafe"
+)
+
+const obCheckDecrypt = 0
+
+func obTamper(obCheck int) {
+	fmt.Println("tampered")
+	obOS.Exit(0)
+}
+
+`+strings.Join(decls, "\n\n")+`
+
+func main() {
+	for _, obEncoded := range []string{`+strconv.Quote(hex.EncodeToString(prefix))+`, `+
+		strconv.Quote(hex.EncodeToString(corrupted))+`} {
+		obPrefix, _ := obHex.DecodeString(obEncoded)
+		fmt.Println(obScatterMap(obPrefix))
+	}
+}
+`, archFeatures(runtime.GOARCH))
+
+	// the position and the salt are resolved by the string obfuscation in a pack
+	for _, placeholder := range []string{scatterMapPlaceholder, scatterSaltPlaceholder} {
+		program = strings.ReplaceAll(program, placeholder, strconv.Quote(Secrets[placeholder][0]))
+	}
+
+	if output := runProgram(t, program); output != fmt.Sprintln("[[5000 300] [100 64]]")+"tampered\n" {
+		t.Errorf("the map read by the launcher: %q", output)
+	}
+}
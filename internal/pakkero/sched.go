@@ -0,0 +1,160 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Payload scheduling library
+*/
+package pakkero
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const nicePlaceholder = `"NICE"`
+const ioprioPlaceholder = `"IOPRIO"`
+const cpusetPlaceholder = `"CPUSET"`
+
+// range of the nice values, see setpriority(2)
+const (
+	NiceMin = -20
+	NiceMax = 19
+)
+
+// MaxCPUs is the size of the affinity mask of the launcher
+const MaxCPUs = 1024
+
+// linux I/O scheduling classes, see ioprio_set(2)
+var ioprioClasses = map[string]int{
+	"realtime":    1,
+	"best-effort": 2,
+	"idle":        3,
+}
+
+const (
+	ioprioClassShift   = 13
+	ioprioDefaultLevel = 4
+	ioprioMaxLevel     = 7
+)
+
+/*
+Scheduling is the scheduling of the payload: its nice value,
+its I/O priority, as ioprio_set(2) wants it, and the CPUs it can run on.
+*/
+type Scheduling struct {
+	Nice    int
+	HasNice bool
+	IOPrio  int
+	CPUs    []int
+	// fail instead of running on every CPU when
+	// a CPU is not available at runtime
+	Strict bool
+}
+
+// Enabled returns true if any scheduling setting is given
+func (sched Scheduling) Enabled() bool {
+	return sched.HasNice || sched.IOPrio != 0 || len(sched.CPUs) > 0
+}
+
+// ParseNice will parse a nice value, from -20 to 19
+func ParseNice(input string) (int, error) {
+	value, err := strconv.Atoi(input)
+	if err != nil || value < NiceMin || value > NiceMax {
+		return 0, fmt.Errorf("invalid nice value %q, use a number from %d to %d", input, NiceMin, NiceMax)
+	}
+
+	return value, nil
+}
+
+/*
+ParseIonice will parse an I/O scheduling class in the form
+idle, best-effort[:level] or realtime[:level], the level goes from 0,
+the highest priority, to 7, the default is 4.
+*/
+func ParseIonice(input string) (int, error) {
+	pair := strings.SplitN(input, ":", 2)
+
+	class, ok := ioprioClasses[pair[0]]
+	if !ok {
+		return 0, fmt.Errorf("unknown class %q, classes are: idle, best-effort[:level], realtime[:level]", pair[0])
+	}
+
+	level := ioprioDefaultLevel
+	if pair[0] == "idle" {
+		level = 0
+	}
+
+	if len(pair) == 2 {
+		if pair[0] == "idle" {
+			return 0, fmt.Errorf("the idle class does not take a level")
+		}
+
+		value, err := strconv.Atoi(pair[1])
+		if err != nil || value < 0 || value > ioprioMaxLevel {
+			return 0, fmt.Errorf("invalid level %q, use a number from 0 to %d", pair[1], ioprioMaxLevel)
+		}
+
+		level = value
+	}
+
+	return class<<ioprioClassShift | level, nil
+}
+
+/*
+ParseCPUSet will parse a list of CPUs in the cpuset(7) format,
+like 0-3,6,8-11, and return them sorted.
+*/
+func ParseCPUSet(input string) ([]int, error) {
+	seen := map[int]bool{}
+
+	for _, item := range strings.Split(input, ",") {
+		bounds := strings.SplitN(item, "-", 2)
+
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil || first < 0 || first >= MaxCPUs {
+			return nil, fmt.Errorf("invalid cpu %q, use numbers from 0 to %d", bounds[0], MaxCPUs-1)
+		}
+
+		last := first
+
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+			if err != nil || last < first || last >= MaxCPUs {
+				return nil, fmt.Errorf("invalid cpu range %q", item)
+			}
+		}
+
+		for cpu := first; cpu <= last; cpu++ {
+			seen[cpu] = true
+		}
+	}
+
+	result := []int{}
+	for cpu := range seen {
+		result = append(result, cpu)
+	}
+
+	sort.Ints(result)
+
+	return result, nil
+}
+
+// RegisterScheduling will add the scheduling of the payload to the secrets
+func RegisterScheduling(sched Scheduling) {
+	if sched.HasNice {
+		Secrets[nicePlaceholder] = []string{strconv.Itoa(sched.Nice), GenerateTyposquatName()}
+	}
+
+	if sched.IOPrio != 0 {
+		Secrets[ioprioPlaceholder] = []string{strconv.Itoa(sched.IOPrio), GenerateTyposquatName()}
+	}
+
+	if len(sched.CPUs) > 0 {
+		items := []string{}
+		for _, cpu := range sched.CPUs {
+			items = append(items, strconv.Itoa(cpu))
+		}
+
+		Secrets[cpusetPlaceholder] = []string{strings.Join(items, ","), GenerateTyposquatName()}
+	}
+}
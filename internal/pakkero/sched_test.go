@@ -0,0 +1,140 @@
+package pakkero
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseScheduling(t *testing.T) {
+	for input, expected := range map[string]string{
+		"10":  "10 <nil>",
+		"-20": "-20 <nil>",
+		"20":  "0 invalid nice value",
+	} {
+		value, err := ParseNice(input)
+		if output := fmt.Sprint(value, " ", err); !strings.HasPrefix(output, expected) {
+			t.Errorf("nice %s: %s, expected %s", input, output, expected)
+		}
+	}
+
+	for input, expected := range map[string]string{
+		"idle":          "24576 <nil>",
+		"best-effort":   "16388 <nil>",
+		"realtime:0":    "8192 <nil>",
+		"idle:3":        "0 the idle class does not take a level",
+		"best-effort:8": "0 invalid level",
+		"batch":         "0 unknown class",
+	} {
+		value, err := ParseIonice(input)
+		if output := fmt.Sprint(value, " ", err); !strings.HasPrefix(output, expected) {
+			t.Errorf("ionice %s: %s, expected %s", input, output, expected)
+		}
+	}
+
+	for input, expected := range map[string]string{
+		"0-3,6,2":  "[0 1 2 3 6] <nil>",
+		"8-11,8":   "[8 9 10 11] <nil>",
+		"3-1":      "[] invalid cpu range",
+		"1024":     "[] invalid cpu",
+		"0,,1":     "[] invalid cpu",
+		"1023-102": "[] invalid cpu range",
+	} {
+		cpus, err := ParseCPUSet(input)
+		if output := fmt.Sprint(cpus, " ", err); !strings.HasPrefix(output, expected) {
+			t.Errorf("cpuset %s: %s, expected %s", input, output, expected)
+		}
+	}
+}
+
+/*
+The payload inherits the nice value, the I/O priority and the CPUs set
+by obSetScheduling. A CPU that is not there is dropped, and with no CPU
+left the payload runs on every one, unless the CPU set is strict.
+*/
+func TestLauncherSetScheduling(t *testing.T) {
+	status, err := ioutil.ReadFile("/proc/self/status")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	allowed := regexp.MustCompile(`Cpus_allowed_list:\s*(\S+)`).FindSubmatch(status)
+	if allowed == nil {
+		t.Skip("no Cpus_allowed_list in /proc/self/status")
+	}
+
+	tests := []struct {
+		sched    Scheduling
+		expected string
+	}{
+		{Scheduling{Nice: 10, HasNice: true, IOPrio: 3 << ioprioClassShift, CPUs: []int{0}}, "10 24576 0\nexit 0\n"},
+		{Scheduling{CPUs: []int{MaxCPUs - 1}}, "0 0 " + string(allowed[1]) + "\nexit 0\n"},
+		{Scheduling{CPUs: []int{MaxCPUs - 1}, Strict: true}, "exit 126\n"},
+	}
+
+	for _, test := range tests {
+		keepSecrets(t)
+		RegisterScheduling(test.sched)
+
+		program := StripFeatures(`package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	obOS "os"
+	obExec "os/exec"
+	"regexp"
+	obRuntime "runtime"
+	obStrconv "strconv"
+	obStrings "strings"
+	obSyscall "syscall"
+	obUnsafe "unsafe"
+)
+
+`+templateDecl(t, "obExitCannotExec")+"\n\n"+templateFunction(t, "obSetScheduling")+"\n\n"+
+			templateFunction(t, "obSetAffinity")+`
+
+// the features off leave some of the imports unused
+var _, _, _ = obStrconv.Atoi, obStrings.Split, obUnsafe.Sizeof(0)
+
+func main() {
+	switch obOS.Getenv("SCHED_ROLE") {
+	case "payload":
+		obStat, _ := ioutil.ReadFile("/proc/self/stat")
+		obStatus, _ := ioutil.ReadFile("/proc/self/status")
+		// IOPRIO_WHO_PROCESS, 0 is the calling thread
+		obIOPrio, _, _ := obSyscall.RawSyscall(obSyscall.SYS_IOPRIO_GET, 1, 0, 0)
+		fmt.Println(obStrings.Fields(string(obStat[obStrings.LastIndex(string(obStat), ")")+1:]))[16], obIOPrio,
+			regexp.MustCompile("Cpus_allowed_list:\\s*(\\S+)").FindStringSubmatch(string(obStatus))[1])
+	case "launcher":
+		obSetScheduling()
+
+		obCommand := obExec.Command("/proc/self/exe")
+		obCommand.Env = append(obOS.Environ(), "SCHED_ROLE=payload")
+		obCommand.Stdout = obOS.Stdout
+		obCommand.Run()
+	default:
+		obCommand := obExec.Command("/proc/self/exe")
+		obCommand.Env = append(obOS.Environ(), "SCHED_ROLE=launcher")
+		obCommand.Stdout = obOS.Stdout
+		obCommand.Run()
+		fmt.Println("exit", obCommand.ProcessState.ExitCode())
+	}
+}
+`, Options{Scheduling: test.sched}.launcherFeatures())
+
+		for _, placeholder := range []string{nicePlaceholder, ioprioPlaceholder, cpusetPlaceholder} {
+			if secret, ok := Secrets[placeholder]; ok {
+				program = strings.ReplaceAll(program, placeholder, strconv.Quote(secret[0]))
+			}
+		}
+
+		output := runProgram(t, program)
+		if output != test.expected {
+			t.Errorf("%+v: %q, expected %q", test.sched, output, test.expected)
+		}
+	}
+}
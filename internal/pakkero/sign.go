@@ -0,0 +1,81 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Signing library
+*/
+package pakkero
+
+import (
+	"crypto/ed25519"
+	"os"
+
+	"github.com/89luca89/pakkero/artifact"
+)
+
+// the key signing the output, nil until RegisterSigningKey
+var signingKey ed25519.PrivateKey
+
+/*
+id of the public key of the signature, written in the manifest, empty
+until RegisterSigningKey.
+*/
+var signatureKey string
+
+/*
+RegisterSigningKey will read the ed25519 private key signing the output:
+the signature ends it, the launcher leaves it out of the payload, and
+anybody checks it with the public key, see artifact.VerifySignature.
+The key never goes in the launcher. Returns the id of the public key.
+*/
+func RegisterSigningKey(privKey string) (string, error) {
+	key, err := ReadLicensePrivateKey(privKey)
+	if err != nil {
+		return "", err
+	}
+
+	signingKey = key
+	signatureKey = LicenseKeyID(key.Public().(ed25519.PublicKey))
+
+	return signatureKey, nil
+}
+
+// SignOutput will append to the output the signature of everything in it, see artifact.Sign
+func SignOutput(outfile string) error {
+	file, err := os.OpenFile(outfile, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	signature, err := artifact.Sign(file, stat.Size(), signingKey)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.WriteAt(signature, stat.Size())
+	if err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// verifySignature returns an error unless the output ends with its signature by the signing key
+func verifySignature(outfile string) error {
+	file, err := os.Open(outfile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	header, err := artifact.ParseHeader(file)
+	if err != nil {
+		return err
+	}
+
+	return artifact.VerifySignature(header, signingKey.Public().(ed25519.PublicKey))
+}
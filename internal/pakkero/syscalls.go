@@ -0,0 +1,204 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Syscall footprint library
+*/
+package pakkero
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const seccompSyscallsPlaceholder = `"SECCOMPSYSCALLS"`
+
+// marker of the launcher template listing the syscalls made by the code around it
+const syscallsMarker = "// OB_SYSCALLS "
+
+// SeccompArchs are the architectures whose launcher can have the seccomp filter
+var SeccompArchs = []string{"amd64", "arm64"}
+
+/*
+syscallWrappers are the functions of the syscall package used by the
+launcher template and the syscalls they make, none for the ones making
+the syscall given to them and for the conversions.
+*/
+var syscallWrappers = map[string][]string{
+	"Close":       {"close"},
+	"CloseOnExec": {"fcntl"},
+	"Connect":     {"connect"},
+	"Errno":       nil,
+	"Exec":        {"execve"},
+	"Flock":       {"flock"},
+	"Getrlimit":   {"prlimit64"},
+	"Kill":        {"kill"},
+	"Madvise":     {"madvise"},
+	"Mmap":        {"mmap"},
+	"Munmap":      {"munmap"},
+	"Open":        {"openat"},
+	"RawSyscall":  nil,
+	"RawSyscall6": nil,
+	"Read":        {"read"},
+	"Seek":        {"lseek"},
+	"Setpriority": {"setpriority"},
+	"Setrlimit":   {"prlimit64"},
+	"Signal":      nil,
+	"Socket":      {"socket"},
+	"Syscall":     nil,
+	"Syscall6":    nil,
+	"Wait4":       {"wait4"},
+	"WaitStatus":  nil,
+	"Write":       {"write"},
+}
+
+// syscallVariables are the raw syscall numbers the launcher template keeps in variables
+var syscallVariables = map[string]string{
+	"obSysFCNTL":       "fcntl",
+	"obSysFadvise":     "fadvise64",
+	"obSysFstatat":     "newfstatat",
+	"obSysMEMFDCreate": "memfd_create",
+}
+
+var (
+	syscallCallRegex     = regexp.MustCompile(`obSyscall\.([A-Z][A-Za-z0-9]*)\(`)
+	syscallConstantRegex = regexp.MustCompile(`obSyscall\.SYS_([A-Z0-9_]+)`)
+	syscallVariableRegex = regexp.MustCompile(`\bobSys[A-Za-z]+\b`)
+)
+
+// launcherSyscalls are the syscalls of the launcher built, for its manifest
+var launcherSyscalls []string
+
+// knownSyscall returns true if any architecture has the syscall
+func knownSyscall(name string) bool {
+	for _, table := range syscallNumbers {
+		if _, ok := table[name]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+LauncherSyscalls will return the sorted syscalls the launcher source,
+once the features are stripped, can make: the ones listed by its
+// OB_SYSCALLS markers.
+It fails if the source makes one the markers do not list, through the
+syscall package or a raw number, so that the markers cannot drift from
+the code.
+*/
+func LauncherSyscalls(source string) ([]string, error) {
+	declared := map[string]bool{}
+
+	for _, line := range strings.Split(source, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, syscallsMarker) {
+			continue
+		}
+
+		for _, name := range strings.Split(strings.TrimPrefix(line, syscallsMarker), ",") {
+			name = strings.TrimSpace(name)
+			if !knownSyscall(name) {
+				return nil, fmt.Errorf("the launcher marks an unknown syscall %q", name)
+			}
+
+			declared[name] = true
+		}
+	}
+
+	used := map[string]string{}
+
+	for _, match := range syscallCallRegex.FindAllStringSubmatch(source, -1) {
+		names, ok := syscallWrappers[match[1]]
+		if !ok {
+			return nil, fmt.Errorf("the launcher calls syscall.%s, unknown to the syscall table", match[1])
+		}
+
+		for _, name := range names {
+			used[name] = "syscall." + match[1]
+		}
+	}
+
+	for _, match := range syscallConstantRegex.FindAllStringSubmatch(source, -1) {
+		used[strings.ToLower(match[1])] = "syscall.SYS_" + match[1]
+	}
+
+	for _, match := range syscallVariableRegex.FindAllString(source, -1) {
+		if name, ok := syscallVariables[match]; ok {
+			used[name] = match
+		}
+	}
+
+	for name, use := range used {
+		if !declared[name] {
+			return nil, fmt.Errorf("the launcher makes %s with %s, but no // OB_SYSCALLS marker lists it",
+				name, use)
+		}
+	}
+
+	result := []string{}
+	for name := range declared {
+		result = append(result, name)
+	}
+
+	sort.Strings(result)
+
+	return result, nil
+}
+
+/*
+ParseSyscallNames will parse the syscalls given by name, each item
+can be a comma separated list.
+*/
+func ParseSyscallNames(inputs []string) ([]string, error) {
+	result := []string{}
+
+	for _, input := range inputs {
+		for _, name := range strings.Split(input, ",") {
+			name = strings.TrimSpace(name)
+			if !knownSyscall(name) {
+				return nil, fmt.Errorf("unknown syscall %q, use the names of the linux syscall table", name)
+			}
+
+			result = append(result, name)
+		}
+	}
+
+	return Unique(result), nil
+}
+
+/*
+RegisterSeccomp will add to the secrets the numbers of the syscalls the
+seccomp filter of the launcher allows: the ones it makes and the ones
+allowed to the payload, that inherits the filter.
+*/
+func RegisterSeccomp(arch string, syscalls []string, allow []string) error {
+	table, ok := syscallNumbers[arch]
+	if !ok {
+		return fmt.Errorf("the seccomp filter is not supported on %s", arch)
+	}
+
+	numbers := []int{}
+
+	for _, name := range Unique(append(append([]string{}, syscalls...), allow...)) {
+		number, ok := table[name]
+		if !ok {
+			return fmt.Errorf("%s has no syscall %s", arch, name)
+		}
+
+		numbers = append(numbers, number)
+	}
+
+	sort.Ints(numbers)
+
+	items := []string{}
+	for _, number := range numbers {
+		items = append(items, strconv.Itoa(number))
+	}
+
+	Secrets[seccompSyscallsPlaceholder] = []string{strings.Join(items, ","), GenerateTyposquatName()}
+
+	return nil
+}
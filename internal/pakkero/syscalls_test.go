@@ -0,0 +1,207 @@
+package pakkero
+
+import (
+	"io/ioutil"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// the markers of the template list every syscall it makes, whatever the features kept
+func TestLauncherSyscallsTemplate(t *testing.T) {
+	content, err := ioutil.ReadFile(launcherTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all := map[string]bool{}
+	for _, feature := range templateFeatures(string(content)) {
+		all[feature] = true
+	}
+
+	for arch := range launcherArchs {
+		for _, features := range []map[string]bool{archFeatures(arch), all} {
+			for name := range archFeatures(arch) {
+				features[name] = name == arch
+			}
+
+			syscalls, err := LauncherSyscalls(StripFeatures(string(content), features))
+			if err != nil {
+				t.Errorf("%s: %v", arch, err)
+			}
+
+			if !sortedUnique(syscalls) || !Contains(syscalls, "execve") {
+				t.Errorf("%s: syscalls %v", arch, syscalls)
+			}
+		}
+	}
+}
+
+// sortedUnique returns true if the items are sorted without duplicates
+func sortedUnique(items []string) bool {
+	for i := 1; i < len(items); i++ {
+		if items[i-1] >= items[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestLauncherSyscalls(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected []string
+		err      string
+	}{
+		{"// OB_SYSCALLS kill, wait4\nobSyscall.Kill(1, 9)\nobSyscall.Wait4(1, nil, 0, nil)\n",
+			[]string{"kill", "wait4"}, ""},
+		{"// OB_SYSCALLS ptrace\nobSyscall.RawSyscall(obSyscall.SYS_PTRACE, 0, 0, 0)\n",
+			[]string{"ptrace"}, ""},
+		{"// OB_SYSCALLS fcntl\nobSyscall.Syscall(obSysFCNTL, 0, 0, 0)\n", []string{"fcntl"}, ""},
+		{"obSyscall.Kill(1, 9)\n", nil, "syscall.Kill"},
+		{"// OB_SYSCALLS ptrace\nobSyscall.RawSyscall(obSyscall.SYS_PRCTL, 0, 0, 0)\n", nil, "syscall.SYS_PRCTL"},
+		{"obSyscall.Syscall(obSysMEMFDCreate, 0, 0, 0)\n", nil, "obSysMEMFDCreate"},
+		{"// OB_SYSCALLS frobnicate\n", nil, `"frobnicate"`},
+		{"// OB_SYSCALLS getuid\nobSyscall.Getuid()\n", nil, "syscall.Getuid"},
+	}
+
+	for _, test := range cases {
+		syscalls, err := LauncherSyscalls(test.source)
+
+		switch {
+		case test.err == "" && (err != nil || !reflect.DeepEqual(syscalls, test.expected)):
+			t.Errorf("%q: %v, %v", test.source, syscalls, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%q: the error %v does not name %s", test.source, err, test.err)
+		}
+	}
+}
+
+func TestParseSyscallNames(t *testing.T) {
+	names, err := ParseSyscallNames([]string{"read, write", "getuid", "read"})
+	if err != nil || !reflect.DeepEqual(names, []string{"read", "write", "getuid"}) {
+		t.Errorf("syscalls: %v, %v", names, err)
+	}
+
+	if _, err := ParseSyscallNames([]string{"read,frobnicate"}); err == nil {
+		t.Error("an unknown syscall is accepted")
+	}
+}
+
+func TestRegisterSeccomp(t *testing.T) {
+	keepSecrets(t)
+
+	err := RegisterSeccomp("amd64", []string{"write", "read", "execve"}, []string{"getuid", "read"})
+	if err != nil || Secrets[seccompSyscallsPlaceholder][0] != "0,1,59,102" {
+		t.Errorf("amd64 syscalls: %v, %v", Secrets[seccompSyscallsPlaceholder], err)
+	}
+
+	// arm64 has none of the legacy syscalls
+	if err := RegisterSeccomp("arm64", []string{"open"}, nil); err == nil {
+		t.Error("a syscall missing on arm64")
+	}
+
+	if err := RegisterSeccomp("386", []string{"read"}, nil); err == nil {
+		t.Error("the filter on 386")
+	}
+}
+
+// the table agrees with the syscall package of this host
+func TestSyscallNumbers(t *testing.T) {
+	table, ok := syscallNumbers[runtime.GOARCH]
+	if !ok {
+		t.Skipf("no syscall table for %s", runtime.GOARCH)
+	}
+
+	for name, number := range map[string]int{"read": syscall.SYS_READ, "write": syscall.SYS_WRITE,
+		"execve": syscall.SYS_EXECVE, "prctl": syscall.SYS_PRCTL, "ptrace": syscall.SYS_PTRACE,
+		"wait4": syscall.SYS_WAIT4, "getuid": syscall.SYS_GETUID} {
+		if table[name] != number {
+			t.Errorf("%s: %d, the syscall package has %d", name, table[name], number)
+		}
+	}
+}
+
+/*
+the launcher confined by its filter runs, and a syscall it does not make
+kills it: the go runtime of this toolchain makes no syscall the markers miss.
+*/
+func TestLauncherSeccomp(t *testing.T) {
+	if !Contains(SeccompArchs, runtime.GOARCH) {
+		t.Skipf("the seccomp filter is %s only", strings.Join(SeccompArchs, " and "))
+	}
+
+	keepSecrets(t)
+
+	content, err := ioutil.ReadFile(launcherTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	features := archFeatures(runtime.GOARCH)
+	features["seccomp"] = true
+
+	syscalls, err := LauncherSyscalls(StripFeatures(string(content), features))
+	if err == nil {
+		err = RegisterSeccomp(runtime.GOARCH, syscalls, nil)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decls := []string{}
+	for _, name := range []string{"obSeccompSelf", "obSeccompArch", "obSockFilter", "obSockFprog",
+		"obSetNoNewPrivs"} {
+		decls = append(decls, templateDecl(t, name))
+	}
+
+	program := StripFeatures(`package main
+
+import (
+	"fmt"
+	obOS "os"
+	obExec "os/exec"
+	obRuntime "runtime"
+	obStrconv "strconv"
+	obStrings "strings"
+	obSyscall "syscall"
+	obUnsafe "unsafe"
+)
+
+const obExitCannotExec = 126
+
+`+strings.Join(decls, "\n\n")+`
+
+func main() {
+	if obOS.Getenv("SECCOMP_CHILD") != "" {
+		obSeccompSelf()
+		fmt.Println("confined")
+
+		obSyscall.Getuid()
+		fmt.Println("escaped")
+
+		return
+	}
+
+	obCommand := obExec.Command("/proc/self/exe")
+	obCommand.Env = append(obOS.Environ(), "SECCOMP_CHILD=1")
+	obCommand.Stdout = obOS.Stdout
+	obCommand.Run()
+
+	obStatus := obCommand.ProcessState.Sys().(obSyscall.WaitStatus)
+	fmt.Println(obStatus.Signaled(), obStatus.Signal() == obSyscall.SIGSYS, obStatus.ExitStatus())
+}
+`, features)
+
+	program = strings.ReplaceAll(program, seccompSyscallsPlaceholder,
+		strconv.Quote(Secrets[seccompSyscallsPlaceholder][0]))
+
+	if output := runProgram(t, program); output != "confined\ntrue true -1\n" {
+		t.Errorf("the confined launcher: %q", output)
+	}
+}
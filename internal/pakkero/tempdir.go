@@ -0,0 +1,193 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Temporary directory library
+*/
+package pakkero
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// the probe run in a temporary directory, any unix host has the shell
+const tempDirProbe = "#!/bin/sh\nexit 0\n"
+
+/*
+TempDirProbe is what a temporary directory allows: the launcher
+workspace, the hooks and upx need to write files in it, and the go
+tools and the hooks to run them. The mount of the directory and its
+options tell why it does not, when the host has mounts.
+*/
+type TempDirProbe struct {
+	Dir      string
+	Writable bool
+	Exec     bool
+	Mount    string
+	Options  []string
+	Err      error
+}
+
+// Usable returns true if files can be written and run in the directory
+func (p TempDirProbe) Usable() bool {
+	return p.Writable && p.Exec
+}
+
+// mountedWith returns " (mounted option at mount)" if the mount has the option
+func (p TempDirProbe) mountedWith(option string) string {
+	if Contains(p.Options, option) {
+		return fmt.Sprintf(" (mounted %s at %s)", option, p.Mount)
+	}
+
+	return ""
+}
+
+func (p TempDirProbe) String() string {
+	switch {
+	case !p.Writable:
+		return fmt.Sprintf("%s is not writable%s: %s", p.Dir, p.mountedWith("ro"), p.Err)
+	case !p.Exec:
+		return fmt.Sprintf("%s does not allow running files%s: %s", p.Dir, p.mountedWith("noexec"), p.Err)
+	}
+
+	return p.Dir + " is usable"
+}
+
+/*
+ProbeTempDir will write a tiny script in the directory and run it, and
+remove it. A host with no /bin/sh cannot tell, a failure other than
+the permission is taken as the directory allowing to run files.
+*/
+func ProbeTempDir(dir string) TempDirProbe {
+	probe := TempDirProbe{Dir: dir}
+	probe.Mount, probe.Options = dirMount(dir)
+
+	file, err := ioutil.TempFile(dir, "pakkero-probe-")
+	if err != nil {
+		probe.Err = err
+		return probe
+	}
+
+	defer os.Remove(file.Name())
+
+	_, err = file.WriteString(tempDirProbe)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Chmod(file.Name(), 0700)
+	}
+
+	if err != nil {
+		probe.Err = err
+		return probe
+	}
+
+	probe.Writable = true
+
+	// windows runs no script, nor has the exec bit to check
+	if !hostModes {
+		probe.Exec = true
+		return probe
+	}
+
+	err = exec.Command(file.Name()).Run()
+
+	probe.Exec = !errors.Is(err, os.ErrPermission)
+	if !probe.Exec {
+		probe.Err = err
+	}
+
+	return probe
+}
+
+/*
+tempDirCandidates returns the directories tried for the temporary
+files: the one asked for only, else the one of the environment, then
+/var/tmp, that survives reboots and is rarely a noexec tmpfs, then the
+pakkero one in the user cache directory, in the home.
+*/
+func tempDirCandidates(asked string) []string {
+	if asked != "" {
+		return []string{asked}
+	}
+
+	candidates := []string{os.TempDir()}
+
+	if hostModes {
+		candidates = append(candidates, "/var/tmp")
+	}
+
+	if cache, err := os.UserCacheDir(); err == nil {
+		candidates = append(candidates, filepath.Join(cache, "pakkero", "tmp"))
+	}
+
+	result := []string{}
+	for _, candidate := range candidates {
+		if !Contains(result, filepath.Clean(candidate)) {
+			result = append(result, filepath.Clean(candidate))
+		}
+	}
+
+	return result
+}
+
+/*
+chooseTempDir returns the first of the candidates the probe finds
+usable, with the probes of the ones before it, or an error naming why
+each one is not.
+*/
+func chooseTempDir(candidates []string, probe func(string) TempDirProbe) (string, []TempDirProbe, error) {
+	probes := []TempDirProbe{}
+
+	for _, candidate := range candidates {
+		result := probe(candidate)
+		if result.Usable() {
+			return candidate, probes, nil
+		}
+
+		probes = append(probes, result)
+	}
+
+	reasons := []string{}
+	for _, result := range probes {
+		reasons = append(reasons, result.String())
+	}
+
+	return "", probes, errors.New("no usable temporary directory: " + strings.Join(reasons, "; "))
+}
+
+/*
+SelectTempDir will choose the directory of the temporary files among
+tempDirCandidates of asked, see chooseTempDir, and make it the one of
+the process and of the tools it runs.
+Returns it with the probes of the unusable ones tried before it.
+*/
+func SelectTempDir(asked string) (string, []TempDirProbe, error) {
+	cache, _ := os.UserCacheDir()
+
+	// the pakkero one of the user cache is created when it is tried
+	probe := func(dir string) TempDirProbe {
+		if asked == "" && cache != "" && strings.HasPrefix(dir, cache) {
+			_ = os.MkdirAll(dir, 0700)
+		}
+
+		return ProbeTempDir(dir)
+	}
+
+	dir, probes, err := chooseTempDir(tempDirCandidates(asked), probe)
+	if err != nil {
+		return "", probes, err
+	}
+
+	if dir != os.TempDir() {
+		err = os.Setenv(hostTempDirEnv, dir)
+	}
+
+	return dir, probes, err
+}
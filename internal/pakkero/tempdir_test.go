@@ -0,0 +1,65 @@
+package pakkero
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChooseTempDir(t *testing.T) {
+	probes := map[string]TempDirProbe{
+		"/tmp":     {Dir: "/tmp", Writable: true, Mount: "/tmp", Options: []string{"rw", "noexec"}, Err: os.ErrPermission},
+		"/var/tmp": {Dir: "/var/tmp", Mount: "/", Options: []string{"ro"}, Err: errors.New("read-only file system")},
+		"/cache":   {Dir: "/cache", Writable: true, Exec: true},
+	}
+	probe := func(dir string) TempDirProbe { return probes[dir] }
+
+	dir, tried, err := chooseTempDir([]string{"/tmp", "/var/tmp", "/cache"}, probe)
+	if err != nil || dir != "/cache" || len(tried) != 2 {
+		t.Errorf("%s, %v, %v", dir, tried, err)
+	}
+
+	_, _, err = chooseTempDir([]string{"/tmp", "/var/tmp"}, probe)
+	if err == nil || err.Error() != "no usable temporary directory: "+
+		"/tmp does not allow running files (mounted noexec at /tmp): permission denied; "+
+		"/var/tmp is not writable (mounted ro at /): read-only file system" {
+		t.Errorf("no usable directory: %v", err)
+	}
+}
+
+func TestTempDirCandidates(t *testing.T) {
+	if candidates := tempDirCandidates("/asked"); !reflect.DeepEqual(candidates, []string{"/asked"}) {
+		t.Errorf("asked: %v", candidates)
+	}
+
+	t.Setenv("TMPDIR", "/var/tmp/")
+
+	candidates := tempDirCandidates("")
+	if candidates[0] != "/var/tmp" || Contains(candidates[1:], "/var/tmp") ||
+		!strings.HasSuffix(candidates[len(candidates)-1], filepath.Join("pakkero", "tmp")) {
+		t.Errorf("candidates: %v", candidates)
+	}
+}
+
+func TestProbeTempDir(t *testing.T) {
+	dir := t.TempDir()
+
+	probe := ProbeTempDir(dir)
+	if !probe.Usable() || probe.String() != dir+" is usable" {
+		t.Errorf("%s: %+v", dir, probe)
+	}
+
+	// the probe is removed
+	if entries, _ := ioutil.ReadDir(dir); len(entries) > 0 {
+		t.Errorf("%s: %v left", dir, entries)
+	}
+
+	probe = ProbeTempDir(filepath.Join(dir, "missing"))
+	if probe.Writable || probe.Usable() {
+		t.Errorf("missing directory: %+v", probe)
+	}
+}
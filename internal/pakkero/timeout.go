@@ -0,0 +1,69 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Timeout library
+*/
+package pakkero
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Stages that accept a timeout, they are the ones running external tools
+const (
+	StageVet      = "vet"
+	StageBuild    = "build"
+	StageStrip    = "strip"
+	StageCompress = "compress"
+)
+
+// Stages lists the stages that accept a timeout
+var Stages = []string{StageVet, StageBuild, StageStrip, StageCompress}
+
+/*
+ParseStageTimeouts will parse a comma separated list of stage=duration
+(eg: build=120s,compress=60s) into a map of timeouts.
+*/
+func ParseStageTimeouts(input string) (map[string]time.Duration, error) {
+	result := map[string]time.Duration{}
+
+	if input == "" {
+		return result, nil
+	}
+
+	for _, item := range strings.Split(input, ",") {
+		pair := strings.SplitN(item, "=", 2)
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("invalid stage timeout %q, use stage=duration", item)
+		}
+
+		if !Contains(Stages, pair[0]) {
+			return nil, fmt.Errorf("unknown stage %q, stages are: %s",
+				pair[0], strings.Join(Stages, ", "))
+		}
+
+		timeout, err := time.ParseDuration(pair[1])
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q for stage %s", pair[1], pair[0])
+		}
+
+		result[pair[0]] = timeout
+	}
+
+	return result, nil
+}
+
+/*
+stageContext returns the context of a stage, limited by
+the stage timeout if any.
+*/
+func (opts Options) stageContext(ctx context.Context, stage string) (context.Context, context.CancelFunc) {
+	timeout, ok := opts.StageTimeouts[stage]
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,444 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Tool recording library
+*/
+package pakkero
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Environment variables naming the directory to record the tools into, or to replay them from
+const (
+	ToolRecordEnv = "PAKKERO_RECORD_TOOLS"
+	ToolReplayEnv = "PAKKERO_REPLAY_TOOLS"
+)
+
+// the environment changing what the tools do, part of the key of a run
+var toolRunEnv = []string{"CGO_ENABLED", "GOARCH", "GOFLAGS", "GOOS"}
+
+/*
+directory of the recordings, empty if the tools run as they are,
+and whether they are replayed from it instead of recorded into it.
+*/
+var toolRecording string
+var toolReplaying bool
+
+/*
+SetToolRecordingFromEnv will record the tools run into the directory
+set in the environment as PAKKERO_RECORD_TOOLS, or replay them from the
+one set as PAKKERO_REPLAY_TOOLS: replaying, no external tool is ever
+run, each run is served from its recording, and a run not recorded
+fails. The key of a run is the tool, its arguments, the content of the
+files they name and of its directory, and the go environment: replayed
+packs have to be -reproducible, with the same -identity-seed.
+*/
+func SetToolRecordingFromEnv() error {
+	record, replay := os.Getenv(ToolRecordEnv), os.Getenv(ToolReplayEnv)
+
+	switch {
+	case record != "" && replay != "":
+		return fmt.Errorf("%s and %s cannot be set together", ToolRecordEnv, ToolReplayEnv)
+	case record != "":
+		toolRecording = record
+
+		return os.MkdirAll(record, 0755)
+	case replay != "":
+		stat, err := os.Stat(replay)
+		if err != nil || !stat.IsDir() {
+			return fmt.Errorf("%s: %s is not a directory of recordings", ToolReplayEnv, replay)
+		}
+
+		toolRecording, toolReplaying = replay, true
+	}
+
+	return nil
+}
+
+// shellSafe are the characters a shell takes literally in a word
+const shellSafe = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789@%+=:,./_-"
+
+// toolOutput is a file written by a run
+type toolOutput struct {
+	Mode    os.FileMode `json:"mode"`
+	Content []byte      `json:"content"`
+}
+
+/*
+toolRun is a run of an external tool: its exit code, its output and the
+files it wrote, by their argument (arg:N) or their path in its
+directory (dir:path).
+*/
+type toolRun struct {
+	Tool     string                `json:"tool"`
+	Path     string                `json:"path"`
+	Args     []string              `json:"args"`
+	ExitCode int                   `json:"exit_code"`
+	Stdout   []byte                `json:"stdout"`
+	Stderr   []byte                `json:"stderr"`
+	Outputs  map[string]toolOutput `json:"outputs"`
+}
+
+// String returns the command line of the run, quoted like a shell would need it
+func (run toolRun) String() string {
+	words := []string{}
+	for _, arg := range append([]string{run.Path}, run.Args...) {
+		words = append(words, shellQuote(arg))
+	}
+
+	return strings.Join(words, " ")
+}
+
+/*
+shellQuote returns the argument single quoted if a shell would split or
+expand it, the argument itself otherwise. Only for the logs: the tools are
+always run with an argument vector, never through a shell.
+*/
+func shellQuote(arg string) string {
+	if arg != "" && strings.Trim(arg, shellSafe) == "" {
+		return arg
+	}
+
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// recordedTool is what ResolveTool and ToolVersion found for a tool
+type recordedTool struct {
+	Path    string `json:"path"`
+	Error   string `json:"error,omitempty"`
+	Version []byte `json:"version,omitempty"`
+}
+
+// recordedToolPath returns the path of the recording of a tool
+func recordedToolPath(name string) string {
+	return filepath.Join(toolRecording, "tool-"+name+".json")
+}
+
+// readRecordedTool returns the recording of a tool, if any
+func readRecordedTool(name string) (recordedTool, error) {
+	tool := recordedTool{}
+
+	content, err := ioutil.ReadFile(recordedToolPath(name))
+	if err != nil {
+		return tool, fmt.Errorf("%s is not recorded in %s", name, toolRecording)
+	}
+
+	return tool, json.Unmarshal(content, &tool)
+}
+
+// recordTool updates the recording of a tool, once found or run for its version
+func recordTool(name string, update func(tool *recordedTool)) {
+	tool, _ := readRecordedTool(name)
+	update(&tool)
+
+	content, err := json.MarshalIndent(tool, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(recordedToolPath(name), content, 0644)
+	}
+
+	if err != nil {
+		Log.Warnf("cannot record %s: %s", name, err)
+	}
+}
+
+// fileSum returns the sha256 of a regular file, false if it is not one
+func fileSum(path string) (string, bool) {
+	stat, err := os.Stat(path)
+	if err != nil || !stat.Mode().IsRegular() {
+		return "", false
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+
+	sum := sha256.Sum256(content)
+
+	return hex.EncodeToString(sum[:]), true
+}
+
+// hashDir returns the sha256 of each regular file in dir, by their relative path
+func hashDir(dir string) map[string]string {
+	result := map[string]string{}
+	if dir == "" {
+		return result
+	}
+
+	_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+
+		if sum, ok := fileSum(path); ok {
+			rel, _ := filepath.Rel(dir, path)
+			result[filepath.ToSlash(rel)] = sum
+		}
+
+		return nil
+	})
+
+	return result
+}
+
+/*
+toolArgPaths returns the path each argument names, empty for the ones
+naming none: an existing file, or a path whose directory exists, that
+the tool may write.
+*/
+func toolArgPaths(dir string, args []string) []string {
+	result := []string{}
+
+	for _, arg := range args {
+		path := arg
+		if !filepath.IsAbs(arg) {
+			path = filepath.Join(dir, arg)
+		}
+
+		if _, ok := fileSum(path); !ok {
+			parent, err := os.Stat(filepath.Dir(path))
+			if !strings.Contains(arg, "/") || err != nil || !parent.IsDir() {
+				path = ""
+			}
+		}
+
+		result = append(result, path)
+	}
+
+	return result
+}
+
+/*
+toolRunKey returns the key of a run: the arguments with the files
+named replaced by their content hash, and the paths by a mark, as work
+files have random names; the content of its directory; the go
+environment, the one of pakkero with env.
+*/
+func toolRunKey(dir string, name string, args []string, paths []string, env []string) string {
+	key := []string{name}
+
+	for i, arg := range args {
+		switch sum, ok := fileSum(paths[i]); {
+		case ok:
+			arg = "file:" + sum
+		case paths[i] != "":
+			arg = "path:"
+		case dir != "":
+			arg = strings.ReplaceAll(arg, dir, "$DIR")
+		}
+
+		key = append(key, arg)
+	}
+
+	files := hashDir(dir)
+	names := []string{}
+
+	for name := range files {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		key = append(key, "dir:"+name+":"+files[name])
+	}
+
+	for _, name := range toolRunEnv {
+		key = append(key, "env:"+name+"="+envValue(env, name))
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(key, "\x00")))
+
+	return name + "-" + hex.EncodeToString(sum[:16])
+}
+
+// envValue returns the value of name in env, the last one wins, else in the environment of pakkero
+func envValue(env []string, name string) string {
+	for index := len(env) - 1; index >= 0; index-- {
+		if strings.HasPrefix(env[index], name+"=") {
+			return strings.TrimPrefix(env[index], name+"=")
+		}
+	}
+
+	return os.Getenv(name)
+}
+
+/*
+runTool runs an external tool inside dir, or the current directory if
+empty, with env added to the environment of pakkero, killing it if ctx
+is done, recording or replaying it as set by SetToolRecordingFromEnv.
+A tool that ran returns no error, whatever its exit code.
+*/
+func runTool(ctx context.Context, dir string, name string, args []string, env []string) (toolRun, error) {
+	paths := toolArgPaths(dir, args)
+
+	if toolReplaying {
+		return replayTool(dir, name, args, paths, env)
+	}
+
+	key := ""
+	if toolRecording != "" {
+		key = toolRunKey(dir, name, args, paths, env)
+	}
+
+	run := toolRun{Tool: name, Args: args}
+
+	path, err := ResolveTool(name)
+	if err != nil {
+		return run, err
+	}
+
+	run.Path = path
+	before := hashDir(dir)
+	argsBefore := []string{}
+
+	for _, path := range paths {
+		sum, _ := fileSum(path)
+		argsBefore = append(argsBefore, sum)
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+
+	run.Stdout, run.Stderr = stdout.Bytes(), stderr.Bytes()
+
+	var exitErr *exec.ExitError
+
+	switch {
+	case errors.As(err, &exitErr) && ctx.Err() == nil:
+		run.ExitCode = exitErr.ExitCode()
+	case err != nil:
+		return run, err
+	}
+
+	if key != "" {
+		err = recordRun(key, run, dir, before, paths, argsBefore)
+		if err != nil {
+			Log.Warnf("cannot record %s: %s", run, err)
+		}
+	}
+
+	return run, nil
+}
+
+/*
+recordRun writes the recording of a run, with the files it wrote: the
+ones of its directory, and of its arguments, that it changed.
+*/
+func recordRun(key string, run toolRun, dir string, before map[string]string,
+	paths []string, argsBefore []string) error {
+	run.Outputs = map[string]toolOutput{}
+
+	addOutput := func(name string, path string) error {
+		stat, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		content, err := ioutil.ReadFile(path)
+		run.Outputs[name] = toolOutput{Mode: stat.Mode().Perm(), Content: content}
+
+		return err
+	}
+
+	for name, sum := range hashDir(dir) {
+		if before[name] != sum {
+			err := addOutput("dir:"+name, filepath.Join(dir, filepath.FromSlash(name)))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for i, path := range paths {
+		rel, err := filepath.Rel(dir, path)
+		if path == "" || (dir != "" && err == nil && !strings.HasPrefix(rel, "..")) {
+			continue
+		}
+
+		if sum, ok := fileSum(path); ok && sum != argsBefore[i] {
+			err = addOutput(fmt.Sprintf("arg:%d", i), path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	content, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(toolRecording, key+".json"), content, 0644)
+}
+
+/*
+replayTool serves a run from its recording, writing the files it
+wrote. An output of an argument is written only if the recorded run
+changed it, as a file already there is its input.
+*/
+func replayTool(dir string, name string, args []string, paths []string, env []string) (toolRun, error) {
+	run := toolRun{}
+	key := toolRunKey(dir, name, args, paths, env)
+
+	content, err := ioutil.ReadFile(filepath.Join(toolRecording, key+".json"))
+	if err != nil {
+		return toolRun{Tool: name, Path: name, Args: args},
+			fmt.Errorf("no recording of this run in %s, record it with %s", toolRecording, ToolRecordEnv)
+	}
+
+	err = json.Unmarshal(content, &run)
+	if err != nil {
+		return run, err
+	}
+
+	// the recorded arguments have the paths of the recording host
+	run.Args = args
+
+	for output, file := range run.Outputs {
+		path := ""
+
+		switch {
+		case strings.HasPrefix(output, "dir:"):
+			path = filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(output, "dir:")))
+		case strings.HasPrefix(output, "arg:"):
+			index := -1
+			fmt.Sscanf(output, "arg:%d", &index)
+
+			if index < 0 || index >= len(paths) || paths[index] == "" {
+				return run, fmt.Errorf("the recording %s has an invalid output %s", key, output)
+			}
+
+			path = paths[index]
+		}
+
+		err = ioutil.WriteFile(path, file.Content, file.Mode)
+		if err == nil {
+			err = os.Chmod(path, file.Mode)
+		}
+
+		if err != nil {
+			return run, err
+		}
+	}
+
+	return run, nil
+}
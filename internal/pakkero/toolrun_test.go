@@ -0,0 +1,157 @@
+package pakkero
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// the go commands of the launcher get its environment, the one of pakkero is left alone
+func TestRunToolEnv(t *testing.T) {
+	t.Setenv("GOARCH", "riscv64")
+	t.Setenv("GOPROXY", "direct")
+
+	run, err := runTool(context.Background(), "", "sh", []string{"-c", "echo $GOOS $GOARCH $GOPROXY"},
+		WorkspaceEnviron("arm64"))
+	if err != nil || run.ExitCode != 0 {
+		t.Fatalf("%s: %d, %v", run, run.ExitCode, err)
+	}
+
+	if output := strings.TrimSpace(string(run.Stdout)); output != "linux arm64 off" {
+		t.Errorf("environment of the tool: %q", output)
+	}
+
+	if os.Getenv("GOARCH") != "riscv64" || os.Getenv("GOPROXY") != "direct" {
+		t.Errorf("the environment of pakkero changed: %q %q", os.Getenv("GOARCH"), os.Getenv("GOPROXY"))
+	}
+}
+
+// keepToolRecording restores the tools run as they are once the test is done
+func keepToolRecording(t *testing.T) {
+	t.Helper()
+
+	recording, replaying := toolRecording, toolReplaying
+
+	t.Cleanup(func() { toolRecording, toolReplaying = recording, replaying })
+}
+
+func TestSetToolRecordingFromEnv(t *testing.T) {
+	keepToolRecording(t)
+
+	dir := t.TempDir()
+
+	t.Setenv(ToolRecordEnv, dir)
+	t.Setenv(ToolReplayEnv, dir)
+
+	if err := SetToolRecordingFromEnv(); err == nil {
+		t.Error("recording and replaying together")
+	}
+
+	t.Setenv(ToolRecordEnv, "")
+	t.Setenv(ToolReplayEnv, filepath.Join(dir, "missing"))
+
+	if err := SetToolRecordingFromEnv(); err == nil {
+		t.Error("replaying from a missing directory")
+	}
+
+	t.Setenv(ToolReplayEnv, "")
+	t.Setenv(ToolRecordEnv, filepath.Join(dir, "recordings"))
+
+	if err := SetToolRecordingFromEnv(); err != nil || toolRecording != filepath.Join(dir, "recordings") ||
+		toolReplaying {
+		t.Errorf("recording: %q %v, %v", toolRecording, toolReplaying, err)
+	}
+
+	if stat, err := os.Stat(toolRecording); err != nil || !stat.IsDir() {
+		t.Errorf("the directory of the recordings is not created: %v", err)
+	}
+}
+
+// a recorded run is replayed without the tool, with its output and the files it wrote
+func TestToolReplay(t *testing.T) {
+	keepToolRecording(t)
+
+	recordings := t.TempDir()
+	tool := filepath.Join(t.TempDir(), "faketool")
+
+	err := ioutil.WriteFile(tool, []byte("#!/bin/sh\necho \"$1\" > out.txt\necho written >> \"$2\"\n"+
+		"echo out\necho err >&2\nexit 3\n"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ToolPaths["faketool"] = tool
+
+	t.Cleanup(func() { delete(ToolPaths, "faketool") })
+	pinFakeTool(t, "strip", "GNU strip (GNU Binutils) 2.40\\n")
+
+	// the same input, in other directories of each run
+	run := func(args ...string) (toolRun, string, string, error) {
+		dir, arg := t.TempDir(), filepath.Join(t.TempDir(), "input")
+
+		err := ioutil.WriteFile(arg, []byte("input\n"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := runTool(context.Background(), dir, "faketool", append(args, arg), nil)
+		output, _ := ioutil.ReadFile(filepath.Join(dir, "out.txt"))
+		changed, _ := ioutil.ReadFile(arg)
+
+		return result, string(output), string(changed), err
+	}
+
+	t.Setenv(ToolRecordEnv, recordings)
+
+	if err := SetToolRecordingFromEnv(); err != nil {
+		t.Fatal(err)
+	}
+
+	recorded, output, changed, err := run("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version := ToolVersion("strip")
+
+	// replaying, the tools are gone
+	ToolPaths["faketool"] = filepath.Join(t.TempDir(), "missing")
+	ToolPaths["strip"] = ToolPaths["faketool"]
+
+	t.Setenv(ToolRecordEnv, "")
+	t.Setenv(ToolReplayEnv, recordings)
+
+	if err := SetToolRecordingFromEnv(); err != nil {
+		t.Fatal(err)
+	}
+
+	replayed, replayedOutput, replayedChanged, err := run("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if replayed.ExitCode != 3 || replayed.ExitCode != recorded.ExitCode ||
+		string(replayed.Stdout) != "out\n" || string(replayed.Stderr) != "err\n" || replayed.Path != tool {
+		t.Errorf("replayed run: %d %q %q %s", replayed.ExitCode, replayed.Stdout, replayed.Stderr, replayed.Path)
+	}
+
+	if output != "hello\n" || replayedOutput != output || changed != "input\nwritten\n" ||
+		replayedChanged != changed {
+		t.Errorf("files written: %q %q, replayed %q %q", output, changed, replayedOutput, replayedChanged)
+	}
+
+	if replayedVersion := ToolVersion("strip"); replayedVersion != version || version.Flavor != FlavorGNU {
+		t.Errorf("version: %+v, replayed %+v", version, replayedVersion)
+	}
+
+	if _, _, _, err := run("other"); err == nil || !strings.Contains(err.Error(), ToolRecordEnv) {
+		t.Errorf("a run not recorded: %v", err)
+	}
+
+	if _, err := ResolveTool("objcopy"); err == nil {
+		t.Error("a tool not recorded is resolved")
+	}
+}
@@ -0,0 +1,227 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+External tools library
+*/
+package pakkero
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ToolEnvPrefix is the prefix of the environment variables pinning a tool
+const ToolEnvPrefix = "PAKKERO_TOOL_"
+
+// PinnableTools are the external tools whose path can be pinned
+var PinnableTools = []string{"garble", "go", "strip", "upx"}
+
+/*
+ToolPaths are the pinned absolute paths of the external tools,
+a pinned tool is never searched in PATH.
+*/
+var ToolPaths = map[string]string{}
+
+/*
+PinTool will pin an external tool to an absolute path.
+*/
+func PinTool(name string, path string) error {
+	if !Contains(PinnableTools, name) {
+		return fmt.Errorf("unknown tool %q, tools are: %s",
+			name, strings.Join(PinnableTools, ", "))
+	}
+
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("tool %s needs an absolute path, got %q", name, path)
+	}
+
+	ToolPaths[name] = path
+
+	return nil
+}
+
+/*
+PinToolsFromEnv will pin the tools set in the environment
+as PAKKERO_TOOL_<NAME>=/path/to/tool.
+*/
+func PinToolsFromEnv() error {
+	for _, name := range PinnableTools {
+		path, ok := os.LookupEnv(ToolEnvPrefix + strings.ToUpper(name))
+		if !ok {
+			continue
+		}
+
+		err := PinTool(name, path)
+		if err != nil {
+			return fmt.Errorf("%s%s: %s", ToolEnvPrefix, strings.ToUpper(name), err)
+		}
+	}
+
+	return nil
+}
+
+/*
+PinTools will pin the tools passed in the form name=/path/to/tool.
+*/
+func PinTools(input []string) error {
+	for _, item := range input {
+		pair := strings.SplitN(item, "=", 2)
+		if len(pair) != 2 {
+			return fmt.Errorf("invalid tool %q, use name=/path/to/tool", item)
+		}
+
+		err := PinTool(pair[0], pair[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ResolveTool returns the absolute path of an external tool,
+the pinned one if any, else the one found in PATH.
+A pinned tool must exist and be executable.
+*/
+func ResolveTool(name string) (string, error) {
+	if toolReplaying {
+		tool, err := readRecordedTool(name)
+		if err == nil && tool.Error != "" {
+			err = errors.New(tool.Error)
+		}
+
+		return tool.Path, err
+	}
+
+	path, err := resolveTool(name)
+	if toolRecording != "" {
+		recordTool(name, func(tool *recordedTool) {
+			tool.Path, tool.Error = path, ""
+			if err != nil {
+				tool.Error = err.Error()
+			}
+		})
+	}
+
+	return path, err
+}
+
+// resolveTool is ResolveTool on this host
+func resolveTool(name string) (string, error) {
+	path, pinned := ToolPaths[name]
+	if !pinned {
+		return exec.LookPath(name)
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("pinned tool %s: %s", name, err)
+	}
+
+	if !isExecutable(stat) {
+		return "", fmt.Errorf("pinned tool %s: %s is not an executable file", name, path)
+	}
+
+	return path, nil
+}
+
+// Implementations of the strip found on a host
+const (
+	FlavorGNU     = "gnu"
+	FlavorBusyBox = "busybox"
+	FlavorLLVM    = "llvm"
+	FlavorUnknown = "unknown"
+	FlavorMissing = "missing"
+)
+
+// FlavoredTools are the external tools whose arguments depend on their implementation
+var FlavoredTools = []string{"strip"}
+
+/*
+toolFlavor returns the implementation of a tool from its version
+output: BusyBox applets may say they are "not GNU", so it is looked
+for before GNU.
+*/
+func toolFlavor(banner string) string {
+	switch {
+	case strings.Contains(banner, "BusyBox"), strings.Contains(banner, "This is not GNU"):
+		return FlavorBusyBox
+	case strings.Contains(banner, "LLVM"):
+		return FlavorLLVM
+	case strings.Contains(banner, "GNU"):
+		return FlavorGNU
+	}
+
+	return FlavorUnknown
+}
+
+/*
+ToolFlavor returns the implementation of a flavored tool,
+FlavorMissing if it is not found, FlavorUnknown if it does not say.
+*/
+func ToolFlavor(name string) string {
+	tool := ToolVersion(name)
+
+	switch {
+	case !tool.Found:
+		return FlavorMissing
+	case tool.Flavor == "":
+		return FlavorUnknown
+	}
+
+	return tool.Flavor
+}
+
+/*
+stripArgs returns the arguments stripping the symbols and the sections
+of infile for the implementation of strip, nil if it cannot: BusyBox
+and unknown ones may not remove sections.
+llvm-strip refuses to remove the section names table.
+*/
+func stripArgs(flavor string, infile string) []string {
+	sections := []string{
+		".bss",
+		".comment",
+		".eh_frame",
+		".eh_frame_hdr",
+		".fini",
+		".fini_array",
+		".gnu.build.attributes",
+		".gnu.hash",
+		".gnu.version",
+		".gosymtab",
+		".got",
+		".note.ABI-tag",
+		".note.gnu.build-id",
+		".note.go.buildid",
+		".shstrtab",
+		".typelink",
+	}
+
+	args := []string{}
+
+	switch flavor {
+	case FlavorGNU:
+		args = append(args, "-sxX")
+		for _, section := range sections {
+			args = append(args, "--remove-section="+section)
+		}
+	case FlavorLLVM:
+		args = append(args, "--strip-all", "--discard-all")
+		for _, section := range sections {
+			if section == ".shstrtab" {
+				continue
+			}
+
+			args = append(args, "-R", section)
+		}
+	default:
+		return nil
+	}
+
+	return append(args, infile)
+}
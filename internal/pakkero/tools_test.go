@@ -0,0 +1,187 @@
+package pakkero
+
+import (
+	"bytes"
+	"context"
+	"debug/elf"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// a pinned tool runs whatever PATH has, and a missing one is not looked for in PATH
+func TestPinnedToolPoisonedPath(t *testing.T) {
+	poisoned := t.TempDir()
+
+	for _, name := range []string{"strip", "upx"} {
+		err := ioutil.WriteFile(filepath.Join(poisoned, name), []byte("#!/bin/sh\necho poisoned\n"), 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Setenv("PATH", poisoned+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	pinFakeTool(t, "strip", "pinned")
+
+	run, err := runTool(context.Background(), "", "strip", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if run.Path != ToolPaths["strip"] || string(run.Stdout) != "pinned" {
+		t.Errorf("ran %s, that printed %q", run.Path, run.Stdout)
+	}
+
+	// pinned from the environment, to a tool that is not there
+	t.Setenv(ToolEnvPrefix+"UPX", filepath.Join(t.TempDir(), "upx"))
+	t.Cleanup(func() { delete(ToolPaths, "upx") })
+
+	err = PinToolsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := ResolveTool("upx")
+	if err == nil || !strings.Contains(err.Error(), "pinned tool upx") {
+		t.Errorf("a missing pinned tool resolved to %q, %v", path, err)
+	}
+}
+
+// the implementation is told from the whole version output, LLVM and BusyBox say GNU too
+func TestToolFlavor(t *testing.T) {
+	for banner, expected := range map[string]string{
+		"GNU strip (GNU Binutils) 2.41\\nCopyright (C) 2023 Free Software Foundation, Inc.":        FlavorGNU,
+		"llvm-strip, compatible with GNU strip\\nLLVM (http://llvm.org/):\\n  LLVM version 17.0.6": FlavorLLVM,
+		"BusyBox v1.36.1 (2023-11-07 18:53:09 UTC) multi-call binary.":                             FlavorBusyBox,
+		"This is not GNU strip":       FlavorBusyBox,
+		"strip 1.0, a strip of a BSD": FlavorUnknown,
+	} {
+		pinFakeTool(t, "strip", banner)
+
+		if flavor := ToolFlavor("strip"); flavor != expected {
+			t.Errorf("%q: flavor %s, expected %s", banner, flavor, expected)
+		}
+	}
+
+	if ToolVersion("go").Flavor != "" {
+		t.Error("go has a flavor")
+	}
+
+	ToolPaths["strip"] = filepath.Join(t.TempDir(), "missing")
+
+	if flavor := ToolFlavor("strip"); flavor != FlavorMissing {
+		t.Errorf("a missing strip is %s", flavor)
+	}
+}
+
+/*
+The GNU and LLVM strip get the arguments they take, llvm-strip keeps
+the section names; the others strip nothing. The sections are gone
+from a binary stripped by the strip of the host, that still loads.
+*/
+func TestStripArgs(t *testing.T) {
+	gnu := strings.Join(stripArgs(FlavorGNU, "launcher"), " ")
+	llvm := strings.Join(stripArgs(FlavorLLVM, "launcher"), " ")
+
+	if !strings.HasPrefix(gnu, "-sxX --remove-section=.bss ") || !strings.HasSuffix(gnu, " launcher") ||
+		!strings.Contains(gnu, "--remove-section=.shstrtab") {
+		t.Errorf("gnu strip arguments: %s", gnu)
+	}
+
+	if !strings.HasPrefix(llvm, "--strip-all --discard-all -R .bss ") || !strings.HasSuffix(llvm, " launcher") ||
+		strings.Contains(llvm, ".shstrtab") {
+		t.Errorf("llvm strip arguments: %s", llvm)
+	}
+
+	for _, flavor := range []string{FlavorBusyBox, FlavorUnknown} {
+		if args := stripArgs(flavor, "launcher"); args != nil {
+			t.Errorf("%s strip arguments: %q", flavor, args)
+		}
+	}
+
+	flavor := ToolFlavor("strip")
+	if flavor != FlavorGNU && flavor != FlavorLLVM {
+		t.Skipf("the strip of the host is %s", flavor)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(executable)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	launcher := filepath.Join(t.TempDir(), "launcher")
+
+	err = ioutil.WriteFile(launcher, content, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := exec.Command(ToolVersion("strip").Path, stripArgs(flavor, launcher)...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s: %s", err, output)
+	}
+
+	binary, err := elf.Open(launcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binary.Close()
+
+	for _, section := range []string{".gosymtab", ".note.go.buildid", ".symtab"} {
+		if binary.Section(section) != nil {
+			t.Errorf("%s strip kept %s", flavor, section)
+		}
+	}
+}
+
+// every UPX header sequence is replaced with as many random bytes, the rest is kept
+func TestStripUPXHeaders(t *testing.T) {
+	header := "Info: This file is packed with the UPX executable packer http://upx.sf.net $\n\x00" +
+		"$Id: UPX 3.96 Copyright (C) 1996-2020 the UPX Team. All Rights Reserved. $\n"
+	content := []byte("\x7fELF before " + header + " middle UPX! after")
+
+	infile := filepath.Join(t.TempDir(), "launcher")
+
+	err := ioutil.WriteFile(infile, content, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !StripUPXHeaders(infile) {
+		t.Fatal("the headers are not stripped")
+	}
+
+	stripped, err := ioutil.ReadFile(infile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stripped) != len(content) {
+		t.Errorf("%d bytes, expected %d", len(stripped), len(content))
+	}
+
+	for _, plain := range []string{"UPX", "Copyright", "upx.sf.net", "packed with"} {
+		if bytes.Contains(stripped, []byte(plain)) {
+			t.Errorf("%q is left:\n%q", plain, stripped)
+		}
+	}
+
+	for _, kept := range []string{"\x7fELF before ", " middle ", " after"} {
+		if !bytes.Contains(stripped, []byte(kept)) {
+			t.Errorf("%q is not kept:\n%q", kept, stripped)
+		}
+	}
+
+	if StripUPXHeaders(filepath.Join(t.TempDir(), "missing")) {
+		t.Error("a missing file is stripped")
+	}
+}
@@ -0,0 +1,141 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Userland exec library
+*/
+package pakkero
+
+import (
+	"debug/elf"
+	"fmt"
+)
+
+// Ways the launcher runs the payload
+const (
+	// exec it from a memfd, the default
+	ExecModeMemfd = "memfd"
+	// map it in a fork of the launcher and jump to it, no file
+	// descriptor ever points to it
+	ExecModeUserland = "userland"
+)
+
+// ExecModes lists the ways the launcher can run the payload
+var ExecModes = []string{ExecModeMemfd, ExecModeUserland}
+
+// UserlandArch is the only architecture with the userland exec
+const UserlandArch = launcherAsmArch
+
+/*
+userlandTextAddress is where the userland launcher is linked, far
+from the 0x400000 of the static payloads, that have to be mapped
+where they are linked.
+*/
+const userlandTextAddress = "0x6000001000"
+
+/*
+userlandAsm forks the launcher and jumps to the mapped payload in
+the child, see launcherAsmParts. Only the forking thread exists in
+the child, the go runtime is left behind: its signal handlers and
+its signal stack are reset, its file descriptors above stderr closed.
+*/
+const userlandAsm = `
+// func obCopyTo(to uintptr, from []byte)
+TEXT ·obCopyTo(SB), NOSPLIT, $0-32
+	MOVQ to+0(FP), DI
+	MOVQ from_base+8(FP), SI
+	MOVQ from_len+16(FP), CX
+	CLD
+	REP; MOVSB
+	RET
+
+// func obUserlandSpawn(entry uintptr, stack uintptr) (pid int)
+TEXT ·obUserlandSpawn(SB), NOSPLIT, $0-24
+	MOVQ entry+0(FP), R12
+	MOVQ stack+8(FP), R13
+	// fork, as clone(SIGCHLD, 0) does
+	MOVQ $56, AX
+	MOVQ $17, DI
+	XORQ SI, SI
+	XORQ DX, DX
+	XORQ R10, R10
+	XORQ R8, R8
+	SYSCALL
+	CMPQ AX, $0
+	JEQ child
+	MOVQ AX, pid+16(FP)
+	RET
+child:
+	// below the payload stack: a zero sigaction, SIG_DFL with an
+	// empty mask, and a disabled signal stack
+	LEAQ -64(R13), R9
+	MOVQ $0, 0(R9)
+	MOVQ $0, 8(R9)
+	MOVQ $0, 16(R9)
+	MOVQ $0, 24(R9)
+	MOVQ $0, 32(R9)
+	MOVQ $2, 40(R9)
+	MOVQ $0, 48(R9)
+	MOVQ $1, BX
+reset:
+	// rt_sigaction, SIGKILL and SIGSTOP fail
+	MOVQ $13, AX
+	MOVQ BX, DI
+	MOVQ R9, SI
+	XORQ DX, DX
+	MOVQ $8, R10
+	SYSCALL
+	INCQ BX
+	CMPQ BX, $65
+	JNE reset
+	// rt_sigprocmask, unblock every signal
+	MOVQ $14, AX
+	MOVQ $2, DI
+	MOVQ R9, SI
+	XORQ DX, DX
+	MOVQ $8, R10
+	SYSCALL
+	// sigaltstack
+	MOVQ $131, AX
+	LEAQ 32(R9), DI
+	XORQ SI, SI
+	SYSCALL
+	// close_range, from 3 up, it fails before linux 5.9
+	MOVQ $436, AX
+	MOVQ $3, DI
+	MOVL $0xffffffff, SI
+	XORQ DX, DX
+	SYSCALL
+	MOVQ R13, SP
+	XORQ DX, DX
+	JMP R12
+`
+
+/*
+CheckUserlandPayload returns an error if the payload cannot be run
+by the userland exec: it has to be a static amd64 ELF, either linked
+at a fixed address or a static PIE. Dynamic payloads, needing their
+interpreter, and scripts are not supported.
+*/
+func CheckUserlandPayload(path string) error {
+	file, err := elf.Open(path)
+	if err != nil {
+		return fmt.Errorf("%s: the userland exec runs ELF binaries only", path)
+	}
+	defer file.Close()
+
+	if file.Class != elf.ELFCLASS64 || file.Machine != elf.EM_X86_64 {
+		return fmt.Errorf("%s: the userland exec runs amd64 binaries only", path)
+	}
+
+	if file.Type != elf.ET_EXEC && file.Type != elf.ET_DYN {
+		return fmt.Errorf("%s: not an executable", path)
+	}
+
+	for _, prog := range file.Progs {
+		if prog.Type == elf.PT_INTERP {
+			return fmt.Errorf("%s: the userland exec runs static binaries only, "+
+				"this one needs a dynamic linker", path)
+		}
+	}
+
+	return nil
+}
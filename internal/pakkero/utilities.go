@@ -5,16 +5,16 @@ Utilities library
 package pakkero
 
 import (
-	"bytes"
-	"compress/zlib"
-	"crypto/rand"
+	"context"
+	"debug/elf"
 	"fmt"
-	"io/ioutil"
-	mathRand "math/rand"
+	"go/parser"
+	"go/token"
+	"io"
 	"os"
-	"os/exec"
+	"path"
+	"strconv"
 	"strings"
-	"time"
 )
 
 // Colors for strings
@@ -26,20 +26,23 @@ const (
 const ERR = 1
 const OK = 0
 
+// ERRVERIFY is the exit code of a pack whose output failed its verification
+const ERRVERIFY = 3
+
 /*
 Random will return a random number in a range
 */
 func Random(min, max int64) int64 {
-	mathRand.Seed(time.Now().UnixNano())
-	return mathRand.Int63n(max-min) + min
+	return randomSource.Int63n(max-min) + min
 }
 
 /*
-Unique will deduplicate a given slice
+Unique will deduplicate a given slice, keeping the first occurrence of
+each item in its order: the same input always gives the same output.
 */
-func Unique(slice []string) []string {
-	keys := make(map[string]bool)
-	list := []string{}
+func Unique[T comparable](slice []T) []T {
+	keys := make(map[T]bool)
+	list := []T{}
 
 	for _, entry := range slice {
 		if _, value := keys[entry]; !value {
@@ -52,6 +55,48 @@ func Unique(slice []string) []string {
 	return list
 }
 
+/*
+IsTargetAbs returns true if name is an absolute path on the target:
+a linux one, whatever the host packing it.
+*/
+func IsTargetAbs(name string) bool {
+	return path.IsAbs(name)
+}
+
+/*
+IsELF returns true if the file starts with the ELF magic, false for a
+script or a file too short to be one.
+*/
+func IsELF(name string) (bool, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	magic := make([]byte, len(elf.ELFMAG))
+
+	_, err = io.ReadFull(file, magic)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+
+	return string(magic) == elf.ELFMAG, nil
+}
+
+/*
+Contains will check if a slice contains a given item
+*/
+func Contains[T comparable](slice []T, item T) bool {
+	for _, entry := range slice {
+		if entry == item {
+			return true
+		}
+	}
+
+	return false
+}
+
 /*
 ReverseByteArray will reverse a slice of bytes
 */
@@ -83,16 +128,16 @@ func ReverseByte(b byte) byte {
 }
 
 /*
-ReverseStringArray reverse a slice of strings
+ReverseSlice will reverse a slice in place, and return it
 */
-func ReverseStringArray(ss []string) []string {
-	last := len(ss) - 1
+func ReverseSlice[T any](slice []T) []T {
+	last := len(slice) - 1
 
-	for i := 0; i < len(ss)/2; i++ {
-		ss[i], ss[last-i] = ss[last-i], ss[i]
+	for i := 0; i < len(slice)/2; i++ {
+		slice[i], slice[last-i] = slice[last-i], slice[i]
 	}
 
-	return ss
+	return slice
 }
 
 /*
@@ -109,67 +154,122 @@ func ReverseString(input string) string {
 }
 
 /*
-ShuffleSlice will shuffle a slice.
+ShuffleSlice will shuffle a slice in place, and return it: a
+Fisher-Yates shuffle whose choices are taken from the randomSource,
+so the same seed always gives the same permutation.
+*/
+func ShuffleSlice[T any](slice []T) []T {
+	for i := len(slice) - 1; i > 0; i-- {
+		j := randomSource.Intn(i + 1)
+		slice[i], slice[j] = slice[j], slice[i]
+	}
+
+	return slice
+}
+
+/*
+ExecCommand is a wrapper arount exec.CommandContext to execute a command
+and ensure it's result is not err, the command is killed if ctx is done.
+The command is resolved with ResolveTool, so pinned tools are honored.
+The command output is logged at debug level on success and
+at error level on failure.
 */
-func ShuffleSlice(in []string) []string {
-	mathRand.Seed(time.Now().UnixNano())
-	mathRand.Shuffle(len(in), func(i, j int) { in[i], in[j] = in[j], in[i] })
+func ExecCommand(ctx context.Context, name string, args []string) bool {
+	return ExecCommandIn(ctx, "", name, args)
+}
 
-	return in
+/*
+ExecCommandIn is like ExecCommand, running the command inside dir,
+or in the current directory if dir is empty.
+*/
+func ExecCommandIn(ctx context.Context, dir string, name string, args []string) bool {
+	return execTool(ctx, dir, name, args, nil) == nil
 }
 
 /*
-ExecCommand is a wrapper arount exec.Command to execute a command
-and ensure it's result is not err.
+execTool is ExecCommandIn with env added to the environment, returning a
+PackError with the output of the tool and its hint, see toolHints, if it
+fails.
 */
-func ExecCommand(name string, args []string) bool {
-	cmd := exec.Command(name, args...)
-	errString, err := cmd.CombinedOutput()
+func execTool(ctx context.Context, dir string, name string, args []string, env []string) error {
+	run, err := runTool(ctx, dir, name, args, env)
+	if err == nil && run.ExitCode != 0 {
+		err = fmt.Errorf("exit status %d", run.ExitCode)
+	}
+
+	if run.Path == "" {
+		Log.Errorf("failed to execute command %s: %s", name, err)
+
+		return &PackError{Err: fmt.Errorf("cannot run %s: %s", name, err),
+			Hint: ToolMissingHint(name)}
+	}
 
+	level := LevelDebug
 	if err != nil {
-		println(fmt.Sprintf("failed to execute command %s: %s", cmd, err))
-		println(string(errString))
+		level = LevelError
 
-		return false
+		Log.Errorf("failed to execute command %s: %s", run, err)
+	} else {
+		Log.Debugf("executed command %s", run)
 	}
 
-	return true
+	outputs := []string{}
+
+	for _, output := range []string{string(run.Stdout), string(run.Stderr)} {
+		output = strings.TrimSpace(output)
+		if output != "" {
+			Log.logf(level, "%s", output)
+
+			outputs = append(outputs, output)
+		}
+	}
+
+	if err != nil {
+		return &PackError{Err: fmt.Errorf("%s failed: %s", name, err),
+			Output: strings.Join(outputs, "\n"), Hint: toolHints[name]}
+	}
+
+	return nil
 }
 
 /*
-ListImportsFromFile will extract from a GO file the import section
-and return an array with all the imports (with multiple entry in case
-of sub packages)
+ListImportsFromFile will extract from a GO file the path of
+all its imports, an unreadable file has none
 */
 func ListImportsFromFile(inputFile string) []string {
-	byteContent, _ := ioutil.ReadFile(inputFile)
-	input := string(byteContent)
 	result := []string{}
 
-	// retrieve import section
-	imports := strings.Index(input, "import (")
-	endimports := strings.Index(input[imports:], ")")
-	importSection := input[imports+len("import (")+1 : imports+endimports-1]
-	// now get all imports from the .go file and anonymize them
-	for _, imports := range strings.Split(importSection, "\n") {
-		head := strings.Split(imports, ` `)
-		importlet := head[1]
-		// remove quotation marks
-		importlet = importlet[1 : len(importlet)-1]
-		// add to the group of removal
-		result = append(result, importlet)
+	file, err := parser.ParseFile(token.NewFileSet(), inputFile, nil, parser.ImportsOnly)
+	if err != nil {
+		return result
+	}
+
+	for _, spec := range file.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err == nil {
+			// add to the group of removal
+			result = append(result, importPath)
+		}
 	}
 
 	return result
 }
 
 /*
-GenerateBitshift will transform a char/byte in a series of operations on value 1
+GenerateBitshift will transform a char/byte in a series of operations on value 1,
+held by EAX: every term uses it, so if EAX is not a constant, no part of the
+expression can be folded.
 
 thanks to:
 https://github.com/GH0st3rs/obfus/blob/master/obfus.go
 */
 func GenerateBitshift(n byte) (buf string) {
+	// EAX is 1, the loop below cannot express 0; x^x and x-x
+	// would be folded to 0 whatever x is, x>>x is not
+	if n == 0 {
+		return "(EAX>>EAX)"
+	}
+
 	var arr []byte
 
 	var x uint8
@@ -186,15 +286,13 @@ func GenerateBitshift(n byte) (buf string) {
 
 	buf = "EAX"
 
-	mathRand.Seed(time.Now().Unix())
-
 	for i := len(arr) - 1; i >= 0; i-- {
 		buf = fmt.Sprintf("%s<<%s", buf, "EAX")
 
 		if arr[i] == 1 {
 			op := "(%s|%s)"
 
-			if mathRand.Intn(2) == 0 {
+			if randomSource.Intn(2) == 0 {
 				op = "(%s^%s)"
 			}
 
@@ -211,7 +309,7 @@ GenerateRandomGarbage creates random garbage to rise entropy
 func GenerateRandomGarbage(size int64) string {
 	randomGarbage := make([]byte, size)
 
-	_, err := rand.Read(randomGarbage)
+	err := randomRead(randomGarbage)
 	if err != nil {
 		panic(err)
 	}
@@ -219,24 +317,6 @@ func GenerateRandomGarbage(size int64) string {
 	return string(randomGarbage)
 }
 
-/*
-GzipContent an input byte slice and return it compressed
-*/
-func GzipContent(input []byte) []byte {
-	// GZIP before encrypt
-	var zlibPlaintext bytes.Buffer
-	zlibWriter := zlib.NewWriter(&zlibPlaintext)
-
-	_, err := zlibWriter.Write(input)
-	zlibWriter.Close()
-
-	if err != nil {
-		panic(err)
-	}
-
-	return zlibPlaintext.Bytes()
-}
-
 /*
 GenerateNullString will return a string with only void chars
 */
@@ -244,50 +324,8 @@ func GenerateNullString(n int) string {
 	result := ""
 
 	for len(result) < n {
-		result += string(0)
+		result += string(rune(0))
 	}
 
 	return result
 }
-
-/*
-RegisterDependency will take a file in input and register the
-Byte Frequency Distribution (BFD) and some other data to let the launcher
-do statystical analysis of the found files
-*/
-func RegisterDependency(dependency string) {
-	dependencyFile, _ := os.Open(dependency)
-	defer dependencyFile.Close()
-	dependencyStats, _ := dependencyFile.Stat()
-	depenencyLinkStats, _ := os.Lstat(dependency)
-
-	if (depenencyLinkStats.Mode() & os.ModeSymlink) != 0 {
-		cleanup()
-		fmt.Printf("Invalid path: %s is a symlink, use absolute paths.\n", dependency)
-		os.Exit(1)
-	}
-	// calculate BFD (byte frequency distribution) for the input dependency
-	bytes, _ := ioutil.ReadFile(dependency)
-
-	bfd := make([]float64, 256)
-
-	for _, b := range bytes {
-		bfd[b]++
-	}
-	// make a string out of it
-	bfdString := "[]float64{"
-	for _, v := range bfd {
-		bfdString += fmt.Sprintf("%f", v) + ","
-	}
-
-	bfdString += "}"
-
-	// add Dependency data to the secrets
-	// register BFD
-	Secrets[depBFDPlaceholder] = []string{bfdString, "leaveBFD"}
-	// register name
-	Secrets[depNamePlaceholder] = []string{dependency, GenerateTyposquatName()}
-	// register size
-	Secrets[depSizePlaceholder] = []string{
-		fmt.Sprintf("%d", dependencyStats.Size()), GenerateTyposquatName()}
-}
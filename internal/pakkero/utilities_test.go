@@ -0,0 +1,70 @@
+package pakkero
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestUnique(t *testing.T) {
+	// the first occurrence of each item, in its order
+	result := Unique([]string{"b", "a", "b", "c", "a"})
+	if !reflect.DeepEqual(result, []string{"b", "a", "c"}) {
+		t.Errorf("strings: %v", result)
+	}
+
+	if ints := Unique([]int{3, 3, 1}); !reflect.DeepEqual(ints, []int{3, 1}) {
+		t.Errorf("ints: %v", ints)
+	}
+
+	if empty := Unique([]string(nil)); empty == nil || len(empty) != 0 {
+		t.Errorf("nil: %#v", empty)
+	}
+}
+
+func TestContains(t *testing.T) {
+	if !Contains([]string{"a", "b"}, "b") || Contains([]string{"a", "b"}, "c") || Contains(nil, 0) {
+		t.Error("Contains")
+	}
+}
+
+func TestReverseSlice(t *testing.T) {
+	for _, test := range [][2][]int{
+		{{}, {}},
+		{{1}, {1}},
+		{{1, 2}, {2, 1}},
+		{{1, 2, 3, 4, 5}, {5, 4, 3, 2, 1}},
+	} {
+		input := append([]int{}, test[0]...)
+
+		// in place, and returned
+		result := ReverseSlice(input)
+		if !reflect.DeepEqual(result, test[1]) || !reflect.DeepEqual(input, test[1]) {
+			t.Errorf("%v: %v", test[0], result)
+		}
+	}
+}
+
+// the same seed always gives the same permutation
+func TestShuffleSlice(t *testing.T) {
+	source := randomSource
+	t.Cleanup(func() { randomSource = source; reproducible = false })
+
+	shuffle := func() []int {
+		SetRandomSeed(42)
+
+		return ShuffleSlice([]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9})
+	}
+
+	first := shuffle()
+	if second := shuffle(); !reflect.DeepEqual(first, second) {
+		t.Errorf("seed 42: %v, then %v", first, second)
+	}
+
+	sorted := append([]int{}, first...)
+	sort.Ints(sorted)
+
+	if !reflect.DeepEqual(sorted, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}) {
+		t.Errorf("not a permutation: %v", first)
+	}
+}
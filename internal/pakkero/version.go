@@ -0,0 +1,160 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Version library
+*/
+package pakkero
+
+import (
+	"encoding/base64"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version of pakkero, injected at build time with
+// -ldflags "-X github.com/89luca89/pakkero/internal/pakkero.Version=..."
+var Version = "0.4.0"
+
+// external tools that pakkero may use, with the flag printing their version
+var versionedTools = map[string]string{
+	"garble": "version",
+	"go":     "version",
+	"strip":  "--version",
+	"upx":    "--version",
+}
+
+// oldest go 1.x minor version supported
+const minGoMinorVersion = 13
+
+var goVersionRegex = regexp.MustCompile(`go1\.([0-9]+)`)
+
+// ToolInfo describes an external tool found on this host
+type ToolInfo struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	Found   bool   `json:"found"`
+	// implementation of a flavored tool: gnu, busybox, llvm or unknown
+	Flavor string `json:"flavor,omitempty"`
+}
+
+// BuildInfo describes this pakkero build and the host tools it would use
+type BuildInfo struct {
+	Version   string              `json:"version"`
+	GoVersion string              `json:"go_version"`
+	Platform  string              `json:"platform"`
+	Revision  string              `json:"revision,omitempty"`
+	BuildTags []string            `json:"build_tags"`
+	Features  []string            `json:"features"`
+	Tools     map[string]ToolInfo `json:"tools"`
+}
+
+/*
+LauncherFeatures returns the optional features compiled in the
+embedded launcher stub.
+*/
+func LauncherFeatures() []string {
+	stub, _ := base64.StdEncoding.DecodeString(LauncherStub)
+
+	return templateFeatures(string(stub))
+}
+
+// templateFeatures returns the features of the blocks and of the lines of a launcher source, sorted
+func templateFeatures(source string) []string {
+	regex := regexp.MustCompile(`// OB_FEATURE(?:_BEGIN)? ([!a-z0-9|]+)`)
+	result := []string{}
+
+	for _, match := range regex.FindAllStringSubmatch(source, -1) {
+		for _, name := range strings.Split(match[1], "|") {
+			result = append(result, strings.TrimPrefix(name, "!"))
+		}
+	}
+
+	result = Unique(result)
+	sort.Strings(result)
+
+	return result
+}
+
+/*
+ToolVersion returns the path and the first line of the
+version output of an external tool, pinned tools are honored.
+The implementation of a flavored tool is told from its whole output.
+*/
+func ToolVersion(name string) ToolInfo {
+	path, err := ResolveTool(name)
+	if err != nil {
+		return ToolInfo{}
+	}
+
+	output := []byte{}
+
+	if toolReplaying {
+		tool, _ := readRecordedTool(name)
+		output = tool.Version
+	} else {
+		output, _ = exec.Command(path, versionedTools[name]).CombinedOutput()
+		if toolRecording != "" {
+			recordTool(name, func(tool *recordedTool) { tool.Version = output })
+		}
+	}
+	firstLine := strings.SplitN(strings.TrimSpace(string(output)), "\n", 2)[0]
+
+	info := ToolInfo{Path: path, Version: firstLine, Found: true}
+	if Contains(FlavoredTools, name) {
+		info.Flavor = toolFlavor(string(output))
+	}
+
+	return info
+}
+
+/*
+GoMinorVersion returns the 1.x minor version of the go toolchain
+that will build the launcher, -1 if unknown.
+*/
+func GoMinorVersion() int {
+	match := goVersionRegex.FindStringSubmatch(ToolVersion("go").Version)
+	if match == nil {
+		return -1
+	}
+
+	minor, _ := strconv.Atoi(match[1])
+
+	return minor
+}
+
+/*
+GetBuildInfo returns the version, the toolchain, the build tags and
+the features of this build, together with the external tools
+found on this host.
+*/
+func GetBuildInfo() BuildInfo {
+	info := BuildInfo{
+		Version:   Version,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		BuildTags: []string{},
+		Features:  LauncherFeatures(),
+		Tools:     map[string]ToolInfo{},
+	}
+
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "-tags":
+				info.BuildTags = strings.Split(setting.Value, ",")
+			case "vcs.revision":
+				info.Revision = setting.Value
+			}
+		}
+	}
+
+	for name := range versionedTools {
+		info.Tools[name] = ToolVersion(name)
+	}
+
+	return info
+}
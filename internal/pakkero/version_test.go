@@ -0,0 +1,88 @@
+package pakkero
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// pinFakeTool pins name to a script printing banner, until the end of the test
+func pinFakeTool(t *testing.T, name string, banner string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+
+	err := ioutil.WriteFile(path, []byte("#!/bin/sh\nprintf '"+banner+"'\n"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous, pinned := ToolPaths[name]
+	ToolPaths[name] = path
+
+	t.Cleanup(func() {
+		if pinned {
+			ToolPaths[name] = previous
+		} else {
+			delete(ToolPaths, name)
+		}
+	})
+}
+
+func TestTemplateFeatures(t *testing.T) {
+	features := templateFeatures("// OB_FEATURE_BEGIN zeta\n// OB_FEATURE_END zeta\n" +
+		"// OB_FEATURE_BEGIN !alpha|beta\n// OB_FEATURE_END !alpha|beta\n" +
+		"x := 1 // OB_FEATURE gamma\n// OB_FEATURE_BEGIN zeta\n// OB_FEATURE_END zeta\n")
+	if !reflect.DeepEqual(features, []string{"alpha", "beta", "gamma", "zeta"}) {
+		t.Errorf("features: %v", features)
+	}
+
+	content, err := ioutil.ReadFile(launcherTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a feature unknown to the pack would always be stripped
+	known := (Options{}).launcherFeatures()
+	for feature := range archFeatures("amd64") {
+		known[feature] = true
+	}
+
+	for _, feature := range templateFeatures(string(content)) {
+		if _, ok := known[feature]; !ok {
+			t.Errorf("the template has the feature %s, unknown to the pack", feature)
+		}
+	}
+}
+
+func TestToolVersion(t *testing.T) {
+	pinFakeTool(t, "strip", "GNU strip (GNU Binutils) 2.42\\nCopyright\\n")
+
+	info := ToolVersion("strip")
+	if !info.Found || info.Version != "GNU strip (GNU Binutils) 2.42" || info.Flavor != FlavorGNU ||
+		info.Path != ToolPaths["strip"] {
+		t.Errorf("strip: %+v", info)
+	}
+
+	ToolPaths["strip"] = filepath.Join(t.TempDir(), "missing")
+
+	if info = ToolVersion("strip"); info.Found {
+		t.Errorf("missing strip: %+v", info)
+	}
+}
+
+func TestGoMinorVersion(t *testing.T) {
+	tests := map[string]int{
+		"go version go1.22.3 linux/amd64\\n": 22,
+		"go version devel +abcdef\\n":        -1,
+	}
+
+	for banner, expected := range tests {
+		pinFakeTool(t, "go", banner)
+
+		if minor := GoMinorVersion(); minor != expected {
+			t.Errorf("%q: %d, expected %d", banner, minor, expected)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Payload whitening library
+*/
+package pakkero
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+const whitenRangesPlaceholder = `"WHITENRANGES"`
+const whitenSaltPlaceholder = `"WHITENSALT"`
+
+// number of ranges of the output keying the whitening, and their longest length
+const whitenRanges = 4
+const whitenRangeMax = 64 * 1024
+
+/*
+whitening is the XOR layer over the encrypted payload: its keystream is
+keyed by ranges of the output before the offset, the offset and a salt,
+all chosen at packing time and embedded obfuscated in the launcher.
+*/
+type whitening struct {
+	ranges [][2]int64
+	salt   int64
+}
+
+// payloadWhitening is the whitening of the payload, nil if it is not whitened
+var payloadWhitening *whitening
+
+/*
+RegisterWhitening will choose the ranges of the output before the
+offset keying the whitening of the payload, and add them to the
+secrets with the salt, the build id, so that they will be embedded
+obfuscated in the launcher.
+*/
+func RegisterWhitening(offset int64) {
+	length := offset / whitenRanges
+	if length > whitenRangeMax {
+		length = whitenRangeMax
+	}
+
+	payloadWhitening = &whitening{salt: buildID()}
+	ranges := []string{}
+
+	for i := 0; i < whitenRanges; i++ {
+		start := Random(0, offset-length)
+		payloadWhitening.ranges = append(payloadWhitening.ranges, [2]int64{start, length})
+		ranges = append(ranges, fmt.Sprintf("%d:%d", start, length))
+	}
+
+	Secrets[whitenRangesPlaceholder] = []string{strings.Join(ranges, ","), GenerateTyposquatName()}
+	Secrets[whitenSaltPlaceholder] = []string{fmt.Sprintf("%d", payloadWhitening.salt),
+		GenerateTyposquatName()}
+}
+
+/*
+xor will XOR the data in place with the AES-256-CTR keystream keyed by
+the sha512_256 of the ranges of prefix, then its length and the salt as
+decimal "offset:salt": whitening and unwhitening are the same.
+This is the same logic compiled in the launcher.
+*/
+func (w *whitening) xor(data []byte, prefix []byte) error {
+	seed := []byte{}
+
+	for _, bounds := range w.ranges {
+		if bounds[0]+bounds[1] > int64(len(prefix)) {
+			return fmt.Errorf("the whitening range %d:%d is past the offset %d",
+				bounds[0], bounds[1], len(prefix))
+		}
+
+		seed = append(seed, prefix[bounds[0]:bounds[0]+bounds[1]]...)
+	}
+
+	seed = append(seed, fmt.Sprintf("%d:%d", len(prefix), w.salt)...)
+	key := sha512.Sum512_256(seed)
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return err
+	}
+
+	cipher.NewCTR(block, make([]byte, block.BlockSize())).XORKeyStream(data, data)
+
+	return nil
+}
+
+/*
+WhitenPayload will XOR the encrypted payload with the keystream of the
+whitening, keyed by outfile as it is before the payload.
+*/
+func WhitenPayload(ciphertext string, outfile string) (string, error) {
+	prefix, err := ioutil.ReadFile(outfile)
+	if err != nil {
+		return "", err
+	}
+
+	data := []byte(ciphertext)
+
+	err = payloadWhitening.xor(data, prefix)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
@@ -0,0 +1,173 @@
+package pakkero
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// keepWhitening restores the payload as not whitened once the test is done
+func keepWhitening(t *testing.T) {
+	t.Helper()
+
+	whitening := payloadWhitening
+	t.Cleanup(func() { payloadWhitening = whitening })
+}
+
+func TestRegisterWhitening(t *testing.T) {
+	keepSecrets(t)
+	keepWhitening(t)
+
+	for _, offset := range []int64{1000, 10 * whitenRangeMax} {
+		RegisterWhitening(offset)
+
+		length := offset / whitenRanges
+		if length > whitenRangeMax {
+			length = whitenRangeMax
+		}
+
+		if len(payloadWhitening.ranges) != whitenRanges {
+			t.Fatalf("%d ranges: %v", len(payloadWhitening.ranges), payloadWhitening.ranges)
+		}
+
+		ranges := []string{}
+
+		for _, bounds := range payloadWhitening.ranges {
+			if bounds[0] < 0 || bounds[1] != length || bounds[0]+bounds[1] > offset {
+				t.Errorf("range %v of the offset %d", bounds, offset)
+			}
+
+			ranges = append(ranges, fmt.Sprintf("%d:%d", bounds[0], bounds[1]))
+		}
+
+		if Secrets[whitenRangesPlaceholder][0] != strings.Join(ranges, ",") ||
+			Secrets[whitenSaltPlaceholder][0] != strconv.FormatInt(payloadWhitening.salt, 10) {
+			t.Errorf("secrets: %v %v", Secrets[whitenRangesPlaceholder], Secrets[whitenSaltPlaceholder])
+		}
+	}
+}
+
+// the keystream depends on the ranges of the output only, unwhitening is whitening again
+func TestWhitenPayload(t *testing.T) {
+	keepWhitening(t)
+
+	prefix := bytes.Repeat([]byte("launcher"), 64)
+	payloadWhitening = &whitening{ranges: [][2]int64{{8, 16}, {100, 32}}, salt: 42}
+	outfile := filepath.Join(t.TempDir(), "output")
+
+	err := ioutil.WriteFile(outfile, prefix, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ciphertext := strings.Repeat("payload!", 8)
+
+	whitened, err := WhitenPayload(ciphertext, outfile)
+	if err != nil || whitened == ciphertext || len(whitened) != len(ciphertext) {
+		t.Fatalf("whitened: %q, %v", whitened, err)
+	}
+
+	data := []byte(whitened)
+	if err := payloadWhitening.xor(data, prefix); err != nil || string(data) != ciphertext {
+		t.Errorf("unwhitened: %q, %v", data, err)
+	}
+
+	changed := func(index int, salt int64) bool {
+		other := append([]byte{}, prefix...)
+		other[index] ^= 0xff
+		data := []byte(ciphertext)
+
+		err := (&whitening{ranges: payloadWhitening.ranges, salt: salt}).xor(data, other)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(data) != whitened
+	}
+
+	if !changed(8, 42) || !changed(131, 42) || changed(7, 42) || changed(132, 42) || !changed(200, 43) {
+		t.Error("the keystream is not keyed by the ranges and the salt only")
+	}
+
+	// the offset is part of the key
+	data = []byte(ciphertext)
+	if payloadWhitening.xor(data, append(prefix, 0)); string(data) == whitened {
+		t.Error("the keystream is not keyed by the offset")
+	}
+
+	payloadWhitening = &whitening{ranges: [][2]int64{{500, 13}}}
+	if err := payloadWhitening.xor(data, prefix); err == nil {
+		t.Error("a range past the offset")
+	}
+}
+
+// the launcher removes the whitening like the packer applies it
+func TestLauncherUnwhiten(t *testing.T) {
+	keepSecrets(t)
+	keepWhitening(t)
+
+	prefix := bytes.Repeat([]byte("0123456789abcdef"), 512)
+	RegisterWhitening(int64(len(prefix)))
+
+	ciphertext := bytes.Repeat([]byte("ciphertext"), 10)
+	data := append([]byte{}, ciphertext...)
+
+	err := payloadWhitening.xor(data, prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decls := []string{}
+	for _, name := range []string{"obUnwhiten", "obSensitive", "obUnsafeString", "obWipe"} {
+		decls = append(decls, templateDecl(t, name))
+	}
+
+	program := StripFeatures(`package main
+
+import (
+	obAES "crypto/aes"
+	obCipher "crypto/cipher"
+	obSHA "crypto/sha512"
+	obHex "encoding/hex"
+	obBytes "bytes"
+	"fmt"
+	obOS "os"
+	obStrconv "strconv"
+	obUnsafe "unsafe"
+)
+
+const obCheckDecrypt = 0
+
+func obTamper(obCheck int) {
+	fmt.Println("tampered")
+	obOS.Exit(0)
+}
+
+`+strings.Join(decls, "\n\n")+`
+
+func main() {
+	obPrefix, _ := obHex.DecodeString(`+strconv.Quote(hex.EncodeToString(prefix))+`)
+	obData, _ := obHex.DecodeString(`+strconv.Quote(hex.EncodeToString(data))+`)
+
+	obUnwhiten(obPrefix, obData)
+	fmt.Printf("%s\n", obData)
+
+	obUnwhiten(obPrefix[:100], obData)
+}
+`, archFeatures(runtime.GOARCH))
+
+	// the ranges and the salt are resolved by the string obfuscation in a pack
+	for _, placeholder := range []string{whitenRangesPlaceholder, whitenSaltPlaceholder} {
+		program = strings.ReplaceAll(program, placeholder, strconv.Quote(Secrets[placeholder][0]))
+	}
+
+	if output := runProgram(t, program); output != string(ciphertext)+"\ntampered\n" {
+		t.Errorf("unwhitened by the launcher: %q", output)
+	}
+}
@@ -0,0 +1,19 @@
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Linux extended attributes library
+*/
+package pakkero
+
+import (
+	"syscall"
+)
+
+// xattr holding the file capabilities
+const capabilityXattr = "security.capability"
+
+// hasCapabilities returns true if the file has file capabilities
+func hasCapabilities(path string) bool {
+	size, err := syscall.Getxattr(path, capabilityXattr, nil)
+
+	return err == nil && size > 0
+}
@@ -0,0 +1,28 @@
+package pakkero
+
+import (
+	"encoding/binary"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// file capabilities are warned about, with the ambient capabilities to use instead
+func TestCheckPayloadCapabilities(t *testing.T) {
+	path := writeModeFile(t, "payload", 0755)
+
+	// vfs_cap_data revision 2, effective, with CAP_NET_BIND_SERVICE permitted
+	capability := make([]byte, 20)
+	binary.LittleEndian.PutUint32(capability, 0x02000001)
+	binary.LittleEndian.PutUint32(capability[4:], 1<<10)
+
+	err := syscall.Setxattr(path, capabilityXattr, capability, 0)
+	if err != nil {
+		t.Skipf("cannot set file capabilities: %s", err)
+	}
+
+	warnings := CheckPayloadMode(path)
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "AmbientCapabilities=") {
+		t.Errorf("warnings %q, expected the capabilities one", warnings)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+/*
+Package pakkero will pack, compress and encrypt any type of executable.
+Extended attributes library of the other hosts
+*/
+package pakkero
+
+/*
+hasCapabilities returns true if the file has file capabilities: only
+linux has them, a payload copied to another host has lost them already.
+*/
+func hasCapabilities(path string) bool {
+	return false
+}
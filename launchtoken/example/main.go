@@ -0,0 +1,40 @@
+/*
+Command example is a payload checking it was started by its launcher,
+packed with -launch-token-key, see the test-launch-token target of the
+Makefile. A real payload embeds the public key, here its file is set
+with -ldflags "-X main.publicKeyFile=path".
+*/
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/89luca89/pakkero/launchtoken"
+)
+
+var publicKeyFile = "launchtoken.pub"
+
+func main() {
+	content, err := ioutil.ReadFile(publicKeyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	key, err := launchtoken.ParsePublicKey(content)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", publicKeyFile, err)
+		os.Exit(2)
+	}
+
+	err = launchtoken.Verify(key, 10*time.Second)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Println("launch token verified")
+}
@@ -0,0 +1,132 @@
+/*
+Package launchtoken lets a payload check that it was started by its
+pakkero launcher, packed with -launch-token-key, and not extracted and
+run directly. It only needs the standard library, to be vendored in
+the payload with the public key of the launch tokens:
+
+	key, err := launchtoken.ParsePublicKey(publicKeyPEM)
+	...
+	err = launchtoken.Verify(key, 10*time.Second)
+	if err != nil {
+		os.Exit(1)
+	}
+
+The launcher passes the token in a pipe, whose path is in the Env
+variable, once the pid of the payload is known: it is the Magic, the
+unix time of the launch and the pid, 8 and 4 bytes big endian, then
+their ed25519 signature. The pipe can be read once, the helper reads
+it and removes the variable.
+
+This raises the bar, it is no guarantee: the private key is in the
+launcher, obfuscated, whoever recovers it signs any token.
+*/
+package launchtoken
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Env is the variable with the path of the pipe of the token
+const Env = "OB_LAUNCH_TOKEN"
+
+// Magic starts every launch token
+const Magic = "PKT1"
+
+// Size is the size of a launch token
+const Size = len(Magic) + 8 + 4 + ed25519.SignatureSize
+
+// ErrNoToken is returned when the program was not given a launch token
+var ErrNoToken = errors.New("no launch token, not started by the launcher")
+
+/*
+ParsePublicKey will parse an ed25519 public key in the PEM PKIX
+format, as written by openssl pkey -pubout.
+*/
+func ParsePublicKey(content []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(content)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("not a PEM PUBLIC KEY")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("not an ed25519 public key")
+	}
+
+	return public, nil
+}
+
+/*
+Read will read the launch token given to the program and remove its
+variable, so that its children cannot find it; ErrNoToken if it was
+given none.
+*/
+func Read() ([]byte, error) {
+	path, ok := os.LookupEnv(Env)
+	if !ok {
+		return nil, ErrNoToken
+	}
+
+	os.Unsetenv(Env)
+
+	token, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the launch token: %s", err)
+	}
+
+	return token, nil
+}
+
+/*
+Check will check the signature of the token, that it is for the
+process with the given pid and that it is at most maxAge old at now.
+*/
+func Check(token []byte, key ed25519.PublicKey, pid int, now time.Time, maxAge time.Duration) error {
+	if len(token) != Size || string(token[:len(Magic)]) != Magic {
+		return errors.New("not a launch token")
+	}
+
+	claims := token[:Size-ed25519.SignatureSize]
+	if !ed25519.Verify(key, claims, token[len(claims):]) {
+		return errors.New("invalid launch token signature")
+	}
+
+	if int(binary.BigEndian.Uint32(claims[len(Magic)+8:])) != pid {
+		return errors.New("the launch token is of another process")
+	}
+
+	launched := time.Unix(int64(binary.BigEndian.Uint64(claims[len(Magic):])), 0)
+	// the token has a resolution of a second
+	if now.Sub(launched) > maxAge || launched.Sub(now) > time.Second {
+		return fmt.Errorf("the launch token is of %s, not in the last %s", launched.UTC(), maxAge)
+	}
+
+	return nil
+}
+
+/*
+Verify will read the launch token given to the program and check it is
+signed with key, for this process and at most maxAge old. It is to be
+called once, early: the token cannot be read twice.
+*/
+func Verify(key ed25519.PublicKey, maxAge time.Duration) error {
+	token, err := Read()
+	if err != nil {
+		return err
+	}
+
+	return Check(token, key, os.Getpid(), time.Now(), maxAge)
+}
@@ -0,0 +1,131 @@
+package launchtoken_test
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/89luca89/pakkero/launchtoken"
+)
+
+// sign returns the launch token of pid at launched, as the launcher writes it
+func sign(key ed25519.PrivateKey, launched time.Time, pid int) []byte {
+	token := make([]byte, len(launchtoken.Magic)+8+4)
+	copy(token, launchtoken.Magic)
+	binary.BigEndian.PutUint64(token[len(launchtoken.Magic):], uint64(launched.Unix()))
+	binary.BigEndian.PutUint32(token[len(launchtoken.Magic)+8:], uint32(pid))
+
+	return append(token, ed25519.Sign(key, token)...)
+}
+
+func TestParsePublicKey(t *testing.T) {
+	public, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := launchtoken.ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil || !key.Equal(public) {
+		t.Errorf("public key: %v", err)
+	}
+
+	for _, content := range [][]byte{
+		[]byte("not a key"),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der[1:]}),
+	} {
+		if _, err := launchtoken.ParsePublicKey(content); err == nil {
+			t.Errorf("%q is accepted", content)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, other, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	valid := sign(private, now.Add(-5*time.Second), 1234)
+
+	wrongMagic := append([]byte{}, valid...)
+	wrongMagic[0] = 'X'
+
+	cases := []struct {
+		name  string
+		token []byte
+		valid bool
+	}{
+		{"valid", valid, true},
+		{"the same second", sign(private, now, 1234), true},
+		{"truncated", valid[:launchtoken.Size-1], false},
+		{"magic", wrongMagic, false},
+		{"another key", sign(other, now, 1234), false},
+		{"another pid", sign(private, now, 1235), false},
+		{"too old", sign(private, now.Add(-11*time.Second), 1234), false},
+		{"in the future", sign(private, now.Add(2*time.Second), 1234), false},
+	}
+
+	for _, test := range cases {
+		err := launchtoken.Check(test.token, public, 1234, now, 10*time.Second)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: %v", test.name, err)
+		}
+	}
+}
+
+// the token is read once, its variable is not seen by the children
+func TestVerify(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(launchtoken.Env, "")
+	os.Unsetenv(launchtoken.Env)
+
+	if err := launchtoken.Verify(public, time.Minute); !errors.Is(err, launchtoken.ErrNoToken) {
+		t.Errorf("no token: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "token")
+
+	err = ioutil.WriteFile(path, sign(private, time.Now(), os.Getpid()), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(launchtoken.Env, path)
+
+	if err := launchtoken.Verify(public, time.Minute); err != nil {
+		t.Errorf("token: %v", err)
+	}
+
+	if _, ok := os.LookupEnv(launchtoken.Env); ok {
+		t.Errorf("%s is still set", launchtoken.Env)
+	}
+
+	t.Setenv(launchtoken.Env, filepath.Join(t.TempDir(), "missing"))
+
+	if _, err := launchtoken.Read(); err == nil || errors.Is(err, launchtoken.ErrNoToken) {
+		t.Errorf("a missing token: %v", err)
+	}
+}
@@ -4,95 +4,1940 @@ Package main, calls all the libraries needed and handles cli flags.
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/89luca89/pakkero/artifact"
 	"github.com/89luca89/pakkero/internal/pakkero"
+	"github.com/89luca89/pakkero/obstrings"
 )
 
 const programName = "pakkero"
-const version = "0.4.0"
 const minArgsLen = 2
 
-var dependencies = []string{"ls", "sed", "go", "strip"}
-var dependenciesComplete = []string{"upx", "ls", "sed", "go", "strip"}
+var dependencies = []string{"go"}
+var dependenciesComplete = []string{"upx", "go"}
+
+// without strip the launcher is stripped by the linker only
+var optionalDependencies = []string{"strip"}
+
+// stringList is a flag that can be repeated
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+
+	return nil
+}
+
+// cliOptions are the packing options plus the cli-only ones
+type cliOptions struct {
+	pakkero.Options
+	dependencies  stringList
+	seccompAllow  stringList
+	bundleLibs    stringList
+	tools         stringList
+	fat           stringList
+	hooks         stringList
+	directives    stringList
+	rlimits       stringList
+	offset        string
+	nice          string
+	ionice        string
+	cpuset        string
+	stageTimeouts string
+	envPolicy     string
+	attest        string
+	successCodes  string
+	timeout       time.Duration
+	tmpDir        string
+	fatTargets    []pakkero.FatTarget
+	verbose       bool
+	debug         bool
+	logFile       string
+	noColor       bool
+	version       bool
+}
 
 /*
 TestDependencies if all dependencies are present
-in the system
+in the system, pinned tools must exist and be executable.
 */
-func testDependencies(deps []string) {
+func testDependencies(deps []string, errorJSON string) {
 	for _, v := range deps {
-		if !pakkero.ExecCommand("which", []string{v}) {
-			println("Missing Dependency: " + v)
+		path, err := pakkero.ResolveTool(v)
+		if err != nil {
+			pakkero.ReportError(&pakkero.PackError{Stage: "Checking Dependencies",
+				Err: fmt.Errorf("missing dependency: %s: %w", v, err), Hint: pakkero.ToolMissingHint(v)}, errorJSON)
 			os.Exit(pakkero.ERR)
 		}
+
+		if pakkero.Log.Enabled(pakkero.LevelInfo) && pakkero.Contains(pakkero.PinnableTools, v) {
+			tool := pakkero.ToolVersion(v)
+			if tool.Flavor != "" {
+				pakkero.Log.Infof("using %s: %s (%s, %s)", v, path, tool.Version, tool.Flavor)
+			} else {
+				pakkero.Log.Infof("using %s: %s (%s)", v, path, tool.Version)
+			}
+		}
 	}
 }
 
 /*
-Print version.
+checkTempDir will choose the directory of the temporary files, the
+asked one or a usable one of the default ones, and exit with the
+reason of each one if none can be written to and run files from.
 */
-func printVersion() {
-	println(programName + " v" + version)
-	os.Exit(pakkero.OK)
+func checkTempDir(asked string, errorJSON string) {
+	dir, probes, err := pakkero.SelectTempDir(asked)
+	if err != nil {
+		pakkero.ReportError(&pakkero.PackError{Stage: "Checking Dependencies", Err: err,
+			Hint: "use -tmp-dir or TMPDIR with a writable directory on a filesystem mounted without noexec"}, errorJSON)
+		os.Exit(pakkero.ERR)
+	}
+
+	for _, probe := range probes {
+		pakkero.Log.Warnf("%s, using %s", probe, dir)
+	}
 }
 
 /*
-Print Help.
+Print version, the toolchain, the features and the external
+tools found, as text or as json.
 */
-func help() {
-	println("Usage: " + programName + " -file /path/to/file -offset OFFSET (-o /path/to/output) (-c) (-register-dep /path/to/file)")
-	println("  -file <file>		Target file to Pack")
-	println("  -o   <file>		place the output into <file> (default is <inputfile>.enc), optional")
-	println("  -c   			compress the output to occupy less space (uses UPX, optional)")
-	println("  -offset		Offset where to start the payload (Number of Bytes, optional)")
-	println("  -register-dep		/path/to/dependency to analyze and use as fingerprint (absolute path, optional)")
-	println("  -v			Check " + programName + " version")
-}
-func main() {
-	if len(os.Args) < minArgsLen {
-		help()
-		os.Exit(pakkero.ERR)
+func printVersion(args []string) int {
+	flags := flag.NewFlagSet("version", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "")
+
+	if flags.Parse(args) != nil || flags.NArg() > 0 {
+		println("Usage: " + programName + " version [-json]")
+
+		return pakkero.ERR
+	}
+
+	err := pakkero.PinToolsFromEnv()
+	if err != nil {
+		pakkero.Log.Errorf("%s", err)
+
+		return pakkero.ERR
+	}
+
+	info := pakkero.GetBuildInfo()
+
+	if *asJSON {
+		output, _ := json.MarshalIndent(info, "", "  ")
+		fmt.Println(string(output))
+
+		return pakkero.OK
 	}
 
-	flag.Usage = func() {
-		help()
+	fmt.Printf("%s v%s\n", programName, info.Version)
+	fmt.Printf("go:         %s %s\n", info.GoVersion, info.Platform)
+
+	if info.Revision != "" {
+		fmt.Printf("revision:   %s\n", info.Revision)
 	}
-	file := flag.String("file", "", "")
-	dependency := flag.String("register-dep", "", "")
-	output := flag.String("o", "", "")
-	offset := flag.Int64("offset", 0, "")
-	compress := flag.Bool("c", false, "")
-	flag.Bool("v", false, "")
-	flag.Parse()
 
-	switch os.Args[1] {
-	case "-v":
-		printVersion()
-	default:
-		// fist test if all dependencies are present
-		if *compress {
-			// compression needs additional upx dependency
-			testDependencies(dependenciesComplete)
+	fmt.Printf("build tags: %s\n", strings.Join(info.BuildTags, ", "))
+	fmt.Printf("features:   %s\n", strings.Join(info.Features, ", "))
+	fmt.Println("tools:")
+
+	tools := []string{}
+	for name := range info.Tools {
+		tools = append(tools, name)
+	}
+
+	sort.Strings(tools)
+
+	for _, name := range tools {
+		tool := info.Tools[name]
+		if tool.Found && tool.Flavor != "" {
+			fmt.Printf("  %-6s %s (%s, %s)\n", name, tool.Version, tool.Flavor, tool.Path)
+		} else if tool.Found {
+			fmt.Printf("  %-6s %s (%s)\n", name, tool.Version, tool.Path)
 		} else {
-			testDependencies(dependencies)
+			fmt.Printf("  %-6s not found\n", name)
 		}
+	}
 
-		// set a default offset if not specified
-		if *offset == 0 {
-			if *compress {
-				*offset = pakkero.Random(800000, 900000)
-			} else {
-				*offset = pakkero.Random(1880000, 1900000)
-			}
+	return pakkero.OK
+}
+
+/*
+Verify that an artifact is the output described by a manifest, or
+signed by a key.
+*/
+func verifyManifest(args []string) int {
+	if len(args) == 3 && args[0] == "-pubkey" {
+		return verifySignature(args[1], args[2])
+	}
+
+	if len(args) != 2 {
+		println("Usage: " + programName + " verify manifest.json file | verify -pubkey public.pem file")
+
+		return pakkero.ERR
+	}
+
+	err := pakkero.VerifyManifest(args[0], args[1])
+	if err != nil {
+		pakkero.Log.Errorf("%s", err)
+
+		return pakkero.ERR
+	}
+
+	fmt.Printf("%s: OK\n", args[1])
+
+	return pakkero.OK
+}
+
+/*
+Verify that an artifact is signed, with -sign-key, by the private key
+of a public key, reading it with the artifact package only.
+*/
+func verifySignature(pubKey string, path string) int {
+	key, err := pakkero.ReadLicensePublicKey(pubKey)
+	if err != nil {
+		pakkero.Log.Errorf("%s", err)
+
+		return pakkero.ERR
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		pakkero.Log.Errorf("%s", err)
+
+		return pakkero.ERR
+	}
+	defer file.Close()
+
+	header, err := artifact.ParseHeader(file)
+	if err == nil {
+		err = artifact.VerifySignature(header, key)
+	}
+
+	if err != nil {
+		pakkero.Log.Errorf("%s: %s", path, err)
+
+		return pakkero.ERR
+	}
+
+	fmt.Printf("%s: signed by %s, packed by pakkero %s\n", path, pakkero.LicenseKeyID(key), header.Version)
+
+	return pakkero.OK
+}
+
+/*
+Verify the chain of the records of an audit log written with -audit-log.
+*/
+func verifyAudit(args []string) int {
+	if len(args) != 2 || args[0] != "verify" {
+		println("Usage: " + programName + " audit verify audit.log")
+
+		return pakkero.ERR
+	}
+
+	count, err := pakkero.VerifyAuditLog(args[1])
+	if err != nil {
+		pakkero.Log.Errorf("%s: %s", args[1], err)
+
+		return pakkero.ERR
+	}
+
+	fmt.Printf("%s: %d records OK\n", args[1], count)
+
+	return pakkero.OK
+}
+
+/*
+Issue or verify a license file for the launchers packed with -license-pubkey.
+*/
+func license(args []string) int {
+	if len(args) > 0 && args[0] == "verify" {
+		return verifyLicense(args[1:])
+	}
+
+	return issueLicense(args)
+}
+
+/*
+Issue a license file for the launchers packed with -license-pubkey.
+*/
+func issueLicense(args []string) int {
+	flags := flag.NewFlagSet("license", flag.ContinueOnError)
+	key := flags.String("key", "", "")
+	output := flags.String("o", "", "")
+	expires := flags.String("expires", "", "")
+	machineID := flags.String("machine-id", "", "")
+	thisMachine := flags.Bool("this-machine", false, "")
+
+	if len(args) == 0 || args[0] != "issue" || flags.Parse(args[1:]) != nil ||
+		flags.NArg() > 0 || *key == "" || *output == "" ||
+		(*machineID != "" && *thisMachine) {
+		println("Usage: " + programName + " license issue -key private.pem -o license " +
+			"[-expires date] [-machine-id id | -this-machine]")
+
+		return pakkero.ERR
+	}
+
+	license := pakkero.License{MachineID: *machineID}
+
+	if *expires != "" {
+		expiry, err := pakkero.ParseArmAfter(*expires)
+		if err != nil {
+			pakkero.Log.Errorf("-expires: %s", err)
+
+			return pakkero.ERR
 		}
-		if *file != "" {
-			pakkero.Pakkero(*file, *offset, *output, *dependency, *compress)
-		} else {
-			println("Missing arguments or invalid arguments!")
-			help()
-			os.Exit(pakkero.ERR)
+
+		license.Expires = time.Unix(expiry, 0).UTC()
+	}
+
+	var err error
+
+	if *thisMachine {
+		license.MachineID, err = pakkero.ReadMachineID()
+		if err != nil {
+			pakkero.Log.Errorf("%s", err)
+
+			return pakkero.ERR
+		}
+	}
+
+	private, err := pakkero.ReadLicensePrivateKey(*key)
+	if err != nil {
+		pakkero.Log.Errorf("%s", err)
+
+		return pakkero.ERR
+	}
+
+	content, err := pakkero.IssueLicense(private, license)
+	if err == nil {
+		err = ioutil.WriteFile(*output, content, 0644)
+	}
+
+	if err != nil {
+		pakkero.Log.Errorf("%s", err)
+
+		return pakkero.ERR
+	}
+
+	return pakkero.OK
+}
+
+/*
+Issue or verify an override token, relaxing some checks of the
+launchers packed with -override-pubkey until it expires.
+*/
+func override(args []string) int {
+	if len(args) > 0 && args[0] == "verify" {
+		return verifyOverride(args[1:])
+	}
+
+	flags := flag.NewFlagSet("override", flag.ContinueOnError)
+	key := flags.String("key", "", "")
+	output := flags.String("o", "", "")
+	ttl := flags.Duration("ttl", 0, "")
+	checks := stringList{}
+	flags.Var(&checks, "checks", "")
+
+	if len(args) == 0 || args[0] != "issue" || flags.Parse(args[1:]) != nil ||
+		flags.NArg() > 0 || *key == "" || *output == "" || *ttl == 0 || len(checks) == 0 {
+		println("Usage: " + programName + " override issue -key private.pem -checks " +
+			strings.Join(pakkero.OverrideCheckNames(), ",") + " -ttl duration -o token")
+
+		return pakkero.ERR
+	}
+
+	mask, err := pakkero.ParseOverrideChecks(checks)
+	if err != nil {
+		pakkero.Log.Errorf("-checks: %s", err)
+
+		return pakkero.ERR
+	}
+
+	private, err := pakkero.ReadLicensePrivateKey(*key)
+	if err != nil {
+		pakkero.Log.Errorf("%s", err)
+
+		return pakkero.ERR
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+
+	content, err := pakkero.IssueOverride(private, pakkero.Override{
+		Issued:  now,
+		Expires: now.Add(*ttl),
+		Checks:  mask,
+	})
+	if err == nil {
+		err = ioutil.WriteFile(*output, content, 0644)
+	}
+
+	if err != nil {
+		pakkero.Log.Errorf("%s", err)
+
+		return pakkero.ERR
+	}
+
+	return pakkero.OK
+}
+
+/*
+Verify an override token as the launcher does, and report the checks
+it relaxes and until when.
+*/
+func verifyOverride(args []string) int {
+	flags := flag.NewFlagSet("override", flag.ContinueOnError)
+	key := flags.String("key", "", "")
+
+	if flags.Parse(args) != nil || flags.NArg() != 1 || *key == "" {
+		println("Usage: " + programName + " override verify -key pub.pem token")
+
+		return pakkero.ERR
+	}
+
+	public, err := pakkero.ReadLicensePublicKey(*key)
+	if err != nil {
+		pakkero.Log.Errorf("%s", err)
+
+		return pakkero.ERR
+	}
+
+	content, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		pakkero.Log.Errorf("%s", err)
+
+		return pakkero.ERR
+	}
+
+	token, err := pakkero.VerifyOverride(content, public, time.Now())
+	if err != nil {
+		pakkero.Log.Errorf("%s: %s", flags.Arg(0), err)
+
+		return pakkero.ERR
+	}
+
+	fmt.Printf("relaxes %s until %s\n", strings.Join(pakkero.RelaxedChecks(token.Checks), ", "),
+		token.Expires.Format(time.RFC3339))
+
+	return pakkero.OK
+}
+
+/*
+Verify a license file as the launcher does, against the public keys of
+a rotation, and report which one signed it.
+*/
+func verifyLicense(args []string) int {
+	flags := flag.NewFlagSet("license", flag.ContinueOnError)
+	pubKeys := stringList{}
+	flags.Var(&pubKeys, "key", "")
+	machineID := flags.String("machine-id", "", "")
+	thisMachine := flags.Bool("this-machine", false, "")
+
+	if flags.Parse(args) != nil || flags.NArg() != 1 || len(pubKeys) == 0 ||
+		(*machineID != "" && *thisMachine) {
+		println("Usage: " + programName + " license verify -key pub.pem [-key pub.pem...] " +
+			"[-machine-id id | -this-machine] license")
+
+		return pakkero.ERR
+	}
+
+	var err error
+
+	if *thisMachine {
+		*machineID, err = pakkero.ReadMachineID()
+		if err != nil {
+			pakkero.Log.Errorf("%s", err)
+
+			return pakkero.ERR
+		}
+	}
+
+	keys := []ed25519.PublicKey{}
+
+	for _, pubKey := range pubKeys {
+		key, err := pakkero.ReadLicensePublicKey(pubKey)
+		if err != nil {
+			pakkero.Log.Errorf("%s", err)
+
+			return pakkero.ERR
+		}
+
+		keys = append(keys, key)
+	}
+
+	content, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		pakkero.Log.Errorf("%s", err)
+
+		return pakkero.ERR
+	}
+
+	slot, err := pakkero.VerifyLicense(content, keys, time.Now(), *machineID)
+	if slot >= 0 {
+		fmt.Printf("%s: signed by key %d, %s (%s)\n", flags.Arg(0), slot+1,
+			pubKeys[slot], pakkero.LicenseKeyID(keys[slot]))
+	}
+
+	if err != nil {
+		pakkero.Log.Errorf("%s: %s", flags.Arg(0), err)
+
+		return pakkero.ERR
+	}
+
+	fmt.Printf("%s: OK\n", flags.Arg(0))
+
+	return pakkero.OK
+}
+
+/*
+Replace the payload of a file packed with -repackable, keeping its launcher.
+*/
+func repackPayload(args []string) int {
+	flags := flag.NewFlagSet("repack", flag.ContinueOnError)
+	packed := flags.String("packed", "", "")
+	infile := flags.String("file", "", "")
+	output := flags.String("o", "", "")
+
+	if flags.Parse(args) != nil || flags.NArg() > 0 ||
+		*packed == "" || *infile == "" || *output == "" {
+		println("Usage: " + programName + " repack -packed old.enc -file payload -o new.enc")
+
+		return pakkero.ERR
+	}
+
+	// a link to it is the file too
+	for _, input := range []string{*packed, *infile} {
+		same, err := pakkero.SameFile(input, *output)
+		if err != nil || same {
+			pakkero.Log.Errorf("-o must not be the -packed file nor the -file payload, " +
+				"keep the packed one until the new one is deployed")
+
+			return pakkero.ERR
+		}
+	}
+
+	err := pakkero.Repack(*packed, *infile, *output)
+	if err != nil {
+		pakkero.Cleanup()
+		pakkero.Log.Errorf("%s", err)
+
+		if errors.Is(err, pakkero.ErrCorruptOutput) {
+			return pakkero.ERRVERIFY
+		}
+
+		return pakkero.ERR
+	}
+
+	fmt.Printf("%s: repacked\n", *output)
+
+	return pakkero.OK
+}
+
+/*
+Recover the payload of a file packed with -recoverable, with the
+recovery key printed when packing it.
+*/
+func extractPayload(args []string) int {
+	flags := flag.NewFlagSet("extract", flag.ContinueOnError)
+	packed := flags.String("packed", "", "")
+	key := flags.String("key", "", "")
+	output := flags.String("o", "", "")
+
+	if flags.Parse(args) != nil || flags.NArg() > 0 ||
+		*packed == "" || *key == "" || *output == "" {
+		println("Usage: " + programName + " extract -packed app.enc -key hex|file -o payload")
+
+		return pakkero.ERR
+	}
+
+	// the key is given as is or in a file
+	keyText := *key
+	if content, err := ioutil.ReadFile(*key); err == nil {
+		keyText = string(content)
+	}
+
+	recoveryKey, err := pakkero.ParseRecoveryKey(keyText)
+	if err != nil {
+		pakkero.Log.Errorf("-key: %s", err)
+
+		return pakkero.ERR
+	}
+
+	err = pakkero.Extract(*packed, recoveryKey, *output)
+	if err != nil {
+		pakkero.Cleanup()
+		pakkero.Log.Errorf("%s", err)
+
+		return pakkero.ERR
+	}
+
+	fmt.Printf("%s: extracted\n", *output)
+
+	return pakkero.OK
+}
+
+/*
+Hide the annotated strings of go sources, for payloads written in go,
+usable with: //go:generate pakkero obstrings $GOFILE
+*/
+func hideStrings(args []string) int {
+	if len(args) == 0 {
+		println("Usage: " + programName + " obstrings file.go [file.go...]")
+
+		return pakkero.ERR
+	}
+
+	count, err := obstrings.Generate(args)
+	if err != nil {
+		pakkero.Log.Errorf("%s", err)
+
+		return pakkero.ERR
+	}
+
+	fmt.Printf("%d strings hidden\n", count)
+
+	return pakkero.OK
+}
+
+/*
+Decrypt the registration records written by the launchers packed
+with -register-host.
+*/
+func decryptRegistration(args []string) int {
+	flags := flag.NewFlagSet("registration", flag.ContinueOnError)
+	key := flags.String("key", "", "")
+
+	if len(args) == 0 || args[0] != "decrypt" || flags.Parse(args[1:]) != nil ||
+		flags.NArg() == 0 || *key == "" {
+		println("Usage: " + programName + " registration decrypt -key private.pem record [record...]")
+
+		return pakkero.ERR
+	}
+
+	private, err := pakkero.ReadRegistrationPrivateKey(*key)
+	if err != nil {
+		pakkero.Log.Errorf("%s", err)
+
+		return pakkero.ERR
+	}
+
+	result := pakkero.OK
+
+	for _, path := range flags.Args() {
+		content, err := ioutil.ReadFile(path)
+		if err == nil {
+			var registration pakkero.Registration
+
+			registration, err = pakkero.DecryptRegistration(content, private)
+			if err == nil {
+				fmt.Printf("%s: build id %d, adopted %s, hostname %q, machine id sha256 %s\n",
+					path, registration.BuildID, registration.Time.Format(time.RFC3339),
+					registration.Hostname, registration.MachineIDHash)
+
+				continue
+			}
 		}
+
+		pakkero.Log.Errorf("%s: %s", path, err)
+
+		result = pakkero.ERR
+	}
+
+	return result
+}
+
+/*
+Decrypt the output captured by the launchers packed with -capture-output.
+*/
+func decryptLogs(args []string) int {
+	flags := flag.NewFlagSet("logs", flag.ContinueOnError)
+	key := flags.String("key", "", "")
+	input := flags.String("i", "", "")
+	output := flags.String("o", "", "")
+	stream := flags.String("stream", "all", "")
+
+	if len(args) == 0 || args[0] != "decrypt" || flags.Parse(args[1:]) != nil ||
+		flags.NArg() > 0 || *key == "" || *input == "" ||
+		!pakkero.Contains([]string{"stdout", "stderr", "all"}, *stream) {
+		println("Usage: " + programName + " logs decrypt -key hex|file -i file " +
+			"[-o file] [-stream stdout|stderr|all]")
+
+		return pakkero.ERR
 	}
+
+	// the key is given as is or in a file
+	keyText := *key
+	if content, err := ioutil.ReadFile(*key); err == nil {
+		keyText = string(content)
+	}
+
+	captureKey, err := pakkero.ParseCaptureKey(keyText)
+	if err != nil {
+		pakkero.Log.Errorf("-key: %s", err)
+
+		return pakkero.ERR
+	}
+
+	inputFile, err := os.Open(*input)
+	if err != nil {
+		pakkero.Log.Errorf("%s", err)
+
+		return pakkero.ERR
+	}
+	defer inputFile.Close()
+
+	outputFile := os.Stdout
+
+	if *output != "" {
+		outputFile, err = os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			pakkero.Log.Errorf("%s", err)
+
+			return pakkero.ERR
+		}
+		defer outputFile.Close()
+	}
+
+	// 0 keeps both streams
+	selected := map[string]byte{"stdout": pakkero.CaptureStdout, "stderr": pakkero.CaptureStderr}[*stream]
+
+	err = pakkero.DecryptCapture(inputFile, captureKey, func(frameStream byte, content []byte) error {
+		if selected != 0 && selected != frameStream {
+			return nil
+		}
+
+		_, err := outputFile.Write(content)
+
+		return err
+	})
+	if err != nil {
+		pakkero.Log.Errorf("%s: %s", *input, err)
+
+		return pakkero.ERR
+	}
+
+	return pakkero.OK
+}
+
+/*
+runBenchmarks will time the packing on this machine and print a table,
+against a baseline saved with -json if given. It is not in the help.
+*/
+func runBenchmarks(args []string) int {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	runs := flags.Int("runs", 3, "")
+	quick := flags.Bool("quick", false, "")
+	payload := flags.String("payload", "/bin/true", "")
+	coldSize := flags.Int("cold-size", 200, "")
+	save := flags.String("json", "", "")
+	baselinePath := flags.String("baseline", "", "")
+
+	if flags.Parse(args) != nil || flags.NArg() > 0 || *runs < 1 {
+		println("Usage: " + programName + " bench [-runs n] [-quick] [-payload file] [-cold-size MB] " +
+			"[-json results.json] [-baseline results.json]")
+
+		return pakkero.ERR
+	}
+
+	err := pakkero.PinToolsFromEnv()
+	if err != nil {
+		pakkero.Log.Errorf("%s", err)
+
+		return pakkero.ERR
+	}
+
+	baseline := []pakkero.BenchResult{}
+
+	if *baselinePath != "" {
+		baseline, err = pakkero.ReadBenchResults(*baselinePath)
+		if err != nil {
+			pakkero.Log.Errorf("%s", err)
+
+			return pakkero.ERR
+		}
+	}
+
+	config := pakkero.BenchConfig{
+		Runs:        *runs,
+		ScrubSizes:  []int{10, 50, 200},
+		Literals:    []int{10, 100, 1000},
+		SecretSizes: []int{16},
+		Payload:     *payload,
+		ColdSize:    *coldSize,
+	}
+
+	// the quick run skips the biggest fixtures, the builds and the packs
+	if *quick {
+		config.ScrubSizes = config.ScrubSizes[:1]
+		config.Literals = config.Literals[:2]
+		config.SecretSizes = nil
+	} else {
+		config.Executable, err = os.Executable()
+		if err != nil {
+			pakkero.Log.Errorf("%s", err)
+
+			return pakkero.ERR
+		}
+	}
+
+	results, err := pakkero.RunBenchmarks(config)
+	pakkero.PrintBenchResults(os.Stdout, results, baseline)
+
+	if err != nil {
+		pakkero.Log.Errorf("%s", err)
+
+		return pakkero.ERR
+	}
+
+	if *save != "" {
+		output, _ := json.MarshalIndent(results, "", "  ")
+
+		err = ioutil.WriteFile(*save, append(output, '\n'), 0644)
+		if err != nil {
+			pakkero.Log.Errorf("%s", err)
+
+			return pakkero.ERR
+		}
+	}
+
+	return pakkero.OK
+}
+
+/*
+Propose the words of the go runtime that a launcher built with the
+current toolchain holds and the scrubbing misses, and with -write add
+them to the calibration file the packs read.
+*/
+func calibrate(args []string) int {
+	flags := flag.NewFlagSet("calibrate", flag.ContinueOnError)
+	arch := flags.String("arch", runtime.GOARCH, "")
+	binary := flags.String("binary", "", "")
+	minCount := flags.Int("min-count", pakkero.DefaultCalibrationCount, "")
+	write := flags.Bool("write", false, "")
+	output := flags.String("o", "", "")
+
+	if flags.Parse(args) != nil || flags.NArg() > 0 || *minCount < 1 {
+		println("Usage: " + programName + " calibrate [-arch arch] [-binary file] [-min-count n] [-write] [-o file]")
+
+		return pakkero.ERR
+	}
+
+	path := *output
+	if path == "" {
+		path = os.Getenv(pakkero.CalibrationEnv)
+	}
+
+	if path == "" {
+		path = pakkero.DefaultCalibrationPath()
+	}
+
+	err := pakkero.PinToolsFromEnv()
+
+	// what is calibrated already is not proposed again
+	if _, statErr := os.Stat(path); err == nil && statErr == nil {
+		err = pakkero.LoadCalibration(path)
+	}
+
+	proposal := []pakkero.CalibrationWord{}
+
+	if err == nil && *binary != "" {
+		proposal, err = pakkero.CalibrateBinary(*binary, *minCount)
+	} else if err == nil {
+		err = pakkero.CheckArch(*arch, false)
+		if err == nil {
+			testDependencies(dependencies, "")
+			checkTempDir("", "")
+
+			// the launcher of the default options
+			opts := cliOptions{}
+			newFlagSet(&opts)
+			opts.Arch = *arch
+
+			proposal, err = pakkero.CalibrateLauncher(context.Background(), opts.Options, *minCount)
+		}
+	}
+
+	if err != nil {
+		pakkero.Log.Errorf("%s", err)
+
+		return pakkero.ERR
+	}
+
+	for _, word := range proposal {
+		fmt.Printf("%-24s %d\n", word.Word, word.Count)
+	}
+
+	if !*write {
+		return pakkero.OK
+	}
+
+	added, err := pakkero.WriteCalibration(path, proposal)
+	if err != nil {
+		pakkero.Log.Errorf("%s", err)
+
+		return pakkero.ERR
+	}
+
+	fmt.Printf("%s: %d words added\n", path, added)
+
+	return pakkero.OK
+}
+
+/*
+Predict the output size and the launcher startup of a pack with the
+same flags, without building it. With -manifest the estimate is
+written as an estimated manifest.
+*/
+func estimatePack(args []string) int {
+	opts := cliOptions{}
+	flags := newFlagSet(&opts)
+
+	err := flags.Parse(args)
+	if err == nil && flags.NArg() > 0 {
+		err = errors.New("unexpected arguments: " + strings.Join(flags.Args(), " "))
+	}
+
+	if err == nil {
+		err = validateOptions(flags, &opts)
+	}
+
+	if err != nil {
+		println("Usage: " + programName + " estimate -file /path/to/file [options]")
+		pakkero.Log.Errorf("%s", explainFlagError(flags, err))
+
+		return pakkero.ERR
+	}
+
+	setDefaultOffset(&opts)
+
+	if opts.OutFile == "" {
+		opts.OutFile = opts.InFile + ".enc"
+	}
+
+	estimate, err := pakkero.EstimatePack(opts.Options)
+	if err != nil {
+		pakkero.Log.Errorf("%s", err)
+
+		return pakkero.ERR
+	}
+
+	fmt.Printf("%-14s %d bytes\n", "launcher", estimate.Layout.LauncherSize)
+	fmt.Printf("%-14s %d bytes, %d of garbage\n", "offset", estimate.Offset, estimate.Layout.KeyMaterial)
+	fmt.Printf("%-14s %d bytes, compressed to %.1f%%\n", "payload", estimate.Payload, estimate.Compression*100)
+	fmt.Printf("%-14s %d bytes\n", "padding", estimate.Padding)
+	fmt.Printf("%-14s %d bytes, within %d%%\n", "output", estimate.Size, pakkero.EstimateTolerance)
+	fmt.Printf("%-14s %s: keys %s, decryption %s, decompression %s\n", "startup",
+		estimate.Startup.Total.Round(time.Microsecond), estimate.Startup.KeyDerivation.Round(time.Microsecond),
+		estimate.Startup.Decryption.Round(time.Microsecond), estimate.Startup.Decompression.Round(time.Microsecond))
+
+	switch {
+	case estimate.Layout.KeyMaterial < 0:
+		pakkero.Log.Warnf("the offset is lower than the launcher, the pack would fail: use -offset auto")
+	case estimate.Layout.KeyMaterial < pakkero.MinKeyMaterial:
+		pakkero.Log.Warnf("only about %d bytes of garbage would derive the key, use -offset auto",
+			estimate.Layout.KeyMaterial)
+	}
+
+	if opts.Manifest != "" {
+		err = pakkero.WriteEstimate(opts.Manifest, opts.Options, estimate)
+		if err != nil {
+			pakkero.Log.Errorf("failed writing the manifest: %s", err)
+
+			return pakkero.ERR
+		}
+	}
+
+	return pakkero.OK
+}
+
+// setDefaultOffset will set a random offset if none is specified
+func setDefaultOffset(opts *cliOptions) {
+	if opts.Offset == 0 && !opts.AutoOffset {
+		if opts.Compress {
+			opts.Offset = pakkero.Random(800000, 900000)
+		} else {
+			opts.Offset = pakkero.Random(1880000, 1900000)
+		}
+	}
+}
+
+/*
+validateFat will parse the -fat members, one per arch other than the
+-arch one, on linux only: the selector needs /bin/sh. There is no
+-explain report of a fat output, its members have a launcher each.
+*/
+func validateFat(opts *cliOptions) error {
+	archs := []string{opts.Arch}
+
+	for _, value := range opts.fat {
+		target, err := pakkero.ParseFatTarget(value)
+		if err != nil {
+			return err
+		}
+
+		if pakkero.Contains(archs, target.Arch) {
+			return errors.New("one member per arch, " + target.Arch + " is given twice")
+		}
+
+		err = pakkero.CheckArch(target.Arch, opts.Compress)
+		if err != nil {
+			return err
+		}
+
+		_, err = os.Stat(target.File)
+		if err != nil {
+			return err
+		}
+
+		archs = append(archs, target.Arch)
+		opts.fatTargets = append(opts.fatTargets, target)
+	}
+
+	if len(opts.fatTargets) > 0 && opts.Platform != pakkero.PlatformLinux {
+		return errors.New("the selector runs on linux only, not on " + opts.Platform)
+	}
+
+	// each member has its own launcher, the report would be of the last one
+	if len(opts.fatTargets) > 0 && opts.Explain != "" {
+		return errors.New("-explain reports a single launcher, pack a member alone to get its report")
+	}
+
+	return nil
+}
+
+/*
+validateOutput will refuse an output that is one of the inputs, the
+same file whatever its path, see pakkero.SameFile: only the payload can
+be, with -in-place, that makes no -o the payload too, and the output
+the file a link points to.
+*/
+func validateOutput(opts *cliOptions) error {
+	if opts.InPlace && opts.OutFile == "" {
+		opts.OutFile = opts.InFile
+	}
+
+	outfile := opts.OutFile
+	if outfile == "" {
+		outfile = opts.InFile + ".enc"
+	}
+
+	inputs := [][]string{{"-file", opts.InFile}, {"-decoy", opts.Decoy}, {"-prologue", opts.Prologue},
+		{"-sign-key", opts.SignKey}}
+	for _, lib := range opts.BundleLibs {
+		inputs = append(inputs, []string{"-bundle-libs", lib})
+	}
+
+	for _, target := range opts.fatTargets {
+		inputs = append(inputs, []string{"-fat", target.File})
+	}
+
+	for _, input := range inputs {
+		if input[1] == "" {
+			continue
+		}
+
+		same, err := pakkero.SameFile(input[1], outfile)
+		if err != nil {
+			return errors.New("-o: " + err.Error())
+		}
+
+		switch {
+		case same && input[0] == "-file" && !opts.InPlace:
+			return errors.New("-o " + outfile + " is the -file payload " + input[1] +
+				", the same file through a link or not: give -in-place to replace it")
+		case same && input[0] != "-file":
+			return errors.New("-o " + outfile + " is the " + input[0] + " file " + input[1] + ", it would be replaced")
+		}
+	}
+
+	if opts.InPlace {
+		same, err := pakkero.SameFile(opts.InFile, outfile)
+		if err != nil || !same {
+			return errors.New("-in-place replaces the -file payload, -o must be it or not given")
+		}
+
+		// the file is replaced, a link to it is kept
+		opts.OutFile, err = filepath.EvalSymlinks(outfile)
+		if err != nil {
+			return errors.New("-o: " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+/*
+withoutFlag returns the arguments without the flag name and its values,
+in any of the forms the flag package parses: -name value, unless it is
+a boolean one, -name=value, and with two dashes.
+*/
+func withoutFlag(args []string, name string, boolean bool) []string {
+	result := []string{}
+
+	for i := 0; i < len(args); i++ {
+		arg := strings.TrimPrefix(strings.TrimPrefix(args[i], "-"), "-")
+
+		switch {
+		case arg == name && len(args[i]) > len(arg):
+			if !boolean {
+				i++
+			}
+		case strings.HasPrefix(arg, name+"=") && len(args[i]) > len(arg):
+		default:
+			result = append(result, args[i])
+		}
+	}
+
+	return result
+}
+
+/*
+fatMemberArgs returns the arguments the members of a fat output are
+packed with: the log, the audit record and the error are the ones of
+the fat output.
+*/
+func fatMemberArgs(args []string) []string {
+	for _, name := range []string{"fat", "log-file", "audit-log", "error-json"} {
+		args = withoutFlag(args, name, false)
+	}
+
+	return withoutFlag(args, "in-place", true)
+}
+
+/*
+Pack a fat output: the -arch member and the -fat ones are each packed by
+running pakkero with the same arguments, then assembled behind the
+selector.
+*/
+func packFat(ctx context.Context, opts cliOptions) int {
+	executable, err := os.Executable()
+	if err != nil {
+		pakkero.Log.Errorf("%s", err)
+
+		return pakkero.ERR
+	}
+
+	if opts.OutFile == "" {
+		opts.OutFile = opts.InFile + ".enc"
+	}
+
+	err = pakkero.PackFat(ctx, pakkero.FatConfig{
+		Executable: executable,
+		Args:       fatMemberArgs(os.Args[1:]),
+		Targets:    append([]pakkero.FatTarget{{Arch: opts.Arch, File: opts.InFile}}, opts.fatTargets...),
+		OutFile:    opts.OutFile,
+		Manifest:   opts.Manifest,
+		Options:    opts.Options,
+	})
+
+	// a member that failed has reported its error
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return pakkero.ERR
+	}
+
+	if err != nil {
+		pakkero.ReportError(&pakkero.PackError{Stage: "Assembling the fat output", Err: err}, opts.ErrorJSON)
+
+		return pakkero.ERR
+	}
+
+	return pakkero.OK
+}
+
+/*
+newFlagSet will declare all the cli flags, each flag must be
+listed in flagGroups to be shown in the help and in the completions.
+Backquoted words in the usage are the flag argument name.
+*/
+func newFlagSet(opts *cliOptions) *flag.FlagSet {
+	flags := flag.NewFlagSet(programName, flag.ContinueOnError)
+	// we print errors and usage by ourselves
+	flags.SetOutput(ioutil.Discard)
+
+	flags.StringVar(&opts.InFile, "file", "",
+		"target `file` to pack (required)")
+	flags.StringVar(&opts.OutFile, "o", "",
+		"place the output into `file` (default <file>.enc)")
+	flags.BoolVar(&opts.InPlace, "in-place", false,
+		"replace the -file payload with the output, -o must be it or not given")
+	flags.StringVar(&opts.offset, "offset", "",
+		"`bytes` from where to start the payload, or auto to measure the launcher (default random)")
+	flags.Int64Var(&opts.OffsetPadding, "offset-padding", pakkero.DefaultOffsetPadding,
+		"garbage after the launcher with -offset auto, in `percent` of its size")
+	flags.BoolVar(&opts.Compress, "c", false,
+		"compress the launcher to occupy less space (uses UPX)")
+	flags.StringVar(&opts.Codec, "codec", pakkero.DefaultCodec,
+		"`codec` compressing the payload, decoy, libraries and prologue: "+strings.Join(pakkero.CodecNames(), ", "))
+	flags.BoolVar(&opts.PreserveMode, "preserve-mode", false,
+		"copy the permission bits of the target file instead of using 0755")
+	flags.StringVar(&opts.Arch, "arch", runtime.GOARCH,
+		"target `arch` of the launcher: 386, amd64, arm, arm64 or riscv64 (default the host one)")
+	flags.StringVar(&opts.Platform, "platform", pakkero.PlatformLinux,
+		"`platform` running the launcher: linux or android")
+	flags.Var(&opts.fat, "fat",
+		"add to the output a member packing `arch=file`, run on that arch instead of the -arch one, repeatable")
+	flags.StringVar(&opts.Manifest, "manifest", "",
+		"write to `file` a json manifest of the output, with hashes and options")
+	flags.BoolVar(&opts.Repackable, "repackable", false,
+		"carry the offset in the output, so that its payload can be replaced with: repack")
+	flags.BoolVar(&opts.Recoverable, "recoverable", false,
+		"seal the offset in the output with a key printed once, to recover its payload with: extract")
+	flags.StringVar(&opts.SignKey, "sign-key", "",
+		"sign the output with the ed25519 `private.pem`, checked with: verify -pubkey")
+	flags.BoolVar(&opts.AllowRepack, "allow-repack", false,
+		"pack a file that is already packed by pakkero")
+	flags.BoolVar(&opts.ScrubPayloadBuildInfo, "scrub-payload-buildinfo", false,
+		"garble the module path, version and dependencies of a go payload, hidden from its debug.ReadBuildInfo too")
+	flags.BoolVar(&opts.Offline, "offline", false,
+		"fail fast if building the launcher would need the network")
+	flags.Int64Var(&opts.IdentitySeed, "identity-seed", 0,
+		"`seed` choosing the launcher module path and file name (default random)")
+	flags.BoolVar(&opts.Reproducible, "reproducible", false,
+		"make every random choice follow -identity-seed, for bit-identical outputs")
+	flags.StringVar(&opts.stageTimeouts, "stage-timeout", "",
+		"comma separated `list` of stage=duration (eg: build=120s,compress=60s)")
+	flags.DurationVar(&opts.timeout, "timeout", 0,
+		"stop the whole pack after this `duration` (eg: 5m)")
+	flags.StringVar(&opts.tmpDir, "tmp-dir", "",
+		"`directory` of the temporary files, it must be writable and allow running files (default $TMPDIR or /tmp)")
+	flags.Var(&opts.tools, "tool",
+		"pin an external tool to an absolute path as `name=path`, repeatable")
+	flags.Var(&opts.hooks, "hook",
+		"run an executable at a packing stage as `stage=path`, repeatable")
+	flags.Var(&opts.directives, "directive-policy",
+		"keep or rename the ob names of a directive of the template as `directive=policy`, repeatable")
+	flags.Var((*stringList)(&opts.PayloadArgs), "payload-arg",
+		"`arg` always passed to the payload before the runtime ones, repeatable")
+	flags.Var((*stringList)(&opts.SecretArgs), "secret-arg",
+		"`arg` passed to the payload outside of its argv, repeatable")
+	flags.StringVar(&opts.envPolicy, "env", "",
+		"`policy` of the inherited environment: passthrough, clear or allowlist:NAME,NAME")
+	flags.Var((*stringList)(&opts.PayloadEnv), "payload-env",
+		"`NAME=value` always given to the payload, repeatable")
+	flags.Var(&opts.bundleLibs, "bundle-libs", "comma separated `list` of shared libraries "+
+		"extracted for the payload, repeatable")
+	flags.BoolVar(&opts.Daemonize, "daemonize", false,
+		"detach the payload in a new session, the launcher exits once it runs")
+	flags.StringVar(&opts.LogPath, "log-path", "",
+		"absolute path `file` receiving the daemonized payload output")
+	flags.StringVar(&opts.Pidfile, "pidfile", "",
+		"absolute path `file` where to write the daemonized payload pid")
+	flags.StringVar(&opts.ExecMode, "exec-mode", pakkero.ExecModeMemfd,
+		"`mode` running the payload: memfd or userland (static amd64 payloads only)")
+	flags.StringVar(&opts.CaptureOutput, "capture-output", "",
+		"absolute path `file` on the target receiving the payload stdout and stderr encrypted")
+	flags.BoolVar(&opts.CaptureTee, "capture-tee", false,
+		"copy the captured output to the launcher stdout and stderr too")
+	flags.DurationVar(&opts.PayloadTimeout, "payload-timeout", 0,
+		"SIGTERM the payload after this `duration`, the launcher exits with 124 (eg: 1h)")
+	flags.DurationVar(&opts.PayloadKillAfter, "payload-kill-after", pakkero.DefaultPayloadKillAfter,
+		"`duration` between the SIGTERM and the SIGKILL of a timed out payload")
+	flags.StringVar(&opts.successCodes, "success-codes", "",
+		"comma separated `list` of payload exit codes the launcher exits 0 with (eg: 0,3)")
+	flags.IntVar(&opts.ExecRetries, "exec-retries", 3,
+		"`times` to retry the transient failures running the payload")
+	flags.DurationVar(&opts.ExecBackoff, "exec-backoff", 100*time.Millisecond,
+		"`duration` to wait before the first retry, doubled at each one")
+	flags.StringVar(&opts.IOProfile, "io-profile", pakkero.DefaultIOProfile,
+		"`profile` of the reads of the payload: "+strings.Join(pakkero.IOProfileNames(), ", "))
+	flags.StringVar(&opts.Prologue, "prologue", "",
+		"`file` run to completion before the payload, that runs only if it exits 0")
+	flags.Var(&opts.rlimits, "rlimit",
+		"resource limit of the payload as `name=value` or name=soft:hard, repeatable")
+	flags.StringVar(&opts.RlimitPolicy, "rlimit-policy", pakkero.RlimitFail,
+		"`policy` for limits above the launcher hard limit: fail or clamp")
+	flags.StringVar(&opts.nice, "nice", "",
+		"nice `value` of the payload, from -20 to 19")
+	flags.StringVar(&opts.ionice, "ionice", "",
+		"I/O scheduling `class` of the payload: idle, best-effort[:level] or realtime[:level]")
+	flags.StringVar(&opts.cpuset, "cpuset", "",
+		"`list` of CPUs the payload can run on, like 0-3,6")
+	flags.BoolVar(&opts.Scheduling.Strict, "cpuset-strict", false,
+		"fail instead of running on every CPU when a -cpuset CPU is not available")
+	flags.BoolVar(&opts.Init, "init", false,
+		"act as init: reap every child and exit with the payload status")
+	flags.Var(&opts.dependencies, "register-dep",
+		"absolute path of a dependency `file` to use as fingerprint, repeatable")
+	flags.BoolVar(&opts.BindDeps, "bind-deps", false,
+		"pass the verified dependencies to the payload as descriptors, in $OB_DEP_FD_<index>")
+	flags.StringVar(&opts.Decoy, "decoy", "",
+		"`file` to run instead of the payload when a degrade dependency does not match")
+	flags.BoolVar(&opts.AntiDump, "anti-dump", false,
+		"seal the payload memfd and keep decrypted buffers out of dumps")
+	flags.BoolVar(&opts.AntiDumpReopen, "anti-dump-reopen", false,
+		"like -anti-dump, also drop every memfd reference once the payload runs (ELF only)")
+	flags.StringVar(&opts.ArmAfter, "arm-after", "",
+		"run the payload only after this UTC `date` (2006-01-02T15:04Z)")
+	flags.StringVar(&opts.RunWindow, "run-window", "",
+		"run the payload only in this UTC `window` (HH:MM-HH:MM)")
+	flags.StringVar(&opts.TriggerFile, "trigger-file", "",
+		"run the payload only if this absolute path `file` exists")
+	flags.BoolVar(&opts.WaitForArming, "wait-for-arming", false,
+		"sleep and retry instead of exiting when not armed")
+	flags.Var((*stringList)(&opts.LicensePubKeys), "license-pubkey",
+		"run only with a license signed by the ed25519 public key in `file`, repeatable to rotate keys")
+	flags.StringVar(&opts.LicenseFile, "license-file", "",
+		"absolute path `file` of the license on the target")
+	flags.StringVar(&opts.OverridePubKey, "override-pubkey", "",
+		"relax the checks named by an override token signed by the ed25519 public key in `file`")
+	flags.StringVar(&opts.OverrideFile, "override-file", "",
+		"absolute path `file` of the override token on the target")
+	flags.StringVar(&opts.LaunchTokenKey, "launch-token-key", "",
+		"ed25519 private key in `file` signing a token that tells the payload it was started by the launcher")
+	flags.StringVar(&opts.RegisterHost, "register-host", "",
+		"absolute path `file` of the record binding the launcher to the host it runs on")
+	flags.StringVar(&opts.RegisterHostPubKey, "register-host-pubkey", "",
+		"RSA public key in `file` encrypting the registration record")
+	flags.StringVar(&opts.RegisterHostPolicy, "register-host-policy", pakkero.RegistrationEnforce,
+		"`policy` on a host the record is not of: enforce or warn")
+	flags.BoolVar(&opts.PinFirstHost, "pin-first-host", false,
+		"a missing registration record adopts the host instead of being a mismatch")
+	flags.Var((*stringList)(&opts.AllowHostnames), "allow-hostname",
+		"run only on hosts whose name matches the glob `pattern`, repeatable")
+	flags.Var((*stringList)(&opts.DenyHostnames), "deny-hostname",
+		"never run on hosts whose name matches the glob `pattern`, repeatable")
+	flags.BoolVar(&opts.UseGarble, "use-garble", false,
+		"build the launcher with garble -literals -tiny instead of go build")
+	flags.IntVar(&opts.Guards, "guards", 0,
+		"`number` of integrity guards checking the obfuscated strings, up to 32")
+	flags.BoolVar(&opts.AllowNoChecks, "allow-no-checks", false,
+		"pack a launcher with no // OB_CHECK marker, that runs no anti-debug check")
+	flags.BoolVar(&opts.Whiten, "whiten", false,
+		"XOR the encrypted payload with a keystream keyed by hidden ranges of the launcher")
+	flags.StringVar(&opts.Layout, "layout", pakkero.LayoutContiguous,
+		"`layout` of the payload: "+strings.Join(pakkero.Layouts, ", ")+
+			", scatter splits it between the launcher, the garbage and the end")
+	flags.BoolVar(&opts.SeccompSelf, "seccomp-self", false,
+		"confine the launcher, and the payload, to the syscalls the launcher makes (amd64 and arm64 only)")
+	flags.Var(&opts.seccompAllow, "seccomp-allow", "also allow the `syscalls` the payload makes, "+
+		"comma separated names, repeatable")
+	flags.BoolVar(&opts.PinProcs, "pin-procs", false,
+		"pin the launcher GOMAXPROCS to a random value from 1 to 4, the payload keeps its own")
+	flags.BoolVar(&opts.SkipLeakScan, "skip-leak-scan", false,
+		"do not fail the pack on a secret found in plaintext in the output")
+	flags.StringVar(&opts.attest, "attest", "",
+		"`channel` of the attestation records: off, file:/path or socket:/path (default off)")
+	flags.BoolVar(&opts.AttestSuccess, "attest-success", false,
+		"write an attestation record when all the checks pass too")
+	flags.BoolVar(&opts.HealthCheck, "health-check", false,
+		"build a launcher checking the host without running the payload, when a random variable is set")
+	flags.BoolVar(&opts.LauncherDebug, "launcher-debug", false,
+		"build a launcher that logs each stage, for troubleshooting only")
+	flags.BoolVar(&opts.AllowVet, "allow-vet", false,
+		"go on packing even if go vet reports findings on the launcher")
+	flags.StringVar(&opts.Explain, "explain", "",
+		"write to `file` a report of what each obfuscation pass did")
+	flags.BoolVar(&opts.ExplainDiff, "explain-diff", false,
+		"save the diff of the launcher source before and after the obfuscation too")
+	flags.BoolVar(&opts.ExplainUnsafe, "explain-unsafe", false,
+		"show the secrets plaintext in the explain report and diff")
+	flags.BoolVar(&opts.PolicyStrict, "strict", false,
+		"fail the pack on any policy rule fired, not only on the fatal ones")
+	flags.Var((*stringList)(&opts.PolicyAllow), "allow",
+		"do not check the policy rule `id`, repeatable")
+	flags.BoolVar(&opts.verbose, "v", false,
+		"verbose output, show the progress of each step")
+	flags.BoolVar(&opts.debug, "vv", false,
+		"debug output, show also the output of external tools")
+	flags.StringVar(&opts.logFile, "log-file", "",
+		"duplicate the uncolored output into `file`")
+	flags.StringVar(&opts.ErrorJSON, "error-json", "",
+		"write the error of a failed pack, with its stage and hint, to `file` as json")
+	flags.StringVar(&opts.AuditLog, "audit-log", "",
+		"append a chained record of the pack, succeeded or failed, to `file`, the secrets hashed")
+	flags.BoolVar(&opts.noColor, "no-color", false,
+		"disable colored output, NO_COLOR is honored too")
+	flags.BoolVar(&opts.version, "version", false,
+		"print "+programName+" version")
+
+	return flags
+}
+
+/*
+validateOptions will check the flags combinations, returning
+a specific error for each invalid one.
+*/
+func validateOptions(flags *flag.FlagSet, opts *cliOptions) error {
+	if opts.version {
+		if flags.NFlag() > 1 {
+			return errors.New("-version cannot be combined with other flags")
+		}
+
+		return nil
+	}
+
+	if opts.verbose && opts.debug {
+		return errors.New("-v and -vv are mutually exclusive, -vv already includes -v")
+	}
+
+	if opts.InFile == "" {
+		return errors.New("missing -file, the target file to pack")
+	}
+
+	if flags.NArg() > 0 {
+		return errors.New("unexpected argument " + flags.Arg(0))
+	}
+
+	if opts.offset == "auto" {
+		opts.AutoOffset = true
+	} else if opts.offset != "" {
+		offset, err := strconv.ParseInt(opts.offset, 10, 64)
+		if err != nil || offset <= 0 {
+			return errors.New("-offset must be a positive number of bytes or auto")
+		}
+
+		opts.Offset = offset
+	}
+
+	paddingSet := false
+
+	flags.Visit(func(f *flag.Flag) {
+		paddingSet = paddingSet || f.Name == "offset-padding"
+	})
+
+	if paddingSet && !opts.AutoOffset {
+		return errors.New("-offset-padding needs -offset auto")
+	}
+
+	if opts.OffsetPadding < 0 {
+		return errors.New("-offset-padding must be a positive percent")
+	}
+
+	if opts.ExecRetries < 0 || opts.ExecBackoff < 0 {
+		return errors.New("-exec-retries and -exec-backoff must be positive")
+	}
+
+	if opts.timeout < 0 {
+		return errors.New("-timeout must be a positive duration")
+	}
+
+	stageTimeouts, err := pakkero.ParseStageTimeouts(opts.stageTimeouts)
+	if err != nil {
+		return errors.New("-stage-timeout: " + err.Error())
+	}
+
+	opts.StageTimeouts = stageTimeouts
+
+	if opts.Reproducible {
+		err = pakkero.CheckReproducible(opts.IdentitySeed)
+		if err != nil {
+			return err
+		}
+
+		// before anything random, the default offset included
+		pakkero.SetRandomSeed(opts.IdentitySeed)
+	}
+
+	opts.SourceDate, err = pakkero.ParseSourceDateEpoch(os.Getenv("SOURCE_DATE_EPOCH"))
+	if err != nil {
+		return err
+	}
+
+	// the flags override the environment
+	err = pakkero.PinToolsFromEnv()
+	if err != nil {
+		return err
+	}
+
+	err = pakkero.SetToolRecordingFromEnv()
+	if err != nil {
+		return err
+	}
+
+	err = pakkero.PinTools(opts.tools)
+	if err != nil {
+		return errors.New("-tool: " + err.Error())
+	}
+
+	hooks, err := pakkero.ParseHooks(opts.hooks)
+	if err != nil {
+		return errors.New("-hook: " + err.Error())
+	}
+
+	opts.Hooks = pakkero.ExecHooks(hooks)
+
+	err = pakkero.ParseDirectivePolicies(opts.directives)
+	if err != nil {
+		return errors.New("-directive-policy: " + err.Error())
+	}
+
+	archSet := false
+
+	flags.Visit(func(f *flag.Flag) {
+		archSet = archSet || f.Name == "arch"
+	})
+
+	err = pakkero.ResolvePlatform(&opts.Options, archSet)
+	if err != nil {
+		return errors.New("-platform: " + err.Error())
+	}
+
+	err = pakkero.CheckArch(opts.Arch, opts.Compress)
+	if err != nil {
+		return errors.New("-arch: " + err.Error())
+	}
+
+	err = pakkero.PinCrossStrip(opts.Arch)
+	if err != nil {
+		return errors.New("-arch: " + err.Error())
+	}
+
+	opts.EnvPolicy, err = pakkero.ParseEnvPolicy(opts.envPolicy)
+	if err != nil {
+		return errors.New("-env: " + err.Error())
+	}
+
+	err = pakkero.ParsePayloadEnv(opts.PayloadEnv)
+	if err != nil {
+		return errors.New("-payload-env: " + err.Error())
+	}
+
+	dependencies, err := pakkero.ParseDependencies(opts.dependencies)
+	if err != nil {
+		return errors.New("-register-dep: " + err.Error())
+	}
+
+	opts.Dependencies = dependencies
+
+	for _, dependency := range dependencies {
+		if dependency.Policy == pakkero.DepPolicyDegrade && opts.Decoy == "" {
+			return errors.New("-register-dep " + dependency.Path + " uses the degrade policy, it needs a -decoy")
+		}
+	}
+
+	if opts.BindDeps && len(dependencies) == 0 {
+		return errors.New("-bind-deps needs -register-dep")
+	}
+
+	// the launcher sets them, a baked value would be overwritten
+	for _, variable := range opts.PayloadEnv {
+		if opts.BindDeps && strings.HasPrefix(variable, pakkero.BoundDepEnvPrefix) {
+			return errors.New("-payload-env: " + pakkero.BoundDepEnvPrefix + " variables are set by -bind-deps")
+		}
+	}
+
+	if (len(opts.LicensePubKeys) == 0) != (opts.LicenseFile == "") {
+		return errors.New("-license-pubkey and -license-file must be given together")
+	}
+
+	if (opts.OverridePubKey == "") != (opts.OverrideFile == "") {
+		return errors.New("-override-pubkey and -override-file must be given together")
+	}
+
+	opts.AllowHostnames, err = pakkero.ParseHostPatterns(opts.AllowHostnames)
+	if err != nil {
+		return errors.New("-allow-hostname: " + err.Error())
+	}
+
+	opts.DenyHostnames, err = pakkero.ParseHostPatterns(opts.DenyHostnames)
+	if err != nil {
+		return errors.New("-deny-hostname: " + err.Error())
+	}
+
+	opts.SeccompAllow, err = pakkero.ParseSyscallNames(opts.seccompAllow)
+	if err != nil {
+		return errors.New("-seccomp-allow: " + err.Error())
+	}
+
+	if len(opts.SeccompAllow) > 0 && !opts.SeccompSelf {
+		return errors.New("-seccomp-allow needs -seccomp-self")
+	}
+
+	if opts.Guards < 0 || opts.Guards > pakkero.MaxGuards {
+		return fmt.Errorf("-guards must be between 0 and %d", pakkero.MaxGuards)
+	}
+
+	opts.Attest, err = pakkero.ParseAttest(opts.attest)
+	if err != nil {
+		return errors.New("-attest: " + err.Error())
+	}
+
+	if (opts.ExplainDiff || opts.ExplainUnsafe) && opts.Explain == "" {
+		return errors.New("-explain-diff and -explain-unsafe need -explain")
+	}
+
+	err = pakkero.CheckPolicyAllow(opts.PolicyAllow)
+	if err != nil {
+		return errors.New("-allow: " + err.Error())
+	}
+
+	// the variable name is written nowhere else
+	if opts.HealthCheck && opts.Manifest == "" {
+		return errors.New("-health-check needs -manifest, its variable is written there only")
+	}
+
+	if opts.AttestSuccess && !opts.Attest.Enabled() {
+		return errors.New("-attest-success needs -attest")
+	}
+
+	if opts.Whiten && (opts.Repackable || opts.Recoverable) {
+		return errors.New("-whiten cannot be used with -repackable or -recoverable, " +
+			"repack and extract do not know the whitening")
+	}
+
+	if opts.Layout == pakkero.LayoutScatter && (opts.Repackable || opts.Recoverable) {
+		return errors.New("-layout scatter cannot be used with -repackable or -recoverable, " +
+			"repack and extract read the payload after the offset only")
+	}
+
+	if opts.SignKey != "" && opts.Repackable {
+		return errors.New("-sign-key cannot be used with -repackable, repack cannot sign the new output")
+	}
+
+	err = validateFat(opts)
+	if err != nil {
+		return errors.New("-fat: " + err.Error())
+	}
+
+	if (opts.RegisterHost == "") != (opts.RegisterHostPubKey == "") {
+		return errors.New("-register-host and -register-host-pubkey must be given together")
+	}
+
+	if opts.RegisterHost != "" && !pakkero.IsTargetAbs(opts.RegisterHost) {
+		return errors.New("-register-host needs an absolute path")
+	}
+
+	if opts.RegisterHostPolicy != pakkero.RegistrationEnforce &&
+		opts.RegisterHostPolicy != pakkero.RegistrationWarn {
+		return errors.New("-register-host-policy must be enforce or warn")
+	}
+
+	if opts.PinFirstHost && opts.RegisterHost == "" {
+		return errors.New("-pin-first-host needs -register-host")
+	}
+
+	if opts.LicenseFile != "" && !pakkero.IsTargetAbs(opts.LicenseFile) {
+		return errors.New("-license-file needs an absolute path")
+	}
+
+	if opts.OverrideFile != "" && !pakkero.IsTargetAbs(opts.OverrideFile) {
+		return errors.New("-override-file needs an absolute path")
+	}
+
+	if opts.TriggerFile != "" && !pakkero.IsTargetAbs(opts.TriggerFile) {
+		return errors.New("-trigger-file needs an absolute path")
+	}
+
+	if opts.WaitForArming &&
+		opts.ArmAfter == "" && opts.RunWindow == "" && opts.TriggerFile == "" {
+		return errors.New("-wait-for-arming needs at least one of -arm-after, -run-window or -trigger-file")
+	}
+
+	rlimits, err := pakkero.ParseRlimits(opts.rlimits)
+	if err != nil {
+		return errors.New("-rlimit: " + err.Error())
+	}
+
+	opts.Rlimits = rlimits
+
+	if opts.RlimitPolicy != pakkero.RlimitFail && opts.RlimitPolicy != pakkero.RlimitClamp {
+		return errors.New("-rlimit-policy must be fail or clamp")
+	}
+
+	if opts.nice != "" {
+		opts.Scheduling.Nice, err = pakkero.ParseNice(opts.nice)
+		if err != nil {
+			return errors.New("-nice: " + err.Error())
+		}
+
+		opts.Scheduling.HasNice = true
+	}
+
+	if opts.ionice != "" {
+		opts.Scheduling.IOPrio, err = pakkero.ParseIonice(opts.ionice)
+		if err != nil {
+			return errors.New("-ionice: " + err.Error())
+		}
+	}
+
+	if opts.cpuset != "" {
+		opts.Scheduling.CPUs, err = pakkero.ParseCPUSet(opts.cpuset)
+		if err != nil {
+			return errors.New("-cpuset: " + err.Error())
+		}
+	}
+
+	if opts.Scheduling.Strict && opts.cpuset == "" {
+		return errors.New("-cpuset-strict needs -cpuset")
+	}
+
+	if opts.Daemonize && opts.Init {
+		return errors.New("-daemonize and -init are mutually exclusive")
+	}
+
+	for _, item := range opts.bundleLibs {
+		opts.BundleLibs = append(opts.BundleLibs, strings.Split(item, ",")...)
+	}
+
+	for _, lib := range opts.BundleLibs {
+		stat, err := os.Stat(lib)
+		if err != nil || !stat.Mode().IsRegular() {
+			return errors.New("-bundle-libs: " + lib + " is not a file")
+		}
+	}
+
+	// the launcher removes the libraries once the payload exits
+	if len(opts.BundleLibs) > 0 && (opts.Daemonize || opts.Init) {
+		return errors.New("-bundle-libs cannot be combined with -daemonize or -init")
+	}
+
+	err = validateOutput(opts)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := pakkero.Codecs[opts.Codec]; !ok {
+		return fmt.Errorf("-codec must be one of: %s", strings.Join(pakkero.CodecNames(), ", "))
+	}
+
+	if !pakkero.Contains(pakkero.Layouts, opts.Layout) {
+		return fmt.Errorf("-layout must be one of: %s", strings.Join(pakkero.Layouts, ", "))
+	}
+
+	if !pakkero.Contains(pakkero.IOProfileNames(), opts.IOProfile) {
+		return fmt.Errorf("-io-profile must be one of: %s", strings.Join(pakkero.IOProfileNames(), ", "))
+	}
+
+	if !pakkero.Contains(pakkero.ExecModes, opts.ExecMode) {
+		return fmt.Errorf("-exec-mode must be one of: %s", strings.Join(pakkero.ExecModes, ", "))
+	}
+
+	if opts.ExecMode == pakkero.ExecModeUserland {
+		if opts.Arch != pakkero.UserlandArch {
+			return errors.New("-exec-mode userland is " + pakkero.UserlandArch + " only")
+		}
+
+		// they all need the payload in a memfd
+		if opts.Daemonize || opts.Init || opts.AntiDumpReopen || len(opts.SecretArgs) > 0 ||
+			len(opts.BundleLibs) > 0 || opts.LaunchTokenKey != "" || opts.BindDeps {
+			return errors.New("-exec-mode userland cannot be combined with -daemonize, -init, " +
+				"-anti-dump-reopen, -secret-arg, -bundle-libs, -launch-token-key or -bind-deps")
+		}
+	}
+
+	// the interpreter of a script cannot open the sealed memfd, a missing file is reported later
+	if opts.AntiDump || opts.AntiDumpReopen {
+		payload, err := pakkero.IsELF(opts.InFile)
+		if err == nil && !payload {
+			return errors.New("-anti-dump and -anti-dump-reopen need an ELF payload, " + opts.InFile + " is not one")
+		}
+	}
+
+	if opts.PayloadTimeout < 0 || opts.PayloadKillAfter < 0 {
+		return errors.New("-payload-timeout and -payload-kill-after must be positive")
+	}
+
+	if opts.successCodes != "" {
+		opts.SuccessCodes, err = pakkero.ParseSuccessCodes(opts.successCodes)
+		if err != nil {
+			return errors.New("-success-codes: " + err.Error())
+		}
+	}
+
+	// the launcher is gone by the time the payload exits
+	if opts.Daemonize && (opts.PayloadTimeout > 0 || len(opts.SuccessCodes) > 0) {
+		return errors.New("-payload-timeout and -success-codes cannot be combined with -daemonize")
+	}
+
+	if opts.CaptureOutput != "" {
+		if !pakkero.IsTargetAbs(opts.CaptureOutput) {
+			return errors.New("-capture-output needs an absolute path")
+		}
+
+		// the launcher is not there to pump the output
+		if opts.Daemonize || opts.Init || opts.ExecMode == pakkero.ExecModeUserland {
+			return errors.New("-capture-output cannot be combined with -daemonize, -init " +
+				"or -exec-mode userland")
+		}
+	}
+
+	if opts.CaptureTee && opts.CaptureOutput == "" {
+		return errors.New("-capture-tee needs -capture-output")
+	}
+
+	if !opts.Daemonize && (opts.LogPath != "" || opts.Pidfile != "") {
+		return errors.New("-log-path and -pidfile need -daemonize")
+	}
+
+	for _, path := range []string{opts.LogPath, opts.Pidfile} {
+		if path != "" && !pakkero.IsTargetAbs(path) {
+			return errors.New("-log-path and -pidfile need absolute paths")
+		}
+	}
+
+	// the tools run in the launcher workspace
+	if opts.tmpDir != "" {
+		opts.tmpDir, err = filepath.Abs(opts.tmpDir)
+		if err != nil {
+			return errors.New("-tmp-dir: " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+/*
+setupLogging sets the level, the colors and the log file of the
+logger from the options, -vv wins over -v.
+*/
+func setupLogging(opts *cliOptions) error {
+	switch {
+	case opts.debug:
+		pakkero.Log.Level = pakkero.LevelDebug
+	case opts.verbose:
+		pakkero.Log.Level = pakkero.LevelInfo
+	}
+
+	if opts.noColor {
+		pakkero.Log.Color = false
+	}
+
+	if opts.logFile != "" {
+		return pakkero.Log.SetLogFile(opts.logFile)
+	}
+
+	return nil
+}
+
+func main() {
+	if len(os.Args) < minArgsLen {
+		help(os.Stderr, newFlagSet(&cliOptions{}))
+		os.Exit(pakkero.ERR)
+	}
+
+	// subcommands
+	switch os.Args[1] {
+	case "completion":
+		os.Exit(completion(os.Args[2:]))
+	case "version":
+		os.Exit(printVersion(os.Args[2:]))
+	case "verify":
+		os.Exit(verifyManifest(os.Args[2:]))
+	case "bench":
+		os.Exit(runBenchmarks(os.Args[2:]))
+	case "license":
+		os.Exit(license(os.Args[2:]))
+	case "override":
+		os.Exit(override(os.Args[2:]))
+	case "logs":
+		os.Exit(decryptLogs(os.Args[2:]))
+	case "registration":
+		os.Exit(decryptRegistration(os.Args[2:]))
+	case "repack":
+		os.Exit(repackPayload(os.Args[2:]))
+	case "extract":
+		os.Exit(extractPayload(os.Args[2:]))
+	case "obstrings":
+		os.Exit(hideStrings(os.Args[2:]))
+	case "calibrate":
+		os.Exit(calibrate(os.Args[2:]))
+	case "estimate":
+		os.Exit(estimatePack(os.Args[2:]))
+	case "audit":
+		os.Exit(verifyAudit(os.Args[2:]))
+	}
+
+	opts := cliOptions{}
+	flags := newFlagSet(&opts)
+
+	err := flags.Parse(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		help(os.Stdout, flags)
+		os.Exit(pakkero.OK)
+	}
+
+	if err != nil {
+		pakkero.Log.Errorf("%s", explainFlagError(flags, err))
+		os.Exit(pakkero.ERR)
+	}
+
+	// setup logging before anything else, the invalid options included
+	err = setupLogging(&opts)
+	if err != nil {
+		pakkero.Log.Errorf("cannot open log file: %s", err)
+		os.Exit(pakkero.ERR)
+	}
+
+	err = validateOptions(flags, &opts)
+	if err != nil {
+		pakkero.ReportError(&pakkero.PackError{Stage: "Checking Options", Err: err,
+			Hint: "use '" + programName + " -h' to see the usage"}, opts.ErrorJSON)
+		os.Exit(pakkero.ERR)
+	}
+
+	if opts.version {
+		os.Exit(printVersion(nil))
+	}
+
+	// SIGINT and SIGTERM cancel the pack, a second one kills pakkero
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+
+	// fist test if all dependencies are present
+	if opts.Compress {
+		// compression needs additional upx dependency
+		testDependencies(dependenciesComplete, opts.ErrorJSON)
+	} else {
+		testDependencies(dependencies, opts.ErrorJSON)
+	}
+
+	if opts.UseGarble {
+		testDependencies([]string{"garble"}, opts.ErrorJSON)
+	}
+
+	for _, v := range optionalDependencies {
+		if _, pinned := pakkero.ToolPaths[v]; pinned || pakkero.ToolVersion(v).Found {
+			testDependencies([]string{v}, opts.ErrorJSON)
+		}
+	}
+
+	// the launcher workspace, the hooks and upx write and run files there
+	checkTempDir(opts.tmpDir, opts.ErrorJSON)
+
+	// each member is a pack of its own
+	if len(opts.fatTargets) > 0 {
+		os.Exit(packFat(ctx, opts))
+	}
+
+	setDefaultOffset(&opts)
+
+	// the words added by pakkero calibrate, if any
+	opts.Calibration = pakkero.CalibrationPath()
+
+	// a single line only a terminal can redraw
+	opts.Progress = newTerminalProgress(os.Stderr)
+
+	os.Exit(pakkero.ExitCode(pakkero.Pakkero(ctx, opts.Options)))
 }
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/89luca89/pakkero/internal/pakkero"
+)
+
+// parseOptions parses and validates the arguments of a pack like main does
+func parseOptions(args ...string) (*cliOptions, error) {
+	opts := &cliOptions{}
+	flags := newFlagSet(opts)
+
+	err := flags.Parse(args)
+	if err != nil {
+		return opts, errors.New(explainFlagError(flags, err))
+	}
+
+	return opts, validateOptions(flags, opts)
+}
+
+func TestFlagGroups(t *testing.T) {
+	flags := newFlagSet(&cliOptions{})
+
+	if others := ungroupedFlags(flags); len(others) > 0 {
+		t.Errorf("flags in no group: %v", others)
+	}
+
+	for _, group := range flagGroups {
+		for _, name := range group.flags {
+			if flags.Lookup(name) == nil {
+				t.Errorf("group %s lists -%s, that is not a flag", group.title, name)
+			}
+		}
+	}
+}
+
+func TestValidateOptions(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"-o", "out"}, "missing -file"},
+		{[]string{"-file", "in", "extra"}, "unexpected argument extra"},
+		{[]string{"-file", "in", "-v", "-vv"}, "mutually exclusive"},
+		{[]string{"-file", "in", "-offset", "-3"}, "-offset must be a positive number"},
+		{[]string{"-file", "in", "-offset", "1000", "-offset-padding", "5"}, "-offset-padding needs -offset auto"},
+		{[]string{"-file", "in", "-version"}, "-version cannot be combined"},
+		{[]string{"-file", "in", "-daemonize", "-payload-timeout", "1s"}, "cannot be combined with -daemonize"},
+		{[]string{"-file", "in", "-capture-tee"}, "-capture-tee needs -capture-output"},
+		{[]string{"-file", "in", "-log-path", "/var/log/payload"}, "-log-path and -pidfile need -daemonize"},
+		{[]string{"-fiel", "in"}, "did you mean -file?"},
+		{[]string{"-file", "in", "-bind-deps"}, "-bind-deps needs -register-dep"},
+		{[]string{"-file", "in", "-bind-deps", "-register-dep", "/bin/sh", "-payload-env", "OB_DEP_FD_0=x"},
+			"OB_DEP_FD_ variables are set by -bind-deps"},
+	}
+
+	for _, test := range tests {
+		_, err := parseOptions(test.args...)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%v: %v, expected %q", test.args, err, test.expected)
+		}
+	}
+
+	opts, err := parseOptions("-file", "in", "-offset", "auto")
+	if err != nil || !opts.AutoOffset {
+		t.Errorf("-offset auto: %v, %+v", err, opts.Options)
+	}
+}
+
+// the members of a fat output log, record and report nothing of their own
+/*
+The logging is set up before the options are validated, so that their
+error is in the log file too, and uncolored with -no-color.
+*/
+func TestSetupLogging(t *testing.T) {
+	logger := pakkero.Log
+	t.Cleanup(func() { pakkero.Log = logger })
+
+	output := &bytes.Buffer{}
+	pakkero.Log = pakkero.NewLogger(pakkero.LevelWarn)
+	pakkero.Log.Output = output
+	pakkero.Log.Color = true
+
+	logFile := filepath.Join(t.TempDir(), "pakkero.log")
+	opts := &cliOptions{}
+	flags := newFlagSet(opts)
+
+	err := flags.Parse([]string{"-file", "in", "-v", "-vv", "-no-color", "-log-file", logFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = setupLogging(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pakkero.Log.Level != pakkero.LevelDebug || pakkero.Log.Color {
+		t.Errorf("level %d and color %t, expected debug and no color", pakkero.Log.Level, pakkero.Log.Color)
+	}
+
+	err = validateOptions(flags, opts)
+	if err == nil {
+		t.Fatal("-v and -vv accepted together")
+	}
+
+	pakkero.ReportError(&pakkero.PackError{Stage: "Checking Options", Err: err}, "")
+
+	content, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(content), "mutually exclusive") {
+		t.Errorf("the log file has no error of the options: %q", content)
+	}
+
+	if strings.Contains(output.String(), "\033[") {
+		t.Errorf("colored output with -no-color: %q", output.String())
+	}
+}
+
+func TestFatMemberArgs(t *testing.T) {
+	args := fatMemberArgs([]string{"-file", "app", "-fat", "arm64=app-arm64", "--log-file=pack.log",
+		"-audit-log", "audit.log", "-error-json", "error.json", "-in-place", "-v", "-offset", "auto"})
+
+	expected := []string{"-file", "app", "-v", "-offset", "auto"}
+	if strings.Join(args, " ") != strings.Join(expected, " ") {
+		t.Errorf("member arguments: %q, expected %q", args, expected)
+	}
+}
+
+// an input reached through a link is an input too, only -in-place may replace the payload
+func TestValidateOutput(t *testing.T) {
+	dir := t.TempDir()
+	payload := filepath.Join(dir, "payload")
+	decoy := filepath.Join(dir, "decoy")
+	other := filepath.Join(dir, "other")
+
+	for _, path := range []string{payload, decoy, other} {
+		err := ioutil.WriteFile(path, []byte("content"), 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	payloadLink := filepath.Join(dir, "payload-link")
+	decoyLink := filepath.Join(dir, "decoy-link")
+
+	if err := os.Link(payload, payloadLink); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(decoy, decoyLink); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		opts     pakkero.Options
+		expected string
+	}{
+		{pakkero.Options{InFile: payload, OutFile: payload}, "give -in-place to replace it"},
+		{pakkero.Options{InFile: payload, OutFile: payloadLink}, "give -in-place to replace it"},
+		{pakkero.Options{InFile: payload, OutFile: decoyLink, Decoy: decoy}, "is the -decoy file"},
+		{pakkero.Options{InFile: payload, OutFile: other, InPlace: true}, "-o must be it or not given"},
+		{pakkero.Options{InFile: payload, OutFile: filepath.Join(dir, "out"), Decoy: decoy}, ""},
+		{pakkero.Options{InFile: payload}, ""},
+	}
+
+	for _, test := range tests {
+		opts := &cliOptions{Options: test.opts}
+
+		err := validateOutput(opts)
+		if (test.expected == "" && err != nil) ||
+			(test.expected != "" && (err == nil || !strings.Contains(err.Error(), test.expected))) {
+			t.Errorf("%+v: %v, expected %q", test.opts, err, test.expected)
+		}
+	}
+
+	// the payload is replaced where it is, a link to it is kept
+	link := filepath.Join(dir, "link")
+
+	if err := os.Symlink(payload, link); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &cliOptions{Options: pakkero.Options{InFile: link, InPlace: true}}
+
+	if err := validateOutput(opts); err != nil || opts.OutFile != payload {
+		t.Errorf("-in-place through a link: %v, -o %s", err, opts.OutFile)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	flags := newFlagSet(&cliOptions{})
+
+	for shell, generator := range map[string]func(*bytes.Buffer){
+		"bash": func(w *bytes.Buffer) { bashCompletion(w, flags) },
+		"zsh":  func(w *bytes.Buffer) { zshCompletion(w, flags) },
+		"fish": func(w *bytes.Buffer) { fishCompletion(w, flags) },
+	} {
+		script := &bytes.Buffer{}
+		generator(script)
+
+		for _, group := range flagGroups {
+			for _, name := range group.flags {
+				if !strings.Contains(script.String(), name) {
+					t.Errorf("%s: -%s is not completed", shell, name)
+				}
+			}
+		}
+
+		for _, subcommand := range sortedSubcommands() {
+			if !strings.Contains(script.String(), subcommand) {
+				t.Errorf("%s: %s is not completed", shell, subcommand)
+			}
+		}
+	}
+}
+
+// writeKeyPair writes an ed25519 key pair in the PEM files openssl writes
+func writeKeyPair(t *testing.T, dir string, name string) (string, string) {
+	t.Helper()
+
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicFile := filepath.Join(dir, name+".pub")
+	privateFile := filepath.Join(dir, name+".key")
+
+	err = ioutil.WriteFile(publicFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0644)
+	if err == nil {
+		err = ioutil.WriteFile(privateFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return publicFile, privateFile
+}
+
+// a license signed by a key of the rotation verifies, whatever its place in it
+func TestLicenseRotation(t *testing.T) {
+	logger := pakkero.Log
+	t.Cleanup(func() { pakkero.Log = logger })
+
+	pakkero.Log = pakkero.NewLogger(pakkero.LevelError)
+	pakkero.Log.Output = &bytes.Buffer{}
+
+	dir := t.TempDir()
+	oldPub, oldKey := writeKeyPair(t, dir, "old")
+	newPub, _ := writeKeyPair(t, dir, "new")
+	licenseFile := filepath.Join(dir, "license")
+
+	if code := license([]string{"issue", "-key", oldKey, "-o", licenseFile, "-machine-id", "host"}); code != pakkero.OK {
+		t.Fatalf("issue: exit code %d", code)
+	}
+
+	for _, test := range []struct {
+		args     []string
+		expected int
+	}{
+		{[]string{"-key", newPub, "-key", oldPub, "-machine-id", "host"}, pakkero.OK},
+		{[]string{"-key", oldPub, "-machine-id", "host"}, pakkero.OK},
+		{[]string{"-key", newPub, "-machine-id", "host"}, pakkero.ERR},
+		{[]string{"-key", oldPub, "-machine-id", "another"}, pakkero.ERR},
+		{[]string{"-key", oldPub, "-machine-id", "host", "-this-machine"}, pakkero.ERR},
+		{[]string{"-machine-id", "host"}, pakkero.ERR},
+	} {
+		if code := license(append(append([]string{"verify"}, test.args...), licenseFile)); code != test.expected {
+			t.Errorf("license verify %q: exit code %d, expected %d", test.args, code, test.expected)
+		}
+	}
+}
+
+func TestOverrideTokens(t *testing.T) {
+	logger := pakkero.Log
+	t.Cleanup(func() { pakkero.Log = logger })
+
+	pakkero.Log = pakkero.NewLogger(pakkero.LevelError)
+	pakkero.Log.Output = &bytes.Buffer{}
+
+	dir := t.TempDir()
+	pub, key := writeKeyPair(t, dir, "override")
+	otherPub, _ := writeKeyPair(t, dir, "other")
+	token := filepath.Join(dir, "token")
+
+	for _, test := range []struct {
+		args     []string
+		expected int
+	}{
+		{[]string{"-checks", "hostname", "-ttl", "200h"}, pakkero.ERR},
+		{[]string{"-checks", "license", "-ttl", "1h"}, pakkero.ERR},
+		{[]string{"-checks", "hostname"}, pakkero.ERR},
+		{[]string{"-checks", "hostname,env", "-ttl", "1h"}, pakkero.OK},
+	} {
+		args := append([]string{"issue", "-key", key, "-o", token}, test.args...)
+		if code := override(args); code != test.expected {
+			t.Errorf("override %q: exit code %d, expected %d", args, code, test.expected)
+		}
+	}
+
+	for _, test := range []struct {
+		key      string
+		expected int
+	}{
+		{pub, pakkero.OK},
+		{otherPub, pakkero.ERR},
+	} {
+		if code := override([]string{"verify", "-key", test.key, token}); code != test.expected {
+			t.Errorf("override verify -key %s: exit code %d, expected %d", test.key, code, test.expected)
+		}
+	}
+}
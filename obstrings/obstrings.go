@@ -0,0 +1,301 @@
+/*
+Package obstrings will hide the strings of a go program the same way
+pakkero hides the ones of its launcher, for payloads written in go.
+The strings to hide are marked with a trailing comment, so the package
+is needed only to generate the code, never by the program at run time:
+
+	endpoint := "https://example.com/api" //obstrings
+	key := []byte("secret") //obstrings:buffer
+*/
+package obstrings
+
+import (
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/89luca89/pakkero/internal/pakkero"
+)
+
+// Scheme is how a string is hidden, see Encode
+type Scheme int
+
+const (
+	// Bitshift hides a string in a function returning it, like the launcher strings
+	Bitshift Scheme = iota
+	// Buffer hides it in a function appending it to a buffer, never as a string, like the launcher secrets
+	Buffer
+)
+
+// GeneratedFile is the file of a package holding the functions of its hidden strings
+const GeneratedFile = "obstrings_gen.go"
+
+// annotations marking the strings to hide, by scheme
+var annotations = map[string]Scheme{
+	"//obstrings":          Bitshift,
+	"//obstrings:bitshift": Bitshift,
+	"//obstrings:buffer":   Buffer,
+}
+
+/*
+Encode returns the source of a function hiding s, and its name: with
+Bitshift it is a func() string, with Buffer a func([]byte) []byte
+filling the buffer given, whose content can be wiped after use.
+The source uses the anchor and the imports declared by Header.
+*/
+func Encode(s string, scheme Scheme) (string, string) {
+	name := pakkero.GenerateTyposquatName()
+
+	if scheme == Buffer {
+		return pakkero.GenerateBytesFunc(s, name), name
+	}
+
+	return pakkero.GenerateStringFunc(s, name), name
+}
+
+/*
+Header returns the start of a file of package pkg holding functions
+generated by Encode: the imports and the anchor they decode from,
+always 1 but known only at run time.
+*/
+func Header(pkg string) string {
+	return "// Code generated by pakkero obstrings. DO NOT EDIT.\n\n" +
+		"package " + pkg + "\n\n" +
+		"import (\n\tobBytes \"bytes\"\n\tobOS \"os\"\n)\n\n" +
+		"var _ = obBytes.Join\n\n" +
+		"var obAnchor = uint8(obOS.Getpagesize() / obOS.Getpagesize())\n"
+}
+
+// edit replaces the source from start to end with text
+type edit struct {
+	start int
+	end   int
+	text  string
+}
+
+/*
+Rewrite replaces each string literal on a line ending with an
+annotation by the call of a function hiding it, and removes the
+annotation. Returns the new source and the generated function of each
+string hidden.
+Constants, imports and struct tags cannot be calls, annotating them
+is an error, like an annotation with no string on its line.
+*/
+func Rewrite(filename string, src []byte) ([]byte, []string, error) {
+	fset := token.NewFileSet()
+
+	file, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// annotated lines, and their annotations to remove
+	schemes := map[int]Scheme{}
+	edits := []edit{}
+
+	for _, group := range file.Comments {
+		for _, comment := range group.List {
+			scheme, ok := annotations[strings.TrimSpace(comment.Text)]
+			if !ok {
+				continue
+			}
+
+			schemes[fset.Position(comment.Pos()).Line] = scheme
+			edits = append(edits, edit{
+				start: fset.Position(comment.Pos()).Offset,
+				end:   fset.Position(comment.End()).Offset,
+			})
+		}
+	}
+
+	if len(schemes) == 0 {
+		return src, nil, nil
+	}
+
+	// the lines whose literals cannot be replaced by a call
+	constant := map[int]string{}
+	hidden := map[int]bool{}
+	funcs := []string{}
+
+	ast.Inspect(file, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.GenDecl:
+			if node.Tok == token.CONST {
+				markLines(fset, node, constant, "a constant")
+			}
+		case *ast.ImportSpec:
+			markLines(fset, node, constant, "an import")
+		case *ast.Field:
+			if node.Tag != nil {
+				markLines(fset, node.Tag, constant, "a struct tag")
+			}
+		case *ast.BasicLit:
+			if node.Kind != token.STRING {
+				return true
+			}
+
+			line := fset.Position(node.End()).Line
+
+			scheme, ok := schemes[line]
+			if !ok {
+				return true
+			}
+
+			if _, ok := constant[line]; ok {
+				return true
+			}
+
+			value, err := strconv.Unquote(node.Value)
+			if err != nil {
+				return true
+			}
+
+			source, name := Encode(value, scheme)
+			call := name + "()"
+
+			if scheme == Buffer {
+				call = name + "(nil)"
+			}
+
+			funcs = append(funcs, source)
+			hidden[line] = true
+			edits = append(edits, edit{
+				start: fset.Position(node.Pos()).Offset,
+				end:   fset.Position(node.End()).Offset,
+				text:  call,
+			})
+		}
+
+		return true
+	})
+
+	lines := []int{}
+	for line := range schemes {
+		lines = append(lines, line)
+	}
+
+	sort.Ints(lines)
+
+	for _, line := range lines {
+		if what, ok := constant[line]; ok {
+			return nil, nil, fmt.Errorf("%s:%d: a string of %s cannot be hidden", filename, line, what)
+		}
+
+		if !hidden[line] {
+			return nil, nil, fmt.Errorf("%s:%d: annotation with no string to hide", filename, line)
+		}
+	}
+
+	// from the end, so that the offsets stay valid
+	sort.Slice(edits, func(i, j int) bool {
+		return edits[i].start > edits[j].start
+	})
+
+	for _, item := range edits {
+		src = append(src[:item.start:item.start], append([]byte(item.text), src[item.end:]...)...)
+	}
+
+	result, err := format.Source(src)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	return result, funcs, nil
+}
+
+// markLines records the lines of a node in lines, as what
+func markLines(fset *token.FileSet, node ast.Node, lines map[int]string, what string) {
+	for line := fset.Position(node.Pos()).Line; line <= fset.Position(node.End()).Line; line++ {
+		lines[line] = what
+	}
+}
+
+/*
+Generate rewrites the files in place, see Rewrite, and appends the
+generated functions to the GeneratedFile of their directory, created
+with the package of the first file there.
+Files already rewritten have no annotation left and are not changed.
+Returns the number of strings hidden.
+*/
+func Generate(files []string) (int, error) {
+	funcs := map[string]string{}
+	packages := map[string]string{}
+	rewritten := map[string][]byte{}
+	count := 0
+
+	for _, filename := range files {
+		src, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return 0, err
+		}
+
+		result, generated, err := Rewrite(filename, src)
+		if err != nil {
+			return 0, err
+		}
+
+		if len(generated) == 0 {
+			continue
+		}
+
+		file, err := parser.ParseFile(token.NewFileSet(), filename, result, parser.PackageClauseOnly)
+		if err != nil {
+			return 0, err
+		}
+
+		dir := filepath.Dir(filename)
+		if pkg, ok := packages[dir]; ok && pkg != file.Name.Name {
+			return 0, fmt.Errorf("%s: package %s, not %s as the other files of %s",
+				filename, file.Name.Name, pkg, dir)
+		}
+
+		packages[dir] = file.Name.Name
+		funcs[dir] += strings.Join(generated, "\n") + "\n"
+		rewritten[filename] = result
+		count += len(generated)
+	}
+
+	// the functions first, a file is never left calling missing ones
+	for dir, generated := range funcs {
+		err := appendGenerated(filepath.Join(dir, GeneratedFile), packages[dir], generated)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	for filename, result := range rewritten {
+		err := ioutil.WriteFile(filename, result, 0644)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return count, nil
+}
+
+// appendGenerated appends the functions to a GeneratedFile, creating it if missing
+func appendGenerated(path string, pkg string, generated string) error {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		content, err = []byte(Header(pkg)), nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	result, err := format.Source(append(content, "\n"+generated...))
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+
+	return ioutil.WriteFile(path, result, 0644)
+}
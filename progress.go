@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/89luca89/pakkero/internal/pakkero"
+)
+
+/*
+terminalProgress renders the progress of the long stages on a single
+line, redrawn only when the percentage changes and cleared once the
+stage is done, so that the log lines are never mixed with it.
+*/
+type terminalProgress struct {
+	output  *os.File
+	mutex   sync.Mutex
+	stage   string
+	percent int64
+}
+
+/*
+newTerminalProgress returns a progress rendered on output,
+nil if output is not a terminal.
+*/
+func newTerminalProgress(output *os.File) pakkero.Progress {
+	stat, err := output.Stat()
+	if err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+
+	return &terminalProgress{output: output, percent: -1}
+}
+
+func (p *terminalProgress) Stage(name string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.stage = name
+	p.percent = -1
+}
+
+func (p *terminalProgress) Step(done int64, total int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	percent := int64(100)
+	if total > 0 {
+		percent = done * 100 / total
+	}
+
+	if percent == p.percent {
+		return
+	}
+
+	p.percent = percent
+
+	if percent >= 100 {
+		fmt.Fprint(p.output, "\r\033[K")
+
+		return
+	}
+
+	fmt.Fprintf(p.output, "\r\033[K → %s %3d%%", p.stage, percent)
+}
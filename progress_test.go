@@ -0,0 +1,35 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// the line is redrawn only when the percentage changes, and cleared at the end of the stage
+func TestTerminalProgress(t *testing.T) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if newTerminalProgress(writer) != nil {
+		t.Error("a pipe is not a terminal")
+	}
+
+	progress := &terminalProgress{output: writer, percent: -1}
+
+	progress.Stage("compressing")
+	progress.Step(0, 1000)
+	progress.Step(1, 1000)
+	progress.Step(500, 1000)
+	progress.Step(1000, 1000)
+	writer.Close()
+
+	output, _ := ioutil.ReadAll(reader)
+
+	expected := "\r\033[K → compressing   0%\r\033[K → compressing  50%\r\033[K"
+	if string(output) != expected {
+		t.Errorf("%q, expected %q", output, expected)
+	}
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// flagGroup is a titled group of flags shown together in the help
+type flagGroup struct {
+	title string
+	flags []string
+	// constraints and interactions between the flags of the group
+	notes []string
+}
+
+var flagGroups = []flagGroup{
+	{
+		title: "Packing",
+		flags: []string{"file", "o", "in-place", "offset", "offset-padding", "c", "codec", "preserve-mode", "manifest", "identity-seed", "reproducible", "repackable", "recoverable", "sign-key", "allow-repack", "scrub-payload-buildinfo", "offline", "arch", "platform", "fat"},
+		notes: []string{
+			"the default offset is random, ~850kb with -c and ~1.9mb without it",
+			"the offset must be greater than the launcher size, more if -c is not used",
+			"-c compresses the launcher with UPX, -codec the payload, decoy, libraries and prologue,",
+			"  none leaves them as they are: zstd is not a codec, the launcher needs only the standard library",
+			"-offset auto builds a launcher to measure it, and leaves -offset-padding percent of it,",
+			"  at least 64kb, of garbage after it",
+			"the output is verified before it replaces -o, on failure pakkero exits with 3",
+			"-o must not be an input, even through a symlink or a hardlink: -in-place allows the -file one,",
+			"  read from a copy, and replaces the file a symlink points to",
+			"the output is executable (0755 minus umask), setuid/setgid bits and capabilities are never kept",
+			"the manifest redacts the offset and the -secret-arg and -payload-env values,",
+			"  check an artifact against it with: pakkero verify manifest.json file",
+			"the launcher is built as a go module with a random path, the same -identity-seed gives the same one",
+			"-reproducible makes the same -identity-seed, payload and options give a bit-identical output:",
+			"  keep that seed as secret as the offset, SOURCE_DATE_EPOCH sets the output and manifest time",
+			"a file packed by pakkero is refused as payload unless -allow-repack is given",
+			"the payload of a -repackable output is replaced, with the launcher kept byte for byte, with:",
+			"  pakkero repack -packed old.enc -file payload -o new.enc, by the same pakkero version",
+			"the payload of a -recoverable output is recovered with the recovery key printed when packing:",
+			"  pakkero extract -packed app.enc -key hex|file -o payload",
+			"-sign-key ends the output with its ed25519 signature, the launcher leaves it out, checked with:",
+			"  pakkero verify -pubkey public.pem app.enc, or github.com/89luca89/pakkero/artifact alone:",
+			"  it cannot be used with -repackable, each -fat member is signed, not the selector",
+			"-scrub-payload-buildinfo needs a go payload, that must not rely on debug.ReadBuildInfo:",
+			"  it has no module information left, the function names keep their package paths",
+			"the launcher needs only the standard library, go never downloads modules or toolchains",
+			"a foreign -arch needs a strip handling it: the binutils cross one or -tool strip=path",
+			"UPX cannot compress riscv64 launchers, 386 and arm ones must load the payload in under 1GB",
+			"-platform android defaults to -arch arm64 and cannot be combined with -daemonize",
+			"-fat packs the payload of each arch=file as the -file one, and puts them behind a /bin/sh selector",
+			"  running the member of uname -m: each member is packed with the same options, use -offset auto",
+			"  the output has the single -audit-log record, and -fat cannot be used with -explain",
+		},
+	},
+	{
+		title: "Tools",
+		flags: []string{"tool", "tmp-dir"},
+		notes: []string{
+			"tools are: garble, go, strip, upx, PAKKERO_TOOL_<NAME>=path works too",
+			"a pinned tool is never searched in PATH, -tool overrides the environment",
+			"strip can be gnu, busybox or llvm, told by --version: a busybox, unknown or missing one",
+			"  is skipped, the launcher is stripped by the linker only",
+			"out of linux the binutils cross strip is looked for with the host architecture too",
+			"PAKKERO_RECORD_TOOLS=dir records the tools runs, PAKKERO_REPLAY_TOOLS=dir replays them without the tools",
+			"the temporary directory is probed by running a script in it: without -tmp-dir, an unusable one, read-only",
+			"  or noexec, is replaced by /var/tmp, then by pakkero/tmp in the user cache directory",
+		},
+	},
+	{
+		title: "Hooks",
+		flags: []string{"hook", "directive-policy"},
+		notes: []string{
+			"stages are: pre-obfuscate, post-build, post-assemble, hooks of a stage run in order",
+			"each hook gets the launcher source, the launcher binary or the output as its argument,",
+			"  and PAKKERO_HOOK_STAGE, PAKKERO_HOOK_DIR and PAKKERO_HOOK_TARGET in its environment",
+			"a hook exiting nonzero fails the pack, its stdout goes to the log and the manifest",
+			"a pre-obfuscate hook adds a check with // OB_CHECK_FUNC obName, a func() bool true on tampering",
+			"directives are: export (keep), linkname (rename), cgo_export (keep), policies are: keep, rename",
+			"  export names are C identifiers, they are always kept",
+		},
+	},
+	{
+		title: "Timeouts",
+		flags: []string{"stage-timeout", "timeout"},
+		notes: []string{
+			"stages are: vet, build, strip, compress, durations are like 90s or 2m",
+			"on timeout the temporary files are removed and the stage is reported",
+		},
+	},
+	{
+		title: "Payload arguments",
+		flags: []string{"payload-arg", "secret-arg"},
+		notes: []string{
+			"the payload gets: its name, the -payload-arg ones, then the runtime arguments",
+			"-secret-arg ones never reach argv: they are in the file at $OB_SECRET_ARGS, NUL terminated",
+		},
+	},
+	{
+		title: "Payload environment",
+		flags: []string{"env", "payload-env", "bundle-libs"},
+		notes: []string{
+			"the default policy is passthrough, clear starts from an empty environment",
+			"-payload-env values and the OB_* variables set by the launcher are always given",
+			"-bundle-libs puts its directory first in the LD_LIBRARY_PATH the payload would get,",
+			"  it cannot be combined with -daemonize or -init",
+			"PAKKERO_LAUNCHER_DEBUG_FILE is read by the launcher and never given to the payload",
+		},
+	},
+	{
+		title: "Execution",
+		flags: []string{"exec-mode", "exec-retries", "exec-backoff",
+			"payload-timeout", "payload-kill-after", "success-codes", "prologue", "io-profile"},
+		notes: []string{
+			"EAGAIN, EINTR, EBUSY, ETXTBSY and ENOMEM are retried extracting and executing the payload",
+			"the launcher exits with 125 if extraction fails, 127 if the payload or its interpreter is missing,",
+			"  126 if it cannot be executed otherwise",
+			"  and prints E1 missing interpreter, E2 missing library or E3 wrong architecture if it finds why",
+			"a timed out payload is sent SIGTERM, then SIGKILL after -payload-kill-after, with its process group",
+			"with -success-codes the launcher exits 0 for those codes, the payload status for the other ones,",
+			"  1 for a 0 not listed and 128 plus the signal for a killed payload, 124 on timeout",
+			"-payload-timeout and -success-codes cannot be combined with -daemonize",
+			"-exec-mode userland maps the payload in a fork of the launcher, no file descriptor ever points",
+			"  to it: static amd64 payloads only, it cannot be combined with -daemonize, -init,",
+			"  -anti-dump-reopen, -secret-arg or -bundle-libs",
+			"-prologue runs from a memfd with the launcher stdio and no argument, a nonzero exit",
+			"  is the exit of the launcher and the payload is not even decrypted",
+			"-io-profile disk prefetches the whole payload, network a window of big chunks, both on huge pages",
+		},
+	},
+	{
+		title: "Payload output",
+		flags: []string{"capture-output", "capture-tee"},
+		notes: []string{
+			"the payload stdout and stderr are appended to the file in AES-GCM frames, with a key",
+			"  printed once when packing: keep it, it is not in the log file nor in the manifest",
+			"decrypt the file with: pakkero logs decrypt -key hex|file -i file [-o file] [-stream stdout|stderr|all]",
+			"the launcher exits with 126 if it cannot open the file, the payload never runs uncaptured",
+			"-capture-output cannot be combined with -daemonize, -init or -exec-mode userland",
+		},
+	},
+	{
+		title: "Resource limits",
+		flags: []string{"rlimit", "rlimit-policy"},
+		notes: []string{
+			"names are the ones of getrlimit(2) without RLIMIT_, like nofile, as, cpu, nproc",
+			"sizes accept K, M, G, T, cpu accepts durations like 5m, unlimited is allowed",
+			"the launcher applies them to itself right before executing the payload",
+		},
+	},
+	{
+		title: "Scheduling",
+		flags: []string{"nice", "ionice", "cpuset", "cpuset-strict"},
+		notes: []string{
+			"a negative nice value and the realtime class need privileges, without them the launcher exits with 126",
+			"-cpuset CPUs not available at runtime are dropped, if none is left the payload runs on every CPU",
+		},
+	},
+	{
+		title: "Daemon",
+		flags: []string{"daemonize", "log-path", "pidfile"},
+		notes: []string{
+			"the launcher exits 0 once the payload is running, with no terminal and stdin from /dev/null",
+			"failures before the payload runs are still reported with a nonzero exit",
+			"-daemonize cannot be combined with -init",
+		},
+	},
+	{
+		title: "Init",
+		flags: []string{"init"},
+		notes: []string{
+			"for container entrypoints, termination signals are forwarded to the payload",
+			"when not PID 1 the launcher becomes the subreaper of the payload orphans",
+		},
+	},
+	{
+		title: "Dependencies",
+		flags: []string{"register-dep", "bind-deps", "decoy"},
+		notes: []string{
+			"the launcher will run only if all the registered files are present and match",
+			"use /path/to/file[:mode][:policy] to choose how a file matches, modes are:",
+			"  bfd (default, similar size and content), digest, size, exists",
+			"and what happens when it does not match, policies are:",
+			"  enforce (default, stop), warn (run with OB_DEP_MISMATCH=1), degrade (run -decoy)",
+			"multiple files can be registered repeating the flag or separating them with commas",
+			"with -bind-deps the payload gets each verified file as a descriptor, whose path is in",
+			"  $OB_DEP_FD_<index>, in the order they are registered: use it instead of the path of the file",
+		},
+	},
+	{
+		title: "Hardening",
+		flags: []string{"anti-dump", "anti-dump-reopen", "use-garble", "guards", "allow-no-checks", "whiten", "layout",
+			"seccomp-self", "seccomp-allow", "pin-procs", "skip-leak-scan"},
+		notes: []string{
+			"-anti-dump-reopen implies -anti-dump, both refuse a payload that is not an ELF, eg: a script",
+			"-use-garble needs garble in PATH or pinned with -tool garble=path, it runs the pinned go",
+			"-guards are placed at random check points, fewer if the launcher has not enough of them",
+			"the anti-debug checks are spread over the check points, a launcher with none fails without -allow-no-checks",
+			"-whiten is the last layer over the payload, it cannot be used with -repackable or -recoverable",
+			"-layout scatter cannot be used with -repackable or -recoverable either, -c leaves no gap in the launcher",
+			"-seccomp-self kills the launcher on any other syscall, the payload inherits the filter:",
+			"  -seccomp-allow the syscalls it makes, the launcher ones are in the -manifest",
+			"-pin-procs has no effect with -launcher-debug, that does not pin the runtime",
+			"the output is scanned for every secret, plain, UTF-16 and base64, -skip-leak-scan allows a hook writing one",
+		},
+	},
+	{
+		title: "Arming",
+		flags: []string{"arm-after", "run-window", "trigger-file", "wait-for-arming"},
+		notes: []string{
+			"all conditions must be met, dates and hours are always UTC",
+			"-wait-for-arming needs at least one arming condition",
+		},
+	},
+	{
+		title: "License",
+		flags: []string{"license-pubkey", "license-file"},
+		notes: []string{
+			"both are needed, the launcher exits before decrypting without a valid license",
+			"licenses are issued with: pakkero license issue -key private.pem -o license",
+			"  [-expires date] [-machine-id id | -this-machine], the key is an openssl ed25519 one",
+			"-license-pubkey is repeatable, up to 8 keys: a license signed by any of them is valid",
+			"licenses are checked with: pakkero license verify -key pub.pem [-key pub.pem...] license",
+		},
+	},
+	{
+		title: "Override",
+		flags: []string{"override-pubkey", "override-file"},
+		notes: []string{
+			"both are needed, a valid token turns the failures of the checks it names into a warning:",
+			"  the payload gets OB_CHECKS_RELAXED=1 and the attestation a record with the negative check index",
+			"tokens are issued with: pakkero override issue -key private.pem -checks env,parent -ttl 48h -o token,",
+			"  for at most 7 days; a malformed, expired or wrongly signed one is ignored",
+			"the checks are dependency, env, hostname and parent: the decryption, the guards",
+			"  and the license are never relaxed",
+			"tokens are checked with: pakkero override verify -key pub.pem token",
+		},
+	},
+	{
+		title: "Launch token",
+		flags: []string{"launch-token-key"},
+		notes: []string{
+			"the payload gets in $OB_LAUNCH_TOKEN a pipe with a token signed over the launch time and its pid,",
+			"  readable once, it checks it with the public key and github.com/89luca89/pakkero/launchtoken",
+			"the key is an openssl ed25519 one, hidden in the launcher: the token raises the bar, it proves nothing",
+			"-launch-token-key cannot be combined with -exec-mode userland",
+		},
+	},
+	{
+		title: "Host registration",
+		flags: []string{"register-host", "register-host-pubkey", "register-host-policy", "pin-first-host"},
+		notes: []string{
+			"the record binds the launcher to a machine id, with -pin-first-host a missing one adopts the host",
+			"  at the first run, concurrent first runs are serialized by a lock on the record",
+			"it holds the hostname, the machine id hash, the time and the build id encrypted with an",
+			"  openssl RSA key, read them with: pakkero registration decrypt -key private.pem record",
+			"with the warn policy the payload runs with OB_HOST_MISMATCH=1 on a host the record is not of",
+		},
+	},
+	{
+		title: "Host lists",
+		flags: []string{"allow-hostname", "deny-hostname"},
+		notes: []string{
+			"patterns are case insensitive globs: * is any sequence of characters, ? any single one",
+			"a host matching a -deny-hostname, or none of the -allow-hostname ones if any, is a tampering",
+			"the hostname is the one of the UTS namespace the launcher runs in, easy to change: pair it with -license-pubkey",
+		},
+	},
+	{
+		title: "Attestation",
+		flags: []string{"attest", "attest-success"},
+		notes: []string{
+			"each record is a line with the build id, the index of the failed check and the unix time,",
+			"  0 is the index of a launcher whose checks passed, the build id is in -v and the manifest",
+			"records are best effort: the tamper reaction never waits for them",
+		},
+	},
+	{
+		title: "Troubleshooting",
+		flags: []string{"health-check", "launcher-debug", "allow-vet", "explain", "explain-diff", "explain-unsafe"},
+		notes: []string{
+			"-health-check needs -manifest: the variable is its health_var, when set to anything",
+			"  the launcher prints a line per check, index and result, and exits 0 if none failed",
+			"the launcher logs to stderr, or to PAKKERO_LAUNCHER_DEBUG_FILE if set",
+			"debug launchers are marked DEBUG BUILD and are not obfuscated, never ship them",
+			"the launcher is checked with go vet before building, findings are fatal without -allow-vet",
+			"the explain report is written right after the obfuscation, even if the build fails later,",
+			"  the diff needs diff in PATH and is kept in the temporary directory, its path is in the report",
+			"the secrets are redacted from the report and the diff unless -explain-unsafe is given",
+		},
+	},
+	{
+		title: "Policy",
+		flags: []string{"strict", "allow"},
+		notes: []string{
+			"the options are checked before packing against rules weakening the output, each fired one warns:",
+			"  PK001 (fatal) -launcher-debug with -license-pubkey, -register-host or -launch-token-key",
+			"  PK002 -explain report in the directory of the output, PK003 -explain-unsafe",
+			"  PK004 -reproducible, PK005 -register-host-policy warn, PK006 -capture-tee, PK007 -repackable,",
+			"  PK008 -override-pubkey",
+			"an allowed rule is never checked, fatal ones included",
+		},
+	},
+	{
+		title: "Output",
+		flags: []string{"v", "vv", "log-file", "error-json", "audit-log", "no-color", "version"},
+		notes: []string{
+			"-v and -vv are mutually exclusive, -version cannot be combined",
+			"the progress of scrubbing, compressing, encrypting and assembling is shown when stderr is a terminal",
+			"a failed pack logs its stage and a hint, -error-json writes them with the tool output as json",
+			"-audit-log keeps a record per pack, each chained to the one before it, check them with: audit verify",
+			"  the secrets are hashed with the salt of the log, in file.salt, readable by its owner only",
+		},
+	},
+}
+
+// subcommands and their arguments
+var subcommands = map[string][]string{
+	"completion":   {"bash", "zsh", "fish"},
+	"version":      {"-json"},
+	"verify":       {},
+	"license":      {"issue", "verify"},
+	"override":     {"issue", "verify"},
+	"logs":         {"decrypt"},
+	"registration": {"decrypt"},
+	"repack":       {"-packed", "-file", "-o"},
+	"extract":      {"-packed", "-key", "-o"},
+	"obstrings":    {},
+	"calibrate":    {"-arch", "-binary", "-min-count", "-write", "-o"},
+	"estimate":     {"-file", "-offset", "-manifest"},
+	"audit":        {"verify"},
+}
+
+/*
+ungroupedFlags returns the flags not listed in any group,
+so that they are never lost from help and completions.
+*/
+func ungroupedFlags(flags *flag.FlagSet) []string {
+	grouped := map[string]bool{}
+
+	for _, group := range flagGroups {
+		for _, name := range group.flags {
+			grouped[name] = true
+		}
+	}
+
+	result := []string{}
+
+	flags.VisitAll(func(f *flag.Flag) {
+		if !grouped[f.Name] {
+			result = append(result, f.Name)
+		}
+	})
+
+	return result
+}
+
+/*
+allGroups returns the flag groups plus a group for
+the ungrouped flags, if any.
+*/
+func allGroups(flags *flag.FlagSet) []flagGroup {
+	others := ungroupedFlags(flags)
+	if len(others) == 0 {
+		return flagGroups
+	}
+
+	return append(flagGroups, flagGroup{title: "Other", flags: others})
+}
+
+/*
+flagArgument returns the argument name of a flag, empty for
+boolean flags, and the usage without backquotes.
+*/
+func flagArgument(f *flag.Flag) (string, string) {
+	name, usage := flag.UnquoteUsage(f)
+
+	if getter, ok := f.Value.(flag.Getter); ok {
+		if _, isBool := getter.Get().(bool); isBool {
+			return "", usage
+		}
+	}
+
+	return name, usage
+}
+
+/*
+Print Help, grouping the flags with their constraints.
+*/
+func help(w io.Writer, flags *flag.FlagSet) {
+	fmt.Fprintf(w, "Usage: %s -file /path/to/file [options]\n", programName)
+	fmt.Fprintf(w, "       %s completion bash|zsh|fish\n", programName)
+	fmt.Fprintf(w, "       %s version [-json]\n", programName)
+	fmt.Fprintf(w, "       %s verify manifest.json file\n", programName)
+	fmt.Fprintf(w, "       %s verify -pubkey public.pem file\n", programName)
+	fmt.Fprintf(w, "       %s license issue -key private.pem -o license [options]\n", programName)
+	fmt.Fprintf(w, "       %s license verify -key pub.pem [-key pub.pem...] [options] license\n", programName)
+	fmt.Fprintf(w, "       %s override issue -key private.pem -checks list -ttl duration -o token\n", programName)
+	fmt.Fprintf(w, "       %s override verify -key pub.pem token\n", programName)
+	fmt.Fprintf(w, "       %s logs decrypt -key hex|file -i file [options]\n", programName)
+	fmt.Fprintf(w, "       %s registration decrypt -key private.pem record [record...]\n", programName)
+	fmt.Fprintf(w, "       %s repack -packed old.enc -file payload -o new.enc\n", programName)
+	fmt.Fprintf(w, "       %s extract -packed app.enc -key hex|file -o payload\n", programName)
+	fmt.Fprintf(w, "       %s obstrings file.go [file.go...]\n", programName)
+	fmt.Fprintf(w, "       %s calibrate [-arch arch] [-binary file] [-min-count n] [-write] [-o file]\n", programName)
+	fmt.Fprintf(w, "       %s estimate -file /path/to/file [options]\n", programName)
+	fmt.Fprintf(w, "       %s audit verify audit.log\n", programName)
+
+	for _, group := range allGroups(flags) {
+		fmt.Fprintf(w, "\n%s:\n", group.title)
+
+		for _, name := range group.flags {
+			f := flags.Lookup(name)
+			arg, usage := flagArgument(f)
+
+			if arg != "" {
+				arg = " <" + arg + ">"
+			}
+
+			fmt.Fprintf(w, "  %-26s %s\n", "-"+name+arg, usage)
+		}
+
+		for _, note := range group.notes {
+			fmt.Fprintf(w, "  * %s\n", note)
+		}
+	}
+}
+
+/*
+levenshtein returns the edit distance between two strings.
+*/
+func levenshtein(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+/*
+closestFlag returns the defined flag most similar to name,
+empty if none is similar enough.
+*/
+func closestFlag(flags *flag.FlagSet, name string) string {
+	result := ""
+	best := len(name)/2 + 1
+
+	flags.VisitAll(func(f *flag.Flag) {
+		distance := levenshtein(name, f.Name)
+		if distance < best {
+			best = distance
+			result = f.Name
+		}
+	})
+
+	return result
+}
+
+/*
+explainFlagError will add a suggestion to the parsing errors
+of unknown flags.
+*/
+func explainFlagError(flags *flag.FlagSet, err error) string {
+	const undefined = "flag provided but not defined: -"
+
+	message := err.Error()
+	if strings.HasPrefix(message, undefined) {
+		name := strings.TrimPrefix(message, undefined)
+
+		suggestion := closestFlag(flags, name)
+		if suggestion != "" {
+			return fmt.Sprintf("unknown flag -%s, did you mean -%s?", name, suggestion)
+		}
+
+		return fmt.Sprintf("unknown flag -%s", name)
+	}
+
+	return message
+}